@@ -1,93 +1,1747 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/gopher-9527/yanshu/agent/pkg/admin"
+	"github.com/gopher-9527/yanshu/agent/pkg/apikey"
+	"github.com/gopher-9527/yanshu/agent/pkg/archive"
+	"github.com/gopher-9527/yanshu/agent/pkg/artifacts"
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"github.com/gopher-9527/yanshu/agent/pkg/backend"
+	"github.com/gopher-9527/yanshu/agent/pkg/budget"
+	"github.com/gopher-9527/yanshu/agent/pkg/cache"
+	"github.com/gopher-9527/yanshu/agent/pkg/checkpoint"
+	"github.com/gopher-9527/yanshu/agent/pkg/cli"
 	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/conversation"
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+	"github.com/gopher-9527/yanshu/agent/pkg/guardrails"
+	"github.com/gopher-9527/yanshu/agent/pkg/health"
+	"github.com/gopher-9527/yanshu/agent/pkg/hedge"
+	"github.com/gopher-9527/yanshu/agent/pkg/hooks"
+	"github.com/gopher-9527/yanshu/agent/pkg/httptool"
+	"github.com/gopher-9527/yanshu/agent/pkg/imagegen"
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/limits"
 	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/memlimit"
+	"github.com/gopher-9527/yanshu/agent/pkg/metrics"
+	"github.com/gopher-9527/yanshu/agent/pkg/persona"
+	"github.com/gopher-9527/yanshu/agent/pkg/plan"
+	"github.com/gopher-9527/yanshu/agent/pkg/policy"
+	"github.com/gopher-9527/yanshu/agent/pkg/refusal"
+	"github.com/gopher-9527/yanshu/agent/pkg/shell"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+	"github.com/gopher-9527/yanshu/agent/pkg/toolloop"
+	"github.com/gopher-9527/yanshu/agent/pkg/toolrun"
+	"github.com/gopher-9527/yanshu/agent/pkg/trace"
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"github.com/gopher-9527/yanshu/agent/pkg/warmup"
+	"github.com/gopher-9527/yanshu/agent/pkg/workspace"
 	"google.golang.org/adk/agent"
 	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/agent/workflowagents/parallelagent"
+	"google.golang.org/adk/agent/workflowagents/sequentialagent"
+	"google.golang.org/adk/artifact"
 	"google.golang.org/adk/cmd/launcher"
-	"google.golang.org/adk/cmd/launcher/full"
+	"google.golang.org/adk/cmd/launcher/console"
+	"google.golang.org/adk/cmd/launcher/universal"
+	adkmodel "google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/agenttool"
+	"google.golang.org/genai"
 )
 
 func main() {
-	// Load configuration from default location or environment variable
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "config.yaml"
+	// Global flags come before the subcommand and override the config file and environment
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	configPath := flags.String("config", cmp.Or(os.Getenv("CONFIG_PATH"), "config.yaml"), "Configuration file, overrides CONFIG_PATH")
+	prompt := flags.String("p", "", "Send a single prompt and exit, shorthand for the run command")
+	var overrides config.Overrides
+	overrides.BindFlags(flags)
+	flags.Parse(os.Args[1:])
+	args := flags.Args()
+	if *prompt != "" {
+		args = append([]string{"run", "-p", *prompt}, args...)
+	}
+
+	// config subcommands check the configuration before anything is built from it
+	if len(args) > 0 && args[0] == "config" {
+		if err := cli.RunConfig(*configPath, &overrides, args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	// Load configuration
-	cfg, err := config.Load(configPath)
+	cfg, err := config.Load(*configPath, &overrides)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v\n\nPlease create config.yaml from config.yaml.example\nOr set CONFIG_PATH environment variable", err)
+		log.Fatalf("Failed to load config: %v\n\nPlease create config.yaml from config.yaml.example\nOr pass -config or set the CONFIG_PATH environment variable", err)
 	}
 
-	// Setup logger based on config
-	logLevel := slog.LevelInfo
-	switch cfg.Logging.GetLogLevel() {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	}
+	// Setup logger based on config, the level can be changed at runtime
+	logLevel := logging.NewLevelController(logging.ParseLevel(cfg.Logging.GetLogLevel()))
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level:     logLevel,
+	// Components listed in logging.levels keep their own level
+	componentLevels := make(map[string]slog.Level, len(cfg.Logging.Levels))
+	for component, level := range cfg.Logging.Levels {
+		componentLevels[component] = logging.ParseLevel(level)
+	}
+	// Logs go to stderr so subcommand output on stdout stays machine-readable
+	var logOutput io.Writer = os.Stderr
+	if cfg.Logging.File.Path != "" {
+		logFile, err := newLogFile(&cfg.Logging.File)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+		logOutput = logFile
+		if cfg.Logging.File.Stderr {
+			logOutput = io.MultiWriter(logFile, os.Stderr)
+		}
+	}
+	logger := slog.New(logging.NewHandler(logOutput, &logging.HandlerOptions{
+		Format:    cfg.Logging.Format,
+		Level:     logLevel.Leveler(),
+		Levels:    componentLevels,
 		AddSource: cfg.Logging.AddSource,
+		Prompts:   cfg.Logging.LogPrompts,
+		Secrets:   cfg.Secrets(),
 	}))
 	slog.SetDefault(logger)
 
 	ctx := context.Background()
+	logLevel.WatchSignals(ctx)
 	logger.Info("Starting agent application",
-		"config_file", configPath,
+		"config_file", *configPath,
 		"log_level", cfg.Logging.Level,
+		"log_levels", cfg.Logging.Levels,
 	)
 
+	// Encrypt session archives, artifacts and model dumps at rest
+	sealer, err := newSealer(&cfg.Encryption)
+	if err != nil {
+		log.Fatalf("Failed to set up encryption: %v", err)
+	}
+	if sealer != nil {
+		logger.Info("Encryption at rest enabled", "provider", cmp.Or(cfg.Encryption.Provider, "local"), "tenant", cmp.Or(cfg.Encryption.Tenant, envelope.ScopeUser))
+	}
+
 	// Get timeout duration
 	timeout, err := cfg.Model.GetTimeout()
 	if err != nil {
 		log.Fatalf("Invalid timeout value: %v", err)
 	}
 
+	streamIdleTimeout, err := cfg.Model.GetStreamIdleTimeout()
+	if err != nil {
+		log.Fatalf("Invalid stream idle timeout value: %v", err)
+	}
+
+	tok, err := tokenizer.Select(cfg.Model.Tokenizer, cfg.Model.ModelName)
+	if err != nil {
+		log.Fatalf("Invalid tokenizer: %v", err)
+	}
+
 	// Create model from config
-	model, err := llmmodel.NewModel(ctx, &llmmodel.Config{
-		APIKey:    cfg.Model.APIKey,
-		ModelName: cfg.Model.ModelName,
-		BaseURL:   cfg.Model.BaseURL,
-		Timeout:   timeout,
-	})
+	model, err := newModel(ctx, &cfg.Model, timeout, streamIdleTimeout, tok, sealer)
 	if err != nil {
 		log.Fatalf("Failed to create model: %v", err)
 	}
-	logger.Info("Model created successfully")
+	logger.Info("Model created successfully", "provider", cfg.Model.Provider, "model", model.Name())
+	if cfg.Model.CheckModel {
+		checkModel(ctx, model, timeout)
+	}
 
-	// Create agent from config
-	yanshu_agent, err := llmagent.New(llmagent.Config{
-		Name:        cfg.Agent.Name,
-		Model:       model,
-		Description: cfg.Agent.Description,
-		Instruction: cfg.Agent.Instruction,
-	})
+	// Shutdown steps run once the launcher returns, e.g. after the web server drained
+	var onExit []func()
+	closeOnExit := func(llm adkmodel.LLM) {
+		if c, ok := llm.(io.Closer); ok {
+			onExit = append(onExit, func() {
+				if err := c.Close(); err != nil {
+					logger.Warn("Failed to close model", "model", llm.Name(), "error", err)
+				}
+			})
+		}
+	}
+	closeOnExit(model)
+	contextWindow := cmp.Or(cfg.Conversation.ContextWindow, cfg.Model.ContextWindow)
+	if preset, ok := model.(*llmmodel.PresetModel); ok && preset.ContextWindow() > 0 {
+		logger.Info("Model context window", "tokens", preset.ContextWindow())
+		contextWindow = cmp.Or(contextWindow, preset.ContextWindow())
+	}
+
+	// Send requests to a fallback model while the model's circuit is open,
+	// on the model's provider and options unless the fallback names others
+	if fb := cfg.Model.CircuitBreaker.Fallback; cfg.Model.CircuitBreaker.Enabled && fb.ModelName != "" {
+		fallbackCfg := cfg.ModelFor(&config.AgentConfig{Model: fb.Profile()})
+		fallbackCfg.CircuitBreaker = config.CircuitBreakerConfig{}
+		fallbackTok, err := tokenizer.Select(fallbackCfg.Tokenizer, fallbackCfg.ModelName)
+		if err != nil {
+			log.Fatalf("Invalid tokenizer for the circuit breaker fallback model: %v", err)
+		}
+		fallback, err := newModel(ctx, &fallbackCfg, timeout, streamIdleTimeout, fallbackTok, sealer)
+		if err != nil {
+			log.Fatalf("Failed to create circuit breaker fallback model: %v", err)
+		}
+		model = llmmodel.NewFailoverModel(model, fallback)
+		logger.Info("Circuit breaker failover enabled", "fallback", fb.ModelName)
+	}
+
+	// Send requests the model is slow to answer to a secondary model too, on
+	// the model's provider and options unless the hedge names others
+	if cfg.Model.Hedge.ModelName != "" {
+		delay, err := cfg.Model.Hedge.GetDelay()
+		if err != nil {
+			log.Fatalf("Invalid hedge delay: %v", err)
+		}
+		secondaryCfg := cfg.ModelFor(&config.AgentConfig{Model: cfg.Model.Hedge.Profile()})
+		secondaryTok, err := tokenizer.Select(secondaryCfg.Tokenizer, secondaryCfg.ModelName)
+		if err != nil {
+			log.Fatalf("Invalid tokenizer for the hedge model: %v", err)
+		}
+		secondary, err := newModel(ctx, &secondaryCfg, timeout, streamIdleTimeout, secondaryTok, sealer)
+		if err != nil {
+			log.Fatalf("Failed to create hedge model: %v", err)
+		}
+		model, err = hedge.NewModel(model, &hedge.Config{Secondary: secondary, Delay: delay})
+		if err != nil {
+			log.Fatalf("Failed to create hedged model: %v", err)
+		}
+		logger.Info("Request hedging enabled", "secondary", cfg.Model.Hedge.ModelName, "delay", delay)
+	}
+
+	// The chat command can switch the model underneath the decorators below
+	baseModel := cli.NewSwitchableModel(model)
+	model = baseModel
+
+	// Keep the model of a local server loaded
+	var keeper *warmup.Keeper
+	if cfg.Model.Warmup.Backend != "" {
+		keepAlive, err := cfg.Model.Warmup.GetKeepAlive()
+		if err != nil {
+			log.Fatalf("Invalid warm-up keep alive: %v", err)
+		}
+		interval, err := cfg.Model.Warmup.GetInterval()
+		if err != nil {
+			log.Fatalf("Invalid warm-up interval: %v", err)
+		}
+		keeper, err = warmup.NewKeeper(&warmup.Config{
+			Backend:   cfg.Model.Warmup.Backend,
+			BaseURL:   cfg.Model.BaseURL,
+			ModelName: cfg.Model.ModelName,
+			KeepAlive: keepAlive,
+			Interval:  interval,
+			Timeout:   timeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create model warm-up: %v", err)
+		}
+		go keeper.Run(ctx)
+	}
+
+	// Watch the load of a local server and throttle calls while it is saturated
+	var backendMonitor *backend.Monitor
+	if mon := cfg.Model.Monitor; mon.Backend != "" {
+		interval, err := mon.GetInterval()
+		if err != nil {
+			log.Fatalf("Invalid backend monitor interval: %v", err)
+		}
+		backendMonitor, err = backend.NewMonitor(&backend.Config{
+			Backend:       mon.Backend,
+			BaseURL:       cfg.Model.BaseURL,
+			Interval:      interval,
+			MaxWaiting:    mon.MaxWaiting,
+			MaxCacheUsage: mon.MaxCacheUsage,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create backend monitor: %v", err)
+		}
+		go backendMonitor.Run(ctx)
+
+		model, err = backend.NewThrottledModel(model, backendMonitor, &backend.ThrottleConfig{
+			MaxConcurrency:       mon.MaxConcurrency,
+			SaturatedConcurrency: mon.SaturatedConcurrency,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create throttled model: %v", err)
+		}
+	}
+
+	// Handle provider content-filter refusals
+	var refusalFallback adkmodel.LLM
+	if cfg.Refusal.Fallback.ModelName != "" {
+		refusalFallback, err = llmmodel.NewOpenAIModel(ctx, &llmmodel.OpenAIConfig{
+			APIKey:        cmp.Or(cfg.Refusal.Fallback.APIKey, cfg.Model.APIKey),
+			ModelName:     cfg.Refusal.Fallback.ModelName,
+			BaseURL:       cmp.Or(cfg.Refusal.Fallback.BaseURL, cfg.Model.BaseURL),
+			ClientOptions: llmmodel.ClientOptions{Timeout: timeout},
+		})
+		if err != nil {
+			log.Fatalf("Failed to create refusal fallback model: %v", err)
+		}
+	}
+
+	// The audit log records admin changes, plan approvals and budget model switches
+	var auditLog *audit.FileLog
+	var auditRecorder audit.Recorder
+	if cfg.Admin.AuditLog != "" {
+		auditLog, err = audit.NewFileLog(cfg.Admin.AuditLog)
+		if err != nil {
+			log.Fatalf("Failed to create audit log: %v", err)
+		}
+		auditRecorder = auditLog
+	}
+
+	// Record token usage when enabled
+	var usageStore usage.Store
+	var budgetTracker *budget.Tracker
+	var budgetFallback adkmodel.LLM
+	var pricing usage.Pricing
+	if cfg.Usage.Enabled {
+		fileStore, err := usage.NewFileStore(cfg.Usage.Path)
+		if err != nil {
+			log.Fatalf("Failed to create usage store: %v", err)
+		}
+		usageStore = fileStore
+
+		// Track monthly spend, alert on thresholds and optionally fall back to a cheaper model
+		if cfg.Budget.Monthly > 0 {
+			alerters := []budget.Alerter{&budget.LogAlerter{}}
+			if cfg.Budget.WebhookURL != "" {
+				alerters = append(alerters, &budget.WebhookAlerter{URL: cfg.Budget.WebhookURL})
+			}
+			if cfg.Budget.SlackWebhookURL != "" {
+				alerters = append(alerters, &budget.SlackAlerter{WebhookURL: cfg.Budget.SlackWebhookURL})
+			}
+
+			budgetTracker, err = budget.NewTracker(ctx, fileStore, &budget.Config{
+				Monthly:    cfg.Budget.Monthly,
+				Thresholds: cfg.Budget.Thresholds,
+				Alerters:   alerters,
+			})
+			if err != nil {
+				log.Fatalf("Failed to create budget tracker: %v", err)
+			}
+			usageStore = budgetTracker
+
+			if cfg.Budget.Fallback.ModelName != "" {
+				budgetFallback, err = llmmodel.NewOpenAIModel(ctx, &llmmodel.OpenAIConfig{
+					APIKey:        cmp.Or(cfg.Budget.Fallback.APIKey, cfg.Model.APIKey),
+					ModelName:     cfg.Budget.Fallback.ModelName,
+					BaseURL:       cmp.Or(cfg.Budget.Fallback.BaseURL, cfg.Model.BaseURL),
+					ClientOptions: llmmodel.ClientOptions{Timeout: timeout},
+				})
+				if err != nil {
+					log.Fatalf("Failed to create fallback model: %v", err)
+				}
+			}
+			logger.Info("Budget tracking enabled",
+				"monthly", cfg.Budget.Monthly,
+				"spend", budgetTracker.Spend(),
+				"fallback_model", cfg.Budget.Fallback.ModelName,
+			)
+		}
+
+		logger.Info("Usage tracking enabled", "path", cfg.Usage.Path)
+	}
+
+	// Authenticate server requests with API keys, counting tokens per key
+	var auth *apikey.Auth
+	apiKeys, err := cfg.Server.APIKeys.Load()
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+	if len(apiKeys) > 0 {
+		keys := make([]apikey.Key, len(apiKeys))
+		for i, k := range apiKeys {
+			keys[i] = apikey.Key{
+				Name:              k.Name,
+				Key:               k.Key,
+				SHA256:            k.SHA256,
+				RequestsPerMinute: k.RequestsPerMinute,
+				TokensPerDay:      k.TokensPerDay,
+			}
+		}
+		auth, err = apikey.New(ctx, &apikey.Config{Keys: keys, Usage: usageStore})
+		if err != nil {
+			log.Fatalf("Failed to create API key authentication: %v", err)
+		}
+		if usageStore != nil {
+			usageStore = auth
+		}
+		logger.Info("API key authentication enabled", "keys", len(keys))
+	}
+	pricing = make(usage.Pricing, len(cfg.Usage.Pricing))
+	for name, price := range cfg.Usage.Pricing {
+		pricing[name] = usage.Price{
+			InputPerMillion:       price.InputPerMillion,
+			CachedInputPerMillion: price.CachedInputPerMillion,
+			OutputPerMillion:      price.OutputPerMillion,
+		}
+	}
+
+	// Record each turn's steps for offline analysis when enabled
+	var traceStore trace.Store
+	var tracer *trace.Recorder
+	if cfg.Trace.Enabled {
+		fileStore, err := trace.NewFileStore(cfg.Trace.Path)
+		if err != nil {
+			log.Fatalf("Failed to create trace store: %v", err)
+		}
+		traceStore = fileStore
+		tracer, err = trace.New(&trace.Config{Store: fileStore, Pricing: pricing})
+		if err != nil {
+			log.Fatalf("Failed to create trace recorder: %v", err)
+		}
+		logger.Info("Tracing enabled", "path", cfg.Trace.Path)
+	}
+
+	if cfg.Budget.Monthly > 0 && !cfg.Usage.Enabled {
+		log.Fatalf("Budget tracking requires usage tracking (set usage.enabled in config)")
+	}
+
+	// Guard against oversized input and responses
+	maxInputSize, err := cfg.Limits.GetMaxInputSize()
+	if err != nil {
+		log.Fatalf("Invalid max input size: %v", err)
+	}
+	maxResponseSize, err := cfg.Limits.GetMaxResponseSize()
+	if err != nil {
+		log.Fatalf("Invalid max response size: %v", err)
+	}
+	limitsEnabled := maxInputSize > 0 || cfg.Limits.MaxInputTokens > 0 || maxResponseSize > 0 || cfg.Limits.MaxResponseTokens > 0
+
+	if cfg.Conversation.Summarize {
+		logger.Info("Conversation summarization enabled",
+			"context_window", contextWindow,
+			"keep_turns", cfg.Conversation.KeepTurns,
+		)
+	}
+	if limitsEnabled {
+		logger.Info("Size limits enabled",
+			"max_input_size", cfg.Limits.MaxInputSize,
+			"max_input_tokens", cfg.Limits.MaxInputTokens,
+			"input_action", cfg.Limits.InputAction,
+			"max_response_size", cfg.Limits.MaxResponseSize,
+			"max_response_tokens", cfg.Limits.MaxResponseTokens,
+			"tokenizer", tok.Name(),
+		)
+	}
+
+	// Content checks on user messages and model responses
+	var guardrailsConfig *guardrails.Config
+	if cfg.Guardrails.Enabled {
+		moderator, err := newModerator(cfg, timeout)
+		if err != nil {
+			log.Fatalf("Failed to create moderation client: %v", err)
+		}
+		input, err := guardrailChecks(cfg.Guardrails.Input, moderator)
+		if err != nil {
+			log.Fatalf("Invalid input guardrails: %v", err)
+		}
+		output, err := guardrailChecks(cfg.Guardrails.Output, moderator)
+		if err != nil {
+			log.Fatalf("Invalid output guardrails: %v", err)
+		}
+		guardrailsConfig = &guardrails.Config{
+			Input:         input,
+			Output:        output,
+			InputMessage:  cfg.Guardrails.InputMessage,
+			OutputMessage: cfg.Guardrails.OutputMessage,
+		}
+		logger.Info("Guardrails enabled", "input_checks", len(input), "output_checks", len(output))
+	}
+
+	// Answer repeated deterministic requests from the response cache
+	var cacheConfig *cache.Config
+	if cfg.Cache.Enabled {
+		store, err := newCacheStore(&cfg.Cache)
+		if err != nil {
+			log.Fatalf("Failed to create response cache: %v", err)
+		}
+		ttl, err := cfg.Cache.GetTTL()
+		if err != nil {
+			log.Fatalf("Invalid cache TTL: %v", err)
+		}
+		cacheConfig = &cache.Config{Store: store, TTL: ttl}
+		logger.Info("Response cache enabled", "backend", cmp.Or(cfg.Cache.Backend, "memory"), "ttl", ttl)
+	}
+
+	// Quality signals per agent and model, served on the admin server
+	quality := metrics.NewQuality()
+
+	budgetLimited := cfg.Budget.MaxTurnTokens > 0 || cfg.Budget.MaxSessionTokens > 0 || cfg.Budget.MaxSessionCost > 0
+
+	// decorate wraps a model in the refusal handling, budget, usage, response
+	// cache, quality metrics, budget limits, summarization, context length
+	// recovery, size limits and guardrails configured above; every agent's
+	// model goes through it
+	decorate := func(m adkmodel.LLM, tok tokenizer.Tokenizer, contextWindow int) (adkmodel.LLM, error) {
+		// Repair JSON responses first, so retries are billed and only valid answers cached
+		if cfg.Model.JSONRepair.Enabled {
+			jsonModel, err := llmmodel.NewJSONModel(m, &llmmodel.JSONConfig{MaxRetries: cfg.Model.JSONRepair.MaxRetries})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create JSON repair: %w", err)
+			}
+			m = jsonModel
+		}
+		m, err := refusal.NewModel(m, &refusal.Config{
+			Policy:           refusal.Policy(cfg.Refusal.Policy),
+			Message:          cfg.Refusal.Message,
+			RetryInstruction: cfg.Refusal.RetryInstruction,
+			Fallback:         refusalFallback,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create refusal policy: %w", err)
+		}
+		if budgetFallback != nil {
+			m = budget.NewFallbackModel(m, budgetFallback, budgetTracker, cfg.Budget.Fallback.GetThreshold(), auditRecorder)
+		}
+		if usageStore != nil {
+			m = usage.NewRecordingModel(m, usageStore, pricing)
+		}
+		// Cache hits are neither billed nor recorded as usage
+		if cacheConfig != nil {
+			m, err = cache.NewModel(m, cacheConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create response cache: %w", err)
+			}
+		}
+		m = quality.Model(m)
+
+		// Stop calling the model once the turn or session is over budget
+		if budgetLimited {
+			m, err = budget.NewLimitModel(m, &budget.LimitConfig{
+				MaxTurnTokens:      int64(cfg.Budget.MaxTurnTokens),
+				MaxSessionTokens:   int64(cfg.Budget.MaxSessionTokens),
+				MaxSessionCost:     cfg.Budget.MaxSessionCost,
+				Pricing:            pricing,
+				Action:             budget.Action(cfg.Budget.Action),
+				SummaryInstruction: cfg.Budget.SummaryInstruction,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create budget limits: %w", err)
+			}
+		}
+
+		// Summarize older turns of long conversations
+		if cfg.Conversation.Summarize {
+			m, err = conversation.NewSummarizer(m, &conversation.Config{
+				ContextWindow:    contextWindow,
+				Threshold:        cfg.Conversation.Threshold,
+				KeepTurns:        cfg.Conversation.KeepTurns,
+				SummaryMaxTokens: cfg.Conversation.SummaryMaxTokens,
+				SummaryPrompt:    cfg.Conversation.SummaryPrompt,
+				Tokenizer:        tok,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create conversation summarizer: %w", err)
+			}
+		}
+
+		// Retry requests the provider rejects as too long with a shorter history
+		if cfg.Conversation.Recover != "" {
+			m, err = conversation.NewRecovery(m, &conversation.RecoveryConfig{
+				Strategy:         conversation.Strategy(cfg.Conversation.Recover),
+				KeepTurns:        cfg.Conversation.KeepTurns,
+				SummaryMaxTokens: cfg.Conversation.SummaryMaxTokens,
+				SummaryPrompt:    cfg.Conversation.SummaryPrompt,
+				Tokenizer:        tok,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create context length recovery: %w", err)
+			}
+		}
+
+		if limitsEnabled {
+			m, err = limits.NewModel(m, &limits.Config{
+				MaxInputBytes:             maxInputSize,
+				MaxInputTokens:            cfg.Limits.MaxInputTokens,
+				InputAction:               limits.Action(cfg.Limits.InputAction),
+				TruncationMessage:         cfg.Limits.TruncationMessage,
+				RejectionMessage:          cfg.Limits.RejectionMessage,
+				MaxResponseBytes:          maxResponseSize,
+				MaxResponseTokens:         cfg.Limits.MaxResponseTokens,
+				ResponseTruncationMessage: cfg.Limits.ResponseTruncationMessage,
+				Tokenizer:                 tok,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create size limits: %w", err)
+			}
+		}
+		if guardrailsConfig != nil {
+			m, err = guardrails.NewModel(m, guardrailsConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create guardrails: %w", err)
+			}
+		}
+		if tracer != nil {
+			m = tracer.Model(m)
+		}
+		return m, nil
+	}
+	model, err = decorate(model, tok, contextWindow)
+	if err != nil {
+		log.Fatalf("Failed to create model: %v", err)
+	}
+
+	// Knowledge base behind the retrieve tool
+	var tools []tool.Tool
+	if cfg.RAG.Enabled {
+		retrieve, err := newRetrieveTool(ctx, cfg, timeout)
+		if err != nil {
+			log.Fatalf("Failed to enable RAG: %v", err)
+		}
+		tools = append(tools, retrieve)
+		logger.Info("RAG enabled",
+			"sources", cfg.RAG.Sources,
+			"store", cfg.RAG.Store,
+			"embedding_model", cfg.RAG.Embedding.ModelName,
+		)
+	}
+
+	// Local commands, only when explicitly enabled
+	if cfg.Shell.Enabled {
+		shellTimeout, err := cfg.Shell.GetTimeout()
+		if err != nil {
+			log.Fatalf("Invalid shell timeout: %v", err)
+		}
+		maxOutput, err := cfg.Shell.GetMaxOutput()
+		if err != nil {
+			log.Fatalf("Invalid shell max output: %v", err)
+		}
+		runner, err := shell.New(&shell.Config{
+			Allow:     cfg.Shell.Allow,
+			Dir:       cfg.Shell.Dir,
+			Timeout:   shellTimeout,
+			MaxOutput: int(maxOutput),
+			Env:       cfg.Shell.Env,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create shell tool: %v", err)
+		}
+		shellTool, err := runner.Tool()
+		if err != nil {
+			log.Fatalf("Failed to create shell tool: %v", err)
+		}
+		tools = append(tools, shellTool)
+		logger.Warn("Shell tool enabled, the agent can run local commands", "allow", cfg.Shell.Allow, "dir", cfg.Shell.Dir)
+	}
+
+	// Code snippets in a sandbox, only when explicitly enabled
+	if cfg.Interpreter.Enabled {
+		codeTimeout, err := cfg.Interpreter.GetTimeout()
+		if err != nil {
+			log.Fatalf("Invalid interpreter timeout: %v", err)
+		}
+		maxOutput, err := cfg.Interpreter.GetMaxOutput()
+		if err != nil {
+			log.Fatalf("Invalid interpreter max output: %v", err)
+		}
+		maxMemory, err := cfg.Interpreter.GetMaxMemory()
+		if err != nil {
+			log.Fatalf("Invalid interpreter max memory: %v", err)
+		}
+		interpreter, err := shell.NewInterpreter(&shell.InterpreterConfig{
+			Languages: cfg.Interpreter.Languages,
+			Runtime:   cfg.Interpreter.Runtime,
+			Images:    cfg.Interpreter.Images,
+			Timeout:   codeTimeout,
+			MaxOutput: int(maxOutput),
+			MaxMemory: maxMemory,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create code interpreter: %v", err)
+		}
+		codeTool, err := interpreter.Tool()
+		if err != nil {
+			log.Fatalf("Failed to create code interpreter tool: %v", err)
+		}
+		tools = append(tools, codeTool)
+		logger.Warn("Code interpreter enabled, the agent can run code", "runtime", cmp.Or(cfg.Interpreter.Runtime, "process"))
+	}
+
+	// File tools confined to the workspace root; with checkpoints, written
+	// files are rolled back with the session
+	var rollbackResources []checkpoint.Resource
+	if cfg.Workspace.Enabled {
+		maxFileSize, err := cfg.Workspace.GetMaxFileSize()
+		if err != nil {
+			log.Fatalf("Invalid workspace max file size: %v", err)
+		}
+		ws, err := workspace.New(&workspace.Config{
+			Root:        cfg.Workspace.Root,
+			MaxFileSize: int(maxFileSize),
+			ReadOnly:    cfg.Workspace.ReadOnly,
+			Journal:     cfg.Checkpoints.Enabled,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open workspace: %v", err)
+		}
+		rollbackResources = append(rollbackResources, ws)
+		fileTools, err := ws.Tools()
+		if err != nil {
+			log.Fatalf("Failed to create workspace tools: %v", err)
+		}
+		tools = append(tools, fileTools...)
+		logger.Info("Workspace tools enabled", "root", cmp.Or(cfg.Workspace.Root, "."), "read_only", cfg.Workspace.ReadOnly)
+	}
+
+	// Web search through the configured provider
+	if cfg.WebSearch.Enabled {
+		searchTool, provider, err := newWebSearchTool(&cfg.WebSearch)
+		if err != nil {
+			log.Fatalf("Failed to enable web search: %v", err)
+		}
+		tools = append(tools, searchTool)
+		logger.Info("Web search enabled", "provider", provider)
+	}
+
+	// HTTP requests to allowed hosts
+	if cfg.HTTPRequest.Enabled {
+		requestTimeout, err := cfg.HTTPRequest.GetTimeout()
+		if err != nil {
+			log.Fatalf("Invalid http_request timeout: %v", err)
+		}
+		maxResponseSize, err := cfg.HTTPRequest.GetMaxResponseSize()
+		if err != nil {
+			log.Fatalf("Invalid http_request max response size: %v", err)
+		}
+		httpClient, err := httptool.New(&httptool.Config{
+			Allow:           cfg.HTTPRequest.Allow,
+			Deny:            cfg.HTTPRequest.Deny,
+			Methods:         cfg.HTTPRequest.Methods,
+			Timeout:         requestTimeout,
+			MaxResponseSize: int(maxResponseSize),
+		})
+		if err != nil {
+			log.Fatalf("Failed to create http_request tool: %v", err)
+		}
+		httpTool, err := httpClient.Tool()
+		if err != nil {
+			log.Fatalf("Failed to create http_request tool: %v", err)
+		}
+		tools = append(tools, httpTool)
+		logger.Info("HTTP request tool enabled", "allow", cfg.HTTPRequest.Allow, "deny", cfg.HTTPRequest.Deny)
+	}
+
+	// Image generation through the images API
+	if cfg.Images.Enabled {
+		imagesClient, err := openai_compatible.NewClient(&openai_compatible.ClientConfig{
+			APIKey:    cmp.Or(cfg.Images.APIKey, cfg.Model.APIKey),
+			BaseURL:   cmp.Or(cfg.Images.BaseURL, cfg.Model.BaseURL),
+			ModelName: cmp.Or(cfg.Images.ModelName, "dall-e-3"),
+			Timeout:   timeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create images client: %v", err)
+		}
+		imageTool, err := imagegen.NewTool(imagesClient, &imagegen.Config{
+			Size:      cfg.Images.Size,
+			Quality:   cfg.Images.Quality,
+			MaxImages: cfg.Images.MaxImages,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create generate_image tool: %v", err)
+		}
+		tools = append(tools, imageTool)
+		logger.Info("Image generation enabled", "model", cmp.Or(cfg.Images.ModelName, "dall-e-3"))
+	}
+
+	// Saving and loading artifacts by name
+	if cfg.Artifacts.Tools {
+		artifactTools, err := artifacts.NewTools()
+		if err != nil {
+			log.Fatalf("Failed to create artifact tools: %v", err)
+		}
+		tools = append(tools, artifactTools...)
+		logger.Info("Artifact tools enabled")
+	}
+
+	// Enforce the timeout, retries and result size limit of each tool
+	toolPolicies, err := newToolPolicies(&cfg.ToolRuntime)
+	if err != nil {
+		log.Fatalf("Invalid tool runtime: %v", err)
+	}
+	tools, err = toolrun.Wrap(tools, toolPolicies)
+	if err != nil {
+		log.Fatalf("Failed to apply tool policies: %v", err)
+	}
+
+	// Steps of parallel workflows run in a branch, which needs the user message back
+	beforeModel := []llmagent.BeforeModelCallback{branchUserMessage}
+	var beforeTool []llmagent.BeforeToolCallback
+	var afterTool []llmagent.AfterToolCallback
+	if tracer != nil {
+		beforeTool = append(beforeTool, tracer.BeforeToolCallback())
+		afterTool = append(afterTool, tracer.AfterToolCallback())
+	}
+
+	// Authorize requests and tool calls against the configured rules
+	if len(cfg.Policy.Rules) > 0 || cfg.Policy.Default != "" {
+		rules := make([]policy.Rule, 0, len(cfg.Policy.Rules))
+		for _, r := range cfg.Policy.Rules {
+			rules = append(rules, policy.Rule{
+				Name:     r.Name,
+				On:       r.On,
+				Effect:   policy.Effect(r.Effect),
+				Users:    r.Users,
+				Tools:    r.Tools,
+				Args:     r.Args,
+				Text:     r.Text,
+				Hours:    r.Hours,
+				Days:     r.Days,
+				Timezone: r.Timezone,
+				When:     r.When,
+				Set:      r.Set,
+				Message:  r.Message,
+			})
+		}
+		engine, err := policy.New(&policy.Config{
+			Rules:   rules,
+			Default: policy.Effect(cfg.Policy.Default),
+		})
+		if err != nil {
+			log.Fatalf("Failed to create policy: %v", err)
+		}
+		beforeModel = append(beforeModel, engine.ModelCallback())
+		beforeTool = append(beforeTool, engine.ToolCallback())
+		logger.Info("Policy enabled", "rules", len(rules), "default", cmp.Or(cfg.Policy.Default, "allow"))
+	}
+
+	// Personas add a named instruction preset, switched per session
+	if len(cfg.Personas.Presets) > 0 {
+		personas := make([]persona.Persona, 0, len(cfg.Personas.Presets))
+		for _, p := range cfg.Personas.Presets {
+			personas = append(personas, persona.Persona{Name: p.Name, Description: p.Description, Instruction: p.Instruction})
+		}
+		switcher, err := persona.New(&persona.Config{Personas: personas, Default: cfg.Personas.Default})
+		if err != nil {
+			log.Fatalf("Failed to create personas: %v", err)
+		}
+		beforeModel = append(beforeModel, switcher.ModelCallback())
+		logger.Info("Personas enabled", "personas", len(personas), "default", cfg.Personas.Default)
+	}
+
+	// Plan mode records side-effectful tool calls for approval, in sessions that turn it on
+	planner, err := plan.New(&plan.Config{ReadOnly: cfg.Plan.ReadOnly, Audit: auditRecorder})
+	if err != nil {
+		log.Fatalf("Failed to create planner: %v", err)
+	}
+	beforeModel = append(beforeModel, planner.ModelCallback())
+	beforeTool = append(beforeTool, planner.ToolCallback())
+
+	// Hooks run after the built-in callbacks, on the calls those let through
+	var afterModel []llmagent.AfterModelCallback
+	if len(cfg.Hooks) > 0 {
+		specs := make([]hooks.Spec, 0, len(cfg.Hooks))
+		for _, h := range cfg.Hooks {
+			specs = append(specs, hooks.Spec{Name: h.Name, Options: h.Options})
+		}
+		chain, err := hooks.New(specs)
+		if err != nil {
+			log.Fatalf("Failed to create hooks: %v", err)
+		}
+		beforeModel = append(beforeModel, chain.BeforeModel())
+		afterModel = append(afterModel, chain.AfterModel())
+		beforeTool = append(beforeTool, chain.BeforeTool())
+		afterTool = append(afterTool, chain.AfterTool())
+		logger.Info("Hooks enabled", "hooks", chain.Len(), "registered", hooks.Names())
+	}
+
+	// Models of the agents by name; additional agents with a model profile get
+	// their own, decorated like the root one. The admin server swaps the
+	// models agents own underneath the decorators.
+	agentConfigs := map[string]*config.AgentConfig{cfg.Agent.Name: &cfg.Agent}
+	agentModels := map[string]adkmodel.LLM{cfg.Agent.Name: model}
+	switchableModels := map[string]*cli.SwitchableModel{cfg.Agent.Name: baseModel}
+	for i := range cfg.Agents {
+		agentConfig := &cfg.Agents[i]
+		agentConfigs[agentConfig.Name] = agentConfig
+		agentModels[agentConfig.Name] = model
+		if !agentConfig.Model.IsSet() {
+			continue
+		}
+
+		modelCfg := cfg.ModelFor(agentConfig)
+		agentTok, err := tokenizer.Select(modelCfg.Tokenizer, modelCfg.ModelName)
+		if err != nil {
+			log.Fatalf("Invalid tokenizer for agent %s: %v", agentConfig.Name, err)
+		}
+		agentModel, err := newModel(ctx, &modelCfg, timeout, streamIdleTimeout, agentTok, sealer)
+		if err != nil {
+			log.Fatalf("Failed to create model for agent %s: %v", agentConfig.Name, err)
+		}
+		if cfg.Model.CheckModel {
+			checkModel(ctx, agentModel, timeout)
+		}
+		closeOnExit(agentModel)
+		switchable := cli.NewSwitchableModel(agentModel)
+		switchableModels[agentConfig.Name] = switchable
+		agentModels[agentConfig.Name], err = decorate(switchable, agentTok, cmp.Or(cfg.Conversation.ContextWindow, modelCfg.ContextWindow))
+		if err != nil {
+			log.Fatalf("Failed to create model for agent %s: %v", agentConfig.Name, err)
+		}
+	}
+
+	// Create the named agent from config, the root one when name is empty;
+	// system replaces the configured instruction when set. Its sub-agents and
+	// agent tools are created anew, since an agent has a single parent.
+	workflowConfigs := make(map[string]*config.WorkflowConfig, len(cfg.Workflows))
+	for i := range cfg.Workflows {
+		workflowConfigs[cfg.Workflows[i].Name] = &cfg.Workflows[i]
+	}
+	var newNamedAgent func(name, system string) (agent.Agent, error)
+	newNamedAgent = func(name, system string) (agent.Agent, error) {
+		name = cmp.Or(name, cfg.Agent.Name)
+		if workflowConfig, ok := workflowConfigs[name]; ok {
+			if system != "" {
+				return nil, fmt.Errorf("workflow %s has no instruction to replace", name)
+			}
+			return newWorkflow(workflowConfig, newNamedAgent)
+		}
+		agentConfig, ok := agentConfigs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown agent %q", name)
+		}
+		agentCfg, err := newAgentConfig(agentConfig, agentModels[name], tools)
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range agentConfig.SubAgents {
+			subAgent, err := newNamedAgent(sub, "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create sub-agent %s: %w", sub, err)
+			}
+			agentCfg.SubAgents = append(agentCfg.SubAgents, subAgent)
+		}
+		agentCfg.Tools = slices.Clip(agentCfg.Tools)
+		for _, delegate := range agentConfig.AgentTools {
+			toolAgent, err := newNamedAgent(delegate, "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create agent tool %s: %w", delegate, err)
+			}
+			agentCfg.Tools = append(agentCfg.Tools, agenttool.New(toolAgent, nil))
+		}
+		agentCfg.BeforeModelCallbacks = beforeModel
+		agentCfg.AfterModelCallbacks = afterModel
+		agentCfg.BeforeToolCallbacks = beforeTool
+		agentCfg.AfterToolCallbacks = afterTool
+		if agentCfg.BeforeModelCallbacks, err = capToolIterations(agentConfig, beforeModel); err != nil {
+			return nil, err
+		}
+		if system != "" {
+			agentCfg.Instruction = system
+			agentCfg.InstructionProvider = nil
+		}
+		return llmagent.New(agentCfg)
+	}
+	newAgent := func(system string) (agent.Agent, error) {
+		return newNamedAgent("", system)
+	}
+	yanshu_agent, err := newAgent("")
 	if err != nil {
 		log.Fatalf("Failed to create agent: %v", err)
 	}
 	logger.Info("Agent created successfully", "name", cfg.Agent.Name)
 
+	// Additional agents share the tools and callbacks, workflows run them
+	var agents []agent.Agent
+	for _, agentConfig := range cfg.Agents {
+		a, err := newNamedAgent(agentConfig.Name, "")
+		if err != nil {
+			log.Fatalf("Failed to create agent %s: %v", agentConfig.Name, err)
+		}
+		agents = append(agents, a)
+		logger.Info("Agent created successfully", "name", agentConfig.Name, "model", agentModels[agentConfig.Name].Name())
+	}
+	for _, workflowConfig := range cfg.Workflows {
+		a, err := newNamedAgent(workflowConfig.Name, "")
+		if err != nil {
+			log.Fatalf("Failed to create workflow %s: %v", workflowConfig.Name, err)
+		}
+		agents = append(agents, a)
+		logger.Info("Workflow created successfully", "name", workflowConfig.Name, "type", workflowConfig.Type, "steps", workflowConfig.Steps)
+	}
+
+	// The root agent is the default, the others are loaded by name
 	launcherConfig := &launcher.Config{
 		AgentLoader: agent.NewSingleLoader(yanshu_agent),
 	}
+	// Keeps the files of tools and the model, such as generated images
+	launcherConfig.ArtifactService, err = newArtifactService(&cfg.Artifacts, sealer)
+	if err != nil {
+		log.Fatalf("Failed to create artifact service: %v", err)
+	}
+	logger.Info("Artifact storage configured", "backend", cmp.Or(cfg.Artifacts.Backend, "memory"))
+	if len(agents) > 0 {
+		launcherConfig.AgentLoader, err = agent.NewMultiLoader(yanshu_agent, agents...)
+		if err != nil {
+			log.Fatalf("Failed to create agent loader: %v", err)
+		}
+	}
 
-	logger.Info("Starting launcher", "args", os.Args[1:])
+	// Sessions idle for longer than the TTL move to the archive directory
+	sessions := session.InMemoryService()
+	idleTTL, err := cfg.Sessions.GetIdleTTL()
+	if err != nil {
+		log.Fatalf("Invalid session idle TTL: %v", err)
+	}
+	if idleTTL > 0 {
+		checkInterval, err := cfg.Sessions.GetCheckInterval()
+		if err != nil {
+			log.Fatalf("Invalid session check interval: %v", err)
+		}
+		var store archive.Store
+		store, err = archive.NewDirStore(cfg.Sessions.ArchiveDir)
+		if err != nil {
+			log.Fatalf("Failed to create session archive: %v", err)
+		}
+		if sealer != nil {
+			store = archive.NewSealedStore(store, sealer)
+		}
+		archiver, err := archive.New(&archive.Config{
+			Sessions: sessions,
+			Store:    store,
+			IdleTTL:  idleTTL,
+			Interval: checkInterval,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create session archival: %v", err)
+		}
+		go archiver.Run(ctx)
+		// Sessions in memory would be lost on exit
+		onExit = append(onExit, func() { archiver.Flush(context.Background()) })
+		sessions = archiver
+		launcherConfig.SessionService = sessions
+		logger.Info("Session archival enabled", "idle_ttl", idleTTL, "dir", cfg.Sessions.ArchiveDir)
+	}
+
+	// Sessions with checkpoints to roll back to
+	var checkpoints *checkpoint.Service
+	if cfg.Checkpoints.Enabled {
+		checkpoints, err = checkpoint.New(&checkpoint.Config{
+			Sessions:  sessions,
+			Auto:      cfg.Checkpoints.Auto,
+			Max:       cfg.Checkpoints.Max,
+			Resources: rollbackResources,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create checkpoints: %v", err)
+		}
+		launcherConfig.SessionService = checkpoints
+		logger.Info("Session checkpoints enabled", "auto", cfg.Checkpoints.Auto, "workspace", len(rollbackResources) > 0)
+	}
 
-	l := full.NewLauncher()
-	if err = l.Execute(ctx, launcherConfig, os.Args[1:]); err != nil {
+	// Start memory monitor
+	softLimit, err := cfg.Memory.GetSoftLimit()
+	if err != nil {
+		log.Fatalf("Invalid memory soft limit: %v", err)
+	}
+	hardLimit, err := cfg.Memory.GetHardLimit()
+	if err != nil {
+		log.Fatalf("Invalid memory hard limit: %v", err)
+	}
+	checkInterval, err := cfg.Memory.GetCheckInterval()
+	if err != nil {
+		log.Fatalf("Invalid memory check interval: %v", err)
+	}
+	memMonitor, err := memlimit.NewMonitor(&memlimit.Config{
+		SoftLimit:     softLimit,
+		HardLimit:     hardLimit,
+		CheckInterval: checkInterval,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create memory monitor: %v", err)
+	}
+	// Past the soft limit, drop the in-memory response cache and pooled request buffers
+	memMonitor.OnSoftLimit(openai_compatible.ReleaseBuffers)
+	if cacheConfig != nil {
+		if store, ok := cacheConfig.Store.(*cache.MemoryStore); ok {
+			memMonitor.OnSoftLimit(store.Clear)
+		}
+	}
+	if softLimit > 0 || hardLimit > 0 {
+		go memMonitor.Run(ctx)
+	}
+
+	var adminServer *admin.Server
+	if cfg.Admin.Enabled {
+		adminCfg := &admin.Config{
+			Addr:  cfg.Admin.Addr,
+			Token: cfg.Admin.Token,
+		}
+		adminCfg.Audit = auditRecorder
+		adminServer = admin.NewServer(adminCfg)
+		adminServer.Handle("/log/level", logLevel)
+		if backendMonitor != nil {
+			adminServer.Handle("/backend/status", backendMonitor)
+		}
+		adminServer.Handle("/metrics", quality)
+		if cfg.Model.CircuitBreaker.Enabled {
+			adminServer.HandleFunc("/circuits", openai_compatible.BreakersHandler)
+		}
+		if usageStore != nil {
+			adminServer.Handle("/usage", usage.NewHandler(usageStore))
+		}
+
+		drainTimeout, err := cfg.Admin.GetDrainTimeout()
+		if err != nil {
+			log.Fatalf("Invalid drain timeout: %v", err)
+		}
+		models, err := cli.NewModelsHandler(&cli.ModelsConfig{
+			Models:  switchableModels,
+			Default: cfg.Agent.Name,
+			NewModel: func(ctx context.Context, name string, p cli.ModelProfile) (adkmodel.LLM, error) {
+				modelCfg := cfg.ModelFor(&config.AgentConfig{Model: config.AgentModelConfig{
+					Provider:  p.Provider,
+					ModelName: p.ModelName,
+					BaseURL:   p.BaseURL,
+					APIKey:    p.APIKey,
+				}})
+				tok, err := tokenizer.Select(modelCfg.Tokenizer, modelCfg.ModelName)
+				if err != nil {
+					return nil, err
+				}
+				// The model outlives the request
+				return newModel(context.WithoutCancel(ctx), &modelCfg, timeout, streamIdleTimeout, tok, sealer)
+			},
+			DrainTimeout: drainTimeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create model swap endpoint: %v", err)
+		}
+		adminServer.Handle("/models", models)
+		if auth != nil {
+			adminServer.Handle("/keys", auth)
+		}
+		if tracer != nil {
+			adminServer.Handle("/traces", tracer)
+		}
+		if checkpoints != nil {
+			adminServer.Handle("/sessions/checkpoints", checkpoints)
+			adminServer.Handle("/sessions/rollback", checkpoints.RollbackHandler())
+		}
+	}
+
+	// Liveness and readiness probes, readiness checking the model provider
+	var checks []health.Check
+	if keeper != nil {
+		checks = append(checks, health.Check{Name: "warmup", Run: func(context.Context) error {
+			if !keeper.Ready() {
+				return fmt.Errorf("local model is not loaded")
+			}
+			return nil
+		}})
+	}
+	switch cfg.Server.Health.Upstream {
+	case "models":
+		if _, ok := baseModel.Current().(health.Lister); !ok {
+			log.Fatalf("Provider %s cannot list models, use the completion upstream check", cfg.Model.Provider)
+		}
+		checks = append(checks, health.Check{Name: "model", Run: health.ModelsCheck(baseModel.Current)})
+	case "completion":
+		checks = append(checks, health.Check{Name: "model", Run: health.CompletionCheck(baseModel)})
+	}
+	healthCacheTTL, err := cfg.Server.Health.GetCacheTTL()
+	if err != nil {
+		log.Fatalf("Invalid health cache TTL: %v", err)
+	}
+	healthTimeout, err := cfg.Server.Health.GetTimeout()
+	if err != nil {
+		log.Fatalf("Invalid health check timeout: %v", err)
+	}
+	checker, err := health.New(&health.Config{Checks: checks, CacheTTL: healthCacheTTL, Timeout: healthTimeout})
+	if err != nil {
+		log.Fatalf("Failed to create health checks: %v", err)
+	}
+
+	// Web server from config, nil when built without it
+	middlewares := []func(http.Handler) http.Handler{memMonitor.Middleware}
+	if auth != nil {
+		middlewares = append([]func(http.Handler) http.Handler{auth.Middleware}, middlewares...)
+	}
+	voice, err := newVoice(cfg, timeout)
+	if err != nil {
+		log.Fatalf("Failed to create speech client: %v", err)
+	}
+	webLauncher, err := newWebLauncher(&cfg.Server, middlewares, adminServer, map[string]http.Handler{
+		"/healthz": checker.LiveHandler(),
+		"/readyz":  checker,
+	}, voice, memMonitor.AllowNewSession)
+	if err != nil {
+		log.Fatalf("Failed to create web launcher: %v", err)
+	}
+	if webLauncher == nil && cfg.Admin.Enabled {
+		logger.Warn("Admin server unavailable: built without the web launcher")
+	}
+	// newNamedModel creates the configured model under another name, for the
+	// commands switching models
+	newNamedModel := func(ctx context.Context, name string) (adkmodel.LLM, error) {
+		modelCfg := cfg.Model
+		modelCfg.ModelName = name
+		tok, err := tokenizer.Select(modelCfg.Tokenizer, name)
+		if err != nil {
+			return nil, err
+		}
+		return newModel(ctx, &modelCfg, timeout, streamIdleTimeout, tok, sealer)
+	}
+	slackLauncher, err := newSlackLauncher(&cfg.Slack)
+	if err != nil {
+		log.Fatalf("Failed to create slack launcher: %v", err)
+	}
+	telegramLauncher, err := newTelegramLauncher(&cfg.Telegram, voice)
+	if err != nil {
+		log.Fatalf("Failed to create telegram launcher: %v", err)
+	}
+	discordLauncher, err := newDiscordLauncher(&cfg.Discord, baseModel, newNamedModel)
+	if err != nil {
+		log.Fatalf("Failed to create discord launcher: %v", err)
+	}
+	sublaunchers := []launcher.SubLauncher{console.NewLauncher()}
+	for _, l := range []launcher.SubLauncher{webLauncher, slackLauncher, telegramLauncher, discordLauncher} {
+		if l != nil {
+			sublaunchers = append(sublaunchers, l)
+		}
+	}
+
+	logger.Info("Starting launcher", "args", args, "features", features)
+
+	l := universal.NewLauncher(append(sublaunchers,
+		cli.NewProfileLauncher(&cli.ProfileConfig{
+			AdminAddr:  cfg.Admin.Addr,
+			AdminToken: cfg.Admin.Token,
+		}),
+		cli.NewUsageLauncher(usageStore),
+		cli.NewAuditLauncher(auditLog),
+		cli.NewTraceLauncher(traceStore),
+		cli.NewDecryptLauncher(sealer),
+		cli.NewRunLauncher(),
+		cli.NewDiffLauncher(&cli.DiffConfig{
+			NewAgent: newNamedAgent,
+			ModelName: func(name string) string {
+				if m, ok := agentModels[cmp.Or(name, cfg.Agent.Name)]; ok {
+					return m.Name()
+				}
+				return ""
+			},
+		}),
+		cli.NewEvalLauncher(&cli.EvalConfig{
+			NewAgent: func(name string) (agent.Agent, error) {
+				return newNamedAgent(name, "")
+			},
+			Judge:      baseModel,
+			AllowBatch: memMonitor.AllowBatch,
+		}),
+		cli.NewBenchLauncher(&cli.BenchConfig{
+			Model: func(name string) (adkmodel.LLM, error) {
+				name = cmp.Or(name, cfg.Agent.Name)
+				if m, ok := switchableModels[name]; ok {
+					return m, nil
+				}
+				if _, ok := agentConfigs[name]; ok {
+					return baseModel, nil
+				}
+				return nil, fmt.Errorf("unknown agent %q", name)
+			},
+			AllowBatch: memMonitor.AllowBatch,
+		}),
+		cli.NewDebugLauncher(&cli.DebugConfig{
+			NewAgent: func(before llmagent.BeforeToolCallback, after llmagent.AfterToolCallback) (agent.Agent, error) {
+				agentCfg, err := newAgentConfig(&cfg.Agent, model, tools)
+				if err != nil {
+					return nil, err
+				}
+				if agentCfg.BeforeModelCallbacks, err = capToolIterations(&cfg.Agent, beforeModel); err != nil {
+					return nil, err
+				}
+				agentCfg.AfterModelCallbacks = afterModel
+				agentCfg.BeforeToolCallbacks = append([]llmagent.BeforeToolCallback{before}, beforeTool...)
+				agentCfg.AfterToolCallbacks = append([]llmagent.AfterToolCallback{after}, afterTool...)
+				return llmagent.New(agentCfg)
+			},
+			Model: baseModel,
+		}),
+		cli.NewChatLauncher(&cli.ChatConfig{
+			NewAgent:       newAgent,
+			Model:          baseModel,
+			NewModel:       newNamedModel,
+			Personas:       len(cfg.Personas.Presets) > 0,
+			SessionService: launcherConfig.SessionService,
+		}),
+	)...)
+	err = l.Execute(ctx, launcherConfig, args)
+	for _, f := range onExit {
+		f()
+	}
+	if err != nil {
 		log.Fatalf("Run failed: %v\n\n%s", err, l.CommandLineSyntax())
 	}
+	logger.Info("Agent stopped")
+}
+
+// newLogFile opens the rotating log file
+func newLogFile(cfg *config.LogFileConfig) (*logging.File, error) {
+	maxSize, err := cfg.GetMaxSize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max size: %w", err)
+	}
+	maxAge, err := cfg.GetMaxAge()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max age: %w", err)
+	}
+	return logging.OpenFile(&logging.FileConfig{
+		Path:       cfg.Path,
+		MaxSize:    maxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     maxAge,
+		Compress:   cfg.Compress,
+	})
+}
+
+// newCacheStore creates the response cache store of the configured backend
+func newCacheStore(cfg *config.CacheConfig) (cache.Store, error) {
+	if cfg.Backend == "redis" {
+		return cache.NewRedisStore(cfg.RedisURL, cmp.Or(cfg.KeyPrefix, "yanshu:cache:"))
+	}
+	maxSize, err := cfg.GetMaxSize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max size: %w", err)
+	}
+	return cache.NewMemoryStore(cmp.Or(cfg.MaxEntries, 1000), maxSize), nil
+}
+
+// newArtifactService creates the artifact store of the configured backend
+func newArtifactService(cfg *config.ArtifactsConfig, sealer *envelope.Sealer) (artifact.Service, error) {
+	var blobs artifacts.Blobs
+	var err error
+	switch cfg.Backend {
+	case "dir":
+		blobs, err = artifacts.NewDirBlobs(cmp.Or(cfg.Dir, "data/artifacts"))
+	case "s3":
+		blobs, err = artifacts.NewS3Blobs(&artifacts.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Bucket:          cfg.S3.Bucket,
+			Region:          cfg.S3.Region,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			Prefix:          cfg.S3.Prefix,
+		})
+	default:
+		return artifact.InMemoryService(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sealer != nil {
+		blobs = artifacts.NewSealedBlobs(blobs, sealer)
+	}
+	return artifacts.NewService(blobs)
+}
+
+// newSealer creates the sealer encrypting data at rest, nil when encryption
+// is disabled
+func newSealer(cfg *config.EncryptionConfig) (*envelope.Sealer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var keys envelope.KeyProvider
+	switch cfg.Provider {
+	case "", "local":
+		local, err := envelope.LoadLocalKeys(cfg.MasterKey, cfg.MasterKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = local
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", cfg.Provider)
+	}
+	return envelope.New(&envelope.Config{Keys: keys, Scope: cfg.Tenant})
+}
+
+// capToolIterations returns the before-model callbacks of an agent, ending
+// with its tool iteration cap when it has one, so the cap applies to the
+// requests the other callbacks let through
+func capToolIterations(cfg *config.AgentConfig, callbacks []llmagent.BeforeModelCallback) ([]llmagent.BeforeModelCallback, error) {
+	if cfg.MaxToolIterations <= 0 {
+		return callbacks, nil
+	}
+	guard, err := toolloop.New(&toolloop.Config{
+		MaxIterations: cfg.MaxToolIterations,
+		Action:        toolloop.Action(cfg.OnMaxToolIterations),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tool iteration cap of %s: %w", cfg.Name, err)
+	}
+	return append(slices.Clip(callbacks), guard.ModelCallback()), nil
+}
+
+// newToolPolicies converts the configured tool policies, nil when there are none
+func newToolPolicies(cfg *config.ToolRuntimeConfig) (*toolrun.Config, error) {
+	if cfg.Default == (config.ToolPolicyConfig{}) && len(cfg.Tools) == 0 {
+		return nil, nil
+	}
+	policy := func(name string, p *config.ToolPolicyConfig) (toolrun.Policy, error) {
+		timeout, err := p.GetTimeout()
+		if err != nil {
+			return toolrun.Policy{}, fmt.Errorf("%s: invalid timeout: %w", name, err)
+		}
+		maxSize, err := p.GetMaxResultSize()
+		if err != nil {
+			return toolrun.Policy{}, fmt.Errorf("%s: invalid max result size: %w", name, err)
+		}
+		return toolrun.Policy{Timeout: timeout, Retries: p.Retries, MaxResultBytes: int(maxSize)}, nil
+	}
+	def, err := policy("default", &cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+	policies := &toolrun.Config{Default: def, Tools: make(map[string]toolrun.Policy, len(cfg.Tools))}
+	for name, p := range cfg.Tools {
+		if policies.Tools[name], err = policy(name, &p); err != nil {
+			return nil, err
+		}
+	}
+	return policies, nil
+}
+
+// newModerator creates the moderation client of moderation checks, nil when
+// no check needs it
+func newModerator(cfg *config.Config, timeout time.Duration) (guardrails.Moderator, error) {
+	if !slices.ContainsFunc(cfg.Guardrails.Input, func(c config.GuardrailCheckConfig) bool { return c.Check == guardrails.CheckModeration }) {
+		return nil, nil
+	}
+	return openai_compatible.NewClient(&openai_compatible.ClientConfig{
+		APIKey:    cmp.Or(cfg.Guardrails.Moderation.APIKey, cfg.Model.APIKey),
+		BaseURL:   cmp.Or(cfg.Guardrails.Moderation.BaseURL, cfg.Model.BaseURL),
+		ModelName: cmp.Or(cfg.Guardrails.Moderation.ModelName, "omni-moderation-latest"),
+		Timeout:   timeout,
+	})
+}
+
+// newVoice creates the voice speaking final answers, nil when speech is off
+func newVoice(cfg *config.Config, timeout time.Duration) (*openai_compatible.Voice, error) {
+	if !cfg.Speech.Enabled {
+		return nil, nil
+	}
+	client, err := openai_compatible.NewClient(&openai_compatible.ClientConfig{
+		APIKey:    cmp.Or(cfg.Speech.APIKey, cfg.Model.APIKey),
+		BaseURL:   cmp.Or(cfg.Speech.BaseURL, cfg.Model.BaseURL),
+		ModelName: cmp.Or(cfg.Speech.ModelName, "gpt-4o-mini-tts"),
+		Timeout:   timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openai_compatible.NewVoice(client, &openai_compatible.SpeechConfig{
+		Voice:  cfg.Speech.Voice,
+		Format: cfg.Speech.Format,
+		Speed:  cfg.Speech.Speed,
+	})
+}
+
+// guardrailChecks converts the checks of a guardrail pipeline
+func guardrailChecks(checks []config.GuardrailCheckConfig, moderator guardrails.Moderator) ([]guardrails.CheckConfig, error) {
+	var out []guardrails.CheckConfig
+	for _, c := range checks {
+		maxSize, err := c.GetMaxSize()
+		if err != nil {
+			return nil, fmt.Errorf("invalid max size of %s check: %w", c.Check, err)
+		}
+		out = append(out, guardrails.CheckConfig{
+			Check:    c.Check,
+			Action:   guardrails.Action(c.Action),
+			MaxSize:  maxSize,
+			Topics:   c.Topics,
+			Types:    c.Types,
+			Patterns: c.Patterns,
+
+			Moderator:  moderator,
+			Thresholds: c.Thresholds,
+		})
+	}
+	return out, nil
+}
+
+// newAgentConfig creates the llmagent configuration of an agent, without
+// callbacks. Its tools are picked from tools by name.
+func newAgentConfig(cfg *config.AgentConfig, model adkmodel.LLM, tools []tool.Tool) (llmagent.Config, error) {
+	// Default generation parameters, requests may still override them
+	generation := &genai.GenerateContentConfig{
+		Temperature:     cfg.Generation.Temperature,
+		TopP:            cfg.Generation.TopP,
+		MaxOutputTokens: cfg.Generation.MaxTokens,
+		StopSequences:   cfg.Generation.Stop,
+	}
+	if cfg.Generation.JSONMode {
+		generation.ResponseMIMEType = "application/json"
+	}
+	if cfg.Generation.JSONSchema != nil {
+		generation.ResponseMIMEType = "application/json"
+		generation.ResponseJsonSchema = cfg.Generation.JSONSchema
+	}
+
+	// Per-turn context sections are merged into the instruction in config order
+	var instructionProvider llmagent.InstructionProvider
+	if len(cfg.Context) > 0 {
+		sections := make([]instruction.Section, 0, len(cfg.Context))
+		for _, c := range cfg.Context {
+			section, err := instruction.FromSpec(instruction.Spec{
+				Title:    c.Title,
+				Provider: c.Provider,
+				Template: c.Template,
+				Timezone: c.Timezone,
+			})
+			if err != nil {
+				return llmagent.Config{}, fmt.Errorf("invalid agent context section: %w", err)
+			}
+			sections = append(sections, section)
+		}
+		instructionProvider = instruction.NewComposer(cfg.Instruction, sections...).Instruction
+	}
+
+	if len(cfg.Tools) > 0 {
+		selected := make([]tool.Tool, 0, len(cfg.Tools))
+		for _, name := range cfg.Tools {
+			i := slices.IndexFunc(tools, func(t tool.Tool) bool { return t.Name() == name })
+			if i < 0 {
+				return llmagent.Config{}, fmt.Errorf("unknown tool %q", name)
+			}
+			selected = append(selected, tools[i])
+		}
+		tools = selected
+	}
+
+	return llmagent.Config{
+		Name:                  cfg.Name,
+		Model:                 model,
+		Description:           cfg.Description,
+		Instruction:           cfg.Instruction,
+		InstructionProvider:   instructionProvider,
+		GenerateContentConfig: generation,
+		Tools:                 tools,
+		OutputKey:             cfg.OutputKey,
+	}, nil
+}
+
+// newWorkflow creates a workflow agent running fresh instances of its steps,
+// created by newAgent. A parallel workflow with a merge step becomes a
+// sequence of the parallel steps and the merge step.
+func newWorkflow(cfg *config.WorkflowConfig, newAgent func(name, system string) (agent.Agent, error)) (agent.Agent, error) {
+	steps := make([]agent.Agent, 0, len(cfg.Steps))
+	for _, name := range cfg.Steps {
+		step, err := newAgent(name, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create step %s of workflow %s: %w", name, cfg.Name, err)
+		}
+		steps = append(steps, step)
+	}
+	agentCfg := agent.Config{
+		Name:        cfg.Name,
+		Description: cfg.Description,
+		SubAgents:   steps,
+	}
+	if cfg.Type == "sequential" {
+		return sequentialagent.New(sequentialagent.Config{AgentConfig: agentCfg})
+	}
+	if cfg.Merge == "" {
+		return parallelagent.New(parallelagent.Config{AgentConfig: agentCfg})
+	}
+
+	agentCfg.Name = cfg.Name + "_branches"
+	branches, err := parallelagent.New(parallelagent.Config{AgentConfig: agentCfg})
+	if err != nil {
+		return nil, err
+	}
+	merge, err := newAgent(cfg.Merge, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merge step %s of workflow %s: %w", cfg.Merge, cfg.Name, err)
+	}
+	return sequentialagent.New(sequentialagent.Config{AgentConfig: agent.Config{
+		Name:        cfg.Name,
+		Description: cfg.Description,
+		SubAgents:   []agent.Agent{branches, merge},
+	}})
+}
+
+// branchUserMessage prepends the user message to requests made in a branch,
+// as by steps of parallel workflows. ADK leaves the message out of branch
+// history because the event carrying it has no branch.
+func branchUserMessage(ctx agent.CallbackContext, req *adkmodel.LLMRequest) (*adkmodel.LLMResponse, error) {
+	msg := ctx.UserContent()
+	if ctx.Branch() == "" || msg == nil {
+		return nil, nil
+	}
+	if slices.ContainsFunc(req.Contents, func(c *genai.Content) bool {
+		return c.Role == genai.RoleUser && reflect.DeepEqual(c.Parts, msg.Parts)
+	}) {
+		return nil, nil
+	}
+	req.Contents = append([]*genai.Content{msg}, req.Contents...)
+	return nil, nil
+}
+
+// checkModel exits when the provider does not serve the model of llm,
+// listing the closest models it does. A provider that cannot list its models
+// only gets a warning.
+func checkModel(ctx context.Context, llm adkmodel.LLM, timeout time.Duration) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := llmmodel.CheckModel(ctx, llm)
+	var notFound *llmmodel.ModelNotFoundError
+	switch {
+	case err == nil:
+		slog.Debug("Model found on the provider", "model", llm.Name())
+	case errors.As(err, &notFound):
+		slog.Error("Model not found on the provider", "model", notFound.Model, "alternatives", notFound.Alternatives)
+		log.Fatalf("Invalid model_name: %v", err)
+	default:
+		slog.Warn("Could not check the model on the provider", "model", llm.Name(), "error", err)
+	}
+}
+
+// newModel creates the model for the configured provider
+func newModel(ctx context.Context, cfg *config.ModelConfig, timeout, streamIdleTimeout time.Duration, tok tokenizer.Tokenizer, sealer *envelope.Sealer) (adkmodel.LLM, error) {
+	maxInlineDataSize, err := cfg.GetMaxInlineDataSize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max inline data size: %w", err)
+	}
+	maxImageSize, err := cfg.GetMaxImageSize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max image size: %w", err)
+	}
+	queueTimeout, err := cfg.GetQueueTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid queue timeout: %w", err)
+	}
+	var breaker *openai_compatible.BreakerConfig
+	if cfg.CircuitBreaker.Enabled {
+		window, err := cfg.CircuitBreaker.GetWindow()
+		if err != nil {
+			return nil, fmt.Errorf("invalid circuit breaker window: %w", err)
+		}
+		openDuration, err := cfg.CircuitBreaker.GetOpenDuration()
+		if err != nil {
+			return nil, fmt.Errorf("invalid circuit breaker open duration: %w", err)
+		}
+		breaker = &openai_compatible.BreakerConfig{
+			Window:       window,
+			MinRequests:  cfg.CircuitBreaker.MinRequests,
+			FailureRate:  cfg.CircuitBreaker.FailureRate,
+			OpenDuration: openDuration,
+		}
+	}
+	opts := llmmodel.ClientOptions{
+		Timeout: timeout,
+
+		StreamRetries:     cfg.StreamRetries,
+		StreamIdleTimeout: streamIdleTimeout,
+		StreamUsage:       cfg.StreamUsage,
+		StrictTools:       cfg.StrictTools,
+
+		AuthHeader: cfg.AuthHeader,
+		Headers:    cfg.Headers,
+
+		Tokenizer:       tok,
+		ContextWindow:   cfg.ContextWindow,
+		TruncateHistory: cfg.TruncateHistory,
+
+		MaxInlineDataSize: int(maxInlineDataSize),
+		InputMIMETypes:    cfg.InputMIMETypes,
+		MaxImageSize:      int(maxImageSize),
+		MaxImageDimension: cfg.MaxImageDimension,
+
+		DumpDir:    cfg.DumpDir,
+		DumpSealer: sealer,
+
+		MaxConcurrentRequests: cfg.MaxConcurrentRequests,
+		QueueTimeout:          queueTimeout,
+		CompressRequests:      cfg.CompressRequests,
+		CircuitBreaker:        breaker,
+	}
+	switch cfg.Provider {
+	case "deepseek":
+		return llmmodel.NewModel(ctx, &llmmodel.Config{
+			APIKey:        cfg.APIKey,
+			ModelName:     cfg.ModelName,
+			BaseURL:       cfg.BaseURL,
+			ClientOptions: opts,
+		})
+	case "openai":
+		return llmmodel.NewOpenAIModel(ctx, &llmmodel.OpenAIConfig{
+			APIKey:        cfg.APIKey,
+			ModelName:     cfg.ModelName,
+			BaseURL:       cfg.BaseURL,
+			ClientOptions: opts,
+
+			Organization: cfg.Organization,
+			Project:      cfg.Project,
+		})
+	case "openrouter":
+		router := cfg.OpenRouter
+		var provider *llmmodel.OpenRouterProviderPreferences
+		if p := router.Provider; len(p.Order) > 0 || len(p.Only) > 0 || len(p.Ignore) > 0 || p.AllowFallbacks != nil ||
+			p.RequireParameters || p.DataCollection != "" || p.Sort != "" {
+			provider = &llmmodel.OpenRouterProviderPreferences{
+				Order:             p.Order,
+				Only:              p.Only,
+				Ignore:            p.Ignore,
+				AllowFallbacks:    p.AllowFallbacks,
+				RequireParameters: p.RequireParameters,
+				DataCollection:    p.DataCollection,
+				Sort:              p.Sort,
+			}
+		}
+		return llmmodel.NewOpenRouterModel(ctx, &llmmodel.OpenRouterConfig{
+			APIKey:        cfg.APIKey,
+			ModelName:     cfg.ModelName,
+			BaseURL:       cfg.BaseURL,
+			ClientOptions: opts,
+
+			SiteURL:    router.SiteURL,
+			AppName:    router.AppName,
+			Models:     router.Models,
+			Transforms: router.Transforms,
+			Provider:   provider,
+
+			CacheControl: router.CacheControl,
+		})
+	case "triton":
+		return newTritonModel(ctx, cfg, timeout)
+	default:
+		if !slices.Contains(llmmodel.PresetNames(), cfg.Provider) {
+			return nil, fmt.Errorf("unknown model provider %q (must be deepseek, openai, openrouter, triton or a preset: %s)",
+				cfg.Provider, strings.Join(llmmodel.PresetNames(), ", "))
+		}
+		return llmmodel.NewPresetModel(ctx, cfg.Provider, &llmmodel.PresetConfig{
+			APIKey:        cfg.APIKey,
+			ModelName:     cfg.ModelName,
+			BaseURL:       cfg.BaseURL,
+			ClientOptions: opts,
+
+			Thinking: cfg.Thinking,
+		})
+	}
 }
@@ -0,0 +1,36 @@
+//go:build !nodiscord && !minimal
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/cli"
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/discord"
+	"google.golang.org/adk/cmd/launcher"
+	adkmodel "google.golang.org/adk/model"
+)
+
+func init() {
+	features = append(features, "discord")
+}
+
+// newDiscordLauncher creates the discord command running the agent as a
+// Discord bot, whose /model command switches model with newModel
+func newDiscordLauncher(cfg *config.DiscordConfig, model *cli.SwitchableModel, newModel func(ctx context.Context, name string) (adkmodel.LLM, error)) (launcher.SubLauncher, error) {
+	updateInterval, err := cfg.GetUpdateInterval()
+	if err != nil {
+		return nil, fmt.Errorf("invalid discord update interval: %w", err)
+	}
+	return discord.NewLauncher(&discord.Config{
+		Token:           cfg.Token,
+		GuildID:         cfg.GuildID,
+		AllowedChannels: cfg.AllowedChannels,
+		ModelAdmins:     cfg.ModelAdmins,
+		Model:           model,
+		NewModel:        newModel,
+		UpdateInterval:  updateInterval,
+	}), nil
+}
@@ -0,0 +1,18 @@
+//go:build nodiscord || minimal
+
+package main
+
+import (
+	"context"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/cli"
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"google.golang.org/adk/cmd/launcher"
+	adkmodel "google.golang.org/adk/model"
+)
+
+// newDiscordLauncher returns no launcher: the discord command is left out of
+// this build
+func newDiscordLauncher(*config.DiscordConfig, *cli.SwitchableModel, func(context.Context, string) (adkmodel.LLM, error)) (launcher.SubLauncher, error) {
+	return nil, nil
+}
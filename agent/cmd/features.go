@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+// features lists the optional features compiled in, added by the init
+// functions of their files. Each is left out by its build tag, and all of
+// them by the minimal tag:
+//
+//	go build -tags minimal ./cmd
+//	go build -tags norag,notriton ./cmd
+var features []string
+
+// notBuilt is the error of a feature left out at compile time
+func notBuilt(feature, tag string) error {
+	return fmt.Errorf("%s is not available in this build (built with the %s or minimal tag)", feature, tag)
+}
@@ -0,0 +1,71 @@
+//go:build !norag && !minimal
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/rag"
+	"google.golang.org/adk/tool"
+)
+
+func init() {
+	features = append(features, "rag")
+}
+
+// newRetrieveTool ingests the knowledge base sources and returns the
+// retrieve tool searching them
+func newRetrieveTool(ctx context.Context, cfg *config.Config, timeout time.Duration) (tool.Tool, error) {
+	if cfg.RAG.Embedding.ModelName == "" {
+		return nil, fmt.Errorf("RAG requires an embedding model (set rag.embedding.model_name in config)")
+	}
+	embedder, err := openai_compatible.NewClient(&openai_compatible.ClientConfig{
+		APIKey:    cmp.Or(cfg.RAG.Embedding.APIKey, cfg.Model.APIKey),
+		BaseURL:   cmp.Or(cfg.RAG.Embedding.BaseURL, cfg.Model.BaseURL),
+		ModelName: cfg.RAG.Embedding.ModelName,
+		Timeout:   timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings client: %w", err)
+	}
+
+	var store rag.Store
+	switch cfg.RAG.Store {
+	case "memory":
+		store = rag.NewMemoryStore()
+	case "file", "":
+		store, err = rag.NewFileStore(cfg.RAG.StorePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open vector store: %w", err)
+		}
+	case "sqlite":
+		store, err = rag.NewSQLiteStore(cfg.RAG.StorePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open vector store: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid RAG store %q (must be memory, file or sqlite)", cfg.RAG.Store)
+	}
+
+	index, err := rag.NewIndex(embedder, store, &rag.Config{
+		ChunkSize:    cfg.RAG.ChunkSize,
+		ChunkOverlap: cfg.RAG.ChunkOverlap,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create RAG index: %w", err)
+	}
+	if _, err := index.IngestFiles(ctx, cfg.RAG.Sources...); err != nil {
+		return nil, fmt.Errorf("failed to ingest RAG sources: %w", err)
+	}
+
+	retrieve, err := rag.NewRetrieveTool(index, cfg.RAG.TopK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retrieve tool: %w", err)
+	}
+	return retrieve, nil
+}
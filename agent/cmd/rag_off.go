@@ -0,0 +1,16 @@
+//go:build norag || minimal
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"google.golang.org/adk/tool"
+)
+
+// newRetrieveTool fails: RAG is left out of this build
+func newRetrieveTool(context.Context, *config.Config, time.Duration) (tool.Tool, error) {
+	return nil, notBuilt("RAG", "norag")
+}
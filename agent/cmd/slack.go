@@ -0,0 +1,30 @@
+//go:build !noslack && !minimal
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/slack"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+func init() {
+	features = append(features, "slack")
+}
+
+// newSlackLauncher creates the slack command running the agent as a Slack app
+func newSlackLauncher(cfg *config.SlackConfig) (launcher.SubLauncher, error) {
+	updateInterval, err := cfg.GetUpdateInterval()
+	if err != nil {
+		return nil, fmt.Errorf("invalid slack update interval: %w", err)
+	}
+	return slack.NewLauncher(&slack.Config{
+		AppToken:       cfg.AppToken,
+		BotToken:       cfg.BotToken,
+		SlashCommand:   cfg.SlashCommand,
+		Placeholder:    cfg.Placeholder,
+		UpdateInterval: updateInterval,
+	}), nil
+}
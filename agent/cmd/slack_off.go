@@ -0,0 +1,14 @@
+//go:build noslack || minimal
+
+package main
+
+import (
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// newSlackLauncher returns no launcher: the slack command is left out of
+// this build
+func newSlackLauncher(*config.SlackConfig) (launcher.SubLauncher, error) {
+	return nil, nil
+}
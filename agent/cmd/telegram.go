@@ -0,0 +1,37 @@
+//go:build !notelegram && !minimal
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/telegram"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+func init() {
+	features = append(features, "telegram")
+}
+
+// newTelegramLauncher creates the telegram command running the agent as a
+// Telegram bot, which also speaks its replies with voice unless it is nil
+func newTelegramLauncher(cfg *config.TelegramConfig, voice *openai_compatible.Voice) (launcher.SubLauncher, error) {
+	updateInterval, err := cfg.GetUpdateInterval()
+	if err != nil {
+		return nil, fmt.Errorf("invalid telegram update interval: %w", err)
+	}
+	c := &telegram.Config{
+		Token:          cfg.Token,
+		WebhookURL:     cfg.WebhookURL,
+		Listen:         cfg.Listen,
+		WebhookSecret:  cfg.WebhookSecret,
+		AllowedChats:   cfg.AllowedChats,
+		UpdateInterval: updateInterval,
+	}
+	if voice != nil {
+		c.Speaker = voice
+	}
+	return telegram.NewLauncher(c), nil
+}
@@ -0,0 +1,15 @@
+//go:build notelegram || minimal
+
+package main
+
+import (
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// newTelegramLauncher returns no launcher: the telegram command is left out
+// of this build
+func newTelegramLauncher(*config.TelegramConfig, *openai_compatible.Voice) (launcher.SubLauncher, error) {
+	return nil, nil
+}
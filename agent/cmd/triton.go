@@ -0,0 +1,30 @@
+//go:build !notriton && !minimal
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/triton"
+	adkmodel "google.golang.org/adk/model"
+)
+
+func init() {
+	features = append(features, "triton")
+}
+
+// newTritonModel creates a model served by Triton Inference Server over gRPC
+func newTritonModel(ctx context.Context, cfg *config.ModelConfig, timeout time.Duration) (adkmodel.LLM, error) {
+	return triton.NewModel(ctx, &triton.Config{
+		Address:      cfg.BaseURL,
+		ModelName:    cfg.ModelName,
+		ModelVersion: cfg.Triton.ModelVersion,
+		Timeout:      timeout,
+		Backend:      cfg.Triton.Backend,
+		Template:     cfg.Triton.Template,
+		TLS:          cfg.Triton.TLS,
+		Headers:      cfg.Headers,
+	})
+}
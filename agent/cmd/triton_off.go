@@ -0,0 +1,16 @@
+//go:build notriton || minimal
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	adkmodel "google.golang.org/adk/model"
+)
+
+// newTritonModel fails: Triton support is left out of this build
+func newTritonModel(context.Context, *config.ModelConfig, time.Duration) (adkmodel.LLM, error) {
+	return nil, notBuilt("the triton provider", "notriton")
+}
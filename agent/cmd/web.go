@@ -0,0 +1,96 @@
+//go:build !noweb && !minimal
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+
+	"github.com/a2aproject/a2a-go/a2a"
+	"github.com/gopher-9527/yanshu/agent/pkg/admin"
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/server"
+	"github.com/gorilla/mux"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/cmd/launcher/web"
+	"google.golang.org/adk/cmd/launcher/web/api"
+	"google.golang.org/adk/cmd/launcher/web/webui"
+)
+
+func init() {
+	features = append(features, "web")
+}
+
+// newWebLauncher creates the web command serving the REST API, the web UI,
+// WebSocket and SSE chat and, when enabled, A2A, with the admin server
+// alongside and handlers on their own paths. The chat endpoints speak final
+// answers with voice unless it is nil, and start sessions only while
+// allowNewSession reports true.
+func newWebLauncher(cfg *config.ServerConfig, middlewares []func(http.Handler) http.Handler, adminServer *admin.Server, handlers map[string]http.Handler, voice *openai_compatible.Voice, allowNewSession func() bool) (launcher.SubLauncher, error) {
+	readTimeout, err := cfg.GetReadTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid server read timeout: %w", err)
+	}
+	writeTimeout, err := cfg.GetWriteTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid server write timeout: %w", err)
+	}
+	idleTimeout, err := cfg.GetIdleTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid server idle timeout: %w", err)
+	}
+	drainTimeout, err := cfg.GetDrainTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid server drain timeout: %w", err)
+	}
+	mws := make([]mux.MiddlewareFunc, len(middlewares))
+	for i, mw := range middlewares {
+		mws[i] = mw
+	}
+	wsCfg := &server.WebSocketConfig{AllowedOrigins: cfg.WebSocket.AllowedOrigins, AllowNewSession: allowNewSession}
+	sseCfg := &server.SSEConfig{AllowNewSession: allowNewSession}
+	if voice != nil {
+		wsCfg.Speaker, sseCfg.Speaker = voice, voice
+	}
+	sublaunchers := []web.Sublauncher{api.NewLauncher(), webui.NewLauncher(), server.NewWebSocketLauncher(wsCfg), server.NewSSELauncher(sseCfg)}
+	if cfg.A2A.Enabled {
+		sublaunchers = append(sublaunchers, server.NewA2ALauncher(newA2AConfig(cfg)))
+	}
+	return server.NewLauncher(&server.Config{
+		Port:         cfg.Port,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+		DrainTimeout: drainTimeout,
+		Middlewares:  mws,
+		Admin:        adminServer,
+		Handlers:     handlers,
+	}, sublaunchers...), nil
+}
+
+// newA2AConfig converts the A2A config to the agent card description,
+// advertising the server's port when no public URL is set
+func newA2AConfig(cfg *config.ServerConfig) *server.A2AConfig {
+	c := &server.A2AConfig{
+		URL:              cmp.Or(cfg.A2A.URL, fmt.Sprintf("http://localhost:%d", cfg.Port)),
+		Name:             cfg.A2A.Name,
+		Description:      cfg.A2A.Description,
+		Version:          cfg.A2A.Version,
+		DocumentationURL: cfg.A2A.DocumentationURL,
+	}
+	if p := cfg.A2A.Provider; p.Organization != "" || p.URL != "" {
+		c.Provider = &a2a.AgentProvider{Org: p.Organization, URL: p.URL}
+	}
+	for _, s := range cfg.A2A.Skills {
+		c.Skills = append(c.Skills, a2a.AgentSkill{
+			ID:          s.ID,
+			Name:        s.Name,
+			Description: s.Description,
+			Tags:        s.Tags,
+			Examples:    s.Examples,
+		})
+	}
+	return c
+}
@@ -0,0 +1,18 @@
+//go:build noweb || minimal
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/admin"
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// newWebLauncher returns no launcher: the web command, and the admin server
+// served alongside it, are left out of this build
+func newWebLauncher(*config.ServerConfig, []func(http.Handler) http.Handler, *admin.Server, map[string]http.Handler, *openai_compatible.Voice, func() bool) (launcher.SubLauncher, error) {
+	return nil, nil
+}
@@ -0,0 +1,37 @@
+//go:build !nosearch && !minimal
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"github.com/gopher-9527/yanshu/agent/pkg/websearch"
+	"google.golang.org/adk/tool"
+)
+
+func init() {
+	features = append(features, "web_search")
+}
+
+// newWebSearchTool returns the web_search tool and the name of its provider
+func newWebSearchTool(cfg *config.WebSearchConfig) (tool.Tool, string, error) {
+	timeout, err := cfg.GetTimeout()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid web search timeout: %w", err)
+	}
+	provider, err := websearch.New(&websearch.Config{
+		Provider: cfg.Provider,
+		APIKey:   cfg.APIKey,
+		BaseURL:  cfg.BaseURL,
+		Timeout:  timeout,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create web search provider: %w", err)
+	}
+	searchTool, err := websearch.NewTool(provider, cfg.MaxResults)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create web search tool: %w", err)
+	}
+	return searchTool, provider.Name(), nil
+}
@@ -0,0 +1,13 @@
+//go:build nosearch || minimal
+
+package main
+
+import (
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+	"google.golang.org/adk/tool"
+)
+
+// newWebSearchTool fails: web search is left out of this build
+func newWebSearchTool(*config.WebSearchConfig) (tool.Tool, string, error) {
+	return nil, "", notBuilt("web search", "nosearch")
+}
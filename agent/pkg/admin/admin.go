@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Config holds admin server configuration
+type Config struct {
+	Addr   string // Listen address, defaults to 127.0.0.1:6060
+	Token  string // Optional bearer token required on every request
+	Logger *slog.Logger
+
+	// Audit records every request that changes state, optional
+	Audit audit.Recorder
+}
+
+// ActorHeader names the operator making an admin request, recorded in the audit log
+const ActorHeader = "X-Admin-Actor"
+
+// Auditable is implemented by admin handlers whose state is recorded before
+// and after each change
+type Auditable interface {
+	AuditState() any
+}
+
+// Server is an admin-only HTTP server, separate from the public web server,
+// that exposes pprof and operational endpoints.
+type Server struct {
+	addr   string
+	token  string
+	mux    *http.ServeMux
+	logger *slog.Logger
+	audit  audit.Recorder
+}
+
+// NewServer creates a new admin server with pprof endpoints registered under /debug/pprof/
+func NewServer(cfg *Config) *Server {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	addr := cfg.Addr
+	if addr == "" {
+		addr = "127.0.0.1:6060"
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("admin")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return &Server{
+		addr:   addr,
+		token:  cfg.Token,
+		mux:    mux,
+		logger: logger,
+		audit:  cfg.Audit,
+	}
+}
+
+// Addr returns the listen address
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Handle registers an admin handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, s.audited(handler))
+}
+
+// HandleFunc registers an admin handler function for the given pattern
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.Handle(pattern, s.audited(http.HandlerFunc(handler)))
+}
+
+// audited wraps handler to record requests that may change state. Read-only
+// methods pass through unrecorded.
+func (s *Server) audited(handler http.Handler) http.Handler {
+	if s.audit == nil {
+		return handler
+	}
+	auditable, _ := handler.(Auditable)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		entry := audit.Entry{
+			Time:   time.Now(),
+			Actor:  cmp.Or(r.Header.Get(ActorHeader), "admin"),
+			Remote: r.RemoteAddr,
+			Action: r.Method,
+			Target: r.URL.Path,
+		}
+		if auditable != nil {
+			entry.Before = auditable.AuditState()
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(sw, r)
+
+		entry.Status = sw.status
+		if auditable != nil {
+			entry.After = auditable.AuditState()
+		}
+		if err := s.audit.Record(r.Context(), entry); err != nil {
+			s.logger.Error("Failed to record admin action", "action", entry.Action, "target", entry.Target, "error", err)
+		}
+	})
+}
+
+// statusWriter captures the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ServeHTTP implements http.Handler, enforcing the bearer token when configured
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		want := "Bearer " + s.token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves admin requests until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.Info("Starting admin server", "addr", s.addr, "auth", s.token != "")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("admin server failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+)
+
+// memoryRecorder keeps audit entries in memory
+type memoryRecorder struct {
+	entries []audit.Entry
+}
+
+func (r *memoryRecorder) Record(_ context.Context, e audit.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+// setting is an auditable admin handler holding a single value
+type setting struct {
+	value string
+}
+
+func (s *setting) AuditState() any { return s.value }
+
+func (s *setting) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		v := r.URL.Query().Get("value")
+		if v == "" {
+			http.Error(w, "value is required", http.StatusBadRequest)
+			return
+		}
+		s.value = v
+	}
+	w.Write([]byte(s.value))
+}
+
+// TestServerAudit tests recording changes with their before and after values
+func TestServerAudit(t *testing.T) {
+	recorder := &memoryRecorder{}
+	s := NewServer(&Config{Token: "secret", Audit: recorder})
+	s.Handle("/setting", &setting{value: "a"})
+
+	do := func(method, target, actor string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if actor != "" {
+			req.Header.Set(ActorHeader, actor)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	do(http.MethodGet, "/setting", "")
+	do(http.MethodPut, "/setting?value=b", "alice")
+	do(http.MethodPut, "/setting", "")
+
+	if len(recorder.entries) != 2 {
+		t.Fatalf("recorded %d entries, want 2 (reads are not audited)", len(recorder.entries))
+	}
+	e := recorder.entries[0]
+	if e.Actor != "alice" || e.Action != "PUT" || e.Target != "/setting" || e.Before != "a" || e.After != "b" || e.Status != http.StatusOK {
+		t.Errorf("change entry = %+v", e)
+	}
+	e = recorder.entries[1]
+	if e.Actor != "admin" || e.Status != http.StatusBadRequest || e.Before != "b" || e.After != "b" {
+		t.Errorf("rejected change entry = %+v", e)
+	}
+
+	// Unauthorized requests never reach the handler
+	req := httptest.NewRequest(http.MethodPut, "/setting?value=c", strings.NewReader(""))
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if len(recorder.entries) != 2 {
+		t.Errorf("unauthorized request was recorded")
+	}
+}
@@ -0,0 +1,267 @@
+// Package apikey authenticates server requests with API keys and enforces
+// per-key quotas: a request rate and a daily token budget. Requests are
+// attributed to their key, so usage records and reports break down by key.
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+)
+
+// Key is an API key and its quotas
+type Key struct {
+	Name string
+	// Key is the secret, or SHA256 its hex-encoded SHA-256 digest, so key
+	// files need not hold secrets
+	Key    string
+	SHA256 string
+	// RequestsPerMinute limits the request rate, 0 for no limit
+	RequestsPerMinute int
+	// TokensPerDay limits the tokens of the model calls made with the key
+	// per UTC day, 0 for no limit
+	TokensPerDay int64
+}
+
+// Config holds the keys
+type Config struct {
+	Keys []Key
+	// Usage is the store usage records are written to. The authenticator
+	// wraps it to count tokens; it is required for token quotas.
+	Usage  usage.Store
+	Logger *slog.Logger
+}
+
+// Auth authenticates requests and enforces the quotas of their keys. It is
+// a usage.Store decorator, counting the tokens of each key as records are
+// written.
+type Auth struct {
+	byDigest map[string]*state
+	byName   map[string]*state
+	states   []*state
+	store    usage.Store
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+// state is a key with its quota usage
+type state struct {
+	key Key
+
+	mu     sync.Mutex
+	tokens float64   // Request tokens left in the bucket
+	filled time.Time // When tokens was last refilled
+	day    string    // UTC day of used
+	used   int64     // Model tokens used on day
+}
+
+// New creates an authenticator and loads today's token usage per key from
+// the usage store
+func New(ctx context.Context, cfg *Config) (*Auth, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+	a := &Auth{
+		byDigest: make(map[string]*state, len(cfg.Keys)),
+		byName:   make(map[string]*state, len(cfg.Keys)),
+		store:    cfg.Usage,
+		logger:   cfg.Logger,
+		now:      time.Now,
+	}
+	if a.logger == nil {
+		a.logger = logging.Component("apikey")
+	}
+
+	for _, k := range cfg.Keys {
+		if _, ok := a.byName[k.Name]; k.Name == "" || ok {
+			return nil, fmt.Errorf("keys need distinct names, got %q", k.Name)
+		}
+		digest, err := keyDigest(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", k.Name, err)
+		}
+		if _, ok := a.byDigest[digest]; ok {
+			return nil, fmt.Errorf("key %s duplicates another key", k.Name)
+		}
+		if k.RequestsPerMinute < 0 || k.TokensPerDay < 0 {
+			return nil, fmt.Errorf("quotas of key %s cannot be negative", k.Name)
+		}
+		if k.TokensPerDay > 0 && cfg.Usage == nil {
+			return nil, fmt.Errorf("key %s has a token quota, which requires usage recording", k.Name)
+		}
+		s := &state{key: k, tokens: float64(k.RequestsPerMinute)}
+		a.byDigest[digest] = s
+		a.byName[k.Name] = s
+		a.states = append(a.states, s)
+	}
+
+	if a.store != nil {
+		now := a.now().UTC()
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		records, err := a.store.List(ctx, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load today's usage: %w", err)
+		}
+		for _, r := range records {
+			a.count(r)
+		}
+	}
+	return a, nil
+}
+
+// keyDigest returns the hex SHA-256 digest of a key
+func keyDigest(k Key) (string, error) {
+	switch {
+	case k.Key != "" && k.SHA256 != "":
+		return "", fmt.Errorf("set either the key or its SHA-256 digest")
+	case k.Key != "":
+		sum := sha256.Sum256([]byte(k.Key))
+		return hex.EncodeToString(sum[:]), nil
+	case len(k.SHA256) == sha256.Size*2:
+		if _, err := hex.DecodeString(k.SHA256); err == nil {
+			return strings.ToLower(k.SHA256), nil
+		}
+	}
+	return "", fmt.Errorf("a key or a hex-encoded SHA-256 digest is required")
+}
+
+// Middleware rejects requests without a known key with 401 and requests
+// beyond the quotas of their key with 429. It accepts the key as a bearer
+// token or in the X-API-Key header.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		presented := req.Header.Get("X-API-Key")
+		if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok && presented == "" {
+			presented = strings.TrimSpace(token)
+		}
+		sum := sha256.Sum256([]byte(presented))
+		s := a.byDigest[hex.EncodeToString(sum[:])]
+		if presented == "" || s == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="yanshu"`)
+			writeError(w, http.StatusUnauthorized, "a valid API key is required")
+			return
+		}
+
+		if wait, reason := s.admit(a.now()); wait > 0 {
+			a.logger.Warn("API key over quota", "key", s.key.Name, "quota", reason, "retry_after", wait)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("API key %s exceeded its %s", s.key.Name, reason))
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(usage.WithKey(req.Context(), s.key.Name)))
+	})
+}
+
+// admit takes a request from the key's bucket. It returns how long to wait
+// and the quota exceeded when the request is over a quota.
+func (s *state) admit(now time.Time) (time.Duration, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := now.UTC().Format(time.DateOnly)
+	if s.day != day {
+		s.day, s.used = day, 0
+	}
+	if s.key.TokensPerDay > 0 && s.used >= s.key.TokensPerDay {
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return tomorrow.Sub(now), "daily token quota"
+	}
+
+	rpm := float64(s.key.RequestsPerMinute)
+	if rpm == 0 {
+		return 0, ""
+	}
+	// Token bucket holding up to a minute of requests, refilled continuously
+	if !s.filled.IsZero() {
+		s.tokens = min(rpm, s.tokens+now.Sub(s.filled).Minutes()*rpm)
+	}
+	s.filled = now
+	if s.tokens < 1 {
+		return time.Duration((1 - s.tokens) / rpm * float64(time.Minute)), "request rate"
+	}
+	s.tokens--
+	return 0, ""
+}
+
+// count adds the tokens of a record to its key
+func (a *Auth) count(r usage.Record) {
+	s, ok := a.byName[r.Key]
+	if !ok {
+		return
+	}
+	day := r.Time.UTC().Format(time.DateOnly)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if day > s.day {
+		s.day, s.used = day, 0
+	}
+	if day == s.day {
+		s.used += r.TotalTokens
+	}
+}
+
+// Append implements usage.Store, counting the record's tokens against its key
+func (a *Auth) Append(ctx context.Context, r usage.Record) error {
+	a.count(r)
+	return a.store.Append(ctx, r)
+}
+
+// List implements usage.Store
+func (a *Auth) List(ctx context.Context, since time.Time) ([]usage.Record, error) {
+	return a.store.List(ctx, since)
+}
+
+// KeyStatus is the quota usage of a key
+type KeyStatus struct {
+	Name              string `json:"name"`
+	RequestsPerMinute int    `json:"requests_per_minute,omitempty"`
+	TokensPerDay      int64  `json:"tokens_per_day,omitempty"`
+	TokensToday       int64  `json:"tokens_today"`
+}
+
+// Status returns the quota usage of the keys, in configuration order
+func (a *Auth) Status() []KeyStatus {
+	today := a.now().UTC().Format(time.DateOnly)
+	statuses := make([]KeyStatus, 0, len(a.states))
+	for _, s := range a.states {
+		s.mu.Lock()
+		st := KeyStatus{Name: s.key.Name, RequestsPerMinute: s.key.RequestsPerMinute, TokensPerDay: s.key.TokensPerDay}
+		if s.day == today {
+			st.TokensToday = s.used
+		}
+		s.mu.Unlock()
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// ServeHTTP lists the keys with their quota usage, for the admin server
+func (a *Auth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Status())
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,126 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+)
+
+// memStore is an in-memory usage store
+type memStore struct {
+	records []usage.Record
+}
+
+func (s *memStore) Append(_ context.Context, r usage.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memStore) List(_ context.Context, since time.Time) ([]usage.Record, error) {
+	var out []usage.Record
+	for _, r := range s.records {
+		if !r.Time.Before(since) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// TestAuth tests authentication, the request rate and the daily token quota
+func TestAuth(t *testing.T) {
+	now := time.Now().UTC()
+	store := &memStore{records: []usage.Record{
+		{Time: now.Add(-48 * time.Hour), Key: "ci", TotalTokens: 900},
+		{Time: now, Key: "ci", TotalTokens: 60},
+	}}
+	digest := sha256.Sum256([]byte("sk-ci"))
+	a, err := New(context.Background(), &Config{
+		Keys: []Key{
+			{Name: "app", Key: "sk-app", RequestsPerMinute: 2},
+			{Name: "ci", SHA256: hex.EncodeToString(digest[:]), TokensPerDay: 100},
+		},
+		Usage: store,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clock := now
+	a.now = func() time.Time { return clock }
+
+	var seen string
+	h := a.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		seen = usage.KeyFromContext(req.Context())
+	}))
+	do := func(header, value string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/list-apps", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct{ header, value string }{{"", ""}, {"Authorization", "Bearer sk-other"}, {"Authorization", "sk-app"}} {
+		if rec := do(tc.header, tc.value); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %q = %d, want 401", tc.header, tc.value, rec.Code)
+		}
+	}
+
+	// Two requests a minute: the third waits for the bucket to refill
+	for i := range 2 {
+		if rec := do("Authorization", "Bearer sk-app"); rec.Code != http.StatusOK || seen != "app" {
+			t.Fatalf("request %d = %d attributed to %q", i, rec.Code, seen)
+		}
+	}
+	if rec := do("X-API-Key", "sk-app"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("over rate = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	clock = clock.Add(30 * time.Second)
+	if rec := do("X-API-Key", "sk-app"); rec.Code != http.StatusOK {
+		t.Errorf("after refill = %d", rec.Code)
+	}
+
+	// Today's usage was loaded; records written through the store count too
+	if rec := do("X-API-Key", "sk-ci"); rec.Code != http.StatusOK || seen != "ci" {
+		t.Errorf("under token quota = %d", rec.Code)
+	}
+	a.Append(context.Background(), usage.Record{Time: clock, Key: "ci", TotalTokens: 40})
+	if len(store.records) != 3 {
+		t.Errorf("Append() did not write to the store")
+	}
+	if rec := do("X-API-Key", "sk-ci"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over token quota = %d", rec.Code)
+	}
+	if status := a.Status(); status[1].TokensToday != 100 || status[0].TokensToday != 0 {
+		t.Errorf("Status() = %+v", status)
+	}
+	clock = clock.Add(24 * time.Hour)
+	if rec := do("X-API-Key", "sk-ci"); rec.Code != http.StatusOK {
+		t.Errorf("next day = %d", rec.Code)
+	}
+}
+
+// TestNew tests rejecting invalid keys
+func TestNew(t *testing.T) {
+	for _, keys := range [][]Key{
+		nil,
+		{{Key: "sk"}},
+		{{Name: "a"}},
+		{{Name: "a", SHA256: "not hex"}},
+		{{Name: "a", Key: "sk", SHA256: "00"}},
+		{{Name: "a", Key: "sk"}, {Name: "a", Key: "sk2"}},
+		{{Name: "a", Key: "sk"}, {Name: "b", Key: "sk"}},
+		{{Name: "a", Key: "sk", TokensPerDay: 10}}, // Without a usage store
+	} {
+		if _, err := New(context.Background(), &Config{Keys: keys}); err == nil {
+			t.Errorf("New(%+v) succeeded", keys)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/session"
+)
+
+// DefaultInterval is how often idle sessions are looked for by default
+const DefaultInterval = 5 * time.Minute
+
+// Config configures archival
+type Config struct {
+	// Sessions is the hot store, required
+	Sessions session.Service
+	// Store is the cold storage, required
+	Store Store
+	// IdleTTL is how long a session stays in the hot store after its last
+	// use, required
+	IdleTTL time.Duration
+	// Interval is how often Run looks for idle sessions, defaults to DefaultInterval
+	Interval time.Duration
+	Logger   *slog.Logger
+}
+
+// transcript is the archived form of a session, stored gzipped JSON
+type transcript struct {
+	AppName    string           `json:"app_name"`
+	UserID     string           `json:"user_id"`
+	ID         string           `json:"id"`
+	State      map[string]any   `json:"state"` // Session-scoped state only
+	Events     []*session.Event `json:"events"`
+	LastUpdate time.Time        `json:"last_update"`
+	Archived   time.Time        `json:"archived"`
+}
+
+// ref identifies a session
+type ref struct {
+	appName, userID, sessionID string
+}
+
+// Service is a session.Service that moves sessions idle for longer than a
+// TTL to cold storage and restores them when they are used again. Sessions
+// it has not seen in the hot store, such as after a restart, are looked up
+// in cold storage. List only returns sessions in the hot store.
+type Service struct {
+	session.Service
+	store    Store
+	ttl      time.Duration
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu  sync.Mutex
+	hot map[ref]time.Time // Last use of the sessions in the hot store
+}
+
+// New wraps the hot store of cfg
+func New(cfg *Config) (*Service, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Sessions == nil || cfg.Store == nil {
+		return nil, fmt.Errorf("session service and archive store are required")
+	}
+	if cfg.IdleTTL <= 0 {
+		return nil, fmt.Errorf("idle TTL must be positive")
+	}
+	s := &Service{
+		Service:  cfg.Sessions,
+		store:    cfg.Store,
+		ttl:      cfg.IdleTTL,
+		interval: cfg.Interval,
+		logger:   cfg.Logger,
+		hot:      make(map[ref]time.Time),
+	}
+	if s.interval <= 0 {
+		s.interval = DefaultInterval
+	}
+	if s.logger == nil {
+		s.logger = logging.Component("archive")
+	}
+	return s, nil
+}
+
+// Create implements session.Service
+func (s *Service) Create(ctx context.Context, req *session.CreateRequest) (*session.CreateResponse, error) {
+	resp, err := s.Service.Create(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hot[ref{req.AppName, req.UserID, resp.Session.ID()}] = time.Now()
+	return resp, nil
+}
+
+// Get implements session.Service, restoring an archived session
+func (s *Service) Get(ctx context.Context, req *session.GetRequest) (*session.GetResponse, error) {
+	r := ref{req.AppName, req.UserID, req.SessionID}
+	resp, err := s.Service.Get(ctx, req)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Not hot: missing, or archived since it was read
+	if _, ok := s.hot[r]; err != nil || !ok {
+		switch restoreErr := s.restore(ctx, r); {
+		case errors.Is(restoreErr, ErrNotFound) && err != nil:
+			return nil, err
+		case restoreErr != nil && !errors.Is(restoreErr, ErrNotFound):
+			return nil, restoreErr
+		case restoreErr == nil:
+			if resp, err = s.Service.Get(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.hot[r] = time.Now()
+	return resp, nil
+}
+
+// AppendEvent implements session.Service, restoring the session first when
+// it was archived since the caller got it
+func (s *Service) AppendEvent(ctx context.Context, sess session.Session, event *session.Event) error {
+	r := ref{sess.AppName(), sess.UserID(), sess.ID()}
+	s.mu.Lock()
+	if _, ok := s.hot[r]; !ok {
+		if err := s.restore(ctx, r); err != nil && !errors.Is(err, ErrNotFound) {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	s.hot[r] = time.Now()
+	s.mu.Unlock()
+	return s.Service.AppendEvent(ctx, sess, event)
+}
+
+// Delete implements session.Service, deleting the archived copy too
+func (s *Service) Delete(ctx context.Context, req *session.DeleteRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Service.Delete(ctx, req); err != nil {
+		return err
+	}
+	delete(s.hot, ref{req.AppName, req.UserID, req.SessionID})
+	return s.store.Delete(ctx, req.AppName, req.UserID, req.SessionID)
+}
+
+// Run archives idle sessions until ctx is cancelled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx, time.Now())
+		}
+	}
+}
+
+// Sweep archives the sessions unused for longer than the TTL at now and
+// returns how many it archived. Failures are logged and the session stays.
+func (s *Service) Sweep(ctx context.Context, now time.Time) int {
+	s.mu.Lock()
+	var idle []ref
+	for r, used := range s.hot {
+		if now.Sub(used) > s.ttl {
+			idle = append(idle, r)
+		}
+	}
+	s.mu.Unlock()
+
+	archived := 0
+	for _, r := range idle {
+		s.mu.Lock()
+		// Used meanwhile, or deleted
+		if used, ok := s.hot[r]; ok && now.Sub(used) > s.ttl {
+			if err := s.archive(ctx, r); err != nil {
+				s.logger.Error("Failed to archive session", "session", r.sessionID, "error", err)
+			} else {
+				archived++
+			}
+		}
+		s.mu.Unlock()
+	}
+	if archived > 0 {
+		s.mu.Lock()
+		hot := len(s.hot)
+		s.mu.Unlock()
+		s.logger.Info("Archived idle sessions", "sessions", archived, "hot", hot)
+	}
+	return archived
+}
+
+// Flush archives every session in memory, so they survive a shutdown, and
+// returns how many it archived. Failures are logged and the session stays.
+func (s *Service) Flush(ctx context.Context) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	archived := 0
+	for r := range s.hot {
+		if err := s.archive(ctx, r); err != nil {
+			s.logger.Error("Failed to archive session", "session", r.sessionID, "error", err)
+			continue
+		}
+		archived++
+	}
+	s.logger.Info("Archived sessions in memory", "sessions", archived, "failed", len(s.hot))
+	return archived
+}
+
+// archive moves a session to cold storage. s.mu must be held.
+func (s *Service) archive(ctx context.Context, r ref) error {
+	resp, err := s.Service.Get(ctx, &session.GetRequest{AppName: r.appName, UserID: r.userID, SessionID: r.sessionID})
+	if err != nil {
+		delete(s.hot, r) // Gone from the hot store
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	t := transcript{
+		AppName:    r.appName,
+		UserID:     r.userID,
+		ID:         r.sessionID,
+		State:      sessionState(resp.Session.State().All()),
+		Events:     []*session.Event{},
+		LastUpdate: resp.Session.LastUpdateTime(),
+		Archived:   time.Now().UTC(),
+	}
+	for event := range resp.Session.Events().All() {
+		t.Events = append(t.Events, event)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(t); err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress session: %w", err)
+	}
+	if err := s.store.Put(ctx, r.appName, r.userID, r.sessionID, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := s.Service.Delete(ctx, &session.DeleteRequest{AppName: r.appName, UserID: r.userID, SessionID: r.sessionID}); err != nil {
+		return fmt.Errorf("failed to delete archived session: %w", err)
+	}
+	delete(s.hot, r)
+	s.logger.Debug("Session archived", "session", r.sessionID, "events", len(t.Events), "bytes", buf.Len())
+	return nil
+}
+
+// Restore moves an archived session back to the hot store; it returns
+// ErrNotFound when the session was not archived
+func (s *Service) Restore(ctx context.Context, appName, userID, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restore(ctx, ref{appName, userID, sessionID})
+}
+
+// restore moves a session back from cold storage. s.mu must be held.
+func (s *Service) restore(ctx context.Context, r ref) error {
+	data, err := s.store.Get(ctx, r.appName, r.userID, r.sessionID)
+	if err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decompress archived session: %w", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("failed to decompress archived session: %w", err)
+	}
+	var t transcript
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return fmt.Errorf("failed to decode archived session: %w", err)
+	}
+
+	created, err := s.Service.Create(ctx, &session.CreateRequest{
+		AppName:   r.appName,
+		UserID:    r.userID,
+		SessionID: r.sessionID,
+		State:     t.State,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore session: %w", err)
+	}
+	// The state is restored as a whole; the deltas of app and user state,
+	// which may have changed since, are not replayed
+	for _, event := range t.Events {
+		event.Actions.StateDelta = sessionState(maps.All(event.Actions.StateDelta))
+		if err := s.Service.AppendEvent(ctx, created.Session, event); err != nil {
+			return fmt.Errorf("failed to restore session history: %w", err)
+		}
+	}
+	if err := s.store.Delete(ctx, r.appName, r.userID, r.sessionID); err != nil {
+		s.logger.Warn("Failed to delete restored session from the archive", "session", r.sessionID, "error", err)
+	}
+	s.hot[r] = time.Now()
+	s.logger.Info("Session restored from the archive", "session", r.sessionID, "events", len(t.Events), "archived", t.Archived)
+	return nil
+}
+
+// sessionState copies the session-scoped entries of a state, leaving out app,
+// user and temporary keys
+func sessionState(all func(yield func(string, any) bool)) map[string]any {
+	state := make(map[string]any)
+	for k, v := range all {
+		if strings.HasPrefix(k, session.KeyPrefixApp) || strings.HasPrefix(k, session.KeyPrefixUser) || strings.HasPrefix(k, session.KeyPrefixTemp) {
+			continue
+		}
+		state[k] = v
+	}
+	return state
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func appendText(t *testing.T, s session.Service, sess session.Session, text string, delta map[string]any) {
+	t.Helper()
+	event := session.NewEvent("inv")
+	event.Author = "user"
+	event.Content = genai.NewContentFromText(text, genai.RoleUser)
+	event.Actions.StateDelta = delta
+	if err := s.AppendEvent(context.Background(), sess, event); err != nil {
+		t.Fatalf("AppendEvent() error = %v", err)
+	}
+}
+
+// TestArchive tests archiving idle sessions and restoring them on use
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot := session.InMemoryService()
+	s, err := New(&Config{Sessions: hot, Store: store, IdleTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := s.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "../u", SessionID: "s1", State: map[string]any{"mode": "plan"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendText(t, s, created.Session, "hello", map[string]any{"step": "one", "app:shared": "x"})
+	other, err := s.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "../u", SessionID: "s2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.Sweep(ctx, time.Now()); n != 0 {
+		t.Errorf("Sweep(now) archived %d sessions, want 0", n)
+	}
+	if _, err := s.Get(ctx, &session.GetRequest{AppName: "app", UserID: "../u", SessionID: "s2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Sweep(ctx, time.Now().Add(2*time.Hour)); n != 2 {
+		t.Fatalf("Sweep(later) archived %d sessions, want 2", n)
+	}
+	if _, err := hot.Get(ctx, &session.GetRequest{AppName: "app", UserID: "../u", SessionID: "s1"}); err == nil {
+		t.Error("archived session is still in the hot store")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app", "%2E%2E%2Fu", "s1.json.gz")); err != nil {
+		t.Errorf("archive file: %v", err)
+	}
+
+	// Get restores the history and the session state
+	resp, err := s.Get(ctx, &session.GetRequest{AppName: "app", UserID: "../u", SessionID: "s1"})
+	if err != nil {
+		t.Fatalf("Get(archived) error = %v", err)
+	}
+	if n := resp.Session.Events().Len(); n != 1 || resp.Session.Events().At(0).Content.Parts[0].Text != "hello" {
+		t.Errorf("restored %d events", n)
+	}
+	for key, want := range map[string]any{"mode": "plan", "step": "one", "app:shared": "x"} {
+		if got, _ := resp.Session.State().Get(key); got != want {
+			t.Errorf("state %s = %v, want %v", key, got, want)
+		}
+	}
+	if _, err := store.Get(ctx, "app", "../u", "s1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restored session is still archived: %v", err)
+	}
+
+	// Appending to a session archived since it was read restores it first
+	appendText(t, s, other.Session, "late", nil)
+	resp, err = hot.Get(ctx, &session.GetRequest{AppName: "app", UserID: "../u", SessionID: "s2"})
+	if err != nil || resp.Session.Events().Len() != 1 {
+		t.Errorf("AppendEvent(archived) = %v", err)
+	}
+
+	// A new service finds the sessions flushed at shutdown
+	if n := s.Flush(ctx); n != 2 {
+		t.Errorf("Flush() archived %d sessions, want 2", n)
+	}
+	restarted, err := New(&Config{Sessions: session.InMemoryService(), Store: store, IdleTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Get(ctx, &session.GetRequest{AppName: "app", UserID: "../u", SessionID: "s1"}); err != nil {
+		t.Errorf("Get() after restart error = %v", err)
+	}
+	if _, err := restarted.Get(ctx, &session.GetRequest{AppName: "app", UserID: "../u", SessionID: "missing"}); err == nil {
+		t.Error("Get(missing) succeeded")
+	}
+
+	if err := restarted.Delete(ctx, &session.DeleteRequest{AppName: "app", UserID: "../u", SessionID: "s2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "app", "../u", "s2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted session is still archived: %v", err)
+	}
+}
+
+// TestSealedStore tests encrypting archives per tenant
+func TestSealedStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := envelope.NewLocalKeys(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := envelope.New(&envelope.Config{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := NewSealedStore(store, sealer)
+
+	if err := sealed.Put(ctx, "app", "alice", "s1", []byte("history")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	raw, err := store.Get(ctx, "app", "alice", "s1")
+	if err != nil || !envelope.Sealed(raw) || bytes.Contains(raw, []byte("history")) {
+		t.Fatalf("stored archive = %q, %v, want it encrypted", raw, err)
+	}
+	if got, err := sealed.Get(ctx, "app", "alice", "s1"); err != nil || string(got) != "history" {
+		t.Errorf("Get() = %q, %v", got, err)
+	}
+
+	// Another user's archive copied in place of bob's does not open
+	if err := store.Put(ctx, "app", "bob", "s1", raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sealed.Get(ctx, "app", "bob", "s1"); err == nil {
+		t.Error("Get() opened another tenant's archive")
+	}
+	if _, err := sealed.Get(ctx, "app", "bob", "s2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+)
+
+// ErrNotFound is returned by Store.Get for sessions that were not archived
+var ErrNotFound = errors.New("session is not archived")
+
+// Store is the cold storage of archived sessions
+type Store interface {
+	Put(ctx context.Context, appName, userID, sessionID string, data []byte) error
+	// Get returns ErrNotFound for sessions that were not archived
+	Get(ctx context.Context, appName, userID, sessionID string) ([]byte, error)
+	Delete(ctx context.Context, appName, userID, sessionID string) error
+}
+
+// DirStore keeps archived sessions as files below a directory, one
+// directory per app and user
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore creates the directory of a store
+func NewDirStore(dir string) (*DirStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("archive directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// path returns the file of a session. Names are escaped, dots included, so
+// they cannot leave the directory.
+func (s *DirStore) path(appName, userID, sessionID string) string {
+	escape := func(name string) string {
+		return strings.ReplaceAll(url.PathEscape(name), ".", "%2E")
+	}
+	return filepath.Join(s.dir, escape(appName), escape(userID), escape(sessionID)+".json.gz")
+}
+
+// Put implements Store, replacing the file atomically
+func (s *DirStore) Put(_ context.Context, appName, userID, sessionID string, data []byte) error {
+	path := s.path(appName, userID, sessionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *DirStore) Get(_ context.Context, appName, userID, sessionID string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(appName, userID, sessionID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive file: %w", err)
+	}
+	return data, nil
+}
+
+// Delete implements Store
+func (s *DirStore) Delete(_ context.Context, appName, userID, sessionID string) error {
+	err := os.Remove(s.path(appName, userID, sessionID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete archive file: %w", err)
+	}
+	return nil
+}
+
+// SealedStore encrypts the archives of a Store with the key of their tenant
+type SealedStore struct {
+	Store
+	sealer *envelope.Sealer
+}
+
+// NewSealedStore wraps store
+func NewSealedStore(store Store, sealer *envelope.Sealer) *SealedStore {
+	return &SealedStore{Store: store, sealer: sealer}
+}
+
+// Put implements Store
+func (s *SealedStore) Put(ctx context.Context, appName, userID, sessionID string, data []byte) error {
+	sealed, err := s.sealer.Seal(ctx, s.sealer.Tenant(appName, userID), data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt archived session: %w", err)
+	}
+	return s.Store.Put(ctx, appName, userID, sessionID, sealed)
+}
+
+// Get implements Store. Archives written before encryption was enabled are
+// read as they are.
+func (s *SealedStore) Get(ctx context.Context, appName, userID, sessionID string) ([]byte, error) {
+	data, err := s.Store.Get(ctx, appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := s.sealer.Open(ctx, s.sealer.Tenant(appName, userID), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archived session: %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,225 @@
+// Package artifacts stores the artifacts of sessions, the files tools and
+// the model save and load by name (generated images, transcripts,
+// downloaded documents), in a local directory or an S3-compatible bucket.
+// Service implements ADK's artifact.Service, so the REST API serves the
+// artifacts too.
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/adk/artifact"
+	"google.golang.org/genai"
+)
+
+// ErrNotFound is returned by Blobs.Get for keys that do not exist
+var ErrNotFound = errors.New("blob not found")
+
+// Blobs is the storage backend of a Service, keeping data by key. Keys are
+// slash-separated paths.
+type Blobs interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns ErrNotFound for keys that do not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete deletes a key; deleting a key that does not exist is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the keys starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// userScope is the session segment of the keys of user-scoped artifacts,
+// whose names start with "user:" and which every session of the user sees
+const userScope = "user"
+
+// Service is an artifact.Service keeping each version of an artifact as a
+// blob at <app>/<user>/<session>/<file>/<version>.json
+type Service struct {
+	blobs Blobs
+
+	mu sync.Mutex // Serializes saves, which pick the next version
+}
+
+// NewService creates an artifact service on blobs
+func NewService(blobs Blobs) (*Service, error) {
+	if blobs == nil {
+		return nil, fmt.Errorf("blobs are required")
+	}
+	return &Service{blobs: blobs}, nil
+}
+
+var _ artifact.Service = (*Service)(nil)
+
+// escape escapes a key segment, dots included, so names cannot leave their
+// directory or contain slashes
+func escape(name string) string {
+	return strings.ReplaceAll(url.PathEscape(name), ".", "%2E")
+}
+
+// sessionPrefix returns the prefix of the keys of a session's artifacts,
+// or of the user's when sessionID is userScope
+func sessionPrefix(appName, userID, sessionID string) string {
+	return escape(appName) + "/" + escape(userID) + "/" + escape(sessionID) + "/"
+}
+
+// filePrefix returns the prefix of the keys of an artifact's versions
+func filePrefix(appName, userID, sessionID, fileName string) string {
+	if strings.HasPrefix(fileName, "user:") {
+		sessionID = userScope
+	}
+	return sessionPrefix(appName, userID, sessionID) + escape(fileName) + "/"
+}
+
+// versions returns the versions of an artifact, oldest first
+func (s *Service) versions(ctx context.Context, prefix string) ([]int64, error) {
+	keys, err := s.blobs.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for _, key := range keys {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(key, prefix), ".json")
+		if !ok || strings.Contains(name, "/") {
+			continue
+		}
+		if v, err := strconv.ParseInt(name, 10, 64); err == nil && v > 0 {
+			versions = append(versions, v)
+		}
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
+
+// Save implements artifact.Service. Versions start at 1.
+func (s *Service) Save(ctx context.Context, req *artifact.SaveRequest) (*artifact.SaveResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("request validation failed: %w", err)
+	}
+	data, err := json.Marshal(req.Part)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode artifact: %w", err)
+	}
+	prefix := filePrefix(req.AppName, req.UserID, req.SessionID, req.FileName)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version := req.Version
+	if version <= 0 {
+		versions, err := s.versions(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list artifact versions: %w", err)
+		}
+		version = 1
+		if len(versions) > 0 {
+			version = versions[len(versions)-1] + 1
+		}
+	}
+	if err := s.blobs.Put(ctx, prefix+strconv.FormatInt(version, 10)+".json", data); err != nil {
+		return nil, fmt.Errorf("failed to save artifact: %w", err)
+	}
+	return &artifact.SaveResponse{Version: version}, nil
+}
+
+// Load implements artifact.Service, loading the latest version unless one
+// is requested
+func (s *Service) Load(ctx context.Context, req *artifact.LoadRequest) (*artifact.LoadResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("request validation failed: %w", err)
+	}
+	prefix := filePrefix(req.AppName, req.UserID, req.SessionID, req.FileName)
+	version := req.Version
+	if version <= 0 {
+		versions, err := s.versions(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list artifact versions: %w", err)
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("artifact not found: %w", fs.ErrNotExist)
+		}
+		version = versions[len(versions)-1]
+	}
+	data, err := s.blobs.Get(ctx, prefix+strconv.FormatInt(version, 10)+".json")
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("artifact not found: %w", fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load artifact: %w", err)
+	}
+	var part genai.Part
+	if err := json.Unmarshal(data, &part); err != nil {
+		return nil, fmt.Errorf("failed to decode artifact: %w", err)
+	}
+	return &artifact.LoadResponse{Part: &part}, nil
+}
+
+// Delete implements artifact.Service, deleting every version unless one is
+// requested
+func (s *Service) Delete(ctx context.Context, req *artifact.DeleteRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("request validation failed: %w", err)
+	}
+	prefix := filePrefix(req.AppName, req.UserID, req.SessionID, req.FileName)
+	versions := []int64{req.Version}
+	if req.Version <= 0 {
+		var err error
+		if versions, err = s.versions(ctx, prefix); err != nil {
+			return fmt.Errorf("failed to list artifact versions: %w", err)
+		}
+	}
+	for _, v := range versions {
+		if err := s.blobs.Delete(ctx, prefix+strconv.FormatInt(v, 10)+".json"); err != nil {
+			return fmt.Errorf("failed to delete artifact: %w", err)
+		}
+	}
+	return nil
+}
+
+// List implements artifact.Service, listing the session's artifacts and
+// the user's
+func (s *Service) List(ctx context.Context, req *artifact.ListRequest) (*artifact.ListResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("request validation failed: %w", err)
+	}
+	var names []string
+	for _, sessionID := range []string{req.SessionID, userScope} {
+		prefix := sessionPrefix(req.AppName, req.UserID, sessionID)
+		keys, err := s.blobs.List(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list artifacts: %w", err)
+		}
+		for _, key := range keys {
+			file, _, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+			if !ok {
+				continue
+			}
+			if name, err := url.PathUnescape(file); err == nil && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return &artifact.ListResponse{FileNames: names}, nil
+}
+
+// Versions implements artifact.Service
+func (s *Service) Versions(ctx context.Context, req *artifact.VersionsRequest) (*artifact.VersionsResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("request validation failed: %w", err)
+	}
+	versions, err := s.versions(ctx, filePrefix(req.AppName, req.UserID, req.SessionID, req.FileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list artifact versions: %w", err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("artifact not found: %w", fs.ErrNotExist)
+	}
+	return &artifact.VersionsResponse{Versions: versions}, nil
+}
@@ -0,0 +1,130 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DirBlobs keeps blobs as files below a directory, a key's slashes
+// separating directories
+type DirBlobs struct {
+	dir string
+}
+
+// NewDirBlobs creates the directory of a store
+func NewDirBlobs(dir string) (*DirBlobs, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("artifact directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	return &DirBlobs{dir: dir}, nil
+}
+
+// path returns the file of a key, refusing keys that would leave the directory
+func (b *DirBlobs) path(key string) (string, error) {
+	if key == "" || path.IsAbs(key) || slices.Contains(strings.Split(key, "/"), "..") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(key)), nil
+}
+
+// Put implements Blobs, replacing the file atomically
+func (b *DirBlobs) Put(_ context.Context, key string, data []byte) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".blob-*")
+	if err != nil {
+		return fmt.Errorf("failed to create artifact file: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write artifact file: %w", err)
+	}
+	return nil
+}
+
+// Get implements Blobs
+func (b *DirBlobs) Get(_ context.Context, key string) ([]byte, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact file: %w", err)
+	}
+	return data, nil
+}
+
+// Delete implements Blobs
+func (b *DirBlobs) Delete(_ context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete artifact file: %w", err)
+	}
+	return nil
+}
+
+// List implements Blobs, walking the directory of the prefix
+func (b *DirBlobs) List(_ context.Context, prefix string) ([]string, error) {
+	dir, _ := path.Split(prefix)
+	root := b.dir
+	if dir != "" {
+		p, err := b.path(strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			return nil, err
+		}
+		root = p
+	}
+	var keys []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".blob-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list artifact files: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,70 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+)
+
+// SealedBlobs encrypts the blobs of a Service with the key of their tenant,
+// found from the app and user segments of the keys
+type SealedBlobs struct {
+	Blobs
+	sealer *envelope.Sealer
+}
+
+// NewSealedBlobs wraps blobs
+func NewSealedBlobs(blobs Blobs, sealer *envelope.Sealer) *SealedBlobs {
+	return &SealedBlobs{Blobs: blobs, sealer: sealer}
+}
+
+// tenant returns the tenant of a key
+func (b *SealedBlobs) tenant(key string) (string, error) {
+	segments := strings.SplitN(key, "/", 3)
+	if len(segments) < 3 {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	appName, err := url.PathUnescape(segments[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	userID, err := url.PathUnescape(segments[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return b.sealer.Tenant(appName, userID), nil
+}
+
+// Put implements Blobs
+func (b *SealedBlobs) Put(ctx context.Context, key string, data []byte) error {
+	tenant, err := b.tenant(key)
+	if err != nil {
+		return err
+	}
+	sealed, err := b.sealer.Seal(ctx, tenant, data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt artifact: %w", err)
+	}
+	return b.Blobs.Put(ctx, key, sealed)
+}
+
+// Get implements Blobs. Blobs written before encryption was enabled are
+// read as they are.
+func (b *SealedBlobs) Get(ctx context.Context, key string) ([]byte, error) {
+	tenant, err := b.tenant(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.Blobs.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := b.sealer.Open(ctx, tenant, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt artifact: %w", err)
+	}
+	return plain, nil
+}
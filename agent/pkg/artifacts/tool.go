@@ -0,0 +1,51 @@
+package artifacts
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/adk/tool/loadartifactstool"
+	"google.golang.org/genai"
+)
+
+// SaveArgs are the arguments of the save_artifact tool
+type SaveArgs struct {
+	Name    string `json:"name" jsonschema:"File name of the artifact, e.g. report.md; prefix it with user: to share it with the user's other sessions"`
+	Content string `json:"content" jsonschema:"Text content of the artifact"`
+}
+
+// SaveResult is the result of the save_artifact tool
+type SaveResult struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+}
+
+// NewTools creates save_artifact, which saves text as an artifact of the
+// session, and ADK's load_artifacts, which lists the session's artifacts
+// and loads them into the conversation
+func NewTools() ([]tool.Tool, error) {
+	save, err := functiontool.New(functiontool.Config{
+		Name: "save_artifact",
+		Description: "Saves text, such as a report, transcript or document, as a named file of the session. " +
+			"Saving under an existing name adds a version. Files are loaded back with load_artifacts.",
+	}, func(ctx tool.Context, args SaveArgs) (SaveResult, error) {
+		name := strings.TrimSpace(args.Name)
+		if name == "" {
+			return SaveResult{}, fmt.Errorf("name is required")
+		}
+		if ctx.Artifacts() == nil {
+			return SaveResult{}, fmt.Errorf("no artifact store is configured")
+		}
+		saved, err := ctx.Artifacts().Save(ctx, name, genai.NewPartFromText(args.Content))
+		if err != nil {
+			return SaveResult{}, err
+		}
+		return SaveResult{Name: name, Version: saved.Version}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []tool.Tool{save, loadartifactstool.New()}, nil
+}
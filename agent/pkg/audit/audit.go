@@ -0,0 +1,116 @@
+// Package audit keeps an append-only log of administrative actions: who
+// changed what, when, and the values before and after the change.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry is a single audited action
+type Entry struct {
+	Time   time.Time `json:"time" yaml:"time"`
+	Actor  string    `json:"actor" yaml:"actor"`                       // Who, e.g. the admin actor header or "system"
+	Remote string    `json:"remote,omitempty" yaml:"remote,omitempty"` // Client address for API actions
+	Action string    `json:"action" yaml:"action"`                     // e.g. "PUT", "approve", "budget.override"
+	Target string    `json:"target" yaml:"target"`                     // What was changed, e.g. an admin path or tool name
+	Before any       `json:"before,omitempty" yaml:"before,omitempty"`
+	After  any       `json:"after,omitempty" yaml:"after,omitempty"`
+	Status int       `json:"status,omitempty" yaml:"status,omitempty"` // HTTP status for API actions
+}
+
+// Recorder records audit entries
+type Recorder interface {
+	Record(ctx context.Context, e Entry) error
+}
+
+// FileLog is a Recorder backed by an append-only JSON lines file. Entries
+// are never rewritten or removed.
+type FileLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileLog creates a file log at path, creating parent directories as needed
+func NewFileLog(path string) (*FileLog, error) {
+	if path == "" {
+		return nil, fmt.Errorf("audit log path is required")
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create audit directory: %w", err)
+		}
+	}
+	return &FileLog{path: path}, nil
+}
+
+// Record implements Recorder, stamping the entry with the current time when unset
+func (l *FileLog) Record(_ context.Context, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns entries with Time at or after since, oldest first
+func (l *FileLog) List(ctx context.Context, since time.Time) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse audit entry at line %d: %w", lineNo, err)
+		}
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
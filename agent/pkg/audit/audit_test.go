@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFileLog tests appending entries and listing them by time
+func TestFileLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	l, err := NewFileLog(path)
+	if err != nil {
+		t.Fatalf("NewFileLog() error = %v", err)
+	}
+	ctx := context.Background()
+
+	if entries, err := l.List(ctx, time.Time{}); err != nil || len(entries) != 0 {
+		t.Fatalf("List() on a missing file = %v, %v, want no entries", entries, err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, e := range []Entry{
+		{Time: old, Actor: "alice", Action: "PUT", Target: "/log/level", Before: "info", After: "debug", Status: 200},
+		{Actor: "bob", Action: "budget.override", Target: "monthly", Before: 100.0, After: 250.0},
+	} {
+		if err := l.Record(ctx, e); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	entries, err := l.List(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Actor != "alice" || entries[0].After != "debug" || entries[1].After != 250.0 {
+		t.Errorf("List() = %+v", entries)
+	}
+	if entries[1].Time.IsZero() {
+		t.Errorf("Record() did not stamp the entry time")
+	}
+
+	recent, _ := l.List(ctx, time.Now().Add(-time.Hour))
+	if len(recent) != 1 || recent[0].Actor != "bob" {
+		t.Errorf("List(since 1h) = %+v, want bob's entry", recent)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("audit log permissions = %o, want 600", perm)
+	}
+}
@@ -0,0 +1,296 @@
+// Package backend watches the load of a local inference server (Ollama or
+// vLLM) and throttles model calls while the server is saturated.
+package backend
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Config holds backend monitor configuration
+type Config struct {
+	Backend  string        // "ollama" or "vllm"
+	BaseURL  string        // Server root, e.g. http://localhost:8000; a trailing /v1 is ignored
+	Interval time.Duration // Optional, poll interval, defaults to 5 seconds
+
+	// MaxWaiting is the queue depth above which vLLM counts as saturated, defaults to 0
+	MaxWaiting int
+
+	// MaxCacheUsage is the KV cache usage (0-1) at which vLLM counts as saturated, defaults to 0.95
+	MaxCacheUsage float64
+
+	HTTPClient *http.Client // Optional, defaults to http.DefaultClient
+	Logger     *slog.Logger
+}
+
+// ModelStatus is a model loaded by the server (Ollama)
+type ModelStatus struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`      // Bytes in memory
+	SizeVRAM  int64  `json:"size_vram"` // Bytes in GPU memory
+	Offloaded bool   `json:"offloaded"` // Partly running on the CPU
+}
+
+// Status is the last observed state of the server
+type Status struct {
+	Backend   string        `json:"backend"`
+	Up        bool          `json:"up"`
+	Error     string        `json:"error,omitempty"`
+	Saturated bool          `json:"saturated"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Models    []ModelStatus `json:"models,omitempty"`    // Ollama
+	VRAMUsed  int64         `json:"vram_used,omitempty"` // Ollama, bytes across loaded models
+	Running   int           `json:"running"`             // vLLM
+	Waiting   int           `json:"waiting"`             // vLLM queue depth
+	CacheUsed float64       `json:"cache_usage"`         // vLLM KV cache usage, 0-1
+}
+
+// Monitor polls the server's metrics
+type Monitor struct {
+	cfg    Config
+	client *http.Client
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// NewMonitor creates a monitor for the configured server
+func NewMonitor(cfg *Config) (*Monitor, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Backend != "ollama" && cfg.Backend != "vllm" {
+		return nil, fmt.Errorf("unknown backend %q (must be ollama or vllm)", cfg.Backend)
+	}
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("base URL is required")
+	}
+	if cfg.MaxWaiting < 0 || cfg.MaxCacheUsage < 0 || cfg.MaxCacheUsage > 1 {
+		return nil, fmt.Errorf("max waiting cannot be negative and max cache usage must be in [0, 1]")
+	}
+
+	c := *cfg
+	c.BaseURL = strings.TrimSuffix(strings.TrimRight(c.BaseURL, "/"), "/v1")
+	c.Interval = cmp.Or(c.Interval, 5*time.Second)
+	c.MaxCacheUsage = cmp.Or(c.MaxCacheUsage, 0.95)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("backend")
+	}
+
+	return &Monitor{
+		cfg:    c,
+		client: client,
+		logger: logger,
+		status: Status{Backend: c.Backend},
+	}, nil
+}
+
+// Status returns the last observed status
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Saturated reports whether the server was saturated at the last poll
+func (m *Monitor) Saturated() bool {
+	return m.Status().Saturated
+}
+
+// Run polls the server until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	m.logger.Info("Backend monitor started",
+		"backend", m.cfg.Backend,
+		"base_url", m.cfg.BaseURL,
+		"interval", m.cfg.Interval,
+	)
+
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	m.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.poll(ctx)
+		}
+	}
+}
+
+// poll fetches the server's metrics and updates the status
+func (m *Monitor) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Interval)
+	defer cancel()
+
+	status := Status{Backend: m.cfg.Backend, UpdatedAt: time.Now()}
+	var err error
+	if m.cfg.Backend == "ollama" {
+		err = m.pollOllama(ctx, &status)
+	} else {
+		err = m.pollVLLM(ctx, &status)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Up = true
+	}
+
+	m.mu.Lock()
+	previous := m.status
+	m.status = status
+	m.mu.Unlock()
+
+	switch {
+	case previous.Up && !status.Up:
+		m.logger.Warn("Backend metrics unavailable", "backend", m.cfg.Backend, "error", err)
+	case status.Saturated && !previous.Saturated:
+		m.logger.Warn("Backend saturated, throttling model calls",
+			"running", status.Running,
+			"waiting", status.Waiting,
+			"cache_usage", status.CacheUsed,
+		)
+	case !status.Saturated && previous.Saturated:
+		m.logger.Info("Backend no longer saturated")
+	}
+}
+
+// pollOllama reads the loaded models and their memory from /api/ps. Ollama
+// does not expose its queue, so it never counts as saturated.
+func (m *Monitor) pollOllama(ctx context.Context, status *Status) error {
+	body, err := m.get(ctx, "/api/ps")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var ps struct {
+		Models []struct {
+			Name     string `json:"name"`
+			Size     int64  `json:"size"`
+			SizeVRAM int64  `json:"size_vram"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(body).Decode(&ps); err != nil {
+		return fmt.Errorf("failed to decode running models: %w", err)
+	}
+	for _, p := range ps.Models {
+		status.Models = append(status.Models, ModelStatus{
+			Name:      p.Name,
+			Size:      p.Size,
+			SizeVRAM:  p.SizeVRAM,
+			Offloaded: p.SizeVRAM < p.Size,
+		})
+		status.VRAMUsed += p.SizeVRAM
+	}
+	return nil
+}
+
+// pollVLLM reads the request queue and KV cache usage from the Prometheus /metrics
+func (m *Monitor) pollVLLM(ctx context.Context, status *Status) error {
+	body, err := m.get(ctx, "/metrics")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name, value, ok := parseSample(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch name {
+		case "vllm:num_requests_running":
+			status.Running += int(value)
+		case "vllm:num_requests_waiting":
+			status.Waiting += int(value)
+		case "vllm:kv_cache_usage_perc", "vllm:gpu_cache_usage_perc":
+			// Named gpu_cache_usage_perc before vLLM 0.10, both are fractions
+			status.CacheUsed = max(status.CacheUsed, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read metrics: %w", err)
+	}
+
+	status.Saturated = status.Waiting > m.cfg.MaxWaiting || status.CacheUsed >= m.cfg.MaxCacheUsage
+	return nil
+}
+
+// get fetches path from the server, returning the body of a 200 response
+func (m *Monitor) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.cfg.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// parseSample parses a Prometheus text exposition sample line into its metric
+// name and value, ignoring labels and timestamps
+func parseSample(line string) (string, float64, bool) {
+	if line == "" || line[0] == '#' {
+		return "", 0, false
+	}
+	name, rest := line, ""
+	if i := strings.IndexAny(line, "{ "); i >= 0 {
+		name, rest = line[:i], line[i:]
+	}
+	if strings.HasPrefix(rest, "{") {
+		end := strings.LastIndex(rest, "}")
+		if end < 0 {
+			return "", 0, false
+		}
+		rest = rest[end+1:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return name, value, true
+}
+
+// ServeHTTP implements the admin endpoint returning the last observed status
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Status())
+}
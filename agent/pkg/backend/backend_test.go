@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+)
+
+const vllmMetrics = `# HELP vllm:num_requests_running Number of requests in model execution batches.
+# TYPE vllm:num_requests_running gauge
+vllm:num_requests_running{engine="0",model_name="qwen"} 8.0
+# TYPE vllm:num_requests_waiting gauge
+vllm:num_requests_waiting{engine="0",model_name="qwen"} %WAITING%
+# TYPE vllm:kv_cache_usage_perc gauge
+vllm:kv_cache_usage_perc{engine="0",model_name="qwen"} 0.42
+vllm:prompt_tokens_total{model_name="qwen"} 12345 1700000000000
+`
+
+// TestMonitor tests reading vLLM and Ollama metrics into the status
+func TestMonitor(t *testing.T) {
+	var waiting atomic.Value
+	waiting.Store("0.0")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			w.Write([]byte(strings.Replace(vllmMetrics, "%WAITING%", waiting.Load().(string), 1)))
+		case "/api/ps":
+			w.Write([]byte(`{"models":[{"name":"qwen3:8b","size":6000,"size_vram":6000},{"name":"llama3:70b","size":40000,"size_vram":24000}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	m, err := NewMonitor(&Config{Backend: "vllm", BaseURL: srv.URL + "/v1"})
+	if err != nil {
+		t.Fatalf("NewMonitor() error = %v", err)
+	}
+	m.poll(ctx)
+	s := m.Status()
+	if !s.Up || s.Running != 8 || s.Waiting != 0 || s.CacheUsed != 0.42 || s.Saturated {
+		t.Errorf("idle vLLM status = %+v", s)
+	}
+	waiting.Store("3")
+	m.poll(ctx)
+	if s := m.Status(); s.Waiting != 3 || !s.Saturated {
+		t.Errorf("queued vLLM status = %+v, want saturated with 3 waiting", s)
+	}
+
+	m, _ = NewMonitor(&Config{Backend: "ollama", BaseURL: srv.URL})
+	m.poll(ctx)
+	s = m.Status()
+	if !s.Up || len(s.Models) != 2 || s.VRAMUsed != 30000 || s.Models[0].Offloaded || !s.Models[1].Offloaded {
+		t.Errorf("ollama status = %+v", s)
+	}
+
+	srv.Close()
+	m.poll(ctx)
+	if s := m.Status(); s.Up || s.Error == "" {
+		t.Errorf("unreachable status = %+v, want down with an error", s)
+	}
+}
+
+// blockingModel holds every call until release is closed
+type blockingModel struct {
+	active  atomic.Int32
+	peak    atomic.Int32
+	release chan struct{}
+}
+
+func (m *blockingModel) Name() string { return "blocking" }
+
+func (m *blockingModel) GenerateContent(context.Context, *model.LLMRequest, bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		n := m.active.Add(1)
+		defer m.active.Add(-1)
+		for {
+			peak := m.peak.Load()
+			if n <= peak || m.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		<-m.release
+		yield(&model.LLMResponse{TurnComplete: true}, nil)
+	}
+}
+
+// TestThrottledModel tests the concurrency limit while saturated and waiting until cancellation
+func TestThrottledModel(t *testing.T) {
+	monitor, _ := NewMonitor(&Config{Backend: "vllm", BaseURL: "http://localhost"})
+	monitor.status.Saturated = true
+
+	llm := &blockingModel{release: make(chan struct{})}
+	throttled, err := NewThrottledModel(llm, monitor, &ThrottleConfig{MaxConcurrency: 4, SaturatedConcurrency: 2})
+	if err != nil {
+		t.Fatalf("NewThrottledModel() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	for range 5 {
+		go func() {
+			for range throttled.GenerateContent(context.Background(), &model.LLMRequest{}, false) {
+			}
+			done <- struct{}{}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := throttled.InFlight(); got != 2 {
+		t.Errorf("InFlight() = %d while saturated, want 2", got)
+	}
+
+	// A call whose context ends while waiting fails without reaching the model
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	for _, err := range throttled.GenerateContent(ctx, &model.LLMRequest{}, false) {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("waiting call error = %v, want deadline exceeded", err)
+		}
+	}
+
+	close(llm.release)
+	for range 5 {
+		<-done
+	}
+	if peak := llm.peak.Load(); peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak)
+	}
+	if got := throttled.InFlight(); got != 0 {
+		t.Errorf("InFlight() = %d after all calls, want 0", got)
+	}
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+)
+
+// ThrottleConfig holds the concurrency limits of a throttled model
+type ThrottleConfig struct {
+	MaxConcurrency       int // Concurrent calls while the backend is healthy, 0 is unlimited
+	SaturatedConcurrency int // Concurrent calls while the backend is saturated, defaults to 1
+	Logger               *slog.Logger
+}
+
+// ThrottledModel wraps a model.LLM and limits concurrent calls, more tightly
+// while the monitor reports the backend as saturated. Calls wait for a slot
+// until their context is cancelled.
+type ThrottledModel struct {
+	llm       model.LLM
+	monitor   *Monitor
+	max       int
+	saturated int
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	inFlight int
+	released chan struct{} // Closed and replaced whenever a slot is released
+}
+
+// NewThrottledModel wraps llm with concurrency limits driven by monitor
+func NewThrottledModel(llm model.LLM, monitor *Monitor, cfg *ThrottleConfig) (*ThrottledModel, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if monitor == nil {
+		return nil, fmt.Errorf("monitor is required")
+	}
+	if cfg == nil {
+		cfg = &ThrottleConfig{}
+	}
+	if cfg.MaxConcurrency < 0 || cfg.SaturatedConcurrency < 0 {
+		return nil, fmt.Errorf("concurrency limits cannot be negative")
+	}
+
+	saturated := cfg.SaturatedConcurrency
+	if saturated == 0 {
+		saturated = 1
+	}
+	if cfg.MaxConcurrency > 0 {
+		saturated = min(saturated, cfg.MaxConcurrency)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("backend")
+	}
+
+	return &ThrottledModel{
+		llm:       llm,
+		monitor:   monitor,
+		max:       cfg.MaxConcurrency,
+		saturated: saturated,
+		logger:    logger,
+		released:  make(chan struct{}),
+	}, nil
+}
+
+// Name implements model.LLM
+func (t *ThrottledModel) Name() string {
+	return t.llm.Name()
+}
+
+// InFlight returns the number of calls currently holding a slot
+func (t *ThrottledModel) InFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.inFlight
+}
+
+// GenerateContent implements model.LLM
+func (t *ThrottledModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if err := t.acquire(ctx); err != nil {
+			yield(nil, fmt.Errorf("waiting for the model backend: %w", err))
+			return
+		}
+		defer t.release()
+
+		for resp, err := range t.llm.GenerateContent(ctx, req, stream) {
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// limit returns the current concurrency limit, 0 for unlimited
+func (t *ThrottledModel) limit() int {
+	if t.monitor.Saturated() {
+		return t.saturated
+	}
+	return t.max
+}
+
+// acquire waits for a slot. Waiters re-check on every release and once a
+// second, since the limit rises again when the backend recovers.
+func (t *ThrottledModel) acquire(ctx context.Context) error {
+	start := time.Now()
+	for {
+		t.mu.Lock()
+		if limit := t.limit(); limit == 0 || t.inFlight < limit {
+			t.inFlight++
+			t.mu.Unlock()
+			if waited := time.Since(start); waited > time.Second {
+				t.logger.Info("Model call waited for the backend", "waited", waited)
+			}
+			return nil
+		}
+		released := t.released
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (t *ThrottledModel) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	close(t.released)
+	t.released = make(chan struct{})
+}
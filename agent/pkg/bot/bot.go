@@ -0,0 +1,229 @@
+// Package bot runs the agent for chat platform integrations. Each
+// conversation of the platform, such as a Slack thread, is a session of its
+// own, and a reply streams to the platform through an update callback at a
+// rate the platform's API tolerates.
+package bot
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// Config holds bot configuration
+type Config struct {
+	// Platform names the integration, e.g. "slack". It is the user ID of
+	// the sessions, so conversations of different platforms never mix.
+	Platform string
+	// UpdateInterval is the least time between two updates of a reply,
+	// defaults to 1s
+	UpdateInterval time.Duration
+	Logger         *slog.Logger
+}
+
+// Speaker synthesizes replies for platforms that can send them as speech,
+// e.g. an *openai_compatible.Voice
+type Speaker interface {
+	Speak(ctx context.Context, text string) (*openai_compatible.Speech, error)
+}
+
+// Bot answers the messages of a platform's conversations with the root agent
+type Bot struct {
+	cfg      Config
+	agent    agent.Agent
+	sessions session.Service
+	runner   *runner.Runner
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	turns map[string]*turnLock // By conversation
+}
+
+// turnLock serializes the turns of a conversation
+type turnLock struct {
+	mu    sync.Mutex
+	users int // Turns holding or waiting for mu
+}
+
+// New creates a bot running the launcher's root agent
+func New(config *launcher.Config, cfg *Config) (*Bot, error) {
+	if cfg == nil || cfg.Platform == "" {
+		return nil, fmt.Errorf("bot platform is required")
+	}
+	c := *cfg
+	if c.UpdateInterval <= 0 {
+		c.UpdateInterval = time.Second
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("bot")
+	}
+
+	sessions := config.SessionService
+	if sessions == nil {
+		sessions = session.InMemoryService()
+	}
+	a := config.AgentLoader.RootAgent()
+	r, err := runner.New(runner.Config{
+		AppName:         a.Name(),
+		Agent:           a,
+		SessionService:  sessions,
+		ArtifactService: config.ArtifactService,
+		MemoryService:   config.MemoryService,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create runner: %w", err)
+	}
+	return &Bot{
+		cfg:      c,
+		agent:    a,
+		sessions: sessions,
+		runner:   r,
+		logger:   logger,
+		turns:    make(map[string]*turnLock),
+	}, nil
+}
+
+// Reply runs the agent on a message of the conversation and returns the
+// reply. While the agent runs, update is called with the reply so far, at
+// most once per update interval; the caller posts the returned reply.
+// Messages of one conversation are answered one at a time, and the log lines
+// of each turn carry a correlation ID of their own.
+func (b *Bot) Reply(ctx context.Context, conversation, text string, update func(ctx context.Context, reply string) error) (string, error) {
+	unlock := b.lock(conversation)
+	defer unlock()
+	ctx = logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+
+	if err := b.openSession(ctx, conversation); err != nil {
+		return "", err
+	}
+
+	var done, partial strings.Builder // Complete responses, and the one streaming
+	var last time.Time
+	var lastReply string
+	reply := func() string {
+		if partial.Len() == 0 {
+			return done.String()
+		}
+		if done.Len() == 0 {
+			return partial.String()
+		}
+		return done.String() + "\n\n" + partial.String()
+	}
+
+	msg := genai.NewContentFromText(text, genai.RoleUser)
+	events := b.runner.Run(ctx, b.cfg.Platform, conversation, msg, agent.RunConfig{StreamingMode: agent.StreamingModeSSE})
+	for event, err := range events {
+		if err == nil && event.ErrorMessage != "" {
+			err = fmt.Errorf("%s: %s", cmp.Or(event.ErrorCode, "model error"), event.ErrorMessage)
+		}
+		if err != nil {
+			return "", err
+		}
+		if event.Content == nil {
+			continue
+		}
+		var eventText strings.Builder
+		for _, part := range event.Content.Parts {
+			if part.Text != "" && !part.Thought {
+				eventText.WriteString(part.Text)
+			}
+		}
+		if event.Partial {
+			partial.WriteString(eventText.String())
+		} else if eventText.Len() > 0 {
+			partial.Reset()
+			if done.Len() > 0 {
+				done.WriteString("\n\n")
+			}
+			done.WriteString(eventText.String())
+		}
+
+		if current := reply(); current != lastReply && time.Since(last) >= b.cfg.UpdateInterval {
+			if err := update(ctx, current); err != nil {
+				b.logger.Warn("Failed to update reply", "platform", b.cfg.Platform, "conversation", conversation, "error", err)
+			}
+			last, lastReply = time.Now(), current
+		}
+	}
+	return reply(), nil
+}
+
+// Reset deletes the conversation's session, so its next message starts over
+func (b *Bot) Reset(ctx context.Context, conversation string) error {
+	unlock := b.lock(conversation)
+	defer unlock()
+
+	err := b.sessions.Delete(ctx, &session.DeleteRequest{AppName: b.agent.Name(), UserID: b.cfg.Platform, SessionID: conversation})
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// openSession creates the conversation's session unless it exists
+func (b *Bot) openSession(ctx context.Context, conversation string) error {
+	_, err := b.sessions.Get(ctx, &session.GetRequest{AppName: b.agent.Name(), UserID: b.cfg.Platform, SessionID: conversation})
+	if err == nil {
+		return nil
+	}
+	_, err = b.sessions.Create(ctx, &session.CreateRequest{AppName: b.agent.Name(), UserID: b.cfg.Platform, SessionID: conversation})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// lock waits for the conversation's running turn and returns the unlock
+// function of the caller's
+func (b *Bot) lock(conversation string) func() {
+	b.mu.Lock()
+	l, ok := b.turns[conversation]
+	if !ok {
+		l = &turnLock{}
+		b.turns[conversation] = l
+	}
+	l.users++
+	b.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		b.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(b.turns, conversation)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Split splits a reply into messages of at most size bytes, at line breaks
+// where possible, for platforms limiting the length of a message
+func Split(text string, size int) []string {
+	var parts []string
+	for len(text) > size {
+		cut := strings.LastIndex(text[:size], "\n")
+		if cut <= 0 {
+			cut = size
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		parts = append(parts, text[:cut])
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	return append(parts, text)
+}
@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// countModel streams how many user messages the session holds
+type countModel struct{}
+
+func (countModel) Name() string { return "count" }
+
+func (countModel) GenerateContent(_ context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		n := 0
+		for _, c := range req.Contents {
+			if c.Role == genai.RoleUser {
+				n++
+			}
+		}
+		text := fmt.Sprintf("message %d", n)
+		if stream && !yield(&model.LLMResponse{Content: genai.NewContentFromText("message", genai.RoleModel), Partial: true}, nil) {
+			return
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// TestReply tests that a conversation keeps its session until reset
+func TestReply(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "count", Model: countModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(&launcher.Config{AgentLoader: agent.NewSingleLoader(a)}, &Config{Platform: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var updates []string
+	update := func(_ context.Context, reply string) error {
+		updates = append(updates, reply)
+		return nil
+	}
+	for i, want := range []string{"message 1", "message 2"} {
+		reply, err := b.Reply(ctx, "c1", "hi", update)
+		if err != nil || reply != want {
+			t.Errorf("Reply() #%d = %q, %v; want %q", i+1, reply, err, want)
+		}
+	}
+	if len(updates) == 0 || updates[0] != "message" {
+		t.Errorf("updates = %q, want the streamed text first", updates)
+	}
+	if reply, _ := b.Reply(ctx, "c2", "hi", update); reply != "message 1" {
+		t.Errorf("other conversation reply = %q, want a session of its own", reply)
+	}
+	if err := b.Reset(ctx, "c1"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if reply, _ := b.Reply(ctx, "c1", "hi", update); reply != "message 1" {
+		t.Errorf("reply after reset = %q, want a new session", reply)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{"short", "hello", 10, []string{"hello"}},
+		{"lines", "aaaa\nbbbb\ncc", 10, []string{"aaaa\nbbbb", "cc"}},
+		{"long line", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"runes", "ééé", 3, []string{"é", "é", "é"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.text, tt.size)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Split() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package budget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// LogAlerter writes alerts to the logger
+type LogAlerter struct {
+	Logger *slog.Logger
+}
+
+// Alert implements Alerter
+func (a *LogAlerter) Alert(_ context.Context, alert Alert) error {
+	logger := a.Logger
+	if logger == nil {
+		logger = logging.Component("budget")
+	}
+	logger.Warn(alert.Message(),
+		"month", alert.Month,
+		"threshold", alert.Threshold,
+		"spend", alert.Spend,
+		"budget", alert.Budget,
+	)
+	return nil
+}
+
+// WebhookAlerter POSTs the alert as JSON to a URL
+type WebhookAlerter struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// Alert implements Alerter
+func (a *WebhookAlerter) Alert(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, a.HTTPClient, a.URL, alert)
+}
+
+// SlackAlerter posts the alert text to a Slack incoming webhook
+type SlackAlerter struct {
+	WebhookURL string
+	HTTPClient *http.Client
+}
+
+// Alert implements Alerter
+func (a *SlackAlerter) Alert(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, a.HTTPClient, a.WebhookURL, map[string]string{"text": alert.Message()})
+}
+
+// postJSON sends body as JSON and treats non-2xx responses as errors
+func postJSON(ctx context.Context, client *http.Client, url string, body any) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alert endpoint returned %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
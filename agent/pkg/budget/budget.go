@@ -0,0 +1,180 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+)
+
+// Alert describes a monthly budget threshold being crossed
+type Alert struct {
+	Month     string    `json:"month"`     // e.g. 2026-03
+	Threshold float64   `json:"threshold"` // Fraction of the budget, e.g. 0.8
+	Spend     float64   `json:"spend"`
+	Budget    float64   `json:"budget"`
+	Time      time.Time `json:"time"`
+}
+
+// Message returns a human-readable alert text
+func (a Alert) Message() string {
+	return fmt.Sprintf("Budget alert: spend for %s reached %.0f%% of the monthly budget (%.2f / %.2f)",
+		a.Month, a.Threshold*100, a.Spend, a.Budget)
+}
+
+// Alerter delivers budget alerts
+type Alerter interface {
+	Alert(ctx context.Context, alert Alert) error
+}
+
+// Config holds budget tracking configuration
+type Config struct {
+	Monthly    float64   // Monthly budget in the pricing currency, must be positive
+	Thresholds []float64 // Fractions of the budget that trigger alerts, defaults to 0.5, 0.8, 1.0
+	Alerters   []Alerter
+	Logger     *slog.Logger
+}
+
+// Tracker is a usage.Store decorator that keeps month-to-date spend and fires
+// alerts the first time each threshold is crossed in a month
+type Tracker struct {
+	store      usage.Store
+	monthly    float64
+	thresholds []float64
+	alerters   []Alerter
+	logger     *slog.Logger
+	now        func() time.Time
+
+	mu    sync.Mutex
+	month string
+	spend float64
+	fired map[float64]bool
+}
+
+// NewTracker creates a tracker over store and loads the current month's spend from it
+func NewTracker(ctx context.Context, store usage.Store, cfg *Config) (*Tracker, error) {
+	if store == nil {
+		return nil, fmt.Errorf("usage store is required for budget tracking")
+	}
+	if cfg == nil || cfg.Monthly <= 0 {
+		return nil, fmt.Errorf("monthly budget must be positive")
+	}
+
+	thresholds := append([]float64(nil), cfg.Thresholds...)
+	if len(thresholds) == 0 {
+		thresholds = []float64{0.5, 0.8, 1.0}
+	}
+	for _, t := range thresholds {
+		if t <= 0 {
+			return nil, fmt.Errorf("budget thresholds must be positive, got %v", t)
+		}
+	}
+	sort.Float64s(thresholds)
+	thresholds = slices.Compact(thresholds)
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("budget")
+	}
+
+	t := &Tracker{
+		store:      store,
+		monthly:    cfg.Monthly,
+		thresholds: thresholds,
+		alerters:   cfg.Alerters,
+		logger:     logger,
+		now:        time.Now,
+		fired:      make(map[float64]bool),
+	}
+
+	// Thresholds already crossed before startup are not re-alerted
+	now := t.now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	records, err := store.List(ctx, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load month-to-date usage: %w", err)
+	}
+	t.month = start.Format("2006-01")
+	for _, r := range records {
+		t.spend += r.Cost
+	}
+	for _, threshold := range t.thresholds {
+		if t.spend >= threshold*t.monthly {
+			t.fired[threshold] = true
+		}
+	}
+
+	return t, nil
+}
+
+// Spend returns the month-to-date spend
+func (t *Tracker) Spend() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spend
+}
+
+// Fraction returns the month-to-date spend as a fraction of the budget
+func (t *Tracker) Fraction() float64 {
+	return t.Spend() / t.monthly
+}
+
+// Append implements usage.Store
+func (t *Tracker) Append(ctx context.Context, r usage.Record) error {
+	if err := t.store.Append(ctx, r); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	month := r.Time.UTC().Format("2006-01")
+	if month != t.month {
+		t.month = month
+		t.spend = 0
+		t.fired = make(map[float64]bool)
+	}
+	t.spend += r.Cost
+
+	var crossed []Alert
+	for _, threshold := range t.thresholds {
+		if !t.fired[threshold] && t.spend >= threshold*t.monthly {
+			t.fired[threshold] = true
+			crossed = append(crossed, Alert{
+				Month:     t.month,
+				Threshold: threshold,
+				Spend:     t.spend,
+				Budget:    t.monthly,
+				Time:      t.now().UTC(),
+			})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, alert := range crossed {
+		// Deliver outside the request path so slow webhooks do not delay the turn
+		go t.deliver(context.WithoutCancel(ctx), alert)
+	}
+	return nil
+}
+
+// List implements usage.Store
+func (t *Tracker) List(ctx context.Context, since time.Time) ([]usage.Record, error) {
+	return t.store.List(ctx, since)
+}
+
+// deliver sends an alert to every alerter
+func (t *Tracker) deliver(ctx context.Context, alert Alert) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	for _, a := range t.alerters {
+		if err := a.Alert(ctx, alert); err != nil {
+			t.logger.Error("Failed to deliver budget alert", "error", err, "threshold", alert.Threshold)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package budget
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"google.golang.org/adk/model"
+)
+
+// memoryStore is an in-memory usage.Store for tests
+type memoryStore struct {
+	records []usage.Record
+}
+
+func (s *memoryStore) Append(_ context.Context, r usage.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, since time.Time) ([]usage.Record, error) {
+	var out []usage.Record
+	for _, r := range s.records {
+		if !r.Time.Before(since) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// recordingAlerter collects delivered alerts
+type recordingAlerter struct {
+	mu     sync.Mutex
+	alerts []Alert
+	done   chan struct{}
+}
+
+func (a *recordingAlerter) Alert(_ context.Context, alert Alert) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alerts = append(a.alerts, alert)
+	a.done <- struct{}{}
+	return nil
+}
+
+// TestTrackerThresholds tests that each threshold alerts once and already-crossed thresholds are not repeated
+func TestTrackerThresholds(t *testing.T) {
+	now := time.Now().UTC()
+	store := &memoryStore{records: []usage.Record{{Time: now, Cost: 6}}}
+	alerter := &recordingAlerter{done: make(chan struct{}, 10)}
+
+	tracker, err := NewTracker(context.Background(), store, &Config{
+		Monthly:  10,
+		Alerters: []Alerter{alerter},
+	})
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+
+	// 6 -> 9 crosses 80%, 50% was already crossed before startup
+	if err := tracker.Append(context.Background(), usage.Record{Time: now, Cost: 3}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	<-alerter.done
+
+	// 9 -> 9.5 crosses nothing
+	tracker.Append(context.Background(), usage.Record{Time: now, Cost: 0.5})
+
+	// 9.5 -> 10.5 crosses 100%
+	tracker.Append(context.Background(), usage.Record{Time: now, Cost: 1})
+	<-alerter.done
+
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+	if len(alerter.alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %d: %+v", len(alerter.alerts), alerter.alerts)
+	}
+	if alerter.alerts[0].Threshold != 0.8 || alerter.alerts[1].Threshold != 1.0 {
+		t.Errorf("unexpected thresholds %v, %v", alerter.alerts[0].Threshold, alerter.alerts[1].Threshold)
+	}
+	if got := tracker.Fraction(); got != 1.05 {
+		t.Errorf("Fraction() = %v, want 1.05", got)
+	}
+}
+
+// namedModel is a model.LLM that only has a name
+type namedModel string
+
+func (m namedModel) Name() string { return string(m) }
+
+func (namedModel) GenerateContent(context.Context, *model.LLMRequest, bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(func(*model.LLMResponse, error) bool) {}
+}
+
+// recordingAudit collects audit entries
+type recordingAudit struct {
+	entries []audit.Entry
+}
+
+func (r *recordingAudit) Record(_ context.Context, e audit.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+// TestFallbackModel tests switching models at the threshold and auditing each switch once
+func TestFallbackModel(t *testing.T) {
+	now := time.Now().UTC()
+	store := &memoryStore{records: []usage.Record{{Time: now, Cost: 7}}}
+	tracker, err := NewTracker(context.Background(), store, &Config{Monthly: 10})
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+	recorder := &recordingAudit{}
+	m := NewFallbackModel(namedModel("primary"), namedModel("cheap"), tracker, 0.8, recorder)
+
+	if m.Name() != "primary" {
+		t.Errorf("Name() under the threshold = %q, want primary", m.Name())
+	}
+	tracker.Append(context.Background(), usage.Record{Time: now, Cost: 2})
+	for range 2 {
+		if m.Name() != "cheap" {
+			t.Errorf("Name() over the threshold = %q, want cheap", m.Name())
+		}
+	}
+	if len(recorder.entries) != 1 {
+		t.Fatalf("audit entries = %+v, want one switch", recorder.entries)
+	}
+	e := recorder.entries[0]
+	if e.Actor != "system" || e.Action != "budget.fallback" || e.Target != "primary" || e.Before != "primary" || e.After != "cheap" {
+		t.Errorf("audit entry = %+v", e)
+	}
+}
@@ -0,0 +1,80 @@
+package budget
+
+import (
+	"context"
+	"iter"
+	"sync/atomic"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+)
+
+// FallbackModel delegates to a primary model while month-to-date spend is
+// below the fallback threshold, and to a cheaper fallback model above it
+type FallbackModel struct {
+	primary   model.LLM
+	fallback  model.LLM
+	tracker   *Tracker
+	threshold float64
+	switched  atomic.Bool
+	audit     audit.Recorder // nil when switches are only logged
+}
+
+// NewFallbackModel creates a model that switches to fallback once tracker
+// spend reaches threshold (a fraction of the monthly budget). Switches are
+// recorded in recorder when it is not nil.
+func NewFallbackModel(primary, fallback model.LLM, tracker *Tracker, threshold float64, recorder audit.Recorder) *FallbackModel {
+	return &FallbackModel{
+		primary:   primary,
+		fallback:  fallback,
+		tracker:   tracker,
+		threshold: threshold,
+		audit:     recorder,
+	}
+}
+
+// active returns the model currently serving requests
+func (m *FallbackModel) active(ctx context.Context) model.LLM {
+	over := m.tracker.Fraction() >= m.threshold
+	if previous := m.switched.Swap(over); previous != over {
+		entry := audit.Entry{
+			Actor:  "system",
+			Action: "budget.fallback",
+			Target: m.primary.Name(),
+			Before: m.primary.Name(),
+			After:  m.fallback.Name(),
+		}
+		if over {
+			logging.Component("budget").Warn("Budget threshold reached, switching to fallback model",
+				"threshold", m.threshold,
+				"from", m.primary.Name(),
+				"to", m.fallback.Name(),
+			)
+		} else {
+			logging.Component("budget").Info("Budget back under threshold, switching to primary model", "model", m.primary.Name())
+			entry.Action = "budget.primary"
+			entry.Before, entry.After = entry.After, entry.Before
+		}
+		if m.audit != nil {
+			if err := m.audit.Record(ctx, entry); err != nil {
+				logging.Component("budget").Error("Failed to record model switch", "action", entry.Action, "error", err)
+			}
+		}
+	}
+
+	if over {
+		return m.fallback
+	}
+	return m.primary
+}
+
+// Name implements model.LLM
+func (m *FallbackModel) Name() string {
+	return m.active(context.Background()).Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *FallbackModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return m.active(ctx).GenerateContent(ctx, req, stream)
+}
@@ -0,0 +1,236 @@
+package budget
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/cache"
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+)
+
+// Limits reported by ExceededError
+const (
+	LimitTurnTokens    = "turn_tokens"
+	LimitSessionTokens = "session_tokens"
+	LimitSessionCost   = "session_cost"
+)
+
+// Action is what happens to a model call over a limit
+type Action string
+
+const (
+	// ActionRefuse fails the call with an *ExceededError
+	ActionRefuse Action = "refuse"
+	// ActionSummarize lets the model give one last answer without tools,
+	// summarizing the work so far, and refuses the calls after it
+	ActionSummarize Action = "summarize"
+)
+
+// DefaultSummaryInstruction is appended to the system instruction of the
+// wrap-up call, used when the config leaves it empty
+const DefaultSummaryInstruction = "The token budget for this conversation is used up and no more tools can be called. " +
+	"Answer now with a concise summary of what you found and did so far, and what is left to do."
+
+// MetadataExceeded is the CustomMetadata key set to the exceeded limit on a
+// wrap-up answer
+const MetadataExceeded = "budget_exceeded"
+
+// ErrExceeded is wrapped by every *ExceededError
+var ErrExceeded = errors.New("budget exceeded")
+
+// ExceededError is returned instead of calling the model once a turn or
+// session is over one of its limits
+type ExceededError struct {
+	Limit   string  `json:"limit"` // LimitTurnTokens, LimitSessionTokens or LimitSessionCost
+	Used    float64 `json:"used"`
+	Max     float64 `json:"max"`
+	Session string  `json:"session,omitempty"`
+}
+
+func (e *ExceededError) Error() string {
+	if e.Limit == LimitSessionCost {
+		return fmt.Sprintf("budget exceeded: %s %.4f of %.4f", e.Limit, e.Used, e.Max)
+	}
+	return fmt.Sprintf("budget exceeded: %s %.0f of %.0f", e.Limit, e.Used, e.Max)
+}
+
+func (e *ExceededError) Unwrap() error {
+	return ErrExceeded
+}
+
+// LimitConfig holds the token and cost limits of turns and sessions. Zero
+// values disable a limit.
+type LimitConfig struct {
+	MaxTurnTokens    int64
+	MaxSessionTokens int64
+	MaxSessionCost   float64 // In the pricing currency
+
+	Pricing usage.Pricing // Prices the tokens for MaxSessionCost
+
+	Action             Action // Defaults to ActionRefuse
+	SummaryInstruction string // Defaults to DefaultSummaryInstruction
+
+	Logger *slog.Logger
+}
+
+// LimitModel wraps a model.LLM and stops calling it once the turn or the
+// session is over budget. Usage is summed from the usage metadata of the
+// session's events, so the totals survive restarts with a persistent session
+// service and count every agent of the session. The limits are checked
+// before each call: the call that crosses a limit completes.
+type LimitModel struct {
+	llm    model.LLM
+	cfg    LimitConfig
+	logger *slog.Logger
+}
+
+// NewLimitModel wraps llm with the limits in cfg
+func NewLimitModel(llm model.LLM, cfg *LimitConfig) (*LimitModel, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	if cfg.MaxTurnTokens < 0 || cfg.MaxSessionTokens < 0 || cfg.MaxSessionCost < 0 {
+		return nil, fmt.Errorf("budget limits must not be negative")
+	}
+
+	c := *cfg
+	c.Action = cmp.Or(c.Action, ActionRefuse)
+	if c.Action != ActionRefuse && c.Action != ActionSummarize {
+		return nil, fmt.Errorf("invalid budget action %q (must be %q or %q)", c.Action, ActionRefuse, ActionSummarize)
+	}
+	c.SummaryInstruction = cmp.Or(c.SummaryInstruction, DefaultSummaryInstruction)
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("budget")
+	}
+	if _, ok := c.Pricing[llm.Name()]; c.MaxSessionCost > 0 && !ok {
+		logger.Warn("Model has no price, its calls do not count towards the session cost limit", "model", llm.Name())
+	}
+
+	return &LimitModel{
+		llm:    llm,
+		cfg:    c,
+		logger: logger,
+	}, nil
+}
+
+// Name implements model.LLM
+func (m *LimitModel) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *LimitModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		exceeded, summarized := m.check(ctx)
+		if exceeded == nil {
+			for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+				if !yield(resp, err) {
+					return
+				}
+			}
+			return
+		}
+
+		if m.cfg.Action == ActionRefuse || summarized {
+			m.logger.WarnContext(ctx, "Budget exceeded, refusing the model call",
+				"limit", exceeded.Limit,
+				"used", exceeded.Used,
+				"max", exceeded.Max,
+				"session", exceeded.Session,
+			)
+			yield(nil, exceeded)
+			return
+		}
+
+		m.logger.WarnContext(ctx, "Budget exceeded, asking the model to wrap up",
+			"limit", exceeded.Limit,
+			"used", exceeded.Used,
+			"max", exceeded.Max,
+			"session", exceeded.Session,
+		)
+		for resp, err := range m.llm.GenerateContent(ctx, m.wrapUp(req), stream) {
+			if err == nil && resp != nil && !resp.Partial {
+				tagged := *resp
+				tagged.CustomMetadata = map[string]any{MetadataExceeded: exceeded.Limit}
+				for k, v := range resp.CustomMetadata {
+					tagged.CustomMetadata[k] = v
+				}
+				resp = &tagged
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// check sums the usage of the invocation's session and returns the first
+// limit it exceeds, nil when there is none. summarized reports whether the
+// model already wrapped up for that limit: in this turn for the turn limit,
+// in the session for the session limits.
+func (m *LimitModel) check(ctx context.Context) (exceeded *ExceededError, summarized bool) {
+	ictx, ok := ctx.(agent.InvocationContext)
+	if !ok || ictx.Session() == nil {
+		return nil, false
+	}
+
+	var turnTokens, sessionTokens int64
+	var sessionCost float64
+	wrappedUp := make(map[string]bool)
+	for event := range ictx.Session().Events().All() {
+		if event == nil {
+			continue
+		}
+		thisTurn := event.InvocationID == ictx.InvocationID()
+		if limit, ok := event.CustomMetadata[MetadataExceeded].(string); ok && (thisTurn || limit != LimitTurnTokens) {
+			wrappedUp[limit] = true
+		}
+		// Cache hits cost nothing
+		if event.UsageMetadata == nil || event.CustomMetadata[cache.MetadataCache] == "hit" {
+			continue
+		}
+		u := event.UsageMetadata
+		tokens := int64(u.TotalTokenCount)
+		sessionTokens += tokens
+		if thisTurn {
+			turnTokens += tokens
+		}
+		sessionCost += m.cfg.Pricing.Cost(m.llm.Name(), int64(u.PromptTokenCount), int64(u.CachedContentTokenCount), int64(u.CandidatesTokenCount))
+	}
+
+	session := ictx.Session().ID()
+	switch {
+	case m.cfg.MaxTurnTokens > 0 && turnTokens >= m.cfg.MaxTurnTokens:
+		exceeded = &ExceededError{Limit: LimitTurnTokens, Used: float64(turnTokens), Max: float64(m.cfg.MaxTurnTokens), Session: session}
+	case m.cfg.MaxSessionTokens > 0 && sessionTokens >= m.cfg.MaxSessionTokens:
+		exceeded = &ExceededError{Limit: LimitSessionTokens, Used: float64(sessionTokens), Max: float64(m.cfg.MaxSessionTokens), Session: session}
+	case m.cfg.MaxSessionCost > 0 && sessionCost >= m.cfg.MaxSessionCost:
+		exceeded = &ExceededError{Limit: LimitSessionCost, Used: sessionCost, Max: m.cfg.MaxSessionCost, Session: session}
+	default:
+		return nil, false
+	}
+	return exceeded, wrappedUp[exceeded.Limit]
+}
+
+// wrapUp returns a copy of req without tools, asking for a final summary
+func (m *LimitModel) wrapUp(req *model.LLMRequest) *model.LLMRequest {
+	out := *req
+	out.Tools = nil
+	instruction.AppendSystem(&out, m.cfg.SummaryInstruction)
+	out.Config.Tools = nil
+	out.Config.ToolConfig = nil
+	return &out
+}
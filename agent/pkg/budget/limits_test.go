@@ -0,0 +1,157 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// fakeInvocation is the agent.InvocationContext of a turn of a session
+type fakeInvocation struct {
+	agent.InvocationContext
+	context.Context
+	id      string
+	session session.Session
+}
+
+func (c *fakeInvocation) InvocationID() string             { return c.id }
+func (c *fakeInvocation) Session() session.Session         { return c.session }
+func (c *fakeInvocation) Value(key any) any                { return c.Context.Value(key) }
+func (c *fakeInvocation) Done() <-chan struct{}            { return c.Context.Done() }
+func (c *fakeInvocation) Err() error                       { return c.Context.Err() }
+func (c *fakeInvocation) Deadline() (d time.Time, ok bool) { return c.Context.Deadline() }
+
+// answeringModel answers every call with 100 tokens of usage
+type answeringModel struct {
+	reqs []*model.LLMRequest
+}
+
+func (m *answeringModel) Name() string { return "m" }
+
+func (m *answeringModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	m.reqs = append(m.reqs, req)
+	return func(yield func(*model.LLMResponse, error) bool) {
+		yield(&model.LLMResponse{
+			Content:       genai.NewContentFromText("answer", genai.RoleModel),
+			UsageMetadata: &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 80, CandidatesTokenCount: 20, TotalTokenCount: 100},
+			TurnComplete:  true,
+		}, nil)
+	}
+}
+
+// TestLimitModel tests the turn and session limits and both actions
+func TestLimitModel(t *testing.T) {
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	created, err := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "u"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	sess := created.Session
+
+	// call runs one model call in invocation inv and stores its final response
+	// as an event of the session, like the runner does
+	call := func(m model.LLM, inv string) (*model.LLMResponse, error) {
+		ictx := &fakeInvocation{Context: ctx, id: inv, session: sess}
+		req := &model.LLMRequest{
+			Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+			Config:   &genai.GenerateContentConfig{Tools: []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{{Name: "search"}}}}},
+		}
+		var last *model.LLMResponse
+		for resp, err := range m.GenerateContent(ictx, req, false) {
+			if err != nil {
+				return nil, err
+			}
+			last = resp
+		}
+		event := session.NewEvent(inv)
+		event.LLMResponse = *last
+		if err := sessions.AppendEvent(ctx, sess, event); err != nil {
+			t.Fatalf("AppendEvent() error = %v", err)
+		}
+		return last, nil
+	}
+
+	llm := &answeringModel{}
+	m, err := NewLimitModel(llm, &LimitConfig{
+		MaxTurnTokens:    200,
+		MaxSessionTokens: 400,
+		MaxSessionCost:   1,
+		Pricing:          usage.Pricing{"m": {InputPerMillion: 1000, OutputPerMillion: 1000}},
+		Action:           ActionSummarize,
+	})
+	if err != nil {
+		t.Fatalf("NewLimitModel() error = %v", err)
+	}
+
+	// Two calls reach the turn limit, the third wraps up without tools
+	for range 2 {
+		if _, err := call(m, "inv1"); err != nil {
+			t.Fatalf("call under the limit error = %v", err)
+		}
+	}
+	resp, err := call(m, "inv1")
+	if err != nil {
+		t.Fatalf("wrap-up call error = %v", err)
+	}
+	if resp.CustomMetadata[MetadataExceeded] != LimitTurnTokens {
+		t.Errorf("wrap-up metadata = %v", resp.CustomMetadata)
+	}
+	wrapUp := llm.reqs[len(llm.reqs)-1]
+	if wrapUp.Config.Tools != nil || len(wrapUp.Config.SystemInstruction.Parts) != 1 {
+		t.Errorf("wrap-up request = %+v, want no tools and the summary instruction", wrapUp.Config)
+	}
+
+	// The turn already wrapped up: refuse with a structured error
+	_, err = call(m, "inv1")
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || !errors.Is(err, ErrExceeded) || exceeded.Limit != LimitTurnTokens || exceeded.Used != 300 {
+		t.Fatalf("error after wrap-up = %v, want turn limit", err)
+	}
+
+	// A new turn starts under the turn limit until the session has used 400
+	// tokens, which cost 0.4, under the cost limit
+	if _, err := call(m, "inv2"); err != nil {
+		t.Fatalf("new turn error = %v", err)
+	}
+	resp, err = call(m, "inv2")
+	if err != nil || resp.CustomMetadata[MetadataExceeded] != LimitSessionTokens {
+		t.Fatalf("session wrap-up = %v, %v", resp, err)
+	}
+	_, err = call(m, "inv3")
+	if !errors.As(err, &exceeded) || exceeded.Limit != LimitSessionTokens || exceeded.Session != sess.ID() {
+		t.Errorf("error in a new turn = %v, want session limit", err)
+	}
+
+	// The session's 500 tokens cost 0.5
+	costly, err := NewLimitModel(llm, &LimitConfig{MaxSessionCost: 0.3, Pricing: usage.Pricing{"m": {InputPerMillion: 1000, OutputPerMillion: 1000}}})
+	if err != nil {
+		t.Fatalf("NewLimitModel() error = %v", err)
+	}
+	_, err = call(costly, "inv4")
+	if !errors.As(err, &exceeded) || exceeded.Limit != LimitSessionCost || exceeded.Used != 0.5 {
+		t.Errorf("error over the cost limit = %v, want session cost", err)
+	}
+
+	// Outside an invocation there is nothing to count
+	refusing, err := NewLimitModel(llm, &LimitConfig{MaxSessionTokens: 1})
+	if err != nil {
+		t.Fatalf("NewLimitModel() error = %v", err)
+	}
+	for _, err := range refusing.GenerateContent(ctx, &model.LLMRequest{}, false) {
+		if err != nil {
+			t.Errorf("call without a session error = %v", err)
+		}
+	}
+	if _, err := NewLimitModel(llm, &LimitConfig{Action: "stop"}); err == nil {
+		t.Error("invalid action should fail")
+	}
+}
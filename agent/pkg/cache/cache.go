@@ -0,0 +1,183 @@
+// Package cache answers repeated deterministic model requests from a cache.
+// A request is cached when its temperature is 0, keyed on the model name,
+// the contents and the generation config, so an identical prompt gets the
+// same answer without calling the provider.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// MetadataCache is the custom metadata key set to "hit" on cached responses
+const MetadataCache = "cache"
+
+// Store holds cached responses
+type Store interface {
+	// Get returns the value of key, false when missing or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Config holds the response cache configuration
+type Config struct {
+	Store  Store         // Required
+	TTL    time.Duration // How long responses are kept, defaults to 1 hour
+	Logger *slog.Logger
+}
+
+// Model wraps a model.LLM and answers deterministic requests it already
+// answered from the store. Only complete turns without errors are cached.
+type Model struct {
+	llm    model.LLM
+	cfg    Config
+	logger *slog.Logger
+}
+
+// NewModel wraps llm with the cache in cfg
+func NewModel(llm model.LLM, cfg *Config) (*Model, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil || cfg.Store == nil {
+		return nil, fmt.Errorf("store is required")
+	}
+	c := *cfg
+	if c.TTL <= 0 {
+		c.TTL = time.Hour
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("cache")
+	}
+	return &Model{llm: llm, cfg: c, logger: logger}, nil
+}
+
+// Name implements model.LLM
+func (m *Model) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *Model) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if !Deterministic(req) {
+			for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+				if !yield(resp, err) {
+					return
+				}
+			}
+			return
+		}
+
+		name := m.llm.Name()
+		key, err := Key(name, req)
+		if err != nil {
+			m.logger.WarnContext(ctx, "Failed to hash request, not caching", "error", err)
+			for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+				if !yield(resp, err) {
+					return
+				}
+			}
+			return
+		}
+
+		if cached, ok := m.get(ctx, key); ok {
+			m.logger.DebugContext(ctx, "Answered from cache", "model", name, "key", key[:12])
+			for _, resp := range cached {
+				if !yield(resp, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		// Keep the complete responses; partial ones repeat their text
+		var final []*model.LLMResponse
+		failed := false
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			if err != nil || resp == nil || resp.ErrorCode != "" {
+				failed = true
+			} else if !resp.Partial {
+				final = append(final, resp)
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+		if !failed && len(final) > 0 && final[len(final)-1].TurnComplete {
+			m.set(ctx, key, final)
+		}
+	}
+}
+
+// get returns the cached responses of key, marked as cache hits
+func (m *Model) get(ctx context.Context, key string) ([]*model.LLMResponse, bool) {
+	data, ok, err := m.cfg.Store.Get(ctx, key)
+	if err != nil {
+		m.logger.WarnContext(ctx, "Failed to read response cache", "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var responses []*model.LLMResponse
+	if err := json.Unmarshal(data, &responses); err != nil || len(responses) == 0 {
+		m.logger.WarnContext(ctx, "Ignoring invalid cached response", "error", err)
+		return nil, false
+	}
+	for _, resp := range responses {
+		custom := maps.Clone(resp.CustomMetadata)
+		if custom == nil {
+			custom = make(map[string]any, 1)
+		}
+		custom[MetadataCache] = "hit"
+		resp.CustomMetadata = custom
+	}
+	return responses, true
+}
+
+// set stores the responses of a request
+func (m *Model) set(ctx context.Context, key string, responses []*model.LLMResponse) {
+	data, err := json.Marshal(responses)
+	if err != nil {
+		m.logger.WarnContext(ctx, "Failed to encode response for the cache", "error", err)
+		return
+	}
+	if err := m.cfg.Store.Set(ctx, key, data, m.cfg.TTL); err != nil {
+		m.logger.WarnContext(ctx, "Failed to write response cache", "error", err)
+	}
+}
+
+// Deterministic reports whether a request asks for a temperature of 0, so
+// the same answer can be given again
+func Deterministic(req *model.LLMRequest) bool {
+	return req.Config != nil && req.Config.Temperature != nil && *req.Config.Temperature == 0
+}
+
+// Key hashes the model name, contents and generation config of a request.
+// Tools are part of the config through their declarations.
+func Key(modelName string, req *model.LLMRequest) (string, error) {
+	data, err := json.Marshal(struct {
+		Model    string                       `json:"model"`
+		Contents []*genai.Content             `json:"contents"`
+		Config   *genai.GenerateContentConfig `json:"config"`
+	}{modelName, req.Contents, req.Config})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
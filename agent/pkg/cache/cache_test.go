@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// countingModel answers with the number of calls it got
+type countingModel struct {
+	calls int
+}
+
+func (m *countingModel) Name() string { return "counting" }
+
+func (m *countingModel) GenerateContent(_ context.Context, _ *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	m.calls++
+	text := fmt.Sprintf("answer %d", m.calls)
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if !yield(&model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), Partial: true}, nil) {
+			return
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// answer runs the model and returns the text of the final response and
+// whether it came from the cache
+func answer(t *testing.T, m model.LLM, req *model.LLMRequest) (string, bool) {
+	t.Helper()
+	var final *model.LLMResponse
+	for resp, err := range m.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		if !resp.Partial {
+			final = resp
+		}
+	}
+	if final == nil {
+		t.Fatal("no final response")
+	}
+	return final.Content.Parts[0].Text, final.CustomMetadata[MetadataCache] == "hit"
+}
+
+func request(text string, temperature *float32) *model.LLMRequest {
+	return &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText(text, genai.RoleUser)},
+		Config:   &genai.GenerateContentConfig{Temperature: temperature},
+	}
+}
+
+func TestModel(t *testing.T) {
+	fake := &countingModel{}
+	m, err := NewModel(fake, &Config{Store: NewMemoryStore(10, 0)})
+	if err != nil {
+		t.Fatalf("NewModel() error = %v", err)
+	}
+
+	if text, hit := answer(t, m, request("hi", genai.Ptr[float32](0))); text != "answer 1" || hit {
+		t.Errorf("first answer = %q (hit %v), want a fresh one", text, hit)
+	}
+	if text, hit := answer(t, m, request("hi", genai.Ptr[float32](0))); text != "answer 1" || !hit {
+		t.Errorf("second answer = %q (hit %v), want the cached one", text, hit)
+	}
+	if text, _ := answer(t, m, request("hello", genai.Ptr[float32](0))); text != "answer 2" {
+		t.Errorf("other prompt answer = %q, want a fresh one", text)
+	}
+	// Sampled requests are never cached
+	answer(t, m, request("hi", genai.Ptr[float32](0.7)))
+	answer(t, m, request("hi", nil))
+	if fake.calls != 4 {
+		t.Errorf("model calls = %d, want 4", fake.calls)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(2, 10)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	s.Set(ctx, "a", []byte("1"), time.Minute)
+	s.Set(ctx, "b", []byte("2"), 0)
+	s.Get(ctx, "a")
+	s.Set(ctx, "c", []byte("3"), 0)
+	if _, ok, _ := s.Get(ctx, "b"); ok {
+		t.Error("least recently used entry was kept")
+	}
+	if _, ok, _ := s.Get(ctx, "a"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok, _ := s.Get(ctx, "a"); ok {
+		t.Error("expired entry was returned")
+	}
+
+	s.Set(ctx, "big", []byte("0123456789"), 0)
+	if _, ok, _ := s.Get(ctx, "c"); ok || s.Len() != 1 {
+		t.Errorf("entries = %d, want only the one filling the byte limit", s.Len())
+	}
+	s.Set(ctx, "huge", []byte("0123456789a"), 0)
+	if _, ok, _ := s.Get(ctx, "huge"); ok {
+		t.Error("value over the byte limit was stored")
+	}
+
+	s.Clear()
+	s.Set(ctx, "d", []byte("0123456789"), 0)
+	if _, ok, _ := s.Get(ctx, "big"); ok || s.Len() != 1 {
+		t.Errorf("entries after Clear = %d, want only the new one", s.Len())
+	}
+}
+
+// fakeRedis serves GET, SET and AUTH from a map
+func fakeRedis(t *testing.T, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authed := password == ""
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					switch {
+					case args[0] == "AUTH" && args[len(args)-1] == password:
+						authed = true
+						io.WriteString(conn, "+OK\r\n")
+					case !authed:
+						io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+					case args[0] == "SET":
+						data[args[1]] = args[2]
+						io.WriteString(conn, "+OK\r\n")
+					case args[0] == "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							io.WriteString(conn, "$-1\r\n")
+						}
+					default:
+						io.WriteString(conn, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// readCommand reads a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	addr := fakeRedis(t, "s3cret")
+
+	s, err := NewRedisStore("redis://:s3cret@"+addr, "yanshu:")
+	if err != nil {
+		t.Fatalf("NewRedisStore() error = %v", err)
+	}
+	defer s.Close()
+	if _, ok, err := s.Get(ctx, "k"); ok || err != nil {
+		t.Errorf("Get() of a missing key = %v, %v", ok, err)
+	}
+	if err := s.Set(ctx, "k", []byte("line 1\r\nline 2"), time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if v, ok, err := s.Get(ctx, "k"); !ok || err != nil || string(v) != "line 1\r\nline 2" {
+		t.Errorf("Get() = %q, %v, %v", v, ok, err)
+	}
+
+	wrong, _ := NewRedisStore("redis://:wrong@"+addr, "")
+	if _, _, err := wrong.Get(ctx, "k"); err == nil {
+		t.Error("Get() with a wrong password succeeded")
+	}
+	for _, bad := range []string{"http://localhost", "redis://", "redis://localhost/x"} {
+		if _, err := NewRedisStore(bad, ""); err == nil {
+			t.Errorf("NewRedisStore(%q) succeeded", bad)
+		}
+	}
+}
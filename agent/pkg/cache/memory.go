@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory LRU store bounded by entry count and bytes
+type MemoryStore struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	ll    *list.List // Front is the most recently used
+	items map[string]*list.Element
+	size  int64
+	now   func() time.Time
+}
+
+// memoryEntry is an element of MemoryStore's list
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore creates a store holding at most maxEntries entries and
+// maxBytes bytes of values, the least recently used being evicted first.
+// Zero means no limit.
+func NewMemoryStore(maxEntries int, maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*memoryEntry)
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		s.remove(el)
+		return nil, false, nil
+	}
+	s.ll.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set implements Store. A value larger than the byte limit is not stored.
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		return nil
+	}
+	e := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = s.now().Add(ttl)
+	}
+	s.items[key] = s.ll.PushFront(e)
+	s.size += int64(len(value))
+	for (s.maxEntries > 0 && s.ll.Len() > s.maxEntries) || (s.maxBytes > 0 && s.size > s.maxBytes) {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+// Len returns the number of entries, expired ones included
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// Clear removes every entry, e.g. to release memory under pressure
+func (s *MemoryStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ll.Init()
+	clear(s.items)
+	s.size = 0
+}
+
+// remove deletes an element, with s.mu held
+func (s *MemoryStore) remove(el *list.Element) {
+	e := s.ll.Remove(el).(*memoryEntry)
+	delete(s.items, e.key)
+	s.size -= int64(len(e.value))
+}
@@ -0,0 +1,256 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/session"
+)
+
+// DefaultMax is the number of checkpoints kept per session by default
+const DefaultMax = 20
+
+// Resource is state outside the session, such as workspace files, that is
+// rolled back with it
+type Resource interface {
+	// Mark returns the position of the resource's changes in a session
+	Mark(sessionID string) int64
+	// Rollback undoes the changes of a session made after mark
+	Rollback(sessionID string, mark int64) error
+	// Release forgets the changes of a session made before mark, which will
+	// not be rolled back to
+	Release(sessionID string, mark int64)
+}
+
+// Config configures checkpoints
+type Config struct {
+	// Sessions stores the sessions, required
+	Sessions session.Service
+	// Auto takes a checkpoint after each final response of the agent
+	Auto bool
+	// Max is the number of checkpoints kept per session, the oldest are
+	// dropped, defaults to DefaultMax
+	Max int
+	// Resources are rolled back along with the sessions
+	Resources []Resource
+	Logger    *slog.Logger
+}
+
+// Checkpoint is the state of a session at a point of the conversation
+type Checkpoint struct {
+	ID      int       `json:"id"`
+	Label   string    `json:"label,omitempty"`
+	Auto    bool      `json:"auto,omitempty"`
+	Created time.Time `json:"created"`
+	Events  int       `json:"events"`
+
+	state map[string]any // Session-scoped state
+	marks []int64        // Marks of the resources
+}
+
+// Service is a session.Service that keeps checkpoints of its sessions and
+// rolls them back. Checkpoints are kept in memory.
+type Service struct {
+	session.Service
+	auto      bool
+	max       int
+	resources []Resource
+	logger    *slog.Logger
+
+	mu          sync.Mutex
+	checkpoints map[string][]*Checkpoint
+	next        map[string]int
+}
+
+// New wraps the session service of cfg
+func New(cfg *Config) (*Service, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Sessions == nil {
+		return nil, fmt.Errorf("session service is required")
+	}
+	s := &Service{
+		Service:     cfg.Sessions,
+		auto:        cfg.Auto,
+		max:         cfg.Max,
+		resources:   cfg.Resources,
+		logger:      cfg.Logger,
+		checkpoints: make(map[string][]*Checkpoint),
+		next:        make(map[string]int),
+	}
+	if s.max <= 0 {
+		s.max = DefaultMax
+	}
+	if s.logger == nil {
+		s.logger = logging.Component("checkpoint")
+	}
+	return s, nil
+}
+
+func key(appName, userID, sessionID string) string {
+	return appName + "\x00" + userID + "\x00" + sessionID
+}
+
+// AppendEvent implements session.Service, taking a checkpoint after a final
+// response when automatic checkpoints are on
+func (s *Service) AppendEvent(ctx context.Context, sess session.Session, event *session.Event) error {
+	if err := s.Service.AppendEvent(ctx, sess, event); err != nil {
+		return err
+	}
+	if !s.auto || event == nil || event.Partial || event.Author == "user" || event.ErrorCode != "" || !event.IsFinalResponse() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.take(ctx, sess.AppName(), sess.UserID(), sess.ID(), "", true); err != nil {
+		s.logger.Error("Failed to take checkpoint", "session", sess.ID(), "error", err)
+	}
+	return nil
+}
+
+// Delete implements session.Service, dropping the checkpoints of the session
+func (s *Service) Delete(ctx context.Context, req *session.DeleteRequest) error {
+	if err := s.Service.Delete(ctx, req); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(req.AppName, req.UserID, req.SessionID)
+	delete(s.checkpoints, k)
+	delete(s.next, k)
+	for _, r := range s.resources {
+		r.Release(req.SessionID, r.Mark(req.SessionID))
+	}
+	return nil
+}
+
+// Checkpoint takes a checkpoint of a session
+func (s *Service) Checkpoint(ctx context.Context, appName, userID, sessionID, label string) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, err := s.take(ctx, appName, userID, sessionID, label, false)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	s.logger.Info("Checkpoint taken", "session", sessionID, "checkpoint", cp.ID, "label", label, "events", cp.Events)
+	return *cp, nil
+}
+
+// take records the current state of a session, dropping the oldest
+// checkpoint beyond the limit. s.mu must be held.
+func (s *Service) take(ctx context.Context, appName, userID, sessionID, label string, auto bool) (*Checkpoint, error) {
+	resp, err := s.Service.Get(ctx, &session.GetRequest{AppName: appName, UserID: userID, SessionID: sessionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	k := key(appName, userID, sessionID)
+	s.next[k]++
+	cp := &Checkpoint{
+		ID:      s.next[k],
+		Label:   label,
+		Auto:    auto,
+		Created: time.Now(),
+		Events:  resp.Session.Events().Len(),
+		state:   sessionState(resp.Session.State().All()),
+	}
+	for _, r := range s.resources {
+		cp.marks = append(cp.marks, r.Mark(sessionID))
+	}
+
+	checkpoints := append(s.checkpoints[k], cp)
+	if len(checkpoints) > s.max {
+		checkpoints = slices.Delete(checkpoints, 0, len(checkpoints)-s.max)
+		for i, r := range s.resources {
+			r.Release(sessionID, checkpoints[0].marks[i])
+		}
+	}
+	s.checkpoints[k] = checkpoints
+	return cp, nil
+}
+
+// Checkpoints returns the checkpoints of a session, oldest first
+func (s *Service) Checkpoints(appName, userID, sessionID string) []Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Checkpoint{}
+	for _, cp := range s.checkpoints[key(appName, userID, sessionID)] {
+		list = append(list, *cp)
+	}
+	return list
+}
+
+// Rollback restores the history, state and resources of a session to a
+// checkpoint and drops the later checkpoints. App and user state, which other
+// sessions share, are left alone. Roll back between turns: a turn running
+// meanwhile would write into the restored session.
+func (s *Service) Rollback(ctx context.Context, appName, userID, sessionID string, id int) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(appName, userID, sessionID)
+	checkpoints := s.checkpoints[k]
+	i := slices.IndexFunc(checkpoints, func(cp *Checkpoint) bool { return cp.ID == id })
+	if i < 0 {
+		return Checkpoint{}, fmt.Errorf("checkpoint %d of session %s not found", id, sessionID)
+	}
+	cp := checkpoints[i]
+
+	resp, err := s.Service.Get(ctx, &session.GetRequest{AppName: appName, UserID: userID, SessionID: sessionID})
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	events := slices.Collect(resp.Session.Events().All())
+	if cp.Events > len(events) {
+		return Checkpoint{}, fmt.Errorf("session %s has fewer events than checkpoint %d", sessionID, id)
+	}
+
+	// Sessions cannot be truncated, so the session is recreated under its ID
+	if err := s.Service.Delete(ctx, &session.DeleteRequest{AppName: appName, UserID: userID, SessionID: sessionID}); err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to delete session: %w", err)
+	}
+	created, err := s.Service.Create(ctx, &session.CreateRequest{
+		AppName:   appName,
+		UserID:    userID,
+		SessionID: sessionID,
+		State:     maps.Clone(cp.state),
+	})
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to recreate session: %w", err)
+	}
+	for _, event := range events[:cp.Events] {
+		restored := *event
+		restored.Actions.StateDelta = sessionState(maps.All(event.Actions.StateDelta))
+		if err := s.Service.AppendEvent(ctx, created.Session, &restored); err != nil {
+			return Checkpoint{}, fmt.Errorf("failed to restore session history: %w", err)
+		}
+	}
+	for j, r := range s.resources {
+		if err := r.Rollback(sessionID, cp.marks[j]); err != nil {
+			return Checkpoint{}, fmt.Errorf("failed to roll back: %w", err)
+		}
+	}
+
+	s.checkpoints[k] = checkpoints[:i+1]
+	s.logger.Info("Session rolled back", "session", sessionID, "checkpoint", id, "dropped_events", len(events)-cp.Events)
+	return *cp, nil
+}
+
+// sessionState copies the session-scoped entries of a state, leaving out app,
+// user and temporary keys. Values are not copied.
+func sessionState(all func(yield func(string, any) bool)) map[string]any {
+	state := make(map[string]any)
+	for k, v := range all {
+		if strings.HasPrefix(k, session.KeyPrefixApp) || strings.HasPrefix(k, session.KeyPrefixUser) || strings.HasPrefix(k, session.KeyPrefixTemp) {
+			continue
+		}
+		state[k] = v
+	}
+	return state
+}
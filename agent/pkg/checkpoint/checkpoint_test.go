@@ -0,0 +1,160 @@
+package checkpoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// counter is a resource counting changes, rolled back by lowering the count
+type counter struct {
+	n        int64
+	released int64
+}
+
+func (c *counter) Mark(string) int64 { return c.n }
+
+func (c *counter) Rollback(_ string, mark int64) error {
+	c.n = mark
+	return nil
+}
+
+func (c *counter) Release(_ string, mark int64) { c.released = mark }
+
+// turn appends a user message and a final response changing the state
+func turn(t *testing.T, s *Service, sess session.Session, text string, delta map[string]any) {
+	t.Helper()
+	user := session.NewEvent("inv")
+	user.Author = "user"
+	user.Content = genai.NewContentFromText(text, genai.RoleUser)
+	reply := session.NewEvent("inv")
+	reply.Author = "agent"
+	reply.Content = genai.NewContentFromText("re: "+text, genai.RoleModel)
+	reply.Actions.StateDelta = delta
+	for _, event := range []*session.Event{user, reply} {
+		if err := s.AppendEvent(context.Background(), sess, event); err != nil {
+			t.Fatalf("AppendEvent() error = %v", err)
+		}
+	}
+}
+
+// TestRollback tests automatic checkpoints and restoring history, state and resources
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	res := &counter{}
+	s, err := New(&Config{Sessions: session.InMemoryService(), Auto: true, Max: 3, Resources: []Resource{res}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "u", SessionID: "s", State: map[string]any{"mode": "plan"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	turn(t, s, created.Session, "one", map[string]any{"step": 1, "app:shared": 1})
+	res.n = 5
+	turn(t, s, created.Session, "two", map[string]any{"step": 2, "app:shared": 2})
+	res.n = 9
+
+	list := s.Checkpoints("app", "u", "s")
+	if len(list) != 2 || !list[0].Auto || list[0].Events != 2 || list[1].Events != 4 {
+		t.Fatalf("Checkpoints() = %+v, want 2 automatic checkpoints", list)
+	}
+
+	cp, err := s.Rollback(ctx, "app", "u", "s", list[0].ID)
+	if err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if cp.ID != list[0].ID {
+		t.Errorf("Rollback() = checkpoint %d, want %d", cp.ID, list[0].ID)
+	}
+	resp, err := s.Get(ctx, &session.GetRequest{AppName: "app", UserID: "u", SessionID: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := resp.Session.Events().Len(); n != 2 {
+		t.Errorf("events after rollback = %d, want 2", n)
+	}
+	state := resp.Session.State()
+	for key, want := range map[string]any{"step": 1, "mode": "plan", "app:shared": 2} {
+		if got, _ := state.Get(key); got != want {
+			t.Errorf("state %s = %v after rollback, want %v", key, got, want)
+		}
+	}
+	if res.n != 0 {
+		t.Errorf("resource = %d after rollback, want 0", res.n)
+	}
+	if list := s.Checkpoints("app", "u", "s"); len(list) != 1 {
+		t.Errorf("Checkpoints() after rollback = %d checkpoints, want 1", len(list))
+	}
+
+	// The restored session continues, beyond the checkpoint limit
+	for _, text := range []string{"three", "four", "five"} {
+		res.n++
+		turn(t, s, resp.Session, text, nil)
+	}
+	list = s.Checkpoints("app", "u", "s")
+	if len(list) != 3 || list[0].ID != 3 || res.released != 1 {
+		t.Errorf("Checkpoints() = %+v, released = %d, want the 3 latest checkpoints", list, res.released)
+	}
+	if _, err := s.Rollback(ctx, "app", "u", "s", 1); err == nil {
+		t.Error("Rollback() to a dropped checkpoint succeeded")
+	}
+
+	if err := s.Delete(ctx, &session.DeleteRequest{AppName: "app", UserID: "u", SessionID: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if list := s.Checkpoints("app", "u", "s"); len(list) != 0 {
+		t.Errorf("Checkpoints() after Delete = %+v", list)
+	}
+}
+
+// TestHTTP tests taking checkpoints and rolling back through the admin endpoints
+func TestHTTP(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(&Config{Sessions: session.InMemoryService()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "u", SessionID: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(h http.Handler, method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	if rec := do(s, http.MethodPost, "/sessions/checkpoints?app=app&user=u&session=s&label=start"); rec.Code != http.StatusOK {
+		t.Fatalf("POST checkpoint = %d %s", rec.Code, rec.Body)
+	}
+	turn(t, s, created.Session, "hello", nil)
+	if rec := do(s, http.MethodGet, "/sessions/checkpoints?app=app&user=u&session=s"); !strings.Contains(rec.Body.String(), `"label":"start"`) {
+		t.Errorf("GET checkpoints = %s, want the manual checkpoint only", rec.Body)
+	} else if strings.Contains(rec.Body.String(), `"auto"`) {
+		t.Errorf("GET checkpoints = %s, want no automatic checkpoint", rec.Body)
+	}
+
+	if rec := do(s.RollbackHandler(), http.MethodPost, "/sessions/rollback?app=app&user=u&session=s&id=1"); rec.Code != http.StatusOK {
+		t.Fatalf("POST rollback = %d %s", rec.Code, rec.Body)
+	}
+	resp, _ := s.Get(ctx, &session.GetRequest{AppName: "app", UserID: "u", SessionID: "s"})
+	if n := resp.Session.Events().Len(); n != 0 {
+		t.Errorf("events after rollback = %d, want 0", n)
+	}
+
+	for target, want := range map[string]int{
+		"/sessions/rollback?app=app&user=u&session=s&id=9": http.StatusConflict,
+		"/sessions/rollback?app=app&user=u&session=s":      http.StatusBadRequest,
+	} {
+		if rec := do(s.RollbackHandler(), http.MethodPost, target); rec.Code != want {
+			t.Errorf("POST %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+}
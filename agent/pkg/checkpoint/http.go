@@ -0,0 +1,63 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ServeHTTP implements the admin endpoint of the checkpoints of a session
+// (?app=&user=&session=): GET lists them, POST takes one labelled ?label=
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	app, user, sessionID := q.Get("app"), q.Get("user"), q.Get("session")
+	if app == "" || user == "" || sessionID == "" {
+		http.Error(w, "app, user and session are required", http.StatusBadRequest)
+		return
+	}
+
+	var result any
+	switch r.Method {
+	case http.MethodGet:
+		result = s.Checkpoints(app, user, sessionID)
+	case http.MethodPost:
+		cp, err := s.Checkpoint(r.Context(), app, user, sessionID, q.Get("label"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		result = cp
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// RollbackHandler returns the admin endpoint that rolls a session
+// (?app=&user=&session=) back to a checkpoint (?id=) on POST
+func (s *Service) RollbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		app, user, sessionID := q.Get("app"), q.Get("user"), q.Get("session")
+		id, err := strconv.Atoi(q.Get("id"))
+		if app == "" || user == "" || sessionID == "" || err != nil {
+			http.Error(w, "app, user, session and a numeric id are required", http.StatusBadRequest)
+			return
+		}
+		cp, err := s.Rollback(r.Context(), app, user, sessionID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cp)
+	})
+}
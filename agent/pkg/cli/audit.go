@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// auditLauncher exports entries of the admin audit log
+type auditLauncher struct {
+	flags  *flag.FlagSet
+	log    *audit.FileLog
+	since  string
+	action string
+	actor  string
+	output string
+}
+
+// auditResult lists the exported audit entries
+type auditResult struct {
+	Entries []audit.Entry `json:"entries" yaml:"entries"`
+}
+
+// Header implements Tabular
+func (r *auditResult) Header() []string {
+	return []string{"TIME", "ACTOR", "ACTION", "TARGET", "STATUS", "BEFORE", "AFTER"}
+}
+
+// Rows implements Tabular
+func (r *auditResult) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Entries))
+	for _, e := range r.Entries {
+		status := ""
+		if e.Status != 0 {
+			status = fmt.Sprint(e.Status)
+		}
+		rows = append(rows, []string{
+			e.Time.Local().Format(time.DateTime), e.Actor, e.Action, e.Target, status,
+			auditValue(e.Before), auditValue(e.After),
+		})
+	}
+	return rows
+}
+
+// auditValue renders a before or after value as compact JSON
+func auditValue(v any) string {
+	if v == nil {
+		return "-"
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// NewAuditLauncher creates the `audit` subcommand reading entries from log
+func NewAuditLauncher(log *audit.FileLog) launcher.SubLauncher {
+	l := &auditLauncher{log: log}
+
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fs.StringVar(&l.since, "since", "30d", "Export window: a duration like '7d', '24h' or a date like '2026-01-01'")
+	fs.StringVar(&l.action, "action", "", "Only entries with this action, e.g. PUT")
+	fs.StringVar(&l.actor, "actor", "", "Only entries by this actor")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *auditLauncher) Keyword() string {
+	return "audit"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *auditLauncher) SimpleDescription() string {
+	return "exports the audit log of admin actions"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *auditLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *auditLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse audit flags: %w", err)
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	if _, err := parseSince(l.since, time.Now()); err != nil {
+		return nil, err
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *auditLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.log == nil {
+		return fmt.Errorf("audit logging is disabled (set admin.audit_log in config)")
+	}
+
+	since, err := parseSince(l.since, time.Now())
+	if err != nil {
+		return err
+	}
+	entries, err := l.log.List(ctx, since)
+	if err != nil {
+		return fmt.Errorf("failed to load audit log: %w", err)
+	}
+
+	result := &auditResult{Entries: []audit.Entry{}}
+	for _, e := range entries {
+		if l.action != "" && !strings.EqualFold(e.Action, l.action) {
+			continue
+		}
+		if l.actor != "" && e.Actor != l.actor {
+			continue
+		}
+		result.Entries = append(result.Entries, e)
+	}
+	return printResult(os.Stdout, l.output, result)
+}
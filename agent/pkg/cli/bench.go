@@ -0,0 +1,332 @@
+package cli
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// defaultBenchPrompt is sent when the bench command gets no prompt
+const defaultBenchPrompt = "Write a paragraph of about 100 words about the sea."
+
+// BenchConfig holds the hook the bench command uses to find the benchmarked model
+type BenchConfig struct {
+	// Model returns the model of the named agent, the root one when name is
+	// empty, without the agent's decorators
+	Model func(agent string) (model.LLM, error)
+	// AllowBatch reports whether batch work may run, false under memory
+	// pressure; optional
+	AllowBatch func() bool
+}
+
+// errMemoryPressure refuses batch commands while memory is past the soft limit
+var errMemoryPressure = errors.New("the process is under memory pressure, batch work is refused until it drops below the soft limit")
+
+// benchLauncher sends concurrent requests to a model and reports their
+// latency, throughput and errors
+type benchLauncher struct {
+	flags       *flag.FlagSet
+	cfg         BenchConfig
+	agent       string
+	prompt      string
+	requests    int
+	concurrency int
+	maxTokens   int
+	timeout     time.Duration
+	noStream    bool
+	output      string
+}
+
+// NewBenchLauncher creates the `bench` subcommand
+func NewBenchLauncher(cfg *BenchConfig) launcher.SubLauncher {
+	l := &benchLauncher{}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.StringVar(&l.agent, "agent", "", "Agent whose model to benchmark, defaults to the root agent")
+	fs.StringVar(&l.prompt, "p", "", "Prompt to send, defaults to the remaining arguments or a short writing task")
+	fs.IntVar(&l.requests, "n", 20, "Number of requests")
+	fs.IntVar(&l.concurrency, "c", 4, "Number of requests in flight at once")
+	fs.IntVar(&l.maxTokens, "max-tokens", 0, "Maximum output tokens per request, 0 for the model's default")
+	fs.DurationVar(&l.timeout, "timeout", 0, "Timeout of each request, 0 for none besides the model's")
+	fs.BoolVar(&l.noStream, "no-stream", false, "Send requests without streaming, so the time to first token is the latency")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *benchLauncher) Keyword() string {
+	return "bench"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *benchLauncher) SimpleDescription() string {
+	return "sends concurrent requests to the model and reports time to first token, tokens/sec, latency percentiles and errors"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *benchLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *benchLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse bench flags: %w", err)
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	if l.requests < 1 || l.concurrency < 1 {
+		return nil, fmt.Errorf("-n and -c must be at least 1")
+	}
+	if l.maxTokens < 0 || l.timeout < 0 {
+		return nil, fmt.Errorf("-max-tokens and -timeout must not be negative")
+	}
+	if l.prompt == "" {
+		l.prompt = buildPrompt(strings.Join(l.flags.Args(), " "), "")
+		return nil, nil
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *benchLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.cfg.Model == nil {
+		return fmt.Errorf("bench is not configured")
+	}
+	if l.cfg.AllowBatch != nil && !l.cfg.AllowBatch() {
+		return errMemoryPressure
+	}
+	llm, err := l.cfg.Model(l.agent)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Sending %d requests to %s, %d at a time...\n", l.requests, llm.Name(), l.concurrency)
+	result := runBench(ctx, llm, &benchOptions{
+		prompt:      cmp.Or(l.prompt, defaultBenchPrompt),
+		requests:    l.requests,
+		concurrency: l.concurrency,
+		maxTokens:   l.maxTokens,
+		timeout:     l.timeout,
+		stream:      !l.noStream,
+	})
+	return printResult(os.Stdout, l.output, result)
+}
+
+// benchOptions holds the requests sent by a benchmark
+type benchOptions struct {
+	prompt      string
+	requests    int
+	concurrency int
+	maxTokens   int
+	timeout     time.Duration
+	stream      bool
+}
+
+// Percentiles summarizes a distribution
+type Percentiles struct {
+	Mean float64 `json:"mean" yaml:"mean"`
+	P50  float64 `json:"p50" yaml:"p50"`
+	P95  float64 `json:"p95" yaml:"p95"`
+	P99  float64 `json:"p99" yaml:"p99"`
+	Max  float64 `json:"max" yaml:"max"`
+}
+
+// percentiles summarizes values, with nearest-rank percentiles
+func percentiles(values []float64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	rank := func(p float64) float64 {
+		return sorted[max(int(math.Ceil(p/100*float64(len(sorted))))-1, 0)]
+	}
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return Percentiles{
+		Mean: sum / float64(len(sorted)),
+		P50:  rank(50),
+		P95:  rank(95),
+		P99:  rank(99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// BenchResult is the outcome of a benchmark. Latencies are in seconds and
+// only count successful requests.
+type BenchResult struct {
+	Model       string  `json:"model" yaml:"model"`
+	Stream      bool    `json:"stream" yaml:"stream"`
+	Requests    int     `json:"requests" yaml:"requests"`
+	Concurrency int     `json:"concurrency" yaml:"concurrency"`
+	Succeeded   int     `json:"succeeded" yaml:"succeeded"`
+	Failed      int     `json:"failed" yaml:"failed"`
+	ErrorRate   float64 `json:"error_rate" yaml:"error_rate"` // Share of failed requests, 0-1
+	Seconds     float64 `json:"seconds" yaml:"seconds"`       // Wall time of the whole benchmark
+
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+	OutputTokens      int64   `json:"output_tokens" yaml:"output_tokens"`
+	TokensPerSecond   float64 `json:"tokens_per_second" yaml:"tokens_per_second"` // Output tokens of all requests over the wall time
+
+	TimeToFirstToken Percentiles `json:"time_to_first_token" yaml:"time_to_first_token"`
+	Latency          Percentiles `json:"latency" yaml:"latency"`
+	// RequestTokensPerSecond is the output rate of each request after its
+	// first token, for requests reporting their usage
+	RequestTokensPerSecond Percentiles `json:"request_tokens_per_second" yaml:"request_tokens_per_second"`
+
+	Errors map[string]int `json:"errors,omitempty" yaml:"errors,omitempty"` // Count per error message
+}
+
+// Header implements Tabular. The value of a distribution is its mean.
+func (r *BenchResult) Header() []string {
+	return []string{"METRIC", "VALUE", "P50", "P95", "P99", "MAX"}
+}
+
+// Rows implements Tabular
+func (r *BenchResult) Rows() [][]string {
+	seconds := func(s float64) string { return strconv.FormatFloat(s, 'f', 3, 64) + "s" }
+	rate := func(s float64) string { return strconv.FormatFloat(s, 'f', 1, 64) }
+	row := func(name string, p Percentiles, format func(float64) string) []string {
+		return []string{name, format(p.Mean), format(p.P50), format(p.P95), format(p.P99), format(p.Max)}
+	}
+	rows := [][]string{
+		{"model", r.Model, "", "", "", ""},
+		{"requests", fmt.Sprintf("%d (%d at a time)", r.Requests, r.Concurrency), "", "", "", ""},
+		{"errors", fmt.Sprintf("%d (%.1f%%)", r.Failed, r.ErrorRate*100), "", "", "", ""},
+		{"duration", seconds(r.Seconds), "", "", "", ""},
+		{"requests/sec", rate(r.RequestsPerSecond), "", "", "", ""},
+		{"tokens/sec", rate(r.TokensPerSecond), "", "", "", ""},
+		row("ttft", r.TimeToFirstToken, seconds),
+		row("latency", r.Latency, seconds),
+		row("request tokens/sec", r.RequestTokensPerSecond, rate),
+	}
+	for _, msg := range slices.Sorted(maps.Keys(r.Errors)) {
+		rows = append(rows, []string{"error", fmt.Sprintf("%s (%d)", msg, r.Errors[msg]), "", "", "", ""})
+	}
+	return rows
+}
+
+// benchSample is the outcome of one request
+type benchSample struct {
+	ttft, latency time.Duration
+	outputTokens  int32
+	err           error
+}
+
+// runBench sends the requests of opts to llm, at most opts.concurrency at once
+func runBench(ctx context.Context, llm model.LLM, opts *benchOptions) *BenchResult {
+	samples := make([]benchSample, opts.requests)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range min(opts.concurrency, opts.requests) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				samples[i] = benchRequest(ctx, llm, opts)
+			}
+		}()
+	}
+	for i := range opts.requests {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	result := &BenchResult{
+		Model:       llm.Name(),
+		Stream:      opts.stream,
+		Requests:    opts.requests,
+		Concurrency: opts.concurrency,
+		Seconds:     elapsed,
+	}
+	var ttfts, latencies, rates []float64
+	for _, s := range samples {
+		if s.err != nil {
+			result.Failed++
+			if result.Errors == nil {
+				result.Errors = make(map[string]int)
+			}
+			result.Errors[s.err.Error()]++
+			continue
+		}
+		result.Succeeded++
+		result.OutputTokens += int64(s.outputTokens)
+		ttfts = append(ttfts, s.ttft.Seconds())
+		latencies = append(latencies, s.latency.Seconds())
+		if generation := (s.latency - s.ttft).Seconds(); s.outputTokens > 0 && generation > 0 {
+			rates = append(rates, float64(s.outputTokens)/generation)
+		}
+	}
+	result.ErrorRate = float64(result.Failed) / float64(result.Requests)
+	if elapsed > 0 {
+		result.RequestsPerSecond = float64(result.Succeeded) / elapsed
+		result.TokensPerSecond = float64(result.OutputTokens) / elapsed
+	}
+	result.TimeToFirstToken = percentiles(ttfts)
+	result.Latency = percentiles(latencies)
+	result.RequestTokensPerSecond = percentiles(rates)
+	return result
+}
+
+// benchRequest sends one request and times its first response and its end
+func benchRequest(ctx context.Context, llm model.LLM, opts *benchOptions) benchSample {
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	config := &genai.GenerateContentConfig{}
+	if opts.maxTokens > 0 {
+		config.MaxOutputTokens = int32(opts.maxTokens)
+	}
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText(opts.prompt, genai.RoleUser)},
+		Config:   config,
+	}
+
+	var s benchSample
+	start := time.Now()
+	for resp, err := range llm.GenerateContent(ctx, req, opts.stream) {
+		if err == nil && resp != nil && resp.ErrorCode != "" {
+			err = fmt.Errorf("%s: %s", resp.ErrorCode, resp.ErrorMessage)
+		}
+		if err != nil {
+			s.err = err
+			return s
+		}
+		if s.ttft == 0 && resp != nil && hasText(resp.Content) {
+			s.ttft = time.Since(start)
+		}
+		if resp != nil && resp.UsageMetadata != nil {
+			s.outputTokens = resp.UsageMetadata.CandidatesTokenCount
+		}
+	}
+	s.latency = time.Since(start)
+	if s.ttft == 0 {
+		s.ttft = s.latency
+	}
+	return s
+}
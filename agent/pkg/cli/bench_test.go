@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// TestPercentiles tests nearest-rank percentiles
+func TestPercentiles(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(100 - i)
+	}
+	got := percentiles(values)
+	want := Percentiles{Mean: 50.5, P50: 50, P95: 95, P99: 99, Max: 100}
+	if got != want {
+		t.Errorf("percentiles(1..100) = %+v, want %+v", got, want)
+	}
+	if got := percentiles([]float64{2}); got.P50 != 2 || got.P99 != 2 {
+		t.Errorf("percentiles(2) = %+v", got)
+	}
+	if got := percentiles(nil); got != (Percentiles{}) {
+		t.Errorf("percentiles(nil) = %+v", got)
+	}
+}
+
+// benchModel streams two chunks with usage, failing every fourth request,
+// and tracks the requests in flight
+type benchModel struct {
+	calls, inFlight, peak atomic.Int32
+}
+
+func (*benchModel) Name() string { return "bench" }
+
+func (m *benchModel) GenerateContent(ctx context.Context, _ *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		n := m.calls.Add(1)
+		current := m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		for peak := m.peak.Load(); current > peak && !m.peak.CompareAndSwap(peak, current); peak = m.peak.Load() {
+		}
+		if n%4 == 0 {
+			yield(nil, errors.New("rate limited"))
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+		if stream && !yield(&model.LLMResponse{Content: genai.NewContentFromText("Hello", genai.RoleModel), Partial: true}, nil) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+		yield(&model.LLMResponse{
+			Content:       genai.NewContentFromText("Hello, world", genai.RoleModel),
+			UsageMetadata: &genai.GenerateContentResponseUsageMetadata{CandidatesTokenCount: 10},
+		}, nil)
+	}
+}
+
+// TestRunBench tests the concurrency, latencies, tokens and errors of a benchmark
+func TestRunBench(t *testing.T) {
+	llm := &benchModel{}
+	result := runBench(context.Background(), llm, &benchOptions{prompt: "hi", requests: 8, concurrency: 3, stream: true})
+
+	if result.Model != "bench" || result.Succeeded != 6 || result.Failed != 2 || result.ErrorRate != 0.25 {
+		t.Errorf("result = %+v", result)
+	}
+	if result.Errors["rate limited"] != 2 {
+		t.Errorf("Errors = %v", result.Errors)
+	}
+	if peak := llm.peak.Load(); peak > 3 {
+		t.Errorf("%d requests in flight, want at most 3", peak)
+	}
+	if result.OutputTokens != 60 || result.TokensPerSecond <= 0 {
+		t.Errorf("OutputTokens = %d, TokensPerSecond = %v", result.OutputTokens, result.TokensPerSecond)
+	}
+	ttft, latency := result.TimeToFirstToken, result.Latency
+	if ttft.P50 < 0.005 || latency.P50 < 0.01 || ttft.P50 >= latency.P50 || latency.P99 < latency.P50 {
+		t.Errorf("TimeToFirstToken = %+v, Latency = %+v", ttft, latency)
+	}
+	if rate := result.RequestTokensPerSecond.P50; rate <= 0 || rate > 2000 {
+		t.Errorf("RequestTokensPerSecond = %+v", result.RequestTokensPerSecond)
+	}
+
+	result = runBench(context.Background(), &benchModel{}, &benchOptions{prompt: "hi", requests: 2, concurrency: 5})
+	if result.Succeeded != 2 || result.TimeToFirstToken.P50 < 0.01 {
+		t.Errorf("without streaming: TimeToFirstToken = %+v, Latency = %+v", result.TimeToFirstToken, result.Latency)
+	}
+}
+
+// TestBenchMemoryPressure tests that bench refuses to run under memory pressure
+func TestBenchMemoryPressure(t *testing.T) {
+	l := NewBenchLauncher(&BenchConfig{
+		Model:      func(string) (model.LLM, error) { return &benchModel{}, nil },
+		AllowBatch: func() bool { return false },
+	})
+	if _, err := l.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Run(context.Background(), nil); !errors.Is(err, errMemoryPressure) {
+		t.Errorf("Run() error = %v, want errMemoryPressure", err)
+	}
+}
@@ -0,0 +1,778 @@
+package cli
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/checkpoint"
+	"github.com/gopher-9527/yanshu/agent/pkg/persona"
+	"github.com/gopher-9527/yanshu/agent/pkg/plan"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// ChatConfig holds the hooks the chat command uses to rebuild the agent
+type ChatConfig struct {
+	// NewAgent builds the agent, with system replacing the configured instruction when not empty
+	NewAgent func(system string) (agent.Agent, error)
+
+	// Model is switched by /model, which is unavailable when Model or NewModel is nil
+	Model    *SwitchableModel
+	NewModel func(ctx context.Context, name string) (model.LLM, error)
+
+	// Personas enables /persona, answered by the persona callback of the agent
+	Personas bool
+
+	// SessionService is optional, defaults to an in-memory service. A
+	// checkpoint.Service enables /checkpoint and /rollback.
+	SessionService session.Service
+}
+
+// chatLauncher runs an interactive chat with the agent in the terminal
+type chatLauncher struct {
+	flags    *flag.FlagSet
+	cfg      ChatConfig
+	userID   string
+	load     string
+	noStream bool
+}
+
+// NewChatLauncher creates the `chat` subcommand
+func NewChatLauncher(cfg *ChatConfig) launcher.SubLauncher {
+	l := &chatLauncher{}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+
+	fs := flag.NewFlagSet("chat", flag.ContinueOnError)
+	fs.StringVar(&l.userID, "user", "user", "User ID of the chat session")
+	fs.StringVar(&l.load, "load", "", "Resume a session saved with /save from this file")
+	fs.BoolVar(&l.noStream, "no-stream", false, "Print responses only once complete")
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *chatLauncher) Keyword() string {
+	return "chat"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *chatLauncher) SimpleDescription() string {
+	return "starts an interactive chat with the agent in the terminal"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *chatLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *chatLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse chat flags: %w", err)
+	}
+	if l.userID == "" {
+		return nil, fmt.Errorf("user ID cannot be empty")
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *chatLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.cfg.NewAgent == nil {
+		return fmt.Errorf("chat is not configured")
+	}
+
+	c := newChat(&l.cfg, l.userID, !l.noStream, os.Stdin, os.Stdout)
+	if err := c.start(ctx); err != nil {
+		return err
+	}
+	if l.load != "" {
+		if err := c.loadSession(ctx, l.load); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(c.out, "Type /help for commands, /exit to quit.")
+	return c.loop(ctx)
+}
+
+// chat is a REPL session with the agent. Input and output are plain streams
+// so the loop can be driven by tests.
+type chat struct {
+	cfg      *ChatConfig
+	userID   string
+	stream   bool
+	in       *bufio.Reader
+	out      io.Writer
+	sessions session.Service
+
+	system   string // Instruction override set with /system
+	planning bool   // Plan mode set with /plan
+	agent    agent.Agent
+	runner   *runner.Runner
+	session  session.Session
+}
+
+// savedSession is the file format of /save and /load
+type savedSession struct {
+	Model  string           `json:"model,omitempty"`
+	System string           `json:"system,omitempty"`
+	Events []*session.Event `json:"events"`
+}
+
+func newChat(cfg *ChatConfig, userID string, stream bool, in io.Reader, out io.Writer) *chat {
+	sessions := cfg.SessionService
+	if sessions == nil {
+		sessions = session.InMemoryService()
+	}
+	return &chat{
+		cfg:      cfg,
+		userID:   userID,
+		stream:   stream,
+		in:       bufio.NewReader(in),
+		out:      out,
+		sessions: sessions,
+	}
+}
+
+// start builds the agent and opens an empty session
+func (c *chat) start(ctx context.Context) error {
+	if err := c.rebuild(); err != nil {
+		return err
+	}
+	return c.reset(ctx)
+}
+
+// rebuild recreates the agent and its runner with the current instruction
+func (c *chat) rebuild() error {
+	a, err := c.cfg.NewAgent(c.system)
+	if err != nil {
+		return fmt.Errorf("failed to create agent: %w", err)
+	}
+	r, err := runner.New(runner.Config{
+		AppName:        a.Name(),
+		Agent:          a,
+		SessionService: c.sessions,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	c.agent, c.runner = a, r
+	return nil
+}
+
+// reset replaces the session with an empty one
+func (c *chat) reset(ctx context.Context) error {
+	req := &session.CreateRequest{
+		AppName: c.agent.Name(),
+		UserID:  c.userID,
+	}
+	if c.planning {
+		req.State = map[string]any{plan.StateKey: true}
+	}
+	resp, err := c.sessions.Create(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	c.session = resp.Session
+	return nil
+}
+
+// loop reads input until EOF or /exit
+func (c *chat) loop(ctx context.Context) error {
+	for {
+		input, err := c.readInput()
+		if errors.Is(err, io.EOF) && input == "" {
+			fmt.Fprintln(c.out)
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+
+		input = strings.TrimSpace(input)
+		switch {
+		case input == "":
+		case strings.HasPrefix(input, "/"):
+			if done := c.command(ctx, input); done {
+				return nil
+			}
+		default:
+			c.send(ctx, input)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// readInput reads one message. A line ending in a backslash continues on the
+// next line, and a line of """ starts a block that runs to the next """.
+func (c *chat) readInput() (string, error) {
+	fmt.Fprint(c.out, "> ")
+	line, err := c.readLine()
+	if err != nil {
+		return line, err
+	}
+
+	if line == `"""` {
+		var lines []string
+		for {
+			fmt.Fprint(c.out, "… ")
+			line, err := c.readLine()
+			if line == `"""` {
+				break
+			}
+			lines = append(lines, line)
+			if err != nil {
+				return strings.Join(lines, "\n"), err
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	var lines []string
+	for {
+		head, more := strings.CutSuffix(line, `\`)
+		lines = append(lines, head)
+		if !more {
+			break
+		}
+		fmt.Fprint(c.out, "… ")
+		if line, err = c.readLine(); err != nil {
+			lines = append(lines, line)
+			return strings.Join(lines, "\n"), err
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// readLine reads a line without its line ending
+func (c *chat) readLine() (string, error) {
+	line, err := c.in.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// command runs a slash command, reporting whether the chat should end
+func (c *chat) command(ctx context.Context, input string) bool {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	var err error
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/help":
+		fmt.Fprint(c.out, chatHelp)
+	case "/reset":
+		if err = c.reset(ctx); err == nil {
+			fmt.Fprintln(c.out, "Started a new session.")
+		}
+	case "/model":
+		err = c.switchModel(ctx, arg)
+	case "/system":
+		err = c.setSystem(arg)
+	case "/save":
+		err = c.saveSession(ctx, arg)
+	case "/load":
+		err = c.loadSession(ctx, arg)
+	case "/plan":
+		err = c.setPlanning(ctx, arg)
+	case "/execute":
+		if !c.planning {
+			err = fmt.Errorf("plan mode is off, turn it on with /plan on")
+			break
+		}
+		c.send(ctx, plan.ExecuteCommand)
+	case "/persona":
+		if !c.cfg.Personas {
+			err = fmt.Errorf("no personas are configured")
+			break
+		}
+		c.send(ctx, strings.TrimSpace(persona.Command+" "+arg))
+	case "/checkpoint":
+		err = c.checkpoint(ctx, arg)
+	case "/checkpoints":
+		err = c.listCheckpoints()
+	case "/rollback":
+		err = c.rollback(ctx, arg)
+	default:
+		err = fmt.Errorf("unknown command %s, type /help for commands", name)
+	}
+	if err != nil {
+		fmt.Fprintf(c.out, "Error: %v\n", err)
+	}
+	return false
+}
+
+const chatHelp = `Commands:
+  /reset           start a new session
+  /model [name]    show or switch the model
+  /system [text]   show or replace the system instruction, "/system default" restores it
+  /save <file>     save the session to a file
+  /load <file>     load a session saved with /save
+  /plan [on|off]   show or switch plan mode, which plans tool calls instead of running them
+  /execute         run the plan proposed in plan mode
+  /persona [name]  list the personas or switch to one, "/persona default" restores the default
+  /checkpoint [label]  save a checkpoint of the session
+  /checkpoints     list the checkpoints of the session
+  /rollback [id]   restore a checkpoint, by default the last one before the latest changes
+  /exit            quit
+End a line with \ to continue it, or wrap multi-line input in """ lines.
+Ctrl-C interrupts a response.
+`
+
+// switchModel shows the model, or switches to the named one
+func (c *chat) switchModel(ctx context.Context, name string) error {
+	if c.cfg.Model != nil && name == "" {
+		fmt.Fprintln(c.out, "Model:", c.cfg.Model.Name())
+		return nil
+	}
+	if c.cfg.Model == nil || c.cfg.NewModel == nil {
+		return fmt.Errorf("model switching is not available")
+	}
+
+	llm, err := c.cfg.NewModel(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to create model: %w", err)
+	}
+	c.cfg.Model.Set(llm)
+	fmt.Fprintln(c.out, "Switched to model", llm.Name())
+	return nil
+}
+
+// setSystem shows the instruction override, or replaces it and rebuilds the
+// agent. The conversation so far is kept.
+func (c *chat) setSystem(text string) error {
+	switch text {
+	case "":
+		if c.system == "" {
+			fmt.Fprintln(c.out, "Using the configured system instruction.")
+		} else {
+			fmt.Fprintln(c.out, "System:", c.system)
+		}
+		return nil
+	case "default":
+		text = ""
+	}
+
+	previous := c.system
+	c.system = text
+	if err := c.rebuild(); err != nil {
+		c.system = previous
+		return err
+	}
+	fmt.Fprintln(c.out, "System instruction updated.")
+	return nil
+}
+
+// setPlanning shows plan mode, or switches it on or off for the session
+func (c *chat) setPlanning(ctx context.Context, arg string) error {
+	switch arg {
+	case "":
+		if c.planning {
+			fmt.Fprintln(c.out, "Plan mode is on.")
+		} else {
+			fmt.Fprintln(c.out, "Plan mode is off.")
+		}
+		return nil
+	case "on", "off":
+	default:
+		return fmt.Errorf("usage: /plan [on|off]")
+	}
+
+	if err := c.applyPlanning(ctx, arg == "on"); err != nil {
+		return err
+	}
+	if c.planning {
+		fmt.Fprintln(c.out, "Plan mode is on: tools with side effects are planned, /execute runs the plan.")
+	} else {
+		fmt.Fprintln(c.out, "Plan mode is off.")
+	}
+	return nil
+}
+
+// applyPlanning records plan mode in the session state
+func (c *chat) applyPlanning(ctx context.Context, on bool) error {
+	event := session.NewEvent("")
+	event.Author = "user"
+	event.Actions.StateDelta = map[string]any{plan.StateKey: on}
+	if err := c.sessions.AppendEvent(ctx, c.session, event); err != nil {
+		return fmt.Errorf("failed to switch plan mode: %w", err)
+	}
+	c.planning = on
+	return nil
+}
+
+// checkpoints returns the session service when it keeps checkpoints
+func (c *chat) checkpoints() (*checkpoint.Service, error) {
+	checkpoints, ok := c.sessions.(*checkpoint.Service)
+	if !ok {
+		return nil, fmt.Errorf("checkpoints are not enabled")
+	}
+	return checkpoints, nil
+}
+
+// checkpoint takes a checkpoint of the session
+func (c *chat) checkpoint(ctx context.Context, label string) error {
+	checkpoints, err := c.checkpoints()
+	if err != nil {
+		return err
+	}
+	cp, err := checkpoints.Checkpoint(ctx, c.session.AppName(), c.session.UserID(), c.session.ID(), label)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Saved checkpoint %d at %d events\n", cp.ID, cp.Events)
+	return nil
+}
+
+// listCheckpoints prints the checkpoints of the session
+func (c *chat) listCheckpoints() error {
+	checkpoints, err := c.checkpoints()
+	if err != nil {
+		return err
+	}
+	list := checkpoints.Checkpoints(c.session.AppName(), c.session.UserID(), c.session.ID())
+	if len(list) == 0 {
+		fmt.Fprintln(c.out, "No checkpoints.")
+	}
+	for _, cp := range list {
+		label := cp.Label
+		if cp.Auto {
+			label = "after a turn"
+		}
+		fmt.Fprintf(c.out, "%4d  %s  %3d events  %s\n", cp.ID, cp.Created.Format("15:04:05"), cp.Events, label)
+	}
+	return nil
+}
+
+// rollback restores a checkpoint, by default the latest one with fewer
+// events than the session
+func (c *chat) rollback(ctx context.Context, arg string) error {
+	checkpoints, err := c.checkpoints()
+	if err != nil {
+		return err
+	}
+	app, user, id := c.session.AppName(), c.session.UserID(), c.session.ID()
+	resp, err := c.sessions.Get(ctx, &session.GetRequest{AppName: app, UserID: user, SessionID: id})
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+
+	events := resp.Session.Events().Len()
+	target := 0
+	if arg != "" {
+		if target, err = strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("usage: /rollback [id]")
+		}
+	} else {
+		for _, cp := range checkpoints.Checkpoints(app, user, id) {
+			if cp.Events < events {
+				target = cp.ID
+			}
+		}
+		if target == 0 {
+			return fmt.Errorf("no checkpoint before the latest changes")
+		}
+	}
+
+	cp, err := checkpoints.Rollback(ctx, app, user, id, target)
+	if err != nil {
+		return err
+	}
+	if resp, err = c.sessions.Get(ctx, &session.GetRequest{AppName: app, UserID: user, SessionID: id}); err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	c.session = resp.Session
+	planning, _ := c.session.State().Get(plan.StateKey)
+	c.planning = planning == true
+	fmt.Fprintf(c.out, "Rolled back to checkpoint %d, dropping %d events\n", cp.ID, events-cp.Events)
+	return nil
+}
+
+// saveSession writes the session events to path
+func (c *chat) saveSession(ctx context.Context, path string) error {
+	if path == "" {
+		return fmt.Errorf("usage: /save <file>")
+	}
+	resp, err := c.sessions.Get(ctx, &session.GetRequest{
+		AppName:   c.session.AppName(),
+		UserID:    c.session.UserID(),
+		SessionID: c.session.ID(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+
+	saved := savedSession{System: c.system, Events: []*session.Event{}}
+	if c.cfg.Model != nil {
+		saved.Model = c.cfg.Model.Name()
+	}
+	for event := range resp.Session.Events().All() {
+		saved.Events = append(saved.Events, event)
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	fmt.Fprintf(c.out, "Saved %d events to %s\n", len(saved.Events), path)
+	return nil
+}
+
+// loadSession replaces the session with one read from path, restoring its
+// system instruction
+func (c *chat) loadSession(ctx context.Context, path string) error {
+	if path == "" {
+		return fmt.Errorf("usage: /load <file>")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read session file: %w", err)
+	}
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to decode session file: %w", err)
+	}
+
+	if saved.System != c.system {
+		previous := c.system
+		c.system = saved.System
+		if err := c.rebuild(); err != nil {
+			c.system = previous
+			return err
+		}
+	}
+	if err := c.reset(ctx); err != nil {
+		return err
+	}
+	planState := false // Whether the loaded events switch plan mode
+	for _, event := range saved.Events {
+		if err := c.sessions.AppendEvent(ctx, c.session, event); err != nil {
+			return fmt.Errorf("failed to restore session: %w", err)
+		}
+		_, ok := event.Actions.StateDelta[plan.StateKey]
+		planState = planState || ok
+	}
+	// The chat keeps its own plan mode
+	if planState {
+		if err := c.applyPlanning(ctx, c.planning); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(c.out, "Loaded %d events from %s\n", len(saved.Events), path)
+	if c.cfg.Model != nil && saved.Model != "" && saved.Model != c.cfg.Model.Name() {
+		fmt.Fprintf(c.out, "The session was saved with model %s, use /model to switch\n", saved.Model)
+	}
+	return nil
+}
+
+// send runs one turn and prints the agent's reply. Ctrl-C cancels the turn
+// instead of exiting.
+func (c *chat) send(ctx context.Context, text string) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	mode := agent.StreamingModeNone
+	if c.stream {
+		mode = agent.StreamingModeSSE
+	}
+	msg := genai.NewContentFromText(text, genai.RoleUser)
+
+	err := writeReply(c.out, c.out, c.runner.Run(ctx, c.userID, c.session.ID(), msg, agent.RunConfig{StreamingMode: mode}))
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		fmt.Fprintln(c.out, "Interrupted.")
+	default:
+		fmt.Fprintf(c.out, "Error: %v\n", err)
+	}
+}
+
+// writeReply prints the agent's text to out as it streams, and tool calls to
+// notes. It stops at the first error, which is either returned by the runner
+// or reported in an event.
+func writeReply(out, notes io.Writer, events iter.Seq2[*session.Event, error]) error {
+	streamed := false // Text of the current response was already printed in partial events
+	for event, err := range events {
+		if err == nil && event.ErrorMessage != "" {
+			err = fmt.Errorf("%s: %s", cmp.Or(event.ErrorCode, "model error"), event.ErrorMessage)
+		}
+		if err != nil {
+			if streamed {
+				fmt.Fprintln(out)
+			}
+			return err
+		}
+		if event.Content == nil {
+			continue
+		}
+
+		for _, part := range event.Content.Parts {
+			switch {
+			case part.Text != "" && !part.Thought:
+				if !streamed || event.Partial {
+					fmt.Fprint(out, part.Text)
+				}
+			case part.FunctionCall != nil:
+				fmt.Fprintf(notes, "[calling %s]\n", part.FunctionCall.Name)
+			case part.FunctionResponse != nil:
+				fmt.Fprintf(notes, "[%s returned]\n", part.FunctionResponse.Name)
+			}
+		}
+		if event.Partial {
+			streamed = true
+		} else {
+			if hasText(event.Content) {
+				fmt.Fprintln(out)
+			}
+			streamed = false
+		}
+	}
+	return nil
+}
+
+// hasText reports whether content has any visible text
+func hasText(content *genai.Content) bool {
+	for _, part := range content.Parts {
+		if part.Text != "" && !part.Thought {
+			return true
+		}
+	}
+	return false
+}
+
+// SwitchableModel is a model.LLM whose underlying model can be replaced at
+// runtime, so decorators wrapped around it survive a /model switch. It
+// counts the calls in flight on each model, so a replaced one can be drained.
+type SwitchableModel struct {
+	mu       sync.Mutex
+	gen      *generation
+	draining map[*generation]struct{} // Replaced models with calls in flight
+}
+
+// generation is a model and the calls in flight on it
+type generation struct {
+	llm     model.LLM
+	active  int
+	drained chan struct{} // Closed once replaced with no calls in flight
+}
+
+// NewSwitchableModel wraps llm
+func NewSwitchableModel(llm model.LLM) *SwitchableModel {
+	return &SwitchableModel{
+		gen:      &generation{llm: llm, drained: make(chan struct{})},
+		draining: make(map[*generation]struct{}),
+	}
+}
+
+// Set replaces the underlying model, calls in progress finish on the old one
+func (s *SwitchableModel) Set(llm model.LLM) {
+	s.replace(llm)
+}
+
+// Swap replaces the underlying model like Set, then waits until the calls in
+// progress on the old one finish. It returns ctx's error when they did not
+// finish in time; the new model is used either way.
+func (s *SwitchableModel) Swap(ctx context.Context, llm model.LLM) error {
+	old := s.replace(llm)
+	select {
+	case <-old.drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("calls still in flight on %s: %w", old.llm.Name(), ctx.Err())
+	}
+}
+
+// replace makes llm the current model and returns the previous generation
+func (s *SwitchableModel) replace(llm model.LLM) *generation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.gen
+	s.gen = &generation{llm: llm, drained: make(chan struct{})}
+	if old.active == 0 {
+		close(old.drained)
+	} else {
+		s.draining[old] = struct{}{}
+	}
+	return old
+}
+
+// InFlight returns the number of calls in progress on the current model and
+// on replaced ones still draining
+func (s *SwitchableModel) InFlight() (current, draining int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for g := range s.draining {
+		draining += g.active
+	}
+	return s.gen.active, draining
+}
+
+// Current returns the underlying model new calls go to
+func (s *SwitchableModel) Current() model.LLM {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gen.llm
+}
+
+// acquire counts a call on the current model
+func (s *SwitchableModel) acquire() *generation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen.active++
+	return s.gen
+}
+
+// release ends a call, completing the drain of a replaced model
+func (s *SwitchableModel) release(g *generation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.active--
+	if _, ok := s.draining[g]; ok && g.active == 0 {
+		delete(s.draining, g)
+		close(g.drained)
+	}
+}
+
+// Name implements model.LLM
+func (s *SwitchableModel) Name() string {
+	return s.Current().Name()
+}
+
+// GenerateContent implements model.LLM. The model is picked when the call
+// starts, and the call counts as in flight until the iteration ends.
+func (s *SwitchableModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		g := s.acquire()
+		defer s.release(g)
+		for resp, err := range g.llm.GenerateContent(ctx, req, stream) {
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/checkpoint"
+	"github.com/gopher-9527/yanshu/agent/pkg/plan"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// echoModel replies with the last user message, streamed in two chunks
+type echoModel struct {
+	name     string
+	requests []*model.LLMRequest
+}
+
+func (m *echoModel) Name() string { return m.name }
+
+func (m *echoModel) GenerateContent(_ context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	m.requests = append(m.requests, req)
+	last := req.Contents[len(req.Contents)-1].Parts[0].Text
+	reply := m.name + ": " + last
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if stream {
+			half := len(reply) / 2
+			for _, chunk := range []string{reply[:half], reply[half:]} {
+				if !yield(&model.LLMResponse{Content: genai.NewContentFromText(chunk, genai.RoleModel), Partial: true}, nil) {
+					return
+				}
+			}
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(reply, genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// TestChat tests streaming replies, multi-line input and the slash commands
+func TestChat(t *testing.T) {
+	base := &echoModel{name: "base"}
+	switchable := NewSwitchableModel(base)
+	var instructions []string
+	cfg := &ChatConfig{
+		NewAgent: func(system string) (agent.Agent, error) {
+			instructions = append(instructions, system)
+			return llmagent.New(llmagent.Config{Name: "test", Model: switchable, Instruction: system})
+		},
+		Model: switchable,
+		NewModel: func(_ context.Context, name string) (model.LLM, error) {
+			return &echoModel{name: name}, nil
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	input := strings.Join([]string{
+		"hello",
+		`"""`, "line one", "line two", `"""`,
+		`first \`, "second",
+		"/system Be brief.",
+		"/save " + path,
+		"/reset",
+		"/load " + path,
+		"/model other",
+		"again",
+		"/bogus",
+		"/exit",
+		"ignored",
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	c := newChat(cfg, "user", true, strings.NewReader(input), &out)
+	if err := c.start(context.Background()); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	if err := c.loop(context.Background()); err != nil {
+		t.Fatalf("loop() error = %v", err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"base: hello\n",
+		"base: line one\nline two\n",
+		"base: first \nsecond\n",
+		"System instruction updated.",
+		"Saved 6 events to " + path,
+		"Loaded 6 events from " + path,
+		"Switched to model other",
+		"other: again\n",
+		"Error: unknown command /bogus",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "ignored") {
+		t.Errorf("input after /exit was processed:\n%s", got)
+	}
+	if strings.Count(got, "base: hello") != 1 {
+		t.Errorf("streamed reply printed more than once:\n%s", got)
+	}
+
+	// The loaded session carries the history and the system instruction
+	other := switchable.Current().(*echoModel)
+	if len(other.requests) != 1 || len(other.requests[0].Contents) != 7 {
+		t.Fatalf("model after load got %d requests, want 1 with 7 contents", len(other.requests))
+	}
+	if want := []string{"", "Be brief."}; len(instructions) != 2 || instructions[1] != want[1] {
+		t.Errorf("agent instructions = %q, want %q", instructions, want)
+	}
+	if base.requests[0].Config.SystemInstruction != nil {
+		t.Errorf("first request has a system instruction, want none")
+	}
+}
+
+// TestChatNoStream tests printing complete replies without streaming
+func TestChatNoStream(t *testing.T) {
+	llm := &echoModel{name: "base"}
+	cfg := &ChatConfig{
+		NewAgent: func(string) (agent.Agent, error) {
+			return llmagent.New(llmagent.Config{Name: "test", Model: llm})
+		},
+	}
+
+	var out bytes.Buffer
+	c := newChat(cfg, "user", false, strings.NewReader("hi\n/model gpt\n"), &out)
+	if err := c.start(context.Background()); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	if err := c.loop(context.Background()); err != nil {
+		t.Fatalf("loop() error = %v", err)
+	}
+
+	want := "> base: hi\n> Error: model switching is not available\n> \n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+// TestChatPlan tests switching plan mode and approving a plan
+func TestChatPlan(t *testing.T) {
+	llm := &echoModel{name: "base"}
+	planner, err := plan.New(&plan.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ChatConfig{
+		NewAgent: func(string) (agent.Agent, error) {
+			return llmagent.New(llmagent.Config{
+				Name:                 "test",
+				Model:                llm,
+				BeforeModelCallbacks: []llmagent.BeforeModelCallback{planner.ModelCallback()},
+			})
+		},
+	}
+
+	input := "/execute\n/plan on\n/plan\nhi\n/execute\n/reset\n/plan off\nbye\n"
+	var out bytes.Buffer
+	c := newChat(cfg, "user", false, strings.NewReader(input), &out)
+	if err := c.start(context.Background()); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	if err := c.loop(context.Background()); err != nil {
+		t.Fatalf("loop() error = %v", err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"Error: plan mode is off",
+		"Plan mode is on: tools with side effects are planned",
+		"> Plan mode is on.\n",
+		"base: hi\n",
+		"There is no plan waiting for approval.",
+		"Plan mode is off.",
+		"base: bye\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+
+	if len(llm.requests) != 2 {
+		t.Fatalf("model got %d requests, want 2", len(llm.requests))
+	}
+	planned := llm.requests[0].Config.SystemInstruction
+	if planned == nil || !strings.Contains(planned.Parts[len(planned.Parts)-1].Text, "Plan mode is on") {
+		t.Errorf("planning request was not told about plan mode")
+	}
+	if after := llm.requests[1].Config.SystemInstruction; after != nil {
+		t.Errorf("request after /plan off has system instruction %+v", after)
+	}
+}
+
+// TestChatRollback tests rolling the session back to the checkpoint of an earlier turn
+func TestChatRollback(t *testing.T) {
+	llm := &echoModel{name: "base"}
+	sessions, err := checkpoint.New(&checkpoint.Config{Sessions: session.InMemoryService(), Auto: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ChatConfig{
+		NewAgent: func(string) (agent.Agent, error) {
+			return llmagent.New(llmagent.Config{Name: "test", Model: llm})
+		},
+		SessionService: sessions,
+	}
+
+	input := "one\n/checkpoint mine\ntwo\n/checkpoints\n/rollback\nthree\n/rollback 9\n"
+	var out bytes.Buffer
+	c := newChat(cfg, "user", false, strings.NewReader(input), &out)
+	if err := c.start(context.Background()); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	if err := c.loop(context.Background()); err != nil {
+		t.Fatalf("loop() error = %v", err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"Saved checkpoint 2 at 2 events",
+		"   2  ",
+		"mine\n",
+		"after a turn\n",
+		"Rolled back to checkpoint 2, dropping 2 events",
+		"Error: checkpoint 9 of session",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+	// The turn after the rollback no longer sees "two"
+	last := llm.requests[len(llm.requests)-1]
+	if len(last.Contents) != 3 {
+		t.Errorf("request after rollback has %d contents, want 3", len(last.Contents))
+	}
+}
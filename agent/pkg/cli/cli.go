@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"flag"
+	"strings"
+)
+
+// flagUsage formats the defaults of a flag set for CommandLineSyntax
+func flagUsage(fs *flag.FlagSet) string {
+	var b strings.Builder
+	o := fs.Output()
+	fs.SetOutput(&b)
+	fs.PrintDefaults()
+	fs.SetOutput(o)
+	return b.String()
+}
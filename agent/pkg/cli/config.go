@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+)
+
+// ConfigUsage describes the config subcommands
+const ConfigUsage = `config validate [-config path]  checks every field and exits non-zero on problems
+config print [-config path]     prints the effective configuration as YAML with secrets masked`
+
+// RunConfig runs `config validate` or `config print` on the configuration at
+// path with overrides applied. It runs before the agent is built, so it works
+// on broken configurations.
+func RunConfig(path string, overrides *config.Overrides, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing config subcommand, usage:\n%s", ConfigUsage)
+	}
+	name, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("config "+name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&path, "config", path, "Configuration file")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse config flags: %w\n%s", err, flagUsage(fs))
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	switch name {
+	case "validate":
+		return validateConfig(path, overrides, out)
+	case "print":
+		cfg, err := config.Parse(path, overrides)
+		if err != nil {
+			return err
+		}
+		return printResult(out, OutputYAML, cfg.Redacted())
+	default:
+		return fmt.Errorf("unknown config subcommand %q, usage:\n%s", name, ConfigUsage)
+	}
+}
+
+// validateConfig reports every problem in the configuration at path
+func validateConfig(path string, overrides *config.Overrides, out io.Writer) error {
+	cfg, err := config.Parse(path, overrides)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		fmt.Fprintf(out, "%s is valid\n", path)
+		return nil
+	}
+
+	problems := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		problems = joined.Unwrap()
+	}
+	for _, p := range problems {
+		fmt.Fprintf(out, "  - %v\n", p)
+	}
+	return fmt.Errorf("%s has %d problem(s)", path, len(problems))
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/config"
+)
+
+// TestRunConfig tests validating and printing a configuration file
+func TestRunConfig(t *testing.T) {
+	for _, env := range []string{"DEEPSEEK_API_KEY", "MODEL_NAME", "MODEL_BASE_URL", "LOG_LEVEL", "ADMIN_TOKEN"} {
+		t.Setenv(env, "")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	valid := write("valid.yaml", "model:\n  api_key: sk-1234567890abcdef\n  headers:\n    X-Gateway-Key: gw-secret-value\n")
+	var out bytes.Buffer
+	if err := RunConfig(valid, nil, []string{"validate"}, &out); err != nil {
+		t.Fatalf("validate error = %v\n%s", err, out.String())
+	}
+
+	// Flags take precedence over the environment
+	t.Setenv("MODEL_NAME", "env-model")
+	out.Reset()
+	if err := RunConfig("missing.yaml", &config.Overrides{ModelName: "flag-model", Port: 9090}, []string{"print", "-config", valid}, &out); err != nil {
+		t.Fatalf("print error = %v", err)
+	}
+	printed := out.String()
+	if strings.Contains(printed, "sk-1234567890abcdef") || strings.Contains(printed, "gw-secret-value") {
+		t.Errorf("print leaked a secret:\n%s", printed)
+	}
+	for _, want := range []string{"api_key: sk-1****", "X-Gateway-Key: gw-s****", "model_name: flag-model", "port: 9090"} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("print missing %q:\n%s", want, printed)
+		}
+	}
+
+	t.Setenv("MODEL_NAME", "")
+	broken := write("broken.yaml", `model:
+  provider: acme
+  timeout: 5 minutes
+server:
+  port: 70000
+logging:
+  level: verbose
+budget:
+  monthly: 50
+`)
+	out.Reset()
+	err := RunConfig(broken, nil, []string{"validate"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "6 problem(s)") {
+		t.Errorf("validate error = %v, want 6 problems", err)
+	}
+	for _, want := range []string{
+		"model: API key is required",
+		`model.provider: unknown provider "acme"`,
+		`model.timeout: invalid duration "5 minutes"`,
+		"server.port: 70000 is not a valid port",
+		`logging.level: invalid value "verbose"`,
+		"budget.monthly: requires usage.enabled",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("validate output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if err := RunConfig(valid, nil, []string{"check"}, &out); err == nil {
+		t.Errorf("unknown subcommand succeeded")
+	}
+}
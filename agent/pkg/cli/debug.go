@@ -0,0 +1,457 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+)
+
+// DebugConfig holds the hooks the debug command uses to instrument the agent
+type DebugConfig struct {
+	// NewAgent builds the agent with the debugger's tool callbacks, before runs
+	// ahead of any other before-tool callback
+	NewAgent func(before llmagent.BeforeToolCallback, after llmagent.AfterToolCallback) (agent.Agent, error)
+	// Model is replaced by a stepping wrapper for the replay
+	Model *SwitchableModel
+}
+
+// debugLauncher replays a turn of a saved chat session step by step
+type debugLauncher struct {
+	flags *flag.FlagSet
+	cfg   DebugConfig
+	turn  int
+	path  string
+}
+
+// NewDebugLauncher creates the `debug` subcommand
+func NewDebugLauncher(cfg *DebugConfig) launcher.SubLauncher {
+	l := &debugLauncher{}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	fs.IntVar(&l.turn, "turn", 0, "Turn to replay, counting user messages from 1; 0 replays the last")
+	l.flags = fs
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *debugLauncher) Keyword() string {
+	return "debug"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *debugLauncher) SimpleDescription() string {
+	return "replays a turn of a session saved with /save step by step, to edit prompts and tool results"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *debugLauncher) CommandLineSyntax() string {
+	return "debug [-turn N] <session.json>\n" + flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *debugLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse debug flags: %w", err)
+	}
+	if l.flags.NArg() != 1 {
+		return nil, fmt.Errorf("debug needs one session file")
+	}
+	if l.turn < 0 {
+		return nil, fmt.Errorf("turn cannot be negative")
+	}
+	l.path = l.flags.Arg(0)
+	return nil, nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *debugLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.cfg.NewAgent == nil || l.cfg.Model == nil {
+		return fmt.Errorf("debug is not configured")
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read session file: %w", err)
+	}
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to decode session file: %w", err)
+	}
+	return replayTurn(ctx, &l.cfg, saved.Events, l.turn, os.Stdin, os.Stdout)
+}
+
+// recordedTurn is a turn of a saved session
+type recordedTurn struct {
+	history   []*session.Event // Events before the turn
+	user      *session.Event   // User message starting the turn
+	responses []*genai.Content // Model responses, in order
+	results   map[string][]map[string]any
+}
+
+// findTurn splits events around the turn-th user message, 0 picking the last
+func findTurn(events []*session.Event, turn int) (*recordedTurn, error) {
+	var starts []int
+	for i, event := range events {
+		if event.Author == "user" && event.Content != nil && hasText(event.Content) {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("the session has no turns")
+	}
+	if turn == 0 {
+		turn = len(starts)
+	}
+	if turn > len(starts) {
+		return nil, fmt.Errorf("the session has %d turns, cannot replay turn %d", len(starts), turn)
+	}
+
+	start, end := starts[turn-1], len(events)
+	if turn < len(starts) {
+		end = starts[turn]
+	}
+	t := &recordedTurn{
+		history: events[:start],
+		user:    events[start],
+		results: make(map[string][]map[string]any),
+	}
+	for _, event := range events[start+1 : end] {
+		if event.Content == nil || event.Partial {
+			continue
+		}
+		// Tool results are stored as function responses, everything else is a model response
+		isResult := false
+		for _, part := range event.Content.Parts {
+			if r := part.FunctionResponse; r != nil {
+				t.results[r.Name] = append(t.results[r.Name], r.Response)
+				isResult = true
+			}
+		}
+		if !isResult {
+			t.responses = append(t.responses, event.Content)
+		}
+	}
+	return t, nil
+}
+
+// replayTurn reruns a turn of events on a fresh session, stopping at every
+// model call and tool call
+func replayTurn(ctx context.Context, cfg *DebugConfig, events []*session.Event, turn int, in io.Reader, out io.Writer) error {
+	recorded, err := findTurn(events, turn)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d := &debugger{
+		in:       bufio.NewReader(in),
+		out:      out,
+		quit:     cancel,
+		live:     cfg.Model.Current(),
+		recorded: recorded,
+	}
+	cfg.Model.Set(d)
+	defer cfg.Model.Set(d.live)
+
+	a, err := cfg.NewAgent(d.beforeTool, d.afterTool)
+	if err != nil {
+		return fmt.Errorf("failed to create agent: %w", err)
+	}
+	sessions := session.InMemoryService()
+	r, err := runner.New(runner.Config{AppName: a.Name(), Agent: a, SessionService: sessions})
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	resp, err := sessions.Create(ctx, &session.CreateRequest{AppName: a.Name(), UserID: "user"})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	for _, event := range recorded.history {
+		if err := sessions.AppendEvent(ctx, resp.Session, event); err != nil {
+			return fmt.Errorf("failed to restore session: %w", err)
+		}
+	}
+
+	fmt.Fprintf(out, "Replaying turn with %d earlier events, %d model responses and %d tool results recorded.\n",
+		len(recorded.history), len(recorded.responses), d.countResults())
+	d.printContent("user", recorded.user.Content)
+	fmt.Fprint(out, debugHelp)
+
+	// The debugger prints every step, so only errors are taken from the events
+	err = writeReply(io.Discard, io.Discard, r.Run(ctx, "user", resp.Session.ID(), recorded.user.Content, agent.RunConfig{}))
+	switch {
+	case d.quitting:
+		fmt.Fprintln(out, "Replay stopped.")
+		return nil
+	case err != nil:
+		return fmt.Errorf("replay failed: %w", err)
+	}
+	fmt.Fprintln(out, "Replay finished.")
+	return nil
+}
+
+const debugHelp = `At each step: Enter or c continues live, r uses the recorded result, e edits, p prints the full request, q quits.
+`
+
+// debugger steps through the model and tool calls of a turn. It wraps the
+// live model, and its tool callbacks are installed on the agent.
+type debugger struct {
+	in       *bufio.Reader
+	out      io.Writer
+	quit     context.CancelFunc
+	quitting bool
+	live     model.LLM
+	recorded *recordedTurn
+	step     int
+	calls    int // Model calls so far, indexing the recorded responses
+}
+
+// Name implements model.LLM
+func (d *debugger) Name() string {
+	return d.live.Name()
+}
+
+// GenerateContent implements model.LLM. The request can be edited before it
+// is sent, or the recorded response used instead.
+func (d *debugger) GenerateContent(ctx context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		d.step++
+		var recorded *genai.Content
+		if d.calls < len(d.recorded.responses) {
+			recorded = d.recorded.responses[d.calls]
+		}
+		d.calls++
+
+		fmt.Fprintf(d.out, "\nStep %d: model call with %d contents\n", d.step, len(req.Contents))
+		if n := len(req.Contents); n > 0 {
+			d.printContent("last", req.Contents[n-1])
+		}
+		if recorded != nil {
+			d.printContent("recorded", recorded)
+		}
+
+		for {
+			switch d.ask("[c]ontinue, [r]ecorded, [e]dit prompt, edit [s]ystem, [p]rint, [q]uit") {
+			case "c":
+				for resp, err := range d.live.GenerateContent(ctx, req, false) {
+					if err == nil && resp.Content != nil && !resp.Partial {
+						d.printContent("model", resp.Content)
+					}
+					if !yield(resp, err) {
+						return
+					}
+				}
+				return
+			case "r":
+				if recorded == nil {
+					fmt.Fprintln(d.out, "No recorded response for this step.")
+					continue
+				}
+				yield(&model.LLMResponse{Content: recorded, TurnComplete: true}, nil)
+				return
+			case "e":
+				d.editPrompt(req)
+			case "s":
+				d.editSystem(req)
+			case "p":
+				d.printRequest(req)
+			case "q":
+				d.stop()
+				yield(nil, context.Canceled)
+				return
+			}
+		}
+	}
+}
+
+// beforeTool offers to skip a tool call with its recorded result
+func (d *debugger) beforeTool(_ tool.Context, t tool.Tool, args map[string]any) (map[string]any, error) {
+	d.step++
+	results := d.recorded.results[t.Name()]
+	var recorded map[string]any
+	if len(results) > 0 {
+		recorded = results[0]
+		d.recorded.results[t.Name()] = results[1:]
+	}
+
+	fmt.Fprintf(d.out, "\nStep %d: tool %s %s\n", d.step, t.Name(), toJSON(args))
+	if recorded != nil {
+		fmt.Fprintf(d.out, "  recorded: %s\n", toJSON(recorded))
+	}
+	for {
+		switch d.ask("[c]ontinue, [r]ecorded, [q]uit") {
+		case "c":
+			return nil, nil
+		case "r":
+			if recorded == nil {
+				fmt.Fprintln(d.out, "No recorded result for this call.")
+				continue
+			}
+			return recorded, nil
+		case "q":
+			d.stop()
+			return nil, context.Canceled
+		}
+	}
+}
+
+// afterTool shows a tool result and lets it be replaced
+func (d *debugger) afterTool(_ tool.Context, t tool.Tool, _, result map[string]any, err error) (map[string]any, error) {
+	if d.quitting {
+		return nil, nil
+	}
+	if err != nil {
+		fmt.Fprintf(d.out, "  %s failed: %v\n", t.Name(), err)
+	} else {
+		fmt.Fprintf(d.out, "  result: %s\n", toJSON(result))
+	}
+
+	for {
+		switch d.ask("[c]ontinue, [e]dit result, [q]uit") {
+		case "c":
+			return nil, nil
+		case "e":
+			text := d.readBlock("New result as a JSON object")
+			var edited map[string]any
+			if err := json.Unmarshal([]byte(text), &edited); err != nil {
+				fmt.Fprintf(d.out, "Invalid JSON object: %v\n", err)
+				continue
+			}
+			return edited, nil
+		case "q":
+			d.stop()
+			return nil, context.Canceled
+		}
+	}
+}
+
+// ask prompts for a step command. The end of input continues live so a
+// replay can run unattended.
+func (d *debugger) ask(options string) string {
+	for {
+		fmt.Fprintf(d.out, "%s > ", options)
+		line, err := d.in.ReadString('\n')
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" && errors.Is(err, io.EOF) {
+			fmt.Fprintln(d.out)
+			return "c"
+		}
+		if line == "" {
+			return "c"
+		}
+		if strings.Contains(options, "["+line[:1]+"]") {
+			return line[:1]
+		}
+		fmt.Fprintf(d.out, "Unknown command %q\n", line)
+	}
+}
+
+// readBlock reads lines up to a line with a single dot
+func (d *debugger) readBlock(prompt string) string {
+	fmt.Fprintf(d.out, "%s, end with a line with a single dot:\n", prompt)
+	var lines []string
+	for {
+		line, err := d.in.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." {
+			break
+		}
+		lines = append(lines, line)
+		if err != nil {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// editPrompt replaces the text of the latest user message in req
+func (d *debugger) editPrompt(req *model.LLMRequest) {
+	for i := len(req.Contents) - 1; i >= 0; i-- {
+		c := req.Contents[i]
+		if c.Role != genai.RoleUser || !hasText(c) {
+			continue
+		}
+		d.printContent("current", c)
+		req.Contents[i] = genai.NewContentFromText(d.readBlock("New prompt"), genai.RoleUser)
+		fmt.Fprintln(d.out, "Prompt updated.")
+		return
+	}
+	fmt.Fprintln(d.out, "The request has no user message to edit.")
+}
+
+// editSystem replaces the system instruction of req
+func (d *debugger) editSystem(req *model.LLMRequest) {
+	if req.Config == nil {
+		req.Config = &genai.GenerateContentConfig{}
+	}
+	if req.Config.SystemInstruction != nil {
+		d.printContent("current", req.Config.SystemInstruction)
+	}
+	req.Config.SystemInstruction = genai.NewContentFromText(d.readBlock("New system instruction"), genai.RoleUser)
+	fmt.Fprintln(d.out, "System instruction updated.")
+}
+
+// printRequest prints the system instruction and every content of req
+func (d *debugger) printRequest(req *model.LLMRequest) {
+	if req.Config != nil && req.Config.SystemInstruction != nil {
+		d.printContent("system", req.Config.SystemInstruction)
+	}
+	for _, c := range req.Contents {
+		d.printContent(c.Role, c)
+	}
+}
+
+// printContent prints the text, calls and responses of c under a label
+func (d *debugger) printContent(label string, c *genai.Content) {
+	for _, part := range c.Parts {
+		switch {
+		case part.Text != "":
+			fmt.Fprintf(d.out, "  %s: %s\n", label, part.Text)
+		case part.FunctionCall != nil:
+			fmt.Fprintf(d.out, "  %s: call %s %s\n", label, part.FunctionCall.Name, toJSON(part.FunctionCall.Args))
+		case part.FunctionResponse != nil:
+			fmt.Fprintf(d.out, "  %s: %s returned %s\n", label, part.FunctionResponse.Name, toJSON(part.FunctionResponse.Response))
+		}
+	}
+}
+
+func (d *debugger) countResults() int {
+	n := 0
+	for _, results := range d.recorded.results {
+		n += len(results)
+	}
+	return n
+}
+
+func (d *debugger) stop() {
+	d.quitting = true
+	d.quit()
+}
+
+// toJSON encodes v for display
+func toJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
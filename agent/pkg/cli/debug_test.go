@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// lookupModel looks up the user message, then answers with the tool result
+type lookupModel struct{}
+
+func (lookupModel) Name() string { return "lookup-model" }
+
+func (lookupModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		last := req.Contents[len(req.Contents)-1].Parts[0]
+		if r := last.FunctionResponse; r != nil {
+			yield(&model.LLMResponse{Content: genai.NewContentFromText(fmt.Sprintf("answer: %v", r.Response["value"]), genai.RoleModel)}, nil)
+			return
+		}
+		call := &genai.Part{FunctionCall: &genai.FunctionCall{Name: "lookup", Args: map[string]any{"q": last.Text}}}
+		yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{call}}}, nil)
+	}
+}
+
+type lookupQuery struct {
+	Q string `json:"q"`
+}
+
+// TestReplayTurn tests stepping through a recorded turn with edits
+func TestReplayTurn(t *testing.T) {
+	lookups := 0
+	lookup, err := functiontool.New(functiontool.Config{Name: "lookup", Description: "Looks up a value"},
+		func(_ tool.Context, args lookupQuery) (map[string]any, error) {
+			lookups++
+			return map[string]any{"value": "live-" + args.Q}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	switchable := NewSwitchableModel(lookupModel{})
+	cfg := &DebugConfig{
+		NewAgent: func(before llmagent.BeforeToolCallback, after llmagent.AfterToolCallback) (agent.Agent, error) {
+			return llmagent.New(llmagent.Config{
+				Name:                "test",
+				Model:               switchable,
+				Tools:               []tool.Tool{lookup},
+				BeforeToolCallbacks: []llmagent.BeforeToolCallback{before},
+				AfterToolCallbacks:  []llmagent.AfterToolCallback{after},
+			})
+		},
+		Model: switchable,
+	}
+
+	// Record two turns the way /save stores them
+	ctx := context.Background()
+	a, _ := llmagent.New(llmagent.Config{Name: "test", Model: switchable, Tools: []tool.Tool{lookup}})
+	sessions := session.InMemoryService()
+	r, _ := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions})
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "user"})
+	for _, text := range []string{"first", "second"} {
+		if err := writeReply(&bytes.Buffer{}, &bytes.Buffer{}, r.Run(ctx, "user", created.Session.ID(), genai.NewContentFromText(text, genai.RoleUser), agent.RunConfig{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := sessions.Get(ctx, &session.GetRequest{AppName: "test", UserID: "user", SessionID: created.Session.ID()})
+	var events []*session.Event
+	for event := range got.Session.Events().All() {
+		events = append(events, event)
+	}
+	lookups = 0
+
+	// Reuse the recorded call and result, then edit the result before the model sees it
+	input := "r\nr\ne\n{\"value\": \"edited\"}\n.\n\n"
+	var out bytes.Buffer
+	if err := replayTurn(ctx, cfg, events, 1, strings.NewReader(input), &out); err != nil {
+		t.Fatalf("replayTurn() error = %v", err)
+	}
+	for _, want := range []string{
+		"Replaying turn with 0 earlier events, 2 model responses and 1 tool results recorded.",
+		"user: first",
+		`recorded: call lookup {"q":"first"}`,
+		`Step 2: tool lookup {"q":"first"}`,
+		`result: {"value":"live-first"}`,
+		"model: answer: edited",
+		"Replay finished.",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+	if lookups != 0 {
+		t.Errorf("tool ran %d times, want the recorded result", lookups)
+	}
+	if switchable.Current() != (lookupModel{}) {
+		t.Errorf("replay did not restore the live model")
+	}
+
+	// Edit the prompt of the last turn and run everything live
+	out.Reset()
+	input = "e\nthird\n.\nc\n"
+	if err := replayTurn(ctx, cfg, events, 0, strings.NewReader(input), &out); err != nil {
+		t.Fatalf("replayTurn() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "current: second") || !strings.Contains(out.String(), "model: answer: live-third") {
+		t.Errorf("edited prompt was not replayed:\n%s", out.String())
+	}
+	if lookups != 1 {
+		t.Errorf("tool ran %d times, want 1", lookups)
+	}
+
+	out.Reset()
+	if err := replayTurn(ctx, cfg, events, 2, strings.NewReader("q\n"), &out); err != nil {
+		t.Fatalf("replayTurn() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "Replay stopped.") {
+		t.Errorf("quit output:\n%s", out.String())
+	}
+
+	if err := replayTurn(ctx, cfg, events, 3, strings.NewReader(""), &out); err == nil {
+		t.Error("replayTurn() of a missing turn succeeded")
+	}
+}
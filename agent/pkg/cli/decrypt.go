@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// decryptLauncher decrypts files encrypted at rest, such as session
+// archives, artifacts and model dumps
+type decryptLauncher struct {
+	flags  *flag.FlagSet
+	sealer *envelope.Sealer
+	output string
+	files  []string
+}
+
+// NewDecryptLauncher creates the `decrypt` subcommand opening files with sealer
+func NewDecryptLauncher(sealer *envelope.Sealer) launcher.SubLauncher {
+	l := &decryptLauncher{sealer: sealer}
+
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	fs.StringVar(&l.output, "o", "-", "File to write the decrypted data to ('-' for stdout)")
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *decryptLauncher) Keyword() string {
+	return "decrypt"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *decryptLauncher) SimpleDescription() string {
+	return "decrypts a session archive, artifact or model dump encrypted at rest"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *decryptLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *decryptLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse decrypt flags: %w", err)
+	}
+	if l.flags.NArg() != 1 {
+		return nil, fmt.Errorf("decrypt takes one file")
+	}
+	l.files = l.flags.Args()
+	return nil, nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *decryptLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.sealer == nil {
+		return fmt.Errorf("encryption is disabled (set encryption.enabled in config)")
+	}
+	data, err := os.ReadFile(l.files[0])
+	if err != nil {
+		return err
+	}
+	if !envelope.Sealed(data) {
+		return fmt.Errorf("%s is not encrypted", l.files[0])
+	}
+	_, plain, err := l.sealer.OpenAny(ctx, data)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", l.files[0], err)
+	}
+
+	var out io.Writer = os.Stdout
+	if l.output != "-" {
+		f, err := os.OpenFile(l.output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = out.Write(plain)
+	return err
+}
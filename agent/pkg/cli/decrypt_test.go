@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+)
+
+// TestDecrypt tests decrypting a file sealed at rest
+func TestDecrypt(t *testing.T) {
+	keys, err := envelope.NewLocalKeys(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := envelope.New(&envelope.Config{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealer.Seal(context.Background(), sealer.Tenant("app", "alice"), []byte("archived"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "s1.json.gz"), filepath.Join(dir, "plain")
+	os.WriteFile(in, sealed, 0o600)
+
+	l := NewDecryptLauncher(sealer)
+	if _, err := l.Parse([]string{"-o", out, in}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := l.Run(context.Background(), nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "archived" {
+		t.Errorf("decrypted file = %q, want archived", got)
+	}
+
+	os.WriteFile(in, []byte("plain"), 0o600)
+	if err := l.Run(context.Background(), nil); err == nil {
+		t.Error("Run() on an unencrypted file succeeded")
+	}
+	if err := NewDecryptLauncher(nil).Run(context.Background(), nil); err == nil {
+		t.Error("Run() without encryption succeeded")
+	}
+}
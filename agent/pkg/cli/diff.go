@@ -0,0 +1,357 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/session"
+)
+
+// DiffConfig holds the hook the diff command uses to build the compared agents
+type DiffConfig struct {
+	// NewAgent builds the named agent, the root one when name is empty, with
+	// instruction replacing the configured one when not empty
+	NewAgent func(name, instruction string) (agent.Agent, error)
+	// ModelName returns the model of the named agent, optional
+	ModelName func(name string) string
+}
+
+// diffSide is one configuration compared by diff
+type diffSide struct {
+	agent       string
+	instruction string // Text, or @path to read it from a file
+}
+
+// diffLauncher runs a prompt against two configurations and compares the answers
+type diffLauncher struct {
+	flags  *flag.FlagSet
+	cfg    DiffConfig
+	a, b   diffSide
+	prompt string
+	userID string
+	stdin  bool
+	output string
+}
+
+// NewDiffLauncher creates the `diff` subcommand
+func NewDiffLauncher(cfg *DiffConfig) launcher.SubLauncher {
+	l := &diffLauncher{}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.StringVar(&l.a.agent, "a", "", "Agent of side A, defaults to the root agent")
+	fs.StringVar(&l.b.agent, "b", "", "Agent of side B, defaults to the root agent")
+	fs.StringVar(&l.a.instruction, "a-instruction", "", "Instruction replacing side A's, or @file to read it")
+	fs.StringVar(&l.b.instruction, "b-instruction", "", "Instruction replacing side B's, or @file to read it")
+	fs.StringVar(&l.userID, "user", "user", "User ID of the sessions")
+	fs.BoolVar(&l.stdin, "stdin", true, "Append piped stdin to the prompt")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *diffLauncher) Keyword() string {
+	return "diff"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *diffLauncher) SimpleDescription() string {
+	return "runs a prompt against two agents or instructions and diffs the answers, tokens and latency"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *diffLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *diffLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse diff flags: %w", err)
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	if l.a == l.b {
+		return nil, fmt.Errorf("both sides are the same configuration, set -a/-b or -a-instruction/-b-instruction")
+	}
+	l.prompt = strings.Join(l.flags.Args(), " ")
+	return nil, nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *diffLauncher) Run(ctx context.Context, config *launcher.Config) error {
+	if l.cfg.NewAgent == nil {
+		return fmt.Errorf("diff is not configured")
+	}
+	var input string
+	if l.stdin && stdinPiped() {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		input = string(data)
+	}
+	prompt := buildPrompt(l.prompt, input)
+	if prompt == "" {
+		return fmt.Errorf("a prompt is required: use arguments or piped stdin")
+	}
+
+	result, err := runDiff(ctx, &l.cfg, config.SessionService, l.userID, prompt, l.a, l.b)
+	if err != nil {
+		return err
+	}
+	if l.output == OutputTable {
+		fmt.Fprint(os.Stdout, result.Diff)
+	}
+	return printResult(os.Stdout, l.output, result)
+}
+
+// DiffRun is the outcome of one side of a diff
+type DiffRun struct {
+	Agent        string  `json:"agent" yaml:"agent"`
+	Model        string  `json:"model,omitempty" yaml:"model,omitempty"`
+	Instruction  string  `json:"instruction,omitempty" yaml:"instruction,omitempty"`
+	Output       string  `json:"output" yaml:"output"`
+	InputTokens  int32   `json:"input_tokens" yaml:"input_tokens"`
+	OutputTokens int32   `json:"output_tokens" yaml:"output_tokens"`
+	Seconds      float64 `json:"seconds" yaml:"seconds"`
+}
+
+// DiffResult compares the runs of both sides
+type DiffResult struct {
+	A          DiffRun `json:"a" yaml:"a"`
+	B          DiffRun `json:"b" yaml:"b"`
+	Diff       string  `json:"diff" yaml:"diff"`             // Unified diff of the outputs by line
+	Similarity float64 `json:"similarity" yaml:"similarity"` // Share of words the outputs have in common, 0-1
+}
+
+// Header implements Tabular
+func (r *DiffResult) Header() []string {
+	return []string{"METRIC", "A", "B", "DELTA"}
+}
+
+// Rows implements Tabular
+func (r *DiffResult) Rows() [][]string {
+	itoa := func(n int32) string { return strconv.Itoa(int(n)) }
+	delta := func(a, b int32) string { return fmt.Sprintf("%+d", b-a) }
+	seconds := func(s float64) string { return strconv.FormatFloat(s, 'f', 2, 64) + "s" }
+	return [][]string{
+		{"agent", r.A.Agent, r.B.Agent, ""},
+		{"model", r.A.Model, r.B.Model, ""},
+		{"input_tokens", itoa(r.A.InputTokens), itoa(r.B.InputTokens), delta(r.A.InputTokens, r.B.InputTokens)},
+		{"output_tokens", itoa(r.A.OutputTokens), itoa(r.B.OutputTokens), delta(r.A.OutputTokens, r.B.OutputTokens)},
+		{"latency", seconds(r.A.Seconds), seconds(r.B.Seconds), fmt.Sprintf("%+.2fs", r.B.Seconds-r.A.Seconds)},
+		{"similarity", "", "", fmt.Sprintf("%.0f%%", r.Similarity*100)},
+	}
+}
+
+// runDiff runs prompt on both sides, one after the other so their latencies
+// are comparable
+func runDiff(ctx context.Context, cfg *DiffConfig, sessions session.Service, userID, prompt string, a, b diffSide) (*DiffResult, error) {
+	runA, err := runSide(ctx, cfg, sessions, userID, prompt, a)
+	if err != nil {
+		return nil, fmt.Errorf("side A failed: %w", err)
+	}
+	runB, err := runSide(ctx, cfg, sessions, userID, prompt, b)
+	if err != nil {
+		return nil, fmt.Errorf("side B failed: %w", err)
+	}
+
+	return &DiffResult{
+		A:          *runA,
+		B:          *runB,
+		Diff:       unifiedDiff(runA.Output, runB.Output, "a/"+runA.Agent, "b/"+runB.Agent),
+		Similarity: similarity(runA.Output, runB.Output),
+	}, nil
+}
+
+// runSide runs prompt on one side and measures its tokens and latency
+func runSide(ctx context.Context, cfg *DiffConfig, sessions session.Service, userID, prompt string, side diffSide) (*DiffRun, error) {
+	instruction := side.instruction
+	if path, ok := strings.CutPrefix(instruction, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read instruction: %w", err)
+		}
+		instruction = strings.TrimSpace(string(data))
+	}
+	a, err := cfg.NewAgent(side.agent, instruction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent: %w", err)
+	}
+
+	run := &DiffRun{Agent: a.Name(), Instruction: instruction}
+	if cfg.ModelName != nil {
+		run.Model = cfg.ModelName(side.agent)
+	}
+	start := time.Now()
+	events, err := runTurn(ctx, a, sessions, userID, prompt, agent.StreamingModeNone)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := writeReply(&out, io.Discard, countUsage(events, run)); err != nil {
+		return nil, err
+	}
+	run.Seconds = time.Since(start).Seconds()
+	run.Output = strings.TrimSpace(out.String())
+	return run, nil
+}
+
+// countUsage passes events through, adding their token usage to run
+func countUsage(events iter.Seq2[*session.Event, error], run *DiffRun) iter.Seq2[*session.Event, error] {
+	return func(yield func(*session.Event, error) bool) {
+		for event, err := range events {
+			if err == nil && !event.Partial {
+				if u := event.UsageMetadata; u != nil {
+					run.InputTokens += u.PromptTokenCount
+					run.OutputTokens += u.CandidatesTokenCount
+				}
+			}
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// diffOp is a line kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// lcsOps returns the edit script turning a into b through their longest common subsequence
+func lcsOps(a, b []string) []diffOp {
+	// lengths[i][j] is the LCS length of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i, j = i+1, j+1
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// unifiedDiff returns the line diff of a and b in unified format, empty when
+// they are equal
+func unifiedDiff(a, b, nameA, nameB string) string {
+	ops := lcsOps(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	var out strings.Builder
+	lineA, lineB := 1, 1 // Line numbers at ops[i]
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i, lineA, lineB = i+1, lineA+1, lineB+1
+			continue
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+
+		// A hunk runs from the context before a change to the context after
+		// the last change that is at most 2*diffContext lines further
+		start := max(i-diffContext, 0)
+		for back := i - start; back > 0; back-- {
+			lineA, lineB = lineA-1, lineB-1
+		}
+		end, unchanged := i, 0
+		for end < len(ops) && unchanged <= 2*diffContext {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		end -= max(unchanged-diffContext, 0)
+
+		countA, countB := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(lineA, countA), hunkRange(lineB, countB))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.text)
+		}
+		i, lineA, lineB = end, lineA+countA, lineB+countB
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk side
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start-- // An empty range names the line before it
+	}
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// similarity is the share of words a and b have in common, in order
+func similarity(a, b string) float64 {
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA)+len(wordsB) == 0 {
+		return 1
+	}
+	common := 0
+	for _, op := range lcsOps(wordsA, wordsB) {
+		if op.kind == ' ' {
+			common++
+		}
+	}
+	return float64(2*common) / float64(len(wordsA)+len(wordsB))
+}
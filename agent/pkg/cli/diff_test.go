@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// TestUnifiedDiff tests hunks, context and line ranges
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(s ...string) string { return strings.Join(s, "\n") }
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "same\ntext", "same\ntext", ""},
+		{"change", lines("1", "2", "3"), lines("1", "two", "3"), lines(
+			"--- a", "+++ b", "@@ -1,3 +1,3 @@", " 1", "-2", "+two", " 3", ""),
+		},
+		{"separate hunks", lines("1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"),
+			lines("one", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13"), lines(
+				"--- a", "+++ b",
+				"@@ -1,4 +1,4 @@", "-1", "+one", " 2", " 3", " 4",
+				"@@ -10,3 +10,4 @@", " 10", " 11", " 12", "+13", ""),
+		},
+		{"merged hunks", lines("1", "2", "3", "4", "5", "6", "7", "8"),
+			lines("1", "x", "3", "4", "5", "6", "7", "y"), lines(
+				"--- a", "+++ b",
+				"@@ -1,8 +1,8 @@", " 1", "-2", "+x", " 3", " 4", " 5", " 6", " 7", "-8", "+y", ""),
+		},
+		{"insert into empty", "", "new", lines("--- a", "+++ b", "@@ -1 +1 @@", "-", "+new", "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff(tt.a, tt.b, "a", "b"); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSimilarity tests the share of common words
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"a b c d", "a b c d", 1},
+		{"a b c d", "a x c d", 0.75},
+		{"a b", "c d", 0},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// instructedModel answers with its system instruction and reports token usage
+type instructedModel struct{ name string }
+
+func (m instructedModel) Name() string { return m.name }
+
+func (m instructedModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := req.Config.SystemInstruction.Parts[0].Text
+		yield(&model.LLMResponse{
+			Content:       genai.NewContentFromText(text, genai.RoleModel),
+			UsageMetadata: &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: int32(len(text))},
+		}, nil)
+	}
+}
+
+// TestRunDiff tests comparing two agents and an instruction override
+func TestRunDiff(t *testing.T) {
+	cfg := &DiffConfig{
+		NewAgent: func(name, instruction string) (agent.Agent, error) {
+			llm := instructedModel{name: "model-" + name}
+			return llmagent.New(llmagent.Config{
+				Name:        "agent-" + name,
+				Model:       llm,
+				Instruction: cmpOr(instruction, "Say hello.\nBe nice."),
+			})
+		},
+		ModelName: func(name string) string { return "model-" + name },
+	}
+
+	result, err := runDiff(context.Background(), cfg, nil, "user", "hi", diffSide{agent: "x"}, diffSide{agent: "y", instruction: "Say hello.\nBe brief."})
+	if err != nil {
+		t.Fatalf("runDiff() error = %v", err)
+	}
+	if result.A.Agent != "agent-x" || result.B.Agent != "agent-y" || result.A.Model != "model-x" {
+		t.Errorf("sides = %+v / %+v", result.A, result.B)
+	}
+	if result.A.OutputTokens != 19 || result.B.OutputTokens != 20 || result.A.InputTokens != 10 {
+		t.Errorf("tokens = %+v / %+v", result.A, result.B)
+	}
+	if !strings.Contains(result.Diff, "-Be nice.\n+Be brief.\n") {
+		t.Errorf("Diff = %q", result.Diff)
+	}
+	if result.Similarity != 0.75 {
+		t.Errorf("Similarity = %v, want 0.75", result.Similarity)
+	}
+
+	rows := result.Rows()
+	if got := rows[3]; got[0] != "output_tokens" || got[3] != "+1" {
+		t.Errorf("output_tokens row = %q", got)
+	}
+}
+
+func cmpOr(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
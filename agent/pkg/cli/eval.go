@@ -0,0 +1,514 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"gopkg.in/yaml.v3"
+)
+
+// EvalConfig holds the hooks the eval command uses to run the suite
+type EvalConfig struct {
+	// NewAgent builds the named agent, the root one when name is empty
+	NewAgent func(name string) (agent.Agent, error)
+	// Judge grades the answers against judge expectations, optional
+	Judge model.LLM
+	// AllowBatch reports whether batch work may run, false under memory
+	// pressure; optional
+	AllowBatch func() bool
+}
+
+// EvalSuite is a suite of prompts with the behaviors expected of their answers
+type EvalSuite struct {
+	Name  string     `json:"name" yaml:"name"`
+	Agent string     `json:"agent" yaml:"agent"` // Default agent of the cases, the root one when empty
+	Cases []EvalCase `json:"cases" yaml:"cases"`
+}
+
+// EvalCase is a prompt sent in a new session and what its answer must satisfy
+type EvalCase struct {
+	Name   string       `json:"name" yaml:"name"`
+	Agent  string       `json:"agent" yaml:"agent"` // Overrides the suite's agent
+	Prompt string       `json:"prompt" yaml:"prompt"`
+	Expect []EvalExpect `json:"expect" yaml:"expect"`
+}
+
+// EvalExpect is one expected behavior of an answer; exactly one field is set
+type EvalExpect struct {
+	Contains    string `json:"contains,omitempty" yaml:"contains,omitempty"`
+	NotContains string `json:"not_contains,omitempty" yaml:"not_contains,omitempty"`
+	Regex       string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Judge       string `json:"judge,omitempty" yaml:"judge,omitempty"` // Criterion the judge model grades the answer against
+}
+
+// check returns the type and expected value of e
+func (e *EvalExpect) check() (string, string) {
+	switch {
+	case e.Contains != "":
+		return "contains", e.Contains
+	case e.NotContains != "":
+		return "not_contains", e.NotContains
+	case e.Regex != "":
+		return "regex", e.Regex
+	default:
+		return "judge", e.Judge
+	}
+}
+
+// loadSuite reads a suite from a YAML file, or from a JSONL file of cases,
+// one per line, and validates it
+func loadSuite(path string) (*EvalSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suite: %w", err)
+	}
+	suite := &EvalSuite{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var c EvalCase
+			dec := json.NewDecoder(strings.NewReader(text))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&c); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(suite); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to parse suite %s: %w", path, err)
+		}
+	}
+	if err := suite.validate(); err != nil {
+		return nil, fmt.Errorf("invalid suite %s: %w", path, err)
+	}
+	return suite, nil
+}
+
+// validate checks the cases, naming unnamed ones after their position
+func (s *EvalSuite) validate() error {
+	if len(s.Cases) == 0 {
+		return fmt.Errorf("no cases")
+	}
+	names := make(map[string]bool, len(s.Cases))
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if c.Name == "" {
+			c.Name = "case_" + strconv.Itoa(i+1)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate case %q", c.Name)
+		}
+		names[c.Name] = true
+		if strings.TrimSpace(c.Prompt) == "" {
+			return fmt.Errorf("case %s: prompt is required", c.Name)
+		}
+		if len(c.Expect) == 0 {
+			return fmt.Errorf("case %s: expect is required", c.Name)
+		}
+		for j, e := range c.Expect {
+			set := 0
+			for _, v := range []string{e.Contains, e.NotContains, e.Regex, e.Judge} {
+				if v != "" {
+					set++
+				}
+			}
+			if set != 1 {
+				return fmt.Errorf("case %s: expect[%d] must set exactly one of contains, not_contains, regex or judge", c.Name, j)
+			}
+			if e.Regex != "" {
+				if _, err := regexp.Compile(e.Regex); err != nil {
+					return fmt.Errorf("case %s: expect[%d]: %w", c.Name, j, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// evalLauncher runs an evaluation suite against the agents and reports the
+// cases that pass and fail
+type evalLauncher struct {
+	flags  *flag.FlagSet
+	cfg    EvalConfig
+	suite  string
+	agent  string
+	filter string
+	userID string
+	report string
+	junit  string
+	output string
+}
+
+// NewEvalLauncher creates the `eval` subcommand
+func NewEvalLauncher(cfg *EvalConfig) launcher.SubLauncher {
+	l := &evalLauncher{}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	fs.StringVar(&l.agent, "agent", "", "Agent of the cases that name none, defaults to the suite's or the root agent")
+	fs.StringVar(&l.filter, "run", "", "Only run the cases whose name matches this regular expression")
+	fs.StringVar(&l.userID, "user", "user", "User ID of the sessions")
+	fs.StringVar(&l.report, "report", "", "Write the JSON report to this file")
+	fs.StringVar(&l.junit, "junit", "", "Write a JUnit XML report to this file")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *evalLauncher) Keyword() string {
+	return "eval"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *evalLauncher) SimpleDescription() string {
+	return "runs a YAML or JSONL suite of prompts and checks the answers, for regression tests of prompts and config"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *evalLauncher) CommandLineSyntax() string {
+	return "[flags] suite.yaml|suite.jsonl\n" + flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *evalLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse eval flags: %w", err)
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	if l.filter != "" {
+		if _, err := regexp.Compile(l.filter); err != nil {
+			return nil, fmt.Errorf("invalid -run pattern: %w", err)
+		}
+	}
+	if l.flags.NArg() == 0 {
+		return nil, fmt.Errorf("a suite file is required")
+	}
+	l.suite = l.flags.Arg(0)
+	return l.flags.Args()[1:], nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *evalLauncher) Run(ctx context.Context, config *launcher.Config) error {
+	if l.cfg.NewAgent == nil {
+		return fmt.Errorf("eval is not configured")
+	}
+	if l.cfg.AllowBatch != nil && !l.cfg.AllowBatch() {
+		return errMemoryPressure
+	}
+	suite, err := loadSuite(l.suite)
+	if err != nil {
+		return err
+	}
+	if l.agent != "" {
+		suite.Agent = l.agent
+	}
+	if l.filter != "" {
+		pattern := regexp.MustCompile(l.filter)
+		var cases []EvalCase
+		for _, c := range suite.Cases {
+			if pattern.MatchString(c.Name) {
+				cases = append(cases, c)
+			}
+		}
+		if len(cases) == 0 {
+			return fmt.Errorf("no case matches %q", l.filter)
+		}
+		suite.Cases = cases
+	}
+
+	report := runEval(ctx, &l.cfg, config.SessionService, l.userID, suite)
+	if l.report != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		if err := os.WriteFile(l.report, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	if l.junit != "" {
+		data, err := report.JUnit()
+		if err != nil {
+			return fmt.Errorf("failed to encode JUnit report: %w", err)
+		}
+		if err := os.WriteFile(l.junit, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write JUnit report: %w", err)
+		}
+	}
+	if err := printResult(os.Stdout, l.output, report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", report.Failed, len(report.Cases))
+	}
+	return nil
+}
+
+// EvalCheck is the outcome of one expectation
+type EvalCheck struct {
+	Type     string `json:"type" yaml:"type"` // contains, not_contains, regex or judge
+	Expected string `json:"expected" yaml:"expected"`
+	Passed   bool   `json:"passed" yaml:"passed"`
+	Reason   string `json:"reason,omitempty" yaml:"reason,omitempty"` // Why it failed, or the judge's reasoning
+}
+
+// EvalResult is the outcome of one case
+type EvalResult struct {
+	Name    string      `json:"name" yaml:"name"`
+	Agent   string      `json:"agent" yaml:"agent"`
+	Prompt  string      `json:"prompt" yaml:"prompt"`
+	Output  string      `json:"output" yaml:"output"`
+	Passed  bool        `json:"passed" yaml:"passed"`
+	Error   string      `json:"error,omitempty" yaml:"error,omitempty"` // The run failed before an answer
+	Seconds float64     `json:"seconds" yaml:"seconds"`
+	Checks  []EvalCheck `json:"checks" yaml:"checks"`
+}
+
+// EvalReport is the outcome of a suite
+type EvalReport struct {
+	Suite   string       `json:"suite" yaml:"suite"`
+	Started time.Time    `json:"started" yaml:"started"`
+	Seconds float64      `json:"seconds" yaml:"seconds"`
+	Passed  int          `json:"passed" yaml:"passed"`
+	Failed  int          `json:"failed" yaml:"failed"`
+	Cases   []EvalResult `json:"cases" yaml:"cases"`
+}
+
+// Header implements Tabular
+func (r *EvalReport) Header() []string {
+	return []string{"CASE", "AGENT", "RESULT", "SECONDS", "FAILURES"}
+}
+
+// Rows implements Tabular
+func (r *EvalReport) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Cases)+1)
+	for _, c := range r.Cases {
+		result := "PASS"
+		if !c.Passed {
+			result = "FAIL"
+		}
+		rows = append(rows, []string{c.Name, c.Agent, result, strconv.FormatFloat(c.Seconds, 'f', 2, 64), strings.Join(c.failures(), "; ")})
+	}
+	rows = append(rows, []string{"TOTAL", "", fmt.Sprintf("%d/%d passed", r.Passed, len(r.Cases)), strconv.FormatFloat(r.Seconds, 'f', 2, 64), ""})
+	return rows
+}
+
+// failures describes the error and failed checks of a case
+func (c *EvalResult) failures() []string {
+	var out []string
+	if c.Error != "" {
+		out = append(out, "error: "+c.Error)
+	}
+	for _, check := range c.Checks {
+		if !check.Passed {
+			out = append(out, check.Type+": "+check.Reason)
+		}
+	}
+	return out
+}
+
+// junitSuites is the root of a JUnit XML report
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnit returns the report as JUnit XML, for CI systems: a case whose run
+// failed is an error, one with failed checks a failure
+func (r *EvalReport) JUnit() ([]byte, error) {
+	suite := junitSuite{
+		Name:      r.Suite,
+		Tests:     len(r.Cases),
+		Time:      strconv.FormatFloat(r.Seconds, 'f', 3, 64),
+		Timestamp: r.Started.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, c := range r.Cases {
+		jc := junitCase{
+			Name:      c.Name,
+			ClassName: r.Suite + "." + cmp.Or(c.Agent, "agent"),
+			Time:      strconv.FormatFloat(c.Seconds, 'f', 3, 64),
+			SystemOut: c.Output,
+		}
+		switch {
+		case c.Error != "":
+			suite.Errors++
+			jc.Error = &junitProblem{Message: c.Error, Text: c.Error}
+		case !c.Passed:
+			suite.Failures++
+			failures := c.failures()
+			jc.Failure = &junitProblem{Message: failures[0], Text: strings.Join(failures, "\n")}
+		}
+		suite.Cases = append(suite.Cases, jc)
+	}
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// runEval runs the cases of suite one after the other, each in a new session
+func runEval(ctx context.Context, cfg *EvalConfig, sessions session.Service, userID string, suite *EvalSuite) *EvalReport {
+	report := &EvalReport{Suite: suite.Name, Started: time.Now()}
+	for _, c := range suite.Cases {
+		if cfg.AllowBatch != nil && !cfg.AllowBatch() {
+			report.Failed++
+			report.Cases = append(report.Cases, EvalResult{Name: c.Name, Agent: cmp.Or(c.Agent, suite.Agent), Prompt: c.Prompt, Error: errMemoryPressure.Error()})
+			continue
+		}
+		result := runCase(ctx, cfg, sessions, userID, cmp.Or(c.Agent, suite.Agent), &c)
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, *result)
+	}
+	report.Seconds = time.Since(report.Started).Seconds()
+	return report
+}
+
+// runCase sends the prompt of c to the named agent and checks the answer
+func runCase(ctx context.Context, cfg *EvalConfig, sessions session.Service, userID, agentName string, c *EvalCase) *EvalResult {
+	result := &EvalResult{Name: c.Name, Agent: agentName, Prompt: c.Prompt}
+	start := time.Now()
+	output, err := evalAnswer(ctx, cfg, sessions, userID, agentName, c.Prompt)
+	result.Seconds = time.Since(start).Seconds()
+	result.Output = output
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Passed = true
+	for _, e := range c.Expect {
+		check := checkAnswer(ctx, cfg.Judge, c.Prompt, output, &e)
+		result.Passed = result.Passed && check.Passed
+		result.Checks = append(result.Checks, check)
+	}
+	return result
+}
+
+// evalAnswer returns the answer of the named agent to prompt in a new session
+func evalAnswer(ctx context.Context, cfg *EvalConfig, sessions session.Service, userID, agentName, prompt string) (string, error) {
+	a, err := cfg.NewAgent(agentName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create agent: %w", err)
+	}
+	events, err := runTurn(ctx, a, sessions, userID, prompt, agent.StreamingModeNone)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = writeReply(&out, io.Discard, events)
+	return strings.TrimSpace(out.String()), err
+}
+
+// judgeVerdict is the grade the judge model gives an answer
+type judgeVerdict struct {
+	Pass   bool   `json:"pass" jsonschema:"Whether the answer meets the criterion"`
+	Reason string `json:"reason" jsonschema:"One sentence explaining the verdict"`
+}
+
+// judgePrompt asks the judge to grade an answer, with the question, the
+// answer and the criterion
+const judgePrompt = `You are grading the answer of an AI assistant against a criterion. Judge only the criterion, not the style or other qualities of the answer.
+
+Question:
+%s
+
+Answer:
+%s
+
+Criterion:
+%s`
+
+// checkAnswer checks output, the answer to prompt, against e
+func checkAnswer(ctx context.Context, judge model.LLM, prompt, output string, e *EvalExpect) EvalCheck {
+	kind, expected := e.check()
+	check := EvalCheck{Type: kind, Expected: expected}
+	switch kind {
+	case "contains":
+		check.Passed = strings.Contains(output, expected)
+		if !check.Passed {
+			check.Reason = fmt.Sprintf("answer does not contain %q", expected)
+		}
+	case "not_contains":
+		check.Passed = !strings.Contains(output, expected)
+		if !check.Passed {
+			check.Reason = fmt.Sprintf("answer contains %q", expected)
+		}
+	case "regex":
+		check.Passed = regexp.MustCompile(expected).MatchString(output)
+		if !check.Passed {
+			check.Reason = fmt.Sprintf("answer does not match %s", expected)
+		}
+	case "judge":
+		if judge == nil {
+			check.Reason = "no judge model is configured"
+			return check
+		}
+		verdict, err := llmmodel.GenerateStruct[judgeVerdict](ctx, judge, fmt.Sprintf(judgePrompt, prompt, output, expected))
+		if err != nil {
+			check.Reason = fmt.Sprintf("judge failed: %v", err)
+			return check
+		}
+		check.Passed, check.Reason = verdict.Pass, verdict.Reason
+	}
+	return check
+}
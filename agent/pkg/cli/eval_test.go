@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"encoding/xml"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// replyModel answers every request with the text reply returns for its last message
+type replyModel struct {
+	reply func(text string) string
+}
+
+func (replyModel) Name() string { return "reply" }
+
+func (m replyModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := req.Contents[len(req.Contents)-1].Parts[0].Text
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(m.reply(text), genai.RoleModel)}, nil)
+	}
+}
+
+// TestLoadSuite tests reading YAML and JSONL suites and rejecting invalid ones
+func TestLoadSuite(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	suite, err := loadSuite(write("smoke.yaml", `
+agent: writer
+cases:
+  - name: greets
+    prompt: "Say hi"
+    expect:
+      - contains: "hi"
+      - judge: "The answer is polite"
+  - prompt: "Count to 3"
+    agent: counter
+    expect:
+      - regex: "1.*2.*3"
+`))
+	if err != nil {
+		t.Fatalf("loadSuite(yaml) error = %v", err)
+	}
+	if suite.Name != "smoke" || suite.Agent != "writer" || len(suite.Cases) != 2 || suite.Cases[1].Name != "case_2" || suite.Cases[1].Agent != "counter" {
+		t.Errorf("loadSuite(yaml) = %+v", suite)
+	}
+
+	suite, err = loadSuite(write("smoke.jsonl", `{"name": "a", "prompt": "x", "expect": [{"not_contains": "error"}]}
+
+{"name": "b", "prompt": "y", "expect": [{"regex": "^y"}]}
+`))
+	if err != nil || len(suite.Cases) != 2 || suite.Cases[0].Expect[0].NotContains != "error" {
+		t.Errorf("loadSuite(jsonl) = %+v, %v", suite, err)
+	}
+
+	invalid := map[string]string{
+		"empty.yaml":     "cases: []",
+		"two.yaml":       "cases: [{prompt: x, expect: [{contains: a, regex: b}]}]",
+		"regex.yaml":     "cases: [{prompt: x, expect: [{regex: '('}]}]",
+		"duplicate.yaml": "cases: [{name: a, prompt: x, expect: [{contains: a}]}, {name: a, prompt: y, expect: [{contains: a}]}]",
+		"unknown.jsonl":  `{"prompt": "x", "expected": [{"contains": "a"}]}`,
+	}
+	for name, content := range invalid {
+		if _, err := loadSuite(write(name, content)); err == nil {
+			t.Errorf("loadSuite(%s) error = nil", name)
+		}
+	}
+}
+
+// TestRunEval tests checking answers, the judge and the JUnit report
+func TestRunEval(t *testing.T) {
+	cfg := &EvalConfig{
+		NewAgent: func(name string) (agent.Agent, error) {
+			return llmagent.New(llmagent.Config{
+				Name:  "agent-" + name,
+				Model: replyModel{reply: func(text string) string { return "Hello, " + text }},
+			})
+		},
+		Judge: replyModel{reply: func(text string) string {
+			if strings.Contains(text, "Hello, Ada") {
+				return `{"pass": true, "reason": "Greets Ada"}`
+			}
+			return `{"pass": false, "reason": "Not a greeting"}`
+		}},
+	}
+	suite := &EvalSuite{Name: "greetings", Agent: "bot", Cases: []EvalCase{
+		{Name: "ada", Prompt: "Ada", Expect: []EvalExpect{{Contains: "Ada"}, {Regex: "^Hello"}, {Judge: "Greets the user"}}},
+		{Name: "bob", Prompt: "Bob", Expect: []EvalExpect{{NotContains: "Bob"}, {Judge: "Greets the user"}}},
+	}}
+
+	report := runEval(context.Background(), cfg, nil, "user", suite)
+	if report.Passed != 1 || report.Failed != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	ada, bob := report.Cases[0], report.Cases[1]
+	if !ada.Passed || ada.Agent != "bot" || ada.Output != "Hello, Ada" || ada.Checks[2].Reason != "Greets Ada" {
+		t.Errorf("ada = %+v", ada)
+	}
+	if bob.Passed || bob.Checks[0].Passed || bob.Checks[0].Reason != `answer contains "Bob"` || bob.Checks[1].Reason != "Not a greeting" {
+		t.Errorf("bob = %+v", bob)
+	}
+
+	data, err := report.JUnit()
+	if err != nil {
+		t.Fatalf("JUnit() error = %v", err)
+	}
+	var junit junitSuites
+	if err := xml.Unmarshal(data, &junit); err != nil {
+		t.Fatalf("JUnit() is not valid XML: %v", err)
+	}
+	got := junit.Suites[0]
+	if got.Name != "greetings" || got.Tests != 2 || got.Failures != 1 || got.Cases[0].Failure != nil || got.Cases[1].Failure == nil {
+		t.Errorf("JUnit() = %+v", got)
+	}
+	if msg := got.Cases[1].Failure.Message; msg != `not_contains: answer contains "Bob"` {
+		t.Errorf("failure message = %q", msg)
+	}
+
+	pressure := &EvalConfig{NewAgent: cfg.NewAgent, AllowBatch: func() bool { return false }}
+	report = runEval(context.Background(), pressure, nil, "user", suite)
+	if report.Failed != 2 || report.Cases[0].Error != errMemoryPressure.Error() {
+		t.Errorf("report under memory pressure = %+v", report)
+	}
+
+	noJudge := &EvalConfig{NewAgent: cfg.NewAgent}
+	report = runEval(context.Background(), noJudge, nil, "user", &EvalSuite{Cases: suite.Cases[:1]})
+	if report.Passed != 0 || report.Cases[0].Checks[2].Reason != "no judge model is configured" {
+		t.Errorf("report without a judge = %+v", report.Cases[0])
+	}
+}
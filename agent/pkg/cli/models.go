@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+)
+
+// DefaultDrainTimeout is how long a model swap waits for the calls in flight
+// on the replaced model by default
+const DefaultDrainTimeout = 5 * time.Minute
+
+// ModelProfile selects a replacement model. Unset fields inherit from the
+// top-level model, as in the model profile of an agent.
+type ModelProfile struct {
+	Provider  string `json:"provider"`
+	ModelName string `json:"model_name"`
+	BaseURL   string `json:"base_url"`
+	APIKey    string `json:"api_key"` // Defaults to the provider's API key environment variable
+}
+
+// ModelsConfig holds the models the admin endpoint can swap
+type ModelsConfig struct {
+	// Models are the switchable models by the name of the agent owning them
+	Models map[string]*SwitchableModel
+	// Default is the agent whose model is swapped when a request names none
+	Default string
+	// NewModel creates the replacement model of an agent
+	NewModel func(ctx context.Context, agent string, profile ModelProfile) (model.LLM, error)
+	// DrainTimeout defaults to DefaultDrainTimeout
+	DrainTimeout time.Duration
+	Logger       *slog.Logger
+}
+
+// ModelsHandler is the admin endpoint listing the models of the agents and
+// swapping one live: new calls go to the replacement at once, and the
+// response reports when the calls in flight on the old model are done.
+type ModelsHandler struct {
+	cfg    ModelsConfig
+	logger *slog.Logger
+}
+
+// NewModelsHandler creates the endpoint
+func NewModelsHandler(cfg *ModelsConfig) (*ModelsHandler, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if len(cfg.Models) == 0 || cfg.NewModel == nil {
+		return nil, fmt.Errorf("models and a model factory are required")
+	}
+	c := *cfg
+	if c.DrainTimeout <= 0 {
+		c.DrainTimeout = DefaultDrainTimeout
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("cli")
+	}
+	return &ModelsHandler{cfg: c, logger: logger}, nil
+}
+
+// ModelStatus is the state of an agent's model
+type ModelStatus struct {
+	Agent    string `json:"agent"`
+	Model    string `json:"model"`
+	InFlight int    `json:"in_flight"` // Calls on the current model
+	Draining int    `json:"draining"`  // Calls left on replaced models
+}
+
+// SwapRequest is the body of a swap
+type SwapRequest struct {
+	Agent string `json:"agent"` // Defaults to the default agent
+	ModelProfile
+	DrainTimeout string `json:"drain_timeout"` // e.g. "2m", defaults to the configured timeout
+}
+
+// SwapResult reports a swap
+type SwapResult struct {
+	Agent    string `json:"agent"`
+	Previous string `json:"previous"`
+	Model    string `json:"model"`
+	Drained  bool   `json:"drained"` // False when calls were still in flight on the previous model at the timeout
+	WaitedMS int64  `json:"waited_ms"`
+}
+
+// Status lists the models, sorted by agent
+func (h *ModelsHandler) Status() []ModelStatus {
+	var statuses []ModelStatus
+	for _, agent := range slices.Sorted(maps.Keys(h.cfg.Models)) {
+		m := h.cfg.Models[agent]
+		inFlight, draining := m.InFlight()
+		statuses = append(statuses, ModelStatus{Agent: agent, Model: m.Name(), InFlight: inFlight, Draining: draining})
+	}
+	return statuses
+}
+
+// AuditState implements admin.Auditable
+func (h *ModelsHandler) AuditState() any {
+	models := make(map[string]string, len(h.cfg.Models))
+	for agent, m := range h.cfg.Models {
+		models[agent] = m.Name()
+	}
+	return models
+}
+
+// Swap replaces the model of an agent and waits up to drainTimeout for the
+// calls in flight on the old one, 0 for the configured timeout
+func (h *ModelsHandler) Swap(ctx context.Context, agent string, profile ModelProfile, drainTimeout time.Duration) (SwapResult, error) {
+	agent = cmp.Or(agent, h.cfg.Default)
+	m, ok := h.cfg.Models[agent]
+	if !ok {
+		return SwapResult{}, fmt.Errorf("agent %q has no model of its own (swappable: %v)", agent, slices.Sorted(maps.Keys(h.cfg.Models)))
+	}
+	llm, err := h.cfg.NewModel(ctx, agent, profile)
+	if err != nil {
+		return SwapResult{}, fmt.Errorf("failed to create model: %w", err)
+	}
+
+	result := SwapResult{Agent: agent, Previous: m.Name(), Model: llm.Name()}
+	h.logger.Info("Swapping model, draining the previous one", "agent", agent, "previous", result.Previous, "model", result.Model)
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(drainTimeout, h.cfg.DrainTimeout))
+	defer cancel()
+	start := time.Now()
+	err = m.Swap(ctx, llm)
+	result.WaitedMS = time.Since(start).Milliseconds()
+	result.Drained = err == nil
+	if err != nil {
+		h.logger.Warn("Model swapped before the previous one drained", "agent", agent, "previous", result.Previous, "error", err)
+	} else {
+		h.logger.Info("Model swapped", "agent", agent, "previous", result.Previous, "model", result.Model, "waited", time.Since(start))
+	}
+	return result, nil
+}
+
+// ServeHTTP lists the models on GET and swaps one on POST with a JSON
+// SwapRequest. A swap answers 200 once the previous model drained, or 202
+// when calls were still in flight on it at the timeout.
+func (h *ModelsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.Status())
+	case http.MethodPost:
+		var body SwapRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64<<10)).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid swap request: %v", err), http.StatusBadRequest)
+			return
+		}
+		var drainTimeout time.Duration
+		if body.DrainTimeout != "" {
+			d, err := time.ParseDuration(body.DrainTimeout)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("invalid drain_timeout %q", body.DrainTimeout), http.StatusBadRequest)
+				return
+			}
+			drainTimeout = d
+		}
+		result, err := h.Swap(req.Context(), body.Agent, body.ModelProfile, drainTimeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Drained {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(result)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
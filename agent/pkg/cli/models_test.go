@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// gatedModel answers once release is closed
+type gatedModel struct {
+	name    string
+	release chan struct{}
+}
+
+func (m *gatedModel) Name() string { return m.name }
+
+func (m *gatedModel) GenerateContent(_ context.Context, _ *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		<-m.release
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(m.name, genai.RoleModel)}, nil)
+	}
+}
+
+// TestModelsHandler tests swapping a model while a call is in flight on it
+func TestModelsHandler(t *testing.T) {
+	old := &gatedModel{name: "old", release: make(chan struct{})}
+	switchable := NewSwitchableModel(old)
+	h, err := NewModelsHandler(&ModelsConfig{
+		Models:  map[string]*SwitchableModel{"main": switchable},
+		Default: "main",
+		NewModel: func(_ context.Context, agent string, p ModelProfile) (model.LLM, error) {
+			return &echoModel{name: p.ModelName}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A call in flight on the old model
+	answered := make(chan string)
+	go func() {
+		var text string
+		for resp := range switchable.GenerateContent(context.Background(), &model.LLMRequest{}, false) {
+			text = resp.Content.Parts[0].Text
+		}
+		answered <- text
+	}()
+	for {
+		if inFlight, _ := switchable.InFlight(); inFlight == 1 {
+			break
+		}
+	}
+
+	swap := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/models", strings.NewReader(body)))
+		return rec
+	}
+	rec := swap(`{"model_name": "new", "drain_timeout": "10ms"}`)
+	var result SwapResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusAccepted || result.Drained || result.Previous != "old" || result.Model != "new" {
+		t.Fatalf("swap while in flight = %d %s", rec.Code, rec.Body)
+	}
+
+	// New calls go to the replacement while the old one drains
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	for resp := range switchable.GenerateContent(context.Background(), req, false) {
+		if text := resp.Content.Parts[0].Text; text != "new: hi" {
+			t.Errorf("reply during drain = %q", text)
+		}
+	}
+	status := h.Status()
+	if len(status) != 1 || status[0].Model != "new" || status[0].InFlight != 0 || status[0].Draining != 1 {
+		t.Errorf("Status() during drain = %+v", status)
+	}
+
+	close(old.release)
+	if text := <-answered; text != "old" {
+		t.Errorf("in-flight call answered by %q, want the old model", text)
+	}
+	rec = swap(`{"agent": "main", "model_name": "newer"}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK || !result.Drained || result.Previous != "new" {
+		t.Errorf("swap when idle = %d %s", rec.Code, rec.Body)
+	}
+	if status := h.Status(); status[0].Draining != 0 {
+		t.Errorf("Status() after drain = %+v", status)
+	}
+
+	for _, body := range []string{`{"agent": "helper"}`, `{"drain_timeout": "soon"}`, `not json`} {
+		if rec := swap(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("swap %s = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats supported by every subcommand via --output
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// Tabular is implemented by command results that can be rendered as a table
+type Tabular interface {
+	Header() []string
+	Rows() [][]string
+}
+
+// addOutputFlag registers the standard --output flag on fs
+func addOutputFlag(fs *flag.FlagSet, format *string) {
+	fs.StringVar(format, "output", OutputTable, "Output format: table, json or yaml")
+}
+
+// validateOutput checks that format is a supported output format
+func validateOutput(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format %q: must be one of table, json, yaml", format)
+	}
+}
+
+// printResult writes v to w in the given format. Table output requires v to implement Tabular.
+func printResult(w io.Writer, format string, v any) error {
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(v)
+	case OutputTable, "":
+		t, ok := v.(Tabular)
+		if !ok {
+			return fmt.Errorf("result of type %T cannot be rendered as a table", v)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.Header(), "\t"))
+		for _, row := range t.Rows() {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return validateOutput(format)
+	}
+}
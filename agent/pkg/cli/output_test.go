@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPrintResult tests rendering a result in every output format
+func TestPrintResult(t *testing.T) {
+	result := &profileResult{Profiles: []capturedProfile{
+		{Type: "cpu", Path: "cpu.pprof", Size: 42},
+	}}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: OutputTable,
+			want:   "TYPE  PATH       SIZE\ncpu   cpu.pprof  42\n",
+		},
+		{
+			format: OutputJSON,
+			want:   "{\n  \"profiles\": [\n    {\n      \"type\": \"cpu\",\n      \"path\": \"cpu.pprof\",\n      \"size\": 42\n    }\n  ]\n}\n",
+		},
+		{
+			format: OutputYAML,
+			want:   "profiles:\n  - type: cpu\n    path: cpu.pprof\n    size: 42\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printResult(&buf, tt.format, result); err != nil {
+				t.Fatalf("printResult() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("printResult() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	if err := printResult(&bytes.Buffer{}, "xml", result); err == nil {
+		t.Error("printResult() with invalid format should fail")
+	}
+}
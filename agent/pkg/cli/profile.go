@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// ProfileConfig holds defaults for the profile command, usually taken from the admin config
+type ProfileConfig struct {
+	AdminAddr  string // e.g. 127.0.0.1:6060
+	AdminToken string
+}
+
+// profileLauncher captures pprof profiles from a running agent's admin server
+type profileLauncher struct {
+	flags    *flag.FlagSet
+	addr     string
+	token    string
+	duration time.Duration
+	outDir   string
+	output   string
+}
+
+// profileResult lists the captured profile files
+type profileResult struct {
+	Profiles []capturedProfile `json:"profiles" yaml:"profiles"`
+}
+
+// capturedProfile is a single profile written to disk
+type capturedProfile struct {
+	Type string `json:"type" yaml:"type"`
+	Path string `json:"path" yaml:"path"`
+	Size int64  `json:"size" yaml:"size"`
+}
+
+// Header implements Tabular
+func (r *profileResult) Header() []string {
+	return []string{"TYPE", "PATH", "SIZE"}
+}
+
+// Rows implements Tabular
+func (r *profileResult) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Profiles))
+	for _, p := range r.Profiles {
+		rows = append(rows, []string{p.Type, p.Path, fmt.Sprint(p.Size)})
+	}
+	return rows
+}
+
+// NewProfileLauncher creates the `profile capture` subcommand
+func NewProfileLauncher(cfg *ProfileConfig) launcher.SubLauncher {
+	if cfg == nil {
+		cfg = &ProfileConfig{}
+	}
+	l := &profileLauncher{}
+
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	fs.StringVar(&l.addr, "addr", cfg.AdminAddr, "Admin server address of the running agent")
+	fs.StringVar(&l.token, "token", cfg.AdminToken, "Admin bearer token")
+	fs.DurationVar(&l.duration, "duration", 30*time.Second, "CPU profile duration (i.e. '10s', '1m')")
+	fs.StringVar(&l.outDir, "out", ".", "Directory where profiles are written")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *profileLauncher) Keyword() string {
+	return "profile"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *profileLauncher) SimpleDescription() string {
+	return "captures CPU and heap profiles from a running agent (profile capture [flags])"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *profileLauncher) CommandLineSyntax() string {
+	return "  capture\n" + flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *profileLauncher) Parse(args []string) ([]string, error) {
+	if len(args) == 0 || args[0] != "capture" {
+		return nil, fmt.Errorf("usage: profile capture [flags]")
+	}
+	if err := l.flags.Parse(args[1:]); err != nil {
+		return nil, fmt.Errorf("failed to parse profile flags: %w", err)
+	}
+	if l.addr == "" {
+		return nil, fmt.Errorf("admin address is required (set admin.addr in config or pass -addr)")
+	}
+	if l.duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *profileLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if err := os.MkdirAll(l.outDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	baseURL := l.addr
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+	stamp := time.Now().Format("20060102-150405")
+	result := &profileResult{}
+
+	// Progress goes to stderr so stdout stays machine-readable
+	fmt.Fprintf(os.Stderr, "Capturing %s CPU profile from %s ...\n", l.duration, baseURL)
+	cpuURL := fmt.Sprintf("%s/debug/pprof/profile?seconds=%d", baseURL, int(l.duration.Seconds()))
+	cpuPath := filepath.Join(l.outDir, "cpu-"+stamp+".pprof")
+	size, err := l.download(ctx, cpuURL, cpuPath, l.duration+30*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to capture CPU profile: %w", err)
+	}
+	result.Profiles = append(result.Profiles, capturedProfile{Type: "cpu", Path: cpuPath, Size: size})
+
+	heapPath := filepath.Join(l.outDir, "heap-"+stamp+".pprof")
+	size, err = l.download(ctx, baseURL+"/debug/pprof/heap", heapPath, 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to capture heap profile: %w", err)
+	}
+	result.Profiles = append(result.Profiles, capturedProfile{Type: "heap", Path: heapPath, Size: size})
+
+	return printResult(os.Stdout, l.output, result)
+}
+
+// download fetches url into path and returns the number of bytes written
+func (l *profileLauncher) download(ctx context.Context, url, path string, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if l.token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("admin server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write profile: %w", err)
+	}
+	return n, nil
+}
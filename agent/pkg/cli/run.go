@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// runLauncher sends a single prompt to the agent and prints the answer
+type runLauncher struct {
+	flags    *flag.FlagSet
+	prompt   string
+	userID   string
+	stdin    bool
+	noStream bool
+}
+
+// NewRunLauncher creates the `run` subcommand for one-shot prompts
+func NewRunLauncher() launcher.SubLauncher {
+	l := &runLauncher{}
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.StringVar(&l.prompt, "p", "", "Prompt to send, defaults to the remaining arguments")
+	fs.StringVar(&l.userID, "user", "user", "User ID of the session")
+	fs.BoolVar(&l.stdin, "stdin", true, "Append piped stdin to the prompt as context (ignored when stdin is a terminal)")
+	fs.BoolVar(&l.noStream, "no-stream", false, "Print the answer only once complete")
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *runLauncher) Keyword() string {
+	return "run"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *runLauncher) SimpleDescription() string {
+	return "sends a single prompt, prints the answer to stdout and exits"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *runLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *runLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse run flags: %w", err)
+	}
+	if l.userID == "" {
+		return nil, fmt.Errorf("user ID cannot be empty")
+	}
+	if l.prompt == "" {
+		l.prompt = strings.Join(l.flags.Args(), " ")
+		return nil, nil
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *runLauncher) Run(ctx context.Context, config *launcher.Config) error {
+	var input string
+	if l.stdin && stdinPiped() {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		input = string(data)
+	}
+	prompt := buildPrompt(l.prompt, input)
+	if prompt == "" {
+		return fmt.Errorf("a prompt is required: use -p, arguments or piped stdin")
+	}
+
+	return runPrompt(ctx, config.AgentLoader.RootAgent(), config.SessionService, l.userID, prompt, !l.noStream, os.Stdout, os.Stderr)
+}
+
+// stdinPiped reports whether stdin is a pipe or file rather than a terminal
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// buildPrompt appends the piped input to the prompt, either may be empty
+func buildPrompt(prompt, input string) string {
+	prompt, input = strings.TrimSpace(prompt), strings.TrimSpace(input)
+	switch {
+	case input == "":
+		return prompt
+	case prompt == "":
+		return input
+	default:
+		return prompt + "\n\n" + input
+	}
+}
+
+// runPrompt sends prompt in a new session, writing the answer to out and tool
+// calls to notes
+func runPrompt(ctx context.Context, a agent.Agent, sessions session.Service, userID, prompt string, stream bool, out, notes io.Writer) error {
+	mode := agent.StreamingModeNone
+	if stream {
+		mode = agent.StreamingModeSSE
+	}
+	events, err := runTurn(ctx, a, sessions, userID, prompt, mode)
+	if err != nil {
+		return err
+	}
+	if err := writeReply(out, notes, events); err != nil {
+		return fmt.Errorf("agent run failed: %w", err)
+	}
+	return nil
+}
+
+// runTurn sends prompt in a new session and returns the events of the turn
+func runTurn(ctx context.Context, a agent.Agent, sessions session.Service, userID, prompt string, mode agent.StreamingMode) (iter.Seq2[*session.Event, error], error) {
+	if sessions == nil {
+		sessions = session.InMemoryService()
+	}
+	r, err := runner.New(runner.Config{
+		AppName:        a.Name(),
+		Agent:          a,
+		SessionService: sessions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create runner: %w", err)
+	}
+	resp, err := sessions.Create(ctx, &session.CreateRequest{
+		AppName: a.Name(),
+		UserID:  userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	msg := genai.NewContentFromText(prompt, genai.RoleUser)
+	return r.Run(ctx, userID, resp.Session.ID(), msg, agent.RunConfig{StreamingMode: mode}), nil
+}
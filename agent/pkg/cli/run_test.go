@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+)
+
+// failingModel fails every call
+type failingModel struct{}
+
+func (failingModel) Name() string { return "failing" }
+
+func (failingModel) GenerateContent(context.Context, *model.LLMRequest, bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		yield(nil, errors.New("backend unavailable"))
+	}
+}
+
+// TestBuildPrompt tests combining the prompt with piped input
+func TestBuildPrompt(t *testing.T) {
+	tests := []struct {
+		prompt, input, want string
+	}{
+		{prompt: "Summarize", input: "", want: "Summarize"},
+		{prompt: "", input: "some text\n", want: "some text"},
+		{prompt: "Summarize:", input: "line 1\nline 2\n", want: "Summarize:\n\nline 1\nline 2"},
+		{prompt: " ", input: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := buildPrompt(tt.prompt, tt.input); got != tt.want {
+			t.Errorf("buildPrompt(%q, %q) = %q, want %q", tt.prompt, tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestRunPrompt tests printing a single answer and failing on model errors
+func TestRunPrompt(t *testing.T) {
+	ctx := context.Background()
+	for _, stream := range []bool{true, false} {
+		a, _ := llmagent.New(llmagent.Config{Name: "test", Model: &echoModel{name: "base"}})
+		var out, notes bytes.Buffer
+		if err := runPrompt(ctx, a, nil, "user", "hello", stream, &out, &notes); err != nil {
+			t.Fatalf("runPrompt(stream=%v) error = %v", stream, err)
+		}
+		if out.String() != "base: hello\n" {
+			t.Errorf("runPrompt(stream=%v) output = %q, want %q", stream, out.String(), "base: hello\n")
+		}
+	}
+
+	a, _ := llmagent.New(llmagent.Config{Name: "test", Model: failingModel{}})
+	var out bytes.Buffer
+	err := runPrompt(ctx, a, nil, "user", "hello", true, &out, &out)
+	if err == nil || !strings.Contains(err.Error(), "backend unavailable") {
+		t.Errorf("runPrompt() error = %v, want the model error", err)
+	}
+}
+
+// TestRunLauncherParse tests taking the prompt from -p or the arguments
+func TestRunLauncherParse(t *testing.T) {
+	l := NewRunLauncher().(*runLauncher)
+	if _, err := l.Parse([]string{"explain", "this"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if l.prompt != "explain this" {
+		t.Errorf("prompt = %q, want %q", l.prompt, "explain this")
+	}
+
+	l = NewRunLauncher().(*runLauncher)
+	rest, err := l.Parse([]string{"-p", "hi", "-no-stream"})
+	if err != nil || len(rest) != 0 || l.prompt != "hi" || !l.noStream {
+		t.Errorf("Parse() = %v, %v with prompt %q, no-stream %v", rest, err, l.prompt, l.noStream)
+	}
+}
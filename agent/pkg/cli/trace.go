@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/trace"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// traceLauncher exports recorded turns from the trace store
+type traceLauncher struct {
+	flags      *flag.FlagSet
+	store      trace.Store
+	since      string
+	session    string
+	invocation string
+	output     string
+}
+
+// traceResult lists the exported turns
+type traceResult struct {
+	Traces []trace.Trace `json:"traces" yaml:"traces"`
+}
+
+// Header implements Tabular
+func (r *traceResult) Header() []string {
+	return []string{"START", "SESSION", "INVOCATION", "USER", "STEPS", "INPUT_TOKENS", "OUTPUT_TOKENS", "COST", "DURATION"}
+}
+
+// Rows implements Tabular
+func (r *traceResult) Rows() [][]string {
+	rows := make([][]string, 0, len(r.Traces))
+	for _, t := range r.Traces {
+		rows = append(rows, []string{
+			t.Start.Local().Format(time.DateTime), t.Session, t.Invocation, t.User, fmt.Sprint(len(t.Steps)),
+			fmt.Sprint(t.InputTokens), fmt.Sprint(t.OutputTokens), fmt.Sprintf("%.4f", t.Cost),
+			t.End.Sub(t.Start).Round(time.Millisecond).String(),
+		})
+	}
+	return rows
+}
+
+// NewTraceLauncher creates the `trace` subcommand reading steps from store
+func NewTraceLauncher(store trace.Store) launcher.SubLauncher {
+	l := &traceLauncher{store: store}
+
+	fs := flag.NewFlagSet("trace", flag.ContinueOnError)
+	fs.StringVar(&l.since, "since", "1d", "Export window: a duration like '7d', '24h' or a date like '2026-01-01'")
+	fs.StringVar(&l.session, "session", "", "Only turns of this session")
+	fs.StringVar(&l.invocation, "invocation", "", "Only this turn")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *traceLauncher) Keyword() string {
+	return "trace"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *traceLauncher) SimpleDescription() string {
+	return "exports recorded turns with their model calls, tool calls, timings and costs"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *traceLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *traceLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse trace flags: %w", err)
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	if _, err := parseSince(l.since, time.Now()); err != nil {
+		return nil, err
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *traceLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.store == nil {
+		return fmt.Errorf("tracing is disabled (set trace.enabled in config)")
+	}
+
+	since, err := parseSince(l.since, time.Now())
+	if err != nil {
+		return err
+	}
+	steps, err := l.store.List(ctx, trace.Filter{Since: since, Session: l.session})
+	if err != nil {
+		return fmt.Errorf("failed to load traces: %w", err)
+	}
+
+	result := &traceResult{Traces: []trace.Trace{}}
+	for _, t := range trace.Build(steps) {
+		if l.invocation == "" || t.Invocation == l.invocation {
+			result.Traces = append(result.Traces, t)
+		}
+	}
+	return printResult(os.Stdout, l.output, result)
+}
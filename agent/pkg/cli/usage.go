@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// usageLauncher reports aggregated token usage and cost from the usage store
+type usageLauncher struct {
+	flags   *flag.FlagSet
+	store   usage.Store
+	since   string
+	groupBy string
+	session string
+	csvPath string
+	output  string
+}
+
+// NewUsageLauncher creates the `usage` subcommand reading records from store
+func NewUsageLauncher(store usage.Store) launcher.SubLauncher {
+	l := &usageLauncher{store: store}
+
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	fs.StringVar(&l.since, "since", "7d", "Report window: a duration like '7d', '24h' or a date like '2026-01-01'")
+	fs.StringVar(&l.groupBy, "group-by", "day,model", "Comma-separated dimensions: day, model, agent, user, key, session")
+	fs.StringVar(&l.session, "session", "", "Only report the model calls of this session")
+	fs.StringVar(&l.csvPath, "csv", "", "Also write the report as CSV to this file ('-' for stdout)")
+	addOutputFlag(fs, &l.output)
+	l.flags = fs
+
+	return l
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *usageLauncher) Keyword() string {
+	return "usage"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *usageLauncher) SimpleDescription() string {
+	return "reports token usage and cost grouped by day, model, agent, user and session"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *usageLauncher) CommandLineSyntax() string {
+	return flagUsage(l.flags)
+}
+
+// Parse implements launcher.SubLauncher
+func (l *usageLauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse usage flags: %w", err)
+	}
+	if err := validateOutput(l.output); err != nil {
+		return nil, err
+	}
+	if err := usage.ValidateGroupBy(splitList(l.groupBy)); err != nil {
+		return nil, err
+	}
+	if _, err := parseSince(l.since, time.Now()); err != nil {
+		return nil, err
+	}
+	return l.flags.Args(), nil
+}
+
+// Run implements launcher.SubLauncher
+func (l *usageLauncher) Run(ctx context.Context, _ *launcher.Config) error {
+	if l.store == nil {
+		return fmt.Errorf("usage tracking is disabled (set usage.enabled in config)")
+	}
+
+	since, err := parseSince(l.since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	records, err := l.store.List(ctx, since)
+	if err != nil {
+		return fmt.Errorf("failed to load usage records: %w", err)
+	}
+	report := usage.Aggregate(usage.FilterSession(records, l.session), since, splitList(l.groupBy))
+
+	if l.csvPath != "" {
+		if err := writeCSV(l.csvPath, report); err != nil {
+			return err
+		}
+		if l.csvPath == "-" {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "CSV report written to %s\n", l.csvPath)
+	}
+
+	return printResult(os.Stdout, l.output, report)
+}
+
+// writeCSV writes the report as CSV to path, or stdout when path is "-"
+func writeCSV(path string, report *usage.Report) error {
+	if path == "-" {
+		return report.WriteCSV(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create CSV file: %w", err)
+	}
+	defer f.Close()
+
+	if err := report.WriteCSV(f); err != nil {
+		return fmt.Errorf("failed to write CSV file: %w", err)
+	}
+	return nil
+}
+
+// parseSince parses a relative window ("7d", "12h") or an absolute date ("2026-01-01")
+func parseSince(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -since value %q: use a window like '7d' or '24h', or a date like '2026-01-01'", s)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
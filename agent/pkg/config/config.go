@@ -1,8 +1,13 @@
 package config
 
 import (
+	"cmp"
+	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -12,29 +17,358 @@ import (
 type Config struct {
 	Model   ModelConfig   `yaml:"model"`
 	Agent   AgentConfig   `yaml:"agent"`
+	Agents  []AgentConfig `yaml:"agents"` // Additional agents, routed by name
 	Logging LoggingConfig `yaml:"logging"`
 	Server  ServerConfig  `yaml:"server"`
+	Memory  MemoryConfig  `yaml:"memory"`
+	Admin   AdminConfig   `yaml:"admin"`
+	Usage   UsageConfig   `yaml:"usage"`
+	Budget  BudgetConfig  `yaml:"budget"`
+	Limits  LimitsConfig  `yaml:"limits"`
+	Refusal RefusalConfig `yaml:"refusal"`
+	Cache   CacheConfig   `yaml:"cache"`
+	RAG     RAGConfig     `yaml:"rag"`
+
+	Conversation ConversationConfig `yaml:"conversation"`
+	Policy       PolicyConfig       `yaml:"policy"`
+	Plan         PlanConfig         `yaml:"plan"`
+	Workflows    []WorkflowConfig   `yaml:"workflows"` // Pipelines of agents, routed by name
+	Trace        TraceConfig        `yaml:"trace"`
+	Shell        ShellConfig        `yaml:"shell"`
+	Interpreter  InterpreterConfig  `yaml:"interpreter"`
+	Workspace    WorkspaceConfig    `yaml:"workspace"`
+	WebSearch    WebSearchConfig    `yaml:"web_search"`
+	HTTPRequest  HTTPRequestConfig  `yaml:"http_request"`
+	Images       ImagesConfig       `yaml:"images"`
+	Artifacts    ArtifactsConfig    `yaml:"artifacts"`
+	ToolRuntime  ToolRuntimeConfig  `yaml:"tool_runtime"`
+	Checkpoints  CheckpointsConfig  `yaml:"checkpoints"`
+	Guardrails   GuardrailsConfig   `yaml:"guardrails"`
+	Sessions     SessionsConfig     `yaml:"sessions"`
+	Encryption   EncryptionConfig   `yaml:"encryption"`
+	Hooks        []HookConfig       `yaml:"hooks"`
+	Personas     PersonasConfig     `yaml:"personas"`
+	Slack        SlackConfig        `yaml:"slack"`
+	Telegram     TelegramConfig     `yaml:"telegram"`
+	Discord      DiscordConfig      `yaml:"discord"`
+	Speech       SpeechConfig       `yaml:"speech"`
 }
 
 // ModelConfig holds LLM model configuration
 type ModelConfig struct {
+	Provider  string `yaml:"provider"` // deepseek (default), openai, openrouter, triton, groq, mistral, together, xai, moonshot, dashscope or zhipu
 	APIKey    string `yaml:"api_key"`
 	ModelName string `yaml:"model_name"`
 	BaseURL   string `yaml:"base_url"`
 	Timeout   string `yaml:"timeout"`
+
+	// CheckModel lists the provider's models at startup and fails when
+	// model_name is not among them, rather than on the first request
+	CheckModel bool `yaml:"check_model"`
+
+	// StreamRetries re-issues a stream that drops mid-generation, 0 disables
+	StreamRetries int `yaml:"stream_retries"`
+
+	// StreamIdleTimeout aborts a stream when no chunk arrives for this long, empty disables
+	StreamIdleTimeout string `yaml:"stream_idle_timeout"`
+
+	// StreamUsage asks for a token usage chunk at the end of streams
+	// (stream_options.include_usage); nil uses the provider's default, which
+	// is off for servers not known to accept it
+	StreamUsage *bool `yaml:"stream_usage"`
+
+	// MaxConcurrentRequests bounds the requests in flight to the provider per
+	// model client; more wait for a slot, up to QueueTimeout. 0 is unlimited.
+	MaxConcurrentRequests int    `yaml:"max_concurrent_requests"`
+	QueueTimeout          string `yaml:"queue_timeout"` // Empty waits as long as the request may
+
+	// CompressRequests gzips large request bodies, for providers that accept
+	// Content-Encoding: gzip
+	CompressRequests bool `yaml:"compress_requests"`
+
+	// StrictTools emits OpenAI strict function schemas for models that support them
+	StrictTools bool `yaml:"strict_tools"`
+
+	// AuthHeader is the header carrying the API key: empty for Authorization
+	// (Bearer), or e.g. "api-key" (Azure) or "x-api-key" for the raw key
+	AuthHeader string `yaml:"auth_header"`
+
+	// Headers are added to every request, e.g. additional gateway keys
+	Headers map[string]string `yaml:"headers"`
+
+	// Organization and Project are sent as OpenAI-Organization and OpenAI-Project (openai only)
+	Organization string `yaml:"organization"`
+	Project      string `yaml:"project"`
+
+	// Thinking turns reasoning on or off for hybrid thinking models (dashscope, zhipu), nil keeps the default
+	Thinking *bool `yaml:"thinking"`
+
+	// Tokenizer used for token estimates: "auto" (by model name), "deepseek",
+	// "ratio-openai", "ratio-openai-legacy", "qwen", "glm" or "generic"
+	Tokenizer string `yaml:"tokenizer"`
+
+	// ContextWindow is the model's context length in tokens. Prompts estimated
+	// above it are logged; 0 uses the preset's known window or disables the check
+	ContextWindow int `yaml:"context_window"`
+
+	// TruncateHistory drops the oldest turns of prompts above the context window
+	TruncateHistory bool `yaml:"truncate_history"`
+
+	// MaxInlineDataSize caps each image, audio or file decoded from a
+	// response, e.g. "20MB" (default); larger ones are replaced by a note
+	MaxInlineDataSize string `yaml:"max_inline_data_size"`
+
+	// InputMIMETypes overrides the inline data types the provider accepts,
+	// e.g. ["image/*", "application/pdf"]; other attachments are rejected
+	InputMIMETypes []string `yaml:"input_mime_types"`
+
+	// MaxImageSize (e.g. "20MB", the default) and MaxImageDimension (pixels of
+	// the longer side, 0 for no limit) downscale larger images before sending
+	MaxImageSize      string `yaml:"max_image_size"`
+	MaxImageDimension int    `yaml:"max_image_dimension"`
+
+	// DumpDir, when set, receives the exact body of every request and the raw
+	// response or SSE stream in timestamped files. They hold prompts and
+	// answers, so it is meant for debugging only.
+	DumpDir string `yaml:"dump_dir"`
+
+	// OpenRouter holds OpenRouter options, used when provider is openrouter
+	OpenRouter OpenRouterConfig `yaml:"openrouter"`
+
+	// Triton holds Triton Inference Server options, used when provider is triton
+	Triton TritonConfig `yaml:"triton"`
+
+	// Warmup keeps the model of a local server loaded, disabled when backend is empty
+	Warmup WarmupConfig `yaml:"warmup"`
+
+	// Monitor watches the load of a local server and throttles calls while it
+	// is saturated, disabled when backend is empty
+	Monitor BackendMonitorConfig `yaml:"monitor"`
+
+	// Hedge sends requests the model is slow to answer to a secondary model
+	// too, disabled when model_name is empty
+	Hedge HedgeConfig `yaml:"hedge"`
+
+	// CircuitBreaker fails requests fast while the endpoint is persistently
+	// erroring, or sends them to its fallback model
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// JSONRepair extracts, repairs and validates the responses to requests
+	// asking for JSON, re-prompting the model when they are invalid
+	JSONRepair JSONRepairConfig `yaml:"json_repair"`
+}
+
+// JSONRepairConfig holds the post-processing of JSON responses
+type JSONRepairConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	MaxRetries int  `yaml:"max_retries"` // Re-prompts of an invalid response, defaults to 2
+}
+
+// TritonConfig holds options for models served by Triton over gRPC. The
+// endpoint (host:port) is taken from base_url and headers are sent as metadata.
+type TritonConfig struct {
+	ModelVersion string `yaml:"model_version"` // Empty uses the server's version policy
+	Backend      string `yaml:"backend"`       // "vllm" (default) or "tensorrtllm"
+	Template     string `yaml:"template"`      // "chatml" (default), "llama3" or "plain"
+	TLS          bool   `yaml:"tls"`
+}
+
+// OpenRouterConfig holds OpenRouter attribution and routing options
+type OpenRouterConfig struct {
+	SiteURL    string                   `yaml:"site_url"`   // Sent as HTTP-Referer
+	AppName    string                   `yaml:"app_name"`   // Sent as X-Title
+	Models     []string                 `yaml:"models"`     // Fallback models tried in order
+	Transforms []string                 `yaml:"transforms"` // e.g. ["middle-out"]
+	Provider   OpenRouterProviderConfig `yaml:"provider"`
+
+	// CacheControl marks large system prompts and histories as cacheable;
+	// nil enables it for anthropic/ models only
+	CacheControl *bool `yaml:"cache_control"`
+}
+
+// OpenRouterProviderConfig holds OpenRouter upstream provider preferences
+type OpenRouterProviderConfig struct {
+	Order             []string `yaml:"order"`
+	Only              []string `yaml:"only"`
+	Ignore            []string `yaml:"ignore"`
+	AllowFallbacks    *bool    `yaml:"allow_fallbacks"`
+	RequireParameters bool     `yaml:"require_parameters"`
+	DataCollection    string   `yaml:"data_collection"` // "allow" or "deny"
+	Sort              string   `yaml:"sort"`            // "price", "throughput" or "latency"
+}
+
+// WarmupConfig holds model warm-up options for local servers. The server
+// and model are taken from base_url and model_name.
+type WarmupConfig struct {
+	Backend   string `yaml:"backend"`    // "ollama" or "llamacpp", empty disables warm-up
+	KeepAlive string `yaml:"keep_alive"` // How long Ollama keeps the model loaded, defaults to 30m
+	Interval  string `yaml:"interval"`   // Check interval, defaults to 1m
+}
+
+// HedgeConfig holds the secondary model of hedged requests: when the model
+// has not responded within the delay, the request is sent to both and the
+// first to respond is used
+type HedgeConfig struct {
+	Provider  string `yaml:"provider"`   // Defaults to model.provider
+	ModelName string `yaml:"model_name"` // Empty disables hedging
+	BaseURL   string `yaml:"base_url"`   // Defaults to model.base_url, or the provider's endpoint
+	APIKey    string `yaml:"api_key"`    // Defaults to model.api_key, or the provider's environment variable
+	Delay     string `yaml:"delay"`      // Time to the first response before hedging, defaults to 1s
+}
+
+// Profile returns the secondary model as a model profile, see ModelFor
+func (h *HedgeConfig) Profile() AgentModelConfig {
+	return AgentModelConfig{Provider: h.Provider, ModelName: h.ModelName, BaseURL: h.BaseURL, APIKey: h.APIKey}
+}
+
+// CircuitBreakerConfig holds the circuit breaker of the model's endpoint. The
+// circuit opens when server and network errors reach the failure rate over
+// the window, and a probe request is let through after the open duration.
+type CircuitBreakerConfig struct {
+	Enabled      bool                  `yaml:"enabled"`
+	Window       string                `yaml:"window"`        // Rolling window of the error rate, defaults to 1m
+	MinRequests  int                   `yaml:"min_requests"`  // Requests in the window before the circuit may open, defaults to 10
+	FailureRate  float64               `yaml:"failure_rate"`  // Fraction of failed requests that opens it, defaults to 0.5
+	OpenDuration string                `yaml:"open_duration"` // How long requests fail fast before a probe, defaults to 30s
+	Fallback     CircuitFallbackConfig `yaml:"fallback"`
+}
+
+// CircuitFallbackConfig holds the model requests go to while the circuit is
+// open. Unset fields inherit from the model as for an agent's model profile.
+type CircuitFallbackConfig struct {
+	Provider  string `yaml:"provider"`   // Defaults to model.provider
+	ModelName string `yaml:"model_name"` // Empty fails requests fast instead
+	BaseURL   string `yaml:"base_url"`   // Defaults to model.base_url, or the provider's endpoint
+	APIKey    string `yaml:"api_key"`    // Defaults to model.api_key, or the provider's environment variable
+}
+
+// Profile returns the fallback as a model profile, see ModelFor
+func (f *CircuitFallbackConfig) Profile() AgentModelConfig {
+	return AgentModelConfig{Provider: f.Provider, ModelName: f.ModelName, BaseURL: f.BaseURL, APIKey: f.APIKey}
+}
+
+// BackendMonitorConfig holds load monitoring options for local servers. The
+// server is taken from base_url.
+type BackendMonitorConfig struct {
+	Backend       string  `yaml:"backend"`         // "ollama" or "vllm", empty disables monitoring
+	Interval      string  `yaml:"interval"`        // Poll interval, defaults to 5s
+	MaxWaiting    int     `yaml:"max_waiting"`     // vLLM queue depth above which it is saturated
+	MaxCacheUsage float64 `yaml:"max_cache_usage"` // vLLM KV cache usage (0-1) at which it is saturated, defaults to 0.95
+
+	MaxConcurrency       int `yaml:"max_concurrency"`       // Concurrent model calls, 0 is unlimited
+	SaturatedConcurrency int `yaml:"saturated_concurrency"` // Concurrent model calls while saturated, defaults to 1
 }
 
 // AgentConfig holds agent configuration
 type AgentConfig struct {
-	Name        string `yaml:"name"`
-	Description string `yaml:"description"`
-	Instruction string `yaml:"instruction"`
+	Name        string           `yaml:"name"`
+	Description string           `yaml:"description"`
+	Instruction string           `yaml:"instruction"`
+	Generation  GenerationConfig `yaml:"generation"`
+
+	// Context sections are computed every turn and appended to the instruction in order
+	Context []ContextSectionConfig `yaml:"context"`
+
+	// Tools the agent may use by name, empty allows every tool
+	Tools []string `yaml:"tools"`
+
+	// Entries of agents the agent can transfer the conversation to
+	SubAgents []string `yaml:"sub_agents"`
+
+	// Entries of agents the agent can call as tools, getting their answer back
+	AgentTools []string `yaml:"agent_tools"`
+
+	// OutputKey stores the agent's final answer in session state under this
+	// key, so later instructions can reference it as {key}
+	OutputKey string `yaml:"output_key"`
+
+	// MaxToolIterations caps the model and tool round-trips of a turn, 0
+	// leaves them unlimited
+	MaxToolIterations   int    `yaml:"max_tool_iterations"`
+	OnMaxToolIterations string `yaml:"on_max_tool_iterations"` // stop, summarize or error, defaults to stop
+
+	// Model overrides the top-level model, for entries of agents only
+	Model AgentModelConfig `yaml:"model"`
+}
+
+// WorkflowConfig composes agents without a model of its own: a sequential
+// workflow runs its steps in order, a parallel one runs them concurrently
+// and then the merge agent, which sees every branch's answer
+type WorkflowConfig struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Type        string   `yaml:"type"`  // sequential or parallel
+	Steps       []string `yaml:"steps"` // Entries of agents or workflows
+	Merge       string   `yaml:"merge"` // Agent or workflow run after a parallel workflow's steps, optional
+}
+
+// AgentModelConfig is the model profile of an agent. Unset fields inherit
+// from the top-level model; a different provider inherits only the timeouts
+// and stream retries.
+type AgentModelConfig struct {
+	Provider  string `yaml:"provider"`
+	ModelName string `yaml:"model_name"`
+	BaseURL   string `yaml:"base_url"`
+	APIKey    string `yaml:"api_key"` // Defaults to the provider's API key environment variable
+}
+
+// IsSet reports whether the profile overrides the top-level model
+func (m *AgentModelConfig) IsSet() bool {
+	return *m != AgentModelConfig{}
+}
+
+// ContextSectionConfig holds a per-turn instruction section
+type ContextSectionConfig struct {
+	Title    string `yaml:"title"`
+	Provider string `yaml:"provider"` // time, environment, user or a registered provider
+	Template string `yaml:"template"` // Go text/template, used when provider is empty
+	Timezone string `yaml:"timezone"` // For the time provider
+}
+
+// GenerationConfig holds default generation parameters for an agent. Unset
+// values fall back to the provider defaults; per-request values take precedence.
+type GenerationConfig struct {
+	Temperature *float32 `yaml:"temperature"`
+	TopP        *float32 `yaml:"top_p"`
+	MaxTokens   int32    `yaml:"max_tokens"`
+	Stop        []string `yaml:"stop"`
+	JSONMode    bool     `yaml:"json_mode"` // Ask the model for a JSON object response
+
+	// JSONSchema is the JSON schema of the responses, sent as strict
+	// structured output where supported and validated by model.json_repair
+	JSONSchema map[string]any `yaml:"json_schema"`
 }
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
-	Level     string `yaml:"level"`
-	AddSource bool   `yaml:"add_source"`
+	Level     string            `yaml:"level"`
+	Format    string            `yaml:"format"` // text (default) or json
+	Levels    map[string]string `yaml:"levels"` // Level by component, e.g. llm: debug, overriding level
+	AddSource bool              `yaml:"add_source"`
+	File      LogFileConfig     `yaml:"file"`
+	// LogPrompts is how prompt and response content is logged: none
+	// (default) for its length and hash only, truncated or full
+	LogPrompts string `yaml:"log_prompts"`
+}
+
+// LogFileConfig holds the rotating log file, written instead of stderr
+type LogFileConfig struct {
+	Path       string `yaml:"path"`        // Empty logs to stderr only
+	MaxSize    string `yaml:"max_size"`    // Rotate past this size, e.g. "100MB" (default)
+	MaxBackups int    `yaml:"max_backups"` // Rotated files kept, all when 0
+	MaxAge     string `yaml:"max_age"`     // Delete rotated files older than this, e.g. "168h"; never when empty
+	Compress   bool   `yaml:"compress"`    // Gzip rotated files
+	Stderr     bool   `yaml:"stderr"`      // Also log to stderr
+}
+
+// GetMaxSize parses the rotation size, 0 means the default
+func (c *LogFileConfig) GetMaxSize() (int64, error) {
+	return parseByteSize(c.MaxSize)
+}
+
+// GetMaxAge parses the age past which rotated files are deleted, 0 means never
+func (c *LogFileConfig) GetMaxAge() (time.Duration, error) {
+	return parseDuration(c.MaxAge, 0)
 }
 
 // ServerConfig holds server configuration
@@ -43,16 +377,673 @@ type ServerConfig struct {
 	ReadTimeout  string `yaml:"read_timeout"`
 	WriteTimeout string `yaml:"write_timeout"`
 	IdleTimeout  string `yaml:"idle_timeout"`
+	DrainTimeout string `yaml:"drain_timeout"` // How long in-flight requests may finish on shutdown
+
+	// Health configures the /healthz and /readyz probes
+	Health HealthConfig `yaml:"health"`
+
+	// APIKeys requires a key on every request when keys are configured
+	APIKeys APIKeysConfig `yaml:"api_keys"`
+
+	// WebSocket configures the /ws/chat endpoint of the ws sublauncher
+	WebSocket WebSocketConfig `yaml:"websocket"`
+
+	// A2A enables the a2a sublauncher and describes the agent in its card
+	A2A A2AConfig `yaml:"a2a"`
+}
+
+// A2AConfig holds the A2A endpoint configuration. Unset card fields are
+// taken from the root agent.
+type A2AConfig struct {
+	Enabled          bool             `yaml:"enabled"`
+	URL              string           `yaml:"url"` // Public base URL advertised to A2A clients
+	Name             string           `yaml:"name"`
+	Description      string           `yaml:"description"`
+	Version          string           `yaml:"version"`
+	DocumentationURL string           `yaml:"documentation_url"`
+	Provider         A2AProvider      `yaml:"provider"`
+	Skills           []A2ASkillConfig `yaml:"skills"` // Built from the agent's tools and sub-agents when empty
+}
+
+// A2AProvider is the organization providing the agent
+type A2AProvider struct {
+	Organization string `yaml:"organization"`
+	URL          string `yaml:"url"`
+}
+
+// A2ASkillConfig describes a capability of the agent in its A2A card
+type A2ASkillConfig struct {
+	ID          string   `yaml:"id"`
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	Examples    []string `yaml:"examples"` // Sample prompts
+}
+
+// WebSocketConfig holds WebSocket chat endpoint configuration
+type WebSocketConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"` // Browser origins besides the server's own, "*" for any
+}
+
+// APIKeysConfig holds the API keys of the server, inline or in a file
+type APIKeysConfig struct {
+	Keys []APIKeyConfig `yaml:"keys"`
+	File string         `yaml:"file"` // YAML list of keys, added to the inline ones
+}
+
+// APIKeyConfig is an API key with its quotas
+type APIKeyConfig struct {
+	Name              string `yaml:"name"`
+	Key               string `yaml:"key"`                 // The secret, or
+	SHA256            string `yaml:"sha256"`              // its hex-encoded SHA-256 digest
+	RequestsPerMinute int    `yaml:"requests_per_minute"` // 0 for no limit
+	TokensPerDay      int64  `yaml:"tokens_per_day"`      // Per UTC day, 0 for no limit; requires usage recording
+}
+
+// Load returns the inline keys followed by those of the keys file
+func (c *APIKeysConfig) Load() ([]APIKeyConfig, error) {
+	keys := slices.Clone(c.Keys)
+	if c.File == "" {
+		return keys, nil
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys file: %w", err)
+	}
+	var fileKeys []APIKeyConfig
+	if err := yaml.Unmarshal(data, &fileKeys); err != nil {
+		return nil, fmt.Errorf("failed to parse keys file %s: %w", c.File, err)
+	}
+	return append(keys, fileKeys...), nil
+}
+
+// HealthConfig holds the upstream check of the readiness probe
+type HealthConfig struct {
+	// Upstream is the provider check of /readyz: empty for none, "models"
+	// (lists models, no tokens) or "completion" (a one-token completion)
+	Upstream string `yaml:"upstream"`
+	CacheTTL string `yaml:"cache_ttl"` // How long a check result is reused, bounding upstream calls; defaults to 30s
+	Timeout  string `yaml:"timeout"`   // Per check, defaults to 5s
+}
+
+// GetCacheTTL parses the check cache TTL, 0 means the default
+func (c *HealthConfig) GetCacheTTL() (time.Duration, error) {
+	return parseDuration(c.CacheTTL, 0)
+}
+
+// GetTimeout parses the check timeout, 0 means the default
+func (c *HealthConfig) GetTimeout() (time.Duration, error) {
+	return parseDuration(c.Timeout, 0)
+}
+
+// MemoryConfig holds process memory limit configuration
+type MemoryConfig struct {
+	SoftLimit     string `yaml:"soft_limit"` // e.g. "1GiB", empty disables
+	HardLimit     string `yaml:"hard_limit"` // e.g. "2GiB", empty disables
+	CheckInterval string `yaml:"check_interval"`
+}
+
+// AdminConfig holds admin server configuration (pprof and operational endpoints)
+type AdminConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Addr     string `yaml:"addr"`
+	Token    string `yaml:"token"`
+	AuditLog string `yaml:"audit_log"` // Append-only log of admin changes, empty disables it
+
+	// DrainTimeout is how long a model swap waits for the calls in flight
+	// on the replaced model, defaults to 5m
+	DrainTimeout string `yaml:"drain_timeout"`
+}
+
+// GetDrainTimeout parses the drain timeout, 0 means the default
+func (c *AdminConfig) GetDrainTimeout() (time.Duration, error) {
+	return parseDuration(c.DrainTimeout, 0)
+}
+
+// UsageConfig holds token usage tracking configuration
+type UsageConfig struct {
+	Enabled bool                   `yaml:"enabled"`
+	Path    string                 `yaml:"path"`    // JSON lines file where usage records are stored
+	Pricing map[string]PriceConfig `yaml:"pricing"` // Keyed by model name
+}
+
+// PriceConfig is the price of a model per million tokens
+type PriceConfig struct {
+	InputPerMillion       float64 `yaml:"input_per_million"`
+	CachedInputPerMillion float64 `yaml:"cached_input_per_million"` // Prompt cache hits, 0 charges the input price
+	OutputPerMillion      float64 `yaml:"output_per_million"`
+}
+
+// BudgetConfig holds monthly budget alerting configuration
+type BudgetConfig struct {
+	Monthly         float64        `yaml:"monthly"`    // Monthly budget in the pricing currency, 0 disables
+	Thresholds      []float64      `yaml:"thresholds"` // Fractions of the budget that trigger alerts
+	WebhookURL      string         `yaml:"webhook_url"`
+	SlackWebhookURL string         `yaml:"slack_webhook_url"`
+	Fallback        FallbackConfig `yaml:"fallback"`
+
+	// Limits of each turn and session, 0 disables; they need no usage tracking
+	MaxTurnTokens      int     `yaml:"max_turn_tokens"`
+	MaxSessionTokens   int     `yaml:"max_session_tokens"`
+	MaxSessionCost     float64 `yaml:"max_session_cost"`    // In the pricing currency
+	Action             string  `yaml:"action"`              // "refuse" (default) or "summarize"
+	SummaryInstruction string  `yaml:"summary_instruction"` // Asks for the final summary
+}
+
+// FallbackConfig holds the cheaper model used once spend reaches the threshold
+type FallbackConfig struct {
+	ModelName string  `yaml:"model_name"` // Empty disables the fallback
+	BaseURL   string  `yaml:"base_url"`   // Defaults to model.base_url
+	APIKey    string  `yaml:"api_key"`    // Defaults to model.api_key
+	Threshold float64 `yaml:"threshold"`  // Fraction of the budget, defaults to 1.0
+}
+
+// LimitsConfig holds soft size limits for user input and model responses
+type LimitsConfig struct {
+	MaxInputSize      string `yaml:"max_input_size"`     // e.g. "32KiB", empty disables
+	MaxInputTokens    int    `yaml:"max_input_tokens"`   // Estimated tokens, 0 disables
+	InputAction       string `yaml:"input_action"`       // "truncate" (default) or "reject"
+	TruncationMessage string `yaml:"truncation_message"` // Appended to truncated input
+	RejectionMessage  string `yaml:"rejection_message"`  // Returned instead of a model reply
+
+	MaxResponseSize           string `yaml:"max_response_size"`   // e.g. "64KiB", empty disables
+	MaxResponseTokens         int    `yaml:"max_response_tokens"` // Estimated tokens, 0 disables
+	ResponseTruncationMessage string `yaml:"response_truncation_message"`
+}
+
+// RefusalConfig holds how provider content-filter refusals are handled
+type RefusalConfig struct {
+	Policy           string                `yaml:"policy"`            // "surface" (default), "retry" or "fallback"
+	Message          string                `yaml:"message"`           // Reply to the user, {reason} is the provider's reason
+	RetryInstruction string                `yaml:"retry_instruction"` // Appended to the system instruction on retry
+	Fallback         RefusalFallbackConfig `yaml:"fallback"`
+}
+
+// RefusalFallbackConfig holds the model asked when the primary model refuses
+type RefusalFallbackConfig struct {
+	ModelName string `yaml:"model_name"`
+	BaseURL   string `yaml:"base_url"` // Defaults to model.base_url
+	APIKey    string `yaml:"api_key"`  // Defaults to model.api_key
+}
+
+// CacheConfig holds the cache of responses to deterministic (temperature 0)
+// requests
+type CacheConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Backend    string `yaml:"backend"`     // "memory" (default) or "redis"
+	TTL        string `yaml:"ttl"`         // How long responses are kept, defaults to 1h
+	MaxEntries int    `yaml:"max_entries"` // Memory backend, defaults to 1000
+	MaxSize    string `yaml:"max_size"`    // Memory backend, e.g. "64MiB", defaults to 64MiB
+	RedisURL   string `yaml:"redis_url"`   // redis://[:password@]host[:port][/db], defaults to REDIS_URL
+	KeyPrefix  string `yaml:"key_prefix"`  // Redis key prefix, defaults to "yanshu:cache:"
+}
+
+// ConversationConfig holds automatic summarization of long conversations and
+// the recovery of requests the provider rejects as too long
+type ConversationConfig struct {
+	Summarize        bool    `yaml:"summarize"`
+	ContextWindow    int     `yaml:"context_window"`     // Tokens, 0 uses the provider preset or 64000
+	Threshold        float64 `yaml:"threshold"`          // Fraction of the context window, defaults to 0.8
+	KeepTurns        int     `yaml:"keep_turns"`         // Recent turns kept verbatim, defaults to 4
+	SummaryMaxTokens int32   `yaml:"summary_max_tokens"` // Defaults to 1024
+	SummaryPrompt    string  `yaml:"summary_prompt"`
+	Recover          string  `yaml:"recover"` // "truncate" or "summarize" retries context length errors once; empty returns them
+}
+
+// RAGConfig holds the knowledge base behind the retrieve tool
+type RAGConfig struct {
+	Enabled      bool            `yaml:"enabled"`
+	Sources      []string        `yaml:"sources"`       // Glob patterns of documents to ingest at startup
+	Store        string          `yaml:"store"`         // "memory", "file" (default) or "sqlite"
+	StorePath    string          `yaml:"store_path"`    // For the file and SQLite stores
+	ChunkSize    int             `yaml:"chunk_size"`    // Characters per chunk
+	ChunkOverlap int             `yaml:"chunk_overlap"` // Characters repeated between chunks
+	TopK         int             `yaml:"top_k"`         // Passages returned per query
+	Embedding    EmbeddingConfig `yaml:"embedding"`
+}
+
+// EmbeddingConfig holds the OpenAI-compatible embeddings endpoint
+type EmbeddingConfig struct {
+	ModelName string `yaml:"model_name"` // e.g. text-embedding-3-small
+	BaseURL   string `yaml:"base_url"`   // Defaults to model.base_url
+	APIKey    string `yaml:"api_key"`    // Defaults to model.api_key
+}
+
+// PolicyConfig holds the rules authorizing requests and tool calls
+type PolicyConfig struct {
+	Default string             `yaml:"default"` // Tool calls no rule decides: "allow" (default) or "deny"
+	Rules   []PolicyRuleConfig `yaml:"rules"`   // Evaluated in order
+}
+
+// PolicyRuleConfig holds a policy rule; every condition set must match
+type PolicyRuleConfig struct {
+	Name   string `yaml:"name"`
+	On     string `yaml:"on"`     // "tool" (default) or "request"
+	Effect string `yaml:"effect"` // "allow", "deny" or "modify" (tool rules)
+
+	Users []string          `yaml:"users"` // Glob patterns of user IDs
+	Tools []string          `yaml:"tools"` // Glob patterns of tool names
+	Args  map[string]string `yaml:"args"`  // Regular expressions per argument
+	Text  string            `yaml:"text"`  // Regular expression on the user message (request rules)
+
+	Hours    string   `yaml:"hours"` // e.g. "09:00-18:00", may wrap midnight
+	Days     []string `yaml:"days"`  // e.g. ["sat", "sun"]
+	Timezone string   `yaml:"timezone"`
+
+	When string `yaml:"when"` // CEL condition over user, tool, args, time and request
+
+	Set     map[string]any `yaml:"set"`     // Argument overrides of modify rules
+	Message string         `yaml:"message"` // Reason given when denied
+}
+
+// PlanConfig holds the tools that run in plan mode
+type PlanConfig struct {
+	ReadOnly []string `yaml:"read_only"` // Glob patterns of tools without side effects, defaults to the retrieve and workspace read tools
+}
+
+// TraceConfig holds per-turn trace recording configuration
+type TraceConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // JSON lines file where trace steps are stored
+}
+
+// ShellConfig holds the exec_shell tool, which runs local commands
+type ShellConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Allow     []string `yaml:"allow"`      // Programs that may run, by name
+	Dir       string   `yaml:"dir"`        // Workspace root commands are confined to, defaults to the current directory
+	Timeout   string   `yaml:"timeout"`    // Per command, defaults to 30s
+	MaxOutput string   `yaml:"max_output"` // Per stream, e.g. "64KB" (default)
+	Env       []string `yaml:"env"`        // Variables passed through besides PATH, HOME, LANG and TZ
+}
+
+// GetTimeout parses the command timeout, 0 means the default
+func (c *ShellConfig) GetTimeout() (time.Duration, error) {
+	return parseDuration(c.Timeout, 0)
+}
+
+// GetMaxOutput parses the output size limit, 0 means the default
+func (c *ShellConfig) GetMaxOutput() (int64, error) {
+	return parseByteSize(c.MaxOutput)
+}
+
+// InterpreterConfig holds the run_code tool, which runs code snippets in a sandbox
+type InterpreterConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	Runtime   string            `yaml:"runtime"`    // process (default), docker or podman
+	Languages []string          `yaml:"languages"`  // python and go, defaults to both
+	Images    map[string]string `yaml:"images"`     // Container image per language
+	Timeout   string            `yaml:"timeout"`    // Per snippet, defaults to 60s
+	MaxOutput string            `yaml:"max_output"` // Per stream, e.g. "64KB" (default)
+	MaxMemory string            `yaml:"max_memory"` // Per snippet, e.g. "512MB" (default)
+}
+
+// GetTimeout parses the snippet timeout, 0 means the default
+func (c *InterpreterConfig) GetTimeout() (time.Duration, error) {
+	return parseDuration(c.Timeout, 0)
+}
+
+// GetMaxOutput parses the output size limit, 0 means the default
+func (c *InterpreterConfig) GetMaxOutput() (int64, error) {
+	return parseByteSize(c.MaxOutput)
+}
+
+// GetMaxMemory parses the memory limit, 0 means the default
+func (c *InterpreterConfig) GetMaxMemory() (int64, error) {
+	return parseByteSize(c.MaxMemory)
+}
+
+// WorkspaceConfig holds the file tools read_file, write_file, list_dir and glob
+type WorkspaceConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Root        string `yaml:"root"`          // Directory the tools are confined to, defaults to the current directory
+	MaxFileSize string `yaml:"max_file_size"` // Per file read or written, e.g. "1MB" (default)
+	ReadOnly    bool   `yaml:"read_only"`     // Leaves out write_file
+}
+
+// GetMaxFileSize parses the file size limit, 0 means the default
+func (c *WorkspaceConfig) GetMaxFileSize() (int64, error) {
+	return parseByteSize(c.MaxFileSize)
+}
+
+// WebSearchConfig holds the web_search tool
+type WebSearchConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Provider   string `yaml:"provider"`    // searxng, brave, tavily or bing
+	APIKey     string `yaml:"api_key"`     // Not needed for searxng
+	BaseURL    string `yaml:"base_url"`    // Required for searxng, overrides the API endpoint of the others
+	MaxResults int    `yaml:"max_results"` // Default number of results per search
+	Timeout    string `yaml:"timeout"`     // Per search, defaults to 10s
+}
+
+// GetTimeout parses the search timeout, 0 means the default
+func (c *WebSearchConfig) GetTimeout() (time.Duration, error) {
+	return parseDuration(c.Timeout, 0)
+}
+
+// HTTPRequestConfig holds the http_request tool, which calls HTTP APIs
+type HTTPRequestConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Allow           []string `yaml:"allow"`             // Hosts requests may go to, e.g. "api.internal", "*.example.com" or "localhost:8080"
+	Deny            []string `yaml:"deny"`              // Hosts refused even when allowed
+	Methods         []string `yaml:"methods"`           // Allowed methods, defaults to all
+	Timeout         string   `yaml:"timeout"`           // Per request, defaults to 30s
+	MaxResponseSize string   `yaml:"max_response_size"` // Response bodies are cut there, e.g. "1MB" (default)
+}
+
+// GetTimeout parses the request timeout, 0 means the default
+func (c *HTTPRequestConfig) GetTimeout() (time.Duration, error) {
+	return parseDuration(c.Timeout, 0)
 }
 
-// Load loads configuration from file or environment variables
-func Load(configPath string) (*Config, error) {
+// GetMaxResponseSize parses the response size limit, 0 means the default
+func (c *HTTPRequestConfig) GetMaxResponseSize() (int64, error) {
+	return parseByteSize(c.MaxResponseSize)
+}
+
+// ImagesConfig holds the generate_image tool and its image model
+// (/v1/images/generations)
+type ImagesConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	ModelName string `yaml:"model_name"` // Defaults to dall-e-3
+	BaseURL   string `yaml:"base_url"`   // Defaults to model.base_url
+	APIKey    string `yaml:"api_key"`    // Defaults to model.api_key
+	Size      string `yaml:"size"`       // When the model gives none, e.g. 1024x1024
+	Quality   string `yaml:"quality"`    // When the model gives none, e.g. standard or hd
+	MaxImages int    `yaml:"max_images"` // Per call, defaults to 4
+}
+
+// ArtifactsConfig holds the store of the files tools and the model save
+// by name, such as generated images and transcripts
+type ArtifactsConfig struct {
+	Backend string           `yaml:"backend"` // memory (default), dir or s3
+	Dir     string           `yaml:"dir"`     // Directory of the dir backend, defaults to data/artifacts
+	Tools   bool             `yaml:"tools"`   // Gives the model save_artifact and load_artifacts
+	S3      ArtifactS3Config `yaml:"s3"`
+}
+
+// ArtifactS3Config holds the bucket of the s3 artifact backend
+type ArtifactS3Config struct {
+	Endpoint        string `yaml:"endpoint"` // Defaults to AWS, e.g. http://localhost:9000 for MinIO
+	Bucket          string `yaml:"bucket"`
+	Region          string `yaml:"region"` // Defaults to us-east-1
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	Prefix          string `yaml:"prefix"` // Prepended to every key, e.g. "yanshu/"
+}
+
+// ToolRuntimeConfig holds the execution policies of the tools
+type ToolRuntimeConfig struct {
+	Default ToolPolicyConfig            `yaml:"default"`
+	Tools   map[string]ToolPolicyConfig `yaml:"tools"` // Per tool name, unset fields keep the default
+}
+
+// ToolPolicyConfig is the execution policy of a tool. Unset fields disable a limit.
+type ToolPolicyConfig struct {
+	Timeout       string `yaml:"timeout"`         // Per attempt, e.g. "30s"
+	Retries       int    `yaml:"retries"`         // Attempts after a failed or timed out one
+	MaxResultSize string `yaml:"max_result_size"` // e.g. "32KB", the rest is cut with a marker
+}
+
+// GetTimeout parses the timeout of a tool call, 0 means none
+func (c *ToolPolicyConfig) GetTimeout() (time.Duration, error) {
+	return parseDuration(c.Timeout, 0)
+}
+
+// GetMaxResultSize parses the size limit of a tool result, 0 means none
+func (c *ToolPolicyConfig) GetMaxResultSize() (int64, error) {
+	return parseByteSize(c.MaxResultSize)
+}
+
+// CheckpointsConfig holds session checkpoints, which roll back history,
+// state and workspace files
+type CheckpointsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Auto    bool `yaml:"auto"` // Takes a checkpoint after each turn, on by default
+	Max     int  `yaml:"max"`  // Checkpoints kept per session, defaults to 20
+}
+
+// GuardrailsConfig holds the checks run on user messages before the model
+// and on its responses after
+type GuardrailsConfig struct {
+	Enabled       bool                   `yaml:"enabled"`
+	Input         []GuardrailCheckConfig `yaml:"input"`
+	Output        []GuardrailCheckConfig `yaml:"output"`
+	InputMessage  string                 `yaml:"input_message"`  // Reply to a blocked message, {checks} lists the checks
+	OutputMessage string                 `yaml:"output_message"` // Replaces a blocked response
+
+	// Moderation is the moderation model of moderation checks
+	Moderation ModerationConfig `yaml:"moderation"`
+}
+
+// ModerationConfig holds the model of the moderation API (/v1/moderations)
+type ModerationConfig struct {
+	ModelName string `yaml:"model_name"` // Defaults to omni-moderation-latest
+	BaseURL   string `yaml:"base_url"`   // Defaults to model.base_url
+	APIKey    string `yaml:"api_key"`    // Defaults to model.api_key
+}
+
+// GuardrailCheckConfig is one check of a guardrail pipeline
+type GuardrailCheckConfig struct {
+	Check    string              `yaml:"check"`    // max_size, prompt_injection, blocked_topics, pii, secrets or moderation (input only)
+	Action   string              `yaml:"action"`   // block, redact (pii and secrets, their default) or log
+	MaxSize  string              `yaml:"max_size"` // Limit of max_size, e.g. "32KB"
+	Topics   map[string][]string `yaml:"topics"`   // Keywords of each blocked topic
+	Types    []string            `yaml:"types"`    // PII types, defaults to all
+	Patterns map[string]string   `yaml:"patterns"` // Extra named regular expressions for pii or secrets
+
+	// Thresholds are the moderation scores from which a category is flagged,
+	// e.g. {"violence": 0.5}; other categories follow the provider's verdict
+	Thresholds map[string]float64 `yaml:"thresholds"`
+}
+
+// GetMaxSize parses the size limit of a max_size check
+func (c *GuardrailCheckConfig) GetMaxSize() (int64, error) {
+	return parseByteSize(c.MaxSize)
+}
+
+// SessionsConfig holds the archival of idle sessions to cold storage
+type SessionsConfig struct {
+	IdleTTL       string `yaml:"idle_ttl"`       // Idle time before a session is archived, empty keeps sessions in memory
+	ArchiveDir    string `yaml:"archive_dir"`    // Where archived sessions are kept, defaults to data/sessions
+	CheckInterval string `yaml:"check_interval"` // How often idle sessions are looked for, defaults to 5m
+}
+
+// EncryptionConfig holds the at-rest encryption of session archives,
+// artifacts and model dumps: each tenant's data key is wrapped by a master key
+type EncryptionConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Provider      string `yaml:"provider"`        // Holder of the master key: "local" (default)
+	MasterKey     string `yaml:"master_key"`      // Base64 of 32 random bytes, or ENCRYPTION_MASTER_KEY
+	MasterKeyFile string `yaml:"master_key_file"` // File holding the base64 master key, instead of master_key
+	Tenant        string `yaml:"tenant"`          // A key per "user" (default) or per "app"
+}
+
+// GetIdleTTL parses the idle TTL, 0 means archival is disabled
+func (c *SessionsConfig) GetIdleTTL() (time.Duration, error) {
+	return parseDuration(c.IdleTTL, 0)
+}
+
+// GetCheckInterval parses the archival check interval, 0 means the default
+func (c *SessionsConfig) GetCheckInterval() (time.Duration, error) {
+	return parseDuration(c.CheckInterval, 0)
+}
+
+// HookConfig enables a registered hook around model and tool calls
+type HookConfig struct {
+	Name    string         `yaml:"name"`    // log, redact or the name a Go package registered
+	Options map[string]any `yaml:"options"` // Options of the hook
+}
+
+// PersonasConfig holds the named instruction presets sessions switch between
+type PersonasConfig struct {
+	Default string          `yaml:"default"` // Persona of sessions that chose none, empty for the agent's instruction alone
+	Presets []PersonaConfig `yaml:"presets"`
+}
+
+// PersonaConfig is an instruction added to the agent's own while active
+type PersonaConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"` // Shown when listing personas
+	Instruction string `yaml:"instruction"`
+}
+
+// SlackConfig holds the Slack app run by the slack command
+type SlackConfig struct {
+	AppToken       string `yaml:"app_token"`       // App-level token (xapp-) for Socket Mode, defaults to SLACK_APP_TOKEN
+	BotToken       string `yaml:"bot_token"`       // Bot token (xoxb-), defaults to SLACK_BOT_TOKEN
+	SlashCommand   string `yaml:"slash_command"`   // Command registered for the app, e.g. "/yanshu"; empty ignores slash commands
+	Placeholder    string `yaml:"placeholder"`     // Posted at once and edited as the reply streams, defaults to "Thinking…"
+	UpdateInterval string `yaml:"update_interval"` // Least time between two edits of a reply, defaults to 1s
+}
+
+// GetUpdateInterval parses the reply update interval, 0 means the default
+func (c *SlackConfig) GetUpdateInterval() (time.Duration, error) {
+	return parseDuration(c.UpdateInterval, 0)
+}
+
+// TelegramConfig holds the Telegram bot run by the telegram command
+type TelegramConfig struct {
+	Token          string  `yaml:"token"`           // From @BotFather, defaults to TELEGRAM_BOT_TOKEN
+	WebhookURL     string  `yaml:"webhook_url"`     // Public HTTPS URL for updates; empty long polls
+	Listen         string  `yaml:"listen"`          // Address of the webhook server, defaults to ":8443"
+	WebhookSecret  string  `yaml:"webhook_secret"`  // Checked on webhook requests
+	AllowedChats   []int64 `yaml:"allowed_chats"`   // Chat IDs the bot answers, any when empty
+	UpdateInterval string  `yaml:"update_interval"` // Least time between two edits of a reply, defaults to 1s
+}
+
+// GetUpdateInterval parses the reply update interval, 0 means the default
+func (c *TelegramConfig) GetUpdateInterval() (time.Duration, error) {
+	return parseDuration(c.UpdateInterval, 0)
+}
+
+// SpeechConfig holds the text-to-speech model (/v1/audio/speech) speaking
+// the final answers of the WebSocket and SSE chat and the Telegram bot
+type SpeechConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	ModelName string  `yaml:"model_name"` // Defaults to gpt-4o-mini-tts
+	BaseURL   string  `yaml:"base_url"`   // Defaults to model.base_url
+	APIKey    string  `yaml:"api_key"`    // Defaults to model.api_key
+	Voice     string  `yaml:"voice"`      // e.g. alloy (default), nova or shimmer
+	Format    string  `yaml:"format"`     // mp3 (default), opus, aac, flac, wav or pcm
+	Speed     float64 `yaml:"speed"`      // From 0.25 to 4, defaults to 1
+}
+
+// DiscordConfig holds the Discord bot run by the discord command
+type DiscordConfig struct {
+	Token           string   `yaml:"token"`            // Bot token, defaults to DISCORD_BOT_TOKEN
+	GuildID         string   `yaml:"guild_id"`         // Server to register the slash commands in, globally when empty
+	AllowedChannels []string `yaml:"allowed_channels"` // Channel or thread IDs the bot answers in, any when empty
+	ModelAdmins     []string `yaml:"model_admins"`     // User IDs allowed to switch the model with /model
+	UpdateInterval  string   `yaml:"update_interval"`  // Least time between two edits of a reply, defaults to 1s
+}
+
+// GetUpdateInterval parses the reply update interval, 0 means the default
+func (c *DiscordConfig) GetUpdateInterval() (time.Duration, error) {
+	return parseDuration(c.UpdateInterval, 0)
+}
+
+// searchKeyEnv is the API key environment variable of each web search provider
+var searchKeyEnv = map[string]string{
+	"brave":  "BRAVE_API_KEY",
+	"tavily": "TAVILY_API_KEY",
+	"bing":   "BING_API_KEY",
+}
+
+// providerKeyEnv is the API key environment variable of each provider
+var providerKeyEnv = map[string]string{
+	"deepseek":   "DEEPSEEK_API_KEY",
+	"openai":     "OPENAI_API_KEY",
+	"openrouter": "OPENROUTER_API_KEY",
+	"groq":       "GROQ_API_KEY",
+	"mistral":    "MISTRAL_API_KEY",
+	"together":   "TOGETHER_API_KEY",
+	"xai":        "XAI_API_KEY",
+	"moonshot":   "MOONSHOT_API_KEY",
+	"dashscope":  "DASHSCOPE_API_KEY",
+	"zhipu":      "ZHIPUAI_API_KEY",
+}
+
+// Overrides are values set by command-line flags. They take precedence over
+// the environment, which takes precedence over the file and the defaults.
+type Overrides struct {
+	Provider    string
+	ModelName   string
+	BaseURL     string
+	Temperature *float32
+	LogLevel    string
+	Port        int
+	DumpDir     string
+}
+
+// BindFlags registers the override flags on fs
+func (o *Overrides) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Provider, "provider", "", "Model provider, overrides model.provider")
+	fs.StringVar(&o.ModelName, "model", "", "Model name, overrides model.model_name and MODEL_NAME")
+	fs.StringVar(&o.BaseURL, "base-url", "", "Model API base URL, overrides model.base_url and MODEL_BASE_URL")
+	fs.Func("temperature", "Sampling temperature, overrides agent.generation.temperature", func(s string) error {
+		t, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return fmt.Errorf("invalid temperature %q", s)
+		}
+		o.Temperature = new(float32)
+		*o.Temperature = float32(t)
+		return nil
+	})
+	fs.StringVar(&o.LogLevel, "log-level", "", "Log level (debug, info, warn, error), overrides logging.level and LOG_LEVEL")
+	fs.IntVar(&o.Port, "port", 0, "Web server port, overrides server.port")
+	fs.StringVar(&o.DumpDir, "dump-dir", "", "Directory to dump raw model requests and responses to, overrides model.dump_dir")
+}
+
+// apply sets the overrides that were given on cfg
+func (o *Overrides) apply(cfg *Config) {
+	if o.ModelName != "" {
+		cfg.Model.ModelName = o.ModelName
+	}
+	if o.BaseURL != "" {
+		cfg.Model.BaseURL = o.BaseURL
+	}
+	if o.Temperature != nil {
+		cfg.Agent.Generation.Temperature = o.Temperature
+	}
+	if o.LogLevel != "" {
+		cfg.Logging.Level = o.LogLevel
+	}
+	if o.Port != 0 {
+		cfg.Server.Port = o.Port
+	}
+	if o.DumpDir != "" {
+		cfg.Model.DumpDir = o.DumpDir
+	}
+}
+
+// Load loads configuration from file, environment variables and overrides,
+// and checks the required fields. overrides may be nil.
+func Load(configPath string, overrides *Overrides) (*Config, error) {
+	cfg, err := Parse(configPath, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkRequired(); err != nil {
+		return nil, err
+	}
+	if err := cfg.checkDelegation(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse loads configuration from file, environment variables and overrides
+// with defaults applied, without checking it. overrides may be nil.
+func Parse(configPath string, overrides *Overrides) (*Config, error) {
+	if overrides == nil {
+		overrides = &Overrides{}
+	}
+
 	cfg := &Config{
 		// Set defaults
 		Model: ModelConfig{
-			ModelName: "deepseek-chat",
-			BaseURL:   "https://api.deepseek.com",
-			Timeout:   "5m",
+			Timeout: "5m",
 		},
 		Agent: AgentConfig{
 			Name:        "yanshu_agent",
@@ -68,6 +1059,37 @@ func Load(configPath string) (*Config, error) {
 			ReadTimeout:  "15s",
 			WriteTimeout: "15s",
 			IdleTimeout:  "60s",
+			DrainTimeout: "30s",
+		},
+		Memory: MemoryConfig{
+			CheckInterval: "10s",
+		},
+		Admin: AdminConfig{
+			Addr:     "127.0.0.1:6060",
+			AuditLog: "data/audit.jsonl",
+		},
+		Usage: UsageConfig{
+			Path: "data/usage.jsonl",
+		},
+		RAG: RAGConfig{
+			Store:     "file",
+			StorePath: "data/rag.jsonl",
+			TopK:      4,
+		},
+		Plan: PlanConfig{
+			ReadOnly: []string{"retrieve", "read_file", "list_dir", "glob", "web_search"},
+		},
+		Trace: TraceConfig{
+			Path: "data/traces.jsonl",
+		},
+		WebSearch: WebSearchConfig{
+			MaxResults: 5,
+		},
+		Checkpoints: CheckpointsConfig{
+			Auto: true,
+		},
+		Sessions: SessionsConfig{
+			ArchiveDir: "data/sessions",
 		},
 	}
 
@@ -83,8 +1105,16 @@ func Load(configPath string) (*Config, error) {
 		}
 	}
 
+	// The provider decides which API key variable is read, so it is overridden first
+	if overrides.Provider != "" {
+		cfg.Model.Provider = overrides.Provider
+	}
+	if cfg.Model.Provider == "" {
+		cfg.Model.Provider = "deepseek"
+	}
+
 	// Override with environment variables if set
-	if apiKey := os.Getenv("DEEPSEEK_API_KEY"); apiKey != "" {
+	if apiKey := os.Getenv(providerKeyEnv[cfg.Model.Provider]); apiKey != "" {
 		cfg.Model.APIKey = apiKey
 	}
 	if modelName := os.Getenv("MODEL_NAME"); modelName != "" {
@@ -93,18 +1123,184 @@ func Load(configPath string) (*Config, error) {
 	if baseURL := os.Getenv("MODEL_BASE_URL"); baseURL != "" {
 		cfg.Model.BaseURL = baseURL
 	}
+	if cfg.Model.Provider == "openai" {
+		if org := os.Getenv("OPENAI_ORG_ID"); org != "" {
+			cfg.Model.Organization = org
+		}
+		if project := os.Getenv("OPENAI_PROJECT_ID"); project != "" {
+			cfg.Model.Project = project
+		}
+	}
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
 		cfg.Logging.Level = logLevel
 	}
-
-	// Validate required fields
-	if cfg.Model.APIKey == "" {
-		return nil, fmt.Errorf("API key is required (set in config.yaml or DEEPSEEK_API_KEY env var)")
+	if logFormat := os.Getenv("LOG_FORMAT"); logFormat != "" {
+		cfg.Logging.Format = logFormat
 	}
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		cfg.Admin.Token = adminToken
+	}
+	if searchKey := os.Getenv(searchKeyEnv[cfg.WebSearch.Provider]); searchKey != "" {
+		cfg.WebSearch.APIKey = searchKey
+	}
+	if token := os.Getenv("SLACK_APP_TOKEN"); token != "" {
+		cfg.Slack.AppToken = token
+	}
+	if token := os.Getenv("SLACK_BOT_TOKEN"); token != "" {
+		cfg.Slack.BotToken = token
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		cfg.Telegram.Token = token
+	}
+	if token := os.Getenv("DISCORD_BOT_TOKEN"); token != "" {
+		cfg.Discord.Token = token
+	}
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		cfg.Cache.RedisURL = url
+	}
+	if key := os.Getenv("ENCRYPTION_MASTER_KEY"); key != "" {
+		cfg.Encryption.MasterKey = key
+	}
+	if cfg.Artifacts.Backend == "s3" && cfg.Artifacts.S3.AccessKeyID == "" {
+		cfg.Artifacts.S3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.Artifacts.S3.SecretAccessKey = cmp.Or(cfg.Artifacts.S3.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	}
+	for i := range cfg.Agents {
+		m := &cfg.Agents[i].Model
+		if m.Provider != "" && m.Provider != cfg.Model.Provider && m.APIKey == "" {
+			m.APIKey = os.Getenv(providerKeyEnv[m.Provider])
+		}
+	}
+	for i := range cfg.Workflows {
+		cfg.Workflows[i].Type = cmp.Or(cfg.Workflows[i].Type, "sequential")
+	}
+	overrides.apply(cfg)
 
+	setProviderDefaults(&cfg.Model)
 	return cfg, nil
 }
 
+// setProviderDefaults sets the DeepSeek defaults; other providers default in
+// their model constructors
+func setProviderDefaults(m *ModelConfig) {
+	if m.Provider == "deepseek" {
+		if m.ModelName == "" {
+			m.ModelName = "deepseek-chat"
+		}
+		if m.BaseURL == "" {
+			m.BaseURL = "https://api.deepseek.com"
+		}
+	}
+}
+
+// ModelFor returns the model configuration of agent a, the top-level model
+// with the agent's profile applied
+func (c *Config) ModelFor(a *AgentConfig) ModelConfig {
+	m := c.Model
+	if p := a.Model.Provider; p != "" && p != m.Provider {
+		m = ModelConfig{
+			Provider:          p,
+			Timeout:           c.Model.Timeout,
+			StreamRetries:     c.Model.StreamRetries,
+			StreamIdleTimeout: c.Model.StreamIdleTimeout,
+
+			MaxConcurrentRequests: c.Model.MaxConcurrentRequests,
+			QueueTimeout:          c.Model.QueueTimeout,
+			CompressRequests:      c.Model.CompressRequests,
+			CircuitBreaker:        c.Model.CircuitBreaker,
+		}
+	}
+	m.ModelName = cmp.Or(a.Model.ModelName, m.ModelName)
+	m.BaseURL = cmp.Or(a.Model.BaseURL, m.BaseURL)
+	m.APIKey = cmp.Or(a.Model.APIKey, m.APIKey)
+	if m.APIKey == "" {
+		m.APIKey = os.Getenv(providerKeyEnv[m.Provider])
+	}
+	setProviderDefaults(&m)
+	return m
+}
+
+// checkDelegation validates how agents and workflows refer to each other.
+// Sub-agents, agent tools, steps and merge steps each name an entry of agents
+// or workflows, nothing contains itself, even indirectly, and no name repeats
+// in an agent tree; an agent tool runs on its own, so it starts a new tree.
+func (c *Config) checkDelegation() error {
+	// Members join the tree of the referring agent, tools start their own
+	type node struct{ members, tools []string }
+	nodes := make(map[string]node, len(c.Agents)+len(c.Workflows))
+	for _, a := range c.Agents {
+		nodes[a.Name] = node{members: a.SubAgents, tools: a.AgentTools}
+	}
+	for _, w := range c.Workflows {
+		members := slices.Clip(w.Steps)
+		if w.Merge != "" {
+			members = append(members, w.Merge)
+		}
+		nodes[w.Name] = node{members: members}
+	}
+
+	var walk func(n node, path []string, tree map[string]bool) error
+	visit := func(name string, path []string, tree map[string]bool) error {
+		n, ok := nodes[name]
+		if !ok {
+			return fmt.Errorf("%s refers to %q, which is not an entry of agents or workflows", path[len(path)-1], name)
+		}
+		if slices.Contains(path, name) {
+			return fmt.Errorf("%s contains itself: %s -> %s", name, strings.Join(path, " -> "), name)
+		}
+		if tree[name] {
+			return fmt.Errorf("%s appears more than once in the agent tree of %s", name, path[0])
+		}
+		tree[name] = true
+		return walk(n, append(path, name), tree)
+	}
+	walk = func(n node, path []string, tree map[string]bool) error {
+		for _, name := range n.members {
+			if err := visit(name, path, tree); err != nil {
+				return err
+			}
+		}
+		for _, name := range n.tools {
+			if err := visit(name, path, map[string]bool{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	root := node{members: c.Agent.SubAgents, tools: c.Agent.AgentTools}
+	if err := walk(root, []string{c.Agent.Name}, map[string]bool{c.Agent.Name: true}); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(nodes))
+	for _, a := range c.Agents {
+		names = append(names, a.Name)
+	}
+	for _, w := range c.Workflows {
+		names = append(names, w.Name)
+	}
+	for _, name := range names {
+		if err := walk(nodes[name], []string{name}, map[string]bool{name: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRequired validates the required fields; self-hosted Triton servers need no API key
+func (c *Config) checkRequired() error {
+	if c.Model.Provider == "triton" && c.Model.BaseURL == "" {
+		return fmt.Errorf("base URL is required for triton (the gRPC endpoint, e.g. localhost:8001)")
+	}
+	if c.Model.APIKey == "" && c.Model.Provider != "triton" {
+		if env := providerKeyEnv[c.Model.Provider]; env != "" {
+			return fmt.Errorf("API key is required (set in config.yaml or %s env var)", env)
+		}
+		return fmt.Errorf("API key is required (set model.api_key in config.yaml)")
+	}
+	return nil
+}
+
 // GetTimeout parses the timeout string and returns a time.Duration
 func (c *ModelConfig) GetTimeout() (time.Duration, error) {
 	if c.Timeout == "" {
@@ -113,6 +1309,164 @@ func (c *ModelConfig) GetTimeout() (time.Duration, error) {
 	return time.ParseDuration(c.Timeout)
 }
 
+// GetMaxInlineDataSize parses the inline data size limit, 0 means the default
+func (c *ModelConfig) GetMaxInlineDataSize() (int64, error) {
+	return parseByteSize(c.MaxInlineDataSize)
+}
+
+// GetMaxImageSize parses the image size limit, 0 means the default
+func (c *ModelConfig) GetMaxImageSize() (int64, error) {
+	return parseByteSize(c.MaxImageSize)
+}
+
+// GetQueueTimeout parses how long requests wait for a slot, 0 means no limit
+func (c *ModelConfig) GetQueueTimeout() (time.Duration, error) {
+	return parseDuration(c.QueueTimeout, 0)
+}
+
+// GetStreamIdleTimeout parses the stream idle timeout string, 0 means disabled
+func (c *ModelConfig) GetStreamIdleTimeout() (time.Duration, error) {
+	return parseDuration(c.StreamIdleTimeout, 0)
+}
+
+// GetDelay parses the hedge delay
+func (c *HedgeConfig) GetDelay() (time.Duration, error) {
+	return parseDuration(c.Delay, time.Second)
+}
+
+// GetWindow parses the rolling window of the error rate
+func (c *CircuitBreakerConfig) GetWindow() (time.Duration, error) {
+	return parseDuration(c.Window, time.Minute)
+}
+
+// GetOpenDuration parses how long the circuit stays open before a probe
+func (c *CircuitBreakerConfig) GetOpenDuration() (time.Duration, error) {
+	return parseDuration(c.OpenDuration, 30*time.Second)
+}
+
+// GetKeepAlive parses the Ollama keep-alive duration
+func (c *WarmupConfig) GetKeepAlive() (time.Duration, error) {
+	return parseDuration(c.KeepAlive, 30*time.Minute)
+}
+
+// GetInterval parses the warm-up check interval
+func (c *WarmupConfig) GetInterval() (time.Duration, error) {
+	return parseDuration(c.Interval, time.Minute)
+}
+
+// GetInterval parses the backend poll interval
+func (c *BackendMonitorConfig) GetInterval() (time.Duration, error) {
+	return parseDuration(c.Interval, 5*time.Second)
+}
+
+// GetThreshold returns the fallback threshold, defaulting to the full budget
+func (c *FallbackConfig) GetThreshold() float64 {
+	if c.Threshold <= 0 {
+		return 1.0
+	}
+	return c.Threshold
+}
+
+// GetReadTimeout parses the read timeout string
+func (c *ServerConfig) GetReadTimeout() (time.Duration, error) {
+	return parseDuration(c.ReadTimeout, 15*time.Second)
+}
+
+// GetWriteTimeout parses the write timeout string
+func (c *ServerConfig) GetWriteTimeout() (time.Duration, error) {
+	return parseDuration(c.WriteTimeout, 15*time.Second)
+}
+
+// GetIdleTimeout parses the idle timeout string
+func (c *ServerConfig) GetIdleTimeout() (time.Duration, error) {
+	return parseDuration(c.IdleTimeout, 60*time.Second)
+}
+
+// GetDrainTimeout parses the shutdown drain timeout
+func (c *ServerConfig) GetDrainTimeout() (time.Duration, error) {
+	return parseDuration(c.DrainTimeout, 30*time.Second)
+}
+
+// GetSoftLimit parses the soft limit size, 0 means disabled
+func (c *MemoryConfig) GetSoftLimit() (int64, error) {
+	return parseByteSize(c.SoftLimit)
+}
+
+// GetHardLimit parses the hard limit size, 0 means disabled
+func (c *MemoryConfig) GetHardLimit() (int64, error) {
+	return parseByteSize(c.HardLimit)
+}
+
+// GetCheckInterval parses the check interval string
+func (c *MemoryConfig) GetCheckInterval() (time.Duration, error) {
+	return parseDuration(c.CheckInterval, 10*time.Second)
+}
+
+// GetMaxInputSize parses the input size limit, 0 means disabled
+func (c *LimitsConfig) GetMaxInputSize() (int64, error) {
+	return parseByteSize(c.MaxInputSize)
+}
+
+// GetMaxResponseSize parses the response size limit, 0 means disabled
+func (c *LimitsConfig) GetMaxResponseSize() (int64, error) {
+	return parseByteSize(c.MaxResponseSize)
+}
+
+// GetTTL parses how long cached responses are kept
+func (c *CacheConfig) GetTTL() (time.Duration, error) {
+	return parseDuration(c.TTL, time.Hour)
+}
+
+// GetMaxSize parses the memory cache size limit
+func (c *CacheConfig) GetMaxSize() (int64, error) {
+	if c.MaxSize == "" {
+		return 64 << 20, nil
+	}
+	return parseByteSize(c.MaxSize)
+}
+
+// parseDuration parses s, returning def when s is empty
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseByteSize parses sizes like "512MiB", "1GB" or "1048576"; empty means 0
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+		{"B", 1},
+	}
+
+	scale := int64(1)
+	number := s
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			scale = u.scale
+			number = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(scale)), nil
+}
+
 // GetLogLevel parses the log level string
 func (c *LoggingConfig) GetLogLevel() string {
 	switch c.Level {
@@ -122,3 +1476,98 @@ func (c *LoggingConfig) GetLogLevel() string {
 		return "info"
 	}
 }
+
+// Redacted returns a copy of the configuration with secrets masked, safe to print
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Model.APIKey = mask(c.Model.APIKey)
+	r.Model.Headers = maskValues(c.Model.Headers)
+	r.Model.Hedge.APIKey = mask(c.Model.Hedge.APIKey)
+	r.Model.CircuitBreaker.Fallback.APIKey = mask(c.Model.CircuitBreaker.Fallback.APIKey)
+	r.Admin.Token = mask(c.Admin.Token)
+	r.Budget.WebhookURL = mask(c.Budget.WebhookURL)
+	r.Budget.SlackWebhookURL = mask(c.Budget.SlackWebhookURL)
+	r.Budget.Fallback.APIKey = mask(c.Budget.Fallback.APIKey)
+	r.Refusal.Fallback.APIKey = mask(c.Refusal.Fallback.APIKey)
+	r.RAG.Embedding.APIKey = mask(c.RAG.Embedding.APIKey)
+	r.Guardrails.Moderation.APIKey = mask(c.Guardrails.Moderation.APIKey)
+	r.Speech.APIKey = mask(c.Speech.APIKey)
+	r.Images.APIKey = mask(c.Images.APIKey)
+	r.Artifacts.S3.SecretAccessKey = mask(c.Artifacts.S3.SecretAccessKey)
+	r.Slack.AppToken = mask(c.Slack.AppToken)
+	r.Slack.BotToken = mask(c.Slack.BotToken)
+	r.Telegram.Token = mask(c.Telegram.Token)
+	r.Telegram.WebhookSecret = mask(c.Telegram.WebhookSecret)
+	r.Discord.Token = mask(c.Discord.Token)
+	r.Cache.RedisURL = mask(c.Cache.RedisURL)
+	r.Encryption.MasterKey = mask(c.Encryption.MasterKey)
+	r.Server.APIKeys.Keys = slices.Clone(c.Server.APIKeys.Keys)
+	for i := range r.Server.APIKeys.Keys {
+		r.Server.APIKeys.Keys[i].Key = mask(r.Server.APIKeys.Keys[i].Key)
+	}
+	r.Agents = slices.Clone(c.Agents)
+	for i := range r.Agents {
+		r.Agents[i].Model.APIKey = mask(r.Agents[i].Model.APIKey)
+	}
+	return &r
+}
+
+// Secrets returns the credentials of the configuration, masked in logs
+func (c *Config) Secrets() []string {
+	secrets := []string{
+		c.Model.APIKey,
+		c.Model.Hedge.APIKey,
+		c.Model.CircuitBreaker.Fallback.APIKey,
+		c.Admin.Token,
+		c.Budget.WebhookURL,
+		c.Budget.SlackWebhookURL,
+		c.Budget.Fallback.APIKey,
+		c.Refusal.Fallback.APIKey,
+		c.RAG.Embedding.APIKey,
+		c.Guardrails.Moderation.APIKey,
+		c.Speech.APIKey,
+		c.Images.APIKey,
+		c.Artifacts.S3.SecretAccessKey,
+		c.Slack.AppToken,
+		c.Slack.BotToken,
+		c.Telegram.Token,
+		c.Telegram.WebhookSecret,
+		c.Discord.Token,
+		c.Cache.RedisURL,
+		c.Encryption.MasterKey,
+	}
+	for _, v := range c.Model.Headers {
+		secrets = append(secrets, v)
+	}
+	for _, k := range c.Server.APIKeys.Keys {
+		secrets = append(secrets, k.Key)
+	}
+	for _, a := range c.Agents {
+		secrets = append(secrets, a.Model.APIKey)
+	}
+	return slices.DeleteFunc(secrets, func(s string) bool { return s == "" })
+}
+
+// mask keeps a short prefix of a secret so it can still be told apart
+func mask(s string) string {
+	switch {
+	case s == "":
+		return ""
+	case len(s) <= 8:
+		return "****"
+	default:
+		return s[:4] + "****"
+	}
+}
+
+// maskValues masks every value of a header map, which often carries credentials
+func maskValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(m))
+	for k, v := range m {
+		masked[k] = mask(v)
+	}
+	return masked
+}
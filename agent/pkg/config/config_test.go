@@ -0,0 +1,100 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestModelFor tests applying agent model profiles to the top-level model
+func TestModelFor(t *testing.T) {
+	cfg := &Config{Model: ModelConfig{
+		Provider:      "deepseek",
+		APIKey:        "sk-deepseek",
+		ModelName:     "deepseek-chat",
+		BaseURL:       "https://gateway.example.com",
+		Timeout:       "2m",
+		StreamRetries: 2,
+		Headers:       map[string]string{"X-Key": "secret"},
+	}}
+
+	tests := []struct {
+		name    string
+		profile AgentModelConfig
+		want    ModelConfig
+	}{
+		{"inherit", AgentModelConfig{}, cfg.Model},
+		{"model name", AgentModelConfig{ModelName: "deepseek-reasoner"}, ModelConfig{
+			Provider: "deepseek", APIKey: "sk-deepseek", ModelName: "deepseek-reasoner", BaseURL: "https://gateway.example.com",
+			Timeout: "2m", StreamRetries: 2, Headers: cfg.Model.Headers,
+		}},
+		{"other provider", AgentModelConfig{Provider: "openai", ModelName: "gpt-4o-mini", APIKey: "sk-openai"}, ModelConfig{
+			Provider: "openai", APIKey: "sk-openai", ModelName: "gpt-4o-mini", Timeout: "2m", StreamRetries: 2,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cfg.ModelFor(&AgentConfig{Name: "a", Model: tt.profile})
+			if got.Provider != tt.want.Provider || got.APIKey != tt.want.APIKey || got.ModelName != tt.want.ModelName ||
+				got.BaseURL != tt.want.BaseURL || got.Timeout != tt.want.Timeout || got.StreamRetries != tt.want.StreamRetries ||
+				len(got.Headers) != len(tt.want.Headers) {
+				t.Errorf("ModelFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// A switch back to DeepSeek from another provider gets its defaults
+	cfg.Model = ModelConfig{Provider: "openai", APIKey: "sk-openai"}
+	got := cfg.ModelFor(&AgentConfig{Model: AgentModelConfig{Provider: "deepseek", APIKey: "sk-deepseek"}})
+	if got.ModelName != "deepseek-chat" || got.BaseURL != "https://api.deepseek.com" {
+		t.Errorf("ModelFor() = %+v, want the DeepSeek defaults", got)
+	}
+}
+
+// TestCheckDelegation tests sub-agent, agent tool and workflow step references
+func TestCheckDelegation(t *testing.T) {
+	agent := func(name string, sub, tools []string) AgentConfig {
+		return AgentConfig{Name: name, SubAgents: sub, AgentTools: tools}
+	}
+	tests := []struct {
+		name      string
+		root      AgentConfig
+		agents    []AgentConfig
+		workflows []WorkflowConfig
+		wantErr   string
+	}{
+		{"coordinator", agent("root", []string{"researcher", "writer"}, []string{"coder"}),
+			[]AgentConfig{agent("researcher", nil, []string{"coder"}), agent("writer", nil, nil), agent("coder", nil, nil)}, nil, ""},
+		{"unknown", agent("root", []string{"nobody"}, nil), nil, nil, `root refers to "nobody"`},
+		{"root as delegate", agent("root", nil, nil), []AgentConfig{agent("a", nil, []string{"root"})}, nil, `a refers to "root"`},
+		{"cycle", agent("root", []string{"a"}, nil),
+			[]AgentConfig{agent("a", nil, []string{"b"}), agent("b", []string{"a"}, nil)}, nil, "root -> a -> b -> a"},
+		{"repeated sub-agent", agent("root", []string{"a", "b"}, nil),
+			[]AgentConfig{agent("a", []string{"c"}, nil), agent("b", []string{"c"}, nil), agent("c", nil, nil)}, nil, "c appears more than once"},
+		{"repeated agent tool", agent("root", []string{"a"}, []string{"c"}),
+			[]AgentConfig{agent("a", nil, []string{"c"}), agent("c", nil, nil)}, nil, ""},
+		{"workflows", agent("root", nil, []string{"pipeline"}),
+			[]AgentConfig{agent("draft", nil, nil), agent("review", nil, nil), agent("editor", nil, []string{"draft"})},
+			[]WorkflowConfig{
+				{Name: "pipeline", Steps: []string{"draft", "reviews"}},
+				{Name: "reviews", Type: "parallel", Steps: []string{"review"}, Merge: "editor"},
+			}, ""},
+		{"unknown merge", agent("root", nil, nil), []AgentConfig{agent("draft", nil, nil)},
+			[]WorkflowConfig{{Name: "fanout", Type: "parallel", Steps: []string{"draft"}, Merge: "nobody"}},
+			`fanout refers to "nobody"`},
+		{"repeated step", agent("root", nil, nil), []AgentConfig{agent("draft", nil, nil)},
+			[]WorkflowConfig{{Name: "pipeline", Steps: []string{"draft", "again"}}, {Name: "again", Steps: []string{"draft"}}},
+			"draft appears more than once in the agent tree of pipeline"},
+		{"workflow cycle", agent("root", nil, nil), []AgentConfig{agent("draft", nil, nil)},
+			[]WorkflowConfig{{Name: "a", Steps: []string{"draft", "b"}}, {Name: "b", Steps: []string{"a"}}},
+			"a -> b -> a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Agent: tt.root, Agents: tt.agents, Workflows: tt.workflows}
+			err := cfg.checkDelegation()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkDelegation() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
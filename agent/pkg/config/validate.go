@@ -0,0 +1,534 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Validate checks every field that is otherwise only checked when the
+// component using it starts, returning all problems joined
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if err := c.checkRequired(); err != nil {
+		v.add("model", "%v", err)
+	}
+	if _, ok := providerKeyEnv[c.Model.Provider]; !ok && c.Model.Provider != "triton" {
+		v.add("model.provider", "unknown provider %q (must be one of %s)", c.Model.Provider, strings.Join(Providers(), ", "))
+	}
+	v.duration("model.timeout", c.Model.Timeout)
+	v.duration("model.stream_idle_timeout", c.Model.StreamIdleTimeout)
+	v.byteSize("model.max_inline_data_size", c.Model.MaxInlineDataSize)
+	v.byteSize("model.max_image_size", c.Model.MaxImageSize)
+	if c.Model.MaxImageDimension < 0 {
+		v.add("model.max_image_dimension", "must not be negative, got %d", c.Model.MaxImageDimension)
+	}
+	v.nonNegative("model.stream_retries", c.Model.StreamRetries)
+	v.nonNegative("model.max_concurrent_requests", c.Model.MaxConcurrentRequests)
+	v.duration("model.queue_timeout", c.Model.QueueTimeout)
+	v.nonNegative("model.context_window", c.Model.ContextWindow)
+	v.oneOf("model.triton.backend", c.Model.Triton.Backend, "vllm", "tensorrtllm")
+	v.oneOf("model.triton.template", c.Model.Triton.Template, "chatml", "llama3", "plain")
+	v.oneOf("model.openrouter.provider.data_collection", c.Model.OpenRouter.Provider.DataCollection, "allow", "deny")
+	v.oneOf("model.openrouter.provider.sort", c.Model.OpenRouter.Provider.Sort, "price", "throughput", "latency")
+	v.oneOf("model.warmup.backend", c.Model.Warmup.Backend, "ollama", "llamacpp")
+	v.duration("model.warmup.keep_alive", c.Model.Warmup.KeepAlive)
+	v.duration("model.warmup.interval", c.Model.Warmup.Interval)
+	v.oneOf("model.monitor.backend", c.Model.Monitor.Backend, "ollama", "vllm")
+	v.duration("model.monitor.interval", c.Model.Monitor.Interval)
+	v.nonNegative("model.monitor.max_waiting", c.Model.Monitor.MaxWaiting)
+	v.fraction("model.monitor.max_cache_usage", c.Model.Monitor.MaxCacheUsage)
+	v.nonNegative("model.monitor.max_concurrency", c.Model.Monitor.MaxConcurrency)
+	v.nonNegative("model.monitor.saturated_concurrency", c.Model.Monitor.SaturatedConcurrency)
+	v.duration("model.hedge.delay", c.Model.Hedge.Delay)
+	if c.Model.Hedge.ModelName != "" {
+		v.profile(c, "model.hedge", c.Model.Hedge.Profile())
+	}
+	v.nonNegative("model.json_repair.max_retries", c.Model.JSONRepair.MaxRetries)
+	v.duration("model.circuit_breaker.window", c.Model.CircuitBreaker.Window)
+	v.duration("model.circuit_breaker.open_duration", c.Model.CircuitBreaker.OpenDuration)
+	v.nonNegative("model.circuit_breaker.min_requests", c.Model.CircuitBreaker.MinRequests)
+	v.fraction("model.circuit_breaker.failure_rate", c.Model.CircuitBreaker.FailureRate)
+	if fb := c.Model.CircuitBreaker.Fallback; fb.ModelName != "" {
+		v.profile(c, "model.circuit_breaker.fallback", fb.Profile())
+	}
+
+	if c.Agent.Name == "" {
+		v.add("agent.name", "is required")
+	}
+	v.agent("agent", &c.Agent)
+	if c.Agent.Model.IsSet() {
+		v.add("agent.model", "only applies to entries of agents, set the top-level model instead")
+	}
+	names := map[string]bool{c.Agent.Name: true}
+	for i := range c.Agents {
+		a := &c.Agents[i]
+		field := fmt.Sprintf("agents[%d]", i)
+		switch {
+		case a.Name == "":
+			v.add(field+".name", "is required")
+		case names[a.Name]:
+			v.add(field+".name", "duplicate agent name %q", a.Name)
+		}
+		names[a.Name] = true
+		v.agent(field, a)
+		v.profile(c, field+".model", a.Model)
+	}
+	for i, w := range c.Workflows {
+		field := fmt.Sprintf("workflows[%d]", i)
+		switch {
+		case w.Name == "":
+			v.add(field+".name", "is required")
+		case names[w.Name]:
+			v.add(field+".name", "duplicate agent name %q", w.Name)
+		}
+		names[w.Name] = true
+		v.oneOf(field+".type", w.Type, "sequential", "parallel")
+		if len(w.Steps) == 0 {
+			v.add(field+".steps", "is required")
+		}
+		if w.Merge != "" && w.Type != "parallel" {
+			v.add(field+".merge", "only applies to parallel workflows")
+		}
+	}
+	if err := c.checkDelegation(); err != nil {
+		v.add("agents", "%v", err)
+	}
+
+	if c.Shell.Enabled && len(c.Shell.Allow) == 0 {
+		v.add("shell.allow", "lists no command, so the enabled shell tool could run nothing")
+	}
+	for i, name := range c.Shell.Allow {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			v.add(fmt.Sprintf("shell.allow[%d]", i), "%q must be a program name without a path", name)
+		}
+	}
+	v.duration("shell.timeout", c.Shell.Timeout)
+	v.byteSize("shell.max_output", c.Shell.MaxOutput)
+	v.oneOf("interpreter.runtime", c.Interpreter.Runtime, "process", "docker", "podman")
+	for i, lang := range c.Interpreter.Languages {
+		v.oneOf(fmt.Sprintf("interpreter.languages[%d]", i), lang, "python", "go")
+	}
+	for lang := range c.Interpreter.Images {
+		if len(c.Interpreter.Languages) > 0 && !slices.Contains(c.Interpreter.Languages, lang) {
+			v.add("interpreter.images."+lang, "names a language that is not enabled")
+		}
+	}
+	v.duration("interpreter.timeout", c.Interpreter.Timeout)
+	v.byteSize("interpreter.max_output", c.Interpreter.MaxOutput)
+	v.byteSize("interpreter.max_memory", c.Interpreter.MaxMemory)
+	v.byteSize("workspace.max_file_size", c.Workspace.MaxFileSize)
+	if c.WebSearch.Enabled {
+		switch p := c.WebSearch.Provider; {
+		case p == "":
+			v.add("web_search.provider", "is required (searxng, brave, tavily or bing)")
+		case p == "searxng":
+			if c.WebSearch.BaseURL == "" {
+				v.add("web_search.base_url", "is required for searxng")
+			}
+		case searchKeyEnv[p] == "":
+			v.oneOf("web_search.provider", p, "searxng", "brave", "tavily", "bing")
+		case c.WebSearch.APIKey == "":
+			v.add("web_search.api_key", "is required (or set %s)", searchKeyEnv[p])
+		}
+	}
+	if c.WebSearch.MaxResults < 0 {
+		v.add("web_search.max_results", "must not be negative")
+	}
+	v.duration("web_search.timeout", c.WebSearch.Timeout)
+
+	if c.HTTPRequest.Enabled && len(c.HTTPRequest.Allow) == 0 {
+		v.add("http_request.allow", "lists no host, so the enabled http_request tool could reach nothing")
+	}
+	for i, pattern := range slices.Concat(c.HTTPRequest.Allow, c.HTTPRequest.Deny) {
+		field := fmt.Sprintf("http_request.allow[%d]", i)
+		if i >= len(c.HTTPRequest.Allow) {
+			field = fmt.Sprintf("http_request.deny[%d]", i-len(c.HTTPRequest.Allow))
+		}
+		if pattern == "" || strings.ContainsAny(strings.TrimPrefix(pattern, "*."), "*/?#@ ") {
+			v.add(field, "%q must be a host name, optionally with a port or a leading *.", pattern)
+		}
+	}
+	for i, method := range c.HTTPRequest.Methods {
+		v.oneOf(fmt.Sprintf("http_request.methods[%d]", i), strings.ToUpper(method), "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+	}
+	v.duration("http_request.timeout", c.HTTPRequest.Timeout)
+	v.byteSize("http_request.max_response_size", c.HTTPRequest.MaxResponseSize)
+
+	if c.Images.MaxImages < 0 {
+		v.add("images.max_images", "must not be negative, got %d", c.Images.MaxImages)
+	}
+
+	v.oneOf("artifacts.backend", c.Artifacts.Backend, "memory", "dir", "s3")
+	if c.Artifacts.Backend == "s3" {
+		if c.Artifacts.S3.Bucket == "" {
+			v.add("artifacts.s3.bucket", "is required with the s3 backend")
+		}
+		if c.Artifacts.S3.AccessKeyID == "" || c.Artifacts.S3.SecretAccessKey == "" {
+			v.add("artifacts.s3", "access_key_id and secret_access_key are required with the s3 backend (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+		}
+	}
+
+	v.toolPolicy("tool_runtime.default", &c.ToolRuntime.Default)
+	for _, name := range slices.Sorted(maps.Keys(c.ToolRuntime.Tools)) {
+		p := c.ToolRuntime.Tools[name]
+		v.toolPolicy("tool_runtime.tools."+name, &p)
+	}
+
+	if c.Checkpoints.Max < 0 {
+		v.add("checkpoints.max", "must not be negative, got %d", c.Checkpoints.Max)
+	}
+	for i := range c.Guardrails.Input {
+		v.guardrail(fmt.Sprintf("guardrails.input[%d]", i), &c.Guardrails.Input[i])
+	}
+	for i := range c.Guardrails.Output {
+		v.guardrail(fmt.Sprintf("guardrails.output[%d]", i), &c.Guardrails.Output[i])
+	}
+	v.duration("sessions.idle_ttl", c.Sessions.IdleTTL)
+	v.duration("sessions.check_interval", c.Sessions.CheckInterval)
+	if c.Sessions.IdleTTL != "" && c.Sessions.ArchiveDir == "" {
+		v.add("sessions.archive_dir", "is required with sessions.idle_ttl")
+	}
+	if c.Encryption.Enabled {
+		v.oneOf("encryption.provider", c.Encryption.Provider, "local")
+		v.oneOf("encryption.tenant", c.Encryption.Tenant, "user", "app")
+		if c.Encryption.MasterKey == "" && c.Encryption.MasterKeyFile == "" {
+			v.add("encryption.master_key", "is required when encryption is enabled (or master_key_file, or ENCRYPTION_MASTER_KEY)")
+		}
+	}
+	for i, h := range c.Hooks {
+		if h.Name == "" {
+			v.add(fmt.Sprintf("hooks[%d].name", i), "is required")
+		}
+	}
+	personas := make(map[string]bool, len(c.Personas.Presets))
+	for i, p := range c.Personas.Presets {
+		field := fmt.Sprintf("personas.presets[%d].name", i)
+		switch {
+		case p.Name == "":
+			v.add(field, "is required")
+		case p.Name == "default" || strings.ContainsAny(p.Name, " \t\n"):
+			v.add(field, "%q is not a valid persona name", p.Name)
+		case personas[p.Name]:
+			v.add(field, "duplicate persona %q", p.Name)
+		}
+		personas[p.Name] = true
+	}
+	if c.Personas.Default != "" && !personas[c.Personas.Default] {
+		v.add("personas.default", "%q is not one of personas.presets", c.Personas.Default)
+	}
+
+	v.oneOf("logging.level", c.Logging.Level, "debug", "info", "warn", "error")
+	v.oneOf("logging.format", c.Logging.Format, "text", "json")
+	v.oneOf("logging.log_prompts", c.Logging.LogPrompts, "none", "truncated", "full")
+	for _, component := range slices.Sorted(maps.Keys(c.Logging.Levels)) {
+		v.oneOf("logging.levels."+component, c.Logging.Levels[component], "debug", "info", "warn", "error")
+	}
+	v.byteSize("logging.file.max_size", c.Logging.File.MaxSize)
+	v.duration("logging.file.max_age", c.Logging.File.MaxAge)
+	if c.Logging.File.MaxBackups < 0 {
+		v.add("logging.file.max_backups", "must not be negative")
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		v.add("server.port", "%d is not a valid port", c.Server.Port)
+	}
+	v.duration("server.read_timeout", c.Server.ReadTimeout)
+	v.duration("server.write_timeout", c.Server.WriteTimeout)
+	v.duration("server.idle_timeout", c.Server.IdleTimeout)
+	v.duration("server.drain_timeout", c.Server.DrainTimeout)
+	v.oneOf("server.health.upstream", c.Server.Health.Upstream, "models", "completion")
+	v.duration("server.health.cache_ttl", c.Server.Health.CacheTTL)
+	v.duration("server.health.timeout", c.Server.Health.Timeout)
+	if keys, err := c.Server.APIKeys.Load(); err != nil {
+		v.add("server.api_keys.file", "%v", err)
+	} else {
+		names := make(map[string]bool, len(keys))
+		for i, k := range keys {
+			field := fmt.Sprintf("server.api_keys.keys[%d]", i)
+			if i >= len(c.Server.APIKeys.Keys) {
+				field = fmt.Sprintf("server.api_keys.file[%d]", i-len(c.Server.APIKeys.Keys))
+			}
+			switch {
+			case k.Name == "":
+				v.add(field+".name", "is required")
+			case names[k.Name]:
+				v.add(field+".name", "duplicate key name %q", k.Name)
+			}
+			names[k.Name] = true
+			if (k.Key == "") == (k.SHA256 == "") {
+				v.add(field, "set either key or sha256")
+			}
+			if k.RequestsPerMinute < 0 || k.TokensPerDay < 0 {
+				v.add(field, "quotas must not be negative")
+			}
+			if k.TokensPerDay > 0 && !c.Usage.Enabled {
+				v.add(field+".tokens_per_day", "requires usage.enabled")
+			}
+		}
+	}
+
+	if c.Server.A2A.Enabled {
+		if c.Server.A2A.URL != "" {
+			if u, err := url.Parse(c.Server.A2A.URL); err != nil || u.Scheme == "" || u.Host == "" {
+				v.add("server.a2a.url", "%q is not an absolute URL", c.Server.A2A.URL)
+			}
+		}
+		skills := make(map[string]bool, len(c.Server.A2A.Skills))
+		for i, s := range c.Server.A2A.Skills {
+			field := fmt.Sprintf("server.a2a.skills[%d]", i)
+			switch {
+			case s.ID == "":
+				v.add(field+".id", "is required")
+			case skills[s.ID]:
+				v.add(field+".id", "duplicate skill %q", s.ID)
+			}
+			skills[s.ID] = true
+			if s.Name == "" {
+				v.add(field+".name", "is required")
+			}
+		}
+	}
+
+	v.duration("slack.update_interval", c.Slack.UpdateInterval)
+	if cmd := c.Slack.SlashCommand; cmd != "" && (!strings.HasPrefix(cmd, "/") || strings.ContainsAny(cmd, " \t")) {
+		v.add("slack.slash_command", "%q is not a slash command, e.g. \"/yanshu\"", cmd)
+	}
+	if c.Speech.Enabled {
+		v.oneOf("speech.format", c.Speech.Format, "mp3", "opus", "aac", "flac", "wav", "pcm")
+		if c.Speech.Speed != 0 && (c.Speech.Speed < 0.25 || c.Speech.Speed > 4) {
+			v.add("speech.speed", "%v is out of range [0.25, 4]", c.Speech.Speed)
+		}
+	}
+	v.duration("telegram.update_interval", c.Telegram.UpdateInterval)
+	if c.Telegram.WebhookURL != "" {
+		if u, err := url.Parse(c.Telegram.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			v.add("telegram.webhook_url", "%q is not an HTTPS URL", c.Telegram.WebhookURL)
+		}
+		if _, _, err := net.SplitHostPort(cmp.Or(c.Telegram.Listen, ":8443")); err != nil {
+			v.add("telegram.listen", "%v", err)
+		}
+	}
+	v.duration("discord.update_interval", c.Discord.UpdateInterval)
+
+	v.byteSize("memory.soft_limit", c.Memory.SoftLimit)
+	v.byteSize("memory.hard_limit", c.Memory.HardLimit)
+	v.duration("memory.check_interval", c.Memory.CheckInterval)
+
+	if c.Admin.Enabled {
+		if _, _, err := net.SplitHostPort(c.Admin.Addr); err != nil {
+			v.add("admin.addr", "%v", err)
+		}
+	}
+	v.duration("admin.drain_timeout", c.Admin.DrainTimeout)
+
+	if c.Usage.Enabled && c.Usage.Path == "" {
+		v.add("usage.path", "is required when usage is enabled")
+	}
+	if c.Budget.Monthly < 0 {
+		v.add("budget.monthly", "cannot be negative")
+	}
+	if c.Budget.Monthly > 0 && !c.Usage.Enabled {
+		v.add("budget.monthly", "requires usage.enabled")
+	}
+	for _, t := range c.Budget.Thresholds {
+		if t <= 0 || t > 1 {
+			v.add("budget.thresholds", "%v is not a fraction in (0, 1]", t)
+		}
+	}
+	v.fraction("budget.fallback.threshold", c.Budget.Fallback.Threshold)
+	v.nonNegative("budget.max_turn_tokens", c.Budget.MaxTurnTokens)
+	v.nonNegative("budget.max_session_tokens", c.Budget.MaxSessionTokens)
+	if c.Budget.MaxSessionCost < 0 {
+		v.add("budget.max_session_cost", "cannot be negative")
+	}
+	if c.Budget.MaxSessionCost > 0 && len(c.Usage.Pricing) == 0 {
+		v.add("budget.max_session_cost", "requires usage.pricing")
+	}
+	v.oneOf("budget.action", c.Budget.Action, "refuse", "summarize")
+
+	v.byteSize("limits.max_input_size", c.Limits.MaxInputSize)
+	v.byteSize("limits.max_response_size", c.Limits.MaxResponseSize)
+	v.nonNegative("limits.max_input_tokens", c.Limits.MaxInputTokens)
+	v.nonNegative("limits.max_response_tokens", c.Limits.MaxResponseTokens)
+	v.oneOf("limits.input_action", c.Limits.InputAction, "truncate", "reject")
+
+	v.oneOf("refusal.policy", c.Refusal.Policy, "surface", "retry", "fallback")
+	if c.Refusal.Policy == "fallback" && c.Refusal.Fallback.ModelName == "" {
+		v.add("refusal.fallback.model_name", "is required for the fallback policy")
+	}
+
+	if c.Cache.Enabled {
+		v.oneOf("cache.backend", c.Cache.Backend, "memory", "redis")
+		if c.Cache.Backend == "redis" && c.Cache.RedisURL == "" {
+			v.add("cache.redis_url", "is required for the redis backend")
+		}
+	}
+	v.duration("cache.ttl", c.Cache.TTL)
+	v.byteSize("cache.max_size", c.Cache.MaxSize)
+	v.nonNegative("cache.max_entries", c.Cache.MaxEntries)
+
+	v.nonNegative("conversation.context_window", c.Conversation.ContextWindow)
+	v.fraction("conversation.threshold", c.Conversation.Threshold)
+	v.nonNegative("conversation.keep_turns", c.Conversation.KeepTurns)
+	v.oneOf("conversation.recover", c.Conversation.Recover, "truncate", "summarize")
+
+	if c.RAG.Enabled {
+		if c.RAG.Embedding.ModelName == "" {
+			v.add("rag.embedding.model_name", "is required when RAG is enabled")
+		}
+		v.oneOf("rag.store", c.RAG.Store, "memory", "file", "sqlite")
+		if c.RAG.Store != "memory" && c.RAG.StorePath == "" {
+			v.add("rag.store_path", "is required for the file and SQLite stores")
+		}
+		v.nonNegative("rag.chunk_size", c.RAG.ChunkSize)
+		v.nonNegative("rag.chunk_overlap", c.RAG.ChunkOverlap)
+		v.nonNegative("rag.top_k", c.RAG.TopK)
+	}
+
+	v.oneOf("policy.default", c.Policy.Default, "allow", "deny")
+	for i, r := range c.Policy.Rules {
+		field := fmt.Sprintf("policy.rules[%d]", i)
+		v.oneOf(field+".on", r.On, "tool", "request")
+		if r.Effect == "" {
+			v.add(field+".effect", "is required")
+		}
+		v.oneOf(field+".effect", r.Effect, "allow", "deny", "modify")
+	}
+
+	for i, pattern := range c.Plan.ReadOnly {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.add(fmt.Sprintf("plan.read_only[%d]", i), "invalid pattern %q", pattern)
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// Providers returns the known model provider names, sorted
+func Providers() []string {
+	names := append(slices.Collect(maps.Keys(providerKeyEnv)), "triton")
+	slices.Sort(names)
+	return names
+}
+
+// validator collects problems, each prefixed with the YAML path of its field
+type validator struct {
+	errs []error
+}
+
+// agent checks the context sections of an agent
+func (v *validator) agent(field string, a *AgentConfig) {
+	for i, section := range a.Context {
+		if section.Provider == "" && section.Template == "" {
+			v.add(fmt.Sprintf("%s.context[%d]", field, i), "needs a provider or a template")
+		}
+	}
+	v.nonNegative(field+".max_tool_iterations", a.MaxToolIterations)
+	v.oneOf(field+".on_max_tool_iterations", a.OnMaxToolIterations, "stop", "summarize", "error")
+}
+
+// profile checks a model profile, which must name a known provider and end
+// up with an API key
+func (v *validator) profile(c *Config, field string, p AgentModelConfig) {
+	if p.Provider != "" {
+		if _, ok := providerKeyEnv[p.Provider]; !ok && p.Provider != "triton" {
+			v.add(field+".provider", "unknown provider %q (must be one of %s)", p.Provider, strings.Join(Providers(), ", "))
+		}
+	}
+	if m := c.ModelFor(&AgentConfig{Model: p}); m.APIKey == "" && m.Provider != "triton" {
+		v.add(field+".api_key", "is required for provider %s", m.Provider)
+	}
+}
+
+// toolPolicy checks the execution policy of a tool
+func (v *validator) toolPolicy(field string, p *ToolPolicyConfig) {
+	v.duration(field+".timeout", p.Timeout)
+	v.nonNegative(field+".retries", p.Retries)
+	v.byteSize(field+".max_result_size", p.MaxResultSize)
+}
+
+// guardrail checks one check of a guardrail pipeline
+func (v *validator) guardrail(field string, g *GuardrailCheckConfig) {
+	switch g.Check {
+	case "max_size":
+		if size, err := g.GetMaxSize(); err != nil {
+			v.add(field+".max_size", "%v", err)
+		} else if size <= 0 {
+			v.add(field+".max_size", "is required for the max_size check")
+		}
+	case "blocked_topics":
+		if len(g.Topics) == 0 {
+			v.add(field+".topics", "is required for the blocked_topics check")
+		}
+	case "moderation":
+		if strings.HasPrefix(field, "guardrails.output") {
+			v.add(field+".check", "moderation only checks input")
+		}
+	case "prompt_injection", "pii", "secrets":
+	case "":
+		v.add(field+".check", "is required")
+	default:
+		v.add(field+".check", "invalid value %q (must be one of max_size, prompt_injection, blocked_topics, pii, secrets, moderation)", g.Check)
+	}
+	v.oneOf(field+".action", g.Action, "block", "redact", "log")
+	if g.Action == "redact" && g.Check != "pii" && g.Check != "secrets" {
+		v.add(field+".action", "only pii and secrets can redact")
+	}
+	for i, t := range g.Types {
+		v.oneOf(fmt.Sprintf("%s.types[%d]", field, i), t, "email", "credit_card", "iban", "ssn", "phone", "ip")
+	}
+	for _, name := range slices.Sorted(maps.Keys(g.Patterns)) {
+		if _, err := regexp.Compile(g.Patterns[name]); err != nil {
+			v.add(field+".patterns."+name, "%v", err)
+		}
+	}
+	for _, category := range slices.Sorted(maps.Keys(g.Thresholds)) {
+		v.fraction(field+".thresholds."+category, g.Thresholds[category])
+	}
+}
+
+func (v *validator) add(field, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) duration(field, s string) {
+	if d, err := parseDuration(s, 0); err != nil {
+		v.add(field, "invalid duration %q", s)
+	} else if d < 0 {
+		v.add(field, "cannot be negative")
+	}
+}
+
+func (v *validator) byteSize(field, s string) {
+	if _, err := parseByteSize(s); err != nil {
+		v.add(field, "%v", err)
+	}
+}
+
+func (v *validator) nonNegative(field string, n int) {
+	if n < 0 {
+		v.add(field, "cannot be negative")
+	}
+}
+
+func (v *validator) fraction(field string, f float64) {
+	if f < 0 || f > 1 {
+		v.add(field, "%v is not a fraction in [0, 1]", f)
+	}
+}
+
+// oneOf checks an optional enum, empty selects the default
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	if value != "" && !slices.Contains(allowed, value) {
+		v.add(field, "invalid value %q (must be one of %s)", value, strings.Join(allowed, ", "))
+	}
+}
@@ -0,0 +1,145 @@
+package conversation
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+	"google.golang.org/adk/model"
+)
+
+// Strategy is how a request rejected for its length is shortened
+type Strategy string
+
+const (
+	// StrategyTruncate drops the turns before the kept ones
+	StrategyTruncate Strategy = "truncate"
+	// StrategySummarize replaces the turns before the kept ones with a
+	// summary, and truncates when the summary fails
+	StrategySummarize Strategy = "summarize"
+)
+
+// RecoveryConfig holds the settings of context length recovery
+type RecoveryConfig struct {
+	Strategy         Strategy // Defaults to StrategyTruncate
+	KeepTurns        int      // Recent turns kept verbatim, defaults to 4
+	SummaryMaxTokens int32    // Output limit of the summary, defaults to 1024
+	SummaryPrompt    string   // Defaults to DefaultSummaryPrompt
+
+	Tokenizer tokenizer.Tokenizer
+	Logger    *slog.Logger
+}
+
+// Recovery wraps a model.LLM and retries a request the provider rejected as
+// too long for the context window once, with the history shortened, instead
+// of failing the turn. Only requests that failed before any response was
+// yielded are retried; when the conversation is a single turn there is
+// nothing to shorten and the error is returned.
+type Recovery struct {
+	llm        model.LLM
+	strategy   Strategy
+	summarizer *Summarizer // Turn boundaries and summaries
+	logger     *slog.Logger
+}
+
+// NewRecovery wraps llm with context length recovery
+func NewRecovery(llm model.LLM, cfg *RecoveryConfig) (*Recovery, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	strategy := cmp.Or(cfg.Strategy, StrategyTruncate)
+	if strategy != StrategyTruncate && strategy != StrategySummarize {
+		return nil, fmt.Errorf("invalid recovery strategy %q (must be %q or %q)", strategy, StrategyTruncate, StrategySummarize)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("conversation")
+	}
+	summarizer, err := NewSummarizer(llm, &Config{
+		KeepTurns:        cfg.KeepTurns,
+		SummaryMaxTokens: cfg.SummaryMaxTokens,
+		SummaryPrompt:    cfg.SummaryPrompt,
+		Tokenizer:        cfg.Tokenizer,
+		Logger:           logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recovery{
+		llm:        llm,
+		strategy:   strategy,
+		summarizer: summarizer,
+		logger:     logger,
+	}, nil
+}
+
+// Name implements model.LLM
+func (r *Recovery) Name() string {
+	return r.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (r *Recovery) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		yielded := false
+		for resp, err := range r.llm.GenerateContent(ctx, req, stream) {
+			if err != nil && !yielded && errors.Is(err, openai_compatible.ErrContextLength) {
+				shortened, ok := r.shorten(ctx, req)
+				if !ok {
+					yield(nil, err)
+					return
+				}
+				r.logger.WarnContext(ctx, "Prompt exceeds the context window, retrying with a shorter history",
+					"strategy", r.strategy,
+					"contents", len(req.Contents),
+					"kept_contents", len(shortened.Contents),
+					"error", err,
+				)
+				for resp, err := range r.llm.GenerateContent(ctx, shortened, stream) {
+					if !yield(resp, err) {
+						return
+					}
+				}
+				return
+			}
+			yielded = true
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// shorten returns req with the turns before the kept ones summarized or
+// dropped, or only the latest turn when there are no more turns than kept.
+// It returns false when req is a single turn.
+func (r *Recovery) shorten(ctx context.Context, req *model.LLMRequest) (*model.LLMRequest, bool) {
+	older := r.summarizer.olderCount(req.Contents)
+	if r.strategy == StrategySummarize && older > 0 {
+		compacted, err := r.summarizer.compact(ctx, req)
+		if err == nil {
+			return compacted, true
+		}
+		r.logger.WarnContext(ctx, "Failed to summarize conversation, truncating it", "error", err)
+	}
+	if older == 0 {
+		older = turnsStart(req.Contents, 1)
+	}
+	if older == 0 {
+		return nil, false
+	}
+	out := *req
+	out.Contents = req.Contents[older:]
+	return &out, true
+}
@@ -0,0 +1,317 @@
+package conversation
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"iter"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// DefaultSummaryPrompt asks for a summary that can stand in for the transcript
+const DefaultSummaryPrompt = "Summarize the conversation below so it can replace the transcript. " +
+	"Keep facts about the user, decisions, open questions, tool results that are still relevant and the language the user writes in. " +
+	"Write concise bullet points and nothing else."
+
+// Config holds the summarization settings
+type Config struct {
+	ContextWindow    int     // Model context length in tokens, defaults to 64000
+	Threshold        float64 // Fraction of the context window that triggers summarization, defaults to 0.8
+	KeepTurns        int     // Recent turns kept verbatim, defaults to 4
+	SummaryMaxTokens int32   // Output limit of the summary, defaults to 1024
+	SummaryPrompt    string  // Defaults to DefaultSummaryPrompt
+
+	// Tokenizer estimates the prompt size, defaults to the one selected for the model
+	Tokenizer tokenizer.Tokenizer
+	Logger    *slog.Logger
+}
+
+// maxSessions bounds the summary cache; it is cleared when full
+const maxSessions = 10000
+
+// summary is the cached summary of the first covered contents of a session
+type summary struct {
+	covered int
+	hash    uint64
+	text    string
+}
+
+// Summarizer wraps a model.LLM and keeps requests within the context window.
+// When the estimated prompt size nears the window, turns older than the
+// kept ones are summarized with the model and the summary replaces them in
+// the system instruction. Summaries are cached per session and extended
+// incrementally as more turns age out.
+type Summarizer struct {
+	llm    model.LLM
+	cfg    Config
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	summaries map[string]summary
+}
+
+// NewSummarizer wraps llm with conversation summarization
+func NewSummarizer(llm model.LLM, cfg *Config) (*Summarizer, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+
+	c := *cfg
+	c.ContextWindow = cmp.Or(c.ContextWindow, 64000)
+	c.Threshold = cmp.Or(c.Threshold, 0.8)
+	c.KeepTurns = cmp.Or(c.KeepTurns, 4)
+	c.SummaryMaxTokens = cmp.Or(c.SummaryMaxTokens, 1024)
+	c.SummaryPrompt = cmp.Or(c.SummaryPrompt, DefaultSummaryPrompt)
+	if c.ContextWindow < 0 || c.KeepTurns < 0 || c.SummaryMaxTokens < 0 {
+		return nil, fmt.Errorf("context window, keep turns and summary max tokens cannot be negative")
+	}
+	if c.Threshold <= 0 || c.Threshold > 1 {
+		return nil, fmt.Errorf("invalid threshold %v (must be in (0, 1])", c.Threshold)
+	}
+	if c.Tokenizer == nil {
+		c.Tokenizer = tokenizer.ForModel(llm.Name())
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("conversation")
+	}
+
+	return &Summarizer{
+		llm:       llm,
+		cfg:       c,
+		logger:    logger,
+		summaries: map[string]summary{},
+	}, nil
+}
+
+// Name implements model.LLM
+func (s *Summarizer) Name() string {
+	return s.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (s *Summarizer) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if limit := int(float64(s.cfg.ContextWindow) * s.cfg.Threshold); s.estimate(req) > limit {
+			compacted, err := s.compact(ctx, req)
+			if err != nil {
+				s.logger.Warn("Failed to summarize conversation, sending it in full", "error", err)
+			} else {
+				req = compacted
+			}
+		}
+		for resp, err := range s.llm.GenerateContent(ctx, req, stream) {
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// compact returns req with the turns before the kept ones replaced by a summary
+func (s *Summarizer) compact(ctx context.Context, req *model.LLMRequest) (*model.LLMRequest, error) {
+	older := s.olderCount(req.Contents)
+	if older == 0 {
+		return req, nil
+	}
+
+	key := sessionKey(ctx)
+	s.mu.Lock()
+	cached, ok := s.summaries[key]
+	s.mu.Unlock()
+
+	// Reuse the cached summary when it still describes a prefix of the history
+	previous, from := "", 0
+	if ok && key != "" && cached.covered <= older && cached.hash == hashContents(req.Contents[:cached.covered]) {
+		previous, from = cached.text, cached.covered
+	}
+
+	text := previous
+	if from < older {
+		var err error
+		text, err = s.summarize(ctx, previous, req.Contents[from:older])
+		if err != nil {
+			return nil, err
+		}
+		s.logger.Info("Summarized conversation",
+			"summarized_contents", older-from,
+			"kept_contents", len(req.Contents)-older,
+			"summary_tokens", s.cfg.Tokenizer.Count(text),
+		)
+		if key != "" {
+			s.mu.Lock()
+			if len(s.summaries) >= maxSessions {
+				clear(s.summaries)
+			}
+			s.summaries[key] = summary{covered: older, hash: hashContents(req.Contents[:older]), text: text}
+			s.mu.Unlock()
+		}
+	}
+
+	out := *req
+	out.Contents = req.Contents[older:]
+	instruction.AppendSystem(&out, "## Summary of the earlier conversation\n"+text)
+	return &out, nil
+}
+
+// summarize asks the model to summarize contents, extending previous
+func (s *Summarizer) summarize(ctx context.Context, previous string, contents []*genai.Content) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString(s.cfg.SummaryPrompt)
+	if previous != "" {
+		prompt.WriteString("\n\nSummary so far:\n")
+		prompt.WriteString(previous)
+	}
+	prompt.WriteString("\n\nConversation:\n")
+	prompt.WriteString(transcript(contents))
+
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText(prompt.String(), genai.RoleUser)},
+		Config:   &genai.GenerateContentConfig{MaxOutputTokens: s.cfg.SummaryMaxTokens},
+	}
+	var text string
+	for resp, err := range s.llm.GenerateContent(ctx, req, false) {
+		if err != nil {
+			return "", fmt.Errorf("failed to generate summary: %w", err)
+		}
+		if resp != nil && !resp.Partial && resp.Content != nil {
+			text = contentText(resp.Content)
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return text, nil
+}
+
+// olderCount returns the number of contents before the kept turns. A turn
+// starts with a user message carrying text (not a tool response).
+func (s *Summarizer) olderCount(contents []*genai.Content) int {
+	return turnsStart(contents, s.cfg.KeepTurns)
+}
+
+// turnsStart returns the index of the first content of the last keep turns,
+// 0 when there are no more turns than that
+func turnsStart(contents []*genai.Content, keep int) int {
+	turns := 0
+	for i := len(contents) - 1; i >= 0; i-- {
+		if isTurnStart(contents[i]) {
+			turns++
+			if turns == keep {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// estimate returns the estimated prompt size of req in tokens
+func (s *Summarizer) estimate(req *model.LLMRequest) int {
+	total := 0
+	if req.Config != nil && req.Config.SystemInstruction != nil {
+		total += s.cfg.Tokenizer.Count(contentText(req.Config.SystemInstruction))
+	}
+	for _, content := range req.Contents {
+		if content != nil {
+			total += s.cfg.Tokenizer.Count(renderContent(content))
+		}
+	}
+	return total
+}
+
+func isTurnStart(content *genai.Content) bool {
+	if content == nil || content.Role != genai.RoleUser {
+		return false
+	}
+	for _, part := range content.Parts {
+		if part != nil && part.Text != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// transcript renders contents as a plain text conversation
+func transcript(contents []*genai.Content) string {
+	var b strings.Builder
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+		speaker := "Assistant"
+		if content.Role == genai.RoleUser {
+			speaker = "User"
+		}
+		if text := renderContent(content); text != "" {
+			fmt.Fprintf(&b, "%s: %s\n", speaker, text)
+		}
+	}
+	return b.String()
+}
+
+// renderContent renders text, tool calls and tool results of content
+func renderContent(content *genai.Content) string {
+	var parts []string
+	for _, part := range content.Parts {
+		switch {
+		case part == nil:
+		case part.Text != "":
+			parts = append(parts, part.Text)
+		case part.FunctionCall != nil:
+			args, _ := json.Marshal(part.FunctionCall.Args)
+			parts = append(parts, fmt.Sprintf("[called %s(%s)]", part.FunctionCall.Name, args))
+		case part.FunctionResponse != nil:
+			result, _ := json.Marshal(part.FunctionResponse.Response)
+			parts = append(parts, fmt.Sprintf("[%s returned %s]", part.FunctionResponse.Name, result))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// contentText returns the text parts of content
+func contentText(content *genai.Content) string {
+	var b strings.Builder
+	for _, part := range content.Parts {
+		if part != nil {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
+}
+
+// hashContents fingerprints contents to detect rewritten history
+func hashContents(contents []*genai.Content) uint64 {
+	h := fnv.New64a()
+	for _, content := range contents {
+		if content != nil {
+			h.Write([]byte(content.Role))
+			h.Write([]byte(renderContent(content)))
+		}
+	}
+	return h.Sum64()
+}
+
+// sessionKey identifies the session of an invocation, or "" outside one
+func sessionKey(ctx context.Context) string {
+	ictx, ok := ctx.(agent.InvocationContext)
+	if !ok || ictx.Session() == nil {
+		return ""
+	}
+	return ictx.Session().AppName() + "/" + ictx.Session().UserID() + "/" + ictx.Session().ID()
+}
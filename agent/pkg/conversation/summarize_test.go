@@ -0,0 +1,163 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// fakeModel answers summary prompts with a fixed summary and records requests
+type fakeModel struct {
+	prompts []string
+	last    *model.LLMRequest
+}
+
+func (m *fakeModel) Name() string { return "fake" }
+
+func (m *fakeModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := contentText(req.Contents[0])
+		if strings.HasPrefix(text, DefaultSummaryPrompt) {
+			m.prompts = append(m.prompts, text)
+			yield(&model.LLMResponse{Content: genai.NewContentFromText("SUMMARY", genai.RoleModel), TurnComplete: true}, nil)
+			return
+		}
+		m.last = req
+		yield(&model.LLMResponse{Content: genai.NewContentFromText("ok", genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// conversation returns n user/model turns of roughly 10 tokens each
+func conversation(n int) []*genai.Content {
+	var contents []*genai.Content
+	for i := range n {
+		contents = append(contents,
+			genai.NewContentFromText(strings.Repeat("q", 20)+string(rune('a'+i)), genai.RoleUser),
+			genai.NewContentFromText(strings.Repeat("a", 20), genai.RoleModel),
+		)
+	}
+	return contents
+}
+
+func run(t *testing.T, s *Summarizer, contents []*genai.Content) {
+	t.Helper()
+	req := &model.LLMRequest{Contents: contents}
+	for _, err := range s.GenerateContent(context.Background(), req, false) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+	}
+}
+
+// TestSummarizer tests that old turns are replaced by a summary once over the threshold
+func TestSummarizer(t *testing.T) {
+	llm := &fakeModel{}
+	s, err := NewSummarizer(llm, &Config{ContextWindow: 100, KeepTurns: 2, Tokenizer: tokenizer.Generic})
+	if err != nil {
+		t.Fatalf("NewSummarizer() error = %v", err)
+	}
+
+	// Below the threshold the request is unchanged
+	run(t, s, conversation(2))
+	if len(llm.prompts) != 0 || len(llm.last.Contents) != 4 {
+		t.Fatalf("short conversation should not be summarized, got %d contents", len(llm.last.Contents))
+	}
+
+	run(t, s, conversation(8))
+	if len(llm.prompts) != 1 {
+		t.Fatalf("got %d summary calls, want 1", len(llm.prompts))
+	}
+	if len(llm.last.Contents) != 4 {
+		t.Errorf("got %d contents, want the last 2 turns (4 contents)", len(llm.last.Contents))
+	}
+	system := contentText(llm.last.Config.SystemInstruction)
+	if !strings.Contains(system, "SUMMARY") {
+		t.Errorf("system instruction should carry the summary, got %q", system)
+	}
+}
+
+// TestOlderCount tests turn boundaries, which ignore tool responses
+func TestOlderCount(t *testing.T) {
+	s := &Summarizer{cfg: Config{KeepTurns: 1}}
+	contents := []*genai.Content{
+		genai.NewContentFromText("first", genai.RoleUser),
+		genai.NewContentFromText("answer", genai.RoleModel),
+		genai.NewContentFromText("second", genai.RoleUser),
+		genai.NewContentFromFunctionCall("lookup", nil, genai.RoleModel),
+		genai.NewContentFromFunctionResponse("lookup", map[string]any{"ok": true}, genai.RoleUser),
+	}
+	if got := s.olderCount(contents); got != 2 {
+		t.Errorf("olderCount() = %d, want 2", got)
+	}
+}
+
+// tooLongModel rejects requests of more than max contents as too long
+type tooLongModel struct {
+	fakeModel
+	max   int
+	calls int
+}
+
+func (m *tooLongModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	m.calls++
+	if len(req.Contents) > m.max {
+		return func(yield func(*model.LLMResponse, error) bool) {
+			yield(nil, &openai_compatible.ContextLengthError{APIError: &openai_compatible.APIError{StatusCode: 400, Message: "maximum context length is 100 tokens"}})
+		}
+	}
+	return m.fakeModel.GenerateContent(ctx, req, stream)
+}
+
+// TestRecovery tests retrying a request rejected as too long with a shorter history
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		strategy     Strategy
+		max          int
+		wantContents int
+		wantSummary  bool
+		wantErr      bool
+	}{
+		{StrategyTruncate, 4, 4, false, false},
+		{StrategySummarize, 4, 4, true, false},
+		{StrategyTruncate, 2, 0, false, true}, // Still too long after the retry
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			llm := &tooLongModel{max: tt.max}
+			r, err := NewRecovery(llm, &RecoveryConfig{Strategy: tt.strategy, KeepTurns: 2, Tokenizer: tokenizer.Generic})
+			if err != nil {
+				t.Fatalf("NewRecovery() error = %v", err)
+			}
+			var gotErr error
+			for _, err := range r.GenerateContent(context.Background(), &model.LLMRequest{Contents: conversation(6)}, false) {
+				gotErr = err
+			}
+			if (gotErr != nil) != tt.wantErr || (gotErr != nil && !errors.Is(gotErr, openai_compatible.ErrContextLength)) {
+				t.Fatalf("GenerateContent() error = %v, want error %v", gotErr, tt.wantErr)
+			}
+			if llm.calls > 2+len(llm.prompts) {
+				t.Errorf("got %d calls, want a single retry", llm.calls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(llm.last.Contents) != tt.wantContents {
+				t.Errorf("got %d contents, want %d", len(llm.last.Contents), tt.wantContents)
+			}
+			if (len(llm.prompts) == 1) != tt.wantSummary {
+				t.Errorf("got %d summaries, want summary %v", len(llm.prompts), tt.wantSummary)
+			}
+		})
+	}
+
+	if _, err := NewRecovery(&fakeModel{}, &RecoveryConfig{Strategy: "drop"}); err == nil {
+		t.Error("NewRecovery() accepted an invalid strategy")
+	}
+}
@@ -0,0 +1,147 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client calls the Discord REST API
+type client struct {
+	http    *http.Client
+	baseURL string
+	token   string
+}
+
+// call sends a JSON request and decodes the response into out. A rate
+// limited request is retried after the time Discord asks for, twice at most.
+func (c *client) call(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode %s %s request: %w", method, path, err)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.baseURL, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create %s %s request: %w", method, path, err)
+		}
+		req.Header.Set("Authorization", "Bot "+c.token)
+		req.Header.Set("User-Agent", "DiscordBot (https://github.com/gopher-9527/yanshu, 1.0)")
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s %s failed: %w", method, path, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s %s response: %w", method, path, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 2 {
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"` // Seconds
+			}
+			json.Unmarshal(data, &limit)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(limit.RetryAfter * float64(time.Second))):
+			}
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+		}
+		if out != nil && len(data) > 0 {
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("failed to decode %s %s response: %w", method, path, err)
+			}
+		}
+		return nil
+	}
+}
+
+// gatewayURL returns the URL to connect the gateway to
+func (c *client) gatewayURL(ctx context.Context) (string, error) {
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/gateway/bot", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+// createMessage posts content to a channel, replying to a message when
+// replyTo is set, and returns the message ID. Mentions in the content do
+// not ping anyone.
+func (c *client) createMessage(ctx context.Context, channelID, replyTo, content string) (string, error) {
+	req := map[string]any{"content": content, "allowed_mentions": map[string]any{"parse": []string{}}}
+	if replyTo != "" {
+		req["message_reference"] = map[string]any{"message_id": replyTo, "fail_if_not_exists": false}
+	}
+	var msg struct {
+		ID string `json:"id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/channels/"+channelID+"/messages", req, &msg); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// editMessage replaces the content of a message
+func (c *client) editMessage(ctx context.Context, channelID, messageID, content string) error {
+	return c.call(ctx, http.MethodPatch, "/channels/"+channelID+"/messages/"+messageID, map[string]any{"content": content}, nil)
+}
+
+// triggerTyping shows the bot as typing in the channel for about 10s
+func (c *client) triggerTyping(ctx context.Context, channelID string) error {
+	return c.call(ctx, http.MethodPost, "/channels/"+channelID+"/typing", nil, nil)
+}
+
+// command is an application command
+type command struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Options     []commandOption `json:"options,omitempty"`
+}
+
+// commandOption is an option of an application command
+type commandOption struct {
+	Type        int    `json:"type"` // 3 for a string
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// registerCommands replaces the application's slash commands, in a guild
+// when guildID is set, where they are available at once
+func (c *client) registerCommands(ctx context.Context, appID, guildID string, commands []command) error {
+	path := "/applications/" + appID + "/commands"
+	if guildID != "" {
+		path = "/applications/" + appID + "/guilds/" + guildID + "/commands"
+	}
+	return c.call(ctx, http.MethodPut, path, commands, nil)
+}
+
+// respond answers an interaction with a message, visible only to the user
+// who ran the command when ephemeral is set
+func (c *client) respond(ctx context.Context, interactionID, token, content string, ephemeral bool) error {
+	data := map[string]any{"content": content, "allowed_mentions": map[string]any{"parse": []string{}}}
+	if ephemeral {
+		data["flags"] = 64
+	}
+	return c.call(ctx, http.MethodPost, "/interactions/"+interactionID+"/"+token+"/callback", map[string]any{"type": 4, "data": data}, nil)
+}
@@ -0,0 +1,568 @@
+// Package discord runs the agent as a Discord bot over the gateway. Each
+// channel, thread or direct message conversation is a session; in servers
+// the bot answers messages that mention it. The /reset and /model slash
+// commands start a conversation over and show or switch the model.
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/bot"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+)
+
+// maxMessageSize is Discord's limit on the content of a message
+const maxMessageSize = 2000
+
+// Gateway intents: server messages and direct messages. Without the
+// privileged message content intent, Discord still sends the content of
+// direct messages and of messages mentioning the bot.
+const intents = 1<<0 | 1<<9 | 1<<12
+
+// SwitchableModel is the model /model switches
+type SwitchableModel interface {
+	Name() string
+	Set(llm model.LLM)
+}
+
+// Config holds Discord bot configuration
+type Config struct {
+	Token string // Bot token
+	// GuildID registers the slash commands in this server, where they are
+	// available at once, instead of globally
+	GuildID string
+	// AllowedChannels restricts the bot to these channel or thread IDs, any
+	// when empty. Direct messages are always answered.
+	AllowedChannels []string
+	// ModelAdmins are the user IDs allowed to switch the model with /model,
+	// which then applies to every conversation
+	ModelAdmins []string
+	// Model and NewModel switch the model, /model only shows it when either
+	// is nil
+	Model    SwitchableModel
+	NewModel func(ctx context.Context, name string) (model.LLM, error)
+	// UpdateInterval is the least time between two edits of a reply,
+	// defaults to 1s to stay within Discord's rate limits
+	UpdateInterval time.Duration
+	// BaseURL is the REST API endpoint, defaults to https://discord.com/api/v10
+	BaseURL    string
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// Launcher is the `discord` subcommand
+type Launcher struct {
+	cfg    Config
+	flags  *flag.FlagSet
+	api    *client
+	logger *slog.Logger
+
+	bot        *bot.Bot
+	botUserID  string
+	mention    *regexp.Regexp
+	registered bool // Slash commands are registered once per process
+	wg         sync.WaitGroup
+}
+
+// NewLauncher creates the `discord` subcommand
+func NewLauncher(cfg *Config) *Launcher {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := *cfg
+	if c.UpdateInterval <= 0 {
+		c.UpdateInterval = time.Second
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = "https://discord.com/api/v10"
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("discord")
+	}
+	return &Launcher{
+		cfg:    c,
+		flags:  flag.NewFlagSet("discord", flag.ContinueOnError),
+		api:    &client{http: c.HTTPClient, baseURL: c.BaseURL, token: c.Token},
+		logger: logger,
+	}
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *Launcher) Keyword() string {
+	return "discord"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *Launcher) SimpleDescription() string {
+	return "runs the agent as a Discord bot"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *Launcher) CommandLineSyntax() string {
+	return ""
+}
+
+// Parse implements launcher.SubLauncher. The subcommand has no flags.
+func (l *Launcher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse discord flags: %w", err)
+	}
+	return l.flags.Args(), nil
+}
+
+// Execute implements launcher.Launcher
+func (l *Launcher) Execute(ctx context.Context, config *launcher.Config, args []string) error {
+	rest, err := l.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	return l.Run(ctx, config)
+}
+
+// Run implements launcher.SubLauncher. It answers Discord until ctx is
+// done, reconnecting when the gateway asks to or the connection drops.
+func (l *Launcher) Run(ctx context.Context, config *launcher.Config) error {
+	if l.cfg.Token == "" {
+		return fmt.Errorf("discord.token is required")
+	}
+	b, err := bot.New(config, &bot.Config{Platform: "discord", UpdateInterval: l.cfg.UpdateInterval, Logger: l.logger})
+	if err != nil {
+		return err
+	}
+	l.bot = b
+	defer l.wg.Wait()
+
+	backoff := time.Second
+	for {
+		connected, err := l.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && fatalClose(closeErr.Code) {
+			return fmt.Errorf("discord gateway refused the bot: %w", err)
+		}
+		if connected {
+			backoff = time.Second
+		}
+		l.logger.Warn("Discord gateway connection closed, reconnecting", "error", err, "in", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// fatalClose reports whether a gateway close code means reconnecting cannot
+// help, e.g. an invalid token or intents the bot may not use
+func fatalClose(code int) bool {
+	return code == 4004 || (code >= 4010 && code <= 4014)
+}
+
+// payload is a gateway message
+type payload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+// Gateway opcodes
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// gateway is a connection to the gateway
+type gateway struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	seq   *int64 // Of the last dispatch
+	acked bool   // Whether the last heartbeat was acknowledged
+}
+
+// send writes a payload
+func (g *gateway) send(op int, d any) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	g.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return g.ws.WriteJSON(payload{Op: op, D: data})
+}
+
+// heartbeat sends a heartbeat with the last sequence number
+func (g *gateway) heartbeat() error {
+	g.mu.Lock()
+	seq := g.seq
+	g.acked = false
+	g.mu.Unlock()
+	return g.send(opHeartbeat, seq)
+}
+
+// connect identifies on a gateway connection and serves its events until
+// it closes. It reports whether the bot was identified.
+func (l *Launcher) connect(ctx context.Context) (bool, error) {
+	gatewayURL, err := l.api.gatewayURL(ctx)
+	if err != nil {
+		return false, err
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, gatewayURL+"?v=10&encoding=json", nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+	g := &gateway{ws: ws, acked: true}
+
+	var hello struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"` // Milliseconds
+	}
+	var p payload
+	if err := ws.ReadJSON(&p); err != nil {
+		return false, err
+	}
+	if p.Op != opHello || json.Unmarshal(p.D, &hello) != nil || hello.HeartbeatInterval <= 0 {
+		return false, fmt.Errorf("expected hello, got opcode %d", p.Op)
+	}
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	defer stopHeartbeat()
+	go l.heartbeat(heartbeatCtx, g, time.Duration(hello.HeartbeatInterval)*time.Millisecond)
+
+	err = g.send(opIdentify, map[string]any{
+		"token":   l.cfg.Token,
+		"intents": intents,
+		"properties": map[string]string{
+			"os":      "linux",
+			"browser": "yanshu",
+			"device":  "yanshu",
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to identify: %w", err)
+	}
+
+	identified := false
+	for {
+		var p payload
+		if err := ws.ReadJSON(&p); err != nil {
+			return identified, err
+		}
+		switch p.Op {
+		case opDispatch:
+			if p.S != nil {
+				g.mu.Lock()
+				g.seq = p.S
+				g.mu.Unlock()
+			}
+			if p.T == "READY" {
+				identified = true
+			}
+			l.dispatch(ctx, p.T, p.D)
+		case opHeartbeat:
+			if err := g.heartbeat(); err != nil {
+				return identified, err
+			}
+		case opHeartbeatACK:
+			g.mu.Lock()
+			g.acked = true
+			g.mu.Unlock()
+		case opReconnect:
+			return identified, fmt.Errorf("gateway asked to reconnect")
+		case opInvalidSession:
+			return identified, fmt.Errorf("gateway invalidated the session")
+		}
+	}
+}
+
+// heartbeat sends heartbeats at the interval the gateway asked for, closing
+// the connection when one is not acknowledged before the next
+func (l *Launcher) heartbeat(ctx context.Context, g *gateway, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		g.mu.Lock()
+		acked := g.acked
+		g.mu.Unlock()
+		if !acked {
+			l.logger.Warn("Discord heartbeat not acknowledged, reconnecting")
+			g.ws.Close()
+			return
+		}
+		if err := g.heartbeat(); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch handles a gateway event
+func (l *Launcher) dispatch(ctx context.Context, event string, data json.RawMessage) {
+	switch event {
+	case "READY":
+		var ready struct {
+			User struct {
+				ID       string `json:"id"`
+				Username string `json:"username"`
+			} `json:"user"`
+			Application struct {
+				ID string `json:"id"`
+			} `json:"application"`
+		}
+		if err := json.Unmarshal(data, &ready); err != nil {
+			l.logger.Warn("Invalid Discord READY event", "error", err)
+			return
+		}
+		l.botUserID = ready.User.ID
+		l.mention = regexp.MustCompile(`<@!?` + regexp.QuoteMeta(ready.User.ID) + `>`)
+		l.logger.Info("Connected to Discord", "bot", ready.User.Username)
+		if !l.registered {
+			if err := l.api.registerCommands(ctx, ready.Application.ID, l.cfg.GuildID, commands); err != nil {
+				l.logger.Warn("Failed to register Discord slash commands", "error", err)
+			} else {
+				l.registered = true
+			}
+		}
+	case "MESSAGE_CREATE":
+		var m messageCreate
+		if err := json.Unmarshal(data, &m); err != nil {
+			l.logger.Warn("Invalid Discord message", "error", err)
+			return
+		}
+		l.handleMessage(ctx, &m)
+	case "INTERACTION_CREATE":
+		var i interaction
+		if err := json.Unmarshal(data, &i); err != nil {
+			l.logger.Warn("Invalid Discord interaction", "error", err)
+			return
+		}
+		l.wg.Go(func() { l.handleInteraction(ctx, &i) })
+	}
+}
+
+// commands are the bot's slash commands
+var commands = []command{
+	{Name: "reset", Description: "Start this conversation over"},
+	{Name: "model", Description: "Show or switch the model", Options: []commandOption{
+		{Type: 3, Name: "name", Description: "Model to switch to"},
+	}},
+}
+
+// author is the user who sent a message or ran a command
+type author struct {
+	ID  string `json:"id"`
+	Bot bool   `json:"bot"`
+}
+
+// messageCreate is a new message
+type messageCreate struct {
+	ID        string   `json:"id"`
+	ChannelID string   `json:"channel_id"`
+	GuildID   string   `json:"guild_id"` // Empty for direct messages
+	Author    author   `json:"author"`
+	Content   string   `json:"content"`
+	Mentions  []author `json:"mentions"`
+}
+
+// handleMessage starts answering a direct message or a mention of the bot
+func (l *Launcher) handleMessage(ctx context.Context, m *messageCreate) {
+	if m.Author.Bot || l.mention == nil {
+		return
+	}
+	if m.GuildID != "" {
+		mentioned := slices.ContainsFunc(m.Mentions, func(a author) bool { return a.ID == l.botUserID })
+		if !mentioned || (len(l.cfg.AllowedChannels) > 0 && !slices.Contains(l.cfg.AllowedChannels, m.ChannelID)) {
+			return
+		}
+	}
+	text := strings.TrimSpace(l.mention.ReplaceAllString(m.Content, ""))
+	if text == "" {
+		return
+	}
+	l.wg.Go(func() { l.reply(ctx, m, text) })
+}
+
+// reply shows the bot as typing, then streams the reply into a message
+// edited as it grows, continuing in more messages past Discord's limit
+func (l *Launcher) reply(ctx context.Context, m *messageCreate, text string) {
+	typingCtx, stopTyping := context.WithCancel(ctx)
+	defer stopTyping()
+	go l.typing(typingCtx, m.ChannelID)
+
+	var messageID, shown string
+	show := func(ctx context.Context, content string) error {
+		if messageID != "" {
+			return l.api.editMessage(ctx, m.ChannelID, messageID, content)
+		}
+		id, err := l.api.createMessage(ctx, m.ChannelID, m.ID, content)
+		if err == nil {
+			messageID = id
+			stopTyping()
+		}
+		return err
+	}
+	update := func(ctx context.Context, reply string) error {
+		content := bot.Split(reply, maxMessageSize)[0]
+		if err := show(ctx, content); err != nil {
+			return err
+		}
+		shown = content
+		return nil
+	}
+
+	reply, err := l.bot.Reply(ctx, m.ChannelID, text, update)
+	stopTyping()
+	switch {
+	case errors.Is(err, context.Canceled):
+		reply = "⚠️ Interrupted, the bot is shutting down."
+	case err != nil:
+		l.logger.Warn("Discord turn failed", "channel", m.ChannelID, "error", err)
+		reply = "⚠️ " + err.Error()
+	case strings.TrimSpace(reply) == "":
+		reply = "*No answer.*"
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	parts := bot.Split(reply, maxMessageSize)
+	if parts[0] != shown {
+		if err := show(ctx, parts[0]); err != nil {
+			l.logger.Warn("Failed to send Discord reply", "channel", m.ChannelID, "error", err)
+		}
+	}
+	for _, part := range parts[1:] {
+		if _, err := l.api.createMessage(ctx, m.ChannelID, "", part); err != nil {
+			l.logger.Warn("Failed to send Discord reply", "channel", m.ChannelID, "error", err)
+			return
+		}
+	}
+}
+
+// typing shows the bot as typing until ctx is done
+func (l *Launcher) typing(ctx context.Context, channelID string) {
+	ticker := time.NewTicker(8 * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := l.api.triggerTyping(ctx, channelID); err != nil && ctx.Err() == nil {
+			l.logger.Debug("Failed to trigger Discord typing", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// interaction is a slash command
+type interaction struct {
+	ID        string `json:"id"`
+	Token     string `json:"token"`
+	Type      int    `json:"type"` // 2 for an application command
+	ChannelID string `json:"channel_id"`
+	Member    *struct {
+		User author `json:"user"`
+	} `json:"member"` // In servers
+	User *author `json:"user"` // In direct messages
+	Data struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value any    `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// userID returns the ID of the user who ran the command
+func (i *interaction) userID() string {
+	if i.Member != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+// handleInteraction runs /reset or /model
+func (l *Launcher) handleInteraction(ctx context.Context, i *interaction) {
+	if i.Type != 2 {
+		return
+	}
+	respond := func(content string, ephemeral bool) {
+		if err := l.api.respond(ctx, i.ID, i.Token, content, ephemeral); err != nil {
+			l.logger.Warn("Failed to answer Discord command", "command", i.Data.Name, "error", err)
+		}
+	}
+
+	switch i.Data.Name {
+	case "reset":
+		if err := l.bot.Reset(ctx, i.ChannelID); err != nil {
+			respond("⚠️ "+err.Error(), true)
+			return
+		}
+		respond("Starting over.", false)
+	case "model":
+		var name string
+		for _, o := range i.Data.Options {
+			if o.Name == "name" {
+				name, _ = o.Value.(string)
+			}
+		}
+		switch {
+		case l.cfg.Model == nil:
+			respond("Model switching is not available.", true)
+		case name == "":
+			respond("Model: "+l.cfg.Model.Name(), true)
+		case l.cfg.NewModel == nil:
+			respond("Model switching is not available.", true)
+		case !slices.Contains(l.cfg.ModelAdmins, i.userID()):
+			respond("Only the bot's model admins can switch the model.", true)
+		default:
+			llm, err := l.cfg.NewModel(ctx, name)
+			if err != nil {
+				respond("⚠️ Failed to create model: "+err.Error(), true)
+				return
+			}
+			l.cfg.Model.Set(llm)
+			l.logger.Info("Model switched from Discord", "model", llm.Name(), "user", i.userID())
+			respond("Switched to model "+llm.Name()+".", false)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// longModel answers with a text longer than a Discord message
+type longModel struct{}
+
+func (longModel) Name() string { return "long" }
+
+func (longModel) GenerateContent(_ context.Context, _ *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := strings.Repeat("a line of the answer\n", 120)
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// namedModel is longModel under another name
+type namedModel struct {
+	longModel
+	name string
+}
+
+func (m namedModel) Name() string { return m.name }
+
+// fakeModel is a SwitchableModel
+type fakeModel struct {
+	mu   sync.Mutex
+	name string
+}
+
+func (m *fakeModel) Name() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.name
+}
+
+func (m *fakeModel) Set(llm model.LLM) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.name = llm.Name()
+}
+
+// TestLauncher tests identifying, answering a direct message in two
+// messages, ignoring server messages not mentioning the bot and the slash
+// commands
+func TestLauncher(t *testing.T) {
+	events := []string{
+		`{"op":0,"s":1,"t":"READY","d":{"user":{"id":"1","username":"yanshu"},"application":{"id":"9"}}}`,
+		`{"op":0,"s":2,"t":"MESSAGE_CREATE","d":{"id":"10","channel_id":"100","guild_id":"7","author":{"id":"5"},"content":"chatting among ourselves"}}`,
+		`{"op":0,"s":3,"t":"MESSAGE_CREATE","d":{"id":"11","channel_id":"200","author":{"id":"5"},"content":"hello"}}`,
+		`{"op":0,"s":4,"t":"INTERACTION_CREATE","d":{"id":"20","token":"t","type":2,"channel_id":"300","user":{"id":"5"},"data":{"name":"reset"}}}`,
+		`{"op":0,"s":5,"t":"INTERACTION_CREATE","d":{"id":"21","token":"t","type":2,"channel_id":"300","user":{"id":"5"},"data":{"name":"model","options":[{"name":"name","value":"other"}]}}}`,
+		`{"op":0,"s":6,"t":"INTERACTION_CREATE","d":{"id":"22","token":"t","type":2,"channel_id":"300","member":{"user":{"id":"6"}},"data":{"name":"model","options":[{"name":"name","value":"other"}]}}}`,
+	}
+	var mu sync.Mutex
+	var calls []string
+	var identify map[string]any
+	upgrader := websocket.Upgrader{}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gateway" {
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			ws.WriteJSON(map[string]any{"op": opHello, "d": map[string]any{"heartbeat_interval": 60000}})
+			var p payload
+			if ws.ReadJSON(&p) != nil {
+				return
+			}
+			mu.Lock()
+			json.Unmarshal(p.D, &identify)
+			mu.Unlock()
+			for _, e := range events {
+				ws.WriteMessage(websocket.TextMessage, []byte(e))
+			}
+			for ws.ReadJSON(&p) == nil {
+			}
+			return
+		}
+
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		call := r.Method + " " + r.URL.Path
+		switch {
+		case r.URL.Path == "/gateway/bot":
+			w.Write([]byte(`{"url":"ws` + strings.TrimPrefix(srv.URL, "http") + `/gateway"}`))
+			return
+		case strings.HasSuffix(r.URL.Path, "/typing"):
+			return
+		case strings.HasSuffix(r.URL.Path, "/messages"):
+			content := req["content"].(string)
+			call += " " + content[:6] + " " + strings.Repeat("+", len(content)/1000)
+			if ref, ok := req["message_reference"].(map[string]any); ok {
+				call += " reply to " + ref["message_id"].(string)
+			}
+		case strings.HasSuffix(r.URL.Path, "/callback"):
+			data := req["data"].(map[string]any)
+			call += " " + data["content"].(string)
+		}
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+		w.Write([]byte(`{"id":"12"}`))
+	}))
+	defer srv.Close()
+
+	a, err := llmagent.New(llmagent.Config{Name: "long", Model: longModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLauncher(&Config{
+		Token:       "token",
+		BaseURL:     srv.URL,
+		ModelAdmins: []string{"6"},
+		Model:       &fakeModel{name: "long"},
+		NewModel: func(_ context.Context, name string) (model.LLM, error) {
+			return namedModel{name: name}, nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Run(ctx, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	}()
+
+	want := []string{ // Sorted
+		"POST /channels/200/messages a line ",
+		"POST /channels/200/messages a line + reply to 11",
+		"POST /interactions/20/t/callback Starting over.",
+		"POST /interactions/21/t/callback Only the bot's model admins can switch the model.",
+		"POST /interactions/22/t/callback Switched to model other.",
+		"PUT /applications/9/commands",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := slices.Clone(calls)
+		mu.Unlock()
+		slices.Sort(got)
+		if slices.Equal(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("calls = %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if identify["token"] != "token" || identify["intents"] != float64(intents) {
+		t.Errorf("identify = %v", identify)
+	}
+	if name := l.cfg.Model.Name(); name != "other" {
+		t.Errorf("model = %q, want other", name)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
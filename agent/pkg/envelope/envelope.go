@@ -0,0 +1,273 @@
+// Package envelope encrypts data at rest with per-tenant keys. Each tenant
+// gets a data key encrypting its data with AES-256-GCM. The data key is
+// encrypted in turn by a KeyProvider holding the master key, and stored with
+// the data it encrypts, so the master key never sees the data and one
+// tenant's data cannot be opened as another's.
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// magic starts sealed data, followed by the tenant, the wrapped data key,
+// the nonce and the ciphertext
+var magic = []byte("YSE1")
+
+// maxOpened caps the unwrapped data keys kept for opening data
+const maxOpened = 1024
+
+// Scopes of tenants
+const (
+	// ScopeUser gives every user of every app their own key
+	ScopeUser = "user"
+	// ScopeApp gives every app one key for its users
+	ScopeApp = "app"
+)
+
+// KeyProvider holds the master key, wrapping and unwrapping data keys. The
+// tenant must be bound to the wrapped key, so that unwrapping it for
+// another tenant fails.
+type KeyProvider interface {
+	// Wrap encrypts a tenant's data key
+	Wrap(ctx context.Context, tenant string, key []byte) ([]byte, error)
+	// Unwrap decrypts a data key wrapped for the tenant
+	Unwrap(ctx context.Context, tenant string, wrapped []byte) ([]byte, error)
+}
+
+// Config configures a Sealer
+type Config struct {
+	Keys   KeyProvider // Required
+	Scope  string      // ScopeUser (default) or ScopeApp
+	Logger *slog.Logger
+}
+
+// dataKey is the data key of a tenant for sealing
+type dataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+}
+
+// Sealer encrypts and decrypts data per tenant
+type Sealer struct {
+	keys   KeyProvider
+	scope  string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	sealing map[string]*dataKey    // Data key of each tenant, created on first use
+	opened  map[string]cipher.AEAD // Unwrapped data keys by tenant and wrapped key
+}
+
+// New creates a sealer on the key provider of cfg
+func New(cfg *Config) (*Sealer, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Keys == nil {
+		return nil, fmt.Errorf("key provider is required")
+	}
+	s := &Sealer{
+		keys:    cfg.Keys,
+		scope:   cfg.Scope,
+		logger:  cfg.Logger,
+		sealing: make(map[string]*dataKey),
+		opened:  make(map[string]cipher.AEAD),
+	}
+	switch s.scope {
+	case "":
+		s.scope = ScopeUser
+	case ScopeUser, ScopeApp:
+	default:
+		return nil, fmt.Errorf("invalid tenant scope %q (must be user or app)", cfg.Scope)
+	}
+	if s.logger == nil {
+		s.logger = logging.Component("envelope")
+	}
+	return s, nil
+}
+
+// Tenant returns the tenant of a user's data
+func (s *Sealer) Tenant(appName, userID string) string {
+	if s.scope == ScopeApp {
+		return "app:" + appName
+	}
+	return "user:" + appName + "/" + userID
+}
+
+// Sealed reports whether data was sealed
+func Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Seal encrypts data with the tenant's data key
+func (s *Sealer) Seal(ctx context.Context, tenant string, data []byte) ([]byte, error) {
+	key, err := s.dataKey(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magic)+4+len(tenant)+len(key.wrapped))
+	header = append(header, magic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(tenant)))
+	header = append(header, tenant...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(key.wrapped)))
+	header = append(header, key.wrapped...)
+
+	out := make([]byte, len(header), len(header)+key.aead.NonceSize()+len(data)+key.aead.Overhead())
+	copy(out, header)
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	// The header is authenticated, binding the data to its tenant and key
+	return key.aead.Seal(out, nonce, data, header), nil
+}
+
+// dataKey returns the data key of a tenant, creating and wrapping it on first use
+func (s *Sealer) dataKey(ctx context.Context, tenant string) (*dataKey, error) {
+	if len(tenant) > 0xffff {
+		return nil, fmt.Errorf("tenant name too long")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.sealing[tenant]; ok {
+		return key, nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := s.keys.Wrap(ctx, tenant, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	if len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("wrapped data key too long")
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	key := &dataKey{aead: aead, wrapped: wrapped}
+	s.sealing[tenant] = key
+	s.logger.Debug("Created data key", "tenant", tenant)
+	return key, nil
+}
+
+// Open decrypts data sealed for the tenant. Data that was not sealed, such
+// as data written before encryption was enabled, is returned as is.
+func (s *Sealer) Open(ctx context.Context, tenant string, data []byte) ([]byte, error) {
+	if !Sealed(data) {
+		return data, nil
+	}
+	sealedFor, plain, err := s.OpenAny(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if sealedFor != tenant {
+		return nil, fmt.Errorf("data was sealed for another tenant")
+	}
+	return plain, nil
+}
+
+// OpenAny decrypts sealed data for whichever tenant it was sealed for and
+// returns that tenant
+func (s *Sealer) OpenAny(ctx context.Context, data []byte) (string, []byte, error) {
+	tenant, wrapped, rest, err := parseHeader(data)
+	if err != nil {
+		return "", nil, err
+	}
+	header := data[:len(data)-len(rest)]
+
+	aead, err := s.openKey(ctx, tenant, wrapped)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return "", nil, fmt.Errorf("sealed data is truncated")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decrypt sealed data: %w", err)
+	}
+	return tenant, plain, nil
+}
+
+// openKey returns the unwrapped data key of sealed data
+func (s *Sealer) openKey(ctx context.Context, tenant string, wrapped []byte) (cipher.AEAD, error) {
+	id := tenant + "\x00" + string(wrapped)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if aead, ok := s.opened[id]; ok {
+		return aead, nil
+	}
+
+	raw, err := s.keys.Unwrap(ctx, tenant, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.opened) >= maxOpened {
+		clear(s.opened)
+	}
+	s.opened[id] = aead
+	return aead, nil
+}
+
+// parseHeader splits sealed data into its tenant, wrapped key and the rest
+func parseHeader(data []byte) (string, []byte, []byte, error) {
+	if !Sealed(data) {
+		return "", nil, nil, fmt.Errorf("data is not sealed")
+	}
+	rest := data[len(magic):]
+	field := func() ([]byte, error) {
+		if len(rest) < 2 {
+			return nil, errors.New("sealed data is truncated")
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return nil, errors.New("sealed data is truncated")
+		}
+		f := rest[2 : 2+n]
+		rest = rest[2+n:]
+		return f, nil
+	}
+	tenant, err := field()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	wrapped, err := field()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return string(tenant), wrapped, rest, nil
+}
+
+// newAEAD returns AES-256-GCM with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key size %d (want 32 bytes)", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,114 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSealer(t *testing.T, master byte) *Sealer {
+	t.Helper()
+	keys, err := NewLocalKeys(bytes.Repeat([]byte{master}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&Config{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestSealOpen tests sealing and opening data per tenant
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSealer(t, 1)
+	alice, bob := s.Tenant("app", "alice"), s.Tenant("app", "bob")
+
+	plain := []byte("the conversation")
+	sealed, err := s.Seal(ctx, alice, plain)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !Sealed(sealed) || bytes.Contains(sealed, plain) {
+		t.Fatalf("Seal() = %q, want ciphertext", sealed)
+	}
+	again, _ := s.Seal(ctx, alice, plain)
+	if bytes.Equal(sealed, again) {
+		t.Error("Seal() repeated its nonce")
+	}
+
+	got, err := s.Open(ctx, alice, sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Open() = %q, %v, want the plaintext", got, err)
+	}
+	if _, err := s.Open(ctx, bob, sealed); err == nil {
+		t.Error("Open() for another tenant succeeded")
+	}
+	tenant, got, err := s.OpenAny(ctx, sealed)
+	if err != nil || tenant != alice || !bytes.Equal(got, plain) {
+		t.Errorf("OpenAny() = %q, %q, %v", tenant, got, err)
+	}
+
+	// A fresh sealer with the same master key, as after a restart
+	if got, err := newTestSealer(t, 1).Open(ctx, alice, sealed); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Open() after restart = %q, %v", got, err)
+	}
+	if _, err := newTestSealer(t, 2).Open(ctx, alice, sealed); err == nil {
+		t.Error("Open() with another master key succeeded")
+	}
+
+	// Moving data to another tenant by rewriting its header fails
+	forged := bytes.Replace(bytes.Clone(sealed), []byte("alice"), []byte("bobby"), 1)
+	if _, _, err := s.OpenAny(ctx, forged); err == nil {
+		t.Error("OpenAny() of a forged tenant succeeded")
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := s.Open(ctx, alice, tampered); err == nil {
+		t.Error("Open() of tampered data succeeded")
+	}
+	if _, err := s.Open(ctx, alice, sealed[:20]); err == nil {
+		t.Error("Open() of truncated data succeeded")
+	}
+
+	if got, err := s.Open(ctx, alice, []byte("plain")); err != nil || string(got) != "plain" {
+		t.Errorf("Open() of unsealed data = %q, %v", got, err)
+	}
+}
+
+// TestTenantScope tests the tenants of app scope
+func TestTenantScope(t *testing.T) {
+	keys, _ := NewLocalKeys(make([]byte, 32))
+	s, err := New(&Config{Keys: keys, Scope: ScopeApp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Tenant("app", "alice") != s.Tenant("app", "bob") || s.Tenant("app", "alice") == s.Tenant("other", "alice") {
+		t.Errorf("Tenant() does not scope by app")
+	}
+	if _, err := New(&Config{Keys: keys, Scope: "session"}); err == nil {
+		t.Error("New() with an invalid scope succeeded")
+	}
+}
+
+// TestLoadLocalKeys tests loading the master key inline and from a file
+func TestLoadLocalKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	if _, err := LoadLocalKeys(key, ""); err != nil {
+		t.Errorf("LoadLocalKeys(inline) error = %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "master.key")
+	os.WriteFile(file, []byte(key+"\n"), 0o600)
+	if _, err := LoadLocalKeys("", file); err != nil {
+		t.Errorf("LoadLocalKeys(file) error = %v", err)
+	}
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := LoadLocalKeys(bad, ""); err == nil {
+			t.Errorf("LoadLocalKeys(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package envelope
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LocalKeys is a KeyProvider holding the master key in memory. Each tenant
+// wraps its data keys with its own key derived from the master key.
+type LocalKeys struct {
+	master []byte
+}
+
+// NewLocalKeys creates a provider with a 32-byte master key
+func NewLocalKeys(master []byte) (*LocalKeys, error) {
+	if len(master) != 32 {
+		return nil, fmt.Errorf("invalid master key size %d (want 32 bytes)", len(master))
+	}
+	return &LocalKeys{master: master}, nil
+}
+
+// LoadLocalKeys creates a provider with a base64 master key, given inline
+// or, when key is empty, read from file
+func LoadLocalKeys(key, file string) (*LocalKeys, error) {
+	if key == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key: %w", err)
+		}
+		key = string(data)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("master key is required")
+	}
+	master, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return NewLocalKeys(master)
+}
+
+// tenantKey derives the key wrapping the data keys of a tenant
+func (k *LocalKeys) tenantKey(tenant string) ([]byte, error) {
+	return hkdf.Key(sha256.New, k.master, nil, "yanshu envelope tenant "+tenant, 32)
+}
+
+// Wrap implements KeyProvider
+func (k *LocalKeys) Wrap(_ context.Context, tenant string, key []byte) ([]byte, error) {
+	kek, err := k.tenantKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, key, []byte(tenant)), nil
+}
+
+// Unwrap implements KeyProvider
+func (k *LocalKeys) Unwrap(_ context.Context, tenant string, wrapped []byte) ([]byte, error) {
+	kek, err := k.tenantKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is truncated")
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(tenant))
+	if err != nil {
+		return nil, fmt.Errorf("wrapped key does not belong to tenant %s or another master key wrapped it", tenant)
+	}
+	return key, nil
+}
@@ -0,0 +1,226 @@
+package guardrails
+
+import (
+	"fmt"
+	"maps"
+	"math/big"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pattern is a named regular expression. With keep, the first group of a
+// match survives redaction, such as the key of a key=value pair.
+type pattern struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(match string) bool // Filters matches, optional
+	keep  bool
+}
+
+// matcher finds the matches of patterns, in order
+type matcher []pattern
+
+// run reports a finding per match, named after its pattern, and redacts the
+// matches when asked
+func (m matcher) run(text string, redact bool) (string, []string) {
+	var findings []string
+	for _, p := range m {
+		text = p.re.ReplaceAllStringFunc(text, func(s string) string {
+			if p.valid != nil && !p.valid(s) {
+				return s
+			}
+			findings = append(findings, p.name)
+			if !redact {
+				return s
+			}
+			kept := ""
+			if p.keep {
+				kept = p.re.FindStringSubmatch(s)[1]
+			}
+			return kept + "[REDACTED:" + p.name + "]"
+		})
+	}
+	return text, findings
+}
+
+// custom compiles the named patterns of the config, sorted by name
+func custom(patterns map[string]string) (matcher, error) {
+	var m matcher
+	for _, name := range slices.Sorted(maps.Keys(patterns)) {
+		re, err := regexp.Compile(patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", name, err)
+		}
+		m = append(m, pattern{name: name, re: re})
+	}
+	return m, nil
+}
+
+// maxSize flags text longer than max bytes
+func maxSize(max int64) func(string, bool) (string, []string) {
+	return func(text string, _ bool) (string, []string) {
+		if int64(len(text)) <= max {
+			return text, nil
+		}
+		return text, []string{fmt.Sprintf("%d bytes exceed the limit of %d", len(text), max)}
+	}
+}
+
+// injectionPatterns are phrases of common prompt injection attempts, in
+// English and Chinese
+var injectionPatterns = matcher{
+	{name: "ignore_instructions", re: regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\s+(?:all\s+|any\s+|the\s+|your\s+)*(?:previous|prior|above|earlier|preceding|system)\s+(?:instructions|prompts?|rules|directions|messages)`)},
+	{name: "ignore_instructions", re: regexp.MustCompile(`(?:忽略|无视|忘记|忘掉)(?:你)?(?:之前|以上|上面|前面|先前)?(?:的)?(?:所有|全部|一切)?(?:的)?(?:指令|指示|提示|规则|设定)`)},
+	{name: "prompt_exfiltration", re: regexp.MustCompile(`(?i)\b(?:reveal|print|show|repeat|output|tell\s+me)\s+(?:me\s+)?(?:your|the)\s+(?:system\s+prompt|initial\s+instructions|hidden\s+instructions|instructions\s+above)`)},
+	{name: "prompt_exfiltration", re: regexp.MustCompile(`(?:输出|显示|告诉我|重复)(?:你的)?(?:系统提示词?|系统指令|初始指令)`)},
+	{name: "role_override", re: regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(?:in\s+)?(?:DAN|developer\s+mode|jailbroken|unrestricted|an?\s+unfiltered)|\b(?:enable|enter|activate)\s+(?:developer|god|jailbreak)\s+mode\b`)},
+	{name: "fake_system_message", re: regexp.MustCompile(`(?im)^\s*(?:\[?system\]?|<\|?system\|?>|###\s*system)\s*[:>]|<\|im_start\|>\s*system`)},
+}
+
+// topics flags the keywords of blocked topics. Keywords match whole words,
+// case-insensitively; keywords in scripts without spaces match anywhere.
+func topics(topics map[string][]string) (func(string, bool) (string, []string), error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("blocked_topics requires topics")
+	}
+	var m matcher
+	for _, name := range slices.Sorted(maps.Keys(topics)) {
+		var alternatives []string
+		for _, keyword := range topics[name] {
+			keyword = strings.TrimSpace(keyword)
+			if keyword == "" {
+				continue
+			}
+			alternative := regexp.QuoteMeta(keyword)
+			if first, _ := utf8.DecodeRuneInString(keyword); isWord(first) {
+				alternative = `\b` + alternative
+			}
+			if last, _ := utf8.DecodeLastRuneInString(keyword); isWord(last) {
+				alternative += `\b`
+			}
+			alternatives = append(alternatives, alternative)
+		}
+		if len(alternatives) == 0 {
+			return nil, fmt.Errorf("topic %s has no keywords", name)
+		}
+		m = append(m, pattern{name: "topic " + name, re: regexp.MustCompile(`(?i)` + strings.Join(alternatives, "|"))})
+	}
+	return m.run, nil
+}
+
+// isWord reports whether \b treats r as a word character
+func isWord(r rune) bool {
+	return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// PII types
+const (
+	PIIEmail      = "email"
+	PIICreditCard = "credit_card"
+	PIIIBAN       = "iban"
+	PIISSN        = "ssn"
+	PIIPhone      = "phone"
+	PIIIP         = "ip"
+)
+
+// PIITypes lists the PII types, in the order they are matched
+func PIITypes() []string {
+	return []string{PIIEmail, PIICreditCard, PIIIBAN, PIISSN, PIIPhone, PIIIP}
+}
+
+// piiPatterns are matched in the order of PIITypes, so card numbers are not
+// taken for phone numbers
+var piiPatterns = matcher{
+	{name: PIIEmail, re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	{name: PIICreditCard, re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhn},
+	{name: PIIIBAN, re: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), valid: ibanChecksum},
+	{name: PIISSN, re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{name: PIIPhone, re: regexp.MustCompile(`\+\d{1,3}[ .-]?\(?\d{1,4}\)?(?:[ .-]?\d{2,4}){2,4}\b`)},
+	{name: PIIPhone, re: regexp.MustCompile(`(?:\(\d{3}\)\s?|\b\d{3}[.-])\d{3}[.-]\d{4}\b`)},
+	{name: PIIPhone, re: regexp.MustCompile(`\b1[3-9]\d{9}\b`)}, // Chinese mobile numbers
+	{name: PIIIP, re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+}
+
+// pii flags personal data of the given types, all when empty, and of the
+// custom patterns
+func pii(types []string, patterns map[string]string) (func(string, bool) (string, []string), error) {
+	for _, t := range types {
+		if !slices.Contains(PIITypes(), t) {
+			return nil, fmt.Errorf("unknown PII type %q (must be one of %s)", t, strings.Join(PIITypes(), ", "))
+		}
+	}
+	var m matcher
+	for _, p := range piiPatterns {
+		if len(types) == 0 || slices.Contains(types, p.name) {
+			m = append(m, p)
+		}
+	}
+	extra, err := custom(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return append(m, extra...).run, nil
+}
+
+// luhn validates the checksum of a card number
+func luhn(s string) bool {
+	var digits []int
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i, d := range slices.Backward(digits) {
+		if (len(digits)-1-i)%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// ibanChecksum validates the mod 97 checksum of an IBAN
+func ibanChecksum(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	var digits strings.Builder
+	for _, r := range s[4:] + s[:4] {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprint(&digits, int(r-'A')+10)
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// secretPatterns are credentials with a recognizable format, and values
+// assigned to keys named like credentials
+var secretPatterns = matcher{
+	{name: "private_key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|\z)`)},
+	{name: "aws_access_key", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "github_token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "slack_token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{name: "google_api_key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{name: "api_key", re: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{name: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{name: "assigned_secret", keep: true, re: regexp.MustCompile(`(?i)(\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?token|auth[_-]?token|client[_-]?secret)["']?\s*[:=]\s*)["']?[^\s"',;]{8,}["']?`)},
+}
+
+// secrets flags credentials and the custom patterns
+func secrets(patterns map[string]string) (func(string, bool) (string, []string), error) {
+	extra, err := custom(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clone(secretPatterns), extra...).run, nil
+}
@@ -0,0 +1,214 @@
+package guardrails
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Stage is where a pipeline runs
+type Stage string
+
+const (
+	// StageInput checks user messages before they reach the model
+	StageInput Stage = "input"
+	// StageOutput checks model responses before they reach the user
+	StageOutput Stage = "output"
+)
+
+// Action is what a check does with content it flags
+type Action string
+
+const (
+	// ActionBlock replaces the content with a message
+	ActionBlock Action = "block"
+	// ActionRedact replaces the flagged parts, only for pii and secrets
+	ActionRedact Action = "redact"
+	// ActionLog only reports the violation
+	ActionLog Action = "log"
+)
+
+// Checks
+const (
+	CheckMaxSize         = "max_size"
+	CheckPromptInjection = "prompt_injection"
+	CheckBlockedTopics   = "blocked_topics"
+	CheckPII             = "pii"
+	CheckSecrets         = "secrets"
+	CheckModeration      = "moderation"
+)
+
+// Checks lists the available checks
+func Checks() []string {
+	return []string{CheckMaxSize, CheckPromptInjection, CheckBlockedTopics, CheckPII, CheckSecrets, CheckModeration}
+}
+
+// CheckConfig configures one check of a pipeline
+type CheckConfig struct {
+	Check string
+	// Action defaults to redact for pii and secrets, block for the others
+	Action Action
+	// MaxSize is the size limit of max_size, in bytes
+	MaxSize int64
+	// Topics maps the topics of blocked_topics to their keywords
+	Topics map[string][]string
+	// Types limits pii to some of PIITypes, defaults to all
+	Types []string
+	// Patterns adds named regular expressions to pii or secrets
+	Patterns map[string]string
+	// Moderator classifies text for moderation, which checks input only
+	Moderator Moderator
+	// Thresholds are the scores from which moderation flags a category;
+	// other categories follow the provider's verdict
+	Thresholds map[string]float64
+}
+
+// Violation is a check flagging content. Details name what matched, such as
+// a PII type or a topic, never the content itself.
+type Violation struct {
+	Stage  Stage  `json:"stage"`
+	Check  string `json:"check"`
+	Action Action `json:"action"`
+	Detail string `json:"detail"`
+	Count  int    `json:"count"`
+}
+
+// String describes the violation for logs and messages
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Check, v.Detail)
+}
+
+// Result is the outcome of a pipeline
+type Result struct {
+	// Text is the content with the redactions applied
+	Text       string
+	Violations []Violation
+	// Blocked is set when a blocking check flagged the content
+	Blocked bool
+}
+
+// check flags parts of a text. It returns the text with its findings
+// redacted and the detail of each finding.
+type check struct {
+	name   string
+	action Action
+	run    func(ctx context.Context, text string, redact bool) (string, []string)
+}
+
+// Pipeline runs checks on the content of one stage, in order
+type Pipeline struct {
+	stage  Stage
+	checks []check
+}
+
+// NewPipeline creates the pipeline of a stage
+func NewPipeline(stage Stage, checks []CheckConfig) (*Pipeline, error) {
+	p := &Pipeline{stage: stage}
+	for i, cfg := range checks {
+		if cfg.Check == CheckModeration && stage != StageInput {
+			return nil, fmt.Errorf("%s check %d: moderation only checks input", stage, i+1)
+		}
+		c, err := newCheck(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s check %d: %w", stage, i+1, err)
+		}
+		p.checks = append(p.checks, c)
+	}
+	return p, nil
+}
+
+// Empty reports whether the pipeline has no checks
+func (p *Pipeline) Empty() bool {
+	return p == nil || len(p.checks) == 0
+}
+
+// Run checks text. Checks after a blocking one still run, so the result
+// lists every violation.
+func (p *Pipeline) Run(text string) Result {
+	return p.RunContext(context.Background(), text)
+}
+
+// RunContext checks text as Run does, with ctx for the checks calling an API
+func (p *Pipeline) RunContext(ctx context.Context, text string) Result {
+	result := Result{Text: text}
+	if p == nil {
+		return result
+	}
+	for _, c := range p.checks {
+		redacted, findings := c.run(ctx, result.Text, c.action == ActionRedact)
+		if len(findings) == 0 {
+			continue
+		}
+		if c.action == ActionRedact {
+			result.Text = redacted
+		}
+		result.Blocked = result.Blocked || c.action == ActionBlock
+
+		var violations []Violation
+		for _, detail := range findings {
+			i := slices.IndexFunc(violations, func(v Violation) bool { return v.Detail == detail })
+			if i < 0 {
+				violations = append(violations, Violation{Stage: p.stage, Check: c.name, Action: c.action, Detail: detail})
+				i = len(violations) - 1
+			}
+			violations[i].Count++
+		}
+		result.Violations = append(result.Violations, violations...)
+	}
+	return result
+}
+
+// newCheck builds a check from its config
+func newCheck(cfg CheckConfig) (check, error) {
+	c := check{name: cfg.Check, action: cfg.Action}
+	redactable := cfg.Check == CheckPII || cfg.Check == CheckSecrets
+	if c.action == "" {
+		c.action = ActionBlock
+		if redactable {
+			c.action = ActionRedact
+		}
+	}
+	switch c.action {
+	case ActionBlock, ActionLog:
+	case ActionRedact:
+		if !redactable {
+			return check{}, fmt.Errorf("%s cannot redact, use block or log", cfg.Check)
+		}
+	default:
+		return check{}, fmt.Errorf("invalid action %q (must be block, redact or log)", c.action)
+	}
+
+	var (
+		run func(text string, redact bool) (string, []string)
+		err error
+	)
+	switch cfg.Check {
+	case CheckMaxSize:
+		if cfg.MaxSize <= 0 {
+			return check{}, fmt.Errorf("max_size requires a size limit")
+		}
+		run = maxSize(cfg.MaxSize)
+	case CheckPromptInjection:
+		run = matcher(injectionPatterns).run
+	case CheckBlockedTopics:
+		run, err = topics(cfg.Topics)
+	case CheckPII:
+		run, err = pii(cfg.Types, cfg.Patterns)
+	case CheckSecrets:
+		run, err = secrets(cfg.Patterns)
+	case CheckModeration:
+		c.run, err = moderation(cfg.Moderator, cfg.Thresholds)
+	default:
+		return check{}, fmt.Errorf("unknown check %q (must be one of %s)", cfg.Check, strings.Join(Checks(), ", "))
+	}
+	if err != nil {
+		return check{}, err
+	}
+	if run != nil {
+		c.run = func(_ context.Context, text string, redact bool) (string, []string) {
+			return run(text, redact)
+		}
+	}
+	return c, nil
+}
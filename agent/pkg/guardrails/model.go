@@ -0,0 +1,259 @@
+package guardrails
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// Default messages, used when the config leaves them empty; {checks} is
+// replaced by the names of the blocking checks
+const (
+	DefaultInputMessage  = "I can't help with this request because it was blocked by a content policy ({checks})."
+	DefaultOutputMessage = "The response was withheld because it was blocked by a content policy ({checks})."
+)
+
+// withheld replaces earlier user messages that were blocked, so the model
+// does not see them on later turns either
+const withheld = "[Message withheld: it was blocked by a content policy.]"
+
+// MetadataViolations is the CustomMetadata key carrying the []Violation of a turn
+const MetadataViolations = "guardrail_violations"
+
+// Config holds the pipelines
+type Config struct {
+	// Input checks user messages before the model sees them
+	Input []CheckConfig
+	// Output checks the text of model responses
+	Output []CheckConfig
+
+	InputMessage  string // Reply to a blocked message, defaults to DefaultInputMessage
+	OutputMessage string // Replaces a blocked response, defaults to DefaultOutputMessage
+
+	Logger *slog.Logger
+}
+
+// Model wraps a model.LLM with the guardrail pipelines. A blocked message is
+// answered without calling the model; a blocked response is replaced. The
+// violations of a turn are logged and attached to its final response.
+type Model struct {
+	llm    model.LLM
+	input  *Pipeline
+	output *Pipeline
+	cfg    Config
+	logger *slog.Logger
+}
+
+// NewModel wraps llm with the pipelines in cfg
+func NewModel(llm model.LLM, cfg *Config) (*Model, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	input, err := NewPipeline(StageInput, cfg.Input)
+	if err != nil {
+		return nil, err
+	}
+	output, err := NewPipeline(StageOutput, cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *cfg
+	c.InputMessage = cmp.Or(c.InputMessage, DefaultInputMessage)
+	c.OutputMessage = cmp.Or(c.OutputMessage, DefaultOutputMessage)
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("guardrails")
+	}
+	return &Model{llm: llm, input: input, output: output, cfg: c, logger: logger}, nil
+}
+
+// Name implements model.LLM
+func (m *Model) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM. Streamed chunks are redacted one by
+// one, so a match split across chunks can reach the client; the final
+// response, which is what the session keeps, is checked as a whole.
+func (m *Model) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		var violations []Violation
+		if !m.input.Empty() {
+			var blocked bool
+			req, violations, blocked = m.checkInput(ctx, req)
+			if blocked {
+				yield(&model.LLMResponse{
+					Content:        genai.NewContentFromText(message(m.cfg.InputMessage, violations), genai.RoleModel),
+					TurnComplete:   true,
+					CustomMetadata: map[string]any{MetadataViolations: violations},
+				}, nil)
+				return
+			}
+		}
+
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			if err != nil || resp == nil {
+				if !yield(resp, err) {
+					return
+				}
+				continue
+			}
+			if resp.Partial {
+				if resp, ok := m.checkPartial(ctx, resp); ok && !yield(resp, nil) {
+					return
+				}
+				continue
+			}
+
+			final := *resp
+			result := m.checkOutput(ctx, &final)
+			m.report(result.Violations)
+			violations = append(violations, result.Violations...)
+			if result.Blocked {
+				final.Content = genai.NewContentFromText(message(m.cfg.OutputMessage, result.Violations), genai.RoleModel)
+			}
+			if len(violations) > 0 {
+				final.CustomMetadata = maps.Clone(resp.CustomMetadata)
+				if final.CustomMetadata == nil {
+					final.CustomMetadata = map[string]any{}
+				}
+				final.CustomMetadata[MetadataViolations] = violations
+			}
+			if !yield(&final, nil) {
+				return
+			}
+		}
+	}
+}
+
+// checkInput runs the input pipeline on the user messages of req. The
+// latest message decides whether the turn is blocked; earlier ones, which
+// were checked on their own turn, are redacted or withheld again because
+// they come back with the history.
+func (m *Model) checkInput(ctx context.Context, req *model.LLMRequest) (*model.LLMRequest, []Violation, bool) {
+	var (
+		contents   []*genai.Content
+		violations []Violation
+		blocked    bool
+	)
+	for i, content := range req.Contents {
+		if content == nil || (content.Role != genai.RoleUser && content.Role != "") {
+			continue
+		}
+		latest := i == len(req.Contents)-1
+		checked, result := m.checkContent(ctx, m.input, content)
+		if latest {
+			violations, blocked = result.Violations, result.Blocked
+			m.report(violations)
+		} else if result.Blocked {
+			checked = &genai.Content{Role: content.Role, Parts: []*genai.Part{genai.NewPartFromText(withheld)}}
+		}
+		if checked == content {
+			continue
+		}
+		if contents == nil {
+			// Copy on write: contents belong to the session history
+			contents = append([]*genai.Content(nil), req.Contents...)
+		}
+		contents[i] = checked
+	}
+
+	if contents == nil {
+		return req, violations, blocked
+	}
+	guarded := *req
+	guarded.Contents = contents
+	return &guarded, violations, blocked
+}
+
+// checkPartial redacts a streamed chunk, dropping it when a check blocks it
+func (m *Model) checkPartial(ctx context.Context, resp *model.LLMResponse) (*model.LLMResponse, bool) {
+	if m.output.Empty() || resp.Content == nil {
+		return resp, true
+	}
+	checked, result := m.checkContent(ctx, m.output, resp.Content)
+	if result.Blocked {
+		return nil, false
+	}
+	partial := *resp
+	partial.Content = checked
+	return &partial, true
+}
+
+// checkOutput runs the output pipeline on a final response, redacting it in place
+func (m *Model) checkOutput(ctx context.Context, resp *model.LLMResponse) Result {
+	if m.output.Empty() || resp.Content == nil {
+		return Result{}
+	}
+	checked, result := m.checkContent(ctx, m.output, resp.Content)
+	resp.Content = checked
+	return result
+}
+
+// checkContent runs p on the text parts of content. It returns content
+// itself when nothing was redacted, else a copy.
+func (m *Model) checkContent(ctx context.Context, p *Pipeline, content *genai.Content) (*genai.Content, Result) {
+	var (
+		out      *genai.Content
+		combined Result
+	)
+	for i, part := range content.Parts {
+		if part == nil || part.Text == "" {
+			continue
+		}
+		result := p.RunContext(ctx, part.Text)
+		combined.Violations = append(combined.Violations, result.Violations...)
+		combined.Blocked = combined.Blocked || result.Blocked
+		if result.Text == part.Text {
+			continue
+		}
+		if out == nil {
+			out = &genai.Content{Role: content.Role, Parts: append([]*genai.Part(nil), content.Parts...)}
+		}
+		redacted := *part
+		redacted.Text = result.Text
+		out.Parts[i] = &redacted
+	}
+	if out == nil {
+		return content, combined
+	}
+	return out, combined
+}
+
+// report logs violations
+func (m *Model) report(violations []Violation) {
+	for _, v := range violations {
+		m.logger.Warn("Guardrail violation",
+			"stage", v.Stage,
+			"check", v.Check,
+			"action", v.Action,
+			"detail", v.Detail,
+			"count", v.Count,
+			"model", m.llm.Name(),
+		)
+	}
+}
+
+// message fills the names of the blocking checks into a message
+func message(template string, violations []Violation) string {
+	var checks []string
+	for _, v := range violations {
+		if v.Action == ActionBlock && !slices.Contains(checks, v.Check) {
+			checks = append(checks, v.Check)
+		}
+	}
+	return strings.ReplaceAll(template, "{checks}", strings.Join(checks, ", "))
+}
@@ -0,0 +1,90 @@
+package guardrails
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Moderator classifies text with a moderation API, such as an
+// openai_compatible.Client with a moderation model
+type Moderator interface {
+	Moderate(ctx context.Context, input string) (*openai_compatible.Moderation, error)
+}
+
+// maxModerations bounds the verdicts cached by a moderation check, which
+// sees earlier user messages again with every turn; the cache is cleared
+// when full
+const maxModerations = 1000
+
+// moderation flags the categories of text the moderator flags, or scores at
+// or above their threshold. When the moderator fails, the text passes and
+// the error is logged, so an outage of the moderation API does not stop the
+// agent.
+func moderation(moderator Moderator, thresholds map[string]float64) (func(ctx context.Context, text string, redact bool) (string, []string), error) {
+	if moderator == nil {
+		return nil, fmt.Errorf("moderation requires a moderator")
+	}
+	for category, threshold := range thresholds {
+		if threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("threshold of %s is %v, not a score in [0, 1]", category, threshold)
+		}
+	}
+
+	logger := logging.Component("guardrails")
+	var mu sync.Mutex
+	verdicts := make(map[[sha256.Size]byte][]string)
+	return func(ctx context.Context, text string, _ bool) (string, []string) {
+		key := sha256.Sum256([]byte(text))
+		mu.Lock()
+		findings, ok := verdicts[key]
+		mu.Unlock()
+		if ok {
+			return text, findings
+		}
+
+		result, err := moderator.Moderate(ctx, text)
+		if err != nil {
+			logger.WarnContext(ctx, "Moderation failed, letting the message through", "error", err)
+			return text, nil
+		}
+		findings = flaggedCategories(result, thresholds)
+		mu.Lock()
+		if len(verdicts) >= maxModerations {
+			clear(verdicts)
+		}
+		verdicts[key] = findings
+		mu.Unlock()
+		return text, findings
+	}, nil
+}
+
+// flaggedCategories returns the sorted categories of result at or above
+// their threshold, or flagged by the provider when they have none
+func flaggedCategories(result *openai_compatible.Moderation, thresholds map[string]float64) []string {
+	categories := maps.Clone(result.Categories)
+	if categories == nil {
+		categories = map[string]bool{}
+	}
+	for category := range result.Scores {
+		categories[category] = categories[category] || false
+	}
+
+	var flagged []string
+	for _, category := range slices.Sorted(maps.Keys(categories)) {
+		if threshold, ok := thresholds[category]; ok {
+			if result.Scores[category] >= threshold {
+				flagged = append(flagged, category)
+			}
+		} else if categories[category] {
+			flagged = append(flagged, category)
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,218 @@
+// Package health serves liveness and readiness probes. Liveness only reports
+// that the process serves requests; readiness runs checks of the upstream
+// dependencies, such as the model provider, and caches their results so
+// frequent probes cost at most one upstream call per check and interval.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// Defaults of Config
+const (
+	DefaultCacheTTL = 30 * time.Second
+	DefaultTimeout  = 5 * time.Second
+)
+
+// Check is a readiness check of a dependency
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Config holds the readiness checks
+type Config struct {
+	Checks []Check
+	// CacheTTL is how long a check result is reused, defaults to DefaultCacheTTL
+	CacheTTL time.Duration
+	// Timeout bounds each check, defaults to DefaultTimeout
+	Timeout time.Duration
+	Logger  *slog.Logger
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name      string    `json:"name"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the readiness of the service
+type Report struct {
+	Status string   `json:"status"` // "ok" or "unavailable"
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs the readiness checks
+type Checker struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	results []Result
+	running []*sync.Mutex // Serializes each check, so concurrent probes share one run
+}
+
+// New creates a checker
+func New(cfg *Config) (*Checker, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	c := *cfg
+	for _, check := range c.Checks {
+		if check.Name == "" || check.Run == nil {
+			return nil, fmt.Errorf("checks need a name and a function")
+		}
+	}
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = DefaultCacheTTL
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("health")
+	}
+
+	running := make([]*sync.Mutex, len(c.Checks))
+	for i := range running {
+		running[i] = &sync.Mutex{}
+	}
+	return &Checker{
+		cfg:     c,
+		logger:  logger,
+		results: make([]Result, len(c.Checks)),
+		running: running,
+	}, nil
+}
+
+// Ready runs the checks whose cached result expired and reports readiness
+func (c *Checker) Ready(ctx context.Context) Report {
+	var wg sync.WaitGroup
+	for i := range c.cfg.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.check(ctx, i)
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := Report{Status: "ok", Checks: append([]Result(nil), c.results...)}
+	for _, r := range report.Checks {
+		if !r.OK {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+// check runs check i unless its result is still fresh
+func (c *Checker) check(ctx context.Context, i int) {
+	c.running[i].Lock()
+	defer c.running[i].Unlock()
+	c.mu.Lock()
+	last := c.results[i]
+	c.mu.Unlock()
+	if !last.CheckedAt.IsZero() && time.Since(last.CheckedAt) < c.cfg.CacheTTL {
+		return
+	}
+
+	check := c.cfg.Checks[i]
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := check.Run(ctx)
+	result := Result{Name: check.Name, OK: err == nil, LatencyMS: time.Since(start).Milliseconds(), CheckedAt: time.Now()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if !last.CheckedAt.IsZero() && last.OK != result.OK {
+		if err != nil {
+			c.logger.Warn("Readiness check failing", "check", check.Name, "error", err)
+		} else {
+			c.logger.Info("Readiness check recovered", "check", check.Name)
+		}
+	}
+
+	c.mu.Lock()
+	c.results[i] = result
+	c.mu.Unlock()
+}
+
+// LiveHandler answers liveness probes
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ServeHTTP answers readiness probes with the report, 503 when a check fails
+func (c *Checker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := c.Ready(req.Context())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Lister is a model that lists the models of its provider
+type Lister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// ModelsCheck checks the provider by listing its models, which costs no
+// tokens. current returns the model to check, which must be a Lister.
+func ModelsCheck(current func() model.LLM) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		llm := current()
+		lister, ok := llm.(Lister)
+		if !ok {
+			return fmt.Errorf("model %s cannot list models", llm.Name())
+		}
+		if _, err := lister.ListModels(ctx); err != nil {
+			return fmt.Errorf("failed to list models: %w", err)
+		}
+		return nil
+	}
+}
+
+// CompletionCheck checks the model with a one-token completion
+func CompletionCheck(llm model.LLM) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req := &model.LLMRequest{
+			Model:    llm.Name(),
+			Contents: []*genai.Content{genai.NewContentFromText("ping", genai.RoleUser)},
+			Config:   &genai.GenerateContentConfig{MaxOutputTokens: 1},
+		}
+		for _, err := range llm.GenerateContent(ctx, req, false) {
+			if err != nil {
+				return fmt.Errorf("completion failed: %w", err)
+			}
+		}
+		return nil
+	}
+}
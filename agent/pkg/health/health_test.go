@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// listModel is a model whose provider is reachable when up is true
+type listModel struct {
+	up    bool
+	calls int
+}
+
+func (*listModel) Name() string { return "list" }
+
+func (m *listModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.calls++
+		if !m.up {
+			yield(nil, errors.New("connection refused"))
+			return
+		}
+		if req.Config.MaxOutputTokens != 1 {
+			yield(nil, errors.New("not a one-token completion"))
+			return
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText("p", genai.RoleModel)}, nil)
+	}
+}
+
+func (m *listModel) ListModels(context.Context) ([]string, error) {
+	m.calls++
+	if !m.up {
+		return nil, errors.New("connection refused")
+	}
+	return []string{"list"}, nil
+}
+
+// TestChecker tests readiness reports and the caching of check results
+func TestChecker(t *testing.T) {
+	llm := &listModel{up: true}
+	c, err := New(&Config{
+		Checks: []Check{
+			{Name: "models", Run: ModelsCheck(func() model.LLM { return llm })},
+			{Name: "completion", Run: CompletionCheck(llm)},
+		},
+		CacheTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := func(h http.Handler) (int, Report) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report Report
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		return rec.Code, report
+	}
+
+	code, report := probe(c)
+	if code != http.StatusOK || report.Status != "ok" || len(report.Checks) != 2 || !report.Checks[0].OK || !report.Checks[1].OK {
+		t.Errorf("ready = %d %+v", code, report)
+	}
+	llm.up = false
+	if code, _ := probe(c); code != http.StatusOK || llm.calls != 2 {
+		t.Errorf("cached ready = %d after %d upstream calls, want 200 after 2", code, llm.calls)
+	}
+
+	c.cfg.CacheTTL = time.Nanosecond
+	code, report = probe(c)
+	if code != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Checks[0].Error == "" {
+		t.Errorf("unreachable = %d %+v", code, report)
+	}
+	if code, _ := probe(c.LiveHandler()); code != http.StatusOK {
+		t.Errorf("live = %d", code)
+	}
+
+	unlisted := &struct{ model.LLM }{llm}
+	if err := ModelsCheck(func() model.LLM { return unlisted })(context.Background()); err == nil {
+		t.Error("ModelsCheck() succeeded on a model that cannot list models")
+	}
+}
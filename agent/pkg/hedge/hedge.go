@@ -0,0 +1,198 @@
+package hedge
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+)
+
+// Config holds the hedging settings
+type Config struct {
+	// Secondary is sent the same request when the primary model is slow
+	Secondary model.LLM
+	// Delay is how long the primary model has to produce its first response
+	// before the request is hedged
+	Delay time.Duration
+
+	Logger *slog.Logger
+}
+
+// Model wraps a model.LLM and hedges slow requests: when the primary model
+// has not produced a first response within the delay, the same request is
+// sent to the secondary model, and whichever responds first is streamed while
+// the other is cancelled. A model that fails before responding does not win
+// the race while the other is still running.
+type Model struct {
+	llm    model.LLM
+	cfg    Config
+	logger *slog.Logger
+}
+
+// NewModel wraps llm with hedging to the secondary model in cfg
+func NewModel(llm model.LLM, cfg *Config) (*Model, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	if cfg.Secondary == nil {
+		return nil, fmt.Errorf("secondary model is required")
+	}
+	if cfg.Delay <= 0 {
+		return nil, fmt.Errorf("hedge delay must be positive")
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("hedge")
+	}
+
+	return &Model{
+		llm:    llm,
+		cfg:    *cfg,
+		logger: logger,
+	}, nil
+}
+
+// Name implements model.LLM
+func (m *Model) Name() string {
+	return m.llm.Name()
+}
+
+// result is one response or error of a racing model
+type result struct {
+	resp *model.LLMResponse
+	err  error
+}
+
+// racer is a model call running in the background
+type racer struct {
+	name    string
+	results <-chan result
+	cancel  context.CancelFunc
+}
+
+// start calls llm in the background, sending its results until ctx is done
+func start(ctx context.Context, llm model.LLM, req *model.LLMRequest, stream bool) *racer {
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan result)
+	go func() {
+		defer close(results)
+		for resp, err := range llm.GenerateContent(ctx, req, stream) {
+			select {
+			case results <- result{resp, err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return &racer{name: llm.Name(), results: results, cancel: cancel}
+}
+
+// GenerateContent implements model.LLM
+func (m *Model) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		// Racers are cancelled once the caller stops reading
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		startTime := time.Now()
+		primary := start(ctx, m.llm, req, stream)
+		var secondary *racer
+
+		timer := time.NewTimer(m.cfg.Delay)
+		defer timer.Stop()
+
+		// Wait for the first response of either model
+		var winner *racer
+		var first result
+		var failed error
+		for winner == nil {
+			var primaryResults, secondaryResults <-chan result
+			var timeout <-chan time.Time
+			if primary != nil {
+				primaryResults = primary.results
+				if secondary == nil {
+					timeout = timer.C
+				}
+			}
+			if secondary != nil {
+				secondaryResults = secondary.results
+			}
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			case <-timeout:
+				m.logger.InfoContext(ctx, "Primary model is slow, hedging the request",
+					"model", m.llm.Name(),
+					"secondary", m.cfg.Secondary.Name(),
+					"delay", m.cfg.Delay,
+				)
+				secondary = start(ctx, m.cfg.Secondary, req, stream)
+			case r, ok := <-primaryResults:
+				if ok && (r.err == nil || secondary == nil) {
+					winner, first = primary, r
+				} else {
+					failed = m.drop(ctx, primary, r, ok, failed)
+					primary = nil
+				}
+			case r, ok := <-secondaryResults:
+				if ok && (r.err == nil || primary == nil) {
+					winner, first = secondary, r
+				} else {
+					failed = m.drop(ctx, secondary, r, ok, failed)
+					secondary = nil
+				}
+			}
+			if winner == nil && primary == nil && secondary == nil {
+				if failed != nil {
+					yield(nil, failed)
+				}
+				return
+			}
+		}
+
+		// Cancel the loser
+		loser := secondary
+		if winner == secondary {
+			loser = primary
+		}
+		if loser != nil {
+			loser.cancel()
+			m.logger.InfoContext(ctx, "Hedged request answered",
+				"winner", winner.name,
+				"cancelled", loser.name,
+				"first_response", time.Since(startTime),
+			)
+		}
+
+		if !yield(first.resp, first.err) {
+			return
+		}
+		for r := range winner.results {
+			if !yield(r.resp, r.err) {
+				return
+			}
+		}
+	}
+}
+
+// drop takes a racer out of the race after it failed, or ended, before
+// responding while the other model is still running. It returns the error to
+// report when the other model fails too.
+func (m *Model) drop(ctx context.Context, racer *racer, r result, ok bool, failed error) error {
+	racer.cancel()
+	if !ok {
+		return failed
+	}
+	m.logger.WarnContext(ctx, "Hedged model failed, waiting for the other", "model", racer.name, "error", r.err)
+	return r.err
+}
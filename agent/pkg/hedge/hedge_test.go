@@ -0,0 +1,107 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// slowModel answers with its name after a delay, or fails with err
+type slowModel struct {
+	name      string
+	delay     time.Duration
+	err       error
+	calls     atomic.Int32
+	cancelled atomic.Bool
+}
+
+func (m *slowModel) Name() string { return m.name }
+
+func (m *slowModel) GenerateContent(ctx context.Context, _ *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.calls.Add(1)
+		select {
+		case <-ctx.Done():
+			m.cancelled.Store(true)
+			yield(nil, ctx.Err())
+			return
+		case <-time.After(m.delay):
+		}
+		if m.err != nil {
+			yield(nil, m.err)
+			return
+		}
+		if !yield(&model.LLMResponse{Content: genai.NewContentFromText(m.name, genai.RoleModel), Partial: true}, nil) {
+			return
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(m.name, genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// TestModel tests that the first model to respond is streamed
+func TestModel(t *testing.T) {
+	failure := errors.New("unavailable")
+	tests := []struct {
+		name          string
+		primary       *slowModel
+		secondary     *slowModel
+		want          string
+		wantErr       error
+		wantSecondary bool
+	}{
+		{"primary fast", &slowModel{name: "primary"}, &slowModel{name: "secondary"}, "primary", nil, false},
+		{"primary slow", &slowModel{name: "primary", delay: time.Second}, &slowModel{name: "secondary"}, "secondary", nil, true},
+		{"secondary fails", &slowModel{name: "primary", delay: 100 * time.Millisecond}, &slowModel{name: "secondary", err: failure}, "primary", nil, true},
+		{"primary fails fast", &slowModel{name: "primary", err: failure}, &slowModel{name: "secondary"}, "", failure, false},
+		{"both fail", &slowModel{name: "primary", delay: 50 * time.Millisecond, err: failure}, &slowModel{name: "secondary", err: failure}, "", failure, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewModel(tt.primary, &Config{Secondary: tt.secondary, Delay: 20 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("NewModel() error = %v", err)
+			}
+			var got []string
+			var gotErr error
+			for resp, err := range m.GenerateContent(context.Background(), &model.LLMRequest{}, true) {
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				got = append(got, resp.Content.Parts[0].Text)
+			}
+			if !errors.Is(gotErr, tt.wantErr) {
+				t.Fatalf("GenerateContent() error = %v, want %v", gotErr, tt.wantErr)
+			}
+			if tt.want != "" && (len(got) != 2 || got[0] != tt.want || got[1] != tt.want) {
+				t.Errorf("responses = %v, want two from %s", got, tt.want)
+			}
+			if called := tt.secondary.calls.Load() > 0; called != tt.wantSecondary {
+				t.Errorf("secondary called = %v, want %v", called, tt.wantSecondary)
+			}
+		})
+	}
+
+	// The slow primary is cancelled once the secondary wins
+	primary := &slowModel{name: "primary", delay: time.Second}
+	m, _ := NewModel(primary, &Config{Secondary: &slowModel{name: "secondary"}, Delay: 10 * time.Millisecond})
+	for range m.GenerateContent(context.Background(), &model.LLMRequest{}, false) {
+	}
+	deadline := time.Now().Add(time.Second)
+	for !primary.cancelled.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !primary.cancelled.Load() {
+		t.Error("primary was not cancelled")
+	}
+
+	if _, err := NewModel(primary, &Config{Secondary: primary}); err == nil {
+		t.Error("NewModel() accepted a zero delay")
+	}
+}
@@ -0,0 +1,167 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/guardrails"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/tool"
+)
+
+// Built-in hooks
+const (
+	// Log logs model calls and tool calls
+	Log = "log"
+	// Redact redacts secrets, and optionally personal data, in tool results
+	// before the model sees them
+	Redact = "redact"
+)
+
+func init() {
+	Register(Log, newLog)
+	Register(Redact, newRedact)
+}
+
+// logOptions are the options of the log hook
+type logOptions struct {
+	Level string `json:"level"` // debug, info (default) or warn
+	Args  bool   `json:"args"`  // Logs tool arguments, which may hold user data
+}
+
+func newLog(options map[string]any) (*Hooks, error) {
+	var opts logOptions
+	if err := Decode(options, &opts); err != nil {
+		return nil, err
+	}
+	var level slog.Level
+	switch opts.Level {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn":
+		level = slog.LevelWarn
+	default:
+		return nil, fmt.Errorf("invalid level %q (must be debug, info or warn)", opts.Level)
+	}
+	log := func(msg string, args ...any) {
+		logging.Component("hooks").Log(context.Background(), level, msg, args...)
+	}
+
+	return &Hooks{
+		BeforeModel: func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+			log("Model request", "agent", ctx.AgentName(), "invocation", ctx.InvocationID(), "contents", len(req.Contents))
+			return nil, nil
+		},
+		AfterModel: func(ctx agent.CallbackContext, resp *model.LLMResponse, err error) (*model.LLMResponse, error) {
+			switch {
+			case err != nil:
+				log("Model error", "agent", ctx.AgentName(), "invocation", ctx.InvocationID(), "error", err)
+			case resp != nil && !resp.Partial:
+				args := []any{"agent", ctx.AgentName(), "invocation", ctx.InvocationID(), "finish_reason", resp.FinishReason}
+				if u := resp.UsageMetadata; u != nil {
+					args = append(args, "prompt_tokens", u.PromptTokenCount, "completion_tokens", u.CandidatesTokenCount)
+				}
+				log("Model response", args...)
+			}
+			return nil, nil
+		},
+		BeforeTool: func(ctx tool.Context, t tool.Tool, args map[string]any) (map[string]any, error) {
+			attrs := []any{"agent", ctx.AgentName(), "invocation", ctx.InvocationID(), "tool", t.Name()}
+			if opts.Args {
+				attrs = append(attrs, "args", args)
+			}
+			log("Tool call", attrs...)
+			return nil, nil
+		},
+		AfterTool: func(ctx tool.Context, t tool.Tool, _, _ map[string]any, err error) (map[string]any, error) {
+			attrs := []any{"agent", ctx.AgentName(), "invocation", ctx.InvocationID(), "tool", t.Name()}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+			log("Tool result", attrs...)
+			return nil, nil
+		},
+	}, nil
+}
+
+// redactOptions are the options of the redact hook
+type redactOptions struct {
+	PII      bool              `json:"pii"`      // Redacts personal data too
+	Types    []string          `json:"types"`    // PII types, defaults to all
+	Patterns map[string]string `json:"patterns"` // Extra named regular expressions
+}
+
+func newRedact(options map[string]any) (*Hooks, error) {
+	var opts redactOptions
+	if err := Decode(options, &opts); err != nil {
+		return nil, err
+	}
+	checks := []guardrails.CheckConfig{{Check: guardrails.CheckSecrets, Action: guardrails.ActionRedact, Patterns: opts.Patterns}}
+	if opts.PII || len(opts.Types) > 0 {
+		checks = append(checks, guardrails.CheckConfig{Check: guardrails.CheckPII, Action: guardrails.ActionRedact, Types: opts.Types})
+	}
+	p, err := guardrails.NewPipeline(guardrails.StageOutput, checks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hooks{
+		AfterTool: func(ctx tool.Context, t tool.Tool, _, result map[string]any, _ error) (map[string]any, error) {
+			redacted, changed := redactValue(p, result)
+			if !changed {
+				return nil, nil
+			}
+			slog.Debug("Redacted tool result", "tool", t.Name(), "invocation", ctx.InvocationID())
+			return redacted.(map[string]any), nil
+		},
+	}, nil
+}
+
+// redactValue redacts the strings in v, which holds JSON-like values. It
+// returns a copy when something was redacted, since v may be shared.
+func redactValue(p *guardrails.Pipeline, v any) (any, bool) {
+	switch v := v.(type) {
+	case string:
+		result := p.Run(v)
+		return result.Text, result.Text != v
+	case map[string]any:
+		var out map[string]any
+		for k, item := range v {
+			redacted, changed := redactValue(p, item)
+			if !changed {
+				continue
+			}
+			if out == nil {
+				out = maps.Clone(v)
+			}
+			out[k] = redacted
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	case []any:
+		var out []any
+		for i, item := range v {
+			redacted, changed := redactValue(p, item)
+			if !changed {
+				continue
+			}
+			if out == nil {
+				out = append([]any(nil), v...)
+			}
+			out[i] = redacted
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	}
+	return v, false
+}
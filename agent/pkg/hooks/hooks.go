@@ -0,0 +1,184 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/tool"
+)
+
+// Hooks are callbacks around the model and tool calls of agent turns; any
+// may be nil. A before hook returning a response or result skips the call
+// and the later before hooks. An after hook returning a response or result
+// replaces it for the later hooks, unlike the callbacks of llmagent, which
+// stop at the first one that returns something.
+type Hooks struct {
+	BeforeModel llmagent.BeforeModelCallback
+	AfterModel  llmagent.AfterModelCallback
+	BeforeTool  llmagent.BeforeToolCallback
+	AfterTool   llmagent.AfterToolCallback
+}
+
+// Factory creates hooks from the options of a config entry
+type Factory func(options map[string]any) (*Hooks, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes hooks available under name, usually from the init function
+// of a package imported by the command for its side effects. It panics when
+// name is taken, like sql.Register.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("hooks: Register factory is nil")
+	}
+	if _, ok := factories[name]; ok {
+		panic("hooks: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Names lists the registered hooks, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return slices.Sorted(maps.Keys(factories))
+}
+
+// Spec selects registered hooks and their options
+type Spec struct {
+	Name    string
+	Options map[string]any
+}
+
+// Chain runs hooks in order
+type Chain struct {
+	hooks []*Hooks
+}
+
+// New creates the hooks of specs, in order
+func New(specs []Spec) (*Chain, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c := &Chain{}
+	for _, spec := range specs {
+		factory, ok := factories[spec.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown hook %q (registered: %v)", spec.Name, slices.Sorted(maps.Keys(factories)))
+		}
+		h, err := factory(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create hook %s: %w", spec.Name, err)
+		}
+		if h != nil {
+			c.hooks = append(c.hooks, h)
+		}
+	}
+	return c, nil
+}
+
+// Len returns the number of hooks
+func (c *Chain) Len() int {
+	return len(c.hooks)
+}
+
+// BeforeModel returns a callback running the before-model hooks
+func (c *Chain) BeforeModel() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		for _, h := range c.hooks {
+			if h.BeforeModel == nil {
+				continue
+			}
+			if resp, err := h.BeforeModel(ctx, req); resp != nil || err != nil {
+				return resp, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+// AfterModel returns a callback running the after-model hooks. It returns
+// nil when no hook replaced the response.
+func (c *Chain) AfterModel() llmagent.AfterModelCallback {
+	return func(ctx agent.CallbackContext, resp *model.LLMResponse, respErr error) (*model.LLMResponse, error) {
+		var replaced *model.LLMResponse
+		for _, h := range c.hooks {
+			if h.AfterModel == nil {
+				continue
+			}
+			next, err := h.AfterModel(ctx, resp, respErr)
+			if err != nil {
+				return nil, err
+			}
+			if next != nil {
+				resp, respErr, replaced = next, nil, next
+			}
+		}
+		return replaced, nil
+	}
+}
+
+// BeforeTool returns a callback running the before-tool hooks
+func (c *Chain) BeforeTool() llmagent.BeforeToolCallback {
+	return func(ctx tool.Context, t tool.Tool, args map[string]any) (map[string]any, error) {
+		for _, h := range c.hooks {
+			if h.BeforeTool == nil {
+				continue
+			}
+			if result, err := h.BeforeTool(ctx, t, args); result != nil || err != nil {
+				return result, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+// AfterTool returns a callback running the after-tool hooks. It returns nil
+// when no hook replaced the result.
+func (c *Chain) AfterTool() llmagent.AfterToolCallback {
+	return func(ctx tool.Context, t tool.Tool, args, result map[string]any, toolErr error) (map[string]any, error) {
+		var replaced map[string]any
+		for _, h := range c.hooks {
+			if h.AfterTool == nil {
+				continue
+			}
+			next, err := h.AfterTool(ctx, t, args, result, toolErr)
+			if err != nil {
+				return nil, err
+			}
+			if next != nil {
+				result, toolErr, replaced = next, nil, next
+			}
+		}
+		return replaced, nil
+	}
+}
+
+// Decode fills v, a pointer to a struct with json tags, from options. Unknown
+// options are an error.
+func Decode(options map[string]any, v any) error {
+	if len(options) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package httptool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+)
+
+// Defaults applied by New
+const (
+	DefaultTimeout         = 30 * time.Second
+	DefaultMaxResponseSize = 1 << 20
+	maxRedirects           = 5
+)
+
+// Config configures the http_request tool
+type Config struct {
+	// Allow lists the hosts requests may go to, as host names or patterns like
+	// "*.example.com" (subdomains only), optionally with a port
+	Allow []string
+	// Deny lists hosts that are refused even when allowed
+	Deny []string
+	// Methods limits the HTTP methods, defaults to all
+	Methods []string
+	// Timeout bounds a request, defaults to DefaultTimeout
+	Timeout time.Duration
+	// MaxResponseSize caps the response body returned, in bytes, defaults to DefaultMaxResponseSize
+	MaxResponseSize int
+	Logger          *slog.Logger
+	// Transport replaces the default transport, for tests
+	Transport http.RoundTripper
+}
+
+// Args are the arguments of the http_request tool
+type Args struct {
+	Method  string            `json:"method,omitempty" jsonschema:"HTTP method, defaults to GET"`
+	URL     string            `json:"url" jsonschema:"Absolute http or https URL"`
+	Headers map[string]string `json:"headers,omitempty" jsonschema:"Request headers"`
+	Body    string            `json:"body,omitempty" jsonschema:"Request body, e.g. JSON"`
+}
+
+// Result is the response; an error status is a result, not an error
+type Result struct {
+	Status    int               `json:"status"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// Client sends requests to allowed hosts
+type Client struct {
+	allow           []string
+	deny            []string
+	methods         []string
+	maxResponseSize int
+	client          *http.Client
+	logger          *slog.Logger
+}
+
+// New creates a client from cfg
+func New(cfg *Config) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if len(cfg.Allow) == 0 {
+		return nil, fmt.Errorf("at least one allowed host is required")
+	}
+	for _, pattern := range slices.Concat(cfg.Allow, cfg.Deny) {
+		if err := CheckPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Client{
+		allow:           lower(cfg.Allow),
+		deny:            lower(cfg.Deny),
+		maxResponseSize: cfg.MaxResponseSize,
+		logger:          cfg.Logger,
+	}
+	for _, method := range cfg.Methods {
+		c.methods = append(c.methods, strings.ToUpper(method))
+	}
+	if c.maxResponseSize <= 0 {
+		c.maxResponseSize = DefaultMaxResponseSize
+	}
+	if c.logger == nil {
+		c.logger = logging.Component("httptool")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c.client = &http.Client{
+		Timeout:   timeout,
+		Transport: cfg.Transport,
+		// Redirects are followed only to allowed hosts
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return c.check(req.URL)
+		},
+	}
+	return c, nil
+}
+
+// CheckPattern validates a host pattern
+func CheckPattern(pattern string) error {
+	if pattern == "" || strings.ContainsAny(strings.TrimPrefix(pattern, "*."), "*/?#@ ") {
+		return fmt.Errorf("host pattern %q must be a host name, optionally with a port or a leading *.", pattern)
+	}
+	return nil
+}
+
+func lower(s []string) []string {
+	out := make([]string, len(s))
+	for i, v := range s {
+		out[i] = strings.ToLower(v)
+	}
+	return out
+}
+
+// Tool returns the http_request tool backed by the client
+func (c *Client) Tool() (tool.Tool, error) {
+	methods := "any method"
+	if len(c.methods) > 0 {
+		methods = strings.Join(c.methods, ", ")
+	}
+	return functiontool.New(functiontool.Config{
+		Name: "http_request",
+		Description: fmt.Sprintf("Sends an HTTP request and returns the status, headers and body of the response. "+
+			"Allowed hosts: %s. Methods: %s. Bodies over %d bytes are cut.",
+			strings.Join(c.allow, ", "), methods, c.maxResponseSize),
+	}, func(ctx tool.Context, args Args) (Result, error) {
+		return c.Do(ctx, args)
+	})
+}
+
+// Do sends the request of args, rejecting hosts that are not allowed
+func (c *Client) Do(ctx context.Context, args Args) (Result, error) {
+	method := strings.ToUpper(strings.TrimSpace(args.Method))
+	if method == "" {
+		method = http.MethodGet
+	}
+	if len(c.methods) > 0 && !slices.Contains(c.methods, method) {
+		return Result{}, fmt.Errorf("method %s is not allowed, allowed: %s", method, strings.Join(c.methods, ", "))
+	}
+	u, err := url.Parse(strings.TrimSpace(args.URL))
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid URL %q: %w", args.URL, err)
+	}
+	if err := c.check(u); err != nil {
+		return Result{}, err
+	}
+
+	var body io.Reader
+	if args.Body != "" {
+		body = strings.NewReader(args.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range args.Headers {
+		req.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s %s failed: %w", method, u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(c.maxResponseSize)+1))
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read response of %s %s: %w", method, u.Redacted(), err)
+	}
+	result := Result{Status: resp.StatusCode, Headers: map[string]string{}}
+	if len(data) > c.maxResponseSize {
+		data, result.Truncated = data[:c.maxResponseSize], true
+	}
+	for key := range resp.Header {
+		result.Headers[key] = resp.Header.Get(key)
+	}
+	text := data
+	for i := 0; i < utf8.UTFMax-1 && result.Truncated && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1] // A character cut in half by the limit
+	}
+	if utf8.Valid(text) {
+		result.Body = string(text)
+	} else {
+		result.Body = fmt.Sprintf("[%d bytes of binary %s data]", len(data), resp.Header.Get("Content-Type"))
+	}
+
+	c.logger.Info("HTTP request finished", "method", method, "host", u.Host, "status", resp.StatusCode,
+		"bytes", len(data), "truncated", result.Truncated, "duration", time.Since(start))
+	return result, nil
+}
+
+// check rejects URLs that are not http or https or whose host is denied or
+// not allowed
+func (c *Client) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL scheme %q is not allowed, use http or https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("URL %q has no host", u.Redacted())
+	}
+	if u.User != nil {
+		return fmt.Errorf("credentials in URLs are not allowed, use a header")
+	}
+	if matchHost(c.deny, u) {
+		return fmt.Errorf("host %s is denied", u.Host)
+	}
+	if !matchHost(c.allow, u) {
+		return fmt.Errorf("host %s is not allowed, allowed: %s", u.Host, strings.Join(c.allow, ", "))
+	}
+	return nil
+}
+
+// matchHost reports whether the host of u matches a pattern. Patterns with a
+// port match that port only, others any port.
+func matchHost(patterns []string, u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	for _, pattern := range patterns {
+		target := host
+		if _, _, err := net.SplitHostPort(pattern); err == nil {
+			target = net.JoinHostPort(host, port)
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if len(target) > len(suffix) && strings.HasSuffix(target, suffix) {
+				return true
+			}
+		} else if target == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package httptool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestMatchHost tests host patterns with and without ports
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"api.example.com", "https://api.example.com/v1", true},
+		{"api.example.com", "https://API.example.com:8443/v1", true},
+		{"api.example.com", "https://evil.com/?api.example.com", false},
+		{"api.example.com", "https://api.example.com.evil.com/", false},
+		{"*.example.com", "https://a.b.example.com/", true},
+		{"*.example.com", "https://example.com/", false},
+		{"localhost:8080", "http://localhost:8080/", true},
+		{"localhost:8080", "http://localhost:9090/", false},
+		{"example.com:443", "https://example.com/", true},
+		{"[::1]:8080", "http://[::1]:8080/", true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchHost([]string{tt.pattern}, u); got != tt.want {
+			t.Errorf("matchHost(%q, %q) = %v, want %v", tt.pattern, tt.url, got, tt.want)
+		}
+	}
+}
+
+// TestDo tests allowed and denied requests, redirects and the response cap
+func TestDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Method", r.Method)
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("Authorization"), body)
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("é", 10))
+		case "/missing":
+			http.NotFound(w, r)
+		case "/redirect":
+			http.Redirect(w, r, "http://denied.test/", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	c, err := New(&Config{
+		Allow:           []string{"127.0.0.1", "denied.test"},
+		Deny:            []string{"denied.test"},
+		Methods:         []string{"get", "post"},
+		MaxResponseSize: 9,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	result, err := c.Do(ctx, Args{Method: "post", URL: srv.URL + "/echo", Headers: map[string]string{"Authorization": "t"}, Body: "{}"})
+	if err != nil || result.Status != 200 || result.Body != "POST t {}" || result.Headers["X-Method"] != "POST" {
+		t.Errorf("Do(echo) = %+v, %v", result, err)
+	}
+
+	// The limit cuts the fifth "é" in half, which is dropped
+	result, err = c.Do(ctx, Args{URL: srv.URL + "/large"})
+	if err != nil || !result.Truncated || result.Body != "éééé" {
+		t.Errorf("Do(large) = %+v, %v", result, err)
+	}
+
+	result, err = c.Do(ctx, Args{URL: srv.URL + "/missing"})
+	if err != nil || result.Status != http.StatusNotFound {
+		t.Errorf("Do(missing) = %+v, %v, want a 404 result", result, err)
+	}
+
+	for _, args := range []Args{
+		{URL: srv.URL + "/redirect"},
+		{URL: "http://denied.test/"},
+		{URL: "http://localhost" + strings.TrimPrefix(host, "127.0.0.1") + "/echo"},
+		{URL: "file:///etc/passwd"},
+		{URL: "http://user:pass@" + host + "/echo"},
+		{Method: "DELETE", URL: srv.URL + "/echo"},
+	} {
+		if result, err := c.Do(ctx, args); err == nil {
+			t.Errorf("Do(%s %s) = %+v, want an error", args.Method, args.URL, result)
+		}
+	}
+
+	if _, err := New(&Config{}); err == nil {
+		t.Error("New() without allowed hosts succeeded")
+	}
+	if _, err := New(&Config{Allow: []string{"https://example.com"}}); err == nil {
+		t.Error("New() with a URL as host pattern succeeded")
+	}
+}
@@ -0,0 +1,120 @@
+// Package imagegen provides the generate_image tool, which generates images
+// with a DALL·E-compatible images API (/v1/images/generations)
+package imagegen
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// Generator generates images, such as an *openai_compatible.Client with an
+// image model
+type Generator interface {
+	GenerateImages(ctx context.Context, req *openai_compatible.ImageRequest) ([]openai_compatible.GeneratedImage, error)
+}
+
+// Config holds the defaults and limits of the tool
+type Config struct {
+	Size      string // When the model gives none, the provider's default when empty
+	Quality   string // When the model gives none, the provider's default when empty
+	MaxImages int    // Per call, defaults to 4
+}
+
+// Args are the arguments of the generate_image tool
+type Args struct {
+	Prompt  string `json:"prompt" jsonschema:"Detailed description of the image to generate"`
+	Size    string `json:"size,omitempty" jsonschema:"Width x height in pixels, e.g. 1024x1024"`
+	Quality string `json:"quality,omitempty" jsonschema:"Quality of the image, e.g. standard or hd"`
+	N       int    `json:"n,omitempty" jsonschema:"Number of images to generate, defaults to 1"`
+}
+
+// Image is a generated image, given by URL or saved as an artifact
+type Image struct {
+	URL           string `json:"url,omitempty"`
+	Artifact      string `json:"artifact,omitempty"` // Name of the artifact holding the image
+	Version       int64  `json:"version,omitempty"`  // Of the artifact
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// Result is the result of the generate_image tool
+type Result struct {
+	Images []Image `json:"images"`
+}
+
+// extensions are the file extensions of image types
+var extensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/webp": "webp",
+	"image/gif":  "gif",
+}
+
+// NewTool creates the "generate_image" tool backed by generator. Images
+// returned as data are saved as artifacts of the session, so the tool needs
+// an artifact service for providers that do not return URLs.
+func NewTool(generator Generator, cfg *Config) (tool.Tool, error) {
+	if generator == nil {
+		return nil, fmt.Errorf("generator is required")
+	}
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	c.MaxImages = cmp.Or(c.MaxImages, 4)
+
+	return functiontool.New(functiontool.Config{
+		Name: "generate_image",
+		Description: "Generates images from a text description and returns their URLs, or the names of the artifacts holding them. " +
+			"Describe the subject, style and composition in detail.",
+	}, func(ctx tool.Context, args Args) (Result, error) {
+		prompt := strings.TrimSpace(args.Prompt)
+		if prompt == "" {
+			return Result{}, fmt.Errorf("prompt is required")
+		}
+		n := max(args.N, 1)
+		if n > c.MaxImages {
+			return Result{}, fmt.Errorf("at most %d images can be generated at once", c.MaxImages)
+		}
+		images, err := generator.GenerateImages(ctx, &openai_compatible.ImageRequest{
+			Prompt:  prompt,
+			N:       n,
+			Size:    cmp.Or(args.Size, c.Size),
+			Quality: cmp.Or(args.Quality, c.Quality),
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		return save(ctx, images)
+	})
+}
+
+// save converts the generated images to the tool's result, saving those
+// returned as data as artifacts named after the function call
+func save(ctx tool.Context, images []openai_compatible.GeneratedImage) (Result, error) {
+	prefix := "image_" + cmp.Or(ctx.FunctionCallID(), fmt.Sprint(time.Now().UnixNano()))
+	var result Result
+	for i, image := range images {
+		out := Image{URL: image.URL, RevisedPrompt: image.RevisedPrompt}
+		if image.URL == "" {
+			if ctx.Artifacts() == nil {
+				return Result{}, fmt.Errorf("the provider returned the image as data, which needs an artifact store to be kept")
+			}
+			out.Artifact = fmt.Sprintf("%s_%d.%s", prefix, i+1, cmp.Or(extensions[image.MIMEType], "png"))
+			saved, err := ctx.Artifacts().Save(ctx, out.Artifact, genai.NewPartFromBytes(image.Data, image.MIMEType))
+			if err != nil {
+				return Result{}, fmt.Errorf("failed to save image: %w", err)
+			}
+			out.Version = saved.Version
+		}
+		result.Images = append(result.Images, out)
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/artifact"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+)
+
+// drawModel calls generate_image with the user message as the prompt, then
+// replies with the tool's result as JSON
+type drawModel struct{}
+
+func (drawModel) Name() string { return "draw" }
+
+func (drawModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		last := req.Contents[len(req.Contents)-1].Parts[0]
+		if last.FunctionResponse != nil {
+			data, _ := json.Marshal(last.FunctionResponse.Response)
+			yield(&model.LLMResponse{Content: genai.NewContentFromText(string(data), genai.RoleModel)}, nil)
+			return
+		}
+		call := &genai.Part{FunctionCall: &genai.FunctionCall{ID: "call_1", Name: "generate_image", Args: map[string]any{"prompt": last.Text, "n": 2}}}
+		yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{call}}}, nil)
+	}
+}
+
+// fakeGenerator returns an image by URL and one as data
+type fakeGenerator struct {
+	req *openai_compatible.ImageRequest
+}
+
+func (g *fakeGenerator) GenerateImages(_ context.Context, req *openai_compatible.ImageRequest) ([]openai_compatible.GeneratedImage, error) {
+	g.req = req
+	return []openai_compatible.GeneratedImage{
+		{URL: "https://img.example.com/1.png", RevisedPrompt: "A red cat"},
+		{Data: []byte("\x89PNG"), MIMEType: "image/png"},
+	}, nil
+}
+
+// TestTool tests generating images, saving those returned as data as
+// artifacts
+func TestTool(t *testing.T) {
+	generator := &fakeGenerator{}
+	generate, err := NewTool(generator, &Config{Size: "1024x1024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := llmagent.New(llmagent.Config{Name: "test", Model: drawModel{}, Tools: []tool.Tool{generate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	artifacts := artifact.InMemoryService()
+	r, err := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions, ArtifactService: artifacts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "u"})
+	var reply string
+	for event, err := range r.Run(ctx, "u", created.Session.ID(), genai.NewContentFromText("a cat", genai.RoleUser), agent.RunConfig{}) {
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if event.Content != nil && event.Content.Parts[0].Text != "" {
+			reply = event.Content.Parts[0].Text
+		}
+	}
+
+	if generator.req.Prompt != "a cat" || generator.req.N != 2 || generator.req.Size != "1024x1024" {
+		t.Errorf("request = %+v", generator.req)
+	}
+	var result Result
+	if err := json.Unmarshal([]byte(reply), &result); err != nil {
+		t.Fatalf("reply %q: %v", reply, err)
+	}
+	want := []Image{
+		{URL: "https://img.example.com/1.png", RevisedPrompt: "A red cat"},
+		{Artifact: "image_call_1_2.png"},
+	}
+	if len(result.Images) != 2 || result.Images[0] != want[0] || result.Images[1].Artifact != want[1].Artifact {
+		t.Errorf("result = %+v, want %+v", result.Images, want)
+	}
+	loaded, err := artifacts.Load(ctx, &artifact.LoadRequest{AppName: "test", UserID: "u", SessionID: created.Session.ID(), FileName: "image_call_1_2.png"})
+	if err != nil || string(loaded.Part.InlineData.Data) != "\x89PNG" {
+		t.Errorf("saved artifact = %v, %v; want the image data", loaded, err)
+	}
+}
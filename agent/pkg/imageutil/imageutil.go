@@ -0,0 +1,153 @@
+// Package imageutil prepares images before they leave the process: it strips
+// metadata, applies the EXIF orientation, converts to an accepted format and
+// downscales to size and dimension limits.
+package imageutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Decodes GIF images for conversion
+	"image/jpeg"
+	"image/png"
+)
+
+// ErrUnsupported is returned, wrapped, for images that cannot be made to fit
+// the options
+var ErrUnsupported = errors.New("unsupported image")
+
+// downscaleAttempts bounds how often an image is shrunk to fit the size limit
+const downscaleAttempts = 8
+
+// Options are the requirements of the receiving side
+type Options struct {
+	// MaxSize is the largest encoded size in bytes, 0 for no limit
+	MaxSize int
+	// MaxDimension is the longest side in pixels, 0 for no limit
+	MaxDimension int
+	// Accept reports whether a MIME type is accepted, nil accepts any
+	Accept func(mimeType string) bool
+}
+
+// Process returns an image ready to send: without metadata, upright, in an
+// accepted format and within the limits. It returns nil data when the image
+// can be sent unchanged. JPEG, PNG and GIF images can be converted; others
+// (e.g. WebP) only have their metadata stripped and must be accepted as is.
+func Process(data []byte, mimeType string, opts Options) ([]byte, string, error) {
+	accept := opts.Accept
+	if accept == nil {
+		accept = func(string) bool { return true }
+	}
+	stripped, orientation := Strip(data, mimeType)
+	current := data
+	if stripped != nil {
+		current = stripped
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if !accept(mimeType) {
+			return nil, "", fmt.Errorf("%w: %s is not accepted and cannot be converted", ErrUnsupported, mimeType)
+		}
+		if opts.MaxSize > 0 && len(current) > opts.MaxSize {
+			return nil, "", fmt.Errorf("%w: %s image of %d bytes exceeds the limit of %d and cannot be downscaled", ErrUnsupported, mimeType, len(current), opts.MaxSize)
+		}
+		return stripped, mimeType, nil
+	}
+	mimeType = "image/" + format
+	longest := max(cfg.Width, cfg.Height)
+	fits := (opts.MaxSize == 0 || len(current) <= opts.MaxSize) && (opts.MaxDimension == 0 || longest <= opts.MaxDimension)
+	if fits && accept(mimeType) && orientation <= 1 {
+		return stripped, mimeType, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %s image: %w", mimeType, err)
+	}
+	src := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	src = Orient(src, orientation)
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	scale := 1.0
+	if opts.MaxDimension > 0 && longest > opts.MaxDimension {
+		scale = float64(opts.MaxDimension) / float64(longest)
+	}
+	for range downscaleAttempts {
+		w, h := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+		resized := src
+		if w != width || h != height {
+			resized = resize(src, w, h)
+		}
+		out, outType, err := encode(resized, accept)
+		if err != nil {
+			return nil, "", err
+		}
+		if opts.MaxSize == 0 || len(out) <= opts.MaxSize {
+			return out, outType, nil
+		}
+		scale *= 0.75
+	}
+	return nil, "", fmt.Errorf("%w: could not shrink the %s image below %d bytes", ErrUnsupported, mimeType, opts.MaxSize)
+}
+
+// encode encodes opaque images as JPEG and others as PNG, falling back to the
+// other format when one is not accepted. Transparency is flattened onto white
+// for JPEG.
+func encode(img *image.RGBA, accept func(string) bool) ([]byte, string, error) {
+	var buf bytes.Buffer
+	jpegOK, pngOK := accept("image/jpeg"), accept("image/png")
+	switch {
+	case jpegOK && (img.Opaque() || !pngOK):
+		if !img.Opaque() {
+			flat := image.NewRGBA(img.Bounds())
+			draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+			draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+			img = flat
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", fmt.Errorf("failed to encode image: %w", err)
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	case pngOK:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", fmt.Errorf("failed to encode image: %w", err)
+		}
+		return buf.Bytes(), "image/png", nil
+	default:
+		return nil, "", fmt.Errorf("%w: neither JPEG nor PNG is accepted", ErrUnsupported)
+	}
+}
+
+// resize scales src to width x height, averaging the source pixels each
+// destination pixel covers
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0, y1 := y*b.Dy()/height, max((y+1)*b.Dy()/height, y*b.Dy()/height+1)
+		for x := range width {
+			x0, x1 := x*b.Dx()/width, max((x+1)*b.Dx()/width, x*b.Dx()/width+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := y*dst.Stride + x*4
+			for k := range 4 {
+				dst.Pix[i+k] = uint8(sum[k] / n)
+			}
+		}
+	}
+	return dst
+}
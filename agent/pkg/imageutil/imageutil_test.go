@@ -0,0 +1,144 @@
+package imageutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage is 4x2, red in the top left corner and white elsewhere
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	return img
+}
+
+// exifJPEG encodes the test image as a JPEG with an EXIF orientation, an
+// XMP segment and a comment
+func exifJPEG(t *testing.T, orientation uint16) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	segment := func(marker byte, payload []byte) []byte {
+		s := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+		return append(s, payload...)
+	}
+	out := []byte{0xff, 0xd8}
+	out = append(out, segment(0xe1, append([]byte("Exif\x00\x00"), tiff...))...)
+	out = append(out, segment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))...)
+	out = append(out, segment(0xfe, []byte("taken at home"))...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+// TestStrip tests removing metadata from each format without re-encoding
+func TestStrip(t *testing.T) {
+	data := exifJPEG(t, 6)
+	stripped, orientation := Strip(data, "image/jpeg")
+	if orientation != 6 || stripped == nil {
+		t.Fatalf("Strip(jpeg) orientation = %d, stripped = %v", orientation, stripped != nil)
+	}
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("xmpmeta")) || bytes.Contains(stripped, []byte("taken at home")) {
+		t.Error("Strip(jpeg) kept metadata")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+	if again, _ := Strip(stripped, "image/jpeg"); again != nil {
+		t.Error("Strip(stripped jpeg) removed something")
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	pngData := buf.Bytes()
+	text := []byte("Author\x00Jane")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	withText := append(append(append([]byte(nil), pngData[:33]...), chunk...), pngData[33:]...) // After IHDR
+	stripped, _ = Strip(withText, "image/png")
+	if !bytes.Equal(stripped, pngData) {
+		t.Error("Strip(png) did not restore the image without its text chunk")
+	}
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x08\x00\x00\x00\x03\x00\x00\x01\x00\x00EXIF\x03\x00\x00\x00MM*\x00")
+	binary.LittleEndian.PutUint32(webp[4:], uint32(len(webp)-8))
+	stripped, _ = Strip(webp, "image/webp")
+	if len(stripped) != 30 || stripped[20] != 0 || binary.LittleEndian.Uint32(stripped[4:]) != 22 {
+		t.Errorf("Strip(webp) = %q", stripped)
+	}
+}
+
+// TestOrient tests where the red corner ends up for each orientation
+func TestOrient(t *testing.T) {
+	want := map[int]image.Point{1: {0, 0}, 2: {3, 0}, 3: {3, 1}, 4: {0, 1}, 5: {0, 0}, 6: {1, 0}, 7: {1, 3}, 8: {0, 3}}
+	for orientation, corner := range want {
+		img := Orient(testImage(), orientation)
+		if got := img.RGBAAt(corner.X, corner.Y); got != (color.RGBA{R: 0xff, A: 0xff}) {
+			t.Errorf("Orient(%d) at %v = %v, want red", orientation, corner, got)
+		}
+	}
+}
+
+// TestProcess tests orientation, conversion and limits
+func TestProcess(t *testing.T) {
+	data, mimeType, err := Process(exifJPEG(t, 6), "image/jpeg", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil || mimeType != "image/jpeg" {
+		t.Fatalf("Process(rotated) = %s, %v", mimeType, err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Errorf("Process(rotated) size = %dx%d, want 2x4", b.Dx(), b.Dy())
+	}
+
+	// An upright JPEG is only stripped
+	data, _, err = Process(exifJPEG(t, 1), "image/jpeg", Options{})
+	if err != nil || data == nil || bytes.Contains(data, []byte("Exif")) {
+		t.Errorf("Process(upright) = %d bytes, %v", len(data), err)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	onlyPNG := func(mimeType string) bool { return mimeType == "image/png" }
+	if data, mimeType, err = Process(buf.Bytes(), "image/gif", Options{Accept: onlyPNG}); err != nil || mimeType != "image/png" {
+		t.Errorf("Process(gif) = %s, %v, want image/png", mimeType, err)
+	} else if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("converted GIF does not decode: %v", err)
+	}
+	if data, _, err = Process(buf.Bytes(), "image/gif", Options{}); err != nil || data != nil {
+		t.Errorf("Process(accepted gif) = %d bytes, %v, want unchanged", len(data), err)
+	}
+
+	if data, _, err = Process(buf.Bytes(), "image/gif", Options{MaxDimension: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || cfg.Width != 2 || cfg.Height != 1 {
+		t.Errorf("Process(max dimension) = %dx%d, %v", cfg.Width, cfg.Height, err)
+	}
+
+	webp := []byte("RIFF\x0c\x00\x00\x00WEBPVP8 \x00\x00\x00\x00")
+	if _, _, err := Process(webp, "image/webp", Options{Accept: onlyPNG}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Process(webp) error = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,212 @@
+package imageutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// Strip removes metadata that may identify a person or place (EXIF with GPS
+// and camera serials, XMP, IPTC, comments and text chunks) from JPEG, PNG and
+// WebP images without re-encoding them. It returns nil data when there was
+// nothing to remove, and the EXIF orientation (1 to 8, 0 when unknown) so the
+// caller can apply it.
+func Strip(data []byte, mimeType string) ([]byte, int) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data), 0
+	default:
+		return nil, 0
+	}
+}
+
+// stripJPEG drops APP1 (EXIF, XMP), APP3 to APP13 (IPTC among others), APP15
+// and comment segments. APP0 (JFIF), APP2 (ICC profile) and APP14 (Adobe
+// color transform) affect how the image looks and are kept.
+func stripJPEG(data []byte) ([]byte, int) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0
+	}
+	out := []byte{0xff, 0xd8}
+	orientation := 0
+	removed := false
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil, orientation // Corrupt, leave it to the decoder
+		}
+		marker := data[i+1]
+		if marker == 0xff { // Fill byte
+			i++
+			continue
+		}
+		if marker == 0xda { // Start of scan: the rest is image data
+			out = append(out, data[i:]...)
+			break
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return nil, orientation
+		}
+		segment := data[i:end]
+		if marker == 0xe1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(segment[10:])
+		}
+		if marker == 0xfe || marker == 0xe1 || (marker >= 0xe3 && marker <= 0xed) || marker == 0xef {
+			removed = true
+		} else {
+			out = append(out, segment...)
+		}
+		i = end
+	}
+	if !removed {
+		return nil, orientation
+	}
+	return out, orientation
+}
+
+// stripPNG drops text, EXIF and time chunks
+func stripPNG(data []byte) ([]byte, int) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, 0
+	}
+	out := []byte(signature)
+	orientation := 0
+	removed := false
+	for i := len(signature); i+12 <= len(data); {
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil, orientation
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf":
+			orientation = exifOrientation(data[i+8 : end-4])
+			removed = true
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			removed = true
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !removed {
+		return nil, orientation
+	}
+	return out, orientation
+}
+
+// stripWebP drops EXIF and XMP chunks and clears their flags in the VP8X header
+func stripWebP(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+	out := append([]byte(nil), data[:12]...)
+	removed := false
+	for i := 12; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) || end < i {
+			return nil
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			removed = true
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !removed {
+		return nil
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// exifOrientation reads the orientation tag of the first IFD of TIFF-encoded
+// EXIF data, 0 when it is missing or invalid
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := range count {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// Orient returns src turned upright according to an EXIF orientation
+func Orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Turned counterclockwise, rotate clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Turned clockwise, rotate counterclockwise
+				sx, sy = w-1-y, x
+			}
+			s, d := src.PixOffset(sx+src.Rect.Min.X, sy+src.Rect.Min.Y), dst.PixOffset(x, y)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,96 @@
+package instruction
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/util/instructionutil"
+)
+
+// Provider computes additional system context for a turn, e.g. the current
+// time, facts about the user or retrieved memories. An empty result adds nothing.
+type Provider func(ctx agent.ReadonlyContext) (string, error)
+
+// Section is a titled piece of per-turn context
+type Section struct {
+	Title    string // Optional, rendered as a "## Title" heading
+	Provider Provider
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Provider{}
+)
+
+// Register makes a Go callback available to the config by name. Registering
+// a name twice replaces the earlier provider.
+func Register(name string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[name] = p
+}
+
+// Lookup returns the provider registered under name
+func Lookup(name string) (Provider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Composer merges a static base instruction with per-turn sections. Sections
+// are appended in order so the same inputs always produce the same prompt.
+type Composer struct {
+	base     string
+	sections []Section
+	logger   *slog.Logger
+}
+
+// NewComposer creates a composer for base followed by sections
+func NewComposer(base string, sections ...Section) *Composer {
+	return &Composer{
+		base:     base,
+		sections: sections,
+		logger:   logging.Component("instruction"),
+	}
+}
+
+// Instruction computes the instruction for a turn. It matches
+// llmagent.InstructionProvider. Since ADK skips {state} placeholder
+// injection for instruction providers, the base instruction is injected here.
+// A failing section is logged and skipped rather than failing the turn.
+func (c *Composer) Instruction(ctx agent.ReadonlyContext) (string, error) {
+	base := c.base
+	if strings.Contains(base, "{") {
+		injected, err := instructionutil.InjectSessionState(ctx, base)
+		if err != nil {
+			return "", fmt.Errorf("failed to inject session state: %w", err)
+		}
+		base = injected
+	}
+
+	var parts []string
+	if strings.TrimSpace(base) != "" {
+		parts = append(parts, base)
+	}
+	for _, s := range c.sections {
+		text, err := s.Provider(ctx)
+		if err != nil {
+			c.logger.Warn("Instruction provider failed, skipping", "section", s.Title, "error", err)
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if s.Title != "" {
+			text = "## " + s.Title + "\n" + text
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
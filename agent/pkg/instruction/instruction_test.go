@@ -0,0 +1,115 @@
+package instruction
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"maps"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// fakeContext is a minimal agent.ReadonlyContext for tests
+type fakeContext struct {
+	context.Context
+	state fakeState
+}
+
+func (c *fakeContext) UserContent() *genai.Content          { return nil }
+func (c *fakeContext) InvocationID() string                 { return "inv" }
+func (c *fakeContext) AgentName() string                    { return "yanshu_agent" }
+func (c *fakeContext) ReadonlyState() session.ReadonlyState { return c.state }
+func (c *fakeContext) UserID() string                       { return "alice" }
+func (c *fakeContext) AppName() string                      { return "app" }
+func (c *fakeContext) SessionID() string                    { return "s1" }
+func (c *fakeContext) Branch() string                       { return "" }
+
+type fakeState map[string]any
+
+func (s fakeState) Get(key string) (any, error) {
+	if v, ok := s[key]; ok {
+		return v, nil
+	}
+	return nil, session.ErrStateKeyNotExist
+}
+
+func (s fakeState) All() iter.Seq2[string, any] { return maps.All(s) }
+
+// TestComposer tests that sections are merged in order and failing or empty sections are skipped
+func TestComposer(t *testing.T) {
+	user := User()
+	tmpl, err := Template("Language: {{index .State \"user:language\"}}")
+	if err != nil {
+		t.Fatalf("Template() error = %v", err)
+	}
+	failing := func(_ agent.ReadonlyContext) (string, error) { return "", errors.New("boom") }
+	empty := func(_ agent.ReadonlyContext) (string, error) { return "  ", nil }
+
+	c := NewComposer("You are helpful.",
+		Section{Title: "User", Provider: user},
+		Section{Provider: failing},
+		Section{Provider: empty},
+		Section{Provider: tmpl},
+	)
+	ctx := &fakeContext{Context: context.Background(), state: fakeState{"user:language": "zh", "user:city": "Beijing", "draft": "x"}}
+
+	got, err := c.Instruction(ctx)
+	if err != nil {
+		t.Fatalf("Instruction() error = %v", err)
+	}
+	want := "You are helpful.\n\n## User\nUser ID: alice\ncity: Beijing\nlanguage: zh\n\nLanguage: zh"
+	if got != want {
+		t.Errorf("Instruction() = %q, want %q", got, want)
+	}
+}
+
+// TestFromSpec tests config validation of sections
+func TestFromSpec(t *testing.T) {
+	Register("memories", func(agent.ReadonlyContext) (string, error) { return "none", nil })
+
+	tests := []struct {
+		name    string
+		spec    Spec
+		wantErr bool
+	}{
+		{"time", Spec{Provider: "time", Timezone: "Asia/Shanghai"}, false},
+		{"bad timezone", Spec{Provider: "time", Timezone: "Mars/Base"}, true},
+		{"registered", Spec{Provider: "memories"}, false},
+		{"unknown", Spec{Provider: "nope"}, true},
+		{"both", Spec{Provider: "time", Template: "x"}, true},
+		{"neither", Spec{Title: "empty"}, true},
+		{"bad template", Spec{Template: "{{"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromSpec(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("FromSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestAppendSystem tests appending to the system instruction without changing the original config
+func TestAppendSystem(t *testing.T) {
+	shared := &genai.GenerateContentConfig{SystemInstruction: genai.NewContentFromText("Be brief.", genai.RoleUser)}
+	req := &model.LLMRequest{Config: shared}
+	AppendSystem(req, "Answer in French.")
+
+	parts := req.Config.SystemInstruction.Parts
+	if len(parts) != 2 || parts[0].Text != "Be brief." || parts[1].Text != "Answer in French." {
+		t.Errorf("system instruction = %+v", req.Config.SystemInstruction)
+	}
+	if req.Config == shared || len(shared.SystemInstruction.Parts) != 1 {
+		t.Errorf("the original config was changed: %+v", shared.SystemInstruction)
+	}
+
+	req = &model.LLMRequest{}
+	AppendSystem(req, "Be brief.")
+	if req.Config == nil || len(req.Config.SystemInstruction.Parts) != 1 || req.Config.SystemInstruction.Role != genai.RoleUser {
+		t.Errorf("system instruction without a config = %+v", req.Config)
+	}
+}
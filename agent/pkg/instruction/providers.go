@@ -0,0 +1,150 @@
+package instruction
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/session"
+)
+
+// Spec describes a section in configuration terms
+type Spec struct {
+	Title    string
+	Provider string // Built-in (time, environment, user) or registered provider name
+	Template string // text/template rendered per turn, used when Provider is empty
+	Timezone string // For the time provider, defaults to the local timezone
+}
+
+// FromSpec builds a section from its configuration
+func FromSpec(spec Spec) (Section, error) {
+	section := Section{Title: spec.Title}
+	switch {
+	case spec.Provider != "" && spec.Template != "":
+		return Section{}, fmt.Errorf("section %q sets both provider and template", spec.Title)
+	case spec.Template != "":
+		p, err := Template(spec.Template)
+		if err != nil {
+			return Section{}, err
+		}
+		section.Provider = p
+	case spec.Provider == "time":
+		loc := time.Local
+		if spec.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(spec.Timezone); err != nil {
+				return Section{}, fmt.Errorf("failed to load timezone %q: %w", spec.Timezone, err)
+			}
+		}
+		section.Provider = CurrentTime(loc)
+	case spec.Provider == "environment":
+		section.Provider = Environment()
+	case spec.Provider == "user":
+		section.Provider = User()
+	case spec.Provider != "":
+		p, ok := Lookup(spec.Provider)
+		if !ok {
+			return Section{}, fmt.Errorf("unknown instruction provider %q", spec.Provider)
+		}
+		section.Provider = p
+	default:
+		return Section{}, fmt.Errorf("section %q needs a provider or a template", spec.Title)
+	}
+	return section, nil
+}
+
+// CurrentTime reports the current time in loc, to the minute
+func CurrentTime(loc *time.Location) Provider {
+	return func(agent.ReadonlyContext) (string, error) {
+		now := time.Now().In(loc)
+		return fmt.Sprintf("Current time: %s (%s, %s)", now.Format("2006-01-02 15:04 MST"), now.Weekday(), loc), nil
+	}
+}
+
+// Environment reports facts about the host the agent runs on. They are
+// computed once since they do not change between turns.
+func Environment() Provider {
+	host, _ := os.Hostname()
+	text := fmt.Sprintf("Operating system: %s/%s", runtime.GOOS, runtime.GOARCH)
+	if host != "" {
+		text += "\nHostname: " + host
+	}
+	return func(agent.ReadonlyContext) (string, error) {
+		return text, nil
+	}
+}
+
+// User reports the user id and the user-scoped session state ("user:" keys),
+// sorted by key
+func User() Provider {
+	return func(ctx agent.ReadonlyContext) (string, error) {
+		lines := []string{"User ID: " + ctx.UserID()}
+		state := userState(ctx)
+		for _, key := range slices.Sorted(maps.Keys(state)) {
+			lines = append(lines, fmt.Sprintf("%s: %v", strings.TrimPrefix(key, session.KeyPrefixUser), state[key]))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+}
+
+// templateData is the data available to instruction templates
+type templateData struct {
+	Now       time.Time
+	UserID    string
+	SessionID string
+	AppName   string
+	AgentName string
+	State     map[string]any
+}
+
+// Template renders a text/template per turn. The template sees .Now, .UserID,
+// .SessionID, .AppName, .AgentName and .State (the session state).
+func Template(text string) (Provider, error) {
+	tmpl, err := template.New("instruction").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse instruction template: %w", err)
+	}
+	return func(ctx agent.ReadonlyContext) (string, error) {
+		data := templateData{
+			Now:       time.Now(),
+			UserID:    ctx.UserID(),
+			SessionID: ctx.SessionID(),
+			AppName:   ctx.AppName(),
+			AgentName: ctx.AgentName(),
+			State:     map[string]any{},
+		}
+		if state := ctx.ReadonlyState(); state != nil {
+			for k, v := range state.All() {
+				data.State[k] = v
+			}
+		}
+
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("failed to render instruction template: %w", err)
+		}
+		return b.String(), nil
+	}, nil
+}
+
+// userState returns the user-scoped entries of the session state
+func userState(ctx agent.ReadonlyContext) map[string]any {
+	out := map[string]any{}
+	state := ctx.ReadonlyState()
+	if state == nil {
+		return out
+	}
+	for k, v := range state.All() {
+		if strings.HasPrefix(k, session.KeyPrefixUser) {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+package instruction
+
+import (
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// AppendSystem adds text as a new part of the system instruction of req. The
+// config and system instruction are copied first, since ADK may share them
+// with the agent, so the caller can change req.Config freely afterwards.
+func AppendSystem(req *model.LLMRequest, text string) {
+	var config genai.GenerateContentConfig
+	if req.Config != nil {
+		config = *req.Config
+	}
+	system := &genai.Content{Role: genai.RoleUser}
+	if config.SystemInstruction != nil {
+		system.Role = config.SystemInstruction.Role
+		system.Parts = append(system.Parts, config.SystemInstruction.Parts...)
+	}
+	system.Parts = append(system.Parts, genai.NewPartFromText(text))
+	config.SystemInstruction = system
+	req.Config = &config
+}
@@ -0,0 +1,76 @@
+package limits
+
+import (
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+)
+
+// budget is the room left under a byte and token limit; negative values are unlimited
+type budget struct {
+	bytes  int
+	tokens int
+	tok    tokenizer.Tokenizer
+}
+
+func newBudget(maxBytes int64, maxTokens int, tok tokenizer.Tokenizer) *budget {
+	b := &budget{bytes: -1, tokens: -1, tok: tok}
+	if maxBytes > 0 {
+		b.bytes = int(maxBytes)
+	}
+	if maxTokens > 0 {
+		b.tokens = maxTokens
+	}
+	return b
+}
+
+// allows reports whether n bytes counting as tokens fit in the budget
+func (b *budget) allows(n, tokens int) bool {
+	return (b.bytes < 0 || n <= b.bytes) && (b.tokens < 0 || tokens <= b.tokens)
+}
+
+// count returns the token count of s, skipping the work when tokens are unlimited
+func (b *budget) count(s string) int {
+	if b.tokens < 0 {
+		return 0
+	}
+	return b.tok.Count(s)
+}
+
+// consume subtracts n bytes and tokens from the budget
+func (b *budget) consume(n, tokens int) {
+	if b.bytes >= 0 {
+		b.bytes -= n
+	}
+	if b.tokens >= 0 {
+		b.tokens -= tokens
+	}
+}
+
+// take consumes s and reports whether all of it fit. When it does not fit, it
+// returns the longest prefix of s that does, cut on a rune boundary, and the
+// budget is exhausted.
+func (b *budget) take(s string) (string, bool) {
+	if tokens := b.count(s); b.allows(len(s), tokens) {
+		b.consume(len(s), tokens)
+		return s, true
+	}
+
+	// Binary search over rune boundaries for the longest fitting prefix
+	bounds := make([]int, 0, len(s))
+	for i := range s {
+		bounds = append(bounds, i)
+	}
+	lo, hi := 0, len(bounds)-1 // bounds[lo] always fits (the empty prefix)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if p := s[:bounds[mid]]; b.allows(len(p), b.count(p)) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	b.bytes, b.tokens = 0, 0
+	return strings.Clone(s[:bounds[lo]]), false
+}
@@ -0,0 +1,299 @@
+package limits
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// Action is what happens to user input beyond the limits
+type Action string
+
+const (
+	ActionTruncate Action = "truncate"
+	ActionReject   Action = "reject"
+)
+
+// Default messages, used when the config leaves them empty
+const (
+	DefaultTruncationMessage         = "[The rest of this message was removed because it exceeded the input size limit.]"
+	DefaultRejectionMessage          = "Your message is too long for me to process. Please shorten it and try again."
+	DefaultResponseTruncationMessage = "[Response truncated: it exceeded the response size limit.]"
+)
+
+// Config holds the size limits. Zero values disable a limit.
+type Config struct {
+	MaxInputBytes     int64
+	MaxInputTokens    int
+	InputAction       Action
+	TruncationMessage string
+	RejectionMessage  string
+
+	MaxResponseBytes          int64
+	MaxResponseTokens         int
+	ResponseTruncationMessage string
+
+	// Tokenizer counts tokens for the token limits, defaults to the one selected for the model
+	Tokenizer tokenizer.Tokenizer
+	Logger    *slog.Logger
+}
+
+// Model wraps a model.LLM and enforces soft size limits on user input and
+// model responses. Oversized input is truncated with an explanation appended,
+// or rejected with a friendly reply that never reaches the provider.
+// Oversized responses are cut off and the rest of the stream is abandoned.
+type Model struct {
+	llm    model.LLM
+	cfg    Config
+	logger *slog.Logger
+}
+
+// NewModel wraps llm with the limits in cfg
+func NewModel(llm model.LLM, cfg *Config) (*Model, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+
+	c := *cfg
+	c.InputAction = cmp.Or(c.InputAction, ActionTruncate)
+	if c.InputAction != ActionTruncate && c.InputAction != ActionReject {
+		return nil, fmt.Errorf("invalid input action %q (must be %q or %q)", c.InputAction, ActionTruncate, ActionReject)
+	}
+	c.TruncationMessage = cmp.Or(c.TruncationMessage, DefaultTruncationMessage)
+	c.RejectionMessage = cmp.Or(c.RejectionMessage, DefaultRejectionMessage)
+	c.ResponseTruncationMessage = cmp.Or(c.ResponseTruncationMessage, DefaultResponseTruncationMessage)
+	if c.Tokenizer == nil {
+		c.Tokenizer = tokenizer.ForModel(llm.Name())
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("limits")
+	}
+
+	return &Model{
+		llm:    llm,
+		cfg:    c,
+		logger: logger,
+	}, nil
+}
+
+// inputLimited reports whether any input limit is set
+func (m *Model) inputLimited() bool {
+	return m.cfg.MaxInputBytes > 0 || m.cfg.MaxInputTokens > 0
+}
+
+// responseLimited reports whether any response limit is set
+func (m *Model) responseLimited() bool {
+	return m.cfg.MaxResponseBytes > 0 || m.cfg.MaxResponseTokens > 0
+}
+
+// inputBudget returns a fresh budget for one user message
+func (m *Model) inputBudget() *budget {
+	return newBudget(m.cfg.MaxInputBytes, m.cfg.MaxInputTokens, m.cfg.Tokenizer)
+}
+
+// responseBudget returns a fresh budget for one model response
+func (m *Model) responseBudget() *budget {
+	return newBudget(m.cfg.MaxResponseBytes, m.cfg.MaxResponseTokens, m.cfg.Tokenizer)
+}
+
+// Name implements model.LLM
+func (m *Model) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *Model) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if m.inputLimited() {
+			limited, rejected := m.limitInput(req)
+			if rejected {
+				yield(&model.LLMResponse{
+					Content:      genai.NewContentFromText(m.cfg.RejectionMessage, genai.RoleModel),
+					TurnComplete: true,
+				}, nil)
+				return
+			}
+			req = limited
+		}
+
+		if !m.responseLimited() {
+			for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+				if !yield(resp, err) {
+					return
+				}
+			}
+			return
+		}
+
+		// Partial text counts towards the limit; the final response repeats it
+		partials := m.responseBudget()
+		var delivered strings.Builder
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			if err != nil || resp == nil || resp.Content == nil {
+				if !yield(resp, err) {
+					return
+				}
+				continue
+			}
+
+			b := partials
+			if !resp.Partial {
+				b = m.responseBudget()
+			}
+			cut, fits := truncateContent(resp.Content, b)
+			if fits {
+				if !resp.Partial {
+					yield(resp, nil)
+					return
+				}
+				writeText(&delivered, resp.Content)
+				if !yield(resp, nil) {
+					return
+				}
+				continue
+			}
+
+			// Limit exceeded: cut the text, finish the turn and stop reading
+			m.logger.Warn("Response exceeded size limit, truncating",
+				"max_bytes", m.cfg.MaxResponseBytes,
+				"max_tokens", m.cfg.MaxResponseTokens,
+				"tokenizer", m.cfg.Tokenizer.Name(),
+				"model", m.llm.Name(),
+			)
+			if !resp.Partial {
+				yield(m.truncatedFinal(resp, cut), nil)
+				return
+			}
+
+			partial := *resp
+			partial.Content = cut
+			if !yield(&partial, nil) {
+				return
+			}
+			writeText(&delivered, cut)
+			yield(m.truncatedFinal(resp, genai.NewContentFromText(delivered.String(), genai.RoleModel)), nil)
+			return
+		}
+	}
+}
+
+// truncatedFinal builds the turn-complete response carrying content cut at the limit
+func (m *Model) truncatedFinal(resp *model.LLMResponse, content *genai.Content) *model.LLMResponse {
+	final := *resp
+	final.Partial = false
+	final.TurnComplete = true
+	final.FinishReason = genai.FinishReasonMaxTokens
+	final.Content = content
+	final.Content.Parts = append(final.Content.Parts, genai.NewPartFromText("\n\n"+m.cfg.ResponseTruncationMessage))
+	return &final
+}
+
+// limitInput returns req with oversized user messages truncated. In reject
+// mode it reports rejected when the latest message is oversized; older
+// oversized messages (rejected on an earlier turn) are truncated so they
+// cannot fail later requests.
+func (m *Model) limitInput(req *model.LLMRequest) (*model.LLMRequest, bool) {
+	var contents []*genai.Content
+	for i, content := range req.Contents {
+		if content == nil || (content.Role != genai.RoleUser && content.Role != "") {
+			continue
+		}
+		truncated, fits := truncateContent(content, m.inputBudget())
+		if fits {
+			continue
+		}
+
+		if m.cfg.InputAction == ActionReject && i == len(req.Contents)-1 {
+			m.logger.Warn("Rejected oversized input",
+				"size_bytes", textSize(content),
+				"max_bytes", m.cfg.MaxInputBytes,
+				"max_tokens", m.cfg.MaxInputTokens,
+				"tokenizer", m.cfg.Tokenizer.Name(),
+			)
+			return nil, true
+		}
+
+		if contents == nil {
+			// Copy on write: contents belong to the session history
+			contents = append([]*genai.Content(nil), req.Contents...)
+		}
+		truncated.Parts = append(truncated.Parts, genai.NewPartFromText("\n\n"+m.cfg.TruncationMessage))
+		contents[i] = truncated
+
+		m.logger.Warn("Truncated oversized input",
+			"size_bytes", textSize(content),
+			"max_bytes", m.cfg.MaxInputBytes,
+			"max_tokens", m.cfg.MaxInputTokens,
+			"tokenizer", m.cfg.Tokenizer.Name(),
+		)
+	}
+
+	if contents == nil {
+		return req, false
+	}
+	limited := *req
+	limited.Contents = contents
+	return &limited, false
+}
+
+// textSize returns the total size of the text parts of content in bytes
+func textSize(content *genai.Content) int {
+	size := 0
+	for _, part := range content.Parts {
+		if part != nil {
+			size += len(part.Text)
+		}
+	}
+	return size
+}
+
+// writeText appends the text parts of content to b
+func writeText(b *strings.Builder, content *genai.Content) {
+	for _, part := range content.Parts {
+		if part != nil {
+			b.WriteString(part.Text)
+		}
+	}
+}
+
+// truncateContent returns a copy of content whose text parts fit in b, cut on
+// a rune boundary, and reports whether all of the text fit. Non-text parts are kept.
+func truncateContent(content *genai.Content, b *budget) (*genai.Content, bool) {
+	out := &genai.Content{Role: content.Role, Parts: make([]*genai.Part, 0, len(content.Parts))}
+	fits := true
+	for _, part := range content.Parts {
+		if part == nil {
+			continue
+		}
+		if part.Text == "" {
+			out.Parts = append(out.Parts, part)
+			continue
+		}
+
+		text, ok := b.take(part.Text)
+		if ok {
+			out.Parts = append(out.Parts, part)
+			continue
+		}
+		fits = false
+		if text != "" {
+			p := *part
+			p.Text = text
+			out.Parts = append(out.Parts, &p)
+		}
+	}
+	return out, fits
+}
@@ -0,0 +1,145 @@
+package limits
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// fakeModel records the last request and streams fixed text chunks
+type fakeModel struct {
+	chunks []string
+	req    *model.LLMRequest
+	calls  int
+}
+
+func (m *fakeModel) Name() string { return "fake" }
+
+func (m *fakeModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	m.req = req
+	m.calls++
+	return func(yield func(*model.LLMResponse, error) bool) {
+		for _, c := range m.chunks {
+			if !yield(&model.LLMResponse{Content: genai.NewContentFromText(c, genai.RoleModel), Partial: true}, nil) {
+				return
+			}
+		}
+		yield(&model.LLMResponse{
+			Content:      genai.NewContentFromText(strings.Join(m.chunks, ""), genai.RoleModel),
+			TurnComplete: true,
+		}, nil)
+	}
+}
+
+// collect runs the model and returns the partial text and the final response
+func collect(t *testing.T, m model.LLM, req *model.LLMRequest) (string, *model.LLMResponse) {
+	t.Helper()
+	var partial strings.Builder
+	var final *model.LLMResponse
+	for resp, err := range m.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		if resp.Partial {
+			partial.WriteString(resp.Content.Parts[0].Text)
+		} else {
+			final = resp
+		}
+	}
+	return partial.String(), final
+}
+
+func userRequest(texts ...string) *model.LLMRequest {
+	req := &model.LLMRequest{}
+	for _, text := range texts {
+		req.Contents = append(req.Contents, genai.NewContentFromText(text, genai.RoleUser))
+	}
+	return req
+}
+
+// TestInputTruncate tests that oversized input is cut and the session contents stay untouched
+func TestInputTruncate(t *testing.T) {
+	fake := &fakeModel{chunks: []string{"ok"}}
+	m, err := NewModel(fake, &Config{MaxInputBytes: 5, TruncationMessage: "[cut]"})
+	if err != nil {
+		t.Fatalf("NewModel() error = %v", err)
+	}
+
+	req := userRequest("héllo world")
+	collect(t, m, req)
+
+	parts := fake.req.Contents[0].Parts
+	if len(parts) != 2 || parts[0].Text != "héll" || !strings.HasSuffix(parts[1].Text, "[cut]") {
+		t.Errorf("unexpected truncated parts %q, %q", parts[0].Text, parts[1].Text)
+	}
+	if req.Contents[0].Parts[0].Text != "héllo world" {
+		t.Errorf("original request was modified: %q", req.Contents[0].Parts[0].Text)
+	}
+}
+
+// TestInputReject tests that an oversized latest message is answered without calling the model
+func TestInputReject(t *testing.T) {
+	fake := &fakeModel{chunks: []string{"ok"}}
+	m, err := NewModel(fake, &Config{MaxInputTokens: 2, InputAction: ActionReject, RejectionMessage: "too long"})
+	if err != nil {
+		t.Fatalf("NewModel() error = %v", err)
+	}
+
+	_, final := collect(t, m, userRequest("this message is too long"))
+	if fake.calls != 0 {
+		t.Errorf("model was called %d times", fake.calls)
+	}
+	if final == nil || final.Content.Parts[0].Text != "too long" {
+		t.Errorf("unexpected rejection %+v", final)
+	}
+
+	// An earlier oversized message no longer blocks the conversation
+	collect(t, m, userRequest("this message is too long", "hi"))
+	if fake.calls != 1 || len(fake.req.Contents[0].Parts[0].Text) > 8 {
+		t.Errorf("expected earlier message to be truncated, got %q", fake.req.Contents[0].Parts[0].Text)
+	}
+}
+
+// TestResponseLimit tests that a streamed response is cut at the limit with a final notice
+func TestResponseLimit(t *testing.T) {
+	fake := &fakeModel{chunks: []string{"abc", "def", "ghi"}}
+	m, err := NewModel(fake, &Config{MaxResponseBytes: 5, ResponseTruncationMessage: "[more]"})
+	if err != nil {
+		t.Fatalf("NewModel() error = %v", err)
+	}
+
+	partial, final := collect(t, m, userRequest("hi"))
+	if partial != "abcde" {
+		t.Errorf("partial text = %q, want %q", partial, "abcde")
+	}
+	if final == nil || final.FinishReason != genai.FinishReasonMaxTokens {
+		t.Fatalf("unexpected final response %+v", final)
+	}
+	var text strings.Builder
+	for _, p := range final.Content.Parts {
+		text.WriteString(p.Text)
+	}
+	if text.String() != "abcde\n\n[more]" {
+		t.Errorf("final text = %q", text.String())
+	}
+}
+
+// TestInputTokenLimitCJK tests that token limits use the tokenizer's CJK ratio
+func TestInputTokenLimitCJK(t *testing.T) {
+	fake := &fakeModel{chunks: []string{"ok"}}
+	m, err := NewModel(fake, &Config{MaxInputTokens: 3, Tokenizer: tokenizer.DeepSeek})
+	if err != nil {
+		t.Fatalf("NewModel() error = %v", err)
+	}
+
+	// 0.6 tokens per character: five characters fit in 3 tokens
+	collect(t, m, userRequest("你好世界你好世界"))
+	if got := fake.req.Contents[0].Parts[0].Text; got != "你好世界你" {
+		t.Errorf("truncated text = %q, want %q", got, "你好世界你")
+	}
+}
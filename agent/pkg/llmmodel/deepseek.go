@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"iter"
-	"time"
 
 	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
 	"google.golang.org/adk/model"
@@ -18,9 +17,10 @@ type DeepSeekModel struct {
 // Config holds configuration for DeepSeek model
 type Config struct {
 	APIKey    string
-	BaseURL   string        // Optional, defaults to https://api.deepseek.com
-	ModelName string        // Optional, defaults to deepseek-chat
-	Timeout   time.Duration // Optional, defaults to 5 minutes
+	BaseURL   string // Optional, defaults to https://api.deepseek.com
+	ModelName string // Optional, defaults to deepseek-chat
+
+	ClientOptions
 }
 
 // NewModel creates a new DeepSeek model instance
@@ -42,12 +42,7 @@ func NewModel(ctx context.Context, cfg *Config) (model.LLM, error) {
 		modelName = "deepseek-chat"
 	}
 
-	client, err := openai_compatible.NewClient(&openai_compatible.ClientConfig{
-		APIKey:    cfg.APIKey,
-		BaseURL:   baseURL,
-		ModelName: modelName,
-		Timeout:   cfg.Timeout,
-	})
+	client, err := openai_compatible.NewClient(cfg.clientConfig(cfg.APIKey, baseURL, modelName, true, []string{}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
@@ -66,3 +61,8 @@ func (m *DeepSeekModel) Name() string {
 func (m *DeepSeekModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
 	return m.client.GenerateContent(ctx, req, stream)
 }
+
+// ListModels returns the models the provider serves
+func (m *DeepSeekModel) ListModels(ctx context.Context) ([]string, error) {
+	return m.client.ListModels(ctx)
+}
@@ -0,0 +1,51 @@
+package llmmodel
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+)
+
+// FailoverModel sends requests to a fallback model while the circuit of the
+// primary model's endpoint is open, rather than failing them
+type FailoverModel struct {
+	llm      model.LLM
+	fallback model.LLM
+	logger   *slog.Logger
+}
+
+// NewFailoverModel wraps llm with failover to fallback
+func NewFailoverModel(llm, fallback model.LLM) *FailoverModel {
+	return &FailoverModel{llm: llm, fallback: fallback, logger: logging.Component("failover")}
+}
+
+// Name implements model.LLM
+func (m *FailoverModel) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *FailoverModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			// An open circuit fails before sending anything
+			if errors.Is(err, openai_compatible.ErrCircuitOpen) {
+				m.logger.WarnContext(ctx, "Circuit open, failing over", "model", m.llm.Name(), "fallback", m.fallback.Name(), "error", err)
+				for resp, err := range m.fallback.GenerateContent(ctx, req, stream) {
+					if !yield(resp, err) {
+						return
+					}
+				}
+				return
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package llmmodel
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// staticModel answers with its name, or fails with err
+type staticModel struct {
+	name string
+	err  error
+}
+
+func (m *staticModel) Name() string { return m.name }
+
+func (m *staticModel) GenerateContent(context.Context, *model.LLMRequest, bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if m.err != nil {
+			yield(nil, m.err)
+			return
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(m.name, genai.RoleModel)}, nil)
+	}
+}
+
+// TestFailoverModel tests that only an open circuit fails over
+func TestFailoverModel(t *testing.T) {
+	server := &openai_compatible.ServerError{APIError: &openai_compatible.APIError{StatusCode: 502}}
+	tests := []struct {
+		name    string
+		err     error
+		want    string
+		wantErr error
+	}{
+		{"healthy", nil, "primary", nil},
+		{"circuit open", &openai_compatible.CircuitOpenError{Endpoint: "https://api.example.com"}, "fallback", nil},
+		{"server error", server, "", server},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewFailoverModel(&staticModel{name: "primary", err: tt.err}, &staticModel{name: "fallback"})
+			for resp, err := range m.GenerateContent(context.Background(), &model.LLMRequest{}, false) {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GenerateContent() error = %v, want %v", err, tt.wantErr)
+				}
+				if err == nil && resp.Content.Parts[0].Text != tt.want {
+					t.Errorf("answered by %s, want %s", resp.Content.Parts[0].Text, tt.want)
+				}
+			}
+		})
+	}
+}
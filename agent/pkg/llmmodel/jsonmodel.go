@@ -0,0 +1,150 @@
+package llmmodel
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// JSONConfig holds the post-processing of JSON responses
+type JSONConfig struct {
+	// MaxRetries is the number of times an invalid response is sent back to
+	// the model, defaults to 2
+	MaxRetries int
+}
+
+// JSONModel post-processes the responses to requests asking for JSON (a
+// JSON response MIME type or a response schema): their JSON is extracted
+// from fenced blocks and repaired, then validated against the response
+// schema. Invalid responses are sent back to the model with the errors,
+// and when retries run out the request fails with an *InvalidJSONError
+// wrapping them. Partial responses to these requests are held back, since
+// only the final one can be repaired. Other requests pass through.
+type JSONModel struct {
+	llm        model.LLM
+	maxRetries int
+	logger     *slog.Logger
+}
+
+// NewJSONModel wraps llm with the post-processing of JSON responses
+func NewJSONModel(llm model.LLM, cfg *JSONConfig) (*JSONModel, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		cfg = &JSONConfig{}
+	}
+	if cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("max retries must not be negative, got %d", cfg.MaxRetries)
+	}
+	return &JSONModel{
+		llm:        llm,
+		maxRetries: cmp.Or(cfg.MaxRetries, 2),
+		logger:     logging.Component("json"),
+	}, nil
+}
+
+// Name implements model.LLM
+func (m *JSONModel) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *JSONModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	if req.Config == nil || (req.Config.ResponseMIMEType != "application/json" && req.Config.ResponseJsonSchema == nil && req.Config.ResponseSchema == nil) {
+		return m.llm.GenerateContent(ctx, req, stream)
+	}
+	var schema any
+	switch {
+	case req.Config.ResponseJsonSchema != nil:
+		schema = req.Config.ResponseJsonSchema
+	case req.Config.ResponseSchema != nil:
+		schema = req.Config.ResponseSchema
+	}
+
+	return func(yield func(*model.LLMResponse, error) bool) {
+		current := req
+		for attempt := 1; ; attempt++ {
+			var final *model.LLMResponse
+			for resp, err := range m.llm.GenerateContent(ctx, current, stream) {
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if resp != nil && !resp.Partial {
+					final = resp
+				}
+			}
+			if final == nil {
+				return
+			}
+			text, ok := jsonText(final)
+			if !ok {
+				yield(final, nil)
+				return
+			}
+			repaired, err := parseJSON(text, schema)
+			if err == nil {
+				yield(withText(final, repaired), nil)
+				return
+			}
+			if attempt > m.maxRetries {
+				m.logger.WarnContext(ctx, "Invalid JSON response", "model", m.llm.Name(), "attempts", attempt, "error", err)
+				yield(nil, &InvalidJSONError{Attempts: attempt, Text: text, Err: err})
+				return
+			}
+			m.logger.InfoContext(ctx, "Re-prompting invalid JSON response", "model", m.llm.Name(), "attempt", attempt, "error", err)
+			retry := *current
+			retry.Contents = append(slices.Clone(current.Contents),
+				genai.NewContentFromText(text, genai.RoleModel),
+				genai.NewContentFromText(fmt.Sprintf(repairPrompt, err), genai.RoleUser))
+			current = &retry
+		}
+	}
+}
+
+// jsonText returns the text of a final response, false when it calls tools
+// or failed, which leaves nothing to repair
+func jsonText(resp *model.LLMResponse) (string, bool) {
+	if resp.ErrorCode != "" {
+		return "", false
+	}
+	var text string
+	if resp.Content != nil {
+		for _, part := range resp.Content.Parts {
+			if part == nil || part.Thought {
+				continue
+			}
+			if part.FunctionCall != nil {
+				return "", false
+			}
+			text += part.Text
+		}
+	}
+	return text, true
+}
+
+// withText returns resp with its text parts replaced by text, keeping its
+// thoughts
+func withText(resp *model.LLMResponse, text string) *model.LLMResponse {
+	out := *resp
+	content := &genai.Content{Role: genai.RoleModel}
+	if resp.Content != nil {
+		content.Role = resp.Content.Role
+		for _, part := range resp.Content.Parts {
+			if part != nil && part.Thought {
+				content.Parts = append(content.Parts, part)
+			}
+		}
+	}
+	content.Parts = append(content.Parts, genai.NewPartFromText(text))
+	out.Content = content
+	return &out
+}
@@ -0,0 +1,357 @@
+package llmmodel
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// fencedJSON matches a fenced code block, optionally tagged json
+var fencedJSON = regexp.MustCompile("(?s)```(?:json|JSON)?[ \t]*\n(.*?)```")
+
+// ExtractJSON returns the JSON of a model response: the content of its first
+// fenced code block, or the text from its first brace or bracket to its
+// last, or the trimmed text when it has neither
+func ExtractJSON(text string) string {
+	if m := fencedJSON.FindStringSubmatch(text); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	text = strings.TrimSpace(text)
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+	end := strings.LastIndexAny(text, "}]")
+	if end < start {
+		return text[start:]
+	}
+	return text[start : end+1]
+}
+
+// RepairJSON fixes the mistakes models commonly make in JSON: single-quoted
+// strings become double-quoted and trailing commas before a closing brace
+// or bracket are dropped. Valid JSON is returned unchanged.
+func RepairJSON(text string) string {
+	if json.Valid([]byte(text)) {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"':
+			end := stringEnd(text, i, '"')
+			b.WriteString(text[i:end])
+			i = end - 1
+		case '\'':
+			end := stringEnd(text, i, '\'')
+			b.WriteByte('"')
+			inner := strings.TrimSuffix(text[i+1:end], "'")
+			for j := 0; j < len(inner); j++ {
+				switch {
+				case inner[j] == '\\' && j+1 < len(inner) && inner[j+1] == '\'':
+					b.WriteByte('\'')
+					j++
+				case inner[j] == '\\' && j+1 < len(inner):
+					b.WriteString(inner[j : j+2])
+					j++
+				case inner[j] == '"':
+					b.WriteString(`\"`)
+				default:
+					b.WriteByte(inner[j])
+				}
+			}
+			b.WriteByte('"')
+			i = end - 1
+		case ',':
+			next := strings.TrimLeft(text[i+1:], " \t\r\n")
+			if next == "" || next[0] == '}' || next[0] == ']' {
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// stringEnd returns the index after the string literal opening at start
+// with quote, or the length of text when it is not closed
+func stringEnd(text string, start int, quote byte) int {
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(text)
+}
+
+// InvalidJSONError reports a response that was still not valid JSON for its
+// schema after the retries
+type InvalidJSONError struct {
+	Attempts int
+	Text     string // The last response
+	Err      error  // The syntax error, or a *ValidationError
+}
+
+func (e *InvalidJSONError) Error() string {
+	return fmt.Sprintf("invalid JSON response after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *InvalidJSONError) Unwrap() error {
+	return e.Err
+}
+
+// parseJSON extracts and repairs the JSON of a response and validates it
+// against schema, when there is one, returning the repaired JSON
+func parseJSON(text string, schema any) (string, error) {
+	text = RepairJSON(ExtractJSON(text))
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if schema != nil {
+		if err := ValidateJSON(schema, value); err != nil {
+			return text, err
+		}
+	}
+	return text, nil
+}
+
+// SchemaError is a value violating its schema
+type SchemaError struct {
+	Path    string `json:"path"` // e.g. $.items[2].name
+	Message string `json:"message"`
+}
+
+// ValidationError reports the values of a JSON document violating its schema
+type ValidationError struct {
+	Errors []SchemaError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Path + ": " + err.Message
+	}
+	return "schema validation failed: " + strings.Join(messages, "; ")
+}
+
+// ValidateJSON validates a decoded JSON value against a JSON schema, or a
+// genai.Schema, returning a *ValidationError listing every violation. It
+// covers type, enum, const, properties, required, additionalProperties,
+// items, minItems, maxItems, minimum, maximum, minLength, maxLength and
+// anyOf; other keywords are ignored.
+func ValidateJSON(schema any, value any) error {
+	normalized, err := normalizeSchema(schema)
+	if err != nil {
+		return err
+	}
+	var errs []SchemaError
+	validate(normalized, value, "$", &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// normalizeSchema converts a schema to its generic JSON form
+func normalizeSchema(schema any) (map[string]any, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON schema: %w", err)
+	}
+	var normalized map[string]any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return normalized, nil
+}
+
+// validate appends the violations of schema by value at path to errs
+func validate(schema map[string]any, value any, path string, errs *[]SchemaError) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, SchemaError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if alternatives, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, alt := range alternatives {
+			altSchema, _ := alt.(map[string]any)
+			var altErrs []SchemaError
+			validate(altSchema, value, path, &altErrs)
+			if len(altErrs) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("matches none of the anyOf schemas")
+		}
+	}
+
+	if nullable, _ := schema["nullable"].(bool); nullable && value == nil {
+		return
+	}
+	types := schemaTypes(schema)
+	if len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(value, t) }) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(v any) bool { return jsonEqual(v, value) }) {
+		fail("%s is not one of %s", compactJSON(value), compactJSON(enum))
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		fail("%s is not %s", compactJSON(value), compactJSON(c))
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range stringList(schema["required"]) {
+			if _, ok := value[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			childPath := path + "." + name
+			if sub, ok := properties[name].(map[string]any); ok {
+				validate(sub, value[name], childPath, errs)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property %q", name)
+				}
+			case map[string]any:
+				validate(additional, value[name], childPath, errs)
+			}
+		}
+	case []any:
+		if limit, ok := schemaNumber(schema["minItems"]); ok && float64(len(value)) < limit {
+			fail("has %d items, fewer than %v", len(value), limit)
+		}
+		if limit, ok := schemaNumber(schema["maxItems"]); ok && float64(len(value)) > limit {
+			fail("has %d items, more than %v", len(value), limit)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		if limit, ok := schemaNumber(schema["minLength"]); ok && float64(len([]rune(value))) < limit {
+			fail("is shorter than %v characters", limit)
+		}
+		if limit, ok := schemaNumber(schema["maxLength"]); ok && float64(len([]rune(value))) > limit {
+			fail("is longer than %v characters", limit)
+		}
+	case float64:
+		if limit, ok := schemaNumber(schema["minimum"]); ok && value < limit {
+			fail("%v is less than %v", value, limit)
+		}
+		if limit, ok := schemaNumber(schema["maximum"]); ok && value > limit {
+			fail("%v is greater than %v", value, limit)
+		}
+	}
+}
+
+// schemaTypes returns the types of a schema in lower case, genai.Schema
+// spelling them in upper case
+func schemaTypes(schema map[string]any) []string {
+	types := typeNames(schema["type"])
+	for i, t := range types {
+		types[i] = strings.ToLower(t)
+	}
+	return types
+}
+
+func typeNames(v any) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	return stringList(v)
+}
+
+// stringList returns the strings of a list
+func stringList(v any) []string {
+	list, _ := v.([]any)
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// schemaNumber returns a numeric keyword, which genai.Schema encodes as a string
+func schemaNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		var f float64
+		if _, err := fmt.Sscan(n, &f); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// hasType reports whether value is of the JSON schema type t
+func hasType(value any, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "":
+		return true
+	default:
+		return jsonType(value) == t || (t == "number" && jsonType(value) == "integer")
+	}
+}
+
+// jsonType returns the JSON schema type of a decoded value
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func jsonEqual(a, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,139 @@
+package llmmodel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// TestRepairJSON tests extracting JSON from responses and repairing it
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"valid", `{"a": [1, 2]}`, `{"a": [1, 2]}`},
+		{"fenced", "Sure:\n```json\n{\"a\": 1}\n```\nAnything else?", `{"a": 1}`},
+		{"untagged fence", "```\n[1, 2]\n```", `[1, 2]`},
+		{"surrounding prose", `The answer is {"a": 1}. Hope it helps!`, `{"a": 1}`},
+		{"trailing commas", "{\"a\": [1, 2,],\n \"b\": 3,\n}", "{\"a\": [1, 2],\n \"b\": 3\n}"},
+		{"single quotes", `{'a': 'it\'s "x"', 'b': ['c',]}`, `{"a": "it's \"x\"", "b": ["c"]}`},
+		{"comma in string", `{"a": "x,}", 'b': 1,}`, `{"a": "x,}", "b": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RepairJSON(ExtractJSON(tt.text))
+			if got != tt.want {
+				t.Errorf("RepairJSON(ExtractJSON(%q)) = %q, want %q", tt.text, got, tt.want)
+			}
+			if !json.Valid([]byte(got)) {
+				t.Errorf("%q is not valid JSON", got)
+			}
+		})
+	}
+}
+
+// TestValidateJSON tests the violations reported for a JSON schema and a
+// genai.Schema
+func TestValidateJSON(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"label": map[string]any{"type": "string", "enum": []string{"positive", "negative"}},
+			"score": map[string]any{"type": "number", "minimum": 0, "maximum": 1},
+			"count": map[string]any{"type": "integer"},
+			"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": 2},
+			"note":  map[string]any{"type": []string{"string", "null"}},
+		},
+		"required":             []string{"label", "score"},
+		"additionalProperties": false,
+	}
+	var value any
+	json.Unmarshal([]byte(`{"label": "mixed", "count": 1.5, "tags": ["a", 2, "c"], "note": null, "extra": true}`), &value)
+	err := ValidateJSON(schema, value)
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("ValidateJSON() error = %v, want a *ValidationError", err)
+	}
+	want := []SchemaError{
+		{Path: "$", Message: `missing required property "score"`},
+		{Path: "$.count", Message: "expected integer, got number"},
+		{Path: "$", Message: `unexpected property "extra"`},
+		{Path: "$.label", Message: `"mixed" is not one of ["positive","negative"]`},
+		{Path: "$.tags", Message: "has 3 items, more than 2"},
+		{Path: "$.tags[1]", Message: "expected string, got integer"},
+	}
+	if !slices.Equal(validation.Errors, want) {
+		t.Errorf("ValidateJSON() errors =\n%v\nwant\n%v", validation.Errors, want)
+	}
+
+	json.Unmarshal([]byte(`{"label": "positive", "score": 0.5}`), &value)
+	if err := ValidateJSON(schema, value); err != nil {
+		t.Errorf("ValidateJSON(valid) error = %v", err)
+	}
+
+	genaiSchema := &genai.Schema{
+		Type:       genai.TypeObject,
+		Properties: map[string]*genai.Schema{"name": {Type: genai.TypeString, Nullable: genai.Ptr(true)}},
+		Required:   []string{"name"},
+	}
+	json.Unmarshal([]byte(`{"name": null}`), &value)
+	if err := ValidateJSON(genaiSchema, value); err != nil {
+		t.Errorf("ValidateJSON(genai.Schema) error = %v", err)
+	}
+	json.Unmarshal([]byte(`{"name": 1}`), &value)
+	if err := ValidateJSON(genaiSchema, value); err == nil {
+		t.Error("ValidateJSON(genai.Schema, number) error = nil")
+	}
+}
+
+// TestJSONModel tests that JSON responses are repaired, invalid ones
+// re-prompted, and other requests passed through
+func TestJSONModel(t *testing.T) {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"city": map[string]any{"type": "string"}},
+		"required":   []string{"city"},
+	}
+	request := func(config *genai.GenerateContentConfig) *model.LLMRequest {
+		return &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("Where?", genai.RoleUser)}, Config: config}
+	}
+	generate := func(llm *scriptedModel, maxRetries int, req *model.LLMRequest) (string, error) {
+		m, err := NewJSONModel(llm, &JSONConfig{MaxRetries: maxRetries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text string
+		for resp, err := range m.GenerateContent(context.Background(), req, true) {
+			if err != nil {
+				return "", err
+			}
+			text = resp.Content.Parts[0].Text
+		}
+		return text, nil
+	}
+
+	llm := &scriptedModel{replies: []string{`{'town': 'Paris'}`, "```json\n{'city': 'Paris',}\n```"}}
+	got, err := generate(llm, 0, request(&genai.GenerateContentConfig{ResponseJsonSchema: schema}))
+	if err != nil || got != `{"city": "Paris"}` || len(llm.requests) != 2 {
+		t.Errorf("GenerateContent() = %q, %v after %d requests", got, err, len(llm.requests))
+	}
+
+	llm = &scriptedModel{replies: []string{`no JSON here`}}
+	_, err = generate(llm, 1, request(&genai.GenerateContentConfig{ResponseMIMEType: "application/json"}))
+	var invalid *InvalidJSONError
+	if !errors.As(err, &invalid) || invalid.Attempts != 2 || invalid.Text != "no JSON here" {
+		t.Errorf("GenerateContent(invalid) error = %v", err)
+	}
+
+	llm = &scriptedModel{replies: []string{`plain {text,}`}}
+	if got, err := generate(llm, 0, request(nil)); err != nil || got != `plain {text,}` {
+		t.Errorf("GenerateContent(no JSON requested) = %q, %v", got, err)
+	}
+}
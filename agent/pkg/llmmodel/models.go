@@ -0,0 +1,123 @@
+package llmmodel
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/adk/model"
+)
+
+// ErrModelNotFound is returned by CheckModel when the provider does not serve
+// the model
+var ErrModelNotFound = errors.New("model not found")
+
+// maxAlternatives is the number of alternatives suggested for an unknown model
+const maxAlternatives = 5
+
+// ModelLister is a model that lists the models of its provider
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// ModelNotFoundError reports a model the provider does not serve, with the
+// closest model IDs it does
+type ModelNotFoundError struct {
+	Model        string
+	Alternatives []string
+}
+
+func (e *ModelNotFoundError) Error() string {
+	if len(e.Alternatives) == 0 {
+		return fmt.Sprintf("model %q not found", e.Model)
+	}
+	return fmt.Sprintf("model %q not found, did you mean %s?", e.Model, strings.Join(e.Alternatives, ", "))
+}
+
+func (e *ModelNotFoundError) Unwrap() error {
+	return ErrModelNotFound
+}
+
+// CheckModel verifies that the provider of llm serves its model, so a typo in
+// the model name fails at startup rather than on the first request. It
+// returns a *ModelNotFoundError when the model is not listed, and the listing
+// error when the provider cannot list its models.
+func CheckModel(ctx context.Context, llm model.LLM) error {
+	lister, ok := llm.(ModelLister)
+	if !ok {
+		return fmt.Errorf("model %s cannot list models", llm.Name())
+	}
+	// OpenRouter picks the model of its auto router per request
+	if llm.Name() == defaultOpenRouterModel {
+		return nil
+	}
+	ids, err := lister.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list models: %w", err)
+	}
+	if hasModel(ids, llm.Name()) {
+		return nil
+	}
+	return &ModelNotFoundError{Model: llm.Name(), Alternatives: alternatives(ids, llm.Name())}
+}
+
+// hasModel reports whether ids contains name; Gemini lists its models with a
+// models/ prefix
+func hasModel(ids []string, name string) bool {
+	return slices.ContainsFunc(ids, func(id string) bool {
+		return id == name || strings.TrimPrefix(id, "models/") == name
+	})
+}
+
+// alternatives returns the model IDs closest to name, by edit distance
+// ignoring case
+func alternatives(ids []string, name string) []string {
+	type candidate struct {
+		id       string
+		distance int
+	}
+	name = strings.ToLower(name)
+	limit := max(len(name)/3, 2)
+	var candidates []candidate
+	for _, id := range ids {
+		lower := strings.ToLower(id)
+		d := editDistance(lower, name)
+		if d > limit && !strings.Contains(lower, name) {
+			continue
+		}
+		candidates = append(candidates, candidate{id, d})
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.distance, b.distance), strings.Compare(a.id, b.id))
+	})
+
+	out := make([]string, 0, min(len(candidates), maxAlternatives))
+	for _, c := range candidates[:min(len(candidates), maxAlternatives)] {
+		out = append(out, c.id)
+	}
+	return out
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,66 @@
+package llmmodel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// TestCheckModel tests the startup model check and the suggested alternatives
+func TestCheckModel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"id":"gpt-4o"},{"id":"gpt-4o-mini"},{"id":"gpt-4.1"},{"id":"o3"},{"id":"text-embedding-3-small"}]}`)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		model string
+		want  []string // nil when the model exists
+	}{
+		{"gpt-4o", nil},
+		{"gpt4o", []string{"gpt-4o"}},
+		{"gpt-4", []string{"gpt-4o", "gpt-4.1", "gpt-4o-mini"}},
+		{"GPT-4O-MINI", []string{"gpt-4o-mini"}},
+		{"claude-sonnet-4", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			m, err := NewOpenAIModel(context.Background(), &OpenAIConfig{APIKey: "test", BaseURL: srv.URL, ModelName: tt.model})
+			if err != nil {
+				t.Fatalf("NewOpenAIModel() error = %v", err)
+			}
+			err = CheckModel(context.Background(), m)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("CheckModel() error = %v", err)
+				}
+				return
+			}
+			var notFound *ModelNotFoundError
+			if !errors.As(err, &notFound) || !errors.Is(err, ErrModelNotFound) {
+				t.Fatalf("CheckModel() error = %v, want ModelNotFoundError", err)
+			}
+			if !slices.Equal(notFound.Alternatives, tt.want) {
+				t.Errorf("alternatives = %v, want %v", notFound.Alternatives, tt.want)
+			}
+		})
+	}
+
+	// A failed listing is not a missing model
+	srv.Close()
+	m, err := NewOpenAIModel(context.Background(), &OpenAIConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("NewOpenAIModel() error = %v", err)
+	}
+	if err := CheckModel(context.Background(), m); err == nil || errors.Is(err, ErrModelNotFound) {
+		t.Errorf("CheckModel() error = %v, want listing error", err)
+	}
+}
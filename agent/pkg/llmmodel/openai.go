@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"iter"
-	"time"
+	"maps"
 
 	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
 	"google.golang.org/adk/model"
@@ -18,9 +18,13 @@ type OpenAIModel struct {
 // OpenAIConfig holds configuration for OpenAI model
 type OpenAIConfig struct {
 	APIKey    string
-	BaseURL   string        // Optional, defaults to https://api.openai.com
-	ModelName string        // Required, e.g., "gpt-4", "gpt-3.5-turbo"
-	Timeout   time.Duration // Optional, defaults to 5 minutes
+	BaseURL   string // Optional, defaults to https://api.openai.com
+	ModelName string // Required, e.g., "gpt-4", "gpt-3.5-turbo"
+
+	ClientOptions
+
+	Organization string // Optional, sent as OpenAI-Organization
+	Project      string // Optional, sent as OpenAI-Project
 }
 
 // NewOpenAIModel creates a new OpenAI model instance
@@ -40,12 +44,18 @@ func NewOpenAIModel(ctx context.Context, cfg *OpenAIConfig) (model.LLM, error) {
 		baseURL = "https://api.openai.com"
 	}
 
-	client, err := openai_compatible.NewClient(&openai_compatible.ClientConfig{
-		APIKey:    cfg.APIKey,
-		BaseURL:   baseURL,
-		ModelName: cfg.ModelName,
-		Timeout:   cfg.Timeout,
-	})
+	headers := make(map[string]string, len(cfg.Headers)+2)
+	if cfg.Organization != "" {
+		headers["OpenAI-Organization"] = cfg.Organization
+	}
+	if cfg.Project != "" {
+		headers["OpenAI-Project"] = cfg.Project
+	}
+	maps.Copy(headers, cfg.Headers)
+
+	clientCfg := cfg.clientConfig(cfg.APIKey, baseURL, cfg.ModelName, cfg.BaseURL == "", openAIInputTypes)
+	clientCfg.Headers = headers
+	client, err := openai_compatible.NewClient(clientCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
@@ -64,3 +74,8 @@ func (m *OpenAIModel) Name() string {
 func (m *OpenAIModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
 	return m.client.GenerateContent(ctx, req, stream)
 }
+
+// ListModels returns the models the provider serves
+func (m *OpenAIModel) ListModels(ctx context.Context) ([]string, error) {
+	return m.client.ListModels(ctx)
+}
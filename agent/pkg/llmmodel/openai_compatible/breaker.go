@@ -0,0 +1,277 @@
+package openai_compatible
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is wrapped by every *CircuitOpenError
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitOpenError is returned without sending the request while the circuit
+// of the provider endpoint is open
+type CircuitOpenError struct {
+	Endpoint string
+	RetryIn  time.Duration // Until the next probe request
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s, retrying in %s", e.Endpoint, e.RetryIn.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+// States of a circuit breaker
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// breakerBuckets is the number of buckets of the rolling window
+const breakerBuckets = 10
+
+// BreakerConfig holds when the circuit of a provider endpoint opens. Zero
+// values use the defaults.
+type BreakerConfig struct {
+	Window       time.Duration // Rolling window of the error rate, defaults to 1m
+	MinRequests  int           // Requests in the window before the circuit may open, defaults to 10
+	FailureRate  float64       // Fraction of failed requests that opens the circuit, defaults to 0.5
+	OpenDuration time.Duration // How long requests fail fast before a probe, defaults to 30s
+}
+
+// BreakerStatus is the state and counters of the circuit of an endpoint
+type BreakerStatus struct {
+	Endpoint string    `json:"endpoint"`
+	State    string    `json:"state"`
+	Requests int       `json:"requests"` // In the rolling window
+	Failures int       `json:"failures"` // In the rolling window
+	Opens    int64     `json:"opens"`    // Times the circuit opened
+	Rejected int64     `json:"rejected"` // Requests failed fast
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+}
+
+// bucket counts the requests of a slice of the rolling window
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Breaker is the circuit breaker of a provider endpoint. It counts server
+// and network errors over a rolling window and opens once they reach the
+// failure rate: requests then fail fast with a *CircuitOpenError instead of
+// waiting for the timeout of a dead upstream. After the open duration a
+// single probe request is let through (half-open); its success closes the
+// circuit and its failure opens it again.
+type Breaker struct {
+	endpoint string
+	cfg      BreakerConfig
+	logger   *slog.Logger
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	buckets  [breakerBuckets]bucket
+	openedAt time.Time
+	probing  bool
+	opens    int64
+	rejected int64
+}
+
+// breakers are shared by the clients of an endpoint
+var breakers = struct {
+	sync.Mutex
+	m map[string]*Breaker
+}{m: make(map[string]*Breaker)}
+
+// breakerFor returns the breaker of endpoint, creating it with cfg; clients
+// of the same endpoint share the breaker created first
+func breakerFor(endpoint string, cfg *BreakerConfig, logger *slog.Logger) *Breaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+	if b, ok := breakers.m[endpoint]; ok {
+		return b
+	}
+	b := newBreaker(endpoint, cfg, logger)
+	breakers.m[endpoint] = b
+	return b
+}
+
+func newBreaker(endpoint string, cfg *BreakerConfig, logger *slog.Logger) *Breaker {
+	c := *cfg
+	c.Window = cmp.Or(c.Window, time.Minute)
+	c.MinRequests = cmp.Or(c.MinRequests, 10)
+	c.FailureRate = cmp.Or(c.FailureRate, 0.5)
+	c.OpenDuration = cmp.Or(c.OpenDuration, 30*time.Second)
+	return &Breaker{
+		endpoint: endpoint,
+		cfg:      c,
+		logger:   logger,
+		now:      time.Now,
+		state:    CircuitClosed,
+	}
+}
+
+// Breakers returns the status of the circuit of every endpoint, sorted by endpoint
+func Breakers() []BreakerStatus {
+	breakers.Lock()
+	all := make([]*Breaker, 0, len(breakers.m))
+	for _, b := range breakers.m {
+		all = append(all, b)
+	}
+	breakers.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(all))
+	for _, b := range all {
+		statuses = append(statuses, b.Status())
+	}
+	slices.SortFunc(statuses, func(a, b BreakerStatus) int {
+		return strings.Compare(a.Endpoint, b.Endpoint)
+	})
+	return statuses
+}
+
+// BreakersHandler serves the status of every circuit as JSON, for the admin server
+func BreakersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Breakers())
+}
+
+// Status returns the state and counters of the circuit
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests, failures := b.counts(b.now())
+	status := BreakerStatus{
+		Endpoint: b.endpoint,
+		State:    b.state,
+		Requests: requests,
+		Failures: failures,
+		Opens:    b.opens,
+		Rejected: b.rejected,
+	}
+	if b.state != CircuitClosed {
+		status.OpenedAt = b.openedAt
+	}
+	return status
+}
+
+// allow returns a *CircuitOpenError when the request must fail fast, and
+// otherwise the function recording the outcome of the request
+func (b *Breaker) allow() (func(error), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	switch b.state {
+	case CircuitOpen:
+		if wait := b.openedAt.Add(b.cfg.OpenDuration).Sub(now); wait > 0 {
+			b.rejected++
+			return nil, &CircuitOpenError{Endpoint: b.endpoint, RetryIn: wait}
+		}
+		b.state = CircuitHalfOpen
+		b.logger.Info("Circuit half-open, probing the provider", "endpoint", b.endpoint)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probing {
+			b.rejected++
+			return nil, &CircuitOpenError{Endpoint: b.endpoint}
+		}
+		b.probing = true
+		return b.recordProbe, nil
+	}
+	return b.record, nil
+}
+
+// record counts the outcome of a request of the closed circuit, and opens
+// the circuit once the failure rate is reached
+func (b *Breaker) record(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	width := b.cfg.Window / breakerBuckets
+	start := now.Truncate(width)
+	current := &b.buckets[int(start.UnixNano()/int64(width))%breakerBuckets]
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+	current.requests++
+	if breakerFailure(err) {
+		current.failures++
+	}
+
+	if b.state != CircuitClosed {
+		return
+	}
+	requests, failures := b.counts(now)
+	if requests >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(requests) {
+		b.open(now)
+		b.logger.Warn("Circuit opened, failing requests fast",
+			"endpoint", b.endpoint,
+			"requests", requests,
+			"failures", failures,
+			"open_duration", b.cfg.OpenDuration,
+			"error", err,
+		)
+	}
+}
+
+// recordProbe closes or reopens the circuit with the outcome of the probe
+func (b *Breaker) recordProbe(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The next request probes instead
+	case breakerFailure(err):
+		b.open(b.now())
+		b.logger.Warn("Circuit probe failed, circuit open again", "endpoint", b.endpoint, "error", err)
+	default:
+		b.state = CircuitClosed
+		b.buckets = [breakerBuckets]bucket{}
+		b.logger.Info("Circuit closed, provider recovered", "endpoint", b.endpoint)
+	}
+}
+
+// open opens the circuit; b.mu must be held
+func (b *Breaker) open(now time.Time) {
+	b.state = CircuitOpen
+	b.openedAt = now
+	b.opens++
+}
+
+// counts sums the buckets of the rolling window; b.mu must be held
+func (b *Breaker) counts(now time.Time) (requests, failures int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+// breakerFailure reports whether err is a sign of an unhealthy provider:
+// server and network errors. Rejected requests and rate limits are answers
+// of a provider that is up.
+func breakerFailure(err error) bool {
+	return errors.Is(err, ErrServer) || errors.Is(err, ErrNetwork)
+}
@@ -0,0 +1,84 @@
+package openai_compatible
+
+import "maps"
+
+// CacheControlMinTokens is the estimated size from which a prompt prefix gets
+// a cache breakpoint; Anthropic does not cache shorter prefixes
+const CacheControlMinTokens = 1024
+
+// addCacheControl marks the stable prefixes of a prompt with Anthropic
+// cache_control breakpoints, as passed through by OpenRouter and LiteLLM:
+// the system messages, and the history before the latest user message. A
+// prefix is only marked when it reaches CacheControlMinTokens. It returns
+// the number of breakpoints.
+func (c *Client) addCacheControl(messages []map[string]any) int {
+	last := -1
+	for i, m := range messages {
+		if m["role"] == "user" {
+			last = i
+		}
+	}
+
+	var breakpoints []int
+	system := -1
+	for i, m := range messages {
+		if m["role"] != "system" {
+			break
+		}
+		system = i
+	}
+	if system >= 0 {
+		breakpoints = append(breakpoints, system)
+	}
+	// The history ends at the last message that can carry the marker
+	for i := last - 1; i > system; i-- {
+		if cacheable(messages[i]) {
+			breakpoints = append(breakpoints, i)
+			break
+		}
+	}
+
+	marked, tokens, next := 0, 0, 0
+	for _, b := range breakpoints {
+		for ; next <= b; next++ {
+			tokens += c.messageTokens(messages[next])
+		}
+		if tokens >= CacheControlMinTokens {
+			messages[b] = withCacheControl(messages[b])
+			marked++
+		}
+	}
+	return marked
+}
+
+// cacheable reports whether a message has content a breakpoint can be set on
+func cacheable(m map[string]any) bool {
+	if m["role"] == "tool" {
+		return false
+	}
+	switch content := m["content"].(type) {
+	case string:
+		return content != ""
+	case []map[string]any:
+		return len(content) > 0 && content[len(content)-1]["type"] == "text"
+	}
+	return false
+}
+
+// withCacheControl returns a copy of m whose last text part ends a cached
+// prefix; text content becomes a single text part
+func withCacheControl(m map[string]any) map[string]any {
+	out := maps.Clone(m)
+	var parts []map[string]any
+	switch content := m["content"].(type) {
+	case string:
+		parts = []map[string]any{{"type": "text", "text": content}}
+	case []map[string]any:
+		parts = make([]map[string]any, len(content))
+		copy(parts, content)
+		parts[len(parts)-1] = maps.Clone(parts[len(parts)-1])
+	}
+	parts[len(parts)-1]["cache_control"] = map[string]any{"type": "ephemeral"}
+	out["content"] = parts
+	return out
+}
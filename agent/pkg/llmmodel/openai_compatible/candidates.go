@@ -0,0 +1,63 @@
+package openai_compatible
+
+import (
+	"context"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// MetadataCandidates is the CustomMetadata key carrying the []Candidate of a
+// response to a request for several candidates
+const MetadataCandidates = "candidates"
+
+// Candidate is one of the choices of a response to a request with a
+// CandidateCount above 1. The response's Content is the first one.
+type Candidate struct {
+	Index        int                `json:"index"`
+	Content      *genai.Content     `json:"content"`
+	FinishReason genai.FinishReason `json:"finish_reason,omitempty"`
+}
+
+// Candidates returns the candidates of a response, for sampling and ranking;
+// a response with a single candidate returns its content
+func Candidates(resp *model.LLMResponse) []Candidate {
+	if resp == nil {
+		return nil
+	}
+	if candidates, ok := resp.CustomMetadata[MetadataCandidates].([]Candidate); ok {
+		return candidates
+	}
+	if resp.Content == nil {
+		return nil
+	}
+	return []Candidate{{Content: resp.Content, FinishReason: resp.FinishReason}}
+}
+
+// candidateCount returns the number of candidates req asks for, 0 when unset
+func candidateCount(req *model.LLMRequest) int {
+	if req.Config == nil {
+		return 0
+	}
+	return int(req.Config.CandidateCount)
+}
+
+// candidates converts the choices of a response, sorted by index. Refused
+// choices and choices whose tool calls cannot be converted are left out.
+func (c *Client) candidates(ctx context.Context, choices []responseChoice, names *toolNames) []Candidate {
+	out := make([]Candidate, 0, len(choices))
+	for _, choice := range choices {
+		reason := c.finishReason(choice.FinishReason)
+		if err := refusal(choice.Message.text(), choice.Message.Refusal, reason, choice.ContentFilterResults.filtered()); err != nil {
+			c.logger.WarnContext(ctx, "Provider refused a candidate", "index", choice.Index, "error", err)
+			continue
+		}
+		content, err := c.choiceContent(choice, names)
+		if err != nil {
+			c.logger.WarnContext(ctx, "Skipping candidate", "index", choice.Index, "error", err)
+			continue
+		}
+		out = append(out, Candidate{Index: choice.Index, Content: content, FinishReason: genai.FinishReason(reason)})
+	}
+	return out
+}
@@ -1,44 +1,132 @@
 package openai_compatible
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
 	"google.golang.org/adk/model"
 	"google.golang.org/genai"
 )
 
-// APIError represents an error returned by the API
-type APIError struct {
-	StatusCode int
-	Message    string
-	Type       string
-	Body       string
-}
-
-func (e *APIError) Error() string {
-	if e.Message != "" {
-		return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
-	}
-	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
-}
-
 // ClientConfig holds configuration for OpenAI-compatible API client
 type ClientConfig struct {
 	APIKey     string
-	BaseURL    string
+	BaseURL    string // e.g. https://api.openai.com, or unix:///var/run/llm.sock for a local server
 	ModelName  string
 	HTTPClient *http.Client
 	Timeout    time.Duration // Request timeout, defaults to 5 minutes
 	Logger     *slog.Logger
+
+	// DialContext replaces the TCP dialer of the default HTTP client, e.g. to
+	// reach a server through a proxy or a custom socket. Unused with HTTPClient.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// StreamRetries is the number of times an interrupted stream is re-issued.
+	// Text already delivered is deduplicated from the resumed stream. 0 disables.
+	StreamRetries      int
+	StreamRetryBackoff time.Duration // Initial backoff between attempts, defaults to 1 second
+
+	// StreamIdleTimeout aborts a stream when no data arrives for this long. 0 disables.
+	StreamIdleTimeout time.Duration
+
+	// StreamUsage sends stream_options.include_usage so streams end with a
+	// token usage chunk. Off by default: some OpenAI-compatible servers reject
+	// the field, and others report usage without it.
+	StreamUsage bool
+
+	// StrictTools emits OpenAI strict function schemas (see ApplyStrictMode)
+	StrictTools bool
+
+	// AuthHeader is the header carrying the API key. It defaults to
+	// Authorization with a Bearer prefix; other headers (api-key for Azure,
+	// x-api-key for some gateways) carry the raw key.
+	AuthHeader string
+
+	// Headers are added to every request, e.g. provider attribution headers
+	Headers map[string]string
+
+	// ExtraBody holds provider-specific top-level request fields. Fields the
+	// client sets itself (model, messages, tools, ...) are never overridden.
+	ExtraBody map[string]any
+
+	// RequestTransform adjusts the request body right before it is sent, e.g.
+	// to drop parameters a provider rejects
+	RequestTransform func(body map[string]any)
+
+	// ChatPath is appended to BaseURL, defaults to /v1/chat/completions
+	ChatPath string
+
+	// FinishReasons maps non-standard finish reasons to OpenAI ones (stop,
+	// length, tool_calls, content_filter). An empty value drops the reason.
+	FinishReasons map[string]string
+
+	// Tokenizer estimates prompt tokens, defaults to the one for ModelName
+	Tokenizer tokenizer.Tokenizer
+
+	// ContextWindow is the model's context length in tokens. Prompts estimated
+	// to exceed it (minus the requested output tokens) are logged. 0 disables.
+	ContextWindow int
+
+	// TruncateHistory drops the oldest turns of prompts that exceed ContextWindow
+	TruncateHistory bool
+
+	// MaxInlineDataSize caps each decoded image, audio or file part of a
+	// response; larger ones are replaced by a note. Defaults to DefaultMaxInlineDataSize.
+	MaxInlineDataSize int
+
+	// InputMIMETypes are the MIME type patterns (e.g. "image/*",
+	// "application/pdf") of inline data the provider accepts; requests with
+	// other types fail with ErrUnsupportedInput before being sent. Nil accepts
+	// any type, an empty list none.
+	InputMIMETypes []string
+
+	// MaxImageSize and MaxImageDimension (pixels of the longer side) bound
+	// images sent to the provider; larger ones are downscaled. MaxImageSize
+	// defaults to DefaultMaxImageSize, a 0 dimension is unbounded.
+	MaxImageSize      int
+	MaxImageDimension int
+
+	// DumpDir, when set, receives the exact body of every request and the raw
+	// response or SSE stream in timestamped files, to diagnose provider
+	// incompatibilities. The files hold prompts and answers.
+	DumpDir string
+	// DumpSealer, when set, encrypts the dump files, written with a .enc
+	// suffix under the DumpTenant key
+	DumpSealer *envelope.Sealer
+
+	// MaxConcurrentRequests bounds the requests in flight to the provider, so
+	// a burst of turns queues instead of opening a connection each. 0 is
+	// unlimited. A stream holds its slot until it ends.
+	MaxConcurrentRequests int
+
+	// QueueTimeout fails requests with ErrQueueTimeout when no slot frees up
+	// in time. 0 waits until the request's context is done.
+	QueueTimeout time.Duration
+
+	// CompressRequests gzips request bodies of 1KiB and more and sends them
+	// with Content-Encoding: gzip, for providers and gateways that accept it
+	CompressRequests bool
+
+	// CacheControl marks the system prompt and the conversation history with
+	// Anthropic cache_control breakpoints once they reach CacheControlMinTokens,
+	// for gateways that pass them to Claude models (OpenRouter, LiteLLM).
+	// Other providers cache prompt prefixes on their own.
+	CacheControl bool
+
+	// CircuitBreaker fails requests fast while the endpoint is persistently
+	// erroring, nil disables it. Clients of the same BaseURL share a circuit.
+	CircuitBreaker *BreakerConfig
 }
 
 // Client handles requests to OpenAI-compatible APIs
@@ -48,6 +136,30 @@ type Client struct {
 	modelName  string
 	httpClient *http.Client
 	logger     *slog.Logger
+
+	streamRetries      int
+	streamRetryBackoff time.Duration
+	streamIdleTimeout  time.Duration
+	streamUsage        bool
+	strictTools        bool
+	authHeader         string
+	headers            map[string]string
+	extraBody          map[string]any
+	requestTransform   func(body map[string]any)
+	chatPath           string
+	finishReasons      map[string]string
+	tokenizer          tokenizer.Tokenizer
+	contextWindow      int
+	truncateHistory    bool
+	maxInlineDataSize  int
+	inputMIMETypes     []string
+	maxImageSize       int
+	maxImageDimension  int
+	cacheControl       bool
+	compressRequests   bool
+	slots              chan struct{} // Request slots, nil when unlimited
+	queueTimeout       time.Duration
+	breaker            *Breaker // nil when disabled
 }
 
 // NewClient creates a new OpenAI-compatible API client
@@ -70,7 +182,24 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 	if logger == nil {
 		// Use the default global logger, which inherits the level set by the application
 		// This allows DEBUG logs to be printed when the application sets slog.SetDefault()
-		logger = slog.Default()
+		logger = logging.Component("llm")
+	}
+
+	// A unix:// base URL talks HTTP over a Unix domain socket
+	baseURL := cfg.BaseURL
+	dial := cfg.DialContext
+	if socket, ok := strings.CutPrefix(baseURL, "unix://"); ok {
+		if socket == "" {
+			return nil, fmt.Errorf("unix base URL requires a socket path, e.g. unix:///var/run/llm.sock")
+		}
+		if dial != nil {
+			return nil, fmt.Errorf("unix base URL cannot be combined with a custom dialer")
+		}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://localhost"
 	}
 
 	// Setup HTTP client
@@ -81,28 +210,119 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 			timeout = 5 * time.Minute // Default 5 minutes for LLM requests
 		}
 
+		// Keep a connection per request slot idle, so bursts reuse them
 		httpClient = &http.Client{
 			Timeout: timeout,
 			Transport: &http.Transport{
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 10,
+				MaxIdleConns:        max(100, cfg.MaxConcurrentRequests),
+				MaxIdleConnsPerHost: max(10, cfg.MaxConcurrentRequests),
 				IdleConnTimeout:     90 * time.Second,
+				DialContext:         dial,
 			},
 		}
 	}
 
+	if cfg.DumpDir != "" {
+		dumping := *httpClient
+		transport, err := newDumpTransport(dumping.Transport, cfg.DumpDir, cfg.DumpSealer, logger)
+		if err != nil {
+			return nil, err
+		}
+		dumping.Transport = transport
+		httpClient = &dumping
+		logger.Warn("Dumping model requests and responses", "dir", cfg.DumpDir, "encrypted", cfg.DumpSealer != nil)
+	}
+
+	if cfg.MaxConcurrentRequests < 0 {
+		return nil, fmt.Errorf("max concurrent requests cannot be negative")
+	}
+	if cfg.StreamRetries < 0 {
+		return nil, fmt.Errorf("stream retries cannot be negative")
+	}
+	if cfg.StreamIdleTimeout < 0 {
+		return nil, fmt.Errorf("stream idle timeout cannot be negative")
+	}
+	streamRetryBackoff := cfg.StreamRetryBackoff
+	if streamRetryBackoff == 0 {
+		streamRetryBackoff = time.Second
+	}
+	authHeader := cfg.AuthHeader
+	if authHeader == "" {
+		authHeader = "Authorization"
+	}
+	if strings.ContainsAny(authHeader, " :\t\r\n") {
+		return nil, fmt.Errorf("invalid auth header name %q", authHeader)
+	}
+	chatPath := cfg.ChatPath
+	if chatPath == "" {
+		chatPath = "/v1/chat/completions"
+	}
+	if cfg.ContextWindow < 0 {
+		return nil, fmt.Errorf("context window cannot be negative")
+	}
+	tok := cfg.Tokenizer
+	if tok == nil {
+		tok = tokenizer.ForModel(cfg.ModelName)
+	}
+	if cfg.MaxInlineDataSize < 0 {
+		return nil, fmt.Errorf("max inline data size cannot be negative")
+	}
+	maxInlineDataSize := cfg.MaxInlineDataSize
+	if maxInlineDataSize == 0 {
+		maxInlineDataSize = DefaultMaxInlineDataSize
+	}
+	if cfg.MaxImageSize < 0 || cfg.MaxImageDimension < 0 {
+		return nil, fmt.Errorf("image limits cannot be negative")
+	}
+	maxImageSize := cfg.MaxImageSize
+	if maxImageSize == 0 {
+		maxImageSize = DefaultMaxImageSize
+	}
+
 	client := &Client{
-		apiKey:     cfg.APIKey,
-		baseURL:    cfg.BaseURL,
-		modelName:  cfg.ModelName,
-		httpClient: httpClient,
-		logger:     logger,
+		apiKey:             cfg.APIKey,
+		baseURL:            baseURL,
+		modelName:          cfg.ModelName,
+		httpClient:         httpClient,
+		logger:             logger,
+		streamRetries:      cfg.StreamRetries,
+		streamRetryBackoff: streamRetryBackoff,
+		streamIdleTimeout:  cfg.StreamIdleTimeout,
+		strictTools:        cfg.StrictTools,
+		authHeader:         http.CanonicalHeaderKey(authHeader),
+		headers:            cfg.Headers,
+		extraBody:          cfg.ExtraBody,
+		requestTransform:   cfg.RequestTransform,
+		chatPath:           chatPath,
+		finishReasons:      cfg.FinishReasons,
+		tokenizer:          tok,
+		contextWindow:      cfg.ContextWindow,
+		truncateHistory:    cfg.TruncateHistory,
+		maxInlineDataSize:  maxInlineDataSize,
+		inputMIMETypes:     cfg.InputMIMETypes,
+		maxImageSize:       maxImageSize,
+		maxImageDimension:  cfg.MaxImageDimension,
+		cacheControl:       cfg.CacheControl,
+		streamUsage:        cfg.StreamUsage,
+		compressRequests:   cfg.CompressRequests,
+		queueTimeout:       cfg.QueueTimeout,
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		client.slots = make(chan struct{}, cfg.MaxConcurrentRequests)
+	}
+	if cfg.CircuitBreaker != nil {
+		if cfg.CircuitBreaker.Window < 0 || cfg.CircuitBreaker.MinRequests < 0 || cfg.CircuitBreaker.OpenDuration < 0 ||
+			cfg.CircuitBreaker.FailureRate < 0 || cfg.CircuitBreaker.FailureRate > 1 {
+			return nil, fmt.Errorf("invalid circuit breaker settings")
+		}
+		client.breaker = breakerFor(cfg.BaseURL, cfg.CircuitBreaker, logger)
 	}
 
 	client.logger.Info("OpenAI-compatible client created",
 		"baseURL", cfg.BaseURL,
 		"model", cfg.ModelName,
 		"timeout", httpClient.Timeout,
+		"max_concurrent_requests", cfg.MaxConcurrentRequests,
 	)
 
 	return client, nil
@@ -116,6 +336,27 @@ func (c *Client) ModelName() string {
 // GenerateContent handles both streaming and non-streaming requests
 func (c *Client) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) func(func(*model.LLMResponse, error) bool) {
 	return func(yield func(*model.LLMResponse, error) bool) {
+		if c.breaker != nil {
+			done, err := c.breaker.allow()
+			if err != nil {
+				c.logger.WarnContext(ctx, "Circuit open, failing the request fast", "error", err)
+				yield(nil, err)
+				return
+			}
+			var failed error
+			defer func() { done(failed) }()
+			inner := yield
+			yield = func(resp *model.LLMResponse, err error) bool {
+				if err != nil {
+					failed = err
+				}
+				return inner(resp, err)
+			}
+		}
+		if stream && candidateCount(req) > 1 {
+			c.logger.DebugContext(ctx, "Requesting several candidates without streaming", "candidates", candidateCount(req))
+			stream = false
+		}
 		if stream {
 			c.generateContentStream(ctx, req, yield)
 		} else {
@@ -124,22 +365,63 @@ func (c *Client) GenerateContent(ctx context.Context, req *model.LLMRequest, str
 	}
 }
 
-// buildRequest builds an HTTP request for the OpenAI API
-func (c *Client) buildRequest(ctx context.Context, req *model.LLMRequest, stream bool) (*http.Request, error) {
-	c.logger.Debug("Building request",
+// buildRequest builds an HTTP request for the OpenAI API. It returns the tool
+// name mapping used in the request so tool calls can be translated back.
+func (c *Client) buildRequest(ctx context.Context, req *model.LLMRequest, stream bool) (*http.Request, *toolNames, error) {
+	c.logger.DebugContext(ctx, "Building request",
 		"stream", stream,
 		"model", c.modelName,
 		"contents_count", len(req.Contents),
 	)
 
-	// Convert genai.Content to OpenAI format
-	messages, err := ConvertContentsToMessages(req.Contents)
+	// Convert tools if specified. ADK packs function declarations into
+	// req.Config.Tools; req.Tools is only used when no declarations exist.
+	var tools []map[string]any
+	var err error
+	if req.Config != nil && len(req.Config.Tools) > 0 {
+		tools, err = ConvertFunctionDeclarations(req.Config.Tools)
+	} else if len(req.Tools) > 0 {
+		tools, err = ConvertToolsToOpenAIFormat(req.Tools)
+	}
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Failed to convert tools", "error", err)
+		return nil, nil, fmt.Errorf("failed to convert tools: %w", err)
+	}
+
+	// Sanitize names before strict mode so required lists use the final names
+	names := sanitizeTools(tools)
+	if c.strictTools {
+		ApplyStrictMode(tools)
+	}
+
+	// Fit inline data to the provider, then convert genai.Content to OpenAI format
+	contents, err := c.prepareInlineData(req.Contents)
 	if err != nil {
-		c.logger.Error("Failed to convert contents", "error", err)
-		return nil, fmt.Errorf("failed to convert contents: %w", err)
+		c.logger.ErrorContext(ctx, "Inline data rejected", "error", err)
+		return nil, nil, err
+	}
+	messages, err := convertContents(contents, names)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Failed to convert contents", "error", err)
+		return nil, nil, fmt.Errorf("failed to convert contents: %w", err)
+	}
+	if system := systemMessage(req.Config); system != nil {
+		messages = append([]map[string]any{system}, messages...)
 	}
 
-	c.logger.Debug("Converted messages", "count", len(messages))
+	c.logger.DebugContext(ctx, "Converted messages", "count", len(messages), logging.Content("prompt", lastUserText(req.Contents)))
+
+	// Estimate the prompt size and keep it within the context window
+	var maxOutput int
+	if req.Config != nil {
+		maxOutput = int(req.Config.MaxOutputTokens)
+	}
+	messages, estimated := c.fitContext(messages, tools, maxOutput)
+	if c.cacheControl {
+		if n := c.addCacheControl(messages); n > 0 {
+			c.logger.DebugContext(ctx, "Added cache breakpoints", "count", n)
+		}
+	}
 
 	// Build OpenAI-compatible request
 	openAIReq := map[string]any{
@@ -148,346 +430,341 @@ func (c *Client) buildRequest(ctx context.Context, req *model.LLMRequest, stream
 		"stream":   stream,
 	}
 
+	// Ask for a final usage chunk so streamed turns report token counts
+	if stream && c.streamUsage {
+		openAIReq["stream_options"] = map[string]any{"include_usage": true}
+	}
+
 	// Add temperature if specified
 	if req.Config != nil && req.Config.Temperature != nil {
 		openAIReq["temperature"] = *req.Config.Temperature
-		c.logger.Debug("Added temperature", "value", *req.Config.Temperature)
+		c.logger.DebugContext(ctx, "Added temperature", "value", *req.Config.Temperature)
 	}
 
 	// Add max_tokens if specified
 	if req.Config != nil && req.Config.MaxOutputTokens > 0 {
 		openAIReq["max_tokens"] = req.Config.MaxOutputTokens
-		c.logger.Debug("Added max_tokens", "value", req.Config.MaxOutputTokens)
+		c.logger.DebugContext(ctx, "Added max_tokens", "value", req.Config.MaxOutputTokens)
 	}
 
-	// Add tools if specified
-	if req.Tools != nil && len(req.Tools) > 0 {
-		tools, err := ConvertToolsToOpenAIFormat(req.Tools)
+	// Add top_p if specified
+	if req.Config != nil && req.Config.TopP != nil {
+		openAIReq["top_p"] = *req.Config.TopP
+		c.logger.DebugContext(ctx, "Added top_p", "value", *req.Config.TopP)
+	}
+
+	// Ask for several choices, returned as candidates
+	if n := candidateCount(req); n > 1 {
+		openAIReq["n"] = n
+		c.logger.DebugContext(ctx, "Added n", "value", n)
+	}
+
+	// Add stop sequences if specified
+	if req.Config != nil && len(req.Config.StopSequences) > 0 {
+		openAIReq["stop"] = req.Config.StopSequences
+		c.logger.DebugContext(ctx, "Added stop", "count", len(req.Config.StopSequences))
+	}
+
+	// Add structured output if a schema was declared, JSON mode if only a
+	// JSON response was requested
+	if req.Config != nil {
+		format, err := responseFormat(req.Config)
 		if err != nil {
-			c.logger.Error("Failed to convert tools", "error", err)
-			return nil, fmt.Errorf("failed to convert tools: %w", err)
+			return nil, nil, err
+		}
+		if format != nil {
+			openAIReq["response_format"] = format
+			c.logger.DebugContext(ctx, "Added response_format", "type", format["type"])
 		}
+	}
+
+	// Add tools if specified
+	if len(tools) > 0 {
 		openAIReq["tools"] = tools
-		c.logger.Debug("Added tools", "count", len(tools))
+		c.logger.DebugContext(ctx, "Added tools", "count", len(tools), "strict", c.strictTools)
+	}
+
+	// Add provider-specific fields
+	for key, value := range c.extraBody {
+		if _, ok := openAIReq[key]; !ok {
+			openAIReq[key] = value
+		}
+	}
+
+	if c.requestTransform != nil {
+		c.requestTransform(openAIReq)
 	}
 
-	// Marshal request body
-	reqBody, err := json.Marshal(openAIReq)
+	// Marshal request body into a pooled buffer, released with releaseBody
+	reqBody, err := encodeBody(openAIReq)
 	if err != nil {
-		c.logger.Error("Failed to marshal request", "error", err)
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		c.logger.ErrorContext(ctx, "Failed to marshal request", "error", err)
+		return nil, nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	size := reqBody.Len()
+	compressed := c.compressRequests && size >= compressMinSize
+	if compressed {
+		gz, err := compressBody(reqBody)
+		reqBody.release()
+		if err != nil {
+			c.logger.ErrorContext(ctx, "Failed to compress request", "error", err)
+			return nil, nil, fmt.Errorf("failed to compress request: %w", err)
+		}
+		reqBody = gz
 	}
 
 	// Create HTTP request
-	url := c.baseURL + "/v1/chat/completions"
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	url := c.baseURL + c.chatPath
+	body := reqBody.reader()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
-		c.logger.Error("Failed to create HTTP request", "error", err, "url", url)
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		body.Close()
+		reqBody.release()
+		c.logger.ErrorContext(ctx, "Failed to create HTTP request", "error", err, "url", url)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.ContentLength = int64(reqBody.Len())
+	httpReq.GetBody = func() (io.ReadCloser, error) {
+		return reqBody.reader(), nil
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey) // Log only prefix
+	c.setHeaders(httpReq)
+	if compressed {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	if !stream {
+		httpReq.Header.Set("Accept-Encoding", acceptEncoding)
+	}
 
-	c.logger.Info("Request built successfully",
+	c.logger.InfoContext(ctx, "Request built successfully",
 		"url", url,
 		"stream", stream,
-		"body_size", len(reqBody),
+		"body_size", size,
+		"sent_size", reqBody.Len(),
+		"estimated_tokens", estimated,
 	)
 
-	return httpReq, nil
+	return httpReq, names, nil
 }
 
-// handleHTTPError parses and returns a detailed API error
-func (c *Client) handleHTTPError(resp *http.Response) error {
-	body, _ := io.ReadAll(resp.Body)
-
-	var errResp struct {
-		Error struct {
-			Message string `json:"message"`
-			Type    string `json:"type"`
-			Code    string `json:"code"`
-		} `json:"error"`
+// setHeaders sets the content type, authentication and configured headers
+func (c *Client) setHeaders(httpReq *http.Request) {
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.authHeader == "Authorization" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	} else {
+		httpReq.Header.Set(c.authHeader, c.apiKey)
 	}
-
-	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
-		return &APIError{
-			StatusCode: resp.StatusCode,
-			Message:    errResp.Error.Message,
-			Type:       errResp.Error.Type,
-			Body:       string(body),
-		}
+	for key, value := range c.headers {
+		httpReq.Header.Set(key, value)
 	}
+}
 
-	return &APIError{
-		StatusCode: resp.StatusCode,
-		Body:       string(body),
+// finishReason normalizes a provider finish reason
+func (c *Client) finishReason(reason string) string {
+	if mapped, ok := c.finishReasons[reason]; ok {
+		return mapped
 	}
+	return reason
 }
 
-// generateContentNonStream handles non-streaming requests
-func (c *Client) generateContentNonStream(ctx context.Context, req *model.LLMRequest, yield func(*model.LLMResponse, error) bool) {
-	c.logger.Info("Starting non-streaming request")
-
+// send builds and sends a non-streaming request, returning the response of a
+// successful one and an *APIError, or a *ResponseRefused wrapping one, for an
+// error status
+func (c *Client) send(ctx context.Context, req *model.LLMRequest) (*http.Response, *toolNames, error) {
 	// Build HTTP request
-	httpReq, err := c.buildRequest(ctx, req, false)
+	httpReq, names, err := c.buildRequest(ctx, req, false)
 	if err != nil {
-		c.logger.Error("Failed to build request", "error", err)
-		yield(nil, err)
-		return
+		c.logger.ErrorContext(ctx, "Failed to build request", "error", err)
+		return nil, nil, err
+	}
+	defer releaseBody(httpReq.Body)
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Make HTTP request
-	c.logger.Info("Sending HTTP request", "url", httpReq.URL.String())
+	c.logger.InfoContext(ctx, "Sending HTTP request", "url", httpReq.URL.String())
 	startTime := time.Now()
 
 	resp, err := c.httpClient.Do(httpReq)
 	elapsed := time.Since(startTime)
 
 	if err != nil {
-		c.logger.Error("HTTP request failed",
+		release()
+		c.logger.ErrorContext(ctx, "HTTP request failed",
 			"error", err,
 			"elapsed", elapsed,
 		)
-		yield(nil, fmt.Errorf("failed to make request: %w", err))
-		return
+		return nil, nil, &NetworkError{Err: err}
+	}
+	if err := decodeResponse(resp); err != nil {
+		release()
+		c.logger.ErrorContext(ctx, "Failed to decode response", "error", err)
+		return nil, nil, err
 	}
-	defer resp.Body.Close()
 
-	c.logger.Info("Received HTTP response",
+	c.logger.InfoContext(ctx, "Received HTTP response",
 		"status", resp.StatusCode,
 		"elapsed", elapsed,
 	)
 
+	// The slot is held until the caller has read the response
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		err := c.handleHTTPError(resp)
-		c.logger.Error("API returned error", "error", err)
+		c.logger.ErrorContext(ctx, "API returned error", "error", err, "request_id", requestID(resp.Header))
+		return nil, nil, err
+	}
+	return resp, names, nil
+}
+
+// generateContentNonStream handles non-streaming requests
+func (c *Client) generateContentNonStream(ctx context.Context, req *model.LLMRequest, yield func(*model.LLMResponse, error) bool) {
+	c.logger.InfoContext(ctx, "Starting non-streaming request")
+
+	resp, names, err := c.send(ctx, req)
+	if reduced, ok := c.reduceMaxTokens(req, err); ok {
+		resp, names, err = c.send(ctx, reduced)
+	}
+	if err != nil {
 		yield(nil, err)
 		return
 	}
+	defer resp.Body.Close()
 
 	// Parse OpenAI response
 	var openAIResp struct {
-		ID      string `json:"id"`
-		Choices []struct {
-			Message struct {
-				Role    string `json:"role"`
-				Content string `json:"content"`
-			} `json:"message"`
-			FinishReason string `json:"finish_reason"`
-		} `json:"choices"`
-		Usage struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
-		} `json:"usage"`
+		ID                string           `json:"id"`
+		Model             string           `json:"model"`
+		SystemFingerprint string           `json:"system_fingerprint"`
+		Choices           []responseChoice `json:"choices"`
+		Usage             tokenUsage       `json:"usage"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
-		c.logger.Error("Failed to decode response", "error", err)
+		c.logger.ErrorContext(ctx, "Failed to decode response", "error", err)
 		yield(nil, fmt.Errorf("failed to decode response: %w", err))
 		return
 	}
 
-	c.logger.Info("Parsed response",
-		"id", openAIResp.ID,
+	meta := responseMetadata{requestID: requestID(resp.Header)}
+	meta.update(openAIResp.ID, openAIResp.Model, openAIResp.SystemFingerprint)
+	c.logger.InfoContext(ctx, "Parsed response", append(meta.logAttrs(),
 		"choices", len(openAIResp.Choices),
 		"prompt_tokens", openAIResp.Usage.PromptTokens,
 		"completion_tokens", openAIResp.Usage.CompletionTokens,
-	)
+		"cached_tokens", openAIResp.Usage.cachedTokens(),
+	)...)
 
 	// Convert to genai format
 	if len(openAIResp.Choices) > 0 {
+		slices.SortStableFunc(openAIResp.Choices, func(a, b responseChoice) int { return a.Index - b.Index })
 		choice := openAIResp.Choices[0]
-		content := genai.NewContentFromText(choice.Message.Content, genai.RoleModel)
+		text := choice.Message.text()
+		if err := refusal(text, choice.Message.Refusal, c.finishReason(choice.FinishReason), choice.ContentFilterResults.filtered()); err != nil {
+			c.logger.WarnContext(ctx, "Provider refused the request", append(meta.logAttrs(), "error", err)...)
+			yield(nil, err)
+			return
+		}
+		content, err := c.choiceContent(choice, names)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "Failed to convert tool calls", "error", err)
+			yield(nil, err)
+			return
+		}
 		llmResp := &model.LLMResponse{
-			Content: content,
-			UsageMetadata: &genai.GenerateContentResponseUsageMetadata{
-				PromptTokenCount:     int32(openAIResp.Usage.PromptTokens),
-				CandidatesTokenCount: int32(openAIResp.Usage.CompletionTokens),
-				TotalTokenCount:      int32(openAIResp.Usage.TotalTokens),
-			},
-			TurnComplete: true,
+			Content:        content,
+			UsageMetadata:  openAIResp.Usage.metadata(),
+			CustomMetadata: meta.custom(),
+			TurnComplete:   true,
 		}
 
-		if choice.FinishReason != "" {
-			llmResp.FinishReason = genai.FinishReason(choice.FinishReason)
+		if reason := c.finishReason(choice.FinishReason); reason != "" {
+			llmResp.FinishReason = genai.FinishReason(reason)
+		}
+		if len(openAIResp.Choices) > 1 {
+			candidates := c.candidates(ctx, openAIResp.Choices, names)
+			if llmResp.CustomMetadata == nil {
+				llmResp.CustomMetadata = map[string]any{}
+			}
+			llmResp.CustomMetadata[MetadataCandidates] = candidates
 		}
 
-		c.logger.Info("Yielding response",
-			"content_length", len(choice.Message.Content),
+		c.logger.InfoContext(ctx, "Yielding response",
+			"content_length", len(text),
+			logging.Content("content", text),
+			"parts", len(content.Parts),
+			"tool_calls", len(choice.Message.ToolCalls),
 			"finish_reason", choice.FinishReason,
 		)
 
 		yield(llmResp, nil)
 	} else {
-		c.logger.Warn("No choices in response")
+		c.logger.WarnContext(ctx, "No choices in response")
 	}
 }
 
-// generateContentStream handles streaming requests
-func (c *Client) generateContentStream(ctx context.Context, req *model.LLMRequest, yield func(*model.LLMResponse, error) bool) {
-	c.logger.Info("Starting streaming request")
+// responseChoice is a choice of a non-streaming completion
+type responseChoice struct {
+	Index        int             `json:"index"`
+	Message      responseMessage `json:"message"`
+	FinishReason string          `json:"finish_reason"`
 
-	// Build HTTP request
-	httpReq, err := c.buildRequest(ctx, req, true)
-	if err != nil {
-		c.logger.Error("Failed to build request", "error", err)
-		yield(nil, err)
-		return
-	}
-
-	// Make HTTP request
-	c.logger.Info("Sending streaming HTTP request", "url", httpReq.URL.String())
-	startTime := time.Now()
+	ContentFilterResults filterResults `json:"content_filter_results"` // Azure
+}
 
-	resp, err := c.httpClient.Do(httpReq)
-	elapsed := time.Since(startTime)
+// responseMessage is the message of a choice
+type responseMessage struct {
+	Role      string         `json:"role"`
+	Content   messageContent `json:"content"`
+	Refusal   string         `json:"refusal"`
+	ToolCalls []toolCall     `json:"tool_calls"`
+	Images    []contentPart  `json:"images"` // Generated images, e.g. OpenRouter
+	Audio     *audioData     `json:"audio"`  // Spoken answer of audio output models
+}
 
-	if err != nil {
-		c.logger.Error("Streaming HTTP request failed",
-			"error", err,
-			"elapsed", elapsed,
-		)
-		yield(nil, fmt.Errorf("failed to make request: %w", err))
-		return
+// text returns the text of the message, the transcript of a spoken answer
+// without text
+func (m *responseMessage) text() string {
+	if m.Content.Text == "" && m.Audio != nil {
+		return m.Audio.Transcript
 	}
-	defer resp.Body.Close()
-
-	c.logger.Info("Received streaming HTTP response",
-		"status", resp.StatusCode,
-		"elapsed", elapsed,
-	)
+	return m.Content.Text
+}
 
-	if resp.StatusCode != http.StatusOK {
-		err := c.handleHTTPError(resp)
-		c.logger.Error("Streaming API returned error", "error", err)
-		yield(nil, err)
-		return
+// choiceContent converts the message of a choice to genai content
+func (c *Client) choiceContent(choice responseChoice, names *toolNames) (*genai.Content, error) {
+	text := choice.Message.text()
+	inline := c.inlineParts(slices.Concat(choice.Message.Content.Parts, choice.Message.Images))
+	if audio := choice.Message.Audio; audio != nil {
+		inline = append(inline, c.inlineParts([]contentPart{{Type: "audio", Audio: audio}})...)
 	}
+	calls, err := convertToolCalls(choice.Message.ToolCalls, names)
+	if err != nil {
+		return nil, err
+	}
+	return responseContent(text, inline, calls), nil
+}
 
-	// Parse streaming response (SSE format)
-	c.logger.Info("Starting to parse streaming response")
-	scanner := bufio.NewScanner(resp.Body)
-	var accumulatedContent strings.Builder
-	accumulatedContent.Grow(1024) // Pre-allocate capacity
-
-	chunkCount := 0
-	firstChunkTime := time.Time{}
-
-	for scanner.Scan() {
-		// Check context cancellation
-		select {
-		case <-ctx.Done():
-			c.logger.Warn("Context cancelled during streaming", "chunks_received", chunkCount)
-			yield(nil, ctx.Err())
-			return
-		default:
-		}
-
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		// SSE format: "data: {...}" or "[DONE]"
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			c.logger.Info("Stream completed with [DONE]",
-				"chunks_received", chunkCount,
-				"total_content_length", accumulatedContent.Len(),
-			)
-
-			// Send final response
-			if accumulatedContent.Len() > 0 {
-				content := genai.NewContentFromText(accumulatedContent.String(), genai.RoleModel)
-				llmResp := &model.LLMResponse{
-					Content:      content,
-					TurnComplete: true,
-				}
-				if !yield(llmResp, nil) {
-					return
-				}
-			}
-			break
-		}
-
-		var streamChunk struct {
-			ID      string `json:"id"`
-			Choices []struct {
-				Delta struct {
-					Role    string `json:"role"`
-					Content string `json:"content"`
-				} `json:"delta"`
-				FinishReason string `json:"finish_reason"`
-			} `json:"choices"`
-		}
-
-		if err := json.Unmarshal([]byte(data), &streamChunk); err != nil {
-			c.logger.Warn("Failed to parse stream chunk, skipping", "error", err, "data", data[:min(len(data), 100)])
+// lastUserText returns the text of the latest user message, for logging
+func lastUserText(contents []*genai.Content) string {
+	for i := len(contents) - 1; i >= 0; i-- {
+		c := contents[i]
+		if c == nil || c.Role != genai.RoleUser {
 			continue
 		}
-
-		if len(streamChunk.Choices) > 0 {
-			choice := streamChunk.Choices[0]
-			if choice.Delta.Content != "" {
-				chunkCount++
-				if firstChunkTime.IsZero() {
-					firstChunkTime = time.Now()
-					c.logger.Info("First chunk received", "time_to_first_chunk", time.Since(startTime))
-				}
-
-				accumulatedContent.WriteString(choice.Delta.Content)
-				content := genai.NewContentFromText(choice.Delta.Content, genai.RoleModel)
-				llmResp := &model.LLMResponse{
-					Content: content,
-					Partial: true,
-				}
-
-				if chunkCount%10 == 0 {
-					c.logger.Debug("Streaming progress",
-						"chunks", chunkCount,
-						"accumulated_length", accumulatedContent.Len(),
-					)
-				}
-
-				if !yield(llmResp, nil) {
-					c.logger.Info("Yield returned false, stopping stream", "chunks_sent", chunkCount)
-					return
-				}
-			}
-
-			if choice.FinishReason != "" {
-				c.logger.Info("Stream finished",
-					"reason", choice.FinishReason,
-					"chunks_received", chunkCount,
-					"total_content_length", accumulatedContent.Len(),
-				)
-
-				// Send final response with accumulated content
-				content := genai.NewContentFromText(accumulatedContent.String(), genai.RoleModel)
-				llmResp := &model.LLMResponse{
-					Content:      content,
-					FinishReason: genai.FinishReason(choice.FinishReason),
-					TurnComplete: true,
-				}
-				if !yield(llmResp, nil) {
-					return
-				}
-				break
+		var b strings.Builder
+		for _, p := range c.Parts {
+			if p != nil {
+				b.WriteString(p.Text)
 			}
 		}
+		return b.String()
 	}
-
-	if err := scanner.Err(); err != nil {
-		c.logger.Error("Scanner error during streaming", "error", err, "chunks_received", chunkCount)
-		yield(nil, fmt.Errorf("failed to read stream: %w", err))
-		return
-	}
-
-	c.logger.Info("Streaming completed successfully", "total_chunks", chunkCount)
+	return ""
 }
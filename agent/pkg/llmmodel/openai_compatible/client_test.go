@@ -0,0 +1,1400 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// TestBuildRequest_GenerationParams tests that generation parameters map to OpenAI request fields
+func TestBuildRequest_GenerationParams(t *testing.T) {
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "http://localhost", ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	temperature, topP := float32(0.5), float32(0.9)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+		Config: &genai.GenerateContentConfig{
+			Temperature:      &temperature,
+			TopP:             &topP,
+			MaxOutputTokens:  128,
+			StopSequences:    []string{"END"},
+			ResponseMIMEType: "application/json",
+		},
+	}
+
+	httpReq, _, err := client.buildRequest(context.Background(), req, false)
+	if err != nil {
+		t.Fatalf("buildRequest() error = %v", err)
+	}
+	data, _ := io.ReadAll(httpReq.Body)
+
+	var body struct {
+		Temperature    float32        `json:"temperature"`
+		TopP           float32        `json:"top_p"`
+		MaxTokens      int            `json:"max_tokens"`
+		Stop           []string       `json:"stop"`
+		ResponseFormat map[string]any `json:"response_format"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+
+	if body.Temperature != 0.5 || body.TopP != 0.9 || body.MaxTokens != 128 {
+		t.Errorf("unexpected sampling parameters in %s", data)
+	}
+	if len(body.Stop) != 1 || body.Stop[0] != "END" {
+		t.Errorf("unexpected stop %v", body.Stop)
+	}
+	if body.ResponseFormat["type"] != "json_object" {
+		t.Errorf("unexpected response_format %v", body.ResponseFormat)
+	}
+}
+
+// TestBuildRequest_ResponseSchema tests that a declared response schema is
+// sent as strict structured output
+func TestBuildRequest_ResponseSchema(t *testing.T) {
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "http://localhost", ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+		Config: &genai.GenerateContentConfig{
+			ResponseMIMEType: "application/json",
+			ResponseJsonSchema: map[string]any{
+				"title": "address",
+				"type":  "object",
+				"properties": map[string]any{
+					"city":    map[string]any{"type": "string"},
+					"country": map[string]any{"type": "string"},
+				},
+				"required": []string{"city"},
+			},
+		},
+	}
+	httpReq, _, err := client.buildRequest(context.Background(), req, false)
+	if err != nil {
+		t.Fatalf("buildRequest() error = %v", err)
+	}
+	var body struct {
+		ResponseFormat struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Name   string         `json:"name"`
+				Strict bool           `json:"strict"`
+				Schema map[string]any `json:"schema"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	if err := json.NewDecoder(httpReq.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	format := body.ResponseFormat
+	if format.Type != "json_schema" || format.JSONSchema.Name != "address" || !format.JSONSchema.Strict {
+		t.Errorf("unexpected response_format %+v", format)
+	}
+	schema, _ := json.Marshal(format.JSONSchema.Schema)
+	want := `{"additionalProperties":false,"properties":{"city":{"type":"string"},"country":{"type":["string","null"]}},"required":["city","country"],"title":"address","type":"object"}`
+	if string(schema) != want {
+		t.Errorf("schema = %s, want %s", schema, want)
+	}
+}
+
+// TestBuildRequest_SystemInstruction tests that the system instruction is sent
+// as a leading system message
+func TestBuildRequest_SystemInstruction(t *testing.T) {
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "http://localhost", ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		config *genai.GenerateContentConfig
+		want   string
+	}{
+		{"no config", nil, `[{"content":"hi","role":"user"}]`},
+		{"no instruction", &genai.GenerateContentConfig{}, `[{"content":"hi","role":"user"}]`},
+		{"instruction", &genai.GenerateContentConfig{SystemInstruction: &genai.Content{Parts: []*genai.Part{
+			{Text: "You are a helpful assistant."}, {Text: ""}, {Text: "## Summary of the earlier conversation\nAda asked about Paris."},
+		}}}, `[{"content":"You are a helpful assistant.\n\n## Summary of the earlier conversation\nAda asked about Paris.","role":"system"},{"content":"hi","role":"user"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &model.LLMRequest{
+				Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+				Config:   tt.config,
+			}
+			httpReq, _, err := client.buildRequest(context.Background(), req, false)
+			if err != nil {
+				t.Fatalf("buildRequest() error = %v", err)
+			}
+			var body struct {
+				Messages json.RawMessage `json:"messages"`
+			}
+			if err := json.NewDecoder(httpReq.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode request body: %v", err)
+			}
+			if string(body.Messages) != tt.want {
+				t.Errorf("messages = %s, want %s", body.Messages, tt.want)
+			}
+		})
+	}
+}
+
+// clientModel adapts a Client to model.LLM the way the provider models do
+type clientModel struct{ *Client }
+
+func (m clientModel) Name() string { return m.ModelName() }
+
+func (m clientModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return m.Client.GenerateContent(ctx, req, stream)
+}
+
+// TestGenerateContent_AgentTools tests that tools registered on an ADK agent
+// reach the provider with their declared descriptions and parameters
+func TestGenerateContent_AgentTools(t *testing.T) {
+	var body struct {
+		Tools []struct {
+			Type     string `json:"type"`
+			Function struct {
+				Name        string         `json:"name"`
+				Description string         `json:"description"`
+				Parameters  map[string]any `json:"parameters"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	type weatherArgs struct {
+		City string `json:"city" jsonschema:"The city to look up"`
+	}
+	weather, err := functiontool.New(functiontool.Config{Name: "get_weather", Description: "Returns the weather for a city"},
+		func(tool.Context, weatherArgs) (map[string]any, error) {
+			return map[string]any{"weather": "sunny"}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := llmagent.New(llmagent.Config{Name: "weather_agent", Model: clientModel{client}, Tools: []tool.Tool{weather}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, _ := runner.New(runner.Config{AppName: "app", Agent: a, SessionService: sessions})
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "alice"})
+	for _, err := range r.Run(ctx, "alice", created.Session.ID(), genai.NewContentFromText("Weather in Paris?", genai.RoleUser), agent.RunConfig{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(body.Tools) != 1 {
+		t.Fatalf("tools = %+v, want one tool", body.Tools)
+	}
+	fn := body.Tools[0].Function
+	if body.Tools[0].Type != "function" || fn.Name != "get_weather" || fn.Description != "Returns the weather for a city" {
+		t.Errorf("tool = %+v", body.Tools[0])
+	}
+	props, _ := fn.Parameters["properties"].(map[string]any)
+	city, _ := props["city"].(map[string]any)
+	if city["type"] != "string" || city["description"] != "The city to look up" {
+		t.Errorf("parameters = %v", fn.Parameters)
+	}
+}
+
+// TestBuildRequest_HeadersAndExtraBody tests provider headers and extra fields without overriding core fields
+func TestBuildRequest_HeadersAndExtraBody(t *testing.T) {
+	client, err := NewClient(&ClientConfig{
+		APIKey:    "test",
+		BaseURL:   "http://localhost",
+		ModelName: "vendor/model",
+		Headers:   map[string]string{"X-Title": "yanshu"},
+		ExtraBody: map[string]any{"models": []string{"vendor/fallback"}, "model": "ignored"},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	httpReq, _, err := client.buildRequest(context.Background(), req, false)
+	if err != nil {
+		t.Fatalf("buildRequest() error = %v", err)
+	}
+	if got := httpReq.Header.Get("X-Title"); got != "yanshu" {
+		t.Errorf("X-Title header = %q", got)
+	}
+
+	var body map[string]any
+	data, _ := io.ReadAll(httpReq.Body)
+	json.Unmarshal(data, &body)
+	if body["model"] != "vendor/model" {
+		t.Errorf("extra body overrode model: %v", body["model"])
+	}
+	if models, ok := body["models"].([]any); !ok || len(models) != 1 {
+		t.Errorf("unexpected models %v", body["models"])
+	}
+}
+
+// TestBuildRequest_AuthHeader tests Bearer auth by default and raw keys in custom auth headers
+func TestBuildRequest_AuthHeader(t *testing.T) {
+	tests := []struct {
+		authHeader string
+		wantHeader string
+		wantValue  string
+		wantErr    bool
+	}{
+		{"", "Authorization", "Bearer secret", false},
+		{"api-key", "Api-Key", "secret", false},
+		{"x-api-key", "X-Api-Key", "secret", false},
+		{"bad header", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.authHeader, func(t *testing.T) {
+			client, err := NewClient(&ClientConfig{APIKey: "secret", BaseURL: "http://localhost", ModelName: "m", AuthHeader: tt.authHeader})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+			httpReq, _, err := client.buildRequest(context.Background(), req, false)
+			if err != nil {
+				t.Fatalf("buildRequest() error = %v", err)
+			}
+			if got := httpReq.Header.Get(tt.wantHeader); got != tt.wantValue {
+				t.Errorf("%s header = %q, want %q", tt.wantHeader, got, tt.wantValue)
+			}
+			if tt.wantHeader != "Authorization" && httpReq.Header.Get("Authorization") != "" {
+				t.Error("Authorization header should not be sent with a custom auth header")
+			}
+		})
+	}
+}
+
+// TestBuildRequest_ContextWindow tests dropping the oldest turns of prompts above the context window
+func TestBuildRequest_ContextWindow(t *testing.T) {
+	long := strings.Repeat("lorem ipsum dolor sit amet ", 40)
+	var contents []*genai.Content
+	for i := range 6 {
+		contents = append(contents, genai.NewContentFromText(fmt.Sprintf("question %d: %s", i, long), genai.RoleUser))
+		if i == 0 {
+			contents = append(contents,
+				&genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{genai.NewPartFromFunctionCall("lookup", map[string]any{"q": "x"})}},
+				&genai.Content{Role: genai.RoleUser, Parts: []*genai.Part{genai.NewPartFromFunctionResponse("lookup", map[string]any{"result": "y"})}},
+			)
+		}
+		contents = append(contents, genai.NewContentFromText(fmt.Sprintf("answer %d: %s", i, long), genai.RoleModel))
+	}
+	contents = append(contents, genai.NewContentFromText("latest question", genai.RoleUser))
+	req := &model.LLMRequest{Contents: contents, Config: &genai.GenerateContentConfig{MaxOutputTokens: 100}}
+
+	tests := []struct {
+		name     string
+		truncate bool
+		wantAll  bool
+	}{
+		{name: "warn only", truncate: false, wantAll: true},
+		{name: "truncate", truncate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(&ClientConfig{
+				APIKey: "k", BaseURL: "http://localhost", ModelName: "gpt-4o",
+				ContextWindow: 1000, TruncateHistory: tt.truncate,
+			})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			all, _ := convertContents(contents, nil)
+			httpReq, _, err := client.buildRequest(context.Background(), req, false)
+			if err != nil {
+				t.Fatalf("buildRequest() error = %v", err)
+			}
+			var body struct {
+				Messages []map[string]any `json:"messages"`
+			}
+			if err := json.NewDecoder(httpReq.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+
+			if tt.wantAll {
+				if len(body.Messages) != len(all) {
+					t.Errorf("got %d messages, want all %d", len(body.Messages), len(all))
+				}
+				return
+			}
+			if len(body.Messages) >= len(all) || len(body.Messages) < 3 {
+				t.Fatalf("got %d messages, want some but not all of %d", len(body.Messages), len(all))
+			}
+			if body.Messages[0]["role"] != "user" {
+				t.Errorf("first message role = %v, want a turn starting with user", body.Messages[0]["role"])
+			}
+			if last := body.Messages[len(body.Messages)-1]; last["content"] != "latest question" {
+				t.Errorf("last message = %v, want the latest question", last)
+			}
+			if got := client.estimateTokens(body.Messages, nil); got > 900 {
+				t.Errorf("truncated prompt is %d tokens, want at most 900", got)
+			}
+		})
+	}
+}
+
+// TestResponseMetadata tests that provider identifiers are attached to the final response
+func TestResponseMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		w.Header().Set("X-Request-Id", "req-123")
+		if !body.Stream {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","model":"test-model-0613","system_fingerprint":"fp_abc","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"model\":\"test-model-0613\",\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"system_fingerprint\":\"fp_abc\",\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	want := map[string]any{
+		MetadataRequestID:         "req-123",
+		MetadataResponseID:        "chatcmpl-1",
+		MetadataModel:             "test-model-0613",
+		MetadataSystemFingerprint: "fp_abc",
+	}
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+			var final *model.LLMResponse
+			for resp, err := range client.GenerateContent(context.Background(), req, stream) {
+				if err != nil {
+					t.Fatalf("GenerateContent() error = %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				}
+			}
+			if final == nil {
+				t.Fatal("no final response")
+			}
+			for key, value := range want {
+				if got := final.CustomMetadata[key]; got != value {
+					t.Errorf("CustomMetadata[%q] = %v, want %v", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+// TestResponseRefused tests that refusals and content_filter finish reasons become ResponseRefused errors
+func TestResponseRefused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if !body.Stream {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":null,"refusal":"I can't help with that."},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Sure\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"content_filter\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	tests := []struct {
+		stream     bool
+		wantReason string
+		wantText   string
+	}{
+		{false, "I can't help with that.", ""},
+		{true, "content_filter", "Sure"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("stream=%v", tt.stream), func(t *testing.T) {
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+			var refused *ResponseRefused
+			for _, err := range client.GenerateContent(context.Background(), req, tt.stream) {
+				if err != nil && !errors.As(err, &refused) {
+					t.Fatalf("GenerateContent() error = %v, want ResponseRefused", err)
+				}
+			}
+			if refused == nil {
+				t.Fatal("expected a ResponseRefused error")
+			}
+			if refused.Reason != tt.wantReason || refused.Text != tt.wantText {
+				t.Errorf("refused = %+v, want reason %q and text %q", refused, tt.wantReason, tt.wantText)
+			}
+		})
+	}
+}
+
+// TestContentFilter tests Azure content filter results, on the answer and as
+// an error rejecting the prompt
+func TestContentFilter(t *testing.T) {
+	const promptFiltered = `{"error":{"message":"The response was filtered due to the prompt triggering Azure OpenAI's content management policy.",` +
+		`"type":null,"param":"prompt","code":"content_filter","status":400,"innererror":{"code":"ResponsibleAIPolicyViolation",` +
+		`"content_filter_result":{"hate":{"filtered":false,"severity":"safe"},"jailbreak":{"filtered":true,"detected":true},"violence":{"filtered":true,"severity":"medium"}}}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Messages[0].Content == "prompt" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, promptFiltered)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"content_filter",`+
+			`"content_filter_results":{"custom_blocklists":[],"self_harm":{"filtered":true,"severity":"high"},"sexual":{"filtered":false}}}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	tests := []struct {
+		input          string
+		wantCategories []string
+		wantPrompt     bool
+	}{
+		{"answer", []string{"self_harm"}, false},
+		{"prompt", []string{"jailbreak", "violence"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText(tt.input, genai.RoleUser)}}
+			var refused *ResponseRefused
+			for _, err := range client.GenerateContent(context.Background(), req, false) {
+				if !errors.As(err, &refused) {
+					t.Fatalf("GenerateContent() error = %v, want ResponseRefused", err)
+				}
+			}
+			if !slices.Equal(refused.Categories, tt.wantCategories) || refused.Prompt != tt.wantPrompt || refused.FinishReason != "content_filter" {
+				t.Errorf("refused = %+v, want categories %v, prompt %v", refused, tt.wantCategories, tt.wantPrompt)
+			}
+			var apiErr *APIError
+			if errors.As(refused, &apiErr) != tt.wantPrompt {
+				t.Errorf("refused wraps API error %v, want %v", apiErr, tt.wantPrompt)
+			}
+		})
+	}
+
+	// Other error responses stay API errors
+	if err := contentFilterError(&APIError{StatusCode: 400, Body: `{"error":{"message":"bad","code":400}}`}); !errors.As(err, new(*APIError)) || errors.As(err, new(*ResponseRefused)) {
+		t.Errorf("contentFilterError() = %T, want *APIError", err)
+	}
+}
+
+// TestUnixSocket tests that a unix:// base URL sends requests over the socket
+func TestUnixSocket(t *testing.T) {
+	// Socket paths are limited to ~100 bytes, t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "uds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "llm.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"over uds"},"finish_reason":"stop"}]}`)
+	}))
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "unix://" + socket, ModelName: "local"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	for resp, err := range client.GenerateContent(context.Background(), req, false) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		if got := resp.Content.Parts[0].Text; got != "over uds" {
+			t.Errorf("text = %q, want %q", got, "over uds")
+		}
+	}
+
+	if _, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "unix://", ModelName: "local"}); err == nil {
+		t.Error("unix base URL without a socket path should fail")
+	}
+}
+
+// TestEmbed tests the embeddings request, the derived path and result ordering
+func TestEmbed(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "embedding-3", ChatPath: "/api/paas/v4/chat/completions"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	vectors, err := client.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if path != "/api/paas/v4/embeddings" {
+		t.Errorf("path = %q, want /api/paas/v4/embeddings", path)
+	}
+	if fmt.Sprint(vectors) != "[[1 0] [0 1]]" {
+		t.Errorf("vectors = %v, want ordered by index", vectors)
+	}
+}
+
+// TestListModels tests listing models through the derived path
+func TestListModels(t *testing.T) {
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if r.URL.Path != "/v1/models" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"message":"not found"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"a"},{"id":"b"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "a"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	ids, err := client.ListModels(context.Background())
+	if err != nil || fmt.Sprint(ids) != "[a b]" || auth != "Bearer test" {
+		t.Errorf("ListModels() = %v, %v with auth %q", ids, err, auth)
+	}
+
+	client, _ = NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "a", ChatPath: "/api/paas/v4/chat/completions"})
+	var apiErr *APIError
+	if _, err := client.ListModels(context.Background()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || path != "/api/paas/v4/models" {
+		t.Errorf("ListModels() error = %v at %s, want a 404 at /api/paas/v4/models", err, path)
+	}
+}
+
+// TestReduceMaxTokens tests reading the context window and prompt size from provider errors
+func TestReduceMaxTokens(t *testing.T) {
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "http://localhost", ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		max  int32
+		want int32 // 0 for no retry
+	}{
+		{"openai", &APIError{StatusCode: 400, Message: "This model's maximum context length is 8192 tokens. However, you requested 9000 tokens (7000 in the messages, 2000 in the completion). Please reduce the length of the messages or completion."}, 2000, 1192},
+		{"openrouter", &APIError{StatusCode: 400, Message: "This endpoint's maximum context length is 200000 tokens. However, you requested about 210000 tokens (150000 of text input, 28000 of tool input, 32000 in the output)."}, 32000, 22000},
+		{"vllm", &APIError{StatusCode: 400, Message: "'max_tokens' is too large: 4096. This model's maximum context length is 32768 tokens and your request has 30000 input tokens (4096 > 32768 - 30000)."}, 4096, 2768},
+		{"tgi", &APIError{StatusCode: 422, Body: "Input validation error: `inputs` tokens + `max_new_tokens` must be <= 32769. Given: 30000 `inputs` tokens and 4000 `max_new_tokens`"}, 4000, 2769},
+		{"mistral", &APIError{StatusCode: 400, Message: "Prompt contains 30000 tokens and 0 draft tokens, too large for model with 32768 maximum context length"}, 0, 2768},
+		{"prompt alone too long", &APIError{StatusCode: 400, Message: "This model's maximum context length is 8192 tokens. However, you requested 9000 tokens (9000 in the messages, 0 in the completion)."}, 0, 0},
+		{"other error", &APIError{StatusCode: 400, Message: "Invalid value for temperature"}, 2000, 0},
+		{"server error", &APIError{StatusCode: 500, Message: "This model's maximum context length is 8192 tokens. However, you requested 9000 tokens (7000 in the messages, 2000 in the completion)."}, 2000, 0},
+		{"not an API error", errors.New("connection reset"), 2000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &model.LLMRequest{Config: &genai.GenerateContentConfig{MaxOutputTokens: tt.max}}
+			reduced, ok := client.reduceMaxTokens(req, tt.err)
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("reduceMaxTokens() = %d, want no retry", reduced.Config.MaxOutputTokens)
+				}
+				return
+			}
+			if !ok || reduced.Config.MaxOutputTokens != tt.want {
+				t.Fatalf("reduceMaxTokens() = %v, %v, want %d", reduced, ok, tt.want)
+			}
+			if req.Config.MaxOutputTokens != tt.max {
+				t.Error("reduceMaxTokens() modified the original request")
+			}
+		})
+	}
+}
+
+// TestContextOverflowRetry tests that a request over the context window is retried once with fewer max_tokens
+func TestContextOverflowRetry(t *testing.T) {
+	var maxTokens []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Stream    bool `json:"stream"`
+			MaxTokens int  `json:"max_tokens"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		maxTokens = append(maxTokens, body.MaxTokens)
+
+		if body.MaxTokens > 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":{"message":"This model's maximum context length is 4096 tokens. However, you requested %d tokens (3096 in the messages, %d in the completion).","type":"invalid_request_error"}}`, 3096+body.MaxTokens, body.MaxTokens)
+			return
+		}
+		if !body.Stream {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			maxTokens = nil
+			req := &model.LLMRequest{
+				Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+				Config:   &genai.GenerateContentConfig{MaxOutputTokens: 2000},
+			}
+			var text string
+			for resp, err := range client.GenerateContent(context.Background(), req, stream) {
+				if err != nil {
+					t.Fatalf("GenerateContent() error = %v", err)
+				}
+				if !resp.Partial {
+					text = resp.Content.Parts[0].Text
+				}
+			}
+			if text != "ok" || fmt.Sprint(maxTokens) != "[2000 1000]" {
+				t.Errorf("text = %q, max_tokens sent = %v, want ok after [2000 1000]", text, maxTokens)
+			}
+		})
+	}
+}
+
+// TestDumpDir tests that requests and raw responses are written to the dump directory
+func TestDumpDir(t *testing.T) {
+	sse := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, sse)
+	}))
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "dumps")
+	client, err := NewClient(&ClientConfig{APIKey: "secret-key", BaseURL: srv.URL, ModelName: "test-model", DumpDir: dir})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hello", genai.RoleUser)}}
+	for _, err := range client.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("dump files = %v, %v; want a request and a response", files, err)
+	}
+	request, _ := os.ReadFile(files[0])
+	response, _ := os.ReadFile(files[1])
+	if !strings.HasSuffix(files[0], "-0001-request.json") || !strings.Contains(string(request), `"content":"hello"`) {
+		t.Errorf("request dump %s = %s", files[0], request)
+	}
+	if strings.Contains(string(request), "secret-key") {
+		t.Errorf("request dump holds the API key")
+	}
+	if !strings.HasSuffix(files[1], "-0001-response-200.sse") || string(response) != sse {
+		t.Errorf("response dump %s = %q, want %q", files[1], response, sse)
+	}
+}
+
+// TestDumpDirSealed tests encrypting the dump files
+func TestDumpDirSealed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"the answer"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	keys, err := envelope.NewLocalKeys(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := envelope.New(&envelope.Config{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model", DumpDir: dir, DumpSealer: sealer})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hello", genai.RoleUser)}}
+	for _, err := range client.GenerateContent(context.Background(), req, false) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil || len(files) != 2 || !strings.HasSuffix(files[0], "-request.json.enc") || !strings.HasSuffix(files[1], "-response-200.json.enc") {
+		t.Fatalf("dump files = %v, %v; want an encrypted request and response", files, err)
+	}
+	for i, want := range []string{`"content":"hello"`, "the answer"} {
+		data, _ := os.ReadFile(files[i])
+		if strings.Contains(string(data), want) {
+			t.Errorf("dump %s is not encrypted", files[i])
+		}
+		plain, err := sealer.Open(context.Background(), DumpTenant, data)
+		if err != nil || !strings.Contains(string(plain), want) {
+			t.Errorf("opened dump %s = %s, %v; want %s", files[i], plain, err, want)
+		}
+	}
+}
+
+// TestCachedTokens tests that DeepSeek and OpenAI prompt cache hits are reported in the usage metadata
+func TestCachedTokens(t *testing.T) {
+	usages := map[string]string{
+		"deepseek": `{"prompt_tokens":100,"completion_tokens":5,"total_tokens":105,"prompt_cache_hit_tokens":64,"prompt_cache_miss_tokens":36}`,
+		"openai":   `{"prompt_tokens":100,"completion_tokens":5,"total_tokens":105,"prompt_tokens_details":{"cached_tokens":64}}`,
+	}
+	for name, usage := range usages {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Stream bool `json:"stream"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if !body.Stream {
+				fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":%s}`, usage)
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprintf(w, "data: {\"choices\":[],\"usage\":%s}\n\n", usage)
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}))
+		defer srv.Close()
+
+		client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", name, stream), func(t *testing.T) {
+				req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+				var final *model.LLMResponse
+				for resp, err := range client.GenerateContent(context.Background(), req, stream) {
+					if err != nil {
+						t.Fatalf("GenerateContent() error = %v", err)
+					}
+					if !resp.Partial {
+						final = resp
+					}
+				}
+				if final == nil || final.UsageMetadata == nil {
+					t.Fatal("no usage metadata")
+				}
+				if u := final.UsageMetadata; u.PromptTokenCount != 100 || u.CachedContentTokenCount != 64 {
+					t.Errorf("usage = %+v, want 100 prompt tokens of which 64 cached", u)
+				}
+			})
+		}
+	}
+}
+
+// TestBuildRequest_CacheControl tests that the system instruction is sent first and large stable prefixes get cache breakpoints
+func TestBuildRequest_CacheControl(t *testing.T) {
+	long := strings.Repeat("You are a careful assistant. ", 500)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{
+			genai.NewContentFromText("first question", genai.RoleUser),
+			genai.NewContentFromText(long, genai.RoleModel),
+			genai.NewContentFromText("second question", genai.RoleUser),
+		},
+		Config: &genai.GenerateContentConfig{SystemInstruction: genai.NewContentFromText(long, genai.RoleUser)},
+	}
+
+	for _, cacheControl := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cache_control=%v", cacheControl), func(t *testing.T) {
+			client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: "http://localhost", ModelName: "anthropic/claude-sonnet-4", CacheControl: cacheControl})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			httpReq, _, err := client.buildRequest(context.Background(), req, false)
+			if err != nil {
+				t.Fatalf("buildRequest() error = %v", err)
+			}
+			var body struct {
+				Messages []struct {
+					Role    string          `json:"role"`
+					Content json.RawMessage `json:"content"`
+				} `json:"messages"`
+			}
+			data, _ := io.ReadAll(httpReq.Body)
+			json.Unmarshal(data, &body)
+
+			if len(body.Messages) != 4 || body.Messages[0].Role != "system" {
+				t.Fatalf("messages = %s, want the system instruction first", data)
+			}
+			var marked []int
+			for i, m := range body.Messages {
+				if strings.Contains(string(m.Content), `"cache_control":{"type":"ephemeral"}`) {
+					marked = append(marked, i)
+				}
+			}
+			want := []int{0, 2}
+			if !cacheControl {
+				want = nil
+			}
+			if fmt.Sprint(marked) != fmt.Sprint(want) {
+				t.Errorf("breakpoints on messages %v, want %v", marked, want)
+			}
+		})
+	}
+}
+
+// TestPooledBody tests that a pooled request body can be re-read for a redirect and is released once unused
+func TestPooledBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/moved/v1/chat/completions" {
+			http.Redirect(w, r, "/moved"+r.URL.Path, http.StatusTemporaryRedirect)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(data), `"model":"test-model"`) {
+			http.Error(w, "bad body: "+string(data), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	for _, err := range client.GenerateContent(context.Background(), req, false) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+	}
+
+	body, err := encodeBody(map[string]any{"a": 1})
+	if err != nil {
+		t.Fatalf("encodeBody() error = %v", err)
+	}
+	r := body.reader()
+	again := body.reader()
+	releaseBody(r)
+	r.Close()
+	r.Close()
+	if got := body.refs.Load(); got != 1 {
+		t.Errorf("refs = %d with one open reader, want 1", got)
+	}
+	again.Close()
+	if got := body.refs.Load(); got != 0 {
+		t.Errorf("refs = %d once every reader is closed, want 0", got)
+	}
+}
+
+// TestMaxConcurrentRequests tests that requests over the limit queue for a slot and time out in the queue
+func TestMaxConcurrentRequests(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-unblock
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model", MaxConcurrentRequests: 2})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	generate := func(ctx context.Context) error {
+		for _, err := range client.GenerateContent(ctx, req, false) {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Go(func() { errs <- generate(context.Background()) })
+	}
+	for client.InFlight() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A request with a queue timeout gives up while the slots are taken
+	queued, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model", MaxConcurrentRequests: 1, QueueTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	queued.slots <- struct{}{}
+	for _, err := range queued.GenerateContent(context.Background(), req, false) {
+		if !errors.Is(err, ErrQueueTimeout) {
+			t.Errorf("GenerateContent() error = %v, want ErrQueueTimeout", err)
+		}
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GenerateContent() error = %v", err)
+		}
+	}
+	if p := peak.Load(); p != 2 {
+		t.Errorf("peak concurrent requests = %d, want 2", p)
+	}
+	if n := client.InFlight(); n != 0 {
+		t.Errorf("%d slots still held after the requests", n)
+	}
+}
+
+// TestCompression tests gzipped request bodies and gzip and deflate responses
+func TestCompression(t *testing.T) {
+	const answer = `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`
+	for _, encoding := range []string{"gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Encoding") != "gzip" {
+					http.Error(w, "request not compressed", http.StatusBadRequest)
+					return
+				}
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				var body map[string]any
+				if err := json.NewDecoder(zr).Decode(&body); err != nil || body["model"] != "test-model" {
+					http.Error(w, "bad body", http.StatusBadRequest)
+					return
+				}
+				if !strings.Contains(r.Header.Get("Accept-Encoding"), encoding) {
+					http.Error(w, "encoding not accepted", http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Encoding", encoding)
+				var zw io.WriteCloser = gzip.NewWriter(w)
+				if encoding == "deflate" {
+					zw = zlib.NewWriter(w)
+				}
+				io.WriteString(zw, answer)
+				zw.Close()
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model", CompressRequests: true})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText(strings.Repeat("long prompt ", 200), genai.RoleUser)}}
+			var final *model.LLMResponse
+			for resp, err := range client.GenerateContent(context.Background(), req, false) {
+				if err != nil {
+					t.Fatalf("GenerateContent() error = %v", err)
+				}
+				final = resp
+			}
+			if final == nil || final.Content.Parts[0].Text != "hi" {
+				t.Errorf("final response = %+v, want hi", final)
+			}
+		})
+	}
+}
+
+// TestCandidates tests that n is forwarded and every choice is returned as a
+// candidate, with streaming turned off
+func TestCandidates(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprint(w, `{"choices":[`+
+			`{"index":2,"message":{"role":"assistant","content":null,"refusal":"no"},"finish_reason":"stop"},`+
+			`{"index":1,"message":{"role":"assistant","content":"second"},"finish_reason":"length"},`+
+			`{"index":0,"message":{"role":"assistant","content":"first"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{BaseURL: srv.URL, APIKey: "test", ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+		Config:   &genai.GenerateContentConfig{CandidateCount: 3},
+	}
+	var resps []*model.LLMResponse
+	for resp, err := range client.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		resps = append(resps, resp)
+	}
+
+	if body["n"] != 3.0 || body["stream"] != false {
+		t.Errorf("n = %v, stream = %v, want 3 without streaming", body["n"], body["stream"])
+	}
+	if len(resps) != 1 || resps[0].Content.Parts[0].Text != "first" {
+		t.Fatalf("responses = %+v, want the first choice", resps)
+	}
+	candidates := Candidates(resps[0])
+	if len(candidates) != 2 || candidates[0].Content.Parts[0].Text != "first" || candidates[1].Content.Parts[0].Text != "second" ||
+		candidates[1].FinishReason != "length" {
+		t.Errorf("candidates = %+v, want first and second without the refused one", candidates)
+	}
+
+	single := &model.LLMResponse{Content: genai.NewContentFromText("only", genai.RoleModel)}
+	if got := Candidates(single); len(got) != 1 || got[0].Content != single.Content {
+		t.Errorf("Candidates() of a single response = %+v", got)
+	}
+}
+
+// TestErrorKinds tests the classification of error responses and their retryability
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		header        string // Retry-After
+		body          string
+		want          error
+		wantRetryable bool
+		wantAfter     time.Duration
+	}{
+		{"auth", 401, "", `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`, ErrAuth, false, 0},
+		{"forbidden", 403, "", `{"error":{"message":"no access to model"}}`, ErrAuth, false, 0},
+		{"rate limit", 429, "7", `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`, ErrRateLimit, true, 7 * time.Second},
+		{"quota", 429, "", `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`, ErrRateLimit, false, 0},
+		{"context length", 400, "", `{"error":{"message":"This model's maximum context length is 8192 tokens.","code":"context_length_exceeded"}}`, ErrContextLength, false, 0},
+		{"content filter", 400, "", `{"error":{"message":"filtered","code":"content_filter"}}`, ErrContentFilter, false, 0},
+		{"server", 503, "2", `{"error":{"message":"overloaded","code":503}}`, ErrServer, true, 2 * time.Second},
+		{"bad request", 400, "", `{"error":{"message":"Invalid value for temperature"}}`, nil, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			_, err = client.ListModels(context.Background())
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("ListModels() error = %v, want an API error %d", err, tt.status)
+			}
+			for _, kind := range []error{ErrAuth, ErrRateLimit, ErrContextLength, ErrContentFilter, ErrServer, ErrNetwork} {
+				if errors.Is(err, kind) != (kind == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, kind, !(kind == tt.want))
+				}
+			}
+			if got := Retryable(err); got != tt.wantRetryable {
+				t.Errorf("Retryable() = %v, want %v", got, tt.wantRetryable)
+			}
+			if got := RetryAfter(err); got != tt.wantAfter {
+				t.Errorf("RetryAfter() = %v, want %v", got, tt.wantAfter)
+			}
+		})
+	}
+
+	var contextLength *ContextLengthError
+	if err := classify(parseAPIError(400, []byte(`{"error":{"message":"This model's maximum context length is 8192 tokens."}}`)), nil); !errors.As(err, &contextLength) || contextLength.Window != 8192 {
+		t.Errorf("classify() = %v, want a context length error with an 8192 window", err)
+	}
+	if err := parseStreamError(http.StatusOK, `{"error":{"message":"Overloaded","type":"overloaded_error"}}`); !errors.Is(err, ErrServer) || !Retryable(err) {
+		t.Errorf("parseStreamError() = %v, want a retryable server error", err)
+	}
+
+	// A request without a response is a network error
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	client, _ := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	var network *NetworkError
+	if _, err := client.ListModels(context.Background()); !errors.As(err, &network) || !errors.Is(err, ErrNetwork) || !Retryable(err) {
+		t.Errorf("ListModels() error = %v, want a retryable network error", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.ListModels(ctx); !errors.Is(err, ErrNetwork) || Retryable(err) {
+		t.Errorf("ListModels() error = %v, want a network error that is not retryable", err)
+	}
+}
+
+// TestCircuitBreaker tests that a persistently failing endpoint fails fast
+// until a probe succeeds
+func TestCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var hits atomic.Int32
+	down.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, `{"error":{"message":"upstream unavailable"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model",
+		CircuitBreaker: &BreakerConfig{MinRequests: 3, OpenDuration: time.Minute}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+	call := func() error {
+		req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+		var last error
+		for _, err := range client.GenerateContent(context.Background(), req, false) {
+			last = err
+		}
+		return last
+	}
+
+	for range 3 {
+		if err := call(); !errors.Is(err, ErrServer) {
+			t.Fatalf("GenerateContent() error = %v, want a server error", err)
+		}
+	}
+	if err := call(); !errors.Is(err, ErrCircuitOpen) || hits.Load() != 3 {
+		t.Fatalf("GenerateContent() error = %v after %d requests, want an open circuit after 3", err, hits.Load())
+	}
+
+	// A failed probe opens the circuit again
+	now = now.Add(time.Minute)
+	if err := call(); !errors.Is(err, ErrServer) || hits.Load() != 4 {
+		t.Fatalf("probe error = %v after %d requests, want a server error", err, hits.Load())
+	}
+	if err := call(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GenerateContent() error = %v, want an open circuit", err)
+	}
+
+	// A successful probe closes it
+	down.Store(false)
+	now = now.Add(time.Minute)
+	if err := call(); err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	status := client.breaker.Status()
+	if status.State != CircuitClosed || status.Opens != 2 || status.Rejected != 2 {
+		t.Errorf("status = %+v, want closed after opening twice and rejecting 2 requests", status)
+	}
+	if !slices.ContainsFunc(Breakers(), func(s BreakerStatus) bool { return s.Endpoint == srv.URL }) {
+		t.Errorf("Breakers() = %+v, want the endpoint of the client", Breakers())
+	}
+}
+
+// TestModerate tests the moderations request and merging per-part results
+func TestModerate(t *testing.T) {
+	var body map[string]any
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprint(w, `{"id":"modr-1","model":"omni-moderation-latest","results":[`+
+			`{"flagged":false,"categories":{"violence":false,"harassment":false},"category_scores":{"violence":0.2,"harassment":0.01}},`+
+			`{"flagged":true,"categories":{"violence":false,"harassment":true},"category_scores":{"violence":0.1,"harassment":0.8}}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "omni-moderation-latest"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	result, err := client.Moderate(context.Background(), "some text")
+	if err != nil {
+		t.Fatalf("Moderate() error = %v", err)
+	}
+	if path != "/v1/moderations" || body["input"] != "some text" || body["model"] != "omni-moderation-latest" {
+		t.Errorf("request = %s %v", path, body)
+	}
+	if !result.Flagged || !result.Categories["harassment"] || result.Categories["violence"] ||
+		result.Scores["violence"] != 0.2 || result.Scores["harassment"] != 0.8 {
+		t.Errorf("Moderate() = %+v, want the merged results", result)
+	}
+}
+
+func TestSpeak(t *testing.T) {
+	var body map[string]any
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "audio/ogg")
+		w.Write([]byte("OggS audio"))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "gpt-4o-mini-tts"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	voice, err := NewVoice(client, &SpeechConfig{Voice: "nova", Format: "opus", Speed: 1.5})
+	if err != nil {
+		t.Fatalf("NewVoice() error = %v", err)
+	}
+	speech, err := voice.Speak(context.Background(), "  Hello there.  ")
+	if err != nil {
+		t.Fatalf("Speak() error = %v", err)
+	}
+	if path != "/v1/audio/speech" || body["input"] != "Hello there." || body["voice"] != "nova" ||
+		body["response_format"] != "opus" || body["speed"] != 1.5 || body["model"] != "gpt-4o-mini-tts" {
+		t.Errorf("request = %s %v", path, body)
+	}
+	if string(speech.Audio) != "OggS audio" || speech.Format != "opus" || speech.ContentType != "audio/ogg" {
+		t.Errorf("Speak() = %+v", speech)
+	}
+
+	long := strings.Repeat("A sentence. ", 400) + strings.Repeat("x", 100)
+	if _, err := client.Speak(context.Background(), long, nil); err != nil {
+		t.Fatalf("Speak() error = %v", err)
+	}
+	if input := body["input"].(string); len(input) > maxSpeechInput || !strings.HasSuffix(input, ".") {
+		t.Errorf("long input sent as %d characters ending in %q, want it cut at a sentence end", len(input), input[len(input)-5:])
+	}
+	if body["voice"] != "alloy" || body["response_format"] != "mp3" {
+		t.Errorf("defaults = %v", body)
+	}
+
+	for _, cfg := range []*SpeechConfig{{Format: "ogg"}, {Speed: 5}} {
+		if _, err := NewVoice(client, cfg); err == nil {
+			t.Errorf("NewVoice(%+v) error = nil, want an error", cfg)
+		}
+	}
+}
+
+func TestGenerateImages(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n image")
+	var body map[string]any
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprintf(w, `{"created":1,"data":[{"url":"https://img.example.com/1.png","revised_prompt":"A red cat"},{"b64_json":%q}]}`,
+			base64.StdEncoding.EncodeToString(png))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "dall-e-3"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	images, err := client.GenerateImages(context.Background(), &ImageRequest{Prompt: "a cat", N: 2, Size: "1024x1024", Quality: "hd"})
+	if err != nil {
+		t.Fatalf("GenerateImages() error = %v", err)
+	}
+	if path != "/v1/images/generations" || body["prompt"] != "a cat" || body["n"] != 2.0 || body["size"] != "1024x1024" ||
+		body["quality"] != "hd" || body["model"] != "dall-e-3" || body["response_format"] != nil {
+		t.Errorf("request = %s %v", path, body)
+	}
+	if len(images) != 2 || images[0].URL != "https://img.example.com/1.png" || images[0].RevisedPrompt != "A red cat" ||
+		!bytes.Equal(images[1].Data, png) || images[1].MIMEType != "image/png" {
+		t.Errorf("GenerateImages() = %+v", images)
+	}
+
+	if _, err := client.GenerateImages(context.Background(), &ImageRequest{Prompt: " "}); err == nil {
+		t.Error("GenerateImages() without a prompt error = nil, want an error")
+	}
+}
@@ -0,0 +1,106 @@
+package openai_compatible
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest request body worth compressing
+const compressMinSize = 1024
+
+// acceptEncoding is sent with non-streaming requests, whose responses are
+// decompressed by decodeResponse. Streams keep the transport's own gzip
+// handling.
+const acceptEncoding = "gzip, deflate"
+
+// gzipWriters holds gzip writers for request bodies
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// compressBody returns the gzip compression of a body in a pooled buffer
+func compressBody(b *pooledBuffer) (*pooledBuffer, error) {
+	out := &pooledBuffer{buf: getBuffer()}
+	out.buf.Reset()
+	out.refs.Store(1)
+
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(out.buf)
+	if _, err := zw.Write(b.buf.Bytes()); err != nil {
+		out.release()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		out.release()
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeResponse replaces a gzip or deflate encoded response body with its
+// decompressed content
+func decodeResponse(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("failed to decompress response: %w", err)
+		}
+		body = &decodedBody{Reader: zr, body: resp.Body}
+	case "deflate":
+		r, err := deflateReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("failed to decompress response: %w", err)
+		}
+		body = &decodedBody{Reader: r, body: resp.Body}
+	default:
+		return nil
+	}
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// deflateReader reads an HTTP deflate body. The spec says zlib, but some
+// servers send a raw deflate stream.
+func deflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodedBody reads the decompressed content of a response body
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	if c, ok := b.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,69 @@
+package openai_compatible
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrQueueTimeout is returned when a request waited longer than the queue
+// timeout for one of the client's request slots
+var ErrQueueTimeout = errors.New("timed out waiting for a request slot")
+
+// acquire waits for a request slot and returns the function releasing it.
+// Without a concurrency limit it returns at once.
+func (c *Client) acquire(ctx context.Context) (func(), error) {
+	if c.slots == nil {
+		return func() {}, nil
+	}
+	release := sync.OnceFunc(func() { <-c.slots })
+	select {
+	case c.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	// All slots are taken: queue until one frees up
+	start := time.Now()
+	c.logger.DebugContext(ctx, "Waiting for a request slot", "max_concurrent_requests", cap(c.slots))
+	var timeout <-chan time.Time
+	if c.queueTimeout > 0 {
+		timer := time.NewTimer(c.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case c.slots <- struct{}{}:
+		c.logger.DebugContext(ctx, "Acquired a request slot", "waited", time.Since(start))
+		return release, nil
+	case <-timeout:
+		c.logger.WarnContext(ctx, "Timed out waiting for a request slot",
+			"max_concurrent_requests", cap(c.slots),
+			"queue_timeout", c.queueTimeout,
+		)
+		return nil, fmt.Errorf("%w after %s (%d concurrent requests)", ErrQueueTimeout, c.queueTimeout, cap(c.slots))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// InFlight returns the number of requests holding a slot, 0 without a
+// concurrency limit
+func (c *Client) InFlight() int {
+	return len(c.slots)
+}
+
+// releasingBody releases a request slot when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
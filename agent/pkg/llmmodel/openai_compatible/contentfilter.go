@@ -0,0 +1,56 @@
+package openai_compatible
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+)
+
+// filterResults are the content filter verdicts of Azure OpenAI, by category
+// (hate, sexual, violence, self_harm, jailbreak, ...). Values are decoded
+// one by one, since some categories are lists rather than verdicts.
+type filterResults map[string]json.RawMessage
+
+// filtered returns the sorted categories whose content was filtered
+func (r filterResults) filtered() []string {
+	var categories []string
+	for category, raw := range r {
+		var verdict struct {
+			Filtered bool `json:"filtered"`
+		}
+		if json.Unmarshal(raw, &verdict) == nil && verdict.Filtered {
+			categories = append(categories, category)
+		}
+	}
+	slices.Sort(categories)
+	return categories
+}
+
+// contentFilterError returns the *ResponseRefused of an error response
+// rejecting the prompt with a content filter, as Azure OpenAI does with a
+// content_filter code, or apiErr itself for other errors
+func contentFilterError(apiErr *APIError) error {
+	var body struct {
+		Error struct {
+			Code       any `json:"code"`
+			InnerError struct {
+				Code                any           `json:"code"`
+				ContentFilterResult filterResults `json:"content_filter_result"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(apiErr.Body), &body) != nil {
+		return apiErr
+	}
+	inner := body.Error.InnerError
+	if body.Error.Code != "content_filter" && inner.Code != "ResponsibleAIPolicyViolation" {
+		return apiErr
+	}
+	return &ResponseRefused{
+		Reason:       cmp.Or(apiErr.Message, "content_filter"),
+		FinishReason: "content_filter",
+		Categories:   inner.ContentFilterResult.filtered(),
+		Prompt:       true,
+		Err:          apiErr,
+	}
+}
@@ -0,0 +1,214 @@
+package openai_compatible
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+const (
+	// messageTokens is the per-message overhead of the chat format (role and separators)
+	messageTokens = 4
+	// mediaPartTokens is charged for each image, audio or file part, whose
+	// encoded size says little about its token cost (a 1024x1024 image is 765
+	// tokens at OpenAI's high detail)
+	mediaPartTokens = 765
+)
+
+// estimateTokens estimates the prompt tokens of messages and tool definitions
+func (c *Client) estimateTokens(messages, tools []map[string]any) int {
+	total := 3 // Every reply is primed with the assistant role
+	for _, m := range messages {
+		total += c.messageTokens(m)
+	}
+	if len(tools) > 0 {
+		data, _ := json.Marshal(tools)
+		total += c.tokenizer.Count(string(data))
+	}
+	return total
+}
+
+// messageTokens estimates the tokens of one chat message
+func (c *Client) messageTokens(m map[string]any) int {
+	total := messageTokens
+	for key, value := range m {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			if key != "role" {
+				total += c.tokenizer.Count(v)
+			}
+		case []map[string]any:
+			for _, part := range v {
+				if text, ok := part["text"].(string); ok {
+					total += c.tokenizer.Count(text)
+				} else {
+					total += mediaPartTokens
+				}
+			}
+		default:
+			data, _ := json.Marshal(v)
+			total += c.tokenizer.Count(string(data))
+		}
+	}
+	return total
+}
+
+// fitContext checks the estimated prompt size against the context window,
+// leaving room for maxOutput tokens. When the prompt does not fit it logs a
+// warning and, with history truncation enabled, drops the oldest turns. A
+// turn starts at a user message, so tool calls are never separated from their
+// results; system messages and the latest turn are always kept. It returns the
+// messages to send and their estimated tokens.
+func (c *Client) fitContext(messages, tools []map[string]any, maxOutput int) ([]map[string]any, int) {
+	estimated := c.estimateTokens(messages, tools)
+	budget := c.contextWindow - maxOutput
+	if c.contextWindow <= 0 || estimated <= budget {
+		return messages, estimated
+	}
+
+	c.logger.Warn("Prompt may exceed the context window",
+		"estimated_tokens", estimated,
+		"context_window", c.contextWindow,
+		"max_output_tokens", maxOutput,
+		"tokenizer", c.tokenizer.Name(),
+	)
+	if !c.truncateHistory {
+		return messages, estimated
+	}
+
+	var system, rest []map[string]any
+	for _, m := range messages {
+		if m["role"] == "system" {
+			system = append(system, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	fixed := estimated
+	for _, m := range rest {
+		fixed -= c.messageTokens(m)
+	}
+
+	// Sizes of the suffixes of rest, to find the longest one that fits
+	suffix := make([]int, len(rest)+1)
+	for i := len(rest) - 1; i >= 0; i-- {
+		suffix[i] = suffix[i+1] + c.messageTokens(rest[i])
+	}
+
+	cut := -1
+	for i, m := range rest {
+		if m["role"] != "user" {
+			continue
+		}
+		cut = i
+		if fixed+suffix[i] <= budget {
+			break
+		}
+	}
+	if cut <= 0 {
+		return messages, estimated
+	}
+
+	truncated := append(system, rest[cut:]...)
+	kept := fixed + suffix[cut]
+	if kept > budget {
+		c.logger.Warn("Latest turn alone exceeds the context window", "estimated_tokens", kept, "context_window", c.contextWindow)
+	}
+	c.logger.Info("Truncated conversation history to fit the context window",
+		"dropped_messages", cut,
+		"estimated_tokens", kept,
+	)
+	return truncated, kept
+}
+
+// Patterns of the context overflow errors of OpenAI, vLLM, DeepSeek,
+// OpenRouter, TGI (Together, Hugging Face) and Mistral
+var (
+	overflowWindow = []*regexp.Regexp{
+		regexp.MustCompile(`maximum context length is (\d+)`),
+		regexp.MustCompile(`(\d+) maximum context length`),
+		regexp.MustCompile("`max_new_tokens` must be <= (\\d+)"),
+		regexp.MustCompile(`context (?:window|length) (?:of|is) (\d+)`),
+	}
+	overflowPrompt = []*regexp.Regexp{
+		regexp.MustCompile(`\((\d+) in the messages`),
+		regexp.MustCompile(`request has (\d+) input tokens`),
+		regexp.MustCompile("Given: (\\d+) `inputs` tokens"),
+		regexp.MustCompile(`[Pp]rompt contains (\d+) tokens`),
+	}
+	overflowRequested = regexp.MustCompile(`you requested (?:about )?(\d+) tokens`)
+	overflowOutput    = regexp.MustCompile(`(\d+) in the (?:completion|output)`)
+)
+
+// firstNumber returns the number captured by the first matching pattern, 0 when none matches
+func firstNumber(s string, patterns ...*regexp.Regexp) int {
+	for _, p := range patterns {
+		if m := p.FindStringSubmatch(s); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// reduceMaxTokens returns req with max_tokens lowered to what fits next to
+// the prompt when err is a provider rejecting the request because prompt and
+// max_tokens exceed the context window. It returns false for other errors and
+// when the prompt alone fills the window.
+func (c *Client) reduceMaxTokens(req *model.LLMRequest, err error) (*model.LLMRequest, bool) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return nil, false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+	default:
+		return nil, false
+	}
+	message := apiErr.Message + " " + apiErr.Body
+	window := firstNumber(message, overflowWindow...)
+	if window == 0 {
+		return nil, false
+	}
+
+	var current int
+	if req.Config != nil {
+		current = int(req.Config.MaxOutputTokens)
+	}
+	prompt := firstNumber(message, overflowPrompt...)
+	if requested := firstNumber(message, overflowRequested); prompt == 0 && requested > 0 {
+		if output := firstNumber(message, overflowOutput); output > 0 {
+			prompt = requested - output
+		} else if current > 0 {
+			prompt = requested - current
+		}
+	}
+	if prompt <= 0 {
+		return nil, false
+	}
+	feasible := window - prompt
+	if feasible <= 0 || (current > 0 && feasible >= current) {
+		return nil, false
+	}
+
+	c.logger.Warn("Reducing max_tokens to fit the context window and retrying",
+		"max_tokens", current,
+		"reduced_max_tokens", feasible,
+		"prompt_tokens", prompt,
+		"context_window", window,
+	)
+	config := genai.GenerateContentConfig{}
+	if req.Config != nil {
+		config = *req.Config
+	}
+	config.MaxOutputTokens = int32(feasible)
+	reduced := *req
+	reduced.Config = &config
+	return &reduced, true
+}
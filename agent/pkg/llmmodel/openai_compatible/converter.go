@@ -1,14 +1,26 @@
 package openai_compatible
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 
 	"google.golang.org/genai"
 )
 
-// ConvertContentsToMessages converts genai.Content to OpenAI message format
+// ConvertContentsToMessages converts genai.Content to OpenAI message format.
+// A content with a single text part becomes a plain string; a content with
+// several parts or non-text parts becomes an OpenAI content array so the part
+// structure is preserved. System messages are always joined into a string since
+// most providers only accept text there. Function calls become assistant
+// tool_calls and function responses become tool messages.
 func ConvertContentsToMessages(contents []*genai.Content) ([]map[string]any, error) {
+	return convertContents(contents, nil)
+}
+
+// convertContents converts contents using names to translate tool and argument names
+func convertContents(contents []*genai.Content, names *toolNames) ([]map[string]any, error) {
 	messages := make([]map[string]any, 0, len(contents))
 
 	for _, content := range contents {
@@ -24,25 +36,188 @@ func ConvertContentsToMessages(contents []*genai.Content) ([]map[string]any, err
 			role = "system"
 		}
 
-		// Extract text from parts
+		parts := make([]map[string]any, 0, len(content.Parts))
 		var textParts []string
+		var toolCalls []map[string]any
+		var toolMessages []map[string]any
+		textOnly := true
 		for _, part := range content.Parts {
-			if part != nil && part.Text != "" {
+			if part == nil {
+				continue
+			}
+			if part.FunctionCall != nil {
+				toolCall, err := convertFunctionCall(part.FunctionCall, names)
+				if err != nil {
+					return nil, err
+				}
+				toolCalls = append(toolCalls, toolCall)
+				continue
+			}
+			if part.FunctionResponse != nil {
+				toolMessage, err := convertFunctionResponse(part.FunctionResponse)
+				if err != nil {
+					return nil, err
+				}
+				toolMessages = append(toolMessages, toolMessage)
+				continue
+			}
+			converted, err := convertPart(part)
+			if err != nil {
+				return nil, err
+			}
+			if converted == nil {
+				continue
+			}
+			if part.Text != "" {
 				textParts = append(textParts, part.Text)
+			} else {
+				textOnly = false
 			}
+			parts = append(parts, converted)
 		}
 
-		if len(textParts) > 0 {
-			messages = append(messages, map[string]any{
-				"role":    role,
-				"content": strings.Join(textParts, "\n"),
-			})
+		// Tool results must directly follow the assistant message that requested them
+		messages = append(messages, toolMessages...)
+
+		if len(toolCalls) > 0 {
+			message := map[string]any{
+				"role":       "assistant",
+				"content":    nil,
+				"tool_calls": toolCalls,
+			}
+			if len(textParts) > 0 {
+				message["content"] = strings.Join(textParts, "\n")
+			}
+			messages = append(messages, message)
+			continue
 		}
+
+		if len(parts) == 0 || (role == "system" && len(textParts) == 0) {
+			continue
+		}
+
+		var messageContent any
+		switch {
+		case role == "system" || (textOnly && len(textParts) == 1):
+			messageContent = strings.Join(textParts, "\n")
+		default:
+			messageContent = parts
+		}
+
+		messages = append(messages, map[string]any{
+			"role":    role,
+			"content": messageContent,
+		})
 	}
 
 	return messages, nil
 }
 
+// systemMessage returns the system message carrying the system instruction
+// of config, nil when there is none. ADK puts the agent instruction there
+// rather than in the contents, so the message is prepended to every request.
+func systemMessage(config *genai.GenerateContentConfig) map[string]any {
+	if config == nil || config.SystemInstruction == nil {
+		return nil
+	}
+	var parts []string
+	for _, part := range config.SystemInstruction.Parts {
+		if part != nil && part.Text != "" {
+			parts = append(parts, part.Text)
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return map[string]any{"role": "system", "content": strings.Join(parts, "\n\n")}
+}
+
+// audioFormats maps audio MIME types to the formats of input_audio parts
+var audioFormats = map[string]string{
+	"audio/wav":      "wav",
+	"audio/wave":     "wav",
+	"audio/x-wav":    "wav",
+	"audio/vnd.wave": "wav",
+	"audio/mpeg":     "mp3",
+	"audio/mp3":      "mp3",
+	"audio/mpeg3":    "mp3",
+	"audio/x-mpeg-3": "mp3",
+	"audio/x-mp3":    "mp3",
+}
+
+// convertPart converts a single genai.Part to an OpenAI content array item,
+// returning nil for parts that have no OpenAI content representation. Audio
+// other than WAV and MP3, and files referenced by a URI that is not an
+// uploaded file ID, have none in the API and fail with ErrUnsupportedInput.
+func convertPart(part *genai.Part) (map[string]any, error) {
+	switch {
+	case part.Text != "":
+		return map[string]any{
+			"type": "text",
+			"text": part.Text,
+		}, nil
+	case part.InlineData != nil && len(part.InlineData.Data) > 0:
+		mimeType := part.InlineData.MIMEType
+		encoded := base64.StdEncoding.EncodeToString(part.InlineData.Data)
+		switch {
+		case strings.HasPrefix(mimeType, "image/"):
+			return map[string]any{
+				"type": "image_url",
+				"image_url": map[string]any{
+					"url": "data:" + mimeType + ";base64," + encoded,
+				},
+			}, nil
+		case strings.HasPrefix(mediaType(mimeType), "audio/"):
+			format, ok := audioFormats[mediaType(mimeType)]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s audio (input audio must be WAV or MP3)", ErrUnsupportedInput, mimeType)
+			}
+			return map[string]any{
+				"type": "input_audio",
+				"input_audio": map[string]any{
+					"data":   encoded,
+					"format": format,
+				},
+			}, nil
+		default:
+			return map[string]any{
+				"type": "file",
+				"file": map[string]any{
+					"file_data": "data:" + mimeType + ";base64," + encoded,
+				},
+			}, nil
+		}
+	case part.FileData != nil && part.FileData.FileURI != "":
+		uri := part.FileData.FileURI
+		if strings.HasPrefix(part.FileData.MIMEType, "image/") || part.FileData.MIMEType == "" {
+			return map[string]any{
+				"type": "image_url",
+				"image_url": map[string]any{
+					"url": uri,
+				},
+			}, nil
+		}
+		// Files other than images can only be referenced by the ID of an upload
+		if !strings.HasPrefix(uri, "file-") {
+			return nil, fmt.Errorf("%w: %s file %s is not an uploaded file ID (file-...)", ErrUnsupportedInput, part.FileData.MIMEType, uri)
+		}
+		return map[string]any{
+			"type": "file",
+			"file": map[string]any{
+				"file_id": uri,
+			},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// mediaType returns the lower-case media type of a MIME type, without parameters
+func mediaType(mimeType string) string {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
 // ConvertToolsToOpenAIFormat converts ADK tools to OpenAI tool format
 // The input is map[string]any as defined in model.LLMRequest
 func ConvertToolsToOpenAIFormat(tools map[string]any) ([]map[string]any, error) {
@@ -110,20 +285,141 @@ func ConvertToolsToOpenAIFormat(tools map[string]any) ([]map[string]any, error)
 	return openAITools, nil
 }
 
+// ConvertFunctionDeclarations converts the function declarations that ADK packs
+// into GenerateContentConfig.Tools to OpenAI tool format. Both genai.Schema
+// parameters and raw JSON schema parameters (used by function tools) are supported.
+func ConvertFunctionDeclarations(tools []*genai.Tool) ([]map[string]any, error) {
+	var openAITools []map[string]any
+
+	for _, tool := range tools {
+		if tool == nil {
+			continue
+		}
+		for _, funcDecl := range tool.FunctionDeclarations {
+			if funcDecl == nil {
+				continue
+			}
+
+			function := map[string]any{
+				"name":        funcDecl.Name,
+				"description": funcDecl.Description,
+			}
+
+			switch {
+			case funcDecl.Parameters != nil:
+				params, err := convertSchema(funcDecl.Parameters)
+				if err != nil {
+					return nil, fmt.Errorf("failed to convert parameters for tool %s: %w", funcDecl.Name, err)
+				}
+				function["parameters"] = params
+			case funcDecl.ParametersJsonSchema != nil:
+				params, err := convertJSONSchema(funcDecl.ParametersJsonSchema)
+				if err != nil {
+					return nil, fmt.Errorf("failed to convert parameters for tool %s: %w", funcDecl.Name, err)
+				}
+				function["parameters"] = params
+			}
+
+			openAITools = append(openAITools, map[string]any{
+				"type":     "function",
+				"function": function,
+			})
+		}
+	}
+
+	return openAITools, nil
+}
+
+// convertJSONSchema converts an arbitrary JSON schema value to a generic map
+func convertJSONSchema(schema any) (map[string]any, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON schema: %w", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON schema: %w", err)
+	}
+	return result, nil
+}
+
 // convertSchema converts genai.Schema to OpenAI parameter schema format
 func convertSchema(schema *genai.Schema) (map[string]any, error) {
 	if schema == nil {
 		return map[string]any{"type": "object", "properties": map[string]any{}}, nil
 	}
 
-	result := map[string]any{
-		"type": convertType(schema.Type),
+	result := map[string]any{}
+
+	// A schema that only combines alternatives carries no type of its own
+	if (schema.Type != "" && schema.Type != genai.TypeUnspecified) || len(schema.AnyOf) == 0 {
+		typeName := convertType(schema.Type)
+		if schema.Nullable != nil && *schema.Nullable {
+			result["type"] = []string{typeName, "null"}
+		} else {
+			result["type"] = typeName
+		}
+	}
+
+	if schema.Title != "" {
+		result["title"] = schema.Title
 	}
 
 	if schema.Description != "" {
 		result["description"] = schema.Description
 	}
 
+	if schema.Format != "" {
+		result["format"] = schema.Format
+	}
+
+	if schema.Pattern != "" {
+		result["pattern"] = schema.Pattern
+	}
+
+	if schema.Default != nil {
+		result["default"] = schema.Default
+	}
+
+	// Handle numeric and size bounds
+	if schema.Minimum != nil {
+		result["minimum"] = *schema.Minimum
+	}
+	if schema.Maximum != nil {
+		result["maximum"] = *schema.Maximum
+	}
+	if schema.MinLength != nil {
+		result["minLength"] = *schema.MinLength
+	}
+	if schema.MaxLength != nil {
+		result["maxLength"] = *schema.MaxLength
+	}
+	if schema.MinItems != nil {
+		result["minItems"] = *schema.MinItems
+	}
+	if schema.MaxItems != nil {
+		result["maxItems"] = *schema.MaxItems
+	}
+	if schema.MinProperties != nil {
+		result["minProperties"] = *schema.MinProperties
+	}
+	if schema.MaxProperties != nil {
+		result["maxProperties"] = *schema.MaxProperties
+	}
+
+	// Handle alternatives
+	if len(schema.AnyOf) > 0 {
+		anyOf := make([]map[string]any, 0, len(schema.AnyOf))
+		for i, alt := range schema.AnyOf {
+			altSchema, err := convertSchema(alt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert anyOf[%d]: %w", i, err)
+			}
+			anyOf = append(anyOf, altSchema)
+		}
+		result["anyOf"] = anyOf
+	}
+
 	// Handle object properties
 	if schema.Properties != nil && len(schema.Properties) > 0 {
 		properties := make(map[string]any)
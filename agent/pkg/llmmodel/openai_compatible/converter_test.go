@@ -1,6 +1,7 @@
 package openai_compatible
 
 import (
+	"errors"
 	"testing"
 
 	"google.golang.org/genai"
@@ -167,3 +168,144 @@ func TestConvertContentsToMessages_EmptyParts(t *testing.T) {
 		t.Errorf("Expected 0 messages, got %d", len(messages))
 	}
 }
+
+// TestConvertContentsToMessages_MultiPart tests that multi-part and non-text contents become content arrays
+func TestConvertContentsToMessages_MultiPart(t *testing.T) {
+	contents := []*genai.Content{
+		{
+			Role: genai.RoleUser,
+			Parts: []*genai.Part{
+				{Text: "Describe this image"},
+				{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte{0x89, 0x50}}},
+			},
+		},
+		{
+			Role: genai.RoleUser,
+			Parts: []*genai.Part{
+				{Text: "first"},
+				{Text: "second"},
+			},
+		},
+		{
+			Role: "system",
+			Parts: []*genai.Part{
+				{Text: "line one"},
+				{Text: "line two"},
+			},
+		},
+	}
+
+	messages, err := ConvertContentsToMessages(contents)
+	if err != nil {
+		t.Fatalf("ConvertContentsToMessages() error = %v", err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+
+	image, ok := messages[0]["content"].([]map[string]any)
+	if !ok || len(image) != 2 {
+		t.Fatalf("Message 0: expected 2-item content array, got %v", messages[0]["content"])
+	}
+	if image[0]["type"] != "text" || image[1]["type"] != "image_url" {
+		t.Errorf("Message 0: unexpected part types %v, %v", image[0]["type"], image[1]["type"])
+	}
+	if url := image[1]["image_url"].(map[string]any)["url"]; url != "data:image/png;base64,iVA=" {
+		t.Errorf("Message 0: unexpected image url %v", url)
+	}
+
+	if text, ok := messages[1]["content"].([]map[string]any); !ok || len(text) != 2 {
+		t.Errorf("Message 1: expected 2-item content array, got %v", messages[1]["content"])
+	}
+
+	if system := messages[2]["content"]; system != "line one\nline two" {
+		t.Errorf("Message 2: expected joined system text, got %v", system)
+	}
+}
+
+// TestConvertPart_AudioAndFiles tests the formats of input audio and the
+// references to files
+func TestConvertPart_AudioAndFiles(t *testing.T) {
+	audio := func(mimeType string) *genai.Part {
+		return &genai.Part{InlineData: &genai.Blob{MIMEType: mimeType, Data: []byte{1}}}
+	}
+	for mimeType, want := range map[string]string{
+		"audio/mpeg":           "mp3",
+		"audio/mp3":            "mp3",
+		"audio/wav":            "wav",
+		"audio/x-wav":          "wav",
+		"Audio/Wave; codecs=1": "wav",
+	} {
+		got, err := convertPart(audio(mimeType))
+		if err != nil || got["input_audio"].(map[string]any)["format"] != want {
+			t.Errorf("convertPart(%s) = %v, %v, want format %s", mimeType, got, err, want)
+		}
+	}
+	if _, err := convertPart(audio("audio/ogg")); !errors.Is(err, ErrUnsupportedInput) {
+		t.Errorf("convertPart(audio/ogg) error = %v, want ErrUnsupportedInput", err)
+	}
+
+	file := func(uri, mimeType string) *genai.Part {
+		return &genai.Part{FileData: &genai.FileData{FileURI: uri, MIMEType: mimeType}}
+	}
+	got, err := convertPart(file("file-abc123", "application/pdf"))
+	if err != nil || got["file"].(map[string]any)["file_id"] != "file-abc123" {
+		t.Errorf("convertPart(file ID) = %v, %v", got, err)
+	}
+	got, err = convertPart(file("https://example.com/cat.png", "image/png"))
+	if err != nil || got["image_url"].(map[string]any)["url"] != "https://example.com/cat.png" {
+		t.Errorf("convertPart(image URL) = %v, %v", got, err)
+	}
+	if _, err := convertPart(file("https://example.com/report.pdf", "application/pdf")); !errors.Is(err, ErrUnsupportedInput) {
+		t.Errorf("convertPart(file URL) error = %v, want ErrUnsupportedInput", err)
+	}
+}
+
+// TestConvertSchema_Constraints tests that validation keywords survive conversion
+func TestConvertSchema_Constraints(t *testing.T) {
+	minLen, maxItems := int64(1), int64(5)
+	minimum := 0.5
+	nullable := true
+
+	schema := &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"name":  {Type: genai.TypeString, MinLength: &minLen, Pattern: "^[a-z]+$", Format: "email"},
+			"tags":  {Type: genai.TypeArray, MaxItems: &maxItems, Items: &genai.Schema{Type: genai.TypeString}},
+			"score": {Type: genai.TypeNumber, Minimum: &minimum, Default: 1.0, Nullable: &nullable},
+			"id":    {AnyOf: []*genai.Schema{{Type: genai.TypeString}, {Type: genai.TypeInteger}}},
+		},
+	}
+
+	result, err := convertSchema(schema)
+	if err != nil {
+		t.Fatalf("convertSchema() error = %v", err)
+	}
+	props := result["properties"].(map[string]any)
+
+	name := props["name"].(map[string]any)
+	if name["minLength"] != int64(1) || name["pattern"] != "^[a-z]+$" || name["format"] != "email" {
+		t.Errorf("name: unexpected schema %v", name)
+	}
+
+	tags := props["tags"].(map[string]any)
+	if tags["maxItems"] != int64(5) {
+		t.Errorf("tags: unexpected schema %v", tags)
+	}
+
+	score := props["score"].(map[string]any)
+	if score["minimum"] != 0.5 || score["default"] != 1.0 {
+		t.Errorf("score: unexpected schema %v", score)
+	}
+	if types, ok := score["type"].([]string); !ok || len(types) != 2 || types[1] != "null" {
+		t.Errorf("score: expected nullable type, got %v", score["type"])
+	}
+
+	id := props["id"].(map[string]any)
+	if _, hasType := id["type"]; hasType {
+		t.Errorf("id: anyOf schema should not have a type, got %v", id["type"])
+	}
+	if anyOf, ok := id["anyOf"].([]map[string]any); !ok || len(anyOf) != 2 {
+		t.Errorf("id: expected 2 anyOf alternatives, got %v", id["anyOf"])
+	}
+}
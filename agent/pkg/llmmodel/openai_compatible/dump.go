@@ -0,0 +1,161 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+)
+
+// DumpTenant is the tenant whose key encrypts the dump files. Dumps are
+// written by the HTTP transport, which does not know the user of a request.
+const DumpTenant = "model-dumps"
+
+// dumpTransport writes the body of each request and the raw body of its
+// response, JSON or SSE stream, to files of a directory. Headers are left
+// out, they carry the API key. Gzipped request bodies are written
+// decompressed, compressed responses as received. With a sealer, each file
+// is encrypted as a whole, responses once their body is closed.
+type dumpTransport struct {
+	next   http.RoundTripper
+	dir    string
+	sealer *envelope.Sealer
+	seq    atomic.Int64
+	logger *slog.Logger
+}
+
+// newDumpTransport creates the dump directory, readable by its owner only
+// since the dumps hold prompts
+func newDumpTransport(next http.RoundTripper, dir string, sealer *envelope.Sealer, logger *slog.Logger) (*dumpTransport, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &dumpTransport{next: next, dir: dir, sealer: sealer, logger: logger}, nil
+}
+
+// RoundTrip implements http.RoundTripper. Files are named after the time
+// and sequence number of the request, e.g. 20260102-150405.000-0001-request.json
+// and 20260102-150405.000-0001-response-200.sse.
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	prefix := filepath.Join(t.dir, fmt.Sprintf("%s-%04d", time.Now().Format("20060102-150405.000"), t.seq.Add(1)))
+
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			if zr, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+				if plain, err := io.ReadAll(zr); err == nil {
+					data = plain
+				}
+			}
+		}
+		t.write(prefix+"-request.json", data)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.write(prefix+"-error.txt", []byte(err.Error()+"\n"))
+		return nil, err
+	}
+	ext := ".json"
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		ext = ".sse"
+	}
+	// Compressed responses are dumped as received
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		ext += ".gz"
+	case "deflate":
+		ext += ".deflate"
+	}
+	name := fmt.Sprintf("%s-response-%d%s", prefix, resp.StatusCode, ext)
+	if t.sealer != nil {
+		resp.Body = &sealedDumpBody{ReadCloser: resp.Body, transport: t, name: name}
+		return resp, nil
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		t.logger.Warn("Failed to create response dump", "error", err)
+		return resp, nil
+	}
+	t.logger.Debug("Dumping response", "file", name)
+	resp.Body = &dumpBody{ReadCloser: resp.Body, file: f}
+	return resp, nil
+}
+
+// write writes a dump file, encrypted when there is a sealer
+func (t *dumpTransport) write(name string, data []byte) {
+	if t.sealer != nil {
+		sealed, err := t.sealer.Seal(context.Background(), DumpTenant, data)
+		if err != nil {
+			t.logger.Warn("Failed to encrypt dump", "error", err)
+			return
+		}
+		name, data = name+".enc", sealed
+	}
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.logger.Warn("Failed to write dump", "error", err)
+		return
+	}
+	t.logger.Debug("Dumped request", "file", name)
+}
+
+// dumpBody copies a response body to a file as it is read
+type dumpBody struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (b *dumpBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.file.Write(p[:n])
+	}
+	return n, err
+}
+
+func (b *dumpBody) Close() error {
+	b.file.Close()
+	return b.ReadCloser.Close()
+}
+
+// sealedDumpBody collects a response body as it is read and writes it
+// encrypted when closed
+type sealedDumpBody struct {
+	io.ReadCloser
+	transport *dumpTransport
+	name      string
+	buf       bytes.Buffer
+	closed    bool
+}
+
+func (b *sealedDumpBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *sealedDumpBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.transport.write(b.name, b.buf.Bytes())
+	}
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,95 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// embeddingsPath derives the embeddings endpoint from the chat endpoint, so
+// providers with a non-standard prefix (e.g. /api/paas/v4) work unchanged
+func (c *Client) embeddingsPath() string {
+	if prefix, ok := strings.CutSuffix(c.chatPath, "/chat/completions"); ok {
+		return prefix + "/embeddings"
+	}
+	return "/v1/embeddings"
+}
+
+// Embed returns one embedding per input, using the client's model as the
+// embedding model (e.g. text-embedding-3-small)
+func (c *Client) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+
+	reqBody, err := json.Marshal(map[string]any{
+		"model": c.modelName,
+		"input": inputs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := c.baseURL + c.embeddingsPath()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setHeaders(httpReq)
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := c.handleHTTPError(resp)
+		c.logger.ErrorContext(ctx, "Embeddings API returned error", "error", err, "request_id", requestID(resp.Header))
+		return nil, err
+	}
+
+	var embResp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	vectors := make([][]float32, len(inputs))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("missing embedding for input %d", i)
+		}
+	}
+
+	c.logger.DebugContext(ctx, "Embedded inputs",
+		"count", len(inputs),
+		"prompt_tokens", embResp.Usage.PromptTokens,
+		"elapsed", time.Since(startTime),
+	)
+	return vectors, nil
+}
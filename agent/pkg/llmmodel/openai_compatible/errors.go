@@ -0,0 +1,279 @@
+package openai_compatible
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of provider errors, matched with errors.Is. Each kind has an error
+// type carrying its details, matched with errors.As.
+var (
+	ErrAuth          = errors.New("authentication failed")      // *AuthError
+	ErrRateLimit     = errors.New("rate limited")               // *RateLimitError
+	ErrContextLength = errors.New("context length exceeded")    // *ContextLengthError
+	ErrContentFilter = errors.New("rejected by content filter") // *ResponseRefused
+	ErrServer        = errors.New("provider server error")      // *ServerError
+	ErrNetwork       = errors.New("network error")              // *NetworkError
+)
+
+// APIError represents an error returned by the API
+type APIError struct {
+	StatusCode int
+	Message    string
+	Type       string
+	Code       string // Provider error code, e.g. rate_limit_exceeded or context_length_exceeded
+	Body       string
+	RequestID  string // Provider request ID from the response headers, if any
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
+
+// AuthError is an error response rejecting the API key (401) or its access
+// to the model (403)
+type AuthError struct {
+	*APIError
+}
+
+func (e *AuthError) Unwrap() error        { return e.APIError }
+func (e *AuthError) Is(target error) bool { return target == ErrAuth }
+
+// RateLimitError is an error response of a provider over its rate limits.
+// Quota is set when the account ran out of credits, which waiting does not fix.
+type RateLimitError struct {
+	*APIError
+	RetryAfter time.Duration // From the Retry-After headers, 0 when absent
+	Quota      bool
+}
+
+func (e *RateLimitError) Unwrap() error        { return e.APIError }
+func (e *RateLimitError) Is(target error) bool { return target == ErrRateLimit }
+
+// ContextLengthError is an error response rejecting a request whose prompt
+// and max_tokens exceed the context window
+type ContextLengthError struct {
+	*APIError
+	Window int // Context window in tokens, 0 when the message does not say
+}
+
+func (e *ContextLengthError) Unwrap() error        { return e.APIError }
+func (e *ContextLengthError) Is(target error) bool { return target == ErrContextLength }
+
+// ServerError is a 5xx error response, or an error event of type server_error
+// or overloaded_error in a stream
+type ServerError struct {
+	*APIError
+	RetryAfter time.Duration // From the Retry-After headers of a 503, 0 when absent
+}
+
+func (e *ServerError) Unwrap() error        { return e.APIError }
+func (e *ServerError) Is(target error) bool { return target == ErrServer }
+
+// NetworkError is a request that got no response: a failed dial, TLS
+// handshake or connection, or the HTTP client timeout
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string        { return fmt.Sprintf("failed to make request: %v", e.Err) }
+func (e *NetworkError) Unwrap() error        { return e.Err }
+func (e *NetworkError) Is(target error) bool { return target == ErrNetwork }
+
+// ResponseRefused is returned when the provider declined to answer, either
+// with an explicit refusal message, a content_filter finish reason or an
+// error response of a content filter rejecting the prompt. An empty answer is
+// not a refusal.
+type ResponseRefused struct {
+	Reason       string // The provider's refusal message, or the finish reason when there is none
+	FinishReason string
+	Text         string   // Text generated before the refusal, if any
+	Categories   []string // Content filter categories that triggered, e.g. hate or jailbreak (Azure)
+	Prompt       bool     // The content filter rejected the prompt rather than the answer
+	Err          *APIError
+}
+
+func (e *ResponseRefused) Error() string {
+	if len(e.Categories) > 0 {
+		return fmt.Sprintf("response refused by provider: %s (%s)", e.Reason, strings.Join(e.Categories, ", "))
+	}
+	return fmt.Sprintf("response refused by provider: %s", e.Reason)
+}
+
+// Unwrap returns the error response of a rejected prompt, nil otherwise
+func (e *ResponseRefused) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+func (e *ResponseRefused) Is(target error) bool { return target == ErrContentFilter }
+
+// refusal returns a *ResponseRefused for a refused response, or nil.
+// categories are the content filter categories that triggered.
+func refusal(text, refusalText, finishReason string, categories []string) error {
+	if refusalText == "" && finishReason != "content_filter" {
+		return nil
+	}
+	reason := refusalText
+	if reason == "" {
+		reason = finishReason
+	}
+	return &ResponseRefused{
+		Reason:       reason,
+		FinishReason: finishReason,
+		Text:         text,
+		Categories:   categories,
+	}
+}
+
+// Retryable reports whether sending the request again may succeed: rate
+// limits other than an exhausted quota, server errors and network errors.
+// Requests canceled by the caller are not retryable.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) {
+		return !rateLimit.Quota
+	}
+	return errors.Is(err, ErrServer) || errors.Is(err, ErrNetwork)
+}
+
+// RetryAfter returns how long the provider asked to wait before retrying
+// err, 0 when it did not say
+func RetryAfter(err error) time.Duration {
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) {
+		return rateLimit.RetryAfter
+	}
+	var server *ServerError
+	if errors.As(err, &server) {
+		return server.RetryAfter
+	}
+	return 0
+}
+
+// handleHTTPError parses an error response into its typed error: a
+// *ResponseRefused when a content filter rejected the prompt, an
+// *AuthError, *RateLimitError, *ContextLengthError or *ServerError, or the
+// *APIError itself for other errors
+func (c *Client) handleHTTPError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := parseAPIError(resp.StatusCode, body)
+	apiErr.RequestID = requestID(resp.Header)
+	return classify(apiErr, resp.Header)
+}
+
+// parseStreamError converts the payload of an SSE "error" event into its
+// typed error, as handleHTTPError does
+func parseStreamError(statusCode int, data string) error {
+	return classify(parseAPIError(statusCode, []byte(data)), nil)
+}
+
+// parseAPIError builds an APIError from an OpenAI-style error body
+func parseAPIError(statusCode int, body []byte) *APIError {
+	var errResp struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"` // A string, or a number for some providers
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+		var code string
+		if errResp.Error.Code != nil {
+			code = fmt.Sprint(errResp.Error.Code)
+		}
+		return &APIError{
+			StatusCode: statusCode,
+			Message:    errResp.Error.Message,
+			Type:       errResp.Error.Type,
+			Code:       code,
+			Body:       string(body),
+		}
+	}
+
+	return &APIError{
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+}
+
+// classify wraps apiErr in the error type of its kind, from the status code,
+// or from the error type and code for the error events of a stream, which
+// arrive with a 200 status. header holds the response headers, nil for a
+// stream error.
+func classify(apiErr *APIError, header http.Header) error {
+	if err := contentFilterError(apiErr); err != apiErr {
+		return err
+	}
+
+	status := apiErr.StatusCode
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden ||
+		apiErr.Type == "authentication_error" || apiErr.Type == "permission_error":
+		return &AuthError{APIError: apiErr}
+	case status == http.StatusTooManyRequests || apiErr.Type == "rate_limit_error" || apiErr.Code == "rate_limit_exceeded":
+		quota := apiErr.Code == "insufficient_quota" || apiErr.Type == "insufficient_quota"
+		return &RateLimitError{APIError: apiErr, RetryAfter: retryAfter(header), Quota: quota}
+	case contextLengthExceeded(apiErr):
+		return &ContextLengthError{APIError: apiErr, Window: firstNumber(apiErr.Message+" "+apiErr.Body, overflowWindow...)}
+	case status >= 500 || apiErr.Type == "server_error" || apiErr.Type == "overloaded_error":
+		return &ServerError{APIError: apiErr, RetryAfter: retryAfter(header)}
+	}
+	return apiErr
+}
+
+// contextLengthExceeded reports whether apiErr rejects a request too long for
+// the context window
+func contextLengthExceeded(apiErr *APIError) bool {
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusOK:
+	default:
+		return false
+	}
+	if apiErr.Code == "context_length_exceeded" {
+		return true
+	}
+	message := apiErr.Message + " " + apiErr.Body
+	return firstNumber(message, overflowWindow...) > 0 ||
+		strings.Contains(message, "prompt is too long") ||
+		strings.Contains(message, "context_length_exceeded")
+}
+
+// retryAfter reads the wait a response asks for before retrying, from
+// OpenAI's retry-after-ms header or the standard Retry-After header, in
+// seconds or as an HTTP date
+func retryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(time.Duration(seconds*float64(time.Second)), 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
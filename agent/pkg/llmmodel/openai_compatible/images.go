@@ -0,0 +1,128 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ImageRequest describes the images to generate
+type ImageRequest struct {
+	Prompt  string
+	N       int    // Number of images, defaults to 1
+	Size    string // e.g. 1024x1024, the provider's default when empty
+	Quality string // e.g. standard or hd, the provider's default when empty
+	// ResponseFormat is url or b64_json. Empty leaves it to the provider,
+	// as some models only return data.
+	ResponseFormat string
+}
+
+// GeneratedImage is an image of the images API, given by URL or inline data
+type GeneratedImage struct {
+	URL           string
+	Data          []byte
+	MIMEType      string // Of Data, e.g. image/png
+	RevisedPrompt string // The prompt the provider actually used, if it rewrote it
+}
+
+// imagesPath derives the image generation endpoint from the chat endpoint,
+// as embeddingsPath does
+func (c *Client) imagesPath() string {
+	if prefix, ok := strings.CutSuffix(c.chatPath, "/chat/completions"); ok {
+		return prefix + "/images/generations"
+	}
+	return "/v1/images/generations"
+}
+
+// GenerateImages generates images with the images API, using the client's
+// model as the image model (e.g. dall-e-3 or gpt-image-1)
+func (c *Client) GenerateImages(ctx context.Context, req *ImageRequest) ([]GeneratedImage, error) {
+	if req == nil || strings.TrimSpace(req.Prompt) == "" {
+		return nil, fmt.Errorf("image prompt is required")
+	}
+	body := map[string]any{
+		"model":  c.modelName,
+		"prompt": req.Prompt,
+		"n":      max(req.N, 1),
+	}
+	if req.Size != "" {
+		body["size"] = req.Size
+	}
+	if req.Quality != "" {
+		body["quality"] = req.Quality
+	}
+	if req.ResponseFormat != "" {
+		body["response_format"] = req.ResponseFormat
+	}
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+c.imagesPath(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setHeaders(httpReq)
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := c.handleHTTPError(resp)
+		c.logger.ErrorContext(ctx, "Images API returned error", "error", err, "request_id", requestID(resp.Header))
+		return nil, err
+	}
+
+	var imgResp struct {
+		Data []struct {
+			URL           string `json:"url"`
+			B64JSON       string `json:"b64_json"`
+			RevisedPrompt string `json:"revised_prompt"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&imgResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(imgResp.Data) == 0 {
+		return nil, fmt.Errorf("images API returned no images")
+	}
+
+	images := make([]GeneratedImage, 0, len(imgResp.Data))
+	for i, d := range imgResp.Data {
+		image := GeneratedImage{URL: d.URL, RevisedPrompt: d.RevisedPrompt}
+		if d.B64JSON != "" {
+			data, err := base64.StdEncoding.DecodeString(d.B64JSON)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode image %d: %w", i, err)
+			}
+			image.Data = data
+			image.MIMEType = http.DetectContentType(data)
+		}
+		if image.URL == "" && image.Data == nil {
+			return nil, fmt.Errorf("image %d has neither a URL nor data", i)
+		}
+		images = append(images, image)
+	}
+	c.logger.DebugContext(ctx, "Generated images",
+		"images", len(images),
+		"size", req.Size,
+		"elapsed", time.Since(startTime),
+	)
+	return images, nil
+}
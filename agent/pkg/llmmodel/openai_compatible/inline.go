@@ -0,0 +1,203 @@
+package openai_compatible
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// DefaultMaxInlineDataSize caps the decoded size of each image, audio or file
+// part of a response
+const DefaultMaxInlineDataSize = 20 << 20
+
+// messageContent is the content of a response message or stream delta: a
+// string, or an array of parts mixing text with images, audio and files
+type messageContent struct {
+	Text  string
+	Parts []contentPart // Parts other than text, in order
+}
+
+// UnmarshalJSON accepts a string, null or an array of content parts
+func (m *messageContent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &m.Text)
+	}
+	var parts []contentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content is neither a string nor an array of parts: %w", err)
+	}
+	var text strings.Builder
+	for _, part := range parts {
+		switch part.Type {
+		case "text", "output_text":
+			text.WriteString(part.Text)
+		default:
+			m.Parts = append(m.Parts, part)
+		}
+	}
+	m.Text = text.String()
+	return nil
+}
+
+// contentPart is a non-text part of a response. Providers use the request
+// shapes: image_url, input_audio (or audio) and file.
+type contentPart struct {
+	Type       string     `json:"type"`
+	Text       string     `json:"text"`
+	ImageURL   imageURL   `json:"image_url"`
+	InputAudio *audioData `json:"input_audio"`
+	Audio      *audioData `json:"audio"`
+	File       *struct {
+		FileData string `json:"file_data"` // Data URL or bare base64
+		FileID   string `json:"file_id"`
+		Filename string `json:"filename"`
+	} `json:"file"`
+}
+
+// imageURL is the URL of an image part, given as {"url": ...} or as a bare string
+type imageURL string
+
+// UnmarshalJSON accepts an object with a url field or a string
+func (u *imageURL) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, (*string)(u))
+	}
+	var obj struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*u = imageURL(obj.URL)
+	return nil
+}
+
+// audioData is base64 audio, as in input_audio parts and message.audio
+type audioData struct {
+	Data       string `json:"data"`
+	Format     string `json:"format"`
+	Transcript string `json:"transcript"`
+}
+
+// inlineParts converts non-text response parts to genai parts. Base64 data is
+// decoded into inline data, URLs become file data and unusable parts are
+// logged and dropped.
+func (c *Client) inlineParts(parts []contentPart) []*genai.Part {
+	var out []*genai.Part
+	add := func(p *genai.Part) {
+		if p != nil {
+			out = append(out, p)
+		}
+	}
+	for _, part := range parts {
+		switch {
+		case part.ImageURL != "":
+			add(c.urlPart(string(part.ImageURL), "image"))
+		case part.InputAudio != nil || part.Audio != nil:
+			audio := cmp.Or(part.InputAudio, part.Audio)
+			add(c.inlinePart("audio", audioMIMEType(audio.Format), audio.Data))
+		case part.File != nil && part.File.FileData != "":
+			if strings.HasPrefix(part.File.FileData, "data:") {
+				add(c.urlPart(part.File.FileData, "file"))
+			} else {
+				add(c.inlinePart("file", mime.TypeByExtension(path.Ext(part.File.Filename)), part.File.FileData))
+			}
+		case part.File != nil && part.File.FileID != "":
+			add(&genai.Part{FileData: &genai.FileData{FileURI: part.File.FileID, DisplayName: part.File.Filename}})
+		default:
+			c.logger.Warn("Dropping unsupported response content part", "type", part.Type)
+		}
+	}
+	return out
+}
+
+// urlPart converts a data URL to inline data and any other URL to file data
+func (c *Client) urlPart(rawURL, kind string) *genai.Part {
+	if mimeType, data, ok := parseDataURL(rawURL); ok {
+		return c.inlinePart(kind, mimeType, data)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		c.logger.Warn("Dropping response part with an invalid URL", "kind", kind)
+		return nil
+	}
+	return &genai.Part{FileData: &genai.FileData{FileURI: rawURL, MIMEType: mime.TypeByExtension(path.Ext(u.Path))}}
+}
+
+// inlinePart decodes base64 data into an inline data part. Data over the size
+// limit is replaced by a note, so the answer shows that something was left out.
+func (c *Client) inlinePart(kind, mimeType, encoded string) *genai.Part {
+	encoded = strings.Join(strings.Fields(encoded), "")
+	if size := base64.StdEncoding.DecodedLen(len(encoded)); size > c.maxInlineDataSize {
+		c.logger.Warn("Dropping response part over the inline data limit", "kind", kind, "size", size, "limit", c.maxInlineDataSize)
+		return genai.NewPartFromText(fmt.Sprintf("[%s of about %d bytes omitted: over the %d byte limit]", kind, size, c.maxInlineDataSize))
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	}
+	if err != nil || len(data) == 0 {
+		c.logger.Warn("Dropping response part with invalid base64 data", "kind", kind, "error", err)
+		return nil
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType, _, _ = strings.Cut(http.DetectContentType(data), ";")
+	}
+	return &genai.Part{InlineData: &genai.Blob{MIMEType: mimeType, Data: data}}
+}
+
+// parseDataURL splits a base64 data URL into its MIME type and data
+func parseDataURL(rawURL string) (mimeType, data string, ok bool) {
+	rest, ok := strings.CutPrefix(rawURL, "data:")
+	if !ok {
+		return "", "", false
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", "", false
+	}
+	params := strings.Split(header, ";")
+	if !slices.Contains(params[1:], "base64") {
+		return "", "", false
+	}
+	return params[0], data, true
+}
+
+// audioMIMEType maps an OpenAI audio format to a MIME type, empty when unknown
+func audioMIMEType(format string) string {
+	switch format {
+	case "":
+		return ""
+	case "mp3":
+		return "audio/mpeg"
+	case "pcm16":
+		return "audio/pcm"
+	default:
+		return "audio/" + format
+	}
+}
+
+// responseContent builds a model content from the text of a response and its
+// other parts. The text part is left out when it is empty and there are others.
+func responseContent(text string, parts ...[]*genai.Part) *genai.Content {
+	content := genai.NewContentFromText(text, genai.RoleModel)
+	if other := slices.Concat(parts...); len(other) > 0 {
+		if text == "" {
+			content.Parts = nil
+		}
+		content.Parts = append(content.Parts, other...)
+	}
+	return content
+}
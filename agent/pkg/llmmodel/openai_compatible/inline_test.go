@@ -0,0 +1,100 @@
+package openai_compatible
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// pngData is the PNG signature, enough for MIME detection
+var pngData = []byte("\x89PNG\r\n\x1a\n0000")
+
+// describeParts summarizes parts as text, mime:size or uri
+func describeParts(parts []*genai.Part) string {
+	var out []string
+	for _, p := range parts {
+		switch {
+		case p.InlineData != nil:
+			out = append(out, fmt.Sprintf("%s:%d", p.InlineData.MIMEType, len(p.InlineData.Data)))
+		case p.FileData != nil:
+			out = append(out, p.FileData.FileURI+"|"+p.FileData.MIMEType)
+		default:
+			out = append(out, p.Text)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+// TestResponseInlineData tests decoding images, audio and files of responses
+func TestResponseInlineData(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString(pngData)
+	wav := base64.StdEncoding.EncodeToString([]byte("RIFF\x00\x00\x00\x00WAVEfmt "))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant",
+			"content":[{"type":"text","text":"Here"},
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,%s"}},
+				{"type":"file","file":{"filename":"big.pdf","file_data":"%s"}},
+				{"type":"image_url","image_url":"data:;base64,%s"}],
+			"images":[{"type":"image_url","image_url":{"url":"https://cdn.example.com/a/cat.jpg"}}],
+			"audio":{"id":"a1","data":"%s","transcript":"Hi"}},
+			"finish_reason":"stop"}]}`, png, strings.Repeat("A", 100), png, wav)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model", MaxInlineDataSize: 64})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("Draw a cat", genai.RoleUser)}}
+	for resp, err := range client.GenerateContent(context.Background(), req, false) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		want := "Here, image/png:12, [file of about 75 bytes omitted: over the 64 byte limit], image/png:12, " +
+			"https://cdn.example.com/a/cat.jpg|image/jpeg, audio/wave:16"
+		if got := describeParts(resp.Content.Parts); got != want {
+			t.Errorf("parts = %s\nwant %s", got, want)
+		}
+	}
+}
+
+// TestStreamInlineData tests that images in stream deltas reach the final response
+func TestStreamInlineData(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString(pngData)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunks(w, "A cat")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"images\":[{\"type\":\"image_url\",\"image_url\":{\"url\":\"data:image/png;base64,%s\"}}]}}]}\n\n", png)
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":[{\"type\":\"text\",\"text\":\".\"}]},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("Draw a cat", genai.RoleUser)}}
+	var final *model.LLMResponse
+	for resp, err := range client.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		if !resp.Partial {
+			final = resp
+		}
+	}
+	if final == nil {
+		t.Fatal("no final response")
+	}
+	if got := describeParts(final.Content.Parts); got != "A cat., image/png:12" {
+		t.Errorf("parts = %s", got)
+	}
+}
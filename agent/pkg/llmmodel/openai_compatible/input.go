@@ -0,0 +1,133 @@
+package openai_compatible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/imageutil"
+	"google.golang.org/genai"
+)
+
+// DefaultMaxImageSize is the largest image sent as is; larger ones are downscaled
+const DefaultMaxImageSize = 20 << 20
+
+// ErrUnsupportedInput is returned, wrapped, for inline data the provider does not accept
+var ErrUnsupportedInput = errors.New("unsupported input")
+
+// prepareInlineData returns contents with inline data ready for the provider:
+// MIME types are sniffed, parts of types the provider does not accept are
+// rejected and images are prepared by imageutil.Process. contents is not
+// modified.
+func (c *Client) prepareInlineData(contents []*genai.Content) ([]*genai.Content, error) {
+	var out []*genai.Content
+	for i, content := range contents {
+		if content == nil {
+			continue
+		}
+		for j, part := range content.Parts {
+			if part == nil || part.InlineData == nil || len(part.InlineData.Data) == 0 {
+				continue
+			}
+			blob, err := c.prepareBlob(part.InlineData)
+			if err != nil {
+				return nil, err
+			}
+			if blob == part.InlineData {
+				continue
+			}
+
+			// Copy on first change, so the session history keeps the original
+			if out == nil {
+				out = append([]*genai.Content(nil), contents...)
+			}
+			if out[i] == contents[i] {
+				copied := *content
+				copied.Parts = append([]*genai.Part(nil), content.Parts...)
+				out[i] = &copied
+			}
+			copied := *part
+			copied.InlineData = blob
+			out[i].Parts[j] = &copied
+		}
+	}
+	if out == nil {
+		return contents, nil
+	}
+	return out, nil
+}
+
+// prepareBlob checks and fits one piece of inline data, returning blob
+// itself when it can be sent unchanged. Images are stripped of metadata,
+// turned upright and converted or downscaled as the provider requires.
+func (c *Client) prepareBlob(blob *genai.Blob) (*genai.Blob, error) {
+	mimeType := sniffMIMEType(blob.MIMEType, blob.Data)
+	convertible := c.acceptsMIMEType("image/jpeg") || c.acceptsMIMEType("image/png")
+	if strings.HasPrefix(mimeType, "image/") && (convertible || c.acceptsMIMEType(mimeType)) {
+		data, fittedType, err := imageutil.Process(blob.Data, mimeType, imageutil.Options{
+			MaxSize:      c.maxImageSize,
+			MaxDimension: c.maxImageDimension,
+			Accept:       c.acceptsMIMEType,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrUnsupportedInput, c.modelName, err)
+		}
+		if data != nil {
+			c.logger.Debug("Prepared image for the provider", "from_bytes", len(blob.Data), "to_bytes", len(data), "from_type", mimeType, "to_type", fittedType)
+			return &genai.Blob{MIMEType: fittedType, Data: data, DisplayName: blob.DisplayName}, nil
+		}
+		mimeType = fittedType
+	}
+	if !c.acceptsMIMEType(mimeType) {
+		accepted := "none"
+		if len(c.inputMIMETypes) > 0 {
+			accepted = strings.Join(c.inputMIMETypes, ", ")
+		}
+		return nil, fmt.Errorf("%w: %s does not accept %s data (accepted: %s)", ErrUnsupportedInput, c.modelName, mimeType, accepted)
+	}
+	if mimeType != blob.MIMEType {
+		return &genai.Blob{MIMEType: mimeType, Data: blob.Data, DisplayName: blob.DisplayName}, nil
+	}
+	return blob, nil
+}
+
+// acceptsMIMEType matches a MIME type against the accepted patterns: nil
+// accepts anything, "image/*" a whole family
+func (c *Client) acceptsMIMEType(mimeType string) bool {
+	if c.inputMIMETypes == nil {
+		return true
+	}
+	for _, pattern := range c.inputMIMETypes {
+		if ok, _ := path.Match(pattern, mimeType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffMIMEType returns the MIME type of data: the declared one unless it is
+// missing or generic, or names a different image format than the content
+func sniffMIMEType(declared string, data []byte) string {
+	declared, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(declared)), ";")
+	sniffed, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	switch sniffed {
+	case "audio/wave":
+		sniffed = "audio/wav"
+	case "text/plain", "application/octet-stream":
+		sniffed = "" // Not recognized by content
+	}
+
+	switch {
+	case declared == "" || declared == "application/octet-stream":
+		if sniffed == "" {
+			return "application/octet-stream"
+		}
+		return sniffed
+	case strings.HasPrefix(declared, "image/") && strings.HasPrefix(sniffed, "image/"):
+		return sniffed
+	default:
+		return declared
+	}
+}
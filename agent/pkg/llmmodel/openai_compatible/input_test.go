@@ -0,0 +1,92 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// TestSniffMIMEType tests trusting declared types except for generic or mislabeled images
+func TestSniffMIMEType(t *testing.T) {
+	tests := []struct {
+		declared string
+		data     []byte
+		want     string
+	}{
+		{"", pngData, "image/png"},
+		{"application/octet-stream", []byte("%PDF-1.7"), "application/pdf"},
+		{"image/jpeg", pngData, "image/png"},
+		{"Image/PNG; charset=binary", pngData, "image/png"},
+		{"audio/mpeg", []byte{0xff, 0xfb, 0x90}, "audio/mpeg"},
+		{"", []byte("RIFF\x00\x00\x00\x00WAVEfmt "), "audio/wav"},
+		{"", []byte{0x01, 0x02}, "application/octet-stream"},
+		{"text/csv", []byte("a,b\n1,2\n"), "text/csv"},
+	}
+	for _, tt := range tests {
+		if got := sniffMIMEType(tt.declared, tt.data); got != tt.want {
+			t.Errorf("sniffMIMEType(%q) = %q, want %q", tt.declared, got, tt.want)
+		}
+	}
+}
+
+// TestPrepareInlineData tests rejecting unsupported types and downscaling large images
+func TestPrepareInlineData(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(&ClientConfig{
+		APIKey:            "test",
+		BaseURL:           "http://localhost",
+		ModelName:         "vision-model",
+		InputMIMETypes:    []string{"image/*"},
+		MaxImageDimension: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := &genai.Content{Role: genai.RoleUser, Parts: []*genai.Part{
+		genai.NewPartFromText("What is this?"),
+		{InlineData: &genai.Blob{MIMEType: "image/jpeg", Data: buf.Bytes()}},
+	}}
+	contents, err := client.prepareInlineData([]*genai.Content{original})
+	if err != nil {
+		t.Fatalf("prepareInlineData() error = %v", err)
+	}
+	blob := contents[0].Parts[1].InlineData
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(blob.Data))
+	if err != nil || format != "jpeg" || blob.MIMEType != "image/jpeg" || cfg.Width != 16 || cfg.Height != 8 {
+		t.Errorf("downscaled image = %s %dx%d (%s), %v", format, cfg.Width, cfg.Height, blob.MIMEType, err)
+	}
+	if original.Parts[1].InlineData.MIMEType != "image/jpeg" || !bytes.Equal(original.Parts[1].InlineData.Data, buf.Bytes()) {
+		t.Error("prepareInlineData() modified the original content")
+	}
+
+	pdf := []*genai.Content{{Role: genai.RoleUser, Parts: []*genai.Part{{InlineData: &genai.Blob{Data: []byte("%PDF-1.7")}}}}}
+	if _, err := client.prepareInlineData(pdf); !errors.Is(err, ErrUnsupportedInput) {
+		t.Errorf("prepareInlineData(pdf) error = %v, want ErrUnsupportedInput", err)
+	}
+
+	client.inputMIMETypes = []string{}
+	if _, err := client.prepareInlineData([]*genai.Content{original}); !errors.Is(err, ErrUnsupportedInput) {
+		t.Errorf("prepareInlineData() with no accepted types error = %v", err)
+	}
+
+	client.inputMIMETypes, client.maxImageDimension = nil, 0
+	got, err := client.prepareInlineData(pdf)
+	if err != nil || got[0].Parts[0].InlineData.MIMEType != "application/pdf" || pdf[0].Parts[0].InlineData.MIMEType != "" {
+		t.Errorf("prepareInlineData(pdf) without limits = %v, %v, want a sniffed copy", got, err)
+	}
+}
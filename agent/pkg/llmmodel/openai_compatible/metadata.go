@@ -0,0 +1,110 @@
+package openai_compatible
+
+import (
+	"net/http"
+
+	"google.golang.org/genai"
+)
+
+// Keys of the provider metadata attached to LLMResponse.CustomMetadata
+const (
+	MetadataRequestID         = "request_id"         // Request ID from the response headers
+	MetadataResponseID        = "response_id"        // The "id" field of the completion
+	MetadataModel             = "model"              // Model version that actually served the request
+	MetadataSystemFingerprint = "system_fingerprint" // Backend configuration fingerprint
+)
+
+// requestIDHeaders are the response headers providers use for their request ID
+var requestIDHeaders = []string{"X-Request-Id", "Request-Id", "Apim-Request-Id", "X-Amzn-Requestid"}
+
+// responseMetadata identifies a provider response, so issues can be
+// correlated with provider-side logs
+type responseMetadata struct {
+	requestID         string
+	responseID        string
+	model             string
+	systemFingerprint string
+}
+
+// requestID returns the provider request ID from response headers
+func requestID(header http.Header) string {
+	for _, name := range requestIDHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// update records the body fields of a response or stream chunk. Empty values
+// keep what an earlier chunk reported.
+func (m *responseMetadata) update(id, model, fingerprint string) {
+	if id != "" {
+		m.responseID = id
+	}
+	if model != "" {
+		m.model = model
+	}
+	if fingerprint != "" {
+		m.systemFingerprint = fingerprint
+	}
+}
+
+// custom returns the metadata as LLMResponse.CustomMetadata, or nil if there is none
+func (m *responseMetadata) custom() map[string]any {
+	custom := map[string]any{}
+	for key, value := range map[string]string{
+		MetadataRequestID:         m.requestID,
+		MetadataResponseID:        m.responseID,
+		MetadataModel:             m.model,
+		MetadataSystemFingerprint: m.systemFingerprint,
+	} {
+		if value != "" {
+			custom[key] = value
+		}
+	}
+	if len(custom) == 0 {
+		return nil
+	}
+	return custom
+}
+
+// logAttrs returns the metadata as slog key-value pairs
+func (m *responseMetadata) logAttrs() []any {
+	return []any{
+		"request_id", m.requestID,
+		"response_id", m.responseID,
+		"served_model", m.model,
+		"system_fingerprint", m.systemFingerprint,
+	}
+}
+
+// tokenUsage is the usage object of a response or of the last stream chunk
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+
+	// Prompt tokens read from the provider's prompt cache, reported by
+	// DeepSeek as prompt_cache_hit_tokens and by OpenAI and OpenRouter in
+	// prompt_tokens_details
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"`
+	PromptTokensDetails  struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// cachedTokens returns the prompt tokens served from the provider's cache
+func (u *tokenUsage) cachedTokens() int {
+	return max(u.PromptCacheHitTokens, u.PromptTokensDetails.CachedTokens)
+}
+
+// metadata converts the usage to genai usage metadata
+func (u *tokenUsage) metadata() *genai.GenerateContentResponseUsageMetadata {
+	return &genai.GenerateContentResponseUsageMetadata{
+		PromptTokenCount:        int32(u.PromptTokens),
+		CachedContentTokenCount: int32(u.cachedTokens()),
+		CandidatesTokenCount:    int32(u.CompletionTokens),
+		TotalTokenCount:         int32(u.TotalTokens),
+	}
+}
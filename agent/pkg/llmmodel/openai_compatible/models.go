@@ -0,0 +1,51 @@
+package openai_compatible
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// modelsPath derives the model list endpoint from the chat endpoint, like
+// embeddingsPath
+func (c *Client) modelsPath() string {
+	if prefix, ok := strings.CutSuffix(c.chatPath, "/chat/completions"); ok {
+		return prefix + "/models"
+	}
+	return "/v1/models"
+}
+
+// ListModels returns the IDs of the models the provider serves. It costs no
+// tokens, which makes it a cheap reachability and credentials check.
+func (c *Client) ListModels(ctx context.Context) ([]string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+c.modelsPath(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setHeaders(httpReq)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleHTTPError(resp)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	ids := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
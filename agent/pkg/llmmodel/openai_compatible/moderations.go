@@ -0,0 +1,96 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Moderation is the verdict of the moderation API on an input
+type Moderation struct {
+	Flagged    bool               // The provider flagged the input
+	Categories map[string]bool    // Flag of each category, e.g. harassment or self-harm/intent
+	Scores     map[string]float64 // Score of each category, from 0 to 1
+}
+
+// moderationsPath derives the moderations endpoint from the chat endpoint,
+// as embeddingsPath does
+func (c *Client) moderationsPath() string {
+	if prefix, ok := strings.CutSuffix(c.chatPath, "/chat/completions"); ok {
+		return prefix + "/moderations"
+	}
+	return "/v1/moderations"
+}
+
+// Moderate classifies input with the moderation API, using the client's
+// model as the moderation model (e.g. omni-moderation-latest). Providers
+// that split a long input return a result per part; they are merged, keeping
+// the highest score of each category.
+func (c *Client) Moderate(ctx context.Context, input string) (*Moderation, error) {
+	reqBody, err := json.Marshal(map[string]any{
+		"model": c.modelName,
+		"input": input,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+c.moderationsPath(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setHeaders(httpReq)
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := c.handleHTTPError(resp)
+		c.logger.ErrorContext(ctx, "Moderations API returned error", "error", err, "request_id", requestID(resp.Header))
+		return nil, err
+	}
+
+	var modResp struct {
+		Results []struct {
+			Flagged        bool               `json:"flagged"`
+			Categories     map[string]bool    `json:"categories"`
+			CategoryScores map[string]float64 `json:"category_scores"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&modResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(modResp.Results) == 0 {
+		return nil, fmt.Errorf("moderations API returned no results")
+	}
+
+	moderation := &Moderation{Categories: map[string]bool{}, Scores: map[string]float64{}}
+	for _, r := range modResp.Results {
+		moderation.Flagged = moderation.Flagged || r.Flagged
+		for category, flagged := range r.Categories {
+			moderation.Categories[category] = moderation.Categories[category] || flagged
+		}
+		for category, score := range r.CategoryScores {
+			moderation.Scores[category] = max(moderation.Scores[category], score)
+		}
+	}
+	c.logger.DebugContext(ctx, "Moderated input",
+		"flagged", moderation.Flagged,
+		"elapsed", time.Since(startTime),
+	)
+	return moderation, nil
+}
@@ -0,0 +1,107 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBufferSize is the largest request buffer returned to the pool, so
+// one huge prompt does not pin its memory
+const maxPooledBufferSize = 4 << 20
+
+// bufferPool holds request body buffers. ReleaseBuffers swaps it for an
+// empty one.
+var bufferPool atomic.Pointer[sync.Pool]
+
+func init() {
+	ReleaseBuffers()
+}
+
+// newBufferPool returns an empty pool of request body buffers
+func newBufferPool() *sync.Pool {
+	return &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+}
+
+// getBuffer returns a pooled request body buffer
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Load().Get().(*bytes.Buffer)
+}
+
+// putBuffer returns a request body buffer to the pool
+func putBuffer(buf *bytes.Buffer) {
+	bufferPool.Load().Put(buf)
+}
+
+// ReleaseBuffers drops the pooled request body buffers, e.g. under memory
+// pressure, leaving them to the garbage collector. Buffers in use return to
+// the new pool.
+func ReleaseBuffers() {
+	bufferPool.Store(newBufferPool())
+}
+
+// pooledBuffer is a request body encoded into a pooled buffer. It goes back
+// to the pool once the client and every reader of the body are done with it;
+// the transport may still read or re-read (GetBody) the body after the
+// response arrived, or close it from another goroutine.
+type pooledBuffer struct {
+	buf  *bytes.Buffer
+	refs atomic.Int32
+}
+
+// encodeBody encodes v as JSON into a pooled buffer. The caller holds one
+// reference, dropped with release.
+func encodeBody(v any) (*pooledBuffer, error) {
+	buf := getBuffer()
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	b := &pooledBuffer{buf: buf}
+	b.refs.Store(1)
+	return b, nil
+}
+
+// Len returns the size of the body
+func (b *pooledBuffer) Len() int {
+	return b.buf.Len()
+}
+
+// reader returns a new reader of the body, holding a reference until closed
+func (b *pooledBuffer) reader() io.ReadCloser {
+	b.refs.Add(1)
+	return &pooledReader{Reader: bytes.NewReader(b.buf.Bytes()), owner: b}
+}
+
+// release drops a reference, returning the buffer to the pool with the last one
+func (b *pooledBuffer) release() {
+	if b.refs.Add(-1) == 0 && b.buf.Cap() <= maxPooledBufferSize {
+		putBuffer(b.buf)
+	}
+}
+
+// pooledReader reads a pooledBuffer
+type pooledReader struct {
+	*bytes.Reader
+	owner  *pooledBuffer
+	closed atomic.Bool
+}
+
+func (r *pooledReader) Close() error {
+	if r.closed.CompareAndSwap(false, true) {
+		r.owner.release()
+	}
+	return nil
+}
+
+// releaseBody drops the client's reference to the pooled body of a request
+// built by buildRequest, once its response has been read. It must be given
+// the Body the request was built with, before the request is sent.
+func releaseBody(body io.ReadCloser) {
+	if r, ok := body.(*pooledReader); ok {
+		r.owner.release()
+	}
+}
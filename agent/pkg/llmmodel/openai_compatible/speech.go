@@ -0,0 +1,169 @@
+package openai_compatible
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxSpeechInput is the length in characters of the longest input the
+// speech API accepts; longer text is cut at a sentence end before it
+const maxSpeechInput = 4096
+
+// maxSpeechSize bounds the audio read from the speech API
+const maxSpeechSize = 32 << 20
+
+// speechTypes are the content types of the speech formats
+var speechTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"pcm":  "audio/pcm",
+}
+
+// SpeechConfig holds the voice of synthesized speech. Zero values use the
+// defaults.
+type SpeechConfig struct {
+	Voice  string  // e.g. alloy or nova, defaults to alloy
+	Format string  // mp3 (default), opus, aac, flac, wav or pcm
+	Speed  float64 // From 0.25 to 4, defaults to 1
+}
+
+// Speech is audio synthesized by the speech API
+type Speech struct {
+	Audio       []byte
+	Format      string // As in SpeechConfig
+	ContentType string // e.g. audio/mpeg
+}
+
+// speechPath derives the speech endpoint from the chat endpoint, as
+// embeddingsPath does
+func (c *Client) speechPath() string {
+	if prefix, ok := strings.CutSuffix(c.chatPath, "/chat/completions"); ok {
+		return prefix + "/audio/speech"
+	}
+	return "/v1/audio/speech"
+}
+
+// Speak synthesizes input with the speech API, using the client's model as
+// the speech model (e.g. gpt-4o-mini-tts). Input past the API's limit is cut
+// at the last sentence end that fits.
+func (c *Client) Speak(ctx context.Context, input string, cfg *SpeechConfig) (*Speech, error) {
+	if cfg == nil {
+		cfg = &SpeechConfig{}
+	}
+	format := cmp.Or(cfg.Format, "mp3")
+	contentType, ok := speechTypes[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported speech format %q", format)
+	}
+	input = speechInput(input)
+	if input == "" {
+		return nil, fmt.Errorf("speech input is empty")
+	}
+
+	reqBody, err := json.Marshal(map[string]any{
+		"model":           c.modelName,
+		"input":           input,
+		"voice":           cmp.Or(cfg.Voice, "alloy"),
+		"response_format": format,
+		"speed":           cmp.Or(cfg.Speed, 1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+c.speechPath(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setHeaders(httpReq)
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := c.handleHTTPError(resp)
+		c.logger.ErrorContext(ctx, "Speech API returned error", "error", err, "request_id", requestID(resp.Header))
+		return nil, err
+	}
+
+	audio, err := io.ReadAll(io.LimitReader(resp.Body, maxSpeechSize+1))
+	if err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	if len(audio) > maxSpeechSize {
+		return nil, fmt.Errorf("speech exceeds %d bytes", maxSpeechSize)
+	}
+	c.logger.DebugContext(ctx, "Synthesized speech",
+		"characters", utf8.RuneCountInString(input),
+		"bytes", len(audio),
+		"format", format,
+		"elapsed", time.Since(startTime),
+	)
+	return &Speech{Audio: audio, Format: format, ContentType: contentType}, nil
+}
+
+// speechInput trims text to the speech API's limit, preferring to cut after
+// the end of a sentence
+func speechInput(text string) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= maxSpeechInput {
+		return text
+	}
+	text = string([]rune(text)[:maxSpeechInput])
+	cut := strings.LastIndexAny(text, ".!?。！？\n")
+	if cut <= 0 {
+		return text
+	}
+	_, size := utf8.DecodeRuneInString(text[cut:])
+	return strings.TrimSpace(text[:cut+size])
+}
+
+// Voice speaks text with a client's speech model and a fixed voice
+type Voice struct {
+	client *Client
+	cfg    SpeechConfig
+}
+
+// NewVoice returns the voice cfg of the client's speech model
+func NewVoice(client *Client, cfg *SpeechConfig) (*Voice, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client is required")
+	}
+	var c SpeechConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if _, ok := speechTypes[cmp.Or(c.Format, "mp3")]; !ok {
+		return nil, fmt.Errorf("unsupported speech format %q", c.Format)
+	}
+	if c.Speed != 0 && (c.Speed < 0.25 || c.Speed > 4) {
+		return nil, fmt.Errorf("speech speed %v is out of range [0.25, 4]", c.Speed)
+	}
+	return &Voice{client: client, cfg: c}, nil
+}
+
+// Speak synthesizes text in the voice
+func (v *Voice) Speak(ctx context.Context, text string) (*Speech, error) {
+	return v.client.Speak(ctx, text, &v.cfg)
+}
@@ -0,0 +1,116 @@
+package openai_compatible
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxSSELineSize bounds a single SSE line; large tool-call arguments can exceed bufio's 64KB default
+const maxSSELineSize = 1024 * 1024
+
+// sseEvent is a single dispatched Server-Sent Event
+type sseEvent struct {
+	Event string // Event type, empty means the default "message" type
+	Data  string // Data lines joined with "\n"
+	ID    string // Last event ID seen on the stream
+}
+
+// sseReader parses a text/event-stream body following the WHATWG SSE spec:
+//   - "event:", "data:", "id:" and "retry:" fields, with or without a space after the colon
+//   - multi-line data, joined with "\n" when the event is dispatched
+//   - ":" comment lines (heartbeats), which are ignored
+//   - events are dispatched on a blank line; a trailing event without one is
+//     still dispatched at EOF since some proxies drop the final blank line
+type sseReader struct {
+	scanner *bufio.Scanner
+	lastID  string
+	started bool
+}
+
+// newSSEReader creates an SSE reader over r
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+	return &sseReader{scanner: scanner}
+}
+
+// Next returns the next event, or io.EOF when the stream is exhausted
+func (r *sseReader) Next() (*sseEvent, error) {
+	// A single data line, the common case, is used as is; more are joined
+	var (
+		eventType string
+		first     string
+		data      strings.Builder
+		lines     int
+	)
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if !r.started {
+			// Strip a leading UTF-8 BOM from the first line
+			line = strings.TrimPrefix(line, "\ufeff")
+			r.started = true
+		}
+
+		if line == "" {
+			// Blank line dispatches the event; events without data are dropped
+			if lines == 0 {
+				eventType = ""
+				continue
+			}
+			return r.event(eventType, first, &data, lines), nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			// Comment, typically a keep-alive heartbeat
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			switch lines {
+			case 0:
+				first = value
+			case 1:
+				data.WriteString(first)
+				fallthrough
+			default:
+				data.WriteByte('\n')
+				data.WriteString(value)
+			}
+			lines++
+		case "id":
+			// IDs containing NUL are ignored per spec
+			if !strings.Contains(value, "\x00") {
+				r.lastID = value
+			}
+		default:
+			// "retry" and unknown fields are ignored
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lines > 0 {
+		return r.event(eventType, first, &data, lines), nil
+	}
+	return nil, io.EOF
+}
+
+// event returns the event of the data lines read
+func (r *sseReader) event(eventType, first string, data *strings.Builder, lines int) *sseEvent {
+	if lines > 1 {
+		first = data.String()
+	}
+	return &sseEvent{Event: eventType, Data: first, ID: r.lastID}
+}
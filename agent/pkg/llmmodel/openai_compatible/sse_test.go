@@ -0,0 +1,88 @@
+package openai_compatible
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestSSEReader tests parsing of the SSE variants emitted by different gateways
+func TestSSEReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		events []sseEvent
+	}{
+		{
+			name:   "openai style",
+			input:  "data: {\"a\":1}\n\ndata: [DONE]\n\n",
+			events: []sseEvent{{Data: `{"a":1}`}, {Data: "[DONE]"}},
+		},
+		{
+			name:   "no space after colon",
+			input:  "data:{\"a\":1}\n\n",
+			events: []sseEvent{{Data: `{"a":1}`}},
+		},
+		{
+			name:   "multi-line data",
+			input:  "data: {\"a\":\ndata: 1}\n\n",
+			events: []sseEvent{{Data: "{\"a\":\n1}"}},
+		},
+		{
+			name:   "comments and heartbeats",
+			input:  ": ping\n\n:keep-alive\ndata: x\n\n",
+			events: []sseEvent{{Data: "x"}},
+		},
+		{
+			name:   "event and id fields",
+			input:  "event: error\nid: 7\ndata: oops\n\ndata: next\n\n",
+			events: []sseEvent{{Event: "error", ID: "7", Data: "oops"}, {ID: "7", Data: "next"}},
+		},
+		{
+			name:   "crlf line endings",
+			input:  "data: x\r\n\r\ndata: y\r\n\r\n",
+			events: []sseEvent{{Data: "x"}, {Data: "y"}},
+		},
+		{
+			name:   "missing trailing blank line",
+			input:  "data: x\n\ndata: y",
+			events: []sseEvent{{Data: "x"}, {Data: "y"}},
+		},
+		{
+			name:   "event without data is dropped",
+			input:  "event: ping\n\ndata: x\n\n",
+			events: []sseEvent{{Data: "x"}},
+		},
+		{
+			name:   "leading BOM",
+			input:  "\ufeffdata: x\n\n",
+			events: []sseEvent{{Data: "x"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSSEReader(strings.NewReader(tt.input))
+			var got []sseEvent
+			for {
+				event, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next() error = %v", err)
+				}
+				got = append(got, *event)
+			}
+
+			if len(got) != len(tt.events) {
+				t.Fatalf("got %d events %+v, want %d", len(got), got, len(tt.events))
+			}
+			for i := range got {
+				if got[i] != tt.events[i] {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], tt.events[i])
+				}
+			}
+		})
+	}
+}
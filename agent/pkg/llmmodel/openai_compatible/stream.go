@@ -0,0 +1,477 @@
+package openai_compatible
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// errStreamDiverged is returned when a resumed stream does not reproduce the text already delivered
+var errStreamDiverged = errors.New("resumed stream diverged from already delivered content")
+
+// ErrStreamIdle is returned when a stream receives no data within the configured idle timeout
+var ErrStreamIdle = errors.New("stream idle timeout")
+
+// idleTimeoutReader closes the underlying body when a read waits longer than
+// timeout. Only time spent blocked on upstream counts, not consumer processing.
+type idleTimeoutReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// newIdleTimeoutReader wraps body with an inter-chunk idle timeout
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration) *idleTimeoutReader {
+	r := &idleTimeoutReader{
+		body:    body,
+		timeout: timeout,
+	}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.expired.Store(true)
+		r.body.Close()
+	})
+	r.timer.Stop()
+	return r
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.body.Read(p)
+	r.timer.Stop()
+
+	if r.expired.Load() {
+		return n, fmt.Errorf("%w: no data received for %s", ErrStreamIdle, r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
+
+// streamInterruptedError marks a transport failure after the stream started, which may be resumed
+type streamInterruptedError struct {
+	err error
+}
+
+func (e *streamInterruptedError) Error() string {
+	return fmt.Sprintf("failed to read stream: %v", e.err)
+}
+
+func (e *streamInterruptedError) Unwrap() error {
+	return e.err
+}
+
+// Is reports an interrupted stream as a network error
+func (e *streamInterruptedError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+// streamState tracks a streaming turn across reconnect attempts
+type streamState struct {
+	startTime      time.Time
+	firstChunkTime time.Time
+	chunkCount     int
+	accumulated    strings.Builder
+
+	// replayed counts bytes of accumulated content re-delivered by the current
+	// attempt; text is only yielded once replayed catches up with accumulated.
+	replayed int
+
+	// toolCalls accumulates tool call deltas by index. They are only emitted
+	// with the final response, so a resumed attempt simply starts over.
+	toolCalls []*toolCall
+	toolArgs  []*strings.Builder // Argument fragments of toolCalls, by index
+	names     *toolNames
+
+	// decoder decodes the chunks of the stream
+	decoder chunkDecoder
+
+	// inline collects the image, audio and file parts of the deltas, sent
+	// with the final response
+	inline []*genai.Part
+
+	// refusal accumulates refusal deltas, sent instead of content
+	refusal strings.Builder
+
+	// filtered collects the content filter categories that triggered (Azure)
+	filtered []string
+
+	// finishReason and usage arrive in the last chunks, before [DONE]
+	finishReason string
+	usage        *genai.GenerateContentResponseUsageMetadata
+
+	// meta identifies the attempt that completed the turn
+	meta responseMetadata
+}
+
+// finalResponse builds the turn-complete response carrying the full accumulated
+// content. It returns a *ResponseRefused when the provider refused.
+func (s *streamState) finalResponse() (*model.LLMResponse, error) {
+	if err := refusal(s.accumulated.String(), s.refusal.String(), s.finishReason, s.filtered); err != nil {
+		return nil, err
+	}
+
+	calls := make([]toolCall, 0, len(s.toolCalls))
+	for i, call := range s.toolCalls {
+		if call.Function.Name != "" {
+			call.Function.Arguments = s.toolArgs[i].String()
+			calls = append(calls, *call)
+		}
+	}
+	parts, err := convertToolCalls(calls, s.names)
+	if err != nil {
+		return nil, err
+	}
+	content := responseContent(s.accumulated.String(), s.inline, parts)
+
+	resp := &model.LLMResponse{
+		Content:        content,
+		UsageMetadata:  s.usage,
+		CustomMetadata: s.meta.custom(),
+		TurnComplete:   true,
+	}
+	if s.finishReason != "" {
+		resp.FinishReason = genai.FinishReason(s.finishReason)
+	}
+	return resp, nil
+}
+
+// yieldFinal yields the final response, or the error converting it
+func (s *streamState) yieldFinal(yield func(*model.LLMResponse, error) bool) {
+	resp, err := s.finalResponse()
+	yield(resp, err)
+}
+
+// generateContentStream handles streaming requests
+func (c *Client) generateContentStream(ctx context.Context, req *model.LLMRequest, yield func(*model.LLMResponse, error) bool) {
+	c.logger.InfoContext(ctx, "Starting streaming request")
+
+	state := &streamState{startTime: time.Now()}
+	state.accumulated.Grow(1024) // Pre-allocate capacity
+
+	shrunk := false // max_tokens was reduced after a context overflow, which is done once
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			backoff := c.streamRetryBackoff * time.Duration(1<<(attempt-1))
+			c.logger.WarnContext(ctx, "Resuming interrupted stream",
+				"attempt", attempt,
+				"max_attempts", c.streamRetries,
+				"delivered_length", state.accumulated.Len(),
+				"backoff", backoff,
+			)
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			case <-time.After(backoff):
+			}
+			state.replayed = 0
+			state.toolCalls = nil
+			state.toolArgs = nil
+			state.inline = nil
+			state.refusal.Reset()
+			state.filtered = nil
+			state.meta = responseMetadata{}
+		}
+
+		err := c.streamOnce(ctx, req, state, yield)
+		if reduced, ok := c.reduceMaxTokens(req, err); ok && !shrunk {
+			shrunk = true
+			req = reduced
+			err = c.streamOnce(ctx, req, state, yield)
+		}
+		if err == nil {
+			return
+		}
+
+		var interrupted *streamInterruptedError
+		if !errors.As(err, &interrupted) || attempt >= c.streamRetries || ctx.Err() != nil {
+			yield(nil, err)
+			return
+		}
+	}
+}
+
+// streamOnce performs one streaming HTTP request. It returns nil when the turn
+// completed or the consumer stopped, and a *streamInterruptedError when the
+// connection failed in a way that may be resumed.
+func (c *Client) streamOnce(ctx context.Context, req *model.LLMRequest, state *streamState, yield func(*model.LLMResponse, error) bool) error {
+	// Build HTTP request
+	httpReq, names, err := c.buildRequest(ctx, req, true)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Failed to build request", "error", err)
+		return err
+	}
+	defer releaseBody(httpReq.Body)
+	state.names = names
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Make HTTP request
+	c.logger.InfoContext(ctx, "Sending streaming HTTP request", "url", httpReq.URL.String())
+	requestTime := time.Now()
+
+	resp, err := c.httpClient.Do(httpReq)
+	elapsed := time.Since(requestTime)
+
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Streaming HTTP request failed",
+			"error", err,
+			"elapsed", elapsed,
+		)
+		if state.accumulated.Len() > 0 && ctx.Err() == nil {
+			// Reconnect attempt failed, keep it within the retry budget
+			return &streamInterruptedError{err: err}
+		}
+		return &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	state.meta.requestID = requestID(resp.Header)
+	c.logger.InfoContext(ctx, "Received streaming HTTP response",
+		"status", resp.StatusCode,
+		"elapsed", elapsed,
+		"request_id", state.meta.requestID,
+	)
+
+	if resp.StatusCode != http.StatusOK {
+		err := c.handleHTTPError(resp)
+		c.logger.ErrorContext(ctx, "Streaming API returned error", "error", err, "request_id", state.meta.requestID)
+		return err
+	}
+
+	// Parse streaming response (SSE format)
+	c.logger.InfoContext(ctx, "Starting to parse streaming response")
+	body := io.ReadCloser(resp.Body)
+	if c.streamIdleTimeout > 0 {
+		idle := newIdleTimeoutReader(resp.Body, c.streamIdleTimeout)
+		defer idle.Close()
+		body = idle
+	}
+	events := newSSEReader(body)
+
+	for {
+		// Check context cancellation
+		select {
+		case <-ctx.Done():
+			c.logger.WarnContext(ctx, "Context cancelled during streaming", "chunks_received", state.chunkCount)
+			return ctx.Err()
+		default:
+		}
+
+		event, err := events.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.logger.ErrorContext(ctx, "Failed to read stream", "error", err, "chunks_received", state.chunkCount)
+			return &streamInterruptedError{err: err}
+		}
+
+		switch event.Event {
+		case "", "message":
+		case "error":
+			err := parseStreamError(resp.StatusCode, event.Data)
+			c.logger.ErrorContext(ctx, "Stream returned error event", "error", err, "chunks_received", state.chunkCount, "request_id", state.meta.requestID)
+			return err
+		default:
+			c.logger.DebugContext(ctx, "Skipping SSE event", "event", event.Event)
+			continue
+		}
+
+		data := strings.TrimSpace(event.Data)
+		if data == "[DONE]" {
+			c.logger.InfoContext(ctx, "Stream completed with [DONE]", append(state.meta.logAttrs(),
+				"chunks_received", state.chunkCount,
+				"total_content_length", state.accumulated.Len(),
+			)...)
+
+			// Send final response
+			if state.accumulated.Len() > 0 || len(state.toolCalls) > 0 || len(state.inline) > 0 || state.refusal.Len() > 0 || state.finishReason != "" {
+				state.yieldFinal(yield)
+			}
+			return nil
+		}
+
+		streamChunk, err := state.decoder.decode(data)
+		if err != nil {
+			c.logger.WarnContext(ctx, "Failed to parse stream chunk, skipping", "error", err, logging.Content("data", data))
+			continue
+		}
+		state.meta.update(streamChunk.ID, streamChunk.Model, streamChunk.SystemFingerprint)
+
+		if streamChunk.Usage != nil {
+			state.usage = streamChunk.Usage.metadata()
+		}
+
+		// Only the first choice is streamed; several candidates are requested
+		// without streaming
+		i := slices.IndexFunc(streamChunk.Choices, func(c chunkChoice) bool { return c.Index == 0 })
+		if i < 0 {
+			continue
+		}
+		choice := streamChunk.Choices[i]
+		for _, delta := range choice.Delta.ToolCalls {
+			state.addToolCallDelta(delta)
+		}
+		state.refusal.WriteString(choice.Delta.Refusal)
+		for _, category := range choice.ContentFilterResults.filtered() {
+			if !slices.Contains(state.filtered, category) {
+				state.filtered = append(state.filtered, category)
+			}
+		}
+		state.inline = append(state.inline, c.inlineParts(slices.Concat(choice.Delta.Content.Parts, choice.Delta.Images))...)
+
+		if choice.Delta.Content.Text != "" {
+			delta, err := state.dedupe(choice.Delta.Content.Text)
+			if err != nil {
+				c.logger.ErrorContext(ctx, "Failed to resume stream", "error", err, "delivered_length", state.accumulated.Len())
+				return err
+			}
+
+			if delta != "" {
+				state.chunkCount++
+				if state.firstChunkTime.IsZero() {
+					state.firstChunkTime = time.Now()
+					c.logger.InfoContext(ctx, "First chunk received", "time_to_first_chunk", time.Since(state.startTime))
+				}
+
+				state.accumulated.WriteString(delta)
+				state.replayed = state.accumulated.Len()
+				llmResp := newPartialResponse(delta)
+
+				if state.chunkCount%10 == 0 {
+					c.logger.DebugContext(ctx, "Streaming progress",
+						"chunks", state.chunkCount,
+						"accumulated_length", state.accumulated.Len(),
+					)
+				}
+
+				if !yield(llmResp, nil) {
+					c.logger.InfoContext(ctx, "Yield returned false, stopping stream", "chunks_sent", state.chunkCount)
+					return nil
+				}
+			}
+		}
+
+		if reason := c.finishReason(choice.FinishReason); reason != "" {
+			// Keep reading: the usage chunk follows the finish reason
+			c.logger.InfoContext(ctx, "Stream finished",
+				"reason", reason,
+				"chunks_received", state.chunkCount,
+				"total_content_length", state.accumulated.Len(),
+				logging.Content("content", state.accumulated.String()),
+			)
+			state.finishReason = reason
+		}
+	}
+
+	// Some servers close the stream without [DONE]
+	if state.finishReason != "" {
+		state.yieldFinal(yield)
+	}
+
+	c.logger.InfoContext(ctx, "Streaming completed successfully", append(state.meta.logAttrs(), "total_chunks", state.chunkCount)...)
+	return nil
+}
+
+// dedupe strips the part of delta that a resumed stream re-delivers. It
+// returns the new text to emit, or errStreamDiverged when the replayed text
+// does not match what was already sent to the consumer.
+func (s *streamState) dedupe(delta string) (string, error) {
+	delivered := s.accumulated.String()
+	if s.replayed >= len(delivered) {
+		return delta, nil
+	}
+
+	pending := delivered[s.replayed:]
+	n := min(len(pending), len(delta))
+	if delta[:n] != pending[:n] {
+		return "", errStreamDiverged
+	}
+	s.replayed += n
+	return delta[n:], nil
+}
+
+// streamChunk is a chunk of a streamed completion
+type streamChunk struct {
+	ID                string        `json:"id"`
+	Model             string        `json:"model"`
+	SystemFingerprint string        `json:"system_fingerprint"`
+	Choices           []chunkChoice `json:"choices"`
+	Usage             *tokenUsage   `json:"usage"`
+}
+
+// chunkChoice is a choice of a stream chunk
+type chunkChoice struct {
+	Index int `json:"index"`
+	Delta struct {
+		Role      string         `json:"role"`
+		Content   messageContent `json:"content"`
+		Refusal   string         `json:"refusal"`
+		ToolCalls []toolCall     `json:"tool_calls"`
+		Images    []contentPart  `json:"images"`
+	} `json:"delta"`
+	FinishReason string `json:"finish_reason"`
+
+	ContentFilterResults filterResults `json:"content_filter_results"` // Azure
+}
+
+// chunkDecoder decodes the chunks of a stream into one chunk and one data
+// buffer, reused from chunk to chunk
+type chunkDecoder struct {
+	data  []byte
+	chunk streamChunk
+}
+
+// decode decodes data into the reused chunk, valid until the next call. The
+// choices are cleared first since the JSON decoder only overwrites the
+// fields present in the data.
+func (d *chunkDecoder) decode(data string) (*streamChunk, error) {
+	d.data = append(d.data[:0], data...)
+	choices := d.chunk.Choices[:cap(d.chunk.Choices)]
+	clear(choices)
+	d.chunk = streamChunk{Choices: choices[:0]}
+	if err := json.Unmarshal(d.data, &d.chunk); err != nil {
+		return nil, err
+	}
+	return &d.chunk, nil
+}
+
+// partialResponse holds a partial response with its content and part, so
+// each streamed delta costs one allocation
+type partialResponse struct {
+	resp    model.LLMResponse
+	content genai.Content
+	parts   [1]*genai.Part
+	part    genai.Part
+}
+
+// newPartialResponse returns the partial response of a text delta
+func newPartialResponse(text string) *model.LLMResponse {
+	p := &partialResponse{}
+	p.part.Text = text
+	p.parts[0] = &p.part
+	p.content = genai.Content{Role: genai.RoleModel, Parts: p.parts[:]}
+	p.resp = model.LLMResponse{Content: &p.content, Partial: true}
+	return &p.resp
+}
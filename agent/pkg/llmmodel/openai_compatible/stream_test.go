@@ -0,0 +1,187 @@
+package openai_compatible
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// writeChunks writes OpenAI-style SSE chunks for each delta
+func writeChunks(w http.ResponseWriter, deltas ...string) {
+	for _, d := range deltas {
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", d)
+	}
+	w.(http.Flusher).Flush()
+}
+
+// TestStreamResume tests that a dropped stream is re-issued and the replayed prefix is not delivered twice
+func TestStreamResume(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if calls.Add(1) == 1 {
+			writeChunks(w, "Hello", ", wor")
+			// Drop the connection mid-stream
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		writeChunks(w, "Hel", "lo, world", "!")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{
+		APIKey:             "test",
+		BaseURL:            srv.URL,
+		ModelName:          "test-model",
+		StreamRetries:      1,
+		StreamRetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	var partial strings.Builder
+	var final string
+	for resp, err := range client.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		if resp.Partial {
+			partial.WriteString(resp.Content.Parts[0].Text)
+		} else {
+			final = resp.Content.Parts[0].Text
+		}
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server called %d times, want 2", got)
+	}
+	if partial.String() != "Hello, world!" {
+		t.Errorf("partial text = %q, want %q", partial.String(), "Hello, world!")
+	}
+	if final != "Hello, world!" {
+		t.Errorf("final text = %q, want %q", final, "Hello, world!")
+	}
+}
+
+// TestStreamDedupeDiverged tests that a resumed stream with different text is rejected
+func TestStreamDedupeDiverged(t *testing.T) {
+	state := &streamState{}
+	state.accumulated.WriteString("Hello")
+
+	if _, err := state.dedupe("Howdy"); err != errStreamDiverged {
+		t.Errorf("dedupe() error = %v, want %v", err, errStreamDiverged)
+	}
+}
+
+// TestStreamIdleTimeout tests that a stream stalling after its first chunk is aborted with ErrStreamIdle
+func TestStreamIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunks(w, "Hello")
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client, err := NewClient(&ClientConfig{
+		APIKey:            "test",
+		BaseURL:           srv.URL,
+		ModelName:         "test-model",
+		StreamIdleTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	var lastErr error
+	for _, err := range client.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	if !errors.Is(lastErr, ErrStreamIdle) {
+		t.Errorf("GenerateContent() error = %v, want %v", lastErr, ErrStreamIdle)
+	}
+}
+
+// TestStreamToolCalls tests that streamed tool call fragments are assembled and mapped back to the declared name
+func TestStreamToolCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather_get","arguments":""}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("weather?", genai.RoleUser)},
+		Config: &genai.GenerateContentConfig{Tools: []*genai.Tool{{
+			FunctionDeclarations: []*genai.FunctionDeclaration{{Name: "weather.get"}},
+		}}},
+	}
+	var final *model.LLMResponse
+	for resp, err := range client.GenerateContent(context.Background(), req, true) {
+		if err != nil {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+		if !resp.Partial {
+			final = resp
+		}
+	}
+
+	if final == nil || len(final.Content.Parts) != 1 || final.Content.Parts[0].FunctionCall == nil {
+		t.Fatalf("expected a single function call part, got %+v", final)
+	}
+	fc := final.Content.Parts[0].FunctionCall
+	if fc.ID != "call_1" || fc.Name != "weather.get" || fc.Args["city"] != "Paris" {
+		t.Errorf("unexpected function call %+v", fc)
+	}
+}
+
+// TestChunkDecoder tests that a reused chunk carries nothing over from the previous one
+func TestChunkDecoder(t *testing.T) {
+	var d chunkDecoder
+	chunk, err := d.decode(`{"id":"c1","choices":[{"delta":{"content":"a","tool_calls":[{"index":0,"function":{"name":"f"}}]},"finish_reason":"tool_calls"}]}`)
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if chunk.Choices[0].FinishReason != "tool_calls" || len(chunk.Choices[0].Delta.ToolCalls) != 1 {
+		t.Fatalf("unexpected first chunk %+v", chunk)
+	}
+
+	chunk, err = d.decode(`{"choices":[{"delta":{"content":"b"}}]}`)
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	choice := chunk.Choices[0]
+	if chunk.ID != "" || choice.FinishReason != "" || len(choice.Delta.ToolCalls) != 0 || choice.Delta.Content.Text != "b" {
+		t.Errorf("second chunk kept fields of the first: %+v", chunk)
+	}
+
+	if _, err := d.decode(`{"choices":[`); err == nil {
+		t.Error("decode() of a truncated chunk succeeded")
+	}
+}
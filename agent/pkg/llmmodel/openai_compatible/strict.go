@@ -0,0 +1,180 @@
+package openai_compatible
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"google.golang.org/genai"
+)
+
+// schemaName matches the names OpenAI accepts for a response schema
+var schemaName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// responseFormat returns the response_format of config: a strict
+// json_schema when it declares a schema, json_object when it only asks for
+// JSON, nil otherwise. The schema is named after its title.
+func responseFormat(config *genai.GenerateContentConfig) (map[string]any, error) {
+	var schema map[string]any
+	var err error
+	switch {
+	case config.ResponseJsonSchema != nil:
+		schema, err = convertJSONSchema(config.ResponseJsonSchema)
+	case config.ResponseSchema != nil:
+		schema, err = convertSchema(config.ResponseSchema)
+	case config.ResponseMIMEType == "application/json":
+		return map[string]any{"type": "json_object"}, nil
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert response schema: %w", err)
+	}
+	name, _ := schema["title"].(string)
+	if !schemaName.MatchString(name) {
+		name = "response"
+	}
+	strictSchema(schema)
+	return map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   name,
+			"schema": schema,
+			"strict": true,
+		},
+	}, nil
+}
+
+// ApplyStrictMode rewrites converted tools into OpenAI strict function schemas:
+// every function gets "strict": true, every object schema gets
+// "additionalProperties": false and lists all of its properties in "required".
+// Properties that were optional become nullable so the model can still omit a value.
+func ApplyStrictMode(tools []map[string]any) {
+	for _, tool := range tools {
+		function, ok := tool["function"].(map[string]any)
+		if !ok {
+			continue
+		}
+		function["strict"] = true
+
+		params, ok := function["parameters"].(map[string]any)
+		if !ok {
+			// Strict mode requires a parameters object
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+			function["parameters"] = params
+		}
+		strictSchema(params)
+	}
+}
+
+// strictSchema applies strict mode rules to schema and its subschemas in place
+func strictSchema(schema map[string]any) {
+	if isObjectSchema(schema) {
+		schema["additionalProperties"] = false
+
+		properties, _ := schema["properties"].(map[string]any)
+		if properties == nil {
+			properties = map[string]any{}
+			schema["properties"] = properties
+		}
+
+		required := make(map[string]bool)
+		for _, name := range stringList(schema["required"]) {
+			required[name] = true
+		}
+
+		names := make([]string, 0, len(properties))
+		for name, prop := range properties {
+			names = append(names, name)
+			if propSchema, ok := prop.(map[string]any); ok && !required[name] {
+				makeNullable(propSchema)
+			}
+		}
+		sort.Strings(names)
+		schema["required"] = names
+	}
+
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		if children, ok := schema[key].(map[string]any); ok {
+			for _, child := range children {
+				if childSchema, ok := child.(map[string]any); ok {
+					strictSchema(childSchema)
+				}
+			}
+		}
+	}
+
+	if items, ok := schema["items"].(map[string]any); ok {
+		strictSchema(items)
+	}
+
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		switch alts := schema[key].(type) {
+		case []map[string]any:
+			for _, alt := range alts {
+				strictSchema(alt)
+			}
+		case []any:
+			for _, alt := range alts {
+				if altSchema, ok := alt.(map[string]any); ok {
+					strictSchema(altSchema)
+				}
+			}
+		}
+	}
+}
+
+// isObjectSchema reports whether schema declares type object
+func isObjectSchema(schema map[string]any) bool {
+	for _, t := range typeList(schema["type"]) {
+		if t == "object" {
+			return true
+		}
+	}
+	return false
+}
+
+// makeNullable adds "null" to the schema type
+func makeNullable(schema map[string]any) {
+	types := typeList(schema["type"])
+	if len(types) == 0 {
+		if alts, ok := schema["anyOf"].([]map[string]any); ok {
+			schema["anyOf"] = append(alts, map[string]any{"type": "null"})
+		}
+		return
+	}
+	for _, t := range types {
+		if t == "null" {
+			return
+		}
+	}
+	schema["type"] = append(types, "null")
+}
+
+// typeList normalizes a JSON schema "type" value to a list
+func typeList(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	default:
+		return stringList(v)
+	}
+}
+
+// stringList normalizes []string or []any of strings
+func stringList(v any) []string {
+	switch list := v.(type) {
+	case []string:
+		return append([]string(nil), list...)
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
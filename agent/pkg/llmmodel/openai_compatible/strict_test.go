@@ -0,0 +1,60 @@
+package openai_compatible
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// TestApplyStrictMode tests that strict mode closes objects and requires every property
+func TestApplyStrictMode(t *testing.T) {
+	tools, err := ConvertFunctionDeclarations([]*genai.Tool{{
+		FunctionDeclarations: []*genai.FunctionDeclaration{{
+			Name: "search",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"query": {Type: genai.TypeString},
+					"limit": {Type: genai.TypeInteger},
+					"filter": {
+						Type:       genai.TypeObject,
+						Properties: map[string]*genai.Schema{"lang": {Type: genai.TypeString}},
+					},
+				},
+				Required: []string{"query"},
+			},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertFunctionDeclarations() error = %v", err)
+	}
+
+	ApplyStrictMode(tools)
+
+	function := tools[0]["function"].(map[string]any)
+	if function["strict"] != true {
+		t.Errorf("expected strict: true, got %v", function["strict"])
+	}
+
+	params := function["parameters"].(map[string]any)
+	if params["additionalProperties"] != false {
+		t.Errorf("expected additionalProperties: false, got %v", params["additionalProperties"])
+	}
+	if got := params["required"]; !reflect.DeepEqual(got, []string{"filter", "limit", "query"}) {
+		t.Errorf("expected all properties required, got %v", got)
+	}
+
+	props := params["properties"].(map[string]any)
+	if got := props["query"].(map[string]any)["type"]; got != "string" {
+		t.Errorf("required property should stay non-nullable, got %v", got)
+	}
+	if got := props["limit"].(map[string]any)["type"]; !reflect.DeepEqual(got, []string{"integer", "null"}) {
+		t.Errorf("optional property should become nullable, got %v", got)
+	}
+
+	filter := props["filter"].(map[string]any)
+	if filter["additionalProperties"] != false || !reflect.DeepEqual(filter["required"], []string{"lang"}) {
+		t.Errorf("nested object not made strict: %v", filter)
+	}
+}
@@ -0,0 +1,107 @@
+package openai_compatible
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// convertFunctionCall converts a function call from history to an OpenAI tool call
+func convertFunctionCall(call *genai.FunctionCall, names *toolNames) (map[string]any, error) {
+	args := names.sanitizeArgs(call.Name, call.Args)
+	if args == nil {
+		args = map[string]any{}
+	}
+	arguments, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal arguments for tool %s: %w", call.Name, err)
+	}
+	return map[string]any{
+		"id":   toolCallID(call.ID, call.Name),
+		"type": "function",
+		"function": map[string]any{
+			"name":      names.toolName(call.Name),
+			"arguments": string(arguments),
+		},
+	}, nil
+}
+
+// convertFunctionResponse converts a function response from history to an OpenAI tool message
+func convertFunctionResponse(resp *genai.FunctionResponse) (map[string]any, error) {
+	result, err := json.Marshal(resp.Response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response of tool %s: %w", resp.Name, err)
+	}
+	return map[string]any{
+		"role":         "tool",
+		"tool_call_id": toolCallID(resp.ID, resp.Name),
+		"content":      string(result),
+	}, nil
+}
+
+// toolCallID returns id, or an id derived from the tool name for calls that carry
+// none, so a call and its response always pair up
+func toolCallID(id, name string) string {
+	if id != "" {
+		return id
+	}
+	return "call_" + sanitizeName(name)
+}
+
+// toolCall is an OpenAI tool call from a response message or stream delta
+type toolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// convertToolCalls converts OpenAI tool calls to genai function call parts,
+// translating names back to the original ADK tool and property names
+func convertToolCalls(calls []toolCall, names *toolNames) ([]*genai.Part, error) {
+	parts := make([]*genai.Part, 0, len(calls))
+	for _, call := range calls {
+		name := names.originalToolName(call.Function.Name)
+
+		args := map[string]any{}
+		if strings.TrimSpace(call.Function.Arguments) != "" {
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("failed to parse arguments for tool %s: %w", name, err)
+			}
+		}
+
+		parts = append(parts, &genai.Part{
+			FunctionCall: &genai.FunctionCall{
+				ID:   call.ID,
+				Name: name,
+				Args: names.restoreArgs(name, args),
+			},
+		})
+	}
+	return parts, nil
+}
+
+// addToolCallDelta merges a streamed tool call fragment into the accumulated calls
+func (s *streamState) addToolCallDelta(delta toolCall) {
+	if delta.Index < 0 {
+		return
+	}
+	for len(s.toolCalls) <= delta.Index {
+		s.toolCalls = append(s.toolCalls, &toolCall{Index: len(s.toolCalls)})
+		s.toolArgs = append(s.toolArgs, &strings.Builder{})
+	}
+	call := s.toolCalls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Type != "" {
+		call.Type = delta.Type
+	}
+	call.Function.Name += delta.Function.Name
+	s.toolArgs[delta.Index].WriteString(delta.Function.Arguments)
+}
@@ -0,0 +1,155 @@
+package openai_compatible
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// toolCallServer answers the first request with two tool calls, streamed as
+// interleaved deltas when the request streams, and records the request bodies
+func toolCallServer(t *testing.T, bodies *[]map[string]any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		*bodies = append(*bodies, body)
+		if len(*bodies) > 1 {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"Sunny in Paris, 9:00 there"},"finish_reason":"stop"}]}`)
+			return
+		}
+		if body["stream"] != true {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"Checking.","tool_calls":[`+
+				`{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Paris\"}"}},`+
+				`{"id":"call_2","type":"function","function":{"name":"clock","arguments":""}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{
+			`{"content":"Checking."}`,
+			`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":""}}]}`,
+			`{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"clock","arguments":""}}]}`,
+			`{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}`,
+			`{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}`,
+		} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":%s}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\ndata: [DONE]\n\n")
+	}))
+}
+
+// TestToolCallRoundTrip tests that the tool calls of a response, streamed or
+// not, become function calls, and that sending them back with their results
+// produces an assistant tool_calls message followed by paired tool messages
+func TestToolCallRoundTrip(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			var bodies []map[string]any
+			srv := toolCallServer(t, &bodies)
+			defer srv.Close()
+
+			client, err := NewClient(&ClientConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "test-model"})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			generate := func(req *model.LLMRequest) *model.LLMResponse {
+				var final *model.LLMResponse
+				for resp, err := range client.GenerateContent(context.Background(), req, stream) {
+					if err != nil {
+						t.Fatalf("GenerateContent() error = %v", err)
+					}
+					if !resp.Partial {
+						final = resp
+					}
+				}
+				return final
+			}
+
+			history := []*genai.Content{genai.NewContentFromText("Weather and time in Paris?", genai.RoleUser)}
+			first := generate(&model.LLMRequest{Contents: history})
+			var calls []*genai.FunctionCall
+			for _, part := range first.Content.Parts {
+				if part.FunctionCall != nil {
+					calls = append(calls, part.FunctionCall)
+				}
+			}
+			if len(calls) != 2 {
+				t.Fatalf("function calls = %+v, want 2", first.Content.Parts)
+			}
+			if calls[0].ID != "call_1" || calls[0].Name != "weather" || calls[0].Args["city"] != "Paris" {
+				t.Errorf("first call = %+v", calls[0])
+			}
+			if calls[1].ID != "call_2" || calls[1].Name != "clock" || len(calls[1].Args) != 0 {
+				t.Errorf("second call = %+v", calls[1])
+			}
+
+			history = append(history, first.Content, &genai.Content{Role: genai.RoleUser, Parts: []*genai.Part{
+				{FunctionResponse: &genai.FunctionResponse{ID: "call_1", Name: "weather", Response: map[string]any{"sky": "sunny"}}},
+				{FunctionResponse: &genai.FunctionResponse{ID: "call_2", Name: "clock", Response: map[string]any{"time": "9:00"}}},
+			}})
+			generate(&model.LLMRequest{Contents: history})
+
+			got, _ := json.Marshal(bodies[1]["messages"])
+			want := `[{"content":"Weather and time in Paris?","role":"user"},` +
+				`{"content":"Checking.","role":"assistant","tool_calls":[` +
+				`{"function":{"arguments":"{\"city\":\"Paris\"}","name":"weather"},"id":"call_1","type":"function"},` +
+				`{"function":{"arguments":"{}","name":"clock"},"id":"call_2","type":"function"}]},` +
+				`{"content":"{\"sky\":\"sunny\"}","role":"tool","tool_call_id":"call_1"},` +
+				`{"content":"{\"time\":\"9:00\"}","role":"tool","tool_call_id":"call_2"}]`
+			if string(got) != want {
+				t.Errorf("messages = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+// TestToolCallID tests that calls and responses without an ID still pair up
+func TestToolCallID(t *testing.T) {
+	contents := []*genai.Content{
+		{Role: genai.RoleModel, Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{Name: "get time"}}}},
+		{Role: genai.RoleUser, Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{Name: "get time", Response: map[string]any{}}}}},
+	}
+	messages, err := ConvertContentsToMessages(contents)
+	if err != nil {
+		t.Fatalf("ConvertContentsToMessages() error = %v", err)
+	}
+	call := messages[0]["tool_calls"].([]map[string]any)[0]
+	if call["id"] != "call_get_time" || messages[1]["tool_call_id"] != call["id"] {
+		t.Errorf("call id %v, tool_call_id %v", call["id"], messages[1]["tool_call_id"])
+	}
+}
+
+// TestAddToolCallDelta tests assembling deltas that arrive out of order
+func TestAddToolCallDelta(t *testing.T) {
+	var s streamState
+	deltas := []string{
+		`{"index":1,"id":"b","function":{"name":"clock"}}`,
+		`{"index":0,"id":"a","type":"function","function":{"name":"wea","arguments":"{\"ci"}}`,
+		`{"index":0,"function":{"name":"ther","arguments":"ty\":1}"}}`,
+		`{"index":-1,"function":{"name":"ignored"}}`,
+	}
+	for _, data := range deltas {
+		var delta toolCall
+		if err := json.Unmarshal([]byte(data), &delta); err != nil {
+			t.Fatal(err)
+		}
+		s.addToolCallDelta(delta)
+	}
+	if len(s.toolCalls) != 2 {
+		t.Fatalf("tool calls = %d, want 2", len(s.toolCalls))
+	}
+	first, second := s.toolCalls[0], s.toolCalls[1]
+	if first.ID != "a" || first.Function.Name != "weather" || s.toolArgs[0].String() != `{"city":1}` {
+		t.Errorf("first call = %+v with arguments %q", first, s.toolArgs[0].String())
+	}
+	if second.ID != "b" || second.Function.Name != "clock" || s.toolArgs[1].String() != "" {
+		t.Errorf("second call = %+v", second)
+	}
+}
@@ -0,0 +1,279 @@
+package openai_compatible
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+// maxToolNameLength is the longest function name OpenAI accepts
+const maxToolNameLength = 64
+
+// validToolName matches the function and property names OpenAI accepts
+var validToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// toolNames is a reversible mapping between ADK tool and property names and
+// the names sent to the provider. Names that are already valid are kept as is;
+// invalid characters are replaced by '_', and names that are too long or would
+// collide get a short hash of the original name appended.
+type toolNames struct {
+	sanitized map[string]string // original tool name -> sanitized
+	original  map[string]string // sanitized tool name -> original
+
+	// Property names are mapped per tool, keyed by the original tool name
+	properties map[string]*nameMap
+}
+
+// nameMap is a bidirectional name mapping within one namespace
+type nameMap struct {
+	sanitized map[string]string
+	original  map[string]string
+}
+
+func newNameMap() *nameMap {
+	return &nameMap{
+		sanitized: make(map[string]string),
+		original:  make(map[string]string),
+	}
+}
+
+// add registers names, keeping valid names first so they never get renamed
+// because of an invalid name that sanitizes to the same string
+func (m *nameMap) add(names []string) {
+	for _, name := range names {
+		if validToolName.MatchString(name) {
+			m.sanitized[name] = name
+			m.original[name] = name
+		}
+	}
+	for _, name := range names {
+		m.sanitize(name)
+	}
+}
+
+// sanitize returns the provider name for name, registering it if needed
+func (m *nameMap) sanitize(name string) string {
+	if s, ok := m.sanitized[name]; ok {
+		return s
+	}
+
+	s := sanitizeName(name)
+	if _, taken := m.original[s]; taken || len(s) > maxToolNameLength {
+		s = hashedName(s, name)
+	}
+	m.sanitized[name] = s
+	m.original[s] = name
+	return s
+}
+
+// restore returns the original name for a provider name, or the name itself when unknown
+func (m *nameMap) restore(name string) string {
+	if o, ok := m.original[name]; ok {
+		return o
+	}
+	return name
+}
+
+// sanitizeName replaces the characters OpenAI does not accept in names
+func sanitizeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// hashedName shortens s and appends a hash of the original name to keep it unique
+func hashedName(s, original string) string {
+	h := fnv.New32a()
+	h.Write([]byte(original))
+	suffix := fmt.Sprintf("_%08x", h.Sum32())
+	if len(s) > maxToolNameLength-len(suffix) {
+		s = s[:maxToolNameLength-len(suffix)]
+	}
+	return s + suffix
+}
+
+// sanitizeTools rewrites tool and property names of converted tools in place
+// and returns the mapping needed to translate names back
+func sanitizeTools(tools []map[string]any) *toolNames {
+	names := &toolNames{
+		sanitized:  make(map[string]string),
+		original:   make(map[string]string),
+		properties: make(map[string]*nameMap),
+	}
+
+	functions := make([]map[string]any, 0, len(tools))
+	originals := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		function, ok := tool["function"].(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := function["name"].(string)
+		functions = append(functions, function)
+		originals = append(originals, name)
+	}
+
+	toolMap := &nameMap{sanitized: names.sanitized, original: names.original}
+	toolMap.add(originals)
+
+	for i, function := range functions {
+		function["name"] = toolMap.sanitize(originals[i])
+
+		params, ok := function["parameters"].(map[string]any)
+		if !ok {
+			continue
+		}
+		var props []string
+		collectPropertyNames(params, &props)
+		if len(props) == 0 {
+			continue
+		}
+		propMap := newNameMap()
+		propMap.add(props)
+		renameProperties(params, propMap)
+		names.properties[originals[i]] = propMap
+	}
+
+	return names
+}
+
+// toolName returns the provider name of an ADK tool. A nil mapping sanitizes
+// without collision handling, which is enough for tools that were not declared.
+func (n *toolNames) toolName(name string) string {
+	if n == nil {
+		s := sanitizeName(name)
+		if len(s) > maxToolNameLength {
+			s = hashedName(s, name)
+		}
+		return s
+	}
+	if s, ok := n.sanitized[name]; ok {
+		return s
+	}
+	return (&nameMap{sanitized: n.sanitized, original: n.original}).sanitize(name)
+}
+
+// originalToolName returns the ADK tool name for a provider name
+func (n *toolNames) originalToolName(name string) string {
+	if n == nil {
+		return name
+	}
+	if o, ok := n.original[name]; ok {
+		return o
+	}
+	return name
+}
+
+// sanitizeArgs renames argument keys of a call to tool the same way its schema was renamed
+func (n *toolNames) sanitizeArgs(tool string, args map[string]any) map[string]any {
+	if n == nil || n.properties[tool] == nil {
+		return args
+	}
+	return renameArgs(args, n.properties[tool].sanitize).(map[string]any)
+}
+
+// restoreArgs renames argument keys of a call to tool back to the original property names
+func (n *toolNames) restoreArgs(tool string, args map[string]any) map[string]any {
+	if n == nil || n.properties[tool] == nil {
+		return args
+	}
+	return renameArgs(args, n.properties[tool].restore).(map[string]any)
+}
+
+// collectPropertyNames appends the property names of schema and its subschemas
+func collectPropertyNames(schema map[string]any, names *[]string) {
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		for name := range properties {
+			*names = append(*names, name)
+		}
+	}
+	forEachSubschema(schema, func(child map[string]any) {
+		collectPropertyNames(child, names)
+	})
+}
+
+// renameProperties renames properties and required entries of schema and its subschemas in place
+func renameProperties(schema map[string]any, m *nameMap) {
+	forEachSubschema(schema, func(child map[string]any) {
+		renameProperties(child, m)
+	})
+
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		renamed := make(map[string]any, len(properties))
+		for name, prop := range properties {
+			renamed[m.sanitize(name)] = prop
+		}
+		schema["properties"] = renamed
+	}
+
+	if required := stringList(schema["required"]); len(required) > 0 {
+		renamed := make([]string, len(required))
+		for i, name := range required {
+			renamed[i] = m.sanitize(name)
+		}
+		schema["required"] = renamed
+	}
+}
+
+// forEachSubschema calls fn for the direct subschemas of schema
+func forEachSubschema(schema map[string]any, fn func(map[string]any)) {
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		if children, ok := schema[key].(map[string]any); ok {
+			for _, child := range children {
+				if childSchema, ok := child.(map[string]any); ok {
+					fn(childSchema)
+				}
+			}
+		}
+	}
+
+	for _, key := range []string{"items", "additionalProperties"} {
+		if child, ok := schema[key].(map[string]any); ok {
+			fn(child)
+		}
+	}
+
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		switch alts := schema[key].(type) {
+		case []map[string]any:
+			for _, alt := range alts {
+				fn(alt)
+			}
+		case []any:
+			for _, alt := range alts {
+				if altSchema, ok := alt.(map[string]any); ok {
+					fn(altSchema)
+				}
+			}
+		}
+	}
+}
+
+// renameArgs returns a copy of v with all object keys passed through rename
+func renameArgs(v any, rename func(string) string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		renamed := make(map[string]any, len(v))
+		for key, value := range v {
+			renamed[rename(key)] = renameArgs(value, rename)
+		}
+		return renamed
+	case []any:
+		renamed := make([]any, len(v))
+		for i, value := range v {
+			renamed[i] = renameArgs(value, rename)
+		}
+		return renamed
+	default:
+		return v
+	}
+}
@@ -0,0 +1,116 @@
+package openai_compatible
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// TestSanitizeTools tests that invalid tool and property names are rewritten and map back
+func TestSanitizeTools(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	tools := []map[string]any{
+		{"type": "function", "function": map[string]any{
+			"name": "weather.get",
+			"parameters": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"city.name": map[string]any{"type": "string"},
+					"units":     map[string]any{"type": "string"},
+				},
+				"required": []string{"city.name"},
+			},
+		}},
+		{"type": "function", "function": map[string]any{"name": "weather_get"}},
+		{"type": "function", "function": map[string]any{"name": long}},
+	}
+
+	names := sanitizeTools(tools)
+
+	got := make([]string, len(tools))
+	for i, tool := range tools {
+		got[i] = tool["function"].(map[string]any)["name"].(string)
+		if !validToolName.MatchString(got[i]) {
+			t.Errorf("tool %d: invalid sanitized name %q", i, got[i])
+		}
+	}
+	if got[1] != "weather_get" {
+		t.Errorf("valid name should be kept, got %q", got[1])
+	}
+	if got[0] == got[1] {
+		t.Errorf("colliding names should differ, both are %q", got[0])
+	}
+	for i, original := range []string{"weather.get", "weather_get", long} {
+		if restored := names.originalToolName(got[i]); restored != original {
+			t.Errorf("originalToolName(%q) = %q, want %q", got[i], restored, original)
+		}
+	}
+
+	params := tools[0]["function"].(map[string]any)["parameters"].(map[string]any)
+	properties := params["properties"].(map[string]any)
+	if _, ok := properties["city_name"]; !ok {
+		t.Errorf("expected sanitized property city_name, got %v", properties)
+	}
+	if required := params["required"].([]string); required[0] != "city_name" {
+		t.Errorf("expected sanitized required entry, got %v", required)
+	}
+
+	args := names.restoreArgs("weather.get", map[string]any{"city_name": "Paris", "units": "metric"})
+	if args["city.name"] != "Paris" || args["units"] != "metric" {
+		t.Errorf("restoreArgs() = %v", args)
+	}
+}
+
+// TestConvertContentsToMessages_FunctionCalls tests that tool calls and results round trip through history
+func TestConvertContentsToMessages_FunctionCalls(t *testing.T) {
+	tools := []map[string]any{
+		{"type": "function", "function": map[string]any{
+			"name": "weather.get",
+			"parameters": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"city.name": map[string]any{"type": "string"}},
+			},
+		}},
+	}
+	names := sanitizeTools(tools)
+
+	contents := []*genai.Content{
+		{Role: genai.RoleModel, Parts: []*genai.Part{
+			{FunctionCall: &genai.FunctionCall{ID: "call_1", Name: "weather.get", Args: map[string]any{"city.name": "Paris"}}},
+		}},
+		{Role: genai.RoleUser, Parts: []*genai.Part{
+			{FunctionResponse: &genai.FunctionResponse{ID: "call_1", Name: "weather.get", Response: map[string]any{"temp": 21}}},
+		}},
+	}
+
+	messages, err := convertContents(contents, names)
+	if err != nil {
+		t.Fatalf("convertContents() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+
+	call := messages[0]["tool_calls"].([]map[string]any)[0]
+	function := call["function"].(map[string]any)
+	if call["id"] != "call_1" || function["name"] != "weather_get" || function["arguments"] != `{"city_name":"Paris"}` {
+		t.Errorf("unexpected tool call %v", call)
+	}
+	if messages[1]["role"] != "tool" || messages[1]["tool_call_id"] != "call_1" || messages[1]["content"] != `{"temp":21}` {
+		t.Errorf("unexpected tool message %v", messages[1])
+	}
+
+	// The provider answers with sanitized names, which map back to the ADK names
+	var calls []toolCall
+	json.Unmarshal([]byte(`[{"id":"call_2","type":"function","function":{"name":"weather_get","arguments":"{\"city_name\":\"Rome\"}"}}]`), &calls)
+	parts, err := convertToolCalls(calls, names)
+	if err != nil {
+		t.Fatalf("convertToolCalls() error = %v", err)
+	}
+	fc := parts[0].FunctionCall
+	if fc.ID != "call_2" || fc.Name != "weather.get" || fc.Args["city.name"] != "Rome" {
+		t.Errorf("unexpected function call %+v", fc)
+	}
+}
@@ -0,0 +1,139 @@
+package llmmodel
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"maps"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/model"
+)
+
+// defaultOpenRouterModel is OpenRouter's auto router
+const defaultOpenRouterModel = "openrouter/auto"
+
+// OpenRouterModel implements the model.LLM interface for OpenRouter
+type OpenRouterModel struct {
+	client *openai_compatible.Client
+}
+
+// OpenRouterConfig holds configuration for OpenRouter model
+type OpenRouterConfig struct {
+	APIKey    string
+	BaseURL   string // Optional, defaults to https://openrouter.ai/api
+	ModelName string // Optional, defaults to openrouter/auto, e.g. "deepseek/deepseek-chat"
+
+	ClientOptions
+
+	SiteURL    string                         // Optional, sent as HTTP-Referer for app attribution
+	AppName    string                         // Optional, sent as X-Title, defaults to yanshu
+	Models     []string                       // Optional, fallback models tried in order when the primary fails
+	Transforms []string                       // Optional, e.g. ["middle-out"] to compress long prompts
+	Provider   *OpenRouterProviderPreferences // Optional, upstream provider routing
+
+	// CacheControl marks large stable prompt prefixes with cache_control
+	// breakpoints. Nil enables it for Anthropic models, which only cache
+	// marked prefixes; the other providers cache automatically.
+	CacheControl *bool
+}
+
+// OpenRouterProviderPreferences controls which upstream providers OpenRouter routes to
+type OpenRouterProviderPreferences struct {
+	Order             []string `json:"order,omitempty"`              // Providers to try first, in order
+	Only              []string `json:"only,omitempty"`               // Restrict routing to these providers
+	Ignore            []string `json:"ignore,omitempty"`             // Never route to these providers
+	AllowFallbacks    *bool    `json:"allow_fallbacks,omitempty"`    // Allow providers outside Order, default true
+	RequireParameters bool     `json:"require_parameters,omitempty"` // Only use providers supporting all request parameters
+	DataCollection    string   `json:"data_collection,omitempty"`    // "allow" or "deny"
+	Sort              string   `json:"sort,omitempty"`               // "price", "throughput" or "latency"
+}
+
+// NewOpenRouterModel creates a new OpenRouter model instance
+func NewOpenRouterModel(ctx context.Context, cfg *OpenRouterConfig) (model.LLM, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("API key is required")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = "https://openrouter.ai/api"
+	}
+
+	modelName := cfg.ModelName
+	if modelName == "" {
+		modelName = defaultOpenRouterModel
+	}
+	for _, name := range append([]string{modelName}, cfg.Models...) {
+		if !strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid OpenRouter model %q (expected vendor/model, e.g. deepseek/deepseek-chat)", name)
+		}
+	}
+
+	if p := cfg.Provider; p != nil {
+		if p.DataCollection != "" && p.DataCollection != "allow" && p.DataCollection != "deny" {
+			return nil, fmt.Errorf("invalid data collection %q (must be allow or deny)", p.DataCollection)
+		}
+		if p.Sort != "" && p.Sort != "price" && p.Sort != "throughput" && p.Sort != "latency" {
+			return nil, fmt.Errorf("invalid provider sort %q (must be price, throughput or latency)", p.Sort)
+		}
+	}
+
+	appName := cfg.AppName
+	if appName == "" {
+		appName = "yanshu"
+	}
+	headers := map[string]string{"X-Title": appName}
+	if cfg.SiteURL != "" {
+		headers["HTTP-Referer"] = cfg.SiteURL
+	}
+	maps.Copy(headers, cfg.Headers)
+
+	extraBody := map[string]any{}
+	if len(cfg.Models) > 0 {
+		extraBody["models"] = cfg.Models
+	}
+	if len(cfg.Transforms) > 0 {
+		extraBody["transforms"] = cfg.Transforms
+	}
+	if cfg.Provider != nil {
+		extraBody["provider"] = cfg.Provider
+	}
+
+	cacheControl := strings.HasPrefix(modelName, "anthropic/")
+	if cfg.CacheControl != nil {
+		cacheControl = *cfg.CacheControl
+	}
+
+	clientCfg := cfg.clientConfig(cfg.APIKey, baseURL, modelName, true, openAIInputTypes)
+	clientCfg.Headers = headers
+	clientCfg.ExtraBody = extraBody
+	clientCfg.CacheControl = cacheControl
+	client, err := openai_compatible.NewClient(clientCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return &OpenRouterModel{
+		client: client,
+	}, nil
+}
+
+// Name returns the model name
+func (m *OpenRouterModel) Name() string {
+	return m.client.ModelName()
+}
+
+// GenerateContent implements the model.LLM interface
+func (m *OpenRouterModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return m.client.GenerateContent(ctx, req, stream)
+}
+
+// ListModels returns the models the provider serves
+func (m *OpenRouterModel) ListModels(ctx context.Context) ([]string, error) {
+	return m.client.ListModels(ctx)
+}
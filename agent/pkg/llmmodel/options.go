@@ -0,0 +1,78 @@
+package llmmodel
+
+import (
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/envelope"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/tokenizer"
+)
+
+// ClientOptions holds the options shared by the OpenAI-compatible providers,
+// embedded in their configs
+type ClientOptions struct {
+	Timeout time.Duration // Optional, defaults to 5 minutes
+
+	StreamRetries     int           // Optional, re-issue interrupted streams up to N times
+	StreamIdleTimeout time.Duration // Optional, abort streams idle for longer than this
+	StreamUsage       *bool         // Optional, ask for token usage at the end of streams, defaults to the provider's
+	StrictTools       bool          // Optional, emit OpenAI strict function schemas
+
+	AuthHeader string            // Optional, header carrying the API key, defaults to Authorization (Bearer)
+	Headers    map[string]string // Optional, added to every request
+
+	Tokenizer       tokenizer.Tokenizer // Optional, estimates prompt tokens, defaults by model name
+	ContextWindow   int                 // Optional, context length in tokens, warns on larger prompts; presets default to the known window of the model
+	TruncateHistory bool                // Optional, drop the oldest turns of prompts above ContextWindow
+
+	MaxInlineDataSize int      // Optional, caps each decoded image, audio or file of a response, defaults to 20MB
+	InputMIMETypes    []string // Optional, inline data types the model accepts, defaults to the provider's
+	MaxImageSize      int      // Optional, images above this many bytes are downscaled, defaults to 20MB
+	MaxImageDimension int      // Optional, images with a longer side are downscaled
+
+	DumpDir    string           // Optional, writes every request and raw response to files of this directory
+	DumpSealer *envelope.Sealer // Optional, encrypts the dump files
+
+	MaxConcurrentRequests int           // Optional, requests in flight to the provider, more queue; 0 is unlimited
+	QueueTimeout          time.Duration // Optional, how long a request waits for a slot, 0 until its context is done
+	CompressRequests      bool          // Optional, gzip request bodies of 1KiB and more
+
+	CircuitBreaker *openai_compatible.BreakerConfig // Optional, fail fast while the endpoint is persistently erroring
+}
+
+// clientConfig returns the client configuration of the options for the
+// endpoint. Streams ask for usage and inline data is restricted as the
+// options say, or by the provider's defaults.
+func (o *ClientOptions) clientConfig(apiKey, baseURL, modelName string, providerUsage bool, providerTypes []string) *openai_compatible.ClientConfig {
+	return &openai_compatible.ClientConfig{
+		APIKey:    apiKey,
+		BaseURL:   baseURL,
+		ModelName: modelName,
+		Timeout:   o.Timeout,
+
+		StreamRetries:     o.StreamRetries,
+		StreamIdleTimeout: o.StreamIdleTimeout,
+		StreamUsage:       streamUsage(o.StreamUsage, providerUsage),
+		StrictTools:       o.StrictTools,
+
+		AuthHeader: o.AuthHeader,
+		Headers:    o.Headers,
+
+		Tokenizer:       o.Tokenizer,
+		ContextWindow:   o.ContextWindow,
+		TruncateHistory: o.TruncateHistory,
+
+		MaxInlineDataSize: o.MaxInlineDataSize,
+		InputMIMETypes:    inputTypes(o.InputMIMETypes, providerTypes),
+		MaxImageSize:      o.MaxImageSize,
+		MaxImageDimension: o.MaxImageDimension,
+
+		DumpDir:    o.DumpDir,
+		DumpSealer: o.DumpSealer,
+
+		MaxConcurrentRequests: o.MaxConcurrentRequests,
+		QueueTimeout:          o.QueueTimeout,
+		CompressRequests:      o.CompressRequests,
+		CircuitBreaker:        o.CircuitBreaker,
+	}
+}
@@ -0,0 +1,425 @@
+package llmmodel
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/model"
+)
+
+// PresetModel implements the model.LLM interface for providers that only
+// differ from the OpenAI API in their endpoint, model names and a few request
+// parameters (Groq, Mistral, Together, ...)
+type PresetModel struct {
+	provider      string
+	client        *openai_compatible.Client
+	contextWindow int
+}
+
+// PresetConfig holds configuration for preset providers
+type PresetConfig struct {
+	APIKey    string
+	BaseURL   string // Optional, defaults to the provider endpoint
+	ModelName string // Optional, defaults to the provider's general purpose model
+
+	ClientOptions
+
+	// Thinking turns reasoning on or off for hybrid thinking models (Qwen3,
+	// GLM-4.5, ...). Nil keeps the provider default.
+	Thinking *bool
+}
+
+// preset describes an OpenAI-compatible provider
+type preset struct {
+	name           string
+	baseURL        string
+	defaultModel   string
+	validateModel  func(name string) error
+	transform      func(body map[string]any)
+	chatPath       string                             // Defaults to /v1/chat/completions
+	finishReasons  map[string]string                  // Non-standard finish reasons, see openai_compatible.ClientConfig
+	thinking       func(body map[string]any, on bool) // Sets the provider's thinking parameter
+	contextWindows map[string]int                     // Context length in tokens by model name prefix
+	inputTypes     []string                           // Inline data types accepted, defaults to imageInputTypes
+	streamUsage    bool                               // Accepts stream_options.include_usage
+}
+
+// Inline data types accepted by providers, see openai_compatible.ClientConfig.InputMIMETypes
+var (
+	imageInputTypes  = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+	openAIInputTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "audio/wav", "audio/mpeg"}
+)
+
+// inputTypes returns the configured inline data types, or the provider's
+func inputTypes(configured, provider []string) []string {
+	if configured != nil {
+		return configured
+	}
+	return provider
+}
+
+// streamUsage returns whether streams ask for token usage, as configured or
+// by the provider's default
+func streamUsage(configured *bool, provider bool) bool {
+	if configured != nil {
+		return *configured
+	}
+	return provider
+}
+
+var (
+	groqPreset = preset{
+		name:          "groq",
+		baseURL:       "https://api.groq.com/openai",
+		defaultModel:  "llama-3.3-70b-versatile",
+		validateModel: validateModelID,
+		// Groq rejects these OpenAI parameters and only supports n=1
+		transform:   dropParams("logprobs", "top_logprobs", "logit_bias", "n"),
+		streamUsage: true,
+	}
+
+	mistralPreset = preset{
+		name:         "mistral",
+		baseURL:      "https://api.mistral.ai",
+		defaultModel: "mistral-large-latest",
+		validateModel: func(name string) error {
+			if strings.Contains(name, "/") {
+				return fmt.Errorf("invalid Mistral model %q (expected a plain model id, e.g. mistral-large-latest)", name)
+			}
+			return validateModelID(name)
+		},
+		transform: mistralTransform,
+	}
+
+	togetherPreset = preset{
+		name:         "together",
+		baseURL:      "https://api.together.xyz",
+		defaultModel: "meta-llama/Llama-3.3-70B-Instruct-Turbo",
+		validateModel: func(name string) error {
+			if !strings.Contains(name, "/") {
+				return fmt.Errorf("invalid Together model %q (expected org/model, e.g. meta-llama/Llama-3.3-70B-Instruct-Turbo)", name)
+			}
+			return validateModelID(name)
+		},
+	}
+
+	xaiPreset = preset{
+		name:          "xai",
+		baseURL:       "https://api.x.ai",
+		defaultModel:  "grok-4",
+		validateModel: validateModelID,
+		transform:     xaiTransform,
+		streamUsage:   true,
+		contextWindows: map[string]int{
+			"grok-4":           256000,
+			"grok-4-fast":      2000000,
+			"grok-code-fast":   256000,
+			"grok-3":           131072,
+			"grok-2":           131072,
+			"grok-2-vision":    32768,
+			"grok-2-image":     131072,
+			"grok-3-mini":      131072,
+			"grok-3-mini-fast": 131072,
+		},
+	}
+
+	// Moonshot serves Kimi; international accounts use https://api.moonshot.ai
+	moonshotPreset = preset{
+		name:         "moonshot",
+		baseURL:      "https://api.moonshot.cn",
+		defaultModel: "kimi-k2-0905-preview",
+		validateModel: func(name string) error {
+			if strings.Contains(name, "/") {
+				return fmt.Errorf("invalid Moonshot model %q (expected a plain model id, e.g. kimi-k2-0905-preview)", name)
+			}
+			return validateModelID(name)
+		},
+		// Moonshot rejects temperatures above 1
+		transform: clampUnit("temperature"),
+		contextWindows: map[string]int{
+			"kimi-k2":          131072,
+			"kimi-k2-0905":     262144,
+			"kimi-k2-turbo":    262144,
+			"kimi-latest":      131072,
+			"kimi-thinking":    131072,
+			"moonshot-v1-8k":   8192,
+			"moonshot-v1-32k":  32768,
+			"moonshot-v1-128k": 131072,
+			"moonshot-v1-auto": 131072,
+		},
+	}
+
+	// DashScope (Alibaba Cloud Model Studio) serves Qwen in its compatible mode.
+	// International accounts use https://dashscope-intl.aliyuncs.com/compatible-mode.
+	dashScopePreset = preset{
+		name:         "dashscope",
+		baseURL:      "https://dashscope.aliyuncs.com/compatible-mode",
+		defaultModel: "qwen-plus",
+		validateModel: func(name string) error {
+			if strings.Contains(name, "/") {
+				return fmt.Errorf("invalid DashScope model %q (expected a plain model id, e.g. qwen-plus)", name)
+			}
+			return validateModelID(name)
+		},
+		transform:   dashScopeTransform,
+		streamUsage: true,
+		// Some models send the string "null" while generating
+		finishReasons: map[string]string{"null": ""},
+		thinking: func(body map[string]any, on bool) {
+			body["enable_thinking"] = on
+		},
+	}
+
+	// Zhipu BigModel serves GLM under /api/paas/v4 rather than /v1.
+	// International accounts use https://api.z.ai/api/paas/v4.
+	zhipuPreset = preset{
+		name:         "zhipu",
+		baseURL:      "https://open.bigmodel.cn/api/paas/v4",
+		defaultModel: "glm-4.5",
+		validateModel: func(name string) error {
+			if strings.Contains(name, "/") {
+				return fmt.Errorf("invalid Zhipu model %q (expected a plain model id, e.g. glm-4.5)", name)
+			}
+			return validateModelID(name)
+		},
+		transform: func(body map[string]any) {
+			clampUnit("temperature", "top_p")(body)
+			// Only a single stop sequence is accepted
+			if stop, ok := body["stop"].([]string); ok && len(stop) > 1 {
+				body["stop"] = stop[:1]
+			}
+		},
+		chatPath: "/chat/completions",
+		// Zhipu reports moderation blocks as "sensitive"
+		finishReasons: map[string]string{"sensitive": "content_filter"},
+		thinking: func(body map[string]any, on bool) {
+			mode := "disabled"
+			if on {
+				mode = "enabled"
+			}
+			body["thinking"] = map[string]any{"type": mode}
+		},
+	}
+)
+
+// presets are the preset providers by config name
+var presets = map[string]preset{}
+
+func init() {
+	for _, p := range []preset{groqPreset, mistralPreset, togetherPreset, xaiPreset, moonshotPreset, dashScopePreset, zhipuPreset} {
+		presets[p.name] = p
+	}
+}
+
+// PresetNames returns the names of the preset providers, sorted
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewPresetModel creates a model served by the named preset provider, e.g. "groq"
+func NewPresetModel(ctx context.Context, provider string, cfg *PresetConfig) (model.LLM, error) {
+	p, ok := presets[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset provider %q (available: %s)", provider, strings.Join(PresetNames(), ", "))
+	}
+	return newPresetModel(ctx, p, cfg)
+}
+
+// NewGroqModel creates a model served by Groq
+func NewGroqModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, groqPreset, cfg)
+}
+
+// NewMistralModel creates a model served by Mistral AI (La Plateforme)
+func NewMistralModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, mistralPreset, cfg)
+}
+
+// NewTogetherModel creates a model served by Together AI
+func NewTogetherModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, togetherPreset, cfg)
+}
+
+// NewXAIModel creates a Grok model served by xAI
+func NewXAIModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, xaiPreset, cfg)
+}
+
+// NewMoonshotModel creates a Kimi model served by Moonshot AI
+func NewMoonshotModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, moonshotPreset, cfg)
+}
+
+// NewDashScopeModel creates a Qwen model served by Alibaba Cloud DashScope
+func NewDashScopeModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, dashScopePreset, cfg)
+}
+
+// NewZhipuModel creates a GLM model served by Zhipu BigModel
+func NewZhipuModel(ctx context.Context, cfg *PresetConfig) (model.LLM, error) {
+	return newPresetModel(ctx, zhipuPreset, cfg)
+}
+
+// newPresetModel creates a model for preset p
+func newPresetModel(ctx context.Context, p preset, cfg *PresetConfig) (model.LLM, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("API key is required")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = p.baseURL
+	}
+
+	modelName := cfg.ModelName
+	if modelName == "" {
+		modelName = p.defaultModel
+	}
+	if p.validateModel != nil {
+		if err := p.validateModel(modelName); err != nil {
+			return nil, err
+		}
+	}
+
+	transform := p.transform
+	if cfg.Thinking != nil {
+		if p.thinking == nil {
+			return nil, fmt.Errorf("%s does not support toggling thinking", p.name)
+		}
+		on := *cfg.Thinking
+		transform = func(body map[string]any) {
+			p.thinking(body, on)
+			if p.transform != nil {
+				p.transform(body)
+			}
+		}
+	}
+
+	contextWindow := cfg.ContextWindow
+	if contextWindow == 0 {
+		contextWindow = lookupPrefix(p.contextWindows, modelName)
+	}
+	providerTypes := p.inputTypes
+	if providerTypes == nil {
+		providerTypes = imageInputTypes
+	}
+
+	clientCfg := cfg.clientConfig(cfg.APIKey, baseURL, modelName, p.streamUsage, providerTypes)
+	clientCfg.ContextWindow = contextWindow
+	clientCfg.RequestTransform = transform
+	clientCfg.ChatPath = p.chatPath
+	clientCfg.FinishReasons = p.finishReasons
+	client, err := openai_compatible.NewClient(clientCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s client: %w", p.name, err)
+	}
+
+	return &PresetModel{
+		provider:      p.name,
+		client:        client,
+		contextWindow: contextWindow,
+	}, nil
+}
+
+// Name returns the model name
+func (m *PresetModel) Name() string {
+	return m.client.ModelName()
+}
+
+// Provider returns the preset provider name, e.g. "groq"
+func (m *PresetModel) Provider() string {
+	return m.provider
+}
+
+// ContextWindow returns the model's context length in tokens, or 0 if unknown
+func (m *PresetModel) ContextWindow() int {
+	return m.contextWindow
+}
+
+// GenerateContent implements the model.LLM interface
+func (m *PresetModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return m.client.GenerateContent(ctx, req, stream)
+}
+
+// validateModelID rejects model names that cannot be valid on any provider
+func validateModelID(name string) error {
+	if strings.TrimSpace(name) != name || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("invalid model name %q (must not contain whitespace)", name)
+	}
+	return nil
+}
+
+// dropParams returns a transform that removes unsupported request parameters
+func dropParams(names ...string) func(map[string]any) {
+	return func(body map[string]any) {
+		for _, name := range names {
+			delete(body, name)
+		}
+	}
+}
+
+// mistralTransform adapts an OpenAI request to Mistral: stream_options is
+// rejected as an unknown field and the seed parameter is called random_seed
+func mistralTransform(body map[string]any) {
+	delete(body, "stream_options")
+	if seed, ok := body["seed"]; ok {
+		body["random_seed"] = seed
+		delete(body, "seed")
+	}
+}
+
+// dashScopeTransform adapts an OpenAI request to DashScope: Qwen3 models
+// reject enable_thinking=true (their open-source default) without streaming
+func dashScopeTransform(body map[string]any) {
+	if stream, _ := body["stream"].(bool); !stream {
+		body["enable_thinking"] = false
+	}
+}
+
+// xaiTransform adapts an OpenAI request to xAI: reasoning models (grok-4,
+// grok-3-mini) reject the penalty parameters and stop sequences
+func xaiTransform(body map[string]any) {
+	name, _ := body["model"].(string)
+	if strings.HasPrefix(name, "grok-4") || strings.HasPrefix(name, "grok-3-mini") {
+		dropParams("presence_penalty", "frequency_penalty", "stop")(body)
+	}
+}
+
+// clampUnit returns a transform that clamps float parameters to [0, 1]
+func clampUnit(names ...string) func(map[string]any) {
+	return func(body map[string]any) {
+		for _, name := range names {
+			if v, ok := body[name].(float32); ok {
+				body[name] = min(max(v, 0), 1)
+			}
+		}
+	}
+}
+
+// lookupPrefix returns the value of the longest key that prefixes name, or 0
+func lookupPrefix(values map[string]int, name string) int {
+	value, best := 0, -1
+	for prefix, v := range values {
+		if strings.HasPrefix(name, prefix) && len(prefix) > best {
+			value, best = v, len(prefix)
+		}
+	}
+	return value
+}
+
+// ListModels returns the models the provider serves
+func (m *PresetModel) ListModels(ctx context.Context) ([]string, error) {
+	return m.client.ListModels(ctx)
+}
@@ -0,0 +1,231 @@
+package llmmodel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// TestPresetModelValidation tests preset defaults and model name validation
+func TestPresetModelValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  func(context.Context, *PresetConfig) (model.LLM, error)
+		model   string
+		want    string
+		wantErr bool
+	}{
+		{"groq default", NewGroqModel, "", "llama-3.3-70b-versatile", false},
+		{"groq namespaced", NewGroqModel, "openai/gpt-oss-120b", "openai/gpt-oss-120b", false},
+		{"mistral default", NewMistralModel, "", "mistral-large-latest", false},
+		{"mistral namespaced", NewMistralModel, "mistralai/mistral-large", "", true},
+		{"together default", NewTogetherModel, "", "meta-llama/Llama-3.3-70B-Instruct-Turbo", false},
+		{"together plain", NewTogetherModel, "llama-3", "", true},
+		{"dashscope default", NewDashScopeModel, "", "qwen-plus", false},
+		{"zhipu default", NewZhipuModel, "", "glm-4.5", false},
+		{"zhipu namespaced", NewZhipuModel, "zai-org/glm-4.5", "", true},
+		{"xai default", NewXAIModel, "", "grok-4", false},
+		{"moonshot default", NewMoonshotModel, "", "kimi-k2-0905-preview", false},
+		{"moonshot namespaced", NewMoonshotModel, "moonshotai/kimi-k2", "", true},
+		{"whitespace", NewGroqModel, "llama 3", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.create(context.Background(), &PresetConfig{APIKey: "test", ModelName: tt.model})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && m.Name() != tt.want {
+				t.Errorf("Name() = %q, want %q", m.Name(), tt.want)
+			}
+		})
+	}
+}
+
+// TestMistralTransform tests that OpenAI-only fields are adapted for Mistral
+func TestMistralTransform(t *testing.T) {
+	body := map[string]any{"seed": 7, "stream_options": map[string]any{"include_usage": true}}
+	mistralTransform(body)
+	if _, ok := body["stream_options"]; ok {
+		t.Error("stream_options should be removed")
+	}
+	if body["random_seed"] != 7 || body["seed"] != nil {
+		t.Errorf("seed should be renamed to random_seed, got %v", body)
+	}
+}
+
+// TestZhipuRequest tests the Zhipu endpoint path, thinking parameter, parameter ranges and finish reasons
+func TestZhipuRequest(t *testing.T) {
+	var path string
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":""},"finish_reason":"sensitive"}]}`)
+	}))
+	defer srv.Close()
+
+	thinking := false
+	m, err := NewZhipuModel(context.Background(), &PresetConfig{APIKey: "test", BaseURL: srv.URL, Thinking: &thinking})
+	if err != nil {
+		t.Fatalf("NewZhipuModel() error = %v", err)
+	}
+
+	temperature := float32(1.5)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)},
+		Config:   &genai.GenerateContentConfig{Temperature: &temperature, StopSequences: []string{"A", "B"}},
+	}
+	var refused *openai_compatible.ResponseRefused
+	for _, err := range m.GenerateContent(context.Background(), req, false) {
+		if err != nil && !errors.As(err, &refused) {
+			t.Fatalf("GenerateContent() error = %v", err)
+		}
+	}
+
+	if path != "/chat/completions" {
+		t.Errorf("path = %q, want /chat/completions", path)
+	}
+	if got := fmt.Sprint(body["thinking"]); got != "map[type:disabled]" {
+		t.Errorf("thinking = %s, want map[type:disabled]", got)
+	}
+	if body["temperature"] != 1.0 {
+		t.Errorf("temperature = %v, want 1", body["temperature"])
+	}
+	if got := fmt.Sprint(body["stop"]); got != "[A]" {
+		t.Errorf("stop = %s, want [A]", got)
+	}
+	if refused == nil || refused.FinishReason != "content_filter" {
+		t.Errorf("refused = %v, want content_filter refusal", refused)
+	}
+}
+
+// TestPresetThinking tests how the thinking toggle is applied per provider
+func TestPresetThinking(t *testing.T) {
+	on := true
+	if _, err := NewGroqModel(context.Background(), &PresetConfig{APIKey: "test", Thinking: &on}); err == nil {
+		t.Error("groq should reject the thinking toggle")
+	}
+
+	body := map[string]any{"stream": true}
+	dashScopePreset.thinking(body, true)
+	dashScopeTransform(body)
+	if body["enable_thinking"] != true {
+		t.Errorf("streaming enable_thinking = %v, want true", body["enable_thinking"])
+	}
+
+	body = map[string]any{"stream": false}
+	dashScopePreset.thinking(body, true)
+	dashScopeTransform(body)
+	if body["enable_thinking"] != false {
+		t.Errorf("non-streaming enable_thinking = %v, want false", body["enable_thinking"])
+	}
+}
+
+// TestNewPresetModel tests preset selection by name and context window lookup
+func TestNewPresetModel(t *testing.T) {
+	tests := []struct {
+		provider    string
+		model       string
+		wantContext int
+		wantErr     bool
+	}{
+		{"xai", "", 256000, false},
+		{"xai", "grok-4-fast-reasoning", 2000000, false},
+		{"moonshot", "moonshot-v1-32k", 32768, false},
+		{"groq", "", 0, false},
+		{"anthropic", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.model, func(t *testing.T) {
+			m, err := NewPresetModel(context.Background(), tt.provider, &PresetConfig{APIKey: "test", ModelName: tt.model})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := m.(*PresetModel).ContextWindow(); got != tt.wantContext {
+				t.Errorf("ContextWindow() = %d, want %d", got, tt.wantContext)
+			}
+		})
+	}
+}
+
+// TestXAITransform tests that reasoning models drop unsupported parameters
+func TestXAITransform(t *testing.T) {
+	body := map[string]any{"model": "grok-4", "stop": []string{"END"}, "presence_penalty": 0.5}
+	xaiTransform(body)
+	if _, ok := body["stop"]; ok {
+		t.Error("stop should be removed for grok-4")
+	}
+
+	body = map[string]any{"model": "grok-3", "stop": []string{"END"}}
+	xaiTransform(body)
+	if _, ok := body["stop"]; !ok {
+		t.Error("stop should be kept for grok-3")
+	}
+}
+
+// TestStreamUsage tests which providers ask for usage at the end of streams
+func TestStreamUsage(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	on, off := true, false
+	tests := []struct {
+		name   string
+		create func() (model.LLM, error)
+		want   bool
+	}{
+		{"groq", func() (model.LLM, error) {
+			return NewGroqModel(context.Background(), &PresetConfig{APIKey: "test", BaseURL: srv.URL})
+		}, true},
+		{"groq disabled", func() (model.LLM, error) {
+			return NewGroqModel(context.Background(), &PresetConfig{APIKey: "test", BaseURL: srv.URL, ClientOptions: ClientOptions{StreamUsage: &off}})
+		}, false},
+		{"together", func() (model.LLM, error) {
+			return NewTogetherModel(context.Background(), &PresetConfig{APIKey: "test", BaseURL: srv.URL})
+		}, false},
+		{"together enabled", func() (model.LLM, error) {
+			return NewTogetherModel(context.Background(), &PresetConfig{APIKey: "test", BaseURL: srv.URL, ClientOptions: ClientOptions{StreamUsage: &on}})
+		}, true},
+		{"openai compatible server", func() (model.LLM, error) {
+			return NewOpenAIModel(context.Background(), &OpenAIConfig{APIKey: "test", BaseURL: srv.URL, ModelName: "local"})
+		}, false},
+		{"deepseek", func() (model.LLM, error) {
+			return NewModel(context.Background(), &Config{APIKey: "test", BaseURL: srv.URL})
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+			for _, err := range m.GenerateContent(context.Background(), req, true) {
+				if err != nil {
+					t.Fatalf("GenerateContent() error = %v", err)
+				}
+			}
+			if _, got := body["stream_options"]; got != tt.want {
+				t.Errorf("stream_options sent = %v, want %v (body %v)", got, tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package llmmodel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// maxStructRetries is the number of times GenerateStruct asks the model to
+// correct a response that does not decode
+const maxStructRetries = 2
+
+// repairPrompt asks the model to correct an invalid JSON response
+const repairPrompt = "Your response is not valid: %v. Reply with only the corrected JSON, matching the schema."
+
+// GenerateStruct asks llm for a T answering prompt. The JSON schema of T,
+// derived with SchemaOf, is sent as the response schema, which OpenAI
+// compatible providers enforce as strict structured output. The JSON of
+// the response is extracted and repaired (see ExtractJSON and RepairJSON);
+// when it is still invalid or violates the schema, it is sent back to the
+// model with the errors, up to maxStructRetries times, before an
+// *InvalidJSONError is returned. Providers enforcing structured output
+// need T to be a struct.
+func GenerateStruct[T any](ctx context.Context, llm model.LLM, prompt string) (T, error) {
+	var zero T
+	schema, err := SchemaOf(reflect.TypeFor[T]())
+	if err != nil {
+		return zero, fmt.Errorf("failed to derive response schema: %w", err)
+	}
+	if name := reflect.TypeFor[T]().Name(); name != "" {
+		schema["title"] = name
+	}
+
+	req := &model.LLMRequest{
+		Model:    llm.Name(),
+		Contents: []*genai.Content{genai.NewContentFromText(prompt, genai.RoleUser)},
+		Config: &genai.GenerateContentConfig{
+			ResponseMIMEType:   "application/json",
+			ResponseJsonSchema: schema,
+		},
+	}
+	for attempt := 1; ; attempt++ {
+		text, err := generateText(ctx, llm, req)
+		if err != nil {
+			return zero, err
+		}
+		var result T
+		err = decodeStruct(text, schema, &result)
+		if err == nil {
+			return result, nil
+		}
+		if attempt > maxStructRetries {
+			return zero, &InvalidJSONError{Attempts: attempt, Text: text, Err: err}
+		}
+		req.Contents = append(req.Contents,
+			genai.NewContentFromText(text, genai.RoleModel),
+			genai.NewContentFromText(fmt.Sprintf(repairPrompt, err), genai.RoleUser))
+	}
+}
+
+// generateText returns the text of the final response of llm to req
+func generateText(ctx context.Context, llm model.LLM, req *model.LLMRequest) (string, error) {
+	var text strings.Builder
+	for resp, err := range llm.GenerateContent(ctx, req, false) {
+		if err != nil {
+			return "", err
+		}
+		if resp == nil || resp.Partial {
+			continue
+		}
+		if resp.ErrorCode != "" {
+			return "", fmt.Errorf("model error %s: %s", resp.ErrorCode, resp.ErrorMessage)
+		}
+		if resp.Content != nil {
+			for _, part := range resp.Content.Parts {
+				if part != nil && !part.Thought {
+					text.WriteString(part.Text)
+				}
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// decodeStruct decodes the JSON of text, once extracted, repaired and
+// validated against schema, into v
+func decodeStruct(text string, schema map[string]any, v any) error {
+	text, err := parseJSON(text, schema)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return nil
+}
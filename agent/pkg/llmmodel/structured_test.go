@@ -0,0 +1,127 @@
+package llmmodel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+type address struct {
+	City    string `json:"city"`
+	Country string `json:"country,omitempty"`
+}
+
+type base struct {
+	ID int64 `json:"id"`
+}
+
+type person struct {
+	base
+	Name      string             `json:"name" jsonschema:"Full name"`
+	Email     *string            `json:"email,omitempty"`
+	Tags      []string           `json:"tags"`
+	Addresses []address          `json:"addresses"`
+	Scores    map[string]float64 `json:"scores,omitempty"`
+	Born      time.Time          `json:"born"`
+	Photo     []byte             `json:"photo,omitempty"`
+	Extra     any                `json:"extra,omitempty"`
+	Secret    string             `json:"-"`
+	internal  bool
+}
+
+// TestSchemaOf tests the schema derived from a struct's fields and tags
+func TestSchemaOf(t *testing.T) {
+	schema, err := SchemaOf(reflect.TypeFor[person]())
+	if err != nil {
+		t.Fatalf("SchemaOf() error = %v", err)
+	}
+	got, _ := json.Marshal(schema)
+	want := `{"additionalProperties":false,"properties":{` +
+		`"addresses":{"items":{"additionalProperties":false,"properties":{"city":{"type":"string"},"country":{"type":"string"}},"required":["city"],"type":"object"},"type":"array"},` +
+		`"born":{"format":"date-time","type":"string"},` +
+		`"email":{"type":"string"},` +
+		`"extra":{},` +
+		`"id":{"type":"integer"},` +
+		`"name":{"description":"Full name","type":"string"},` +
+		`"photo":{"contentEncoding":"base64","type":"string"},` +
+		`"scores":{"additionalProperties":{"type":"number"},"type":"object"},` +
+		`"tags":{"items":{"type":"string"},"type":"array"}},` +
+		`"required":["id","name","tags","addresses","born"],"type":"object"}`
+	if string(got) != want {
+		t.Errorf("SchemaOf() =\n%s\nwant\n%s", got, want)
+	}
+
+	type node struct {
+		Children []node `json:"children"`
+	}
+	if _, err := SchemaOf(reflect.TypeFor[node]()); err == nil {
+		t.Error("SchemaOf(recursive) error = nil")
+	}
+	if _, err := SchemaOf(reflect.TypeFor[struct{ C chan int }]()); err == nil {
+		t.Error("SchemaOf(chan) error = nil")
+	}
+}
+
+// scriptedModel answers each request with the next of its replies,
+// recording the requests
+type scriptedModel struct {
+	replies  []string
+	requests []*model.LLMRequest
+}
+
+func (m *scriptedModel) Name() string { return "scripted" }
+
+func (m *scriptedModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.requests = append(m.requests, req)
+		reply := m.replies[min(len(m.requests), len(m.replies))-1]
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(reply, genai.RoleModel)}, nil)
+	}
+}
+
+// TestGenerateStruct tests decoding a repaired structured response, and
+// sending invalid ones back to the model
+func TestGenerateStruct(t *testing.T) {
+	llm := &scriptedModel{replies: []string{
+		`I don't know.`,
+		`{"country": "FR"}`,
+		"Here it is:\n```json\n{'city': 'Paris', 'country': 'FR',}\n```",
+	}}
+	got, err := GenerateStruct[address](context.Background(), llm, "Where is the Louvre?")
+	if err != nil {
+		t.Fatalf("GenerateStruct() error = %v", err)
+	}
+	if got != (address{City: "Paris", Country: "FR"}) {
+		t.Errorf("GenerateStruct() = %+v", got)
+	}
+	if len(llm.requests) != 3 {
+		t.Fatalf("requests = %d, want 3", len(llm.requests))
+	}
+	config := llm.requests[0].Config
+	schema, _ := config.ResponseJsonSchema.(map[string]any)
+	if config.ResponseMIMEType != "application/json" || schema["title"] != "address" || schema["type"] != "object" {
+		t.Errorf("request config = %+v", config)
+	}
+	contents := llm.requests[2].Contents
+	if len(contents) != 5 || contents[3].Parts[0].Text != `{"country": "FR"}` ||
+		!strings.Contains(contents[4].Parts[0].Text, `$: missing required property "city"`) {
+		t.Errorf("repair contents = %v", contents)
+	}
+
+	llm = &scriptedModel{replies: []string{`{"city": "Paris", "zip": "75001"}`}}
+	_, err = GenerateStruct[address](context.Background(), llm, "Where?")
+	var invalid *InvalidJSONError
+	var validation *ValidationError
+	if !errors.As(err, &invalid) || invalid.Attempts != 3 || !errors.As(err, &validation) ||
+		validation.Errors[0] != (SchemaError{Path: "$", Message: `unexpected property "zip"`}) {
+		t.Errorf("GenerateStruct(unknown field) error = %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package triton
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// templates render a conversation as the prompt the served model was trained on
+var templates = map[string]func(system string, turns []turn) string{
+	"chatml": chatML,
+	"llama3": llama3,
+	"plain":  plain,
+}
+
+// turn is a rendered message of the conversation
+type turn struct {
+	role string // system, user or assistant
+	text string
+}
+
+// renderTurns renders contents as plain text turns. Tool calls and results are
+// rendered inline, since the served model receives a bare prompt.
+func renderTurns(contents []*genai.Content) []turn {
+	var turns []turn
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+		role := "assistant"
+		if content.Role == genai.RoleUser {
+			role = "user"
+		}
+		var parts []string
+		for _, part := range content.Parts {
+			switch {
+			case part == nil || part.Thought:
+			case part.Text != "":
+				parts = append(parts, part.Text)
+			case part.FunctionCall != nil:
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				parts = append(parts, fmt.Sprintf("[called %s(%s)]", part.FunctionCall.Name, args))
+			case part.FunctionResponse != nil:
+				result, _ := json.Marshal(part.FunctionResponse.Response)
+				parts = append(parts, fmt.Sprintf("[%s returned %s]", part.FunctionResponse.Name, result))
+			}
+		}
+		if len(parts) > 0 {
+			turns = append(turns, turn{role: role, text: strings.Join(parts, "\n")})
+		}
+	}
+	return turns
+}
+
+// chatML renders the ChatML format used by Qwen and many fine-tunes
+func chatML(system string, turns []turn) string {
+	var b strings.Builder
+	if system != "" {
+		fmt.Fprintf(&b, "<|im_start|>system\n%s<|im_end|>\n", system)
+	}
+	for _, t := range turns {
+		fmt.Fprintf(&b, "<|im_start|>%s\n%s<|im_end|>\n", t.role, t.text)
+	}
+	b.WriteString("<|im_start|>assistant\n")
+	return b.String()
+}
+
+// llama3 renders the Llama 3 instruct format
+func llama3(system string, turns []turn) string {
+	var b strings.Builder
+	b.WriteString("<|begin_of_text|>")
+	if system != "" {
+		fmt.Fprintf(&b, "<|start_header_id|>system<|end_header_id|>\n\n%s<|eot_id|>", system)
+	}
+	for _, t := range turns {
+		fmt.Fprintf(&b, "<|start_header_id|>%s<|end_header_id|>\n\n%s<|eot_id|>", t.role, t.text)
+	}
+	b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	return b.String()
+}
+
+// plain renders "Role: text" lines, for base models and servers that apply a template themselves
+func plain(system string, turns []turn) string {
+	var b strings.Builder
+	if system != "" {
+		b.WriteString(system)
+		b.WriteString("\n\n")
+	}
+	for _, t := range turns {
+		fmt.Fprintf(&b, "%s: %s\n\n", strings.ToUpper(t.role[:1])+t.role[1:], t.text)
+	}
+	b.WriteString("Assistant:")
+	return b.String()
+}
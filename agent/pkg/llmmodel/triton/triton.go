@@ -0,0 +1,300 @@
+// Package triton implements model.LLM over the gRPC inference protocol of
+// NVIDIA Triton Inference Server, for LLMs served with the vLLM or
+// TensorRT-LLM backends rather than behind an OpenAI-compatible API.
+package triton
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// streamInferMethod is the bidirectional streaming method, which also serves
+// decoupled models (the vLLM backend) that the unary ModelInfer cannot
+const streamInferMethod = "/inference.GRPCInferenceService/ModelStreamInfer"
+
+// Config holds configuration for a Triton-served model
+type Config struct {
+	Address      string        // host:port of the gRPC endpoint, e.g. localhost:8001
+	ModelName    string        // Triton model to call, e.g. "vllm_model" or "ensemble"
+	ModelVersion string        // Optional, empty uses the server's version policy
+	Timeout      time.Duration // Optional, per request, defaults to 5 minutes
+
+	// Backend selects the model's tensor interface: "vllm" (default) or
+	// "tensorrtllm" (the TensorRT-LLM ensemble)
+	Backend string
+
+	// Template formats the conversation as a prompt: "chatml" (default), "llama3" or "plain"
+	Template string
+
+	TLS     bool              // Optional, dial with TLS using the system roots
+	Headers map[string]string // Optional, sent as gRPC metadata, e.g. for a gateway key
+	Logger  *slog.Logger
+}
+
+// Model implements the model.LLM interface for Triton Inference Server
+type Model struct {
+	conn     *grpc.ClientConn
+	cfg      Config
+	template func(system string, turns []turn) string
+	logger   *slog.Logger
+}
+
+// NewModel creates a model served by Triton at cfg.Address. The connection is
+// established lazily on the first request.
+func NewModel(ctx context.Context, cfg *Config) (*Model, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("address is required")
+	}
+	if cfg.ModelName == "" {
+		return nil, fmt.Errorf("model name is required")
+	}
+
+	c := *cfg
+	c.Timeout = cmp.Or(c.Timeout, 5*time.Minute)
+	c.Backend = cmp.Or(c.Backend, "vllm")
+	c.Template = cmp.Or(c.Template, "chatml")
+	if c.Backend != "vllm" && c.Backend != "tensorrtllm" {
+		return nil, fmt.Errorf("unknown backend %q (must be vllm or tensorrtllm)", c.Backend)
+	}
+	template, ok := templates[c.Template]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q (must be %s)", c.Template,
+			strings.Join(slices.Sorted(maps.Keys(templates)), ", "))
+	}
+
+	creds := insecure.NewCredentials()
+	if c.TLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(c.Address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("llm")
+	}
+
+	return &Model{
+		conn:     conn,
+		cfg:      c,
+		template: template,
+		logger:   logger,
+	}, nil
+}
+
+// Name returns the model name
+func (m *Model) Name() string {
+	return m.cfg.ModelName
+}
+
+// Close closes the gRPC connection
+func (m *Model) Close() error {
+	return m.conn.Close()
+}
+
+// GenerateContent implements the model.LLM interface
+func (m *Model) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+		defer cancel()
+		if len(m.cfg.Headers) > 0 {
+			pairs := make([]string, 0, 2*len(m.cfg.Headers))
+			for k, v := range m.cfg.Headers {
+				pairs = append(pairs, strings.ToLower(k), v)
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
+		}
+
+		if req.Config != nil && len(req.Config.Tools) > 0 {
+			m.logger.Debug("Triton models receive a bare prompt, tool declarations are not sent", "tools", len(req.Config.Tools))
+		}
+
+		payload := m.buildRequest(req, stream).marshal()
+		s, err := m.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, streamInferMethod)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to open inference stream: %w", err))
+			return
+		}
+		if err := s.SendMsg(&payload); err != nil {
+			yield(nil, fmt.Errorf("failed to send inference request: %w", err))
+			return
+		}
+		if err := s.CloseSend(); err != nil {
+			yield(nil, fmt.Errorf("failed to send inference request: %w", err))
+			return
+		}
+
+		startTime := time.Now()
+		var text strings.Builder
+		for {
+			var data []byte
+			err := s.RecvMsg(&data)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				yield(nil, fmt.Errorf("inference stream failed: %w", err))
+				return
+			}
+			resp, err := unmarshalStreamResponse(data)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if resp.errorMessage != "" {
+				yield(nil, fmt.Errorf("triton error: %s", resp.errorMessage))
+				return
+			}
+
+			if delta := string(resp.outputs["text_output"]); delta != "" {
+				text.WriteString(delta)
+				if stream {
+					if !yield(&model.LLMResponse{Content: genai.NewContentFromText(delta, genai.RoleModel), Partial: true}, nil) {
+						return
+					}
+				}
+			}
+			if resp.final {
+				break
+			}
+		}
+
+		m.logger.Info("Triton generation completed",
+			"model", m.cfg.ModelName,
+			"duration", time.Since(startTime),
+			"response_length", text.Len(),
+		)
+		yield(&model.LLMResponse{
+			Content:      genai.NewContentFromText(text.String(), genai.RoleModel),
+			FinishReason: genai.FinishReasonStop,
+			TurnComplete: true,
+		}, nil)
+	}
+}
+
+// buildRequest renders req as the inputs of the configured backend
+func (m *Model) buildRequest(req *model.LLMRequest, stream bool) *inferRequest {
+	var system string
+	config := &genai.GenerateContentConfig{}
+	if req.Config != nil {
+		config = req.Config
+		if config.SystemInstruction != nil {
+			var parts []string
+			for _, part := range config.SystemInstruction.Parts {
+				if part != nil && part.Text != "" {
+					parts = append(parts, part.Text)
+				}
+			}
+			system = strings.Join(parts, "\n\n")
+		}
+	}
+	prompt := m.template(system, renderTurns(req.Contents))
+
+	r := &inferRequest{
+		modelName:    m.cfg.ModelName,
+		modelVersion: m.cfg.ModelVersion,
+		boolParams:   map[string]bool{"triton_enable_empty_final_response": true},
+		outputs:      []string{"text_output"},
+	}
+
+	if m.cfg.Backend == "vllm" {
+		params := map[string]any{}
+		if config.MaxOutputTokens > 0 {
+			params["max_tokens"] = config.MaxOutputTokens
+		}
+		if config.Temperature != nil {
+			params["temperature"] = *config.Temperature
+		}
+		if config.TopP != nil {
+			params["top_p"] = *config.TopP
+		}
+		if config.TopK != nil {
+			params["top_k"] = int(*config.TopK)
+		}
+		if len(config.StopSequences) > 0 {
+			params["stop"] = config.StopSequences
+		}
+		sampling, _ := json.Marshal(params)
+		r.inputs = []tensor{
+			bytesTensor("text_input", []int64{1}, prompt),
+			boolTensor("stream", []int64{1}, stream),
+			bytesTensor("sampling_parameters", []int64{1}, string(sampling)),
+			boolTensor("exclude_input_in_output", []int64{1}, true),
+		}
+		return r
+	}
+
+	// The TensorRT-LLM ensemble takes batched [1, 1] tensors and requires max_tokens
+	one := []int64{1, 1}
+	r.inputs = []tensor{
+		bytesTensor("text_input", one, prompt),
+		int32Tensor("max_tokens", one, cmp.Or(config.MaxOutputTokens, 1024)),
+		boolTensor("stream", one, stream),
+		boolTensor("exclude_input_in_output", one, true),
+	}
+	if config.Temperature != nil {
+		r.inputs = append(r.inputs, fp32Tensor("temperature", one, *config.Temperature))
+	}
+	if config.TopP != nil {
+		r.inputs = append(r.inputs, fp32Tensor("top_p", one, *config.TopP))
+	}
+	if config.TopK != nil {
+		r.inputs = append(r.inputs, int32Tensor("top_k", one, int32(*config.TopK)))
+	}
+	if n := len(config.StopSequences); n > 0 {
+		r.inputs = append(r.inputs, bytesTensor("stop_words", []int64{1, int64(n)}, config.StopSequences...))
+	}
+	return r
+}
+
+// rawCodec passes pre-encoded protobuf messages through gRPC. It keeps the
+// "proto" name so requests carry the content type Triton expects.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,206 @@
+package triton
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// streamResponse encodes a ModelStreamInferResponse with a text_output delta
+func streamResponse(text string, final bool) []byte {
+	var infer []byte
+	if final {
+		var param []byte
+		param = protowire.AppendTag(param, 1, protowire.VarintType)
+		param = protowire.AppendVarint(param, 1)
+		entry := appendMessage(appendString(nil, 1, "triton_final_response"), 2, param)
+		infer = appendMessage(infer, 4, entry)
+	}
+	if text != "" {
+		infer = appendMessage(infer, 5, appendString(appendString(nil, 1, "text_output"), 2, "BYTES"))
+		raw := binary.LittleEndian.AppendUint32(nil, uint32(len(text)))
+		infer = appendMessage(infer, 6, append(raw, text...))
+	}
+	return appendMessage(nil, 2, infer)
+}
+
+// inputs decodes the raw inputs of a ModelInferRequest by name
+func inputs(t *testing.T, b []byte) map[string][]byte {
+	var names []string
+	var raw [][]byte
+	err := walk(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 5:
+			return walk(v, func(num protowire.Number, v []byte) error {
+				if num == 1 {
+					names = append(names, string(v))
+				}
+				return nil
+			})
+		case 7:
+			raw = append(raw, v)
+		}
+		return nil
+	})
+	if err != nil || len(names) != len(raw) {
+		t.Fatalf("failed to decode request: %v (%d inputs, %d contents)", err, len(names), len(raw))
+	}
+	out := map[string][]byte{}
+	for i, name := range names {
+		out[name] = raw[i]
+	}
+	return out
+}
+
+// fakeTriton serves ModelStreamInfer, answering each request with deltas
+func fakeTriton(t *testing.T, deltas []string, requests chan<- map[string][]byte) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			if method != streamInferMethod {
+				t.Errorf("method = %s, want %s", method, streamInferMethod)
+			}
+			var req []byte
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			requests <- inputs(t, req)
+			for _, d := range deltas {
+				msg := streamResponse(d, false)
+				if err := stream.SendMsg(&msg); err != nil {
+					return err
+				}
+			}
+			msg := streamResponse("", true)
+			return stream.SendMsg(&msg)
+		}),
+	)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// TestGenerateContent tests prompt rendering, backend inputs and streaming over gRPC
+func TestGenerateContent(t *testing.T) {
+	temperature := float32(0.2)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("Hi", genai.RoleUser)},
+		Config: &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText("Be brief.", genai.RoleUser),
+			Temperature:       &temperature,
+			MaxOutputTokens:   64,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		backend string
+		stream  bool
+		check   func(t *testing.T, in map[string][]byte)
+	}{
+		{
+			name:    "vllm stream",
+			backend: "vllm",
+			stream:  true,
+			check: func(t *testing.T, in map[string][]byte) {
+				if got := string(in["sampling_parameters"][4:]); got != `{"max_tokens":64,"temperature":0.2}` {
+					t.Errorf("sampling_parameters = %s", got)
+				}
+				if in["stream"][0] != 1 {
+					t.Errorf("stream = %v, want true", in["stream"])
+				}
+			},
+		},
+		{
+			name:    "tensorrtllm",
+			backend: "tensorrtllm",
+			check: func(t *testing.T, in map[string][]byte) {
+				if got := binary.LittleEndian.Uint32(in["max_tokens"]); got != 64 {
+					t.Errorf("max_tokens = %d, want 64", got)
+				}
+				if _, ok := in["temperature"]; !ok {
+					t.Error("temperature input missing")
+				}
+				if in["stream"][0] != 0 {
+					t.Errorf("stream = %v, want false", in["stream"])
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan map[string][]byte, 1)
+			addr := fakeTriton(t, []string{"Hello", " there"}, requests)
+			m, err := NewModel(context.Background(), &Config{Address: addr, ModelName: "llm", Backend: tt.backend})
+			if err != nil {
+				t.Fatalf("NewModel() error = %v", err)
+			}
+			defer m.Close()
+
+			var partials []string
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(context.Background(), req, tt.stream) {
+				if err != nil {
+					t.Fatalf("GenerateContent() error = %v", err)
+				}
+				if resp.Partial {
+					partials = append(partials, resp.Content.Parts[0].Text)
+				} else {
+					final = resp
+				}
+			}
+
+			in := <-requests
+			prompt := string(in["text_input"][4:])
+			want := "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\n"
+			if prompt != want {
+				t.Errorf("prompt = %q, want %q", prompt, want)
+			}
+			tt.check(t, in)
+
+			if tt.stream && strings.Join(partials, "|") != "Hello| there" {
+				t.Errorf("partials = %q", partials)
+			}
+			if !tt.stream && len(partials) != 0 {
+				t.Errorf("got %d partials without streaming", len(partials))
+			}
+			if final == nil || final.Content.Parts[0].Text != "Hello there" || !final.TurnComplete {
+				t.Errorf("final response = %+v, want turn complete with %q", final, "Hello there")
+			}
+		})
+	}
+}
+
+// TestNewModel_Validation tests config validation
+func TestNewModel_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+	}{
+		{"nil config", nil},
+		{"missing address", &Config{ModelName: "llm"}},
+		{"missing model", &Config{Address: "localhost:8001"}},
+		{"unknown backend", &Config{Address: "localhost:8001", ModelName: "llm", Backend: "onnx"}},
+		{"unknown template", &Config{Address: "localhost:8001", ModelName: "llm", Template: "alpaca"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewModel(context.Background(), tt.cfg); err == nil {
+				t.Error("NewModel() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package triton
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages below are the subset of Triton's grpc_service.proto (package
+// inference) the client needs, encoded by hand so no generated code is required.
+
+// tensor is an input tensor of a ModelInferRequest
+type tensor struct {
+	name     string
+	datatype string // BYTES, BOOL, INT32 or FP32
+	shape    []int64
+	raw      []byte // Contents in Triton's raw binary layout
+}
+
+// bytesTensor creates a BYTES tensor, each element prefixed with its length
+func bytesTensor(name string, shape []int64, values ...string) tensor {
+	var raw []byte
+	for _, v := range values {
+		raw = binary.LittleEndian.AppendUint32(raw, uint32(len(v)))
+		raw = append(raw, v...)
+	}
+	return tensor{name: name, datatype: "BYTES", shape: shape, raw: raw}
+}
+
+// boolTensor creates a BOOL tensor holding one value
+func boolTensor(name string, shape []int64, value bool) tensor {
+	var b byte
+	if value {
+		b = 1
+	}
+	return tensor{name: name, datatype: "BOOL", shape: shape, raw: []byte{b}}
+}
+
+// int32Tensor creates an INT32 tensor holding one value
+func int32Tensor(name string, shape []int64, value int32) tensor {
+	return tensor{name: name, datatype: "INT32", shape: shape, raw: binary.LittleEndian.AppendUint32(nil, uint32(value))}
+}
+
+// fp32Tensor creates an FP32 tensor holding one value
+func fp32Tensor(name string, shape []int64, value float32) tensor {
+	return tensor{name: name, datatype: "FP32", shape: shape, raw: binary.LittleEndian.AppendUint32(nil, math.Float32bits(value))}
+}
+
+// inferRequest is a ModelInferRequest
+type inferRequest struct {
+	modelName    string
+	modelVersion string
+	id           string
+	boolParams   map[string]bool
+	inputs       []tensor
+	outputs      []string
+}
+
+// marshal encodes the request. Input contents go to raw_input_contents, in input order.
+func (r *inferRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.modelName)
+	b = appendString(b, 2, r.modelVersion)
+	b = appendString(b, 3, r.id)
+	for key, value := range r.boolParams {
+		// map<string, InferParameter> entry; InferParameter.bool_param is field 1
+		var param []byte
+		param = protowire.AppendTag(param, 1, protowire.VarintType)
+		param = protowire.AppendVarint(param, protowire.EncodeBool(value))
+		var entry []byte
+		entry = appendString(entry, 1, key)
+		entry = appendMessage(entry, 2, param)
+		b = appendMessage(b, 4, entry)
+	}
+	for _, in := range r.inputs {
+		var t []byte
+		t = appendString(t, 1, in.name)
+		t = appendString(t, 2, in.datatype)
+		var shape []byte
+		for _, dim := range in.shape {
+			shape = protowire.AppendVarint(shape, uint64(dim))
+		}
+		t = appendMessage(t, 3, shape)
+		b = appendMessage(b, 5, t)
+	}
+	for _, name := range r.outputs {
+		b = appendMessage(b, 6, appendString(nil, 1, name))
+	}
+	for _, in := range r.inputs {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, in.raw)
+	}
+	return b
+}
+
+// inferResponse is the part of a ModelStreamInferResponse the client reads
+type inferResponse struct {
+	errorMessage string
+	final        bool              // triton_final_response parameter
+	outputs      map[string][]byte // Output name to its BYTES contents, first element
+}
+
+// unmarshalStreamResponse decodes a ModelStreamInferResponse
+func unmarshalStreamResponse(b []byte) (*inferResponse, error) {
+	resp := &inferResponse{outputs: map[string][]byte{}}
+	err := walk(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			resp.errorMessage = string(v)
+		case 2:
+			return resp.unmarshalInfer(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode stream response: %w", err)
+	}
+	return resp, nil
+}
+
+// unmarshalInfer decodes a ModelInferResponse into resp
+func (resp *inferResponse) unmarshalInfer(b []byte) error {
+	var names []string
+	var raw [][]byte
+	inline := map[string][]byte{}
+	err := walk(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 4:
+			key, final, err := boolParam(v)
+			if err != nil {
+				return err
+			}
+			if key == "triton_final_response" {
+				resp.final = final
+			}
+		case 5:
+			name, contents, err := outputTensor(v)
+			if err != nil {
+				return err
+			}
+			names = append(names, name)
+			if contents != nil {
+				inline[name] = contents
+			}
+		case 6:
+			raw = append(raw, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// raw_output_contents, when used, holds the contents of every output in order
+	for i, name := range names {
+		switch {
+		case i < len(raw):
+			value, err := firstBytesElement(raw[i])
+			if err != nil {
+				return fmt.Errorf("output %s: %w", name, err)
+			}
+			resp.outputs[name] = value
+		case inline[name] != nil:
+			resp.outputs[name] = inline[name]
+		}
+	}
+	return nil
+}
+
+// boolParam decodes a map<string, InferParameter> entry, returning its bool value
+func boolParam(b []byte) (string, bool, error) {
+	var key string
+	var value bool
+	err := walk(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			return walkVarints(v, func(num protowire.Number, x uint64) {
+				if num == 1 {
+					value = protowire.DecodeBool(x)
+				}
+			})
+		}
+		return nil
+	})
+	return key, value, err
+}
+
+// outputTensor decodes an InferOutputTensor, returning its name and first
+// bytes_contents element, if any
+func outputTensor(b []byte) (string, []byte, error) {
+	var name string
+	var contents []byte
+	err := walk(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			name = string(v)
+		case 5:
+			// InferTensorContents.bytes_contents is field 8
+			return walk(v, func(num protowire.Number, v []byte) error {
+				if num == 8 && contents == nil {
+					contents = append([]byte{}, v...)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return name, contents, err
+}
+
+// firstBytesElement returns the first element of a raw BYTES tensor, where each
+// element is prefixed with its 4-byte little-endian length
+func firstBytesElement(raw []byte) ([]byte, error) {
+	if len(raw) < 4 {
+		return nil, fmt.Errorf("truncated BYTES tensor")
+	}
+	n := binary.LittleEndian.Uint32(raw)
+	if uint64(len(raw)-4) < uint64(n) {
+		return nil, fmt.Errorf("truncated BYTES tensor")
+	}
+	return raw[4 : 4+n], nil
+}
+
+// walk calls fn with the length-delimited fields of a message, skipping others
+func walk(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkVarints calls fn with the varint fields of a message, skipping others
+func walkVarints(b []byte, fn func(protowire.Number, uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.VarintType {
+			x, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, x)
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
@@ -0,0 +1,217 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files, sorting in rotation order and
+// valid in file names on every platform
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// FileConfig configures a rotating log file
+type FileConfig struct {
+	Path       string
+	MaxSize    int64         // Bytes past which the file is rotated, defaults to 100MB
+	MaxBackups int           // Rotated files kept, all when 0
+	MaxAge     time.Duration // Rotated files older than this are deleted, none when 0
+	Compress   bool          // Gzip rotated files
+}
+
+// File is a log file that is rotated when it grows past its maximum size.
+// The rotated file is renamed with the time of rotation, e.g. agent.log to
+// agent-2026-01-02T15-04-05.000.log, then compressed and old backups are
+// deleted in the background.
+type File struct {
+	cfg FileConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	cleanupMu sync.Mutex // Serializes compression and deletion of backups
+	wg        sync.WaitGroup
+}
+
+// OpenFile opens a log file for appending, creating it and its directory as
+// needed
+func OpenFile(cfg *FileConfig) (*File, error) {
+	c := *cfg
+	if c.MaxSize <= 0 {
+		c.MaxSize = 100 << 20
+	}
+	f := &File{cfg: c}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file, keeping its current size
+func (f *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.cfg.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first when p would take it
+// past its maximum size
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.cfg.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file as a backup and starts a new one. When
+// the file cannot be renamed, logging goes on in it.
+func (f *File) rotate() error {
+	f.file.Close()
+	f.file = nil
+	backup := f.backupName(time.Now())
+	renameErr := os.Rename(f.cfg.Path, backup)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", renameErr)
+		return nil
+	}
+	f.wg.Go(func() { f.cleanup(backup) })
+	return nil
+}
+
+// backupName returns the name of a file rotated at t
+func (f *File) backupName(t time.Time) string {
+	ext := filepath.Ext(f.cfg.Path)
+	return strings.TrimSuffix(f.cfg.Path, ext) + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// cleanup compresses a new backup, then deletes the backups past the
+// maximum count or age. Failures are reported on stderr, the log itself
+// being the file at fault.
+func (f *File) cleanup(backup string) {
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+
+	if f.cfg.Compress {
+		if err := compress(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compress log file %s: %v\n", backup, err)
+		}
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list log files: %v\n", err)
+		return
+	}
+	// Newest first
+	for i, b := range backups {
+		tooMany := f.cfg.MaxBackups > 0 && i >= f.cfg.MaxBackups
+		tooOld := f.cfg.MaxAge > 0 && time.Since(b.rotated) > f.cfg.MaxAge
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete log file: %v\n", err)
+			}
+		}
+	}
+}
+
+// backup is a rotated log file
+type backup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups lists the rotated files, newest first
+func (f *File) backups() ([]backup, error) {
+	dir := filepath.Dir(f.cfg.Path)
+	ext := filepath.Ext(f.cfg.Path)
+	prefix := strings.TrimSuffix(filepath.Base(f.cfg.Path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(name, ".gz"), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		rotated, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), rotated: rotated})
+	}
+	slices.SortFunc(backups, func(a, b backup) int { return b.rotated.Compare(a.rotated) })
+	return backups, nil
+}
+
+// compress gzips a file next to it and removes the original
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(path)
+}
+
+// Close closes the file after the background cleanup is done
+func (f *File) Close() error {
+	f.mu.Lock()
+	file := f.file
+	f.file = nil
+	f.mu.Unlock()
+	f.wg.Wait()
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "agent.log")
+	f, err := OpenFile(&FileConfig{Path: path, MaxSize: 20, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		// Rotated files are named to the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "fourth line\n" {
+		t.Errorf("current file = %q, want the last line", data)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backups []string
+	for _, e := range entries {
+		if e.Name() != "agent.log" {
+			backups = append(backups, e.Name())
+		}
+	}
+	// The oldest backup, of the first line, is deleted
+	if len(backups) != 2 || !slices.ContainsFunc(backups, func(name string) bool {
+		return strings.HasPrefix(name, "agent-") && strings.HasSuffix(name, ".log.gz")
+	}) {
+		t.Errorf("backups = %v, want 2 compressed ones", backups)
+	}
+}
+
+func TestFileMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.log")
+	f, err := OpenFile(&FileConfig{Path: path, MaxSize: 10, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := f.backupName(time.Now().Add(-2 * time.Hour))
+	if err := os.WriteFile(old, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("0123456789\n"))
+	f.Write([]byte("rotated\n"))
+	f.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("backup older than max age was kept: %v", err)
+	}
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("backups = %v, want the new one", backups)
+	}
+}
@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// ComponentKey is the attribute naming the component a logger belongs to
+const ComponentKey = "component"
+
+// CorrelationKey is the attribute holding the correlation ID of the request
+// a log line belongs to
+const CorrelationKey = "correlation_id"
+
+// CorrelationHeader carries the correlation ID of HTTP requests and responses
+const CorrelationHeader = "X-Request-Id"
+
+// Component returns the default logger tagged with a component, whose level
+// can be set apart from the global one
+func Component(name string) *slog.Logger {
+	return slog.Default().With(ComponentKey, name)
+}
+
+// HandlerOptions configures NewHandler
+type HandlerOptions struct {
+	Format    string       // "text" (default) or "json"
+	Level     slog.Leveler // Level of components without their own
+	Levels    map[string]slog.Level
+	AddSource bool
+	// Prompts is how content attributes are logged: PromptsNone (default),
+	// PromptsTruncated or PromptsFull
+	Prompts string
+	// Secrets are masked wherever they appear, besides the values of
+	// authorization, key, token, secret, password and cookie attributes
+	Secrets []string
+}
+
+// NewHandler creates a handler writing text or JSON lines to w, with a level
+// per component and the correlation ID of the context on every line.
+// Secrets are masked and prompt content logged only as far as allowed.
+func NewHandler(w io.Writer, opts *HandlerOptions) slog.Handler {
+	if opts == nil {
+		opts = &HandlerOptions{}
+	}
+	// The handler filters levels itself, the wrapped one writes everything
+	innerOpts := &slog.HandlerOptions{Level: slog.Level(-100), AddSource: opts.AddSource}
+	var inner slog.Handler = slog.NewTextHandler(w, innerOpts)
+	if opts.Format == "json" {
+		inner = slog.NewJSONHandler(w, innerOpts)
+	}
+	level := opts.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &handler{inner: inner, level: level, levels: opts.Levels, redact: newRedactor(opts.Prompts, opts.Secrets)}
+}
+
+// handler filters records by the level of their component, redacts them
+// and adds the correlation ID
+type handler struct {
+	inner  slog.Handler
+	level  slog.Leveler
+	levels map[string]slog.Level
+	redact *redactor
+}
+
+// Enabled implements slog.Handler
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	r = h.redact.record(r)
+	if id := CorrelationID(ctx); id != "" {
+		r.AddAttrs(slog.String(CorrelationKey, id))
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler. A component attribute switches to the
+// component's level.
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redact.attr(a)
+	}
+	c.inner = h.inner.WithAttrs(redacted)
+	for _, a := range attrs {
+		if a.Key != ComponentKey {
+			continue
+		}
+		if level, ok := h.levels[a.Value.String()]; ok {
+			c.level = level
+		}
+	}
+	return &c
+}
+
+// WithGroup implements slog.Handler
+func (h *handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithGroup(name)
+	return &c
+}
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context whose log lines carry id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID of ctx, or ""
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// NewCorrelationID returns a random correlation ID
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware gives each request a correlation ID, the client's X-Request-Id
+// when it sent a valid one, and returns it in the response header
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationHeader)
+		if !validCorrelationID(id) {
+			id = NewCorrelationID()
+		}
+		w.Header().Set(CorrelationHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithCorrelationID(r.Context(), id)))
+	})
+}
+
+// validCorrelationID reports whether a client's ID is safe to log: short
+// and made of letters, digits, '-', '_' and '.'
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
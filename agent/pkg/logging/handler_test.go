@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, &HandlerOptions{
+		Format: "json",
+		Level:  slog.LevelInfo,
+		Levels: map[string]slog.Level{"llm": slog.LevelDebug, "server": slog.LevelWarn},
+	}))
+	ctx := WithCorrelationID(context.Background(), "abc")
+
+	logger.With(ComponentKey, "llm").DebugContext(ctx, "llm debug")
+	logger.With(ComponentKey, "server").InfoContext(ctx, "server info")
+	logger.With(ComponentKey, "server").WarnContext(ctx, "server warn")
+	logger.DebugContext(ctx, "global debug")
+	logger.Info("global info")
+
+	var got []string
+	for line := range strings.Lines(buf.String()) {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		id, _ := entry[CorrelationKey].(string)
+		got = append(got, entry["msg"].(string)+" "+id)
+	}
+	want := []string{"llm debug abc", "server warn abc", "global info "}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = CorrelationID(r.Context())
+	}))
+	for header, keep := range map[string]bool{"req-42": true, "": false, "bad id\n": false} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(CorrelationHeader, header)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get(CorrelationHeader); got != seen || seen == "" {
+			t.Errorf("header %q: response ID %q, request ID %q", header, got, seen)
+		}
+		if (seen == header) != keep {
+			t.Errorf("header %q: ID = %q, kept = %v, want %v", header, seen, seen == header, keep)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	prompt := "my card number is 4111 1111 1111 1111, please keep it safe and never repeat it to anyone at all"
+	tests := []struct {
+		name    string
+		prompts string
+		log     func(*slog.Logger)
+		want    []string // Substrings of the line
+		notWant []string
+	}{
+		{
+			name: "secret attributes",
+			log: func(l *slog.Logger) {
+				l.Info("call", "authorization", "Bearer abc.def", "bot_token", "123:xyz", "prompt_tokens", 12, "tokenizer", "ratio-openai")
+			},
+			want:    []string{"authorization=[REDACTED]", "bot_token=[REDACTED]", "prompt_tokens=12", "tokenizer=ratio-openai"},
+			notWant: []string{"abc.def", "123:xyz"},
+		},
+		{
+			name: "secrets in text",
+			log: func(l *slog.Logger) {
+				l.Info("request failed", "error", errors.New("401 for key sk-abcdefghijklmnopqrstuvwx"), "url", "https://x/?k=configured-secret")
+			},
+			want:    []string{"[REDACTED]"},
+			notWant: []string{"sk-abcdefghijklmnopqrstuvwx", "configured-secret"},
+		},
+		{
+			name: "headers",
+			log: func(l *slog.Logger) {
+				l.Info("headers", "headers", http.Header{"X-Api-Key": {"k3y-value"}, "Accept": {"text/plain"}})
+			},
+			want:    []string{"text/plain"},
+			notWant: []string{"k3y-value"},
+		},
+		{
+			name:    "prompt hidden",
+			log:     func(l *slog.Logger) { l.Info("prompt", Content("prompt", prompt)) },
+			want:    []string{"chars, sha256:"},
+			notWant: []string{"4111"},
+		},
+		{
+			name:    "prompt truncated",
+			prompts: PromptsTruncated,
+			log:     func(l *slog.Logger) { l.Info("prompt", Content("prompt", prompt)) },
+			want:    []string{"my card number", "…", "sha256:"},
+			notWant: []string{"anyone at all"},
+		},
+		{
+			name:    "prompt in full",
+			prompts: PromptsFull,
+			log:     func(l *slog.Logger) { l.Info("prompt", Content("prompt", prompt)) },
+			want:    []string{"anyone at all"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(slog.New(NewHandler(&buf, &HandlerOptions{Prompts: tt.prompts, Secrets: []string{"configured-secret"}})))
+			line := buf.String()
+			for _, s := range tt.want {
+				if !strings.Contains(line, s) {
+					t.Errorf("line %q does not contain %q", line, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(line, s) {
+					t.Errorf("line %q contains %q", line, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ParseLevel converts a level name (debug, info, warn, error) to a slog.Level, defaulting to info
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// LevelName returns the lowercase config name of a level
+func LevelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// LevelController changes the log level at runtime without a restart
+type LevelController struct {
+	level *slog.LevelVar
+	base  slog.Level // Level restored when toggling debug off
+}
+
+// NewLevelController creates a controller starting at the given level
+func NewLevelController(initial slog.Level) *LevelController {
+	level := &slog.LevelVar{}
+	level.Set(initial)
+
+	base := initial
+	if base == slog.LevelDebug {
+		base = slog.LevelInfo
+	}
+	return &LevelController{level: level, base: base}
+}
+
+// Leveler returns the level to pass to slog.HandlerOptions
+func (c *LevelController) Leveler() slog.Leveler {
+	return c.level
+}
+
+// Level returns the current level
+func (c *LevelController) Level() slog.Level {
+	return c.level.Level()
+}
+
+// Set changes the current level
+func (c *LevelController) Set(l slog.Level) {
+	previous := c.level.Level()
+	c.level.Set(l)
+	if l != slog.LevelDebug {
+		c.base = l
+	}
+	slog.Info("Log level changed", "from", LevelName(previous), "to", LevelName(l))
+}
+
+// Toggle switches between debug and the configured non-debug level
+func (c *LevelController) Toggle() slog.Level {
+	next := slog.LevelDebug
+	if c.level.Level() == slog.LevelDebug {
+		next = c.base
+	}
+	c.Set(next)
+	return next
+}
+
+// WatchSignals toggles debug logging on SIGUSR1 until ctx is cancelled.
+// It is a no-op on platforms without SIGUSR1.
+func (c *LevelController) WatchSignals(ctx context.Context) {
+	watchToggleSignal(ctx, func() { c.Toggle() })
+}
+
+// ServeHTTP implements the admin endpoint: GET returns the current level,
+// PUT or POST with {"level":"debug"} (or ?level=debug) changes it.
+func (c *LevelController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		name := r.URL.Query().Get("level")
+		if name == "" {
+			var body struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			name = body.Level
+		}
+
+		switch strings.ToLower(name) {
+		case "debug", "info", "warn", "warning", "error":
+			c.Set(ParseLevel(name))
+		default:
+			http.Error(w, "invalid level: must be one of debug, info, warn, error", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": LevelName(c.Level())})
+}
+
+// AuditState implements admin.Auditable
+func (c *LevelController) AuditState() any {
+	return LevelName(c.Level())
+}
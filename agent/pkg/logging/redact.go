@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// How prompt and response content is logged
+const (
+	PromptsNone      = "none"      // Only its length and hash (default)
+	PromptsTruncated = "truncated" // Its start, length and hash
+	PromptsFull      = "full"      // As is
+)
+
+// truncatedLength is the number of characters of content logged in
+// truncated mode
+const truncatedLength = 80
+
+// redacted replaces secrets in log lines
+const redacted = "[REDACTED]"
+
+// Content returns an attribute holding prompt or response content, logged
+// as logging.log_prompts allows
+func Content(key, text string) slog.Attr {
+	return slog.Any(key, content(text))
+}
+
+// content is prompt or response content
+type content string
+
+// render formats content for mode
+func (c content) render(mode string) string {
+	if mode == PromptsFull {
+		return string(c)
+	}
+	sum := sha256.Sum256([]byte(c))
+	summary := fmt.Sprintf("(%d chars, sha256:%s)", utf8.RuneCountInString(string(c)), hex.EncodeToString(sum[:4]))
+	if mode != PromptsTruncated {
+		return summary
+	}
+	text := string(c)
+	if utf8.RuneCountInString(text) > truncatedLength {
+		text = string([]rune(text)[:truncatedLength]) + "…"
+	}
+	return text + " " + summary
+}
+
+// secretKeys match the attribute and header names whose values are always
+// masked, e.g. authorization, x-api-key or bot_token
+var secretKeys = regexp.MustCompile(`(?i)(^|[_-])(authorization|api[_-]?key|token|secret|password|cookie)$`)
+
+// secretPatterns match credentials in logged text
+var secretPatterns = regexp.MustCompile(`(?i)\bbearer\s+[a-z0-9._~+/=-]+|\b(?:sk|pk|rk)-[a-z0-9_-]{16,}|\bxox[abpr]-[a-z0-9-]+|\bxapp-[a-z0-9-]+|\bAIza[0-9a-z_-]{35}\b`)
+
+// redactor masks secrets and prompt content in log attributes
+type redactor struct {
+	prompts string
+	secrets *strings.Replacer // Known secret values, nil when none
+}
+
+// newRedactor creates a redactor masking the given secret values. Short
+// values are ignored, they would mask unrelated text.
+func newRedactor(prompts string, secrets []string) *redactor {
+	r := &redactor{prompts: prompts}
+	var pairs []string
+	for _, s := range secrets {
+		if len(s) >= 8 {
+			pairs = append(pairs, s, redacted)
+		}
+	}
+	if len(pairs) > 0 {
+		r.secrets = strings.NewReplacer(pairs...)
+	}
+	return r
+}
+
+// secretKey reports whether values of an attribute or header are secret
+func secretKey(key string) bool {
+	return secretKeys.MatchString(key)
+}
+
+// text masks secrets in s
+func (r *redactor) text(s string) string {
+	if r.secrets != nil {
+		s = r.secrets.Replace(s)
+	}
+	return secretPatterns.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(strings.ToLower(match), "bearer") {
+			return match[:len("bearer ")] + redacted
+		}
+		return redacted
+	})
+}
+
+// attr masks a secret attribute and renders content
+func (r *redactor) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = r.attr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	case slog.KindString:
+		if secretKey(a.Key) && v.String() != "" {
+			return slog.String(a.Key, redacted)
+		}
+		return slog.String(a.Key, r.text(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case content:
+			return slog.String(a.Key, r.text(x.render(r.prompts)))
+		case http.Header:
+			return slog.Any(a.Key, r.header(x))
+		case error:
+			if s := r.text(x.Error()); s != x.Error() {
+				return slog.String(a.Key, s)
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// header returns a copy of h with secret headers masked
+func (r *redactor) header(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, values := range h {
+		if secretKey(k) {
+			out[k] = []string{redacted}
+			continue
+		}
+		out[k] = make([]string, len(values))
+		for i, v := range values {
+			out[k][i] = r.text(v)
+		}
+	}
+	return out
+}
+
+// record returns r with secrets masked and content rendered
+func (r *redactor) record(rec slog.Record) slog.Record {
+	out := slog.NewRecord(rec.Time, rec.Level, r.text(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(r.attr(a))
+		return true
+	})
+	return out
+}
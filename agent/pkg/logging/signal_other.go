@@ -0,0 +1,8 @@
+//go:build !unix
+
+package logging
+
+import "context"
+
+// watchToggleSignal is a no-op on platforms without SIGUSR1
+func watchToggleSignal(ctx context.Context, toggle func()) {}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package logging
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchToggleSignal calls toggle on every SIGUSR1 until ctx is cancelled
+func watchToggleSignal(ctx context.Context, toggle func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigs:
+				toggle()
+			}
+		}
+	}()
+}
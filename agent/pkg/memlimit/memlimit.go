@@ -0,0 +1,249 @@
+package memlimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Level describes the current memory pressure
+type Level int32
+
+const (
+	// LevelNormal means RSS is below the soft limit
+	LevelNormal Level = iota
+	// LevelSoft means RSS is past the soft limit: caches are shrunk and batch jobs rejected
+	LevelSoft
+	// LevelHard means RSS is past the hard limit: new sessions are refused
+	LevelHard
+)
+
+// String returns the level name
+func (l Level) String() string {
+	switch l {
+	case LevelSoft:
+		return "soft"
+	case LevelHard:
+		return "hard"
+	default:
+		return "normal"
+	}
+}
+
+// Config holds memory monitor configuration
+type Config struct {
+	SoftLimit     int64         // Bytes, 0 disables the soft limit
+	HardLimit     int64         // Bytes, 0 disables the hard limit
+	CheckInterval time.Duration // Optional, defaults to 10 seconds
+	Logger        *slog.Logger
+}
+
+// Monitor samples process RSS and reacts to soft and hard thresholds
+type Monitor struct {
+	softLimit int64
+	hardLimit int64
+	interval  time.Duration
+	logger    *slog.Logger
+	readRSS   func() (int64, error)
+
+	level atomic.Int32
+	rss   atomic.Int64
+
+	mu        sync.Mutex
+	shrinkers []func()
+}
+
+// NewMonitor creates a new memory monitor
+func NewMonitor(cfg *Config) (*Monitor, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.SoftLimit < 0 || cfg.HardLimit < 0 {
+		return nil, fmt.Errorf("memory limits cannot be negative")
+	}
+	if cfg.SoftLimit > 0 && cfg.HardLimit > 0 && cfg.SoftLimit > cfg.HardLimit {
+		return nil, fmt.Errorf("soft limit (%d) cannot exceed hard limit (%d)", cfg.SoftLimit, cfg.HardLimit)
+	}
+
+	interval := cfg.CheckInterval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("memlimit")
+	}
+
+	return &Monitor{
+		softLimit: cfg.SoftLimit,
+		hardLimit: cfg.HardLimit,
+		interval:  interval,
+		logger:    logger,
+		readRSS:   readRSS,
+	}, nil
+}
+
+// OnSoftLimit registers a function that releases memory (e.g. clears a cache or
+// buffer pool). Shrinkers run each time the monitor enters the soft level.
+func (m *Monitor) OnSoftLimit(shrink func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shrinkers = append(m.shrinkers, shrink)
+}
+
+// Level returns the current memory pressure level
+func (m *Monitor) Level() Level {
+	return Level(m.level.Load())
+}
+
+// RSS returns the last sampled resident set size in bytes
+func (m *Monitor) RSS() int64 {
+	return m.rss.Load()
+}
+
+// AllowBatch reports whether new batch jobs may start
+func (m *Monitor) AllowBatch() bool {
+	return m.Level() == LevelNormal
+}
+
+// AllowNewSession reports whether new sessions may be created
+func (m *Monitor) AllowNewSession() bool {
+	return m.Level() != LevelHard
+}
+
+// Run samples RSS until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	if m.hardLimit > 0 {
+		// Let the GC work harder before the hard limit is reached
+		debug.SetMemoryLimit(m.hardLimit)
+	}
+
+	m.logger.Info("Memory monitor started",
+		"soft_limit", m.softLimit,
+		"hard_limit", m.hardLimit,
+		"interval", m.interval,
+	)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.check()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// check samples RSS once and updates the level
+func (m *Monitor) check() {
+	rss, err := m.readRSS()
+	if err != nil {
+		m.logger.Warn("Failed to read process RSS", "error", err)
+		return
+	}
+	m.rss.Store(rss)
+
+	level := LevelNormal
+	switch {
+	case m.hardLimit > 0 && rss >= m.hardLimit:
+		level = LevelHard
+	case m.softLimit > 0 && rss >= m.softLimit:
+		level = LevelSoft
+	}
+
+	previous := Level(m.level.Swap(int32(level)))
+	if level == previous {
+		return
+	}
+
+	if level > previous {
+		m.logger.Warn("Memory pressure increased", "level", level.String(), "rss", rss)
+	} else {
+		m.logger.Info("Memory pressure decreased", "level", level.String(), "rss", rss)
+	}
+
+	if level >= LevelSoft && previous == LevelNormal {
+		m.shrink()
+	}
+}
+
+// shrink runs registered shrinkers and returns freed memory to the OS
+func (m *Monitor) shrink() {
+	m.mu.Lock()
+	shrinkers := append([]func(){}, m.shrinkers...)
+	m.mu.Unlock()
+
+	for _, shrink := range shrinkers {
+		shrink()
+	}
+	debug.FreeOSMemory()
+	m.logger.Info("Released memory after soft limit", "shrinkers", len(shrinkers))
+}
+
+// Middleware refuses new sessions with 503 while the monitor is at the hard
+// level. It matches the REST API's session routes only, the chat endpoints
+// starting sessions implicitly ask AllowNewSession themselves.
+func (m *Monitor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSessionCreate(r) && !m.AllowNewSession() {
+			m.logger.Warn("Rejecting new session due to memory pressure", "rss", m.RSS())
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.interval.Seconds())+1))
+			http.Error(w, "server is under memory pressure, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isSessionCreate matches the ADK REST session creation routes:
+// POST .../users/{user_id}/sessions and POST .../users/{user_id}/sessions/{session_id}
+func isSessionCreate(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	n := len(segments)
+	switch {
+	case n >= 3 && segments[n-1] == "sessions" && segments[n-3] == "users":
+		return true
+	case n >= 4 && segments[n-2] == "sessions" && segments[n-4] == "users":
+		return true
+	default:
+		return false
+	}
+}
+
+// readRSS returns the resident set size of the current process. It reads
+// /proc/self/statm on Linux and falls back to Go runtime statistics elsewhere.
+func readRSS() (int64, error) {
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) >= 2 {
+			pages, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse statm: %w", err)
+			}
+			return pages * int64(os.Getpagesize()), nil
+		}
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys - stats.HeapReleased), nil
+}
@@ -0,0 +1,75 @@
+package memlimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestMonitor tests the levels, shrinkers and admission checks as RSS moves
+// across the limits
+func TestMonitor(t *testing.T) {
+	m, err := NewMonitor(&Config{SoftLimit: 100, HardLimit: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rss int64
+	m.readRSS = func() (int64, error) { return rss, nil }
+	shrunk := 0
+	m.OnSoftLimit(func() { shrunk++ })
+
+	steps := []struct {
+		rss        int64
+		level      Level
+		shrunk     int
+		batch, new bool
+	}{
+		{50, LevelNormal, 0, true, true},
+		{150, LevelSoft, 1, false, true},
+		{160, LevelSoft, 1, false, true},
+		{250, LevelHard, 1, false, false},
+		{120, LevelSoft, 1, false, true},
+		{50, LevelNormal, 1, true, true},
+		{300, LevelHard, 2, false, false},
+	}
+	for _, step := range steps {
+		rss = step.rss
+		m.check()
+		if m.Level() != step.level || shrunk != step.shrunk || m.AllowBatch() != step.batch || m.AllowNewSession() != step.new {
+			t.Errorf("at %d bytes: level %s, %d shrinks, batch %v, new sessions %v; want %s, %d, %v, %v",
+				step.rss, m.Level(), shrunk, m.AllowBatch(), m.AllowNewSession(), step.level, step.shrunk, step.batch, step.new)
+		}
+	}
+	if m.RSS() != 300 {
+		t.Errorf("RSS() = %d, want 300", m.RSS())
+	}
+
+	if _, err := NewMonitor(&Config{SoftLimit: 300, HardLimit: 200}); err == nil {
+		t.Error("NewMonitor(soft above hard) error = nil")
+	}
+}
+
+// TestMiddleware tests that only session creation is refused at the hard level
+func TestMiddleware(t *testing.T) {
+	m, _ := NewMonitor(&Config{HardLimit: 100})
+	m.readRSS = func() (int64, error) { return 200, nil }
+	m.check()
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/api/apps/a/users/u/sessions", http.StatusServiceUnavailable},
+		{http.MethodPost, "/api/apps/a/users/u/sessions/s1", http.StatusServiceUnavailable},
+		{http.MethodGet, "/api/apps/a/users/u/sessions", http.StatusOK},
+		{http.MethodPost, "/api/run_sse", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
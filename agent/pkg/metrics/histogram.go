@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"slices"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histograms
+var LatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+// Exemplar is an observation with the trace it belongs to
+type Exemplar struct {
+	TraceID string // Invocation ID of the turn, as in the traces
+	Value   float64
+	Time    time.Time
+}
+
+// Histogram counts observations in LatencyBuckets and keeps the latest
+// observation of each bucket as its exemplar
+type Histogram struct {
+	Counts    []int64    // Per bucket, not cumulative; the last one is +Inf
+	Exemplars []Exemplar // Per bucket, empty TraceID when none
+	Sum       float64
+	Count     int64
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		Counts:    make([]int64, len(LatencyBuckets)+1),
+		Exemplars: make([]Exemplar, len(LatencyBuckets)+1),
+	}
+}
+
+// observe adds an observation of v seconds
+func (h *Histogram) observe(v float64, traceID string, at time.Time) {
+	i, _ := slices.BinarySearch(LatencyBuckets, v)
+	h.Counts[i]++
+	if traceID != "" {
+		h.Exemplars[i] = Exemplar{TraceID: traceID, Value: v, Time: at}
+	}
+	h.Sum += v
+	h.Count++
+}
+
+func (h *Histogram) clone() Histogram {
+	c := *h
+	c.Counts = slices.Clone(h.Counts)
+	c.Exemplars = slices.Clone(h.Exemplars)
+	return c
+}
+
+// Latency holds the latency histograms of one agent and model
+type Latency struct {
+	Call          Histogram // Model calls until the final response or error
+	FirstResponse Histogram // Model calls until their first chunk or response
+}
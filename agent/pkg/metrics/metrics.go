@@ -0,0 +1,363 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/refusal"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+)
+
+// sessionTTL is how long an idle session's last turn is remembered to
+// recognize a repeated message
+const sessionTTL = time.Hour
+
+// Labels identify the series a turn is counted in
+type Labels struct {
+	Agent string
+	Model string
+}
+
+// Counts are the quality signals counted for one agent and model
+type Counts struct {
+	Turns         int64 // Turns started
+	Retries       int64 // Turns repeating the message of a turn that failed
+	Regenerations int64 // Turns repeating the message of a turn that was answered
+	Refusals      int64 // Answers replaced by a provider refusal
+	Errors        int64 // Turns that failed with an error
+	AnswerChars   int64 // Characters of final answers, for the average turn length
+}
+
+// Quality counts proxy signals of conversation quality per agent and model:
+// how often users resend a message after a failure (retry) or after an
+// answer (regenerate), how often the provider refuses and how long answers
+// are. Rates are the counts divided by Turns, computed by the dashboard.
+// It also measures model latency, with the turns of recent observations as
+// exemplars.
+type Quality struct {
+	mu        sync.Mutex
+	counts    map[Labels]*Counts
+	latencies map[Labels]*latency
+	sessions  map[string]*lastTurn
+}
+
+// latency is the mutable form of Latency
+type latency struct {
+	call, firstResponse *Histogram
+}
+
+// lastTurn is the latest turn of a session
+type lastTurn struct {
+	invocation string
+	message    string
+	failed     bool
+	seen       time.Time
+}
+
+// NewQuality creates an empty quality tracker
+func NewQuality() *Quality {
+	return &Quality{
+		counts:    make(map[Labels]*Counts),
+		latencies: make(map[Labels]*latency),
+		sessions:  make(map[string]*lastTurn),
+	}
+}
+
+// Model wraps llm so its calls are counted
+func (q *Quality) Model(llm model.LLM) model.LLM {
+	return &qualityModel{llm: llm, quality: q}
+}
+
+// Snapshot returns a copy of the counts
+func (q *Quality) Snapshot() map[Labels]Counts {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[Labels]Counts, len(q.counts))
+	for l, c := range q.counts {
+		out[l] = *c
+	}
+	return out
+}
+
+// Latencies returns a copy of the latency histograms
+func (q *Quality) Latencies() map[Labels]Latency {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[Labels]Latency, len(q.latencies))
+	for l, h := range q.latencies {
+		out[l] = Latency{Call: h.call.clone(), FirstResponse: h.firstResponse.clone()}
+	}
+	return out
+}
+
+// series lists the exported counters
+var series = []struct {
+	name, help string
+	value      func(Counts) int64
+}{
+	{"yanshu_turns_total", "Turns started, by the agent and model answering first", func(c Counts) int64 { return c.Turns }},
+	{"yanshu_turn_retries_total", "Turns repeating the message of a failed turn", func(c Counts) int64 { return c.Retries }},
+	{"yanshu_turn_regenerations_total", "Turns repeating the message of an answered turn", func(c Counts) int64 { return c.Regenerations }},
+	{"yanshu_turn_errors_total", "Turns that failed with an error", func(c Counts) int64 { return c.Errors }},
+	{"yanshu_refusals_total", "Answers replaced by a provider refusal", func(c Counts) int64 { return c.Refusals }},
+	{"yanshu_answer_chars_total", "Characters of final answers", func(c Counts) int64 { return c.AnswerChars }},
+}
+
+// histograms lists the exported histograms
+var histograms = []struct {
+	name, help string
+	value      func(Latency) Histogram
+}{
+	{"yanshu_model_call_duration_seconds", "Duration of model calls until the final response or error", func(l Latency) Histogram { return l.Call }},
+	{"yanshu_model_first_response_seconds", "Time from the start of model calls to their first chunk or response", func(l Latency) Histogram { return l.FirstResponse }},
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (q *Quality) WriteTo(w io.Writer) (int64, error) {
+	return q.write(w, false)
+}
+
+// WriteOpenMetrics writes the metrics in the OpenMetrics text format, which
+// adds the exemplars of the histogram buckets
+func (q *Quality) WriteOpenMetrics(w io.Writer) (int64, error) {
+	return q.write(w, true)
+}
+
+func (q *Quality) write(w io.Writer, openMetrics bool) (int64, error) {
+	counts := q.Snapshot()
+	latencies := q.Latencies()
+	compare := func(a, b Labels) int {
+		return strings.Compare(a.Agent+"\x00"+a.Model, b.Agent+"\x00"+b.Model)
+	}
+
+	var b strings.Builder
+	labels := slices.SortedFunc(maps.Keys(counts), compare)
+	for _, s := range series {
+		// OpenMetrics names counter families without the _total suffix
+		family := s.name
+		if openMetrics {
+			family = strings.TrimSuffix(family, "_total")
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", family, s.help, family)
+		for _, l := range labels {
+			fmt.Fprintf(&b, "%s{agent=%s,model=%s} %d\n", s.name, quote(l.Agent), quote(l.Model), s.value(counts[l]))
+		}
+	}
+
+	labels = slices.SortedFunc(maps.Keys(latencies), compare)
+	for _, s := range histograms {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s histogram\n", s.name, s.help, s.name)
+		for _, l := range labels {
+			h := s.value(latencies[l])
+			var cumulative int64
+			for i, count := range h.Counts {
+				cumulative += count
+				le := "+Inf"
+				if i < len(LatencyBuckets) {
+					le = formatFloat(LatencyBuckets[i])
+				}
+				fmt.Fprintf(&b, "%s_bucket{agent=%s,model=%s,le=%s} %d", s.name, quote(l.Agent), quote(l.Model), quote(le), cumulative)
+				if e := h.Exemplars[i]; openMetrics && e.TraceID != "" {
+					fmt.Fprintf(&b, " # {trace_id=%s} %s %.3f", quote(e.TraceID), formatFloat(e.Value), float64(e.Time.UnixMilli())/1000)
+				}
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "%s_sum{agent=%s,model=%s} %s\n", s.name, quote(l.Agent), quote(l.Model), formatFloat(h.Sum))
+			fmt.Fprintf(&b, "%s_count{agent=%s,model=%s} %d\n", s.name, quote(l.Agent), quote(l.Model), h.Count)
+		}
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP implements the admin endpoint scraped by Prometheus. Scrapers
+// accepting OpenMetrics get the exemplars.
+func (q *Quality) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		q.WriteOpenMetrics(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	q.WriteTo(w)
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// quote quotes a label value, escaping backslashes, quotes and newlines
+func quote(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// countsOf returns the counts of l; q.mu must be held
+func (q *Quality) countsOf(l Labels) *Counts {
+	c, ok := q.counts[l]
+	if !ok {
+		c = &Counts{}
+		q.counts[l] = c
+	}
+	return c
+}
+
+// call counts the start of a model call, which starts a turn when it is the
+// first call of the invocation
+func (q *Quality) call(l Labels, session, invocation, message string) {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	last, ok := q.sessions[session]
+	if ok && last.invocation == invocation {
+		return
+	}
+	maps.DeleteFunc(q.sessions, func(_ string, t *lastTurn) bool { return now.Sub(t.seen) > sessionTTL })
+
+	c := q.countsOf(l)
+	c.Turns++
+	if ok && message != "" && message == last.message {
+		if last.failed {
+			c.Retries++
+		} else {
+			c.Regenerations++
+		}
+	}
+	q.sessions[session] = &lastTurn{invocation: invocation, message: message, seen: now}
+}
+
+// fail marks the turn of invocation failed, counting it once
+func (q *Quality) fail(l Labels, session, invocation string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if last, ok := q.sessions[session]; ok && last.invocation == invocation && !last.failed {
+		last.failed = true
+		q.countsOf(l).Errors++
+	}
+}
+
+// observe records the latency of a model call: since start until its first
+// response and, when done, until its end
+func (q *Quality) observe(l Labels, invocation string, start time.Time, first, done bool) {
+	now := time.Now()
+	seconds := now.Sub(start).Seconds()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	h, ok := q.latencies[l]
+	if !ok {
+		h = &latency{call: newHistogram(), firstResponse: newHistogram()}
+		q.latencies[l] = h
+	}
+	if first {
+		h.firstResponse.observe(seconds, invocation, now)
+	}
+	if done {
+		h.call.observe(seconds, invocation, now)
+	}
+}
+
+// answer counts a final response
+func (q *Quality) answer(l Labels, resp *model.LLMResponse) {
+	var chars int
+	if resp.Content != nil {
+		for _, part := range resp.Content.Parts {
+			if !part.Thought {
+				chars += utf8.RuneCountInString(part.Text)
+			}
+		}
+	}
+	_, refused := resp.CustomMetadata[refusal.MetadataReason]
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c := q.countsOf(l)
+	c.AnswerChars += int64(chars)
+	if refused {
+		c.Refusals++
+	}
+}
+
+// qualityModel counts the calls of the wrapped model
+type qualityModel struct {
+	llm     model.LLM
+	quality *Quality
+}
+
+// Name implements model.LLM
+func (m *qualityModel) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *qualityModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	ictx, ok := ctx.(agent.InvocationContext)
+	if !ok || ictx.Session() == nil {
+		return m.llm.GenerateContent(ctx, req, stream)
+	}
+	l := Labels{Model: m.llm.Name()}
+	if a := ictx.Agent(); a != nil {
+		l.Agent = a.Name()
+	}
+	session, invocation := ictx.Session().ID(), ictx.InvocationID()
+
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.quality.call(l, session, invocation, message(ictx))
+		start := time.Now()
+		first, done := true, false
+		defer func() {
+			// Ended without a final response, such as when the caller stopped
+			if !done {
+				m.quality.observe(l, invocation, start, false, true)
+			}
+		}()
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			final := err != nil || resp != nil && !resp.Partial
+			if first || final && !done {
+				m.quality.observe(l, invocation, start, first, final)
+				first, done = false, done || final
+			}
+			switch {
+			case err != nil:
+				m.quality.fail(l, session, invocation)
+			case resp != nil && !resp.Partial:
+				m.quality.answer(l, resp)
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// message is the normalized text of the user message that started the turn
+func message(ictx agent.InvocationContext) string {
+	content := ictx.UserContent()
+	if content == nil {
+		return ""
+	}
+	var words []string
+	for _, part := range content.Parts {
+		words = append(words, strings.Fields(part.Text)...)
+	}
+	return strings.Join(words, " ")
+}
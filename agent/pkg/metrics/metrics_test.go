@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/refusal"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// scriptedModel fails on "fail", refuses on "refuse" and answers "ok" otherwise
+type scriptedModel struct{}
+
+func (scriptedModel) Name() string { return "scripted" }
+
+func (scriptedModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		switch req.Contents[len(req.Contents)-1].Parts[0].Text {
+		case "fail":
+			yield(nil, errors.New("backend down"))
+		case "refuse":
+			yield(&model.LLMResponse{
+				Content:        genai.NewContentFromText("Sorry", genai.RoleModel),
+				CustomMetadata: map[string]any{refusal.MetadataReason: "content_filter"},
+			}, nil)
+		default:
+			yield(&model.LLMResponse{Content: genai.NewContentFromText("ok", genai.RoleModel)}, nil)
+		}
+	}
+}
+
+// TestQuality tests counting turns, retries, regenerations, refusals and answer
+// length, and measuring latency with exemplars
+func TestQuality(t *testing.T) {
+	q := NewQuality()
+	a, err := llmagent.New(llmagent.Config{Name: "helper", Model: q.Model(scriptedModel{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, _ := runner.New(runner.Config{AppName: "app", Agent: a, SessionService: sessions})
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "alice"})
+	for _, msg := range []string{"hello", " hello ", "fail", "fail", "refuse", "bye"} {
+		for range r.Run(ctx, "alice", created.Session.ID(), genai.NewContentFromText(msg, genai.RoleUser), agent.RunConfig{}) {
+		}
+	}
+
+	got := q.Snapshot()[Labels{Agent: "helper", Model: "scripted"}]
+	want := Counts{Turns: 6, Retries: 1, Regenerations: 1, Refusals: 1, Errors: 2, AnswerChars: 11}
+	if got != want {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	q.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		"# TYPE yanshu_turns_total counter",
+		`yanshu_turns_total{agent="helper",model="scripted"} 6`,
+		`yanshu_turn_retries_total{agent="helper",model="scripted"} 1`,
+		`yanshu_answer_chars_total{agent="helper",model="scripted"} 11`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("ServeHTTP() body lacks %q:\n%s", line, rec.Body)
+		}
+	}
+
+	if !strings.Contains(rec.Body.String(), `yanshu_model_call_duration_seconds_bucket{agent="helper",model="scripted",le="+Inf"} 6`+"\n") ||
+		strings.Contains(rec.Body.String(), "trace_id") {
+		t.Errorf("ServeHTTP() histograms:\n%s", rec.Body)
+	}
+
+	latency := q.Latencies()[Labels{Agent: "helper", Model: "scripted"}]
+	if latency.Call.Count != 6 || latency.FirstResponse.Count != 6 || latency.Call.Counts[0] != 6 {
+		t.Errorf("latency = %+v", latency)
+	}
+	exemplar := latency.Call.Exemplars[0]
+	if exemplar.TraceID == "" || exemplar.Value > LatencyBuckets[0] {
+		t.Errorf("exemplar = %+v", exemplar)
+	}
+
+	// OpenMetrics adds exemplars and names counter families without _total
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	q.ServeHTTP(rec, req)
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE yanshu_turns counter\n",
+		`yanshu_turns_total{agent="helper",model="scripted"} 6` + "\n",
+		`yanshu_model_call_duration_seconds_bucket{agent="helper",model="scripted",le="0.1"} 6 # {trace_id="` + exemplar.TraceID + `"} `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("OpenMetrics body lacks %q:\n%s", want, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Errorf("OpenMetrics response is not terminated or typed: %s", rec.Header().Get("Content-Type"))
+	}
+
+	h := newHistogram()
+	h.observe(1, "a", time.Now())
+	h.observe(1.5, "b", time.Now())
+	h.observe(500, "", time.Now())
+	if h.Counts[3] != 1 || h.Counts[4] != 1 || h.Counts[len(LatencyBuckets)] != 1 || h.Exemplars[4].TraceID != "b" || h.Sum != 502.5 {
+		t.Errorf("histogram = %+v", h)
+	}
+
+	if got := quote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("quote() = %s", got)
+	}
+}
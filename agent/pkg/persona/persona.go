@@ -0,0 +1,177 @@
+// Package persona implements named instruction presets switched per session.
+// The active persona is kept in session state and its instruction is added to
+// the agent's own on every model call, so one deployment can serve several
+// assistant behaviors.
+package persona
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+const (
+	// StateKey is the session state key holding the name of the active persona
+	StateKey = "persona"
+	// Command is the user message switching persona, followed by its name.
+	// Alone it lists the personas.
+	Command = "/persona"
+)
+
+// Persona is a named instruction preset
+type Persona struct {
+	Name        string
+	Description string
+	Instruction string
+}
+
+// Config holds the personas
+type Config struct {
+	Personas []Persona
+	// Default is active in sessions that chose none, empty for the agent's
+	// own instruction alone
+	Default string
+	Logger  *slog.Logger
+}
+
+// Switcher adds the instruction of the active persona to model calls
+type Switcher struct {
+	personas []Persona
+	byName   map[string]*Persona
+	def      string
+	logger   *slog.Logger
+}
+
+// New creates a switcher
+func New(cfg *Config) (*Switcher, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	byName := make(map[string]*Persona, len(cfg.Personas))
+	for i, p := range cfg.Personas {
+		if p.Name == "" || p.Name == "default" || strings.ContainsAny(p.Name, " \t\n") {
+			return nil, fmt.Errorf("invalid persona name %q", p.Name)
+		}
+		if _, ok := byName[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate persona %q", p.Name)
+		}
+		byName[p.Name] = &cfg.Personas[i]
+	}
+	if _, ok := byName[cfg.Default]; cfg.Default != "" && !ok {
+		return nil, fmt.Errorf("default persona %q is not defined", cfg.Default)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("persona")
+	}
+	return &Switcher{personas: cfg.Personas, byName: byName, def: cfg.Default, logger: logger}, nil
+}
+
+// Personas returns the personas in configuration order
+func (s *Switcher) Personas() []Persona {
+	return s.personas
+}
+
+// Active returns the name of the persona active in state, empty for none
+func (s *Switcher) Active(state session.ReadonlyState) string {
+	v, err := state.Get(StateKey)
+	name, _ := v.(string)
+	if err != nil || name == "" {
+		return s.def
+	}
+	if _, ok := s.byName[name]; !ok {
+		s.logger.Warn("Unknown persona in session state, using the default", "persona", name, "default", s.def)
+		return s.def
+	}
+	return name
+}
+
+// ModelCallback returns a callback that adds the active persona's
+// instruction to the request. On a persona command it switches persona and
+// answers directly instead.
+func (s *Switcher) ModelCallback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		if name, ok := command(ctx); ok {
+			return s.switchTo(ctx, name)
+		}
+		if p := s.byName[s.Active(ctx.State())]; p != nil && p.Instruction != "" {
+			instruction.AppendSystem(req, p.Instruction)
+		}
+		return nil, nil
+	}
+}
+
+// switchTo sets the active persona, or lists the personas when name is empty
+func (s *Switcher) switchTo(ctx agent.CallbackContext, name string) (*model.LLMResponse, error) {
+	reply := func(text string) (*model.LLMResponse, error) {
+		return &model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel)}, nil
+	}
+	if name == "" {
+		return reply(s.list(s.Active(ctx.State())))
+	}
+	if name == "default" {
+		name = s.def
+	} else if _, ok := s.byName[name]; !ok {
+		return reply(fmt.Sprintf("There is no persona %q.\n%s", name, s.list(s.Active(ctx.State()))))
+	}
+
+	if err := ctx.State().Set(StateKey, name); err != nil {
+		return nil, fmt.Errorf("failed to switch persona: %w", err)
+	}
+	s.logger.Info("Switched persona", "user", ctx.UserID(), "session", ctx.SessionID(), "persona", name)
+	if name == "" {
+		return reply("Switched back to the default behavior.")
+	}
+	return reply(fmt.Sprintf("Switched to persona %s.", name))
+}
+
+// list describes the personas, marking the active one
+func (s *Switcher) list(active string) string {
+	if len(s.personas) == 0 {
+		return "No personas are configured."
+	}
+	var b strings.Builder
+	b.WriteString("Personas:")
+	for _, p := range s.personas {
+		mark := " "
+		if p.Name == active {
+			mark = "*"
+		}
+		fmt.Fprintf(&b, "\n%s %s", mark, p.Name)
+		if p.Description != "" {
+			fmt.Fprintf(&b, ": %s", p.Description)
+		}
+	}
+	fmt.Fprintf(&b, "\nSwitch with %s <name>, or %s default.", Command, Command)
+	return b.String()
+}
+
+// command reports whether the user message of the turn is a persona
+// command, with the persona it names
+func command(ctx agent.ReadonlyContext) (string, bool) {
+	content := ctx.UserContent()
+	if content == nil {
+		return "", false
+	}
+	var text strings.Builder
+	for _, part := range content.Parts {
+		text.WriteString(part.Text)
+	}
+	fields := strings.Fields(text.String())
+	if len(fields) == 0 || fields[0] != Command || len(fields) > 2 {
+		return "", false
+	}
+	if len(fields) == 1 {
+		return "", true
+	}
+	return fields[1], true
+}
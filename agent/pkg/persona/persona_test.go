@@ -0,0 +1,126 @@
+package persona
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// systemModel replies with the system instruction it was given
+type systemModel struct {
+	calls int
+}
+
+func (*systemModel) Name() string { return "system" }
+
+func (m *systemModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.calls++
+		var system []string
+		if req.Config != nil && req.Config.SystemInstruction != nil {
+			for _, p := range req.Config.SystemInstruction.Parts {
+				system = append(system, p.Text)
+			}
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(strings.Join(system, "\n"), genai.RoleModel)}, nil)
+	}
+}
+
+// TestSwitcher tests switching persona within sessions
+func TestSwitcher(t *testing.T) {
+	s, err := New(&Config{
+		Personas: []Persona{
+			{Name: "support", Description: "Patient helpdesk", Instruction: "Answer as a patient helpdesk agent."},
+			{Name: "pirate", Instruction: "Talk like a pirate."},
+		},
+		Default: "support",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	llm := &systemModel{}
+	a, err := llmagent.New(llmagent.Config{
+		Name:                 "test",
+		Model:                llm,
+		Instruction:          "You are helpful.",
+		BeforeModelCallbacks: []llmagent.BeforeModelCallback{s.ModelCallback()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, _ := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions})
+	newSession := func(state map[string]any) string {
+		created, err := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "u", State: state})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created.Session.ID()
+	}
+	reply := func(id, text string) string {
+		var last string
+		for event, err := range r.Run(ctx, "u", id, genai.NewContentFromText(text, genai.RoleUser), agent.RunConfig{}) {
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if event.Content != nil && len(event.Content.Parts) > 0 && event.Content.Parts[0].Text != "" {
+				last = event.Content.Parts[0].Text
+			}
+		}
+		return last
+	}
+
+	first := newSession(nil)
+	if got := reply(first, "hi"); !strings.Contains(got, "You are helpful.") || !strings.Contains(got, "patient helpdesk") {
+		t.Errorf("default persona instruction = %q", got)
+	}
+	calls := llm.calls
+	if got := reply(first, "/persona pirate"); got != "Switched to persona pirate." || llm.calls != calls {
+		t.Errorf("switch = %q after %d model calls", got, llm.calls-calls)
+	}
+	if got := reply(first, "hi"); !strings.Contains(got, "pirate") || strings.Contains(got, "helpdesk") {
+		t.Errorf("switched persona instruction = %q", got)
+	}
+	if got := reply(first, "/persona"); !strings.Contains(got, "* pirate") || !strings.Contains(got, "  support: Patient helpdesk") {
+		t.Errorf("list = %q", got)
+	}
+	if got := reply(first, "/persona ninja"); !strings.HasPrefix(got, `There is no persona "ninja".`) {
+		t.Errorf("unknown persona = %q", got)
+	}
+
+	// Sessions choose their persona independently, also at creation
+	second := newSession(map[string]any{StateKey: "pirate"})
+	reply(second, "/persona default")
+	if got := reply(second, "hi"); !strings.Contains(got, "helpdesk") {
+		t.Errorf("persona after switching back = %q", got)
+	}
+	if got := reply(first, "hi"); !strings.Contains(got, "pirate") {
+		t.Errorf("persona of the first session = %q", got)
+	}
+}
+
+// TestNew tests rejecting invalid personas
+func TestNew(t *testing.T) {
+	for _, cfg := range []*Config{
+		nil,
+		{Personas: []Persona{{Name: ""}}},
+		{Personas: []Persona{{Name: "two words"}}},
+		{Personas: []Persona{{Name: "default"}}},
+		{Personas: []Persona{{Name: "a"}, {Name: "a"}}},
+		{Personas: []Persona{{Name: "a"}}, Default: "b"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package plan implements a dry-run mode for agent turns. In plan mode the
+// agent still runs read-only tools, but calls to every other tool are recorded
+// instead of executed. The recorded plan is returned to the user, who
+// approves it with a follow-up execute message.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+)
+
+const (
+	// StateKey is the session state key enabling plan mode when true
+	StateKey = "plan_mode"
+	// ExecuteCommand is the user message approving the pending plan
+	ExecuteCommand = "/execute"
+
+	pendingKey  = "plan_pending"       // Calls recorded while planning
+	approvedKey = "temp:plan_approved" // Calls approved in the current invocation
+)
+
+const planningNote = `Plan mode is on: tools with side effects are not executed. Calling them records the call in a plan for the user to approve; read-only tools run as usual.
+Work out the steps, call the tools you would use, then summarize the plan and tell the user to reply ` + ExecuteCommand + ` to carry it out.`
+
+// Call is a tool call recorded in a plan
+type Call struct {
+	Tool string         `json:"tool"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// Config holds the read-only tools that run in plan mode
+type Config struct {
+	ReadOnly []string       // Glob patterns of tool names without side effects
+	Audit    audit.Recorder // Optional, records plan approvals
+	Logger   *slog.Logger
+}
+
+// Planner records side-effectful tool calls while plan mode is on
+type Planner struct {
+	readOnly []string
+	audit    audit.Recorder
+	logger   *slog.Logger
+}
+
+// New creates a planner
+func New(cfg *Config) (*Planner, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	for _, pattern := range cfg.ReadOnly {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid read-only tool pattern %q: %w", pattern, err)
+		}
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("plan")
+	}
+	return &Planner{readOnly: cfg.ReadOnly, audit: cfg.Audit, logger: logger}, nil
+}
+
+// ReadOnly reports whether the named tool runs in plan mode
+func (p *Planner) ReadOnly(name string) bool {
+	for _, pattern := range p.readOnly {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolCallback returns a callback that records calls to side-effectful tools
+// while planning instead of running them. On an execute turn only the tools
+// of the approved plan run.
+func (p *Planner) ToolCallback() llmagent.BeforeToolCallback {
+	return func(ctx tool.Context, t tool.Tool, args map[string]any) (map[string]any, error) {
+		if !Enabled(ctx.State()) || p.ReadOnly(t.Name()) {
+			return nil, nil
+		}
+		if executing(ctx) {
+			for _, c := range calls(ctx.State(), approvedKey) {
+				if c.Tool == t.Name() {
+					return nil, nil
+				}
+			}
+			return map[string]any{"error": fmt.Sprintf("tool %s is not part of the approved plan", t.Name())}, nil
+		}
+
+		calls := append(Pending(ctx.State()), Call{Tool: t.Name(), Args: args})
+		if err := setCalls(ctx.State(), pendingKey, calls); err != nil {
+			return nil, fmt.Errorf("failed to record planned call: %w", err)
+		}
+		p.logger.Debug("Planned tool call", "tool", t.Name(), "step", len(calls))
+		return map[string]any{
+			"status": "planned",
+			"note":   fmt.Sprintf("Not executed: recorded as step %d of the plan, it runs once the user approves.", len(calls)),
+		}, nil
+	}
+}
+
+// ModelCallback returns a callback that tells the model about plan mode. On
+// an execute turn it hands the model the approved plan and clears it, or
+// answers directly when no plan is pending.
+func (p *Planner) ModelCallback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		state := ctx.State()
+		if !Enabled(state) {
+			return nil, nil
+		}
+		if !executing(ctx) {
+			instruction.AppendSystem(req, planningNote)
+			return nil, nil
+		}
+
+		approved := calls(state, approvedKey)
+		if approved == nil {
+			approved = Pending(state)
+			if len(approved) == 0 {
+				return &model.LLMResponse{
+					Content: genai.NewContentFromText("There is no plan waiting for approval.", genai.RoleModel),
+				}, nil
+			}
+			// The approval is kept for the rest of the turn, which may call the model again
+			if err := setCalls(state, approvedKey, approved); err != nil {
+				return nil, fmt.Errorf("failed to approve plan: %w", err)
+			}
+			if err := state.Set(pendingKey, nil); err != nil {
+				return nil, fmt.Errorf("failed to clear plan: %w", err)
+			}
+			p.logger.Info("Executing approved plan", "user", ctx.UserID(), "steps", len(approved))
+			p.record(ctx, approved)
+		}
+
+		data, err := json.Marshal(approved)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode plan: %w", err)
+		}
+		instruction.AppendSystem(req, fmt.Sprintf("The user approved the plan below. Carry it out now by making these tool calls, then report the results.\n%s", data))
+		return nil, nil
+	}
+}
+
+// record adds the approval of a plan to the audit log
+func (p *Planner) record(ctx agent.CallbackContext, approved []Call) {
+	if p.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		Actor:  ctx.UserID(),
+		Action: "plan.approve",
+		Target: ctx.SessionID(),
+		After:  approved,
+	}
+	if err := p.audit.Record(ctx, entry); err != nil {
+		p.logger.Error("Failed to record plan approval", "session", ctx.SessionID(), "error", err)
+	}
+}
+
+// Enabled reports whether plan mode is on in state
+func Enabled(state session.ReadonlyState) bool {
+	v, err := state.Get(StateKey)
+	on, _ := v.(bool)
+	return err == nil && on
+}
+
+// Pending returns the calls planned in state and not yet approved
+func Pending(state session.ReadonlyState) []Call {
+	return calls(state, pendingKey)
+}
+
+// executing reports whether the user message of the turn approves the plan
+func executing(ctx agent.ReadonlyContext) bool {
+	content := ctx.UserContent()
+	if content == nil {
+		return false
+	}
+	var text strings.Builder
+	for _, part := range content.Parts {
+		text.WriteString(part.Text)
+	}
+	return strings.TrimSpace(text.String()) == ExecuteCommand
+}
+
+// calls reads the calls stored under key. State values may have been through
+// JSON, so they are decoded rather than type asserted.
+func calls(state session.ReadonlyState, key string) []Call {
+	v, err := state.Get(key)
+	if err != nil || v == nil {
+		return nil
+	}
+	if c, ok := v.([]Call); ok {
+		return c
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var c []Call
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil
+	}
+	return c
+}
+
+// setCalls stores calls under key as plain JSON values
+func setCalls(state session.State, key string, c []Call) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	var v []any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return state.Set(key, v)
+}
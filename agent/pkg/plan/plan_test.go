@@ -0,0 +1,223 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/audit"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// scriptedModel calls lookup and then send_email, then reports the tool
+// results it saw. It records whether it was told about plan mode.
+type scriptedModel struct {
+	instructions []string
+}
+
+func (*scriptedModel) Name() string { return "scripted" }
+
+func (m *scriptedModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		var system []string
+		if req.Config != nil && req.Config.SystemInstruction != nil {
+			for _, p := range req.Config.SystemInstruction.Parts {
+				system = append(system, p.Text)
+			}
+		}
+		m.instructions = append(m.instructions, strings.Join(system, "\n"))
+
+		var results []string
+		for _, c := range req.Contents {
+			for _, p := range c.Parts {
+				if p.FunctionResponse != nil {
+					results = append(results, fmt.Sprintf("%s=%v", p.FunctionResponse.Name, p.FunctionResponse.Response))
+				}
+			}
+		}
+		last := req.Contents[len(req.Contents)-1].Parts[0]
+		if last.FunctionResponse == nil {
+			calls := []*genai.Part{
+				{FunctionCall: &genai.FunctionCall{Name: "lookup", Args: map[string]any{"name": "bob"}}},
+				{FunctionCall: &genai.FunctionCall{Name: "send_email", Args: map[string]any{"to": "bob", "body": "hi"}}},
+			}
+			yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: calls}}, nil)
+			return
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(strings.Join(results, "; "), genai.RoleModel)}, nil)
+	}
+}
+
+// recordingAudit collects audit entries
+type recordingAudit struct {
+	entries []audit.Entry
+}
+
+func (r *recordingAudit) Record(_ context.Context, e audit.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+type lookupArgs struct {
+	Name string `json:"name"`
+}
+
+type emailArgs struct {
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+// TestPlanMode tests planning a turn, then executing the approved plan
+func TestPlanMode(t *testing.T) {
+	recorder := &recordingAudit{}
+	planner, err := New(&Config{ReadOnly: []string{"look*"}, Audit: recorder})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var sent []string
+	lookup, _ := functiontool.New(functiontool.Config{Name: "lookup", Description: "Looks up a contact"},
+		func(_ tool.Context, args lookupArgs) (map[string]any, error) {
+			return map[string]any{"email": args.Name + "@example.com"}, nil
+		})
+	email, _ := functiontool.New(functiontool.Config{Name: "send_email", Description: "Sends an email"},
+		func(_ tool.Context, args emailArgs) (map[string]any, error) {
+			sent = append(sent, args.To)
+			return map[string]any{"sent": true}, nil
+		})
+
+	llm := &scriptedModel{}
+	a, err := llmagent.New(llmagent.Config{
+		Name:                 "test",
+		Model:                llm,
+		Tools:                []tool.Tool{lookup, email},
+		BeforeModelCallbacks: []llmagent.BeforeModelCallback{planner.ModelCallback()},
+		BeforeToolCallbacks:  []llmagent.BeforeToolCallback{planner.ToolCallback()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, _ := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions})
+	created, err := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "u", State: map[string]any{StateKey: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() session.Session {
+		resp, err := sessions.Get(ctx, &session.GetRequest{AppName: "test", UserID: "u", SessionID: created.Session.ID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Session
+	}
+	reply := func(text string) string {
+		var last string
+		for event, err := range r.Run(ctx, "u", created.Session.ID(), genai.NewContentFromText(text, genai.RoleUser), agent.RunConfig{}) {
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if event.Content != nil && len(event.Content.Parts) > 0 && event.Content.Parts[0].Text != "" {
+				last = event.Content.Parts[0].Text
+			}
+		}
+		return last
+	}
+
+	got := reply("email bob")
+	if len(sent) != 0 {
+		t.Fatalf("plan mode sent email to %v", sent)
+	}
+	if !strings.Contains(got, "lookup=map[email:bob@example.com]") || !strings.Contains(got, "status:planned") {
+		t.Errorf("planning reply = %q, want the lookup result and a planned email", got)
+	}
+	if !strings.Contains(llm.instructions[0], "Plan mode is on") {
+		t.Errorf("model was not told about plan mode: %q", llm.instructions[0])
+	}
+	pending := Pending(get().State())
+	if len(pending) != 1 || pending[0].Tool != "send_email" || pending[0].Args["to"] != "bob" {
+		t.Fatalf("Pending() = %+v, want the email call", pending)
+	}
+
+	llm.instructions = nil
+	got = reply(ExecuteCommand)
+	if len(sent) != 1 || sent[0] != "bob" {
+		t.Errorf("execute sent email to %v, want [bob]", sent)
+	}
+	if !strings.Contains(got, "send_email=map[sent:true]") {
+		t.Errorf("execute reply = %q", got)
+	}
+	for _, instruction := range llm.instructions {
+		if !strings.Contains(instruction, `"tool":"send_email"`) {
+			t.Errorf("model call of the execute turn was not given the plan: %q", instruction)
+		}
+	}
+	if pending := Pending(get().State()); len(pending) != 0 {
+		t.Errorf("Pending() after execute = %+v, want none", pending)
+	}
+	if len(recorder.entries) != 1 {
+		t.Fatalf("audit entries = %+v, want the approval", recorder.entries)
+	}
+	approval := recorder.entries[0]
+	approved, _ := approval.After.([]Call)
+	if approval.Actor != "u" || approval.Action != "plan.approve" || approval.Target != created.Session.ID() || len(approved) != 1 || approved[0].Tool != "send_email" {
+		t.Errorf("audit entry = %+v", approval)
+	}
+
+	if got := reply(ExecuteCommand); got != "There is no plan waiting for approval." {
+		t.Errorf("execute without a plan = %q", got)
+	}
+}
+
+// TestDisabled tests that tools run normally without plan mode
+func TestDisabled(t *testing.T) {
+	planner, err := New(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := map[string]any{}
+	if Enabled(mapState(state)) {
+		t.Error("Enabled() = true for empty state")
+	}
+	state[StateKey] = true
+	if !Enabled(mapState(state)) {
+		t.Error("Enabled() = false with plan mode on")
+	}
+	if planner.ReadOnly("lookup") {
+		t.Error("ReadOnly() = true without read-only tools")
+	}
+	if _, err := New(&Config{ReadOnly: []string{"["}}); err == nil {
+		t.Error("New() accepted an invalid pattern")
+	}
+}
+
+// mapState is a read-only state backed by a map
+type mapState map[string]any
+
+func (s mapState) Get(key string) (any, error) {
+	v, ok := s[key]
+	if !ok {
+		return nil, session.ErrStateKeyNotExist
+	}
+	return v, nil
+}
+
+func (s mapState) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		for k, v := range s {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
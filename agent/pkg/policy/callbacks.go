@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"strings"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+)
+
+// ToolCallback returns a before-tool callback enforcing the tool rules. A
+// denied call is not run and the model gets the reason as the tool's error.
+func (e *Engine) ToolCallback() llmagent.BeforeToolCallback {
+	return func(ctx tool.Context, t tool.Tool, args map[string]any) (map[string]any, error) {
+		d := e.Evaluate(Input{
+			Scope:   ScopeTool,
+			User:    ctx.UserID(),
+			Tool:    t.Name(),
+			Args:    args,
+			Text:    contentText(ctx.UserContent()),
+			Agent:   ctx.AgentName(),
+			Session: ctx.SessionID(),
+		})
+		if !d.Allowed() {
+			return map[string]any{
+				"error": cmp.Or(d.Message, fmt.Sprintf("tool %s is not permitted by policy", t.Name())),
+			}, nil
+		}
+		// The flow passes the same map on to the tool, so overrides apply in place
+		if args != nil {
+			maps.Copy(args, d.Args)
+		}
+		return nil, nil
+	}
+}
+
+// ModelCallback returns a before-model callback enforcing the request rules.
+// A denied request is answered with the reason instead of calling the model.
+func (e *Engine) ModelCallback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, _ *model.LLMRequest) (*model.LLMResponse, error) {
+		d := e.Evaluate(Input{
+			Scope:   ScopeRequest,
+			User:    ctx.UserID(),
+			Text:    contentText(ctx.UserContent()),
+			Agent:   ctx.AgentName(),
+			Session: ctx.SessionID(),
+		})
+		if d.Allowed() {
+			return nil, nil
+		}
+		return &model.LLMResponse{
+			Content:      genai.NewContentFromText(cmp.Or(d.Message, "This request is not permitted by policy."), genai.RoleModel),
+			TurnComplete: true,
+		}, nil
+	}
+}
+
+// contentText joins the text parts of content
+func contentText(content *genai.Content) string {
+	if content == nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range content.Parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,1237 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Conditions are written in a subset of CEL, the Common Expression Language
+// (https://cel.dev), evaluated over the variables user, tool, args, time and
+// request. The subset covers:
+//
+//   - literals: ints, doubles, strings (r'...' is raw), true, false, null,
+//     lists [a, b] and maps {"k": v}
+//   - field and index access: args.path, args["path"], request.text, list[0]
+//   - operators: ! - * / % + < <= > >= == != in && || and c ? a : b
+//   - has(args.path), size(x), int(x), double(x), string(x), timestamp(s)
+//   - string methods: startsWith, endsWith, contains, matches, lowerAscii,
+//     upperAscii and size
+//   - list macros: exists, all, filter and map, e.g. args.tags.exists(t, t == "x")
+//   - timestamp methods: getFullYear, getMonth (0-11), getDate (1-31),
+//     getDayOfWeek (0 is Sunday), getHours and getMinutes, each taking an
+//     optional IANA timezone, plus comparisons between timestamps
+//
+// Unlike CEL, ints and doubles mix freely in arithmetic, since JSON tool
+// arguments are always doubles.
+
+// exprVars are the variables of a condition
+var exprVars = []string{"user", "tool", "args", "time", "request"}
+
+// expr is a compiled condition
+type expr struct {
+	src  string
+	root node
+}
+
+// compileExpr parses src
+func compileExpr(src string) (*expr, error) {
+	p := &parser{lex: lexer{src: src}, scope: slices.Clone(exprVars)}
+	p.next()
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &expr{src: src, root: root}, nil
+}
+
+// match evaluates the condition over vars, which must yield a bool
+func (e *expr) match(vars map[string]any) (bool, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition returned %s, want bool", typeName(v))
+	}
+	return b, nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokDouble
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string // Punctuation or identifier
+	val  any    // Literal value
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+// twoCharOps are the operators of two characters
+var twoCharOps = []string{"<=", ">=", "==", "!=", "&&", "||"}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case (c == 'r' || c == 'R') && l.pos+1 < len(l.src) && (l.src[l.pos+1] == '\'' || l.src[l.pos+1] == '"'):
+		l.pos++
+		s, err := l.quoted(true)
+		return token{kind: tokString, val: s, pos: start}, err
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || unicode.IsLetter(rune(l.src[l.pos])) || unicode.IsDigit(rune(l.src[l.pos]))) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	case c >= '0' && c <= '9' || c == '.' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9':
+		return l.number()
+	case c == '\'' || c == '"':
+		s, err := l.quoted(false)
+		return token{kind: tokString, val: s, pos: start}, err
+	}
+
+	for _, op := range twoCharOps {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += 2
+			return token{kind: tokPunct, text: op, pos: start}, nil
+		}
+	}
+	if strings.IndexByte("()[]{}.,:?!-+*/%<>", c) >= 0 {
+		l.pos++
+		return token{kind: tokPunct, text: string(c), pos: start}, nil
+	}
+	return token{}, fmt.Errorf("unexpected %q at %d", c, start)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	double := false
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' || c == 'e' || c == 'E':
+			double = true
+		case (c == '+' || c == '-') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E'):
+		default:
+			goto done
+		}
+		l.pos++
+	}
+done:
+	text := l.src[start:l.pos]
+	if double {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, fmt.Errorf("invalid number %q at %d", text, start)
+		}
+		return token{kind: tokDouble, val: f, pos: start}, nil
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return token{}, fmt.Errorf("invalid number %q at %d", text, start)
+	}
+	return token{kind: tokInt, val: n, pos: start}, nil
+}
+
+// quoted reads a string literal, interpreting escapes unless raw
+func (l *lexer) quoted(raw bool) (string, error) {
+	start := l.pos
+	quote := l.src[l.pos]
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", fmt.Errorf("unterminated string at %d", start)
+		case c == '\\' && !raw:
+			if l.pos+1 >= len(l.src) {
+				return "", fmt.Errorf("unterminated string at %d", start)
+			}
+			r, n, err := unescape(l.src[l.pos+1:])
+			if err != nil {
+				return "", fmt.Errorf("%w at %d", err, l.pos)
+			}
+			b.WriteRune(r)
+			l.pos += 1 + n
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated string at %d", start)
+}
+
+// unescape decodes the escape sequence at the start of s, after the backslash
+func unescape(s string) (rune, int, error) {
+	switch s[0] {
+	case 'n':
+		return '\n', 1, nil
+	case 't':
+		return '\t', 1, nil
+	case 'r':
+		return '\r', 1, nil
+	case '\\', '\'', '"', '`', '?':
+		return rune(s[0]), 1, nil
+	case 'x', 'u', 'U':
+		n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[0]]
+		if len(s) > n {
+			if r, err := strconv.ParseUint(s[1:1+n], 16, 32); err == nil && utf8.ValidRune(rune(r)) {
+				return rune(r), 1 + n, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid escape \\%c", s[0])
+}
+
+// Parser
+
+type parser struct {
+	lex   lexer
+	tok   token
+	err   error
+	scope []string // Variables in scope, innermost last
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) is(punct string) bool {
+	return p.err == nil && p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) accept(punct string) bool {
+	if p.is(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) {
+	if !p.accept(punct) {
+		p.fail("expected %q", punct)
+	}
+}
+
+func (p *parser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%s at %d", fmt.Sprintf(format, args...), p.tok.pos)
+	}
+}
+
+func (p *parser) parse() (node, error) {
+	n := p.ternary()
+	if p.err == nil && p.tok.kind != tokEOF {
+		p.fail("unexpected %s", p.describe())
+	}
+	return n, p.err
+}
+
+func (p *parser) describe() string {
+	switch p.tok.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokIdent, tokPunct:
+		return strconv.Quote(p.tok.text)
+	default:
+		return fmt.Sprintf("%v", p.tok.val)
+	}
+}
+
+func (p *parser) ternary() node {
+	c := p.binary(0)
+	if !p.accept("?") {
+		return c
+	}
+	t := p.ternary()
+	p.expect(":")
+	f := p.ternary()
+	return &condNode{c, t, f}
+}
+
+// precedence lists the binary operators from the loosest
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"<", "<=", ">", ">=", "==", "!=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) node {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	x := p.binary(level + 1)
+	for p.err == nil {
+		op := p.tok.text
+		if (p.tok.kind != tokPunct && !(p.tok.kind == tokIdent && op == "in")) || !slices.Contains(precedence[level], op) {
+			return x
+		}
+		p.next()
+		y := p.binary(level + 1)
+		x = &binaryNode{op, x, y}
+	}
+	return x
+}
+
+func (p *parser) unary() node {
+	switch {
+	case p.accept("!"):
+		return &unaryNode{"!", p.unary()}
+	case p.accept("-"):
+		return &unaryNode{"-", p.unary()}
+	}
+	return p.member()
+}
+
+func (p *parser) member() node {
+	x := p.primary()
+	for p.err == nil {
+		switch {
+		case p.accept("."):
+			if p.tok.kind != tokIdent {
+				p.fail("expected a field name")
+				return x
+			}
+			name := p.tok.text
+			p.next()
+			if p.is("(") {
+				x = p.method(x, name)
+			} else {
+				x = &selectNode{operand: x, field: name}
+			}
+		case p.accept("["):
+			i := p.ternary()
+			p.expect("]")
+			x = &indexNode{x, i}
+		default:
+			return x
+		}
+	}
+	return x
+}
+
+// macros are the list methods binding a variable
+var macros = []string{"exists", "all", "filter", "map"}
+
+func (p *parser) method(target node, name string) node {
+	if slices.Contains(macros, name) {
+		p.expect("(")
+		if p.tok.kind != tokIdent {
+			p.fail("%s needs a variable name", name)
+			return nil
+		}
+		v := p.tok.text
+		p.next()
+		p.expect(",")
+		p.scope = append(p.scope, v)
+		body := p.ternary()
+		p.scope = p.scope[:len(p.scope)-1]
+		p.expect(")")
+		return &macroNode{macro: name, target: target, v: v, body: body}
+	}
+	return p.call(target, name)
+}
+
+func (p *parser) call(target node, name string) node {
+	p.expect("(")
+	var args []node
+	for p.err == nil && !p.is(")") {
+		args = append(args, p.ternary())
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(")")
+	if p.err != nil {
+		return nil
+	}
+	c := &callNode{fn: name, target: target, args: args}
+	if err := c.check(); err != nil {
+		p.fail("%v", err)
+	}
+	return c
+}
+
+func (p *parser) primary() node {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt, tokDouble, tokString:
+		p.next()
+		return &literalNode{tok.val}
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true", "false":
+			return &literalNode{tok.text == "true"}
+		case "null":
+			return &literalNode{nil}
+		case "has":
+			p.expect("(")
+			x := p.member()
+			p.expect(")")
+			sel, ok := x.(*selectNode)
+			if !ok && p.err == nil {
+				p.fail("has() needs a field selection like args.path")
+				return nil
+			}
+			return &hasNode{sel}
+		}
+		if p.is("(") {
+			return p.call(nil, tok.text)
+		}
+		if !slices.Contains(p.scope, tok.text) {
+			p.err = fmt.Errorf("undeclared variable %q at %d (want one of %s)", tok.text, tok.pos, strings.Join(exprVars, ", "))
+			return nil
+		}
+		return &identNode{tok.text}
+	case tokPunct:
+		switch {
+		case p.accept("("):
+			x := p.ternary()
+			p.expect(")")
+			return x
+		case p.accept("["):
+			l := &listNode{}
+			for p.err == nil && !p.is("]") {
+				l.elems = append(l.elems, p.ternary())
+				if !p.accept(",") {
+					break
+				}
+			}
+			p.expect("]")
+			return l
+		case p.accept("{"):
+			m := &mapNode{}
+			for p.err == nil && !p.is("}") {
+				m.keys = append(m.keys, p.ternary())
+				p.expect(":")
+				m.values = append(m.values, p.ternary())
+				if !p.accept(",") {
+					break
+				}
+			}
+			p.expect("}")
+			return m
+		}
+	}
+	p.fail("unexpected %s", p.describe())
+	return nil
+}
+
+// Evaluation
+
+// node is a parsed expression, evaluated over variables. Values are nil,
+// bool, int64, float64, string, time.Time, []any and map[string]any.
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literalNode struct{ v any }
+
+func (n *literalNode) eval(map[string]any) (any, error) { return n.v, nil }
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(vars map[string]any) (any, error) {
+	return value(vars[n.name]), nil
+}
+
+type selectNode struct {
+	operand node
+	field   string
+}
+
+func (n *selectNode) eval(vars map[string]any) (any, error) {
+	x, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := x.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot select field %q of %s", n.field, typeName(x))
+	}
+	v, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key %q", n.field)
+	}
+	return value(v), nil
+}
+
+type hasNode struct{ sel *selectNode }
+
+func (n *hasNode) eval(vars map[string]any) (any, error) {
+	x, err := n.sel.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := x.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("has() cannot test a field of %s", typeName(x))
+	}
+	_, ok = m[n.sel.field]
+	return ok, nil
+}
+
+type indexNode struct{ operand, index node }
+
+func (n *indexNode) eval(vars map[string]any) (any, error) {
+	x, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case []any:
+		idx, ok := toInt(i)
+		if !ok {
+			return nil, fmt.Errorf("cannot index a list with %s", typeName(i))
+		}
+		if idx < 0 || idx >= int64(len(x)) {
+			return nil, fmt.Errorf("index %d out of range", idx)
+		}
+		return value(x[idx]), nil
+	case map[string]any:
+		key, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot index a map with %s", typeName(i))
+		}
+		v, ok := x[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key %q", key)
+		}
+		return value(v), nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(x))
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case bool:
+		if n.op == "!" {
+			return !x, nil
+		}
+	case int64:
+		if n.op == "-" {
+			if x == math.MinInt64 {
+				return nil, fmt.Errorf("int overflow")
+			}
+			return -x, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	}
+	return nil, fmt.Errorf("no operator %s for %s", n.op, typeName(x))
+}
+
+type condNode struct{ c, t, f node }
+
+func (n *condNode) eval(vars map[string]any) (any, error) {
+	c, err := n.c.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition of ?: is %s, want bool", typeName(c))
+	}
+	if b {
+		return n.t.eval(vars)
+	}
+	return n.f.eval(vars)
+}
+
+type binaryNode struct {
+	op   string
+	x, y node
+}
+
+func (n *binaryNode) eval(vars map[string]any) (any, error) {
+	if n.op == "&&" || n.op == "||" {
+		return n.logical(vars)
+	}
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(x, y), nil
+	case "!=":
+		return !equal(x, y), nil
+	case "in":
+		switch y := y.(type) {
+		case []any:
+			return slices.ContainsFunc(y, func(e any) bool { return equal(x, value(e)) }), nil
+		case map[string]any:
+			key, ok := x.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = y[key]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("no operator in for %s", typeName(y))
+	case "<", "<=", ">", ">=":
+		c, err := compare(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	return arithmetic(n.op, x, y)
+}
+
+// logical evaluates && and || as CEL does: an error on one side is absorbed
+// when the other side decides the result
+func (n *binaryNode) logical(vars map[string]any) (any, error) {
+	decisive := n.op == "||"
+	x, errX := n.x.eval(vars)
+	if errX == nil {
+		b, ok := x.(bool)
+		if !ok {
+			errX = fmt.Errorf("no operator %s for %s", n.op, typeName(x))
+		} else if b == decisive {
+			return b, nil
+		}
+	}
+	y, errY := n.y.eval(vars)
+	if errY == nil {
+		b, ok := y.(bool)
+		if !ok {
+			errY = fmt.Errorf("no operator %s for %s", n.op, typeName(y))
+		} else if b == decisive || errX == nil {
+			return b, nil
+		}
+	}
+	if errX != nil {
+		return nil, errX
+	}
+	return nil, errY
+}
+
+type listNode struct{ elems []node }
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+	l := make([]any, len(n.elems))
+	for i, e := range n.elems {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = v
+	}
+	return l, nil
+}
+
+type mapNode struct{ keys, values []node }
+
+func (n *mapNode) eval(vars map[string]any) (any, error) {
+	m := make(map[string]any, len(n.keys))
+	for i := range n.keys {
+		k, err := n.keys[i].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys must be strings, got %s", typeName(k))
+		}
+		if m[key], err = n.values[i].eval(vars); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+type macroNode struct {
+	macro  string
+	target node
+	v      string
+	body   node
+}
+
+func (n *macroNode) eval(vars map[string]any) (any, error) {
+	x, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := x.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s() needs a list, got %s", n.macro, typeName(x))
+	}
+
+	scope := make(map[string]any, len(vars)+1)
+	for k, v := range vars {
+		scope[k] = v
+	}
+	var out []any
+	for _, e := range list {
+		scope[n.v] = e
+		r, err := n.body.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		if n.macro == "map" {
+			out = append(out, r)
+			continue
+		}
+		b, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s() predicate returned %s, want bool", n.macro, typeName(r))
+		}
+		switch {
+		case n.macro == "exists" && b:
+			return true, nil
+		case n.macro == "all" && !b:
+			return false, nil
+		case n.macro == "filter" && b:
+			out = append(out, value(e))
+		}
+	}
+	switch n.macro {
+	case "exists":
+		return false, nil
+	case "all":
+		return true, nil
+	}
+	if out == nil {
+		out = []any{}
+	}
+	return out, nil
+}
+
+// functions maps function names to their arity, counting the target of
+// methods. size is both a function and a method.
+var functions = map[string]struct {
+	min, max int
+	method   bool
+}{
+	"size":         {1, 1, false},
+	"int":          {1, 1, false},
+	"double":       {1, 1, false},
+	"string":       {1, 1, false},
+	"timestamp":    {1, 1, false},
+	"startsWith":   {2, 2, true},
+	"endsWith":     {2, 2, true},
+	"contains":     {2, 2, true},
+	"matches":      {2, 2, true},
+	"lowerAscii":   {1, 1, true},
+	"upperAscii":   {1, 1, true},
+	"getFullYear":  {1, 2, true},
+	"getMonth":     {1, 2, true},
+	"getDate":      {1, 2, true},
+	"getDayOfWeek": {1, 2, true},
+	"getHours":     {1, 2, true},
+	"getMinutes":   {1, 2, true},
+}
+
+type callNode struct {
+	fn     string
+	target node
+	args   []node
+	re     *regexp.Regexp // Pattern of matches() when it is a literal
+}
+
+// check validates the function and its arity at compile time
+func (n *callNode) check() error {
+	f, ok := functions[n.fn]
+	if !ok {
+		return fmt.Errorf("unknown function %q", n.fn)
+	}
+	argc := len(n.args)
+	switch {
+	case n.target != nil:
+		argc++
+		if !f.method && n.fn != "size" {
+			return fmt.Errorf("%s is a function, call it as %s(x)", n.fn, n.fn)
+		}
+	case f.method:
+		return fmt.Errorf("%s is a method, call it as x.%s()", n.fn, n.fn)
+	}
+	if argc < f.min || argc > f.max {
+		return fmt.Errorf("wrong number of arguments to %s", n.fn)
+	}
+	if n.fn == "matches" {
+		if lit, ok := n.args[len(n.args)-1].(*literalNode); ok {
+			pattern, ok := lit.v.(string)
+			if !ok {
+				return fmt.Errorf("matches() needs a string pattern")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern: %w", err)
+			}
+			n.re = re
+		}
+	}
+	return nil
+}
+
+func (n *callNode) eval(vars map[string]any) (any, error) {
+	var args []any
+	if n.target != nil {
+		t, err := n.target.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t)
+	}
+	for _, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch n.fn {
+	case "size":
+		switch x := args[0].(type) {
+		case string:
+			return int64(utf8.RuneCountInString(x)), nil
+		case []any:
+			return int64(len(x)), nil
+		case map[string]any:
+			return int64(len(x)), nil
+		}
+	case "int":
+		switch x := args[0].(type) {
+		case int64:
+			return x, nil
+		case float64:
+			if x >= -(1<<63) && x < 1<<63 {
+				return int64(x), nil
+			}
+			return nil, fmt.Errorf("int overflow")
+		case string:
+			return strconv.ParseInt(x, 10, 64)
+		case time.Time:
+			return x.Unix(), nil
+		}
+	case "double":
+		switch x := args[0].(type) {
+		case int64:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		case string:
+			return strconv.ParseFloat(x, 64)
+		}
+	case "string":
+		switch x := args[0].(type) {
+		case string:
+			return x, nil
+		case int64, bool:
+			return fmt.Sprint(x), nil
+		case float64:
+			return strconv.FormatFloat(x, 'g', -1, 64), nil
+		case time.Time:
+			return x.UTC().Format(time.RFC3339Nano), nil
+		}
+	case "timestamp":
+		if s, ok := args[0].(string); ok {
+			return time.Parse(time.RFC3339, s)
+		}
+	case "startsWith", "endsWith", "contains", "matches":
+		s, ok1 := args[0].(string)
+		sub, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			break
+		}
+		switch n.fn {
+		case "startsWith":
+			return strings.HasPrefix(s, sub), nil
+		case "endsWith":
+			return strings.HasSuffix(s, sub), nil
+		case "contains":
+			return strings.Contains(s, sub), nil
+		}
+		re := n.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(sub); err != nil {
+				return nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+		}
+		return re.MatchString(s), nil
+	case "lowerAscii", "upperAscii":
+		if s, ok := args[0].(string); ok {
+			return mapASCII(s, n.fn == "upperAscii"), nil
+		}
+	default:
+		return timeField(n.fn, args)
+	}
+	return nil, fmt.Errorf("no function %s for %s", n.fn, typeNames(args))
+}
+
+// mapASCII changes the case of ASCII letters only, as CEL's string extension does
+func mapASCII(s string, upper bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case upper && r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case !upper && r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return r
+	}, s)
+}
+
+// timeField returns a calendar field of a timestamp, in the timezone of the
+// optional second argument or UTC
+func timeField(fn string, args []any) (any, error) {
+	t, ok := args[0].(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("no function %s for %s", fn, typeNames(args))
+	}
+	loc := time.UTC
+	if len(args) == 2 {
+		name, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a timezone name", fn)
+		}
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	t = t.In(loc)
+	switch fn {
+	case "getFullYear":
+		return int64(t.Year()), nil
+	case "getMonth":
+		return int64(t.Month()) - 1, nil
+	case "getDate":
+		return int64(t.Day()), nil
+	case "getDayOfWeek":
+		return int64(t.Weekday()), nil
+	case "getHours":
+		return int64(t.Hour()), nil
+	}
+	return int64(t.Minute()), nil
+}
+
+// value converts Go values to the types of expressions
+func value(v any) any {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return float64(v)
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		l := make([]any, len(v))
+		for i, s := range v {
+			l[i] = s
+		}
+		return l
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return m
+	}
+	return v
+}
+
+// toInt returns v as an int when it is a whole number
+func toInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// toDouble returns v as a double when it is a number
+func toDouble(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal compares values, numbers across int and double
+func equal(x, y any) bool {
+	if a, ok := toDouble(x); ok {
+		b, ok := toDouble(y)
+		if !ok {
+			return false
+		}
+		if ai, ok := x.(int64); ok {
+			if bi, ok := y.(int64); ok {
+				return ai == bi
+			}
+		}
+		return a == b
+	}
+	switch x := x.(type) {
+	case []any:
+		y, ok := y.([]any)
+		return ok && slices.EqualFunc(x, y, func(a, b any) bool { return equal(value(a), value(b)) })
+	case map[string]any:
+		y, ok := y.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, a := range x {
+			b, ok := y[k]
+			if !ok || !equal(value(a), value(b)) {
+				return false
+			}
+		}
+		return true
+	case time.Time:
+		y, ok := y.(time.Time)
+		return ok && x.Equal(y)
+	case nil, bool, string:
+		return x == y
+	}
+	return false
+}
+
+// compare orders numbers, strings, bools and timestamps
+func compare(x, y any) (int, error) {
+	if xi, ok := x.(int64); ok {
+		if yi, ok := y.(int64); ok {
+			return cmpOrdered(xi, yi), nil
+		}
+	}
+	if a, ok := toDouble(x); ok {
+		if b, ok := toDouble(y); ok {
+			return cmpOrdered(a, b), nil
+		}
+	}
+	switch x := x.(type) {
+	case string:
+		if y, ok := y.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			return cmpOrdered(boolInt(x), boolInt(y)), nil
+		}
+	case time.Time:
+		if y, ok := y.(time.Time); ok {
+			return x.Compare(y), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", typeName(x), typeName(y))
+}
+
+func cmpOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// arithmetic applies + - * / %. Ints stay ints, checking overflow, and a
+// double on either side makes a double.
+func arithmetic(op string, x, y any) (any, error) {
+	if a, ok := x.(int64); ok {
+		if b, ok := y.(int64); ok {
+			return intArithmetic(op, a, b)
+		}
+	}
+	if a, ok := toDouble(x); ok {
+		if b, ok := toDouble(y); ok {
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			case "*":
+				return a * b, nil
+			case "/":
+				return a / b, nil
+			}
+			return nil, fmt.Errorf("no operator %% for double")
+		}
+	}
+	if op == "+" {
+		switch x := x.(type) {
+		case string:
+			if y, ok := y.(string); ok {
+				return x + y, nil
+			}
+		case []any:
+			if y, ok := y.([]any); ok {
+				return slices.Concat(x, y), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no operator %s for %s and %s", op, typeName(x), typeName(y))
+}
+
+func intArithmetic(op string, a, b int64) (any, error) {
+	var r int64
+	switch op {
+	case "+":
+		r = a + b
+		if (r > a) != (b > 0) {
+			return nil, fmt.Errorf("int overflow")
+		}
+	case "-":
+		r = a - b
+		if (r < a) != (b > 0) {
+			return nil, fmt.Errorf("int overflow")
+		}
+	case "*":
+		if a != 0 && b != 0 {
+			r = a * b
+			if r/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+				return nil, fmt.Errorf("int overflow")
+			}
+		}
+	case "/", "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if a == math.MinInt64 && b == -1 {
+			return nil, fmt.Errorf("int overflow")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+	return r, nil
+}
+
+// typeName names the expression type of v for errors
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case time.Time:
+		return "timestamp"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func typeNames(args []any) string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = typeName(a)
+	}
+	return strings.Join(names, ", ")
+}
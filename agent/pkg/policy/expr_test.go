@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExpr tests evaluating conditions
+func TestExpr(t *testing.T) {
+	vars := map[string]any{
+		"user": "alice",
+		"tool": "write_file",
+		"args": map[string]any{
+			"path":  "/home/alice/notes.md",
+			"owner": "alice",
+			"size":  float64(60),
+			"tags":  []any{"draft", "work"},
+			"opts":  map[string]any{"force": true},
+		},
+		"time":    time.Date(2026, 10, 17, 23, 30, 0, 0, time.UTC), // Saturday
+		"request": map[string]any{"text": "Save my notes", "agent": "writer", "session": "s1"},
+	}
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`args.owner == user`, true},
+		{`args.size * 2 > 100`, true},
+		{`args.size + 1 == 61`, true},
+		{`args.size / 7 < 9`, true},
+		{`7 % 3 == 1 && -2 < 0`, true},
+		{`args.path.startsWith("/home/" + user + "/")`, true},
+		{`args.path.endsWith('.md') && !args.path.contains("..")`, true},
+		{`args.path.matches(r'^/home/\w+/')`, true},
+		{`"draft" in args.tags && "force" in args.opts`, true},
+		{`args.tags.exists(t, t == "work")`, true},
+		{`args.tags.all(t, size(t) > 4)`, false},
+		{`args.tags.filter(t, t.startsWith("d")) == ["draft"]`, true},
+		{`args.tags.map(t, t.upperAscii()) == ["DRAFT", "WORK"]`, true},
+		{`size(args.tags) == 2 && args.tags.size() == 2 && args.tags[1] == "work"`, true},
+		{`args.opts.force`, true},
+		{`args["opts"]["force"] == true`, true},
+		{`has(args.mode) ? args.mode == "append" : true`, true},
+		{`has(args.owner) && !has(args.mode)`, true},
+		{`tool in ["read_file", "write_file"]`, true},
+		{`{"a": 1}.a == 1.0`, true},
+		{`time.getDayOfWeek() == 6 && time.getHours() >= 23`, true},
+		{`time.getDayOfWeek("Asia/Shanghai") == 0 && time.getHours("Asia/Shanghai") == 7`, true},
+		{`time.getFullYear() == 2026 && time.getMonth() == 9 && time.getDate() == 17 && time.getMinutes() == 30`, true},
+		{`time > timestamp("2026-10-01T00:00:00Z")`, true},
+		{`request.text.lowerAscii().contains("save") && request.agent == "writer"`, true},
+		{`int("3") + 1 == 4 && double(1) == 1 && string(2) == "2"`, true},
+		{`args.missing == 1 || user == "alice"`, true},
+		{`args.missing == 1 && false`, false},
+		{`null == null && 'a\n' != "a"`, true},
+	}
+	for _, tt := range tests {
+		e, err := compileExpr(tt.src)
+		if err != nil {
+			t.Errorf("compileExpr(%s) error = %v", tt.src, err)
+			continue
+		}
+		got, err := e.match(vars)
+		if err != nil || got != tt.want {
+			t.Errorf("match(%s) = %v, %v, want %v", tt.src, got, err, tt.want)
+		}
+	}
+
+	for _, src := range []string{
+		`args.missing == 1`,
+		`args.size`,
+		`args.tags[5] == "x"`,
+		`user > 1`,
+		`1 / 0 == 0`,
+		`9223372036854775807 + 1 > 0`,
+		`user.matches(args.path + "(")`,
+	} {
+		e, err := compileExpr(src)
+		if err != nil {
+			t.Errorf("compileExpr(%s) error = %v", src, err)
+			continue
+		}
+		if _, err := e.match(vars); err == nil {
+			t.Errorf("match(%s) succeeded, want an error", src)
+		}
+	}
+}
+
+// TestCompileExprInvalid tests rejecting malformed conditions
+func TestCompileExprInvalid(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{``, "unexpected end of expression"},
+		{`user ==`, "unexpected end of expression"},
+		{`usr == "alice"`, `undeclared variable "usr"`},
+		{`args.tags.exists(t, x == 1)`, `undeclared variable "x"`},
+		{`upper(user)`, `unknown function "upper"`},
+		{`startsWith(user, "a")`, "is a method"},
+		{`user.startsWith()`, "wrong number of arguments"},
+		{`user.matches("(")`, "invalid pattern"},
+		{`has(user)`, "has() needs a field selection"},
+		{`"abc`, "unterminated string"},
+		{`user == "a" user`, `unexpected "user"`},
+		{`user # 1`, "unexpected '#'"},
+	}
+	for _, tt := range tests {
+		_, err := compileExpr(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("compileExpr(%s) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
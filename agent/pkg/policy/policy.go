@@ -0,0 +1,358 @@
+// Package policy authorizes requests and tool calls against declarative
+// rules: CEL conditions over the user, tool, arguments, time and request,
+// and shorthand patterns for the common cases.
+package policy
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Effect is what a matching rule does
+type Effect string
+
+const (
+	// EffectAllow permits the call and stops evaluation
+	EffectAllow Effect = "allow"
+	// EffectDeny rejects the call and stops evaluation
+	EffectDeny Effect = "deny"
+	// EffectModify overrides tool arguments and continues evaluation
+	EffectModify Effect = "modify"
+)
+
+// Scopes a rule applies to
+const (
+	ScopeTool    = "tool"
+	ScopeRequest = "request"
+)
+
+// Rule matches calls by every condition that is set. Empty conditions match anything.
+type Rule struct {
+	Name   string
+	On     string // ScopeTool (default) or ScopeRequest
+	Effect Effect
+
+	Users []string          // Glob patterns of user IDs
+	Tools []string          // Glob patterns of tool names, tool rules only
+	Args  map[string]string // Regular expressions matched against argument values, tool rules only
+	Text  string            // Regular expression matched against the user message, request rules only
+
+	Hours    string   // Time window like "09:00-18:00", may wrap midnight
+	Days     []string // Weekdays like "mon", "sat"
+	Timezone string   // IANA name for Hours and Days, defaults to local time
+
+	// When is a CEL condition over user, tool, args, time and request (text,
+	// agent and session), e.g. `args.owner == user || args.size * 2 > 100`
+	When string
+
+	Set     map[string]any // Argument overrides of modify rules
+	Message string         // Reason returned when denied
+}
+
+// Config holds the rules, evaluated in order
+type Config struct {
+	Rules   []Rule
+	Default Effect // Decides tool calls no rule decides, EffectAllow (default) or EffectDeny
+	Logger  *slog.Logger
+}
+
+// Input describes the call being authorized
+type Input struct {
+	Scope string
+	User  string
+	Tool  string
+	Args  map[string]any
+	Text  string // User message of the turn
+	Time  time.Time
+
+	Agent   string
+	Session string
+}
+
+// Decision is the outcome of an evaluation
+type Decision struct {
+	Effect  Effect         // EffectAllow or EffectDeny
+	Rule    string         // Rule that decided, empty for the default
+	Message string         // Reason of a denial
+	Args    map[string]any // Arguments after modify rules, tool calls only
+}
+
+// Allowed reports whether the call may proceed
+func (d Decision) Allowed() bool {
+	return d.Effect == EffectAllow
+}
+
+// rule is a Rule with its patterns compiled
+type rule struct {
+	Rule
+	args     map[string]*regexp.Regexp
+	text     *regexp.Regexp
+	from, to int // Minutes since midnight, from == to disables the window
+	days     map[time.Weekday]bool
+	loc      *time.Location
+	when     *expr
+}
+
+// Engine evaluates rules
+type Engine struct {
+	rules  []rule
+	def    Effect
+	logger *slog.Logger
+}
+
+// New compiles the rules of cfg
+func New(cfg *Config) (*Engine, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+
+	def := cfg.Default
+	switch def {
+	case "":
+		def = EffectAllow
+	case EffectAllow, EffectDeny:
+	default:
+		return nil, fmt.Errorf("invalid default effect %q (must be allow or deny)", def)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("policy")
+	}
+
+	e := &Engine{def: def, logger: logger}
+	for i, r := range cfg.Rules {
+		compiled, err := compile(r)
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("invalid policy rule %s: %w", name, err)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+// compile validates r and compiles its patterns
+func compile(r Rule) (rule, error) {
+	c := rule{Rule: r}
+	if c.On == "" {
+		c.On = ScopeTool
+	}
+
+	switch c.On {
+	case ScopeTool:
+		if c.Text != "" {
+			return c, fmt.Errorf("text only applies to request rules")
+		}
+	case ScopeRequest:
+		if len(c.Tools) > 0 || len(c.Args) > 0 || c.Effect == EffectModify {
+			return c, fmt.Errorf("tools, args and modify only apply to tool rules")
+		}
+	default:
+		return c, fmt.Errorf("invalid scope %q (must be tool or request)", c.On)
+	}
+
+	switch c.Effect {
+	case EffectAllow, EffectDeny:
+	case EffectModify:
+		if len(c.Set) == 0 {
+			return c, fmt.Errorf("modify rules need arguments to set")
+		}
+	default:
+		return c, fmt.Errorf("invalid effect %q (must be allow, deny or modify)", c.Effect)
+	}
+
+	for _, pattern := range slices.Concat(c.Users, c.Tools) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return c, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	if len(c.Args) > 0 {
+		c.args = make(map[string]*regexp.Regexp, len(c.Args))
+		for name, expr := range c.Args {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return c, fmt.Errorf("invalid pattern for argument %s: %w", name, err)
+			}
+			c.args[name] = re
+		}
+	}
+	if c.Text != "" {
+		re, err := regexp.Compile(c.Text)
+		if err != nil {
+			return c, fmt.Errorf("invalid text pattern: %w", err)
+		}
+		c.text = re
+	}
+
+	if c.Hours != "" {
+		from, to, ok := strings.Cut(c.Hours, "-")
+		var err error
+		if c.from, err = parseClock(from); err == nil && ok {
+			c.to, err = parseClock(to)
+		}
+		if err != nil || !ok || c.from == c.to {
+			return c, fmt.Errorf("invalid hours %q (want a window like 09:00-18:00)", c.Hours)
+		}
+	}
+	if len(c.Days) > 0 {
+		c.days = make(map[time.Weekday]bool, len(c.Days))
+		for _, d := range c.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return c, fmt.Errorf("invalid day %q (want mon, tue, ...)", d)
+			}
+			c.days[day] = true
+		}
+	}
+	if c.When != "" {
+		when, err := compileExpr(c.When)
+		if err != nil {
+			return c, fmt.Errorf("invalid condition: %w", err)
+		}
+		c.when = when
+	}
+	c.loc = time.Local
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return c, fmt.Errorf("invalid timezone: %w", err)
+		}
+		c.loc = loc
+	}
+	return c, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Evaluate returns the decision for in. Modify rules apply in order until an
+// allow or deny rule matches. When none does, tool calls get the default
+// effect and requests are allowed. A condition failing to evaluate, such as
+// one reading a missing argument, does not match.
+func (e *Engine) Evaluate(in Input) Decision {
+	if in.Time.IsZero() {
+		in.Time = time.Now()
+	}
+	args := in.Args
+	modified := false
+
+	for _, r := range e.rules {
+		ok, err := r.matches(in, args)
+		if err != nil {
+			e.logger.Warn("Policy condition failed", "rule", r.Name, "scope", in.Scope, "tool", in.Tool, "error", err)
+		}
+		if !ok {
+			continue
+		}
+		if r.Effect == EffectModify {
+			if !modified {
+				args = maps.Clone(args)
+				if args == nil {
+					args = make(map[string]any, len(r.Set))
+				}
+				modified = true
+			}
+			maps.Copy(args, r.Set)
+			continue
+		}
+
+		d := Decision{Effect: r.Effect, Rule: r.Name, Args: args}
+		if r.Effect == EffectDeny {
+			d.Message = r.Message
+			e.logger.Info("Policy denied call", "rule", r.Name, "scope", in.Scope, "user", in.User, "tool", in.Tool)
+		}
+		return d
+	}
+
+	d := Decision{Effect: EffectAllow, Args: args}
+	if in.Scope == ScopeTool && e.def == EffectDeny {
+		d.Effect = EffectDeny
+		e.logger.Info("Policy denied call by default", "scope", in.Scope, "user", in.User, "tool", in.Tool)
+	}
+	return d
+}
+
+// matches reports whether every condition of r holds for in
+func (r *rule) matches(in Input, args map[string]any) (bool, error) {
+	if r.On != in.Scope {
+		return false, nil
+	}
+	if !matchAny(r.Users, in.User) || !matchAny(r.Tools, in.Tool) {
+		return false, nil
+	}
+	for name, re := range r.args {
+		v, ok := args[name]
+		if !ok || !re.MatchString(fmt.Sprint(v)) {
+			return false, nil
+		}
+	}
+	if r.text != nil && !r.text.MatchString(in.Text) {
+		return false, nil
+	}
+
+	t := in.Time.In(r.loc)
+	if r.days != nil && !r.days[t.Weekday()] {
+		return false, nil
+	}
+	if r.from != r.to {
+		now := t.Hour()*60 + t.Minute()
+		if r.from < r.to && (now < r.from || now >= r.to) || r.from > r.to && now < r.from && now >= r.to {
+			return false, nil
+		}
+	}
+
+	if r.when == nil {
+		return true, nil
+	}
+	if args == nil {
+		args = map[string]any{}
+	}
+	return r.when.match(map[string]any{
+		"user": in.User,
+		"tool": in.Tool,
+		"args": args,
+		"time": in.Time,
+		"request": map[string]any{
+			"text":    in.Text,
+			"agent":   in.Agent,
+			"session": in.Session,
+		},
+	})
+}
+
+// matchAny reports whether s matches one of the glob patterns, or there are none
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
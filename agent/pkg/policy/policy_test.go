@@ -0,0 +1,213 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// TestEvaluate tests rule matching, ordering and defaults
+func TestEvaluate(t *testing.T) {
+	engine, err := New(&Config{
+		Default: EffectDeny,
+		Rules: []Rule{
+			{Name: "small-searches", Tools: []string{"search"}, Effect: EffectModify, Set: map[string]any{"limit": 2}},
+			{Name: "no-etc", Tools: []string{"read_*"}, Args: map[string]string{"path": "^/etc/"}, Effect: EffectDeny, Message: "system files are off limits"},
+			{Name: "guests", Users: []string{"guest-*"}, Tools: []string{"search"}, Effect: EffectDeny},
+			{Name: "tools", Tools: []string{"search", "read_*"}, Effect: EffectAllow},
+			{Name: "after-hours", On: ScopeRequest, Hours: "18:00-09:00", Timezone: "UTC", Effect: EffectDeny},
+			{Name: "weekend", On: ScopeRequest, Days: []string{"sat", "sun"}, Text: "(?i)deploy", Effect: EffectDeny},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	monday := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+	saturday := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		in       Input
+		want     Effect
+		wantRule string
+	}{
+		{"allowed tool", Input{Scope: ScopeTool, User: "alice", Tool: "read_file", Args: map[string]any{"path": "/home/a"}}, EffectAllow, "tools"},
+		{"denied argument", Input{Scope: ScopeTool, User: "alice", Tool: "read_file", Args: map[string]any{"path": "/etc/passwd"}}, EffectDeny, "no-etc"},
+		{"denied user", Input{Scope: ScopeTool, User: "guest-7", Tool: "search"}, EffectDeny, "guests"},
+		{"default deny", Input{Scope: ScopeTool, User: "alice", Tool: "shell"}, EffectDeny, ""},
+		{"office hours", Input{Scope: ScopeRequest, User: "alice", Text: "hi", Time: monday}, EffectAllow, ""},
+		{"after hours", Input{Scope: ScopeRequest, User: "alice", Text: "hi", Time: monday.Add(10 * time.Hour)}, EffectDeny, "after-hours"},
+		{"weekend deploy", Input{Scope: ScopeRequest, User: "alice", Text: "Deploy now", Time: saturday}, EffectDeny, "weekend"},
+		{"weekend chat", Input{Scope: ScopeRequest, User: "alice", Text: "hello", Time: saturday}, EffectAllow, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := engine.Evaluate(tt.in)
+			if d.Effect != tt.want || d.Rule != tt.wantRule {
+				t.Errorf("Evaluate() = %s by %q, want %s by %q", d.Effect, d.Rule, tt.want, tt.wantRule)
+			}
+		})
+	}
+
+	args := map[string]any{"query": "go", "limit": 50}
+	d := engine.Evaluate(Input{Scope: ScopeTool, User: "alice", Tool: "search", Args: args})
+	if !d.Allowed() || d.Args["limit"] != 2 || d.Args["query"] != "go" {
+		t.Errorf("modified decision = %+v", d)
+	}
+	if args["limit"] != 50 {
+		t.Errorf("Evaluate() changed the input arguments")
+	}
+}
+
+// TestEvaluateWhen tests rules with CEL conditions
+func TestEvaluateWhen(t *testing.T) {
+	engine, err := New(&Config{
+		Default: EffectDeny,
+		Rules: []Rule{
+			{Name: "cap", Tools: []string{"search"}, When: `args.limit > 10`, Effect: EffectModify, Set: map[string]any{"limit": 10}},
+			{Name: "own-files", Tools: []string{"write_file"}, When: `args.path.startsWith("/home/" + user + "/")`, Effect: EffectAllow},
+			{Name: "search", Tools: []string{"search"}, When: `args.limit <= 10 && request.agent == "researcher"`, Effect: EffectAllow},
+			{Name: "nights", On: ScopeRequest, When: `time.getHours("UTC") < 6 && !request.text.startsWith("/status")`, Effect: EffectDeny},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	night := time.Date(2026, 10, 12, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		in       Input
+		want     Effect
+		wantRule string
+	}{
+		{"own file", Input{Scope: ScopeTool, User: "bob", Tool: "write_file", Args: map[string]any{"path": "/home/bob/a"}}, EffectAllow, "own-files"},
+		{"other file", Input{Scope: ScopeTool, User: "bob", Tool: "write_file", Args: map[string]any{"path": "/home/alice/a"}}, EffectDeny, ""},
+		{"missing argument", Input{Scope: ScopeTool, User: "bob", Tool: "write_file"}, EffectDeny, ""},
+		{"capped search", Input{Scope: ScopeTool, Tool: "search", Agent: "researcher", Args: map[string]any{"limit": float64(50)}}, EffectAllow, "search"},
+		{"other agent", Input{Scope: ScopeTool, Tool: "search", Agent: "writer", Args: map[string]any{"limit": float64(5)}}, EffectDeny, ""},
+		{"night", Input{Scope: ScopeRequest, Text: "hi", Time: night}, EffectDeny, "nights"},
+		{"night status", Input{Scope: ScopeRequest, Text: "/status", Time: night}, EffectAllow, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := engine.Evaluate(tt.in)
+			if d.Effect != tt.want || d.Rule != tt.wantRule {
+				t.Errorf("Evaluate() = %s by %q, want %s by %q", d.Effect, d.Rule, tt.want, tt.wantRule)
+			}
+		})
+	}
+}
+
+// TestNewInvalid tests rejecting malformed rules
+func TestNewInvalid(t *testing.T) {
+	for _, r := range []Rule{
+		{Effect: "block"},
+		{On: "response", Effect: EffectDeny},
+		{On: ScopeRequest, Tools: []string{"x"}, Effect: EffectDeny},
+		{Effect: EffectModify},
+		{Args: map[string]string{"path": "("}, Effect: EffectDeny},
+		{Hours: "9-5", Effect: EffectDeny},
+		{Days: []string{"someday"}, Effect: EffectDeny},
+		{Users: []string{"["}, Effect: EffectDeny},
+		{When: `args.path.startsWith(`, Effect: EffectDeny},
+		{When: `owner == user`, Effect: EffectDeny},
+	} {
+		if _, err := New(&Config{Rules: []Rule{r}}); err == nil {
+			t.Errorf("New(%+v) succeeded, want an error", r)
+		}
+	}
+}
+
+// toolCallingModel asks for the search tool once, then echoes the tool result
+type toolCallingModel struct{}
+
+func (toolCallingModel) Name() string { return "scripted" }
+
+func (toolCallingModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		last := req.Contents[len(req.Contents)-1].Parts[0]
+		if last.FunctionResponse != nil {
+			text := fmt.Sprintf("result: %v", last.FunctionResponse.Response["result"])
+			if msg, ok := last.FunctionResponse.Response["error"]; ok {
+				text = fmt.Sprintf("error: %v", msg)
+			}
+			yield(&model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel)}, nil)
+			return
+		}
+		call := &genai.Part{FunctionCall: &genai.FunctionCall{Name: "search", Args: map[string]any{"query": last.Text, "limit": 50}}}
+		yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{call}}}, nil)
+	}
+}
+
+type searchArgs struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// TestCallbacks tests enforcing rules through the agent callbacks
+func TestCallbacks(t *testing.T) {
+	engine, err := New(&Config{Rules: []Rule{
+		{Name: "deny-secrets", On: ScopeRequest, Text: "secret", Effect: EffectDeny, Message: "Not allowed."},
+		{Name: "guests", Users: []string{"guest"}, Tools: []string{"search"}, Effect: EffectDeny},
+		{Name: "cap", Tools: []string{"search"}, Effect: EffectModify, Set: map[string]any{"limit": 3}},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	search, err := functiontool.New(functiontool.Config{Name: "search", Description: "Searches"},
+		func(_ tool.Context, args searchArgs) (map[string]any, error) {
+			return map[string]any{"result": fmt.Sprintf("%s x%d", args.Query, args.Limit)}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := llmagent.New(llmagent.Config{
+		Name:                 "test",
+		Model:                toolCallingModel{},
+		Tools:                []tool.Tool{search},
+		BeforeModelCallbacks: []llmagent.BeforeModelCallback{engine.ModelCallback()},
+		BeforeToolCallbacks:  []llmagent.BeforeToolCallback{engine.ToolCallback()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := func(user, text string) string {
+		sessions := session.InMemoryService()
+		r, _ := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions})
+		created, _ := sessions.Create(context.Background(), &session.CreateRequest{AppName: "test", UserID: user})
+		var last string
+		for event, err := range r.Run(context.Background(), user, created.Session.ID(), genai.NewContentFromText(text, genai.RoleUser), agent.RunConfig{}) {
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if event.Content != nil && len(event.Content.Parts) > 0 && event.Content.Parts[0].Text != "" {
+				last = event.Content.Parts[0].Text
+			}
+		}
+		return last
+	}
+
+	if got := reply("alice", "go"); got != "result: go x3" {
+		t.Errorf("modified call reply = %q, want the capped limit", got)
+	}
+	if got := reply("guest", "go"); got != "error: tool search is not permitted by policy" {
+		t.Errorf("denied tool reply = %q", got)
+	}
+	if got := reply("alice", "the secret plan"); got != "Not allowed." {
+		t.Errorf("denied request reply = %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package rag
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// sentenceEnds are the characters after which a chunk may be cut, in
+// addition to paragraph and line breaks. Includes full-width CJK punctuation.
+const sentenceEnds = ".!?。！？；;"
+
+// Chunk splits text into chunks of at most size characters, with overlap
+// characters repeated between consecutive chunks. Cuts prefer paragraph
+// breaks, then line breaks, then sentence ends, within the last third of a chunk.
+func Chunk(text string, size, overlap int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if size <= 0 {
+		return []string{text}
+	}
+	overlap = max(0, min(overlap, size/2))
+
+	runes := []rune(text)
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := min(start+size, len(runes))
+		if end < len(runes) {
+			end = cutPoint(runes, start+size*2/3, end)
+		}
+		if chunk := strings.TrimSpace(string(runes[start:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(runes) {
+			break
+		}
+		start = max(end-overlap, start+1)
+	}
+	return chunks
+}
+
+// cutPoint returns the best position in runes[from:to] to end a chunk, or to
+func cutPoint(runes []rune, from, to int) int {
+	window := string(runes[from:to])
+	for _, sep := range []string{"\n\n", "\n"} {
+		if i := strings.LastIndex(window, sep); i >= 0 {
+			return from + utf8.RuneCountInString(window[:i+len(sep)])
+		}
+	}
+	if i := strings.LastIndexAny(window, sentenceEnds); i >= 0 {
+		_, n := utf8.DecodeRuneInString(window[i:])
+		return from + utf8.RuneCountInString(window[:i+n])
+	}
+	return to
+}
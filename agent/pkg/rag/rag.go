@@ -0,0 +1,162 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Embedder turns texts into embedding vectors, e.g. *openai_compatible.Client
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Document is a text to ingest
+type Document struct {
+	Source string // Identifies the document, e.g. its path
+	Text   string
+}
+
+// Config holds the index configuration
+type Config struct {
+	ChunkSize    int // Characters per chunk, defaults to 800
+	ChunkOverlap int // Characters repeated between chunks, defaults to 100
+	BatchSize    int // Chunks per embedding request, defaults to 64
+	Logger       *slog.Logger
+}
+
+// Index chunks and embeds documents into a store and retrieves passages for queries
+type Index struct {
+	embedder Embedder
+	store    Store
+	cfg      Config
+	logger   *slog.Logger
+}
+
+// NewIndex creates an index over store
+func NewIndex(embedder Embedder, store Store, cfg *Config) (*Index, error) {
+	if embedder == nil {
+		return nil, fmt.Errorf("embedder is required")
+	}
+	if store == nil {
+		return nil, fmt.Errorf("store is required")
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c := *cfg
+	if c.ChunkSize == 0 {
+		c.ChunkSize = 800
+	}
+	if c.ChunkOverlap == 0 {
+		c.ChunkOverlap = 100
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 64
+	}
+	if c.ChunkSize < 0 || c.ChunkOverlap < 0 || c.BatchSize < 0 {
+		return nil, fmt.Errorf("chunk size, overlap and batch size cannot be negative")
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("rag")
+	}
+
+	return &Index{
+		embedder: embedder,
+		store:    store,
+		cfg:      c,
+		logger:   logger,
+	}, nil
+}
+
+// Ingest chunks, embeds and stores docs. Chunks already stored with the same
+// text are not embedded again. It returns the number of chunks embedded.
+func (x *Index) Ingest(ctx context.Context, docs ...Document) (int, error) {
+	var pending []Record
+	for _, doc := range docs {
+		for i, text := range Chunk(doc.Text, x.cfg.ChunkSize, x.cfg.ChunkOverlap) {
+			r := Record{
+				ID:     fmt.Sprintf("%s#%d", doc.Source, i),
+				Source: doc.Source,
+				Text:   text,
+				Hash:   hash(text),
+			}
+			existing, ok, err := x.store.Get(ctx, r.ID)
+			if err != nil {
+				return 0, fmt.Errorf("failed to look up chunk: %w", err)
+			}
+			if ok && existing.Hash == r.Hash {
+				continue
+			}
+			pending = append(pending, r)
+		}
+	}
+
+	for start := 0; start < len(pending); start += x.cfg.BatchSize {
+		batch := pending[start:min(start+x.cfg.BatchSize, len(pending))]
+		texts := make([]string, len(batch))
+		for i, r := range batch {
+			texts[i] = r.Text
+		}
+		vectors, err := x.embedder.Embed(ctx, texts)
+		if err != nil {
+			return start, fmt.Errorf("failed to embed chunks: %w", err)
+		}
+		for i := range batch {
+			batch[i].Vector = vectors[i]
+		}
+		if err := x.store.Upsert(ctx, batch); err != nil {
+			return start, fmt.Errorf("failed to store chunks: %w", err)
+		}
+	}
+
+	x.logger.Info("Ingested documents", "documents", len(docs), "embedded_chunks", len(pending))
+	return len(pending), nil
+}
+
+// IngestFiles ingests the files matching the glob patterns
+func (x *Index) IngestFiles(ctx context.Context, patterns ...string) (int, error) {
+	var docs []Document
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return 0, fmt.Errorf("invalid source pattern %q: %w", pattern, err)
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			docs = append(docs, Document{Source: filepath.ToSlash(path), Text: string(data)})
+		}
+	}
+	return x.Ingest(ctx, docs...)
+}
+
+// Retrieve returns the k passages most relevant to query
+func (x *Index) Retrieve(ctx context.Context, query string, k int) ([]Result, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	vectors, err := x.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	return x.store.Search(ctx, vectors[0], k)
+}
+
+func hash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
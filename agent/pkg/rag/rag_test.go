@@ -0,0 +1,100 @@
+package rag
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// keywordEmbedder embeds texts as keyword counts, so similar texts share dimensions
+type keywordEmbedder struct {
+	keywords []string
+	calls    int
+	inputs   int
+}
+
+func (e *keywordEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	e.inputs += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, len(e.keywords))
+		for j, k := range e.keywords {
+			v[j] = float32(strings.Count(strings.ToLower(text), k))
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+// TestChunk tests chunk sizes, overlap and boundary preference
+func TestChunk(t *testing.T) {
+	text := strings.Repeat("这是一个句子。", 30) + "\n\n" + strings.Repeat("word ", 100)
+	chunks := Chunk(text, 100, 20)
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want at least 3", len(chunks))
+	}
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > 100 {
+			t.Errorf("chunk %d has %d characters, want at most 100", i, n)
+		}
+	}
+	if !strings.HasSuffix(chunks[0], "。") {
+		t.Errorf("first chunk should end on a sentence boundary, got %q", chunks[0])
+	}
+
+	if got := Chunk("  short  ", 100, 20); len(got) != 1 || got[0] != "short" {
+		t.Errorf("Chunk(short) = %q", got)
+	}
+	if got := Chunk("", 100, 20); got != nil {
+		t.Errorf("Chunk(empty) = %q, want nil", got)
+	}
+}
+
+// TestIndex tests ingestion, retrieval and skipping unchanged chunks across a file store reload
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	embedder := &keywordEmbedder{keywords: []string{"refund", "shipping", "password"}}
+	path := filepath.Join(t.TempDir(), "rag.jsonl")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	index, err := NewIndex(embedder, store, &Config{ChunkSize: 200})
+	if err != nil {
+		t.Fatalf("NewIndex() error = %v", err)
+	}
+
+	docs := []Document{
+		{Source: "refunds.md", Text: "Refunds are issued within 14 days. A refund goes to the original payment method."},
+		{Source: "shipping.md", Text: "Shipping takes 3-5 days. Express shipping is available."},
+		{Source: "account.md", Text: "Reset your password from the login page."},
+	}
+	if n, err := index.Ingest(ctx, docs...); err != nil || n != 3 {
+		t.Fatalf("Ingest() = %d, %v, want 3 chunks", n, err)
+	}
+
+	results, err := index.Retrieve(ctx, "how long does shipping take", 2)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Source != "shipping.md" {
+		t.Fatalf("Retrieve() = %+v, want shipping.md first", results)
+	}
+
+	// Reopen the store: unchanged chunks are not embedded again
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	if reopened.Len() != 3 {
+		t.Fatalf("reopened store has %d records, want 3", reopened.Len())
+	}
+	index, _ = NewIndex(embedder, reopened, nil)
+	docs[2].Text = "Reset your password from the account settings page."
+	if n, err := index.Ingest(ctx, docs...); err != nil || n != 1 {
+		t.Errorf("re-Ingest() = %d, %v, want only the changed chunk", n, err)
+	}
+}
@@ -0,0 +1,324 @@
+//go:build sqlite && cgo
+
+package rag
+
+/*
+#cgo LDFLAGS: -lsqlite3
+#include <stdlib.h>
+#include <sqlite3.h>
+
+// SQLITE_TRANSIENT is a cast macro cgo cannot express
+static int bind_text(sqlite3_stmt *s, int i, const char *p, int n) {
+	return sqlite3_bind_text(s, i, p, n, SQLITE_TRANSIENT);
+}
+static int bind_blob(sqlite3_stmt *s, int i, const void *p, int n) {
+	return sqlite3_bind_blob(s, i, p, n, SQLITE_TRANSIENT);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+// SQLiteStore is a Store kept in a SQLite database, for knowledge bases too
+// large to hold in memory. Upserts replace rows in place and Search scans
+// the vectors from disk, keeping only the best k. Vectors are stored as
+// little-endian float32 blobs, the format of the sqlite-vec extension.
+type SQLiteStore struct {
+	mu sync.Mutex
+	db *C.sqlite3
+}
+
+// NewSQLiteStore opens the database at path, creating it and its parent
+// directories as needed
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("vector store path is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create vector store directory: %w", err)
+	}
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	s := &SQLiteStore{}
+	if rc := C.sqlite3_open_v2(cpath, &s.db, C.SQLITE_OPEN_READWRITE|C.SQLITE_OPEN_CREATE|C.SQLITE_OPEN_FULLMUTEX, nil); rc != C.SQLITE_OK {
+		err := s.error("open", rc)
+		C.sqlite3_close(s.db)
+		return nil, fmt.Errorf("failed to open vector store: %w", err)
+	}
+	if err := s.exec(`PRAGMA journal_mode = WAL;
+CREATE TABLE IF NOT EXISTS records (
+	id     TEXT PRIMARY KEY,
+	source TEXT NOT NULL,
+	text   TEXT NOT NULL,
+	hash   TEXT NOT NULL,
+	vector BLOB NOT NULL
+)`); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to create vector store: %w", err)
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rc := C.sqlite3_close(s.db); rc != C.SQLITE_OK {
+		return s.error("close", rc)
+	}
+	return nil
+}
+
+// Upsert implements Store. The records are written in one transaction.
+func (s *SQLiteStore) Upsert(_ context.Context, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.exec("BEGIN"); err != nil {
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	if err := s.upsert(records); err != nil {
+		s.exec("ROLLBACK")
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	if err := s.exec("COMMIT"); err != nil {
+		s.exec("ROLLBACK")
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) upsert(records []Record) error {
+	stmt, err := s.prepare(`INSERT INTO records (id, source, text, hash, vector) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET source = excluded.source, text = excluded.text, hash = excluded.hash, vector = excluded.vector`)
+	if err != nil {
+		return err
+	}
+	defer stmt.finalize()
+	for _, r := range records {
+		if err := stmt.bind(r.ID, r.Source, r.Text, r.Hash, encodeVector(normalize(r.Vector))); err != nil {
+			return err
+		}
+		if _, err := stmt.step(); err != nil {
+			return err
+		}
+		if err := stmt.reset(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *SQLiteStore) Get(_ context.Context, id string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stmt, err := s.prepare("SELECT id, source, text, hash, vector FROM records WHERE id = ?")
+	if err != nil {
+		return Record{}, false, fmt.Errorf("failed to read vector store: %w", err)
+	}
+	defer stmt.finalize()
+	if err := stmt.bind(id); err != nil {
+		return Record{}, false, fmt.Errorf("failed to read vector store: %w", err)
+	}
+	row, err := stmt.step()
+	if err != nil {
+		return Record{}, false, fmt.Errorf("failed to read vector store: %w", err)
+	}
+	if !row {
+		return Record{}, false, nil
+	}
+	return stmt.record(), true, nil
+}
+
+// Search implements Store
+func (s *SQLiteStore) Search(ctx context.Context, vector []float32, k int) ([]Result, error) {
+	query := normalize(vector)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stmt, err := s.prepare("SELECT id, source, text, hash, vector FROM records")
+	if err != nil {
+		return nil, fmt.Errorf("failed to search vector store: %w", err)
+	}
+	defer stmt.finalize()
+
+	var results []Result
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		row, err := stmt.step()
+		if err != nil {
+			return nil, fmt.Errorf("failed to search vector store: %w", err)
+		}
+		if !row {
+			break
+		}
+		r := stmt.record()
+		if len(r.Vector) != len(query) {
+			continue
+		}
+		results = append(results, Result{Record: r, Score: dot(r.Vector, query)})
+		// Only the best k are kept, the scan may cover more rows than fit
+		// in memory
+		if k > 0 && len(results) > 2*k {
+			results = bestResults(results, k)
+		}
+	}
+	return bestResults(results, k), nil
+}
+
+// Len returns the number of records
+func (s *SQLiteStore) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stmt, err := s.prepare("SELECT count(*) FROM records")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.finalize()
+	if _, err := stmt.step(); err != nil {
+		return 0, err
+	}
+	return int(C.sqlite3_column_int64(stmt.s, 0)), nil
+}
+
+// encodeVector encodes v as little-endian float32s
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+// decodeVector decodes little-endian float32s
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// error returns the database's last error for a failed operation
+func (s *SQLiteStore) error(op string, rc C.int) error {
+	if s.db == nil {
+		return fmt.Errorf("%s: %s", op, C.GoString(C.sqlite3_errstr(rc)))
+	}
+	return fmt.Errorf("%s: %s", op, C.GoString(C.sqlite3_errmsg(s.db)))
+}
+
+// exec runs SQL statements without results
+func (s *SQLiteStore) exec(sql string) error {
+	csql := C.CString(sql)
+	defer C.free(unsafe.Pointer(csql))
+	if rc := C.sqlite3_exec(s.db, csql, nil, nil, nil); rc != C.SQLITE_OK {
+		return s.error("exec", rc)
+	}
+	return nil
+}
+
+// sqliteStmt is a prepared statement
+type sqliteStmt struct {
+	store *SQLiteStore
+	s     *C.sqlite3_stmt
+}
+
+// prepare compiles a statement, to be finalized by the caller
+func (s *SQLiteStore) prepare(sql string) (*sqliteStmt, error) {
+	csql := C.CString(sql)
+	defer C.free(unsafe.Pointer(csql))
+	stmt := &sqliteStmt{store: s}
+	if rc := C.sqlite3_prepare_v2(s.db, csql, -1, &stmt.s, nil); rc != C.SQLITE_OK {
+		return nil, s.error("prepare", rc)
+	}
+	return stmt, nil
+}
+
+// bind binds strings and blobs to the parameters in order
+func (st *sqliteStmt) bind(args ...any) error {
+	for i, arg := range args {
+		var rc C.int
+		switch v := arg.(type) {
+		case string:
+			p := C.CString(v)
+			rc = C.bind_text(st.s, C.int(i+1), p, C.int(len(v)))
+			C.free(unsafe.Pointer(p))
+		case []byte:
+			if len(v) == 0 {
+				rc = C.sqlite3_bind_zeroblob(st.s, C.int(i+1), 0)
+				break
+			}
+			rc = C.bind_blob(st.s, C.int(i+1), unsafe.Pointer(&v[0]), C.int(len(v)))
+		default:
+			return fmt.Errorf("bind: unsupported type %T", arg)
+		}
+		if rc != C.SQLITE_OK {
+			return st.store.error("bind", rc)
+		}
+	}
+	return nil
+}
+
+// step advances the statement, reporting whether a row is available
+func (st *sqliteStmt) step() (bool, error) {
+	switch rc := C.sqlite3_step(st.s); rc {
+	case C.SQLITE_ROW:
+		return true, nil
+	case C.SQLITE_DONE:
+		return false, nil
+	default:
+		return false, st.store.error("step", rc)
+	}
+}
+
+// reset makes the statement ready to be bound and run again
+func (st *sqliteStmt) reset() error {
+	if rc := C.sqlite3_reset(st.s); rc != C.SQLITE_OK {
+		return st.store.error("reset", rc)
+	}
+	return nil
+}
+
+// finalize frees the statement
+func (st *sqliteStmt) finalize() {
+	C.sqlite3_finalize(st.s)
+}
+
+// record reads the current row of a records query
+func (st *sqliteStmt) record() Record {
+	return Record{
+		ID:     st.text(0),
+		Source: st.text(1),
+		Text:   st.text(2),
+		Hash:   st.text(3),
+		Vector: decodeVector(st.blob(4)),
+	}
+}
+
+func (st *sqliteStmt) text(i int) string {
+	p := C.sqlite3_column_text(st.s, C.int(i))
+	n := C.sqlite3_column_bytes(st.s, C.int(i))
+	return C.GoStringN((*C.char)(unsafe.Pointer(p)), n)
+}
+
+func (st *sqliteStmt) blob(i int) []byte {
+	p := C.sqlite3_column_blob(st.s, C.int(i))
+	n := C.sqlite3_column_bytes(st.s, C.int(i))
+	return C.GoBytes(p, n)
+}
@@ -0,0 +1,16 @@
+//go:build !sqlite || !cgo
+
+package rag
+
+import "fmt"
+
+// SQLiteStore is the SQLite store, left out of builds without the sqlite tag
+type SQLiteStore struct {
+	Store
+}
+
+// NewSQLiteStore fails: the SQLite store needs the sqlite build tag, which
+// links the system's libsqlite3 through cgo
+func NewSQLiteStore(string) (*SQLiteStore, error) {
+	return nil, fmt.Errorf("the SQLite vector store is not built in (build with -tags sqlite, which needs cgo and libsqlite3)")
+}
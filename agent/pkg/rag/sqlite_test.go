@@ -0,0 +1,71 @@
+//go:build sqlite && cgo
+
+package rag
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestSQLiteStore tests upserts, search and reopening a SQLite store
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rag.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore() error = %v", err)
+	}
+	records := []Record{
+		{ID: "a#0", Source: "a", Text: "refunds", Hash: "1", Vector: []float32{1, 0, 0}},
+		{ID: "b#0", Source: "b", Text: "shipping", Hash: "2", Vector: []float32{0, 2, 0}},
+		{ID: "c#0", Source: "c", Text: "passwords", Hash: "3", Vector: []float32{0, 1, 1}},
+	}
+	if err := store.Upsert(ctx, records); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	if err := store.Upsert(ctx, []Record{{ID: "a#0", Source: "a", Text: "refunds v2", Hash: "4", Vector: []float32{1, 0, 0}}}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	results, err := store.Search(ctx, []float32{0, 1, 0.1}, 2)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 2 || results[0].ID != "b#0" || results[1].ID != "c#0" {
+		t.Errorf("Search() = %+v, want b#0 then c#0", results)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore() error = %v", err)
+	}
+	defer reopened.Close()
+	if n, err := reopened.Len(); err != nil || n != 3 {
+		t.Errorf("reopened Len() = %d, %v, want 3", n, err)
+	}
+	r, ok, err := reopened.Get(ctx, "a#0")
+	if err != nil || !ok || r.Text != "refunds v2" || r.Hash != "4" || len(r.Vector) != 3 {
+		t.Errorf("Get(a#0) = %+v, %v, %v, want the replaced record", r, ok, err)
+	}
+	if _, ok, err := reopened.Get(ctx, "missing"); ok || err != nil {
+		t.Errorf("Get(missing) = %v, %v", ok, err)
+	}
+
+	// The index skips unchanged chunks stored in SQLite
+	embedder := &keywordEmbedder{keywords: []string{"refund", "shipping"}}
+	index, err := NewIndex(embedder, reopened, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []Document{{Source: "refunds.md", Text: "Refunds are issued within 14 days."}}
+	if n, err := index.Ingest(ctx, docs...); err != nil || n != 1 {
+		t.Fatalf("Ingest() = %d, %v, want 1 chunk", n, err)
+	}
+	if n, err := index.Ingest(ctx, docs...); err != nil || n != 0 {
+		t.Errorf("re-Ingest() = %d, %v, want nothing embedded", n, err)
+	}
+}
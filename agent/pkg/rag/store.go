@@ -0,0 +1,216 @@
+package rag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Record is an embedded chunk of a document
+type Record struct {
+	ID     string    `json:"id"`     // Source and chunk number, e.g. "docs/faq.md#3"
+	Source string    `json:"source"` // Document the chunk came from
+	Text   string    `json:"text"`
+	Hash   string    `json:"hash"`   // Hash of Text, to skip re-embedding unchanged chunks
+	Vector []float32 `json:"vector"` // Normalized to unit length
+}
+
+// Result is a record matching a query
+type Result struct {
+	Record
+	Score float64 // Cosine similarity, higher is more similar
+}
+
+// Store persists records and finds the nearest ones to a query vector
+type Store interface {
+	// Upsert adds records, replacing records with the same ID
+	Upsert(ctx context.Context, records []Record) error
+	// Get returns the record with id
+	Get(ctx context.Context, id string) (Record, bool, error)
+	// Search returns the k records most similar to vector, best first
+	Search(ctx context.Context, vector []float32, k int) ([]Result, error)
+}
+
+// MemoryStore is a Store that keeps records in memory and searches them exhaustively
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records []Record
+	byID    map[string]int
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{byID: map[string]int{}}
+}
+
+// Upsert implements Store
+func (s *MemoryStore) Upsert(_ context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upsert(records)
+	return nil
+}
+
+func (s *MemoryStore) upsert(records []Record) {
+	for _, r := range records {
+		r.Vector = normalize(r.Vector)
+		if i, ok := s.byID[r.ID]; ok {
+			s.records[i] = r
+			continue
+		}
+		s.byID[r.ID] = len(s.records)
+		s.records = append(s.records, r)
+	}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, id string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byID[id]
+	if !ok {
+		return Record{}, false, nil
+	}
+	return s.records[i], true, nil
+}
+
+// Search implements Store
+func (s *MemoryStore) Search(_ context.Context, vector []float32, k int) ([]Result, error) {
+	query := normalize(vector)
+
+	s.mu.RLock()
+	results := make([]Result, 0, len(s.records))
+	for _, r := range s.records {
+		if len(r.Vector) != len(query) {
+			continue
+		}
+		results = append(results, Result{Record: r, Score: dot(r.Vector, query)})
+	}
+	s.mu.RUnlock()
+	return bestResults(results, k), nil
+}
+
+// bestResults returns the k results with the highest scores, best first,
+// all of them when k is not positive
+func bestResults(results []Result, k int) []Result {
+	slices.SortFunc(results, func(a, b Result) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Len returns the number of records
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.records)
+}
+
+// FileStore is a MemoryStore persisted to an append-only JSON lines file.
+// The file is replayed on open; later lines replace earlier ones with the same ID.
+type FileStore struct {
+	*MemoryStore
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore opens the store at path, creating parent directories as needed
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("vector store path is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create vector store directory: %w", err)
+	}
+
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vector store: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse vector store record: %w", err)
+		}
+		s.upsert([]Record{r})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vector store: %w", err)
+	}
+	return s, nil
+}
+
+// Upsert implements Store
+func (s *FileStore) Upsert(ctx context.Context, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open vector store: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("failed to write vector store record: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write vector store: %w", err)
+	}
+	return s.MemoryStore.Upsert(ctx, records)
+}
+
+// normalize returns v scaled to unit length, so cosine similarity is a dot product
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := math.Sqrt(sum)
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
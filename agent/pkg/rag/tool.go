@@ -0,0 +1,57 @@
+package rag
+
+import (
+	"fmt"
+
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+)
+
+// RetrieveArgs are the arguments of the retrieve tool
+type RetrieveArgs struct {
+	Query string `json:"query" jsonschema:"What to search the knowledge base for"`
+	TopK  int    `json:"top_k,omitempty" jsonschema:"Number of passages to return"`
+}
+
+// Passage is a retrieved piece of a document
+type Passage struct {
+	Source string  `json:"source"`
+	Text   string  `json:"text"`
+	Score  float64 `json:"score"`
+}
+
+// RetrieveResults are the results of the retrieve tool
+type RetrieveResults struct {
+	Passages []Passage `json:"passages"`
+}
+
+// NewRetrieveTool creates the "retrieve" tool, which searches index for
+// passages relevant to a query. topK is the default number of passages.
+func NewRetrieveTool(index *Index, topK int) (tool.Tool, error) {
+	if index == nil {
+		return nil, fmt.Errorf("index is required")
+	}
+	if topK <= 0 {
+		topK = 4
+	}
+
+	return functiontool.New(functiontool.Config{
+		Name:        "retrieve",
+		Description: "Searches the knowledge base and returns the passages most relevant to the query, with their source documents.",
+	}, func(ctx tool.Context, args RetrieveArgs) (RetrieveResults, error) {
+		k := args.TopK
+		if k <= 0 || k > 4*topK {
+			k = topK
+		}
+		results, err := index.Retrieve(ctx, args.Query, k)
+		if err != nil {
+			return RetrieveResults{}, err
+		}
+
+		passages := make([]Passage, len(results))
+		for i, r := range results {
+			passages[i] = Passage{Source: r.Source, Text: r.Text, Score: r.Score}
+		}
+		return RetrieveResults{Passages: passages}, nil
+	})
+}
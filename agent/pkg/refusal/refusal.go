@@ -0,0 +1,184 @@
+package refusal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// Policy is how a provider refusal is handled
+type Policy string
+
+const (
+	// PolicySurface replies to the user with the refusal message
+	PolicySurface Policy = "surface"
+	// PolicyRetry asks the same model once more with a softening instruction
+	PolicyRetry Policy = "retry"
+	// PolicyFallback asks the fallback model instead
+	PolicyFallback Policy = "fallback"
+)
+
+// Default messages, used when the config leaves them empty
+const (
+	DefaultMessage          = "Sorry, the model provider declined to answer this request ({reason}). Please rephrase it and try again."
+	DefaultRetryInstruction = "Your previous answer to this request was blocked by the provider's content filter. Answer again in a neutral, factual tone and leave out anything that could be considered unsafe. If you cannot help, say so briefly."
+)
+
+// CustomMetadata keys of a surfaced refusal
+const (
+	MetadataReason     = "refusal_reason"     // The provider's refusal reason
+	MetadataCategories = "refusal_categories" // Content filter categories that triggered, when known
+	MetadataPrompt     = "refusal_prompt"     // True when the content filter rejected the prompt
+)
+
+// Config holds the refusal policy
+type Config struct {
+	Policy Policy // Defaults to PolicySurface
+
+	// Message is the reply when the refusal is surfaced; {reason} is replaced by the provider's reason
+	Message string
+
+	// RetryInstruction is appended to the system instruction for PolicyRetry
+	RetryInstruction string
+
+	// Fallback is the model asked for PolicyFallback
+	Fallback model.LLM
+
+	Logger *slog.Logger
+}
+
+// Model wraps a model.LLM and handles content-filter refusals
+// (openai_compatible.ResponseRefused) according to a policy. When retrying or
+// falling back does not help either, the refusal is surfaced to the user.
+type Model struct {
+	llm    model.LLM
+	cfg    Config
+	logger *slog.Logger
+}
+
+// NewModel wraps llm with the refusal policy in cfg
+func NewModel(llm model.LLM, cfg *Config) (*Model, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("model is required")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+
+	c := *cfg
+	c.Policy = cmp.Or(c.Policy, PolicySurface)
+	switch c.Policy {
+	case PolicySurface, PolicyRetry:
+	case PolicyFallback:
+		if c.Fallback == nil {
+			return nil, fmt.Errorf("fallback policy requires a fallback model")
+		}
+	default:
+		return nil, fmt.Errorf("invalid refusal policy %q (must be %q, %q or %q)", c.Policy, PolicySurface, PolicyRetry, PolicyFallback)
+	}
+	c.Message = cmp.Or(c.Message, DefaultMessage)
+	c.RetryInstruction = cmp.Or(c.RetryInstruction, DefaultRetryInstruction)
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("refusal")
+	}
+
+	return &Model{
+		llm:    llm,
+		cfg:    c,
+		logger: logger,
+	}, nil
+}
+
+// Name implements model.LLM
+func (m *Model) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM. Partial text streamed before a
+// refusal has already reached the user; the final response replaces it.
+func (m *Model) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		refused, ok := forward(m.llm.GenerateContent(ctx, req, stream), yield)
+		if !ok || refused == nil {
+			return
+		}
+		m.logger.Warn("Provider refused the request",
+			"model", m.llm.Name(),
+			"reason", refused.Reason,
+			"categories", refused.Categories,
+			"prompt", refused.Prompt,
+			"policy", m.cfg.Policy,
+		)
+
+		var next model.LLM
+		switch m.cfg.Policy {
+		case PolicyRetry:
+			next, req = m.llm, m.softened(req)
+		case PolicyFallback:
+			next = m.cfg.Fallback
+		}
+		if next != nil {
+			refused, ok = forward(next.GenerateContent(ctx, req, stream), yield)
+			if !ok || refused == nil {
+				return
+			}
+			m.logger.Warn("Provider refused the request again, surfacing the refusal",
+				"model", next.Name(),
+				"reason", refused.Reason,
+			)
+		}
+
+		yield(m.surface(refused), nil)
+	}
+}
+
+// forward yields responses from seq until it ends or the consumer stops. A
+// refusal is returned instead of being yielded; ok is false once the consumer stopped.
+func forward(seq iter.Seq2[*model.LLMResponse, error], yield func(*model.LLMResponse, error) bool) (refused *openai_compatible.ResponseRefused, ok bool) {
+	for resp, err := range seq {
+		if errors.As(err, &refused) {
+			return refused, true
+		}
+		if !yield(resp, err) {
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
+// softened returns a copy of req with the retry instruction appended to its system instruction
+func (m *Model) softened(req *model.LLMRequest) *model.LLMRequest {
+	out := *req
+	instruction.AppendSystem(&out, m.cfg.RetryInstruction)
+	return &out
+}
+
+// surface builds the turn-complete reply explaining the refusal
+func (m *Model) surface(refused *openai_compatible.ResponseRefused) *model.LLMResponse {
+	text := strings.ReplaceAll(m.cfg.Message, "{reason}", refused.Reason)
+	custom := map[string]any{MetadataReason: refused.Reason}
+	if len(refused.Categories) > 0 {
+		custom[MetadataCategories] = refused.Categories
+	}
+	if refused.Prompt {
+		custom[MetadataPrompt] = true
+	}
+	return &model.LLMResponse{
+		Content:        genai.NewContentFromText(text, genai.RoleModel),
+		CustomMetadata: custom,
+		FinishReason:   genai.FinishReasonSafety,
+		TurnComplete:   true,
+	}
+}
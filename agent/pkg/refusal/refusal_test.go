@@ -0,0 +1,99 @@
+package refusal
+
+import (
+	"context"
+	"iter"
+	"testing"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// fakeModel refuses the first refusals calls and answers afterwards
+type fakeModel struct {
+	name     string
+	refusals int
+	reqs     []*model.LLMRequest
+}
+
+func (m *fakeModel) Name() string { return m.name }
+
+func (m *fakeModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	m.reqs = append(m.reqs, req)
+	refuse := len(m.reqs) <= m.refusals
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if refuse {
+			yield(nil, &openai_compatible.ResponseRefused{Reason: "content_filter", FinishReason: "content_filter"})
+			return
+		}
+		yield(&model.LLMResponse{
+			Content:      genai.NewContentFromText("answer from "+m.name, genai.RoleModel),
+			TurnComplete: true,
+		}, nil)
+	}
+}
+
+// TestPolicies tests how each policy turns a refusal into a reply
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		refusals  int
+		want      string
+		wantCalls int
+	}{
+		{"surface", PolicySurface, 1, "declined: content_filter", 1},
+		{"retry succeeds", PolicyRetry, 1, "answer from primary", 2},
+		{"retry refused again", PolicyRetry, 2, "declined: content_filter", 2},
+		{"fallback", PolicyFallback, 1, "answer from fallback", 1},
+		{"no refusal", PolicySurface, 0, "answer from primary", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &fakeModel{name: "primary", refusals: tt.refusals}
+			m, err := NewModel(primary, &Config{
+				Policy:   tt.policy,
+				Message:  "declined: {reason}",
+				Fallback: &fakeModel{name: "fallback"},
+			})
+			if err != nil {
+				t.Fatalf("NewModel() error = %v", err)
+			}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(context.Background(), req, false) {
+				if err != nil {
+					t.Fatalf("GenerateContent() error = %v", err)
+				}
+				final = resp
+			}
+			if got := final.Content.Parts[0].Text; got != tt.want {
+				t.Errorf("reply = %q, want %q", got, tt.want)
+			}
+			if len(primary.reqs) != tt.wantCalls {
+				t.Errorf("primary called %d times, want %d", len(primary.reqs), tt.wantCalls)
+			}
+			if tt.policy == PolicyRetry {
+				system := primary.reqs[1].Config.SystemInstruction
+				if system == nil || system.Parts[len(system.Parts)-1].Text != DefaultRetryInstruction {
+					t.Errorf("retry should append the retry instruction, got %v", system)
+				}
+				if req.Config != nil {
+					t.Error("retry should not modify the original request")
+				}
+			}
+		})
+	}
+}
+
+// TestNewModel_Validation tests policy validation
+func TestNewModel_Validation(t *testing.T) {
+	if _, err := NewModel(&fakeModel{}, &Config{Policy: PolicyFallback}); err == nil {
+		t.Error("fallback policy without a fallback model should fail")
+	}
+	if _, err := NewModel(&fakeModel{}, &Config{Policy: "ignore"}); err == nil {
+		t.Error("unknown policy should fail")
+	}
+}
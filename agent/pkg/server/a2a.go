@@ -0,0 +1,137 @@
+package server
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/a2aproject/a2a-go/a2a"
+	"github.com/a2aproject/a2a-go/a2asrv"
+	"github.com/gorilla/mux"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/cmd/launcher/web"
+	"google.golang.org/adk/server/adka2a"
+)
+
+// A2APath is where A2A JSON-RPC requests are served. The agent card is
+// served at a2asrv.WellKnownAgentCardPath.
+const A2APath = "/a2a/invoke"
+
+// A2AConfig describes the agent in its A2A agent card. Unset fields are
+// taken from the root agent.
+type A2AConfig struct {
+	// URL is the server's public base URL, advertised as the endpoint of
+	// A2A clients. Defaults to http://localhost:8080.
+	URL              string
+	Name             string
+	Description      string
+	Version          string // Defaults to "1.0.0"
+	DocumentationURL string
+	Provider         *a2a.AgentProvider
+	// Skills describe what the agent can do, built from its tools and sub
+	// agents when empty
+	Skills []a2a.AgentSkill
+}
+
+// A2ALauncher is a sublauncher exposing the root agent over the A2A
+// protocol, so other agent frameworks can delegate tasks to it. Tasks are
+// sent with message/send, or message/stream for SSE task updates, and
+// followed with tasks/get and tasks/cancel.
+type A2ALauncher struct {
+	cfg   *A2AConfig
+	flags *flag.FlagSet
+}
+
+// NewA2ALauncher creates the A2A sublauncher
+func NewA2ALauncher(cfg *A2AConfig) *A2ALauncher {
+	if cfg == nil {
+		cfg = &A2AConfig{}
+	}
+	c := *cfg
+	c.URL = cmp.Or(c.URL, "http://localhost:8080")
+	c.Version = cmp.Or(c.Version, "1.0.0")
+
+	fs := flag.NewFlagSet("a2a", flag.ContinueOnError)
+	fs.StringVar(&c.URL, "a2a_agent_url", c.URL, "A2A host URL as advertised in the public agent card")
+	return &A2ALauncher{cfg: &c, flags: fs}
+}
+
+// Keyword implements web.Sublauncher
+func (l *A2ALauncher) Keyword() string {
+	return "a2a"
+}
+
+// SimpleDescription implements web.Sublauncher
+func (l *A2ALauncher) SimpleDescription() string {
+	return fmt.Sprintf("starts A2A server which handles jsonrpc requests on %s path", A2APath)
+}
+
+// CommandLineSyntax implements web.Sublauncher
+func (l *A2ALauncher) CommandLineSyntax() string {
+	var b strings.Builder
+	o := l.flags.Output()
+	l.flags.SetOutput(&b)
+	l.flags.PrintDefaults()
+	l.flags.SetOutput(o)
+	return b.String()
+}
+
+// Parse implements web.Sublauncher
+func (l *A2ALauncher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse a2a flags: %w", err)
+	}
+	return l.flags.Args(), nil
+}
+
+// UserMessage implements web.Sublauncher
+func (l *A2ALauncher) UserMessage(webURL string, printer func(v ...any)) {
+	printer(fmt.Sprintf("       a2a:  you can access A2A using jsonrpc protocol: %s%s", webURL, A2APath))
+	printer(fmt.Sprintf("       a2a:      agent card: %s%s", webURL, a2asrv.WellKnownAgentCardPath))
+}
+
+// SetupSubrouters implements web.Sublauncher
+func (l *A2ALauncher) SetupSubrouters(router *mux.Router, config *launcher.Config) error {
+	card, err := l.AgentCard(config)
+	if err != nil {
+		return err
+	}
+	router.Handle(a2asrv.WellKnownAgentCardPath, a2asrv.NewStaticAgentCardHandler(card))
+
+	a := config.AgentLoader.RootAgent()
+	executor := adka2a.NewExecutor(adka2a.ExecutorConfig{
+		RunnerConfig: runnerConfig(config, a),
+	})
+	router.Handle(A2APath, a2asrv.NewJSONRPCHandler(a2asrv.NewHandler(executor, config.A2AOptions...)))
+	return nil
+}
+
+var _ web.Sublauncher = (*A2ALauncher)(nil)
+
+// AgentCard returns the agent card of the root agent
+func (l *A2ALauncher) AgentCard(config *launcher.Config) (*a2a.AgentCard, error) {
+	publicURL, err := url.JoinPath(l.cfg.URL, A2APath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid A2A URL: %w", err)
+	}
+	a := config.AgentLoader.RootAgent()
+	skills := l.cfg.Skills
+	if len(skills) == 0 {
+		skills = adka2a.BuildAgentSkills(a)
+	}
+	return &a2a.AgentCard{
+		Name:               cmp.Or(l.cfg.Name, a.Name()),
+		Description:        cmp.Or(l.cfg.Description, a.Description()),
+		Version:            l.cfg.Version,
+		DocumentationURL:   l.cfg.DocumentationURL,
+		Provider:           l.cfg.Provider,
+		URL:                publicURL,
+		PreferredTransport: a2a.TransportProtocolJSONRPC,
+		DefaultInputModes:  []string{"text/plain"},
+		DefaultOutputModes: []string{"text/plain"},
+		Skills:             skills,
+		Capabilities:       a2a.AgentCapabilities{Streaming: true},
+	}, nil
+}
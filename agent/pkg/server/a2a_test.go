@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a2aproject/a2a-go/a2a"
+	"github.com/a2aproject/a2a-go/a2asrv"
+	"github.com/gorilla/mux"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/session"
+)
+
+// TestA2A tests the configured agent card and sending a task
+func TestA2A(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Description: "Echoes messages", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewA2ALauncher(&A2AConfig{
+		URL:    "https://agents.example.com",
+		Skills: []a2a.AgentSkill{{ID: "echo", Name: "Echo", Description: "Repeats the message", Tags: []string{"test"}}},
+	})
+	if err := l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()}); err != nil {
+		t.Fatalf("SetupSubrouters() error = %v", err)
+	}
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + a2asrv.WellKnownAgentCardPath)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	var card a2a.AgentCard
+	err = json.NewDecoder(resp.Body).Decode(&card)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("agent card: %v", err)
+	}
+	if card.Name != "echo" || card.Description != "Echoes messages" || card.URL != "https://agents.example.com"+A2APath {
+		t.Errorf("agent card = %q %q %q, want the agent at the configured URL", card.Name, card.Description, card.URL)
+	}
+	if len(card.Skills) != 1 || card.Skills[0].ID != "echo" || !card.Capabilities.Streaming {
+		t.Errorf("agent card skills = %+v, capabilities = %+v", card.Skills, card.Capabilities)
+	}
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"message/send","params":{"message":{"kind":"message","messageId":"m1","role":"user","parts":[{"kind":"text","text":"hello"}]}}}`
+	resp, err = http.Post(srv.URL+A2APath, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	defer resp.Body.Close()
+	var rpc struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpc); err != nil {
+		t.Fatalf("message/send response: %v", err)
+	}
+	if rpc.Error != nil {
+		t.Fatalf("message/send error = %s", rpc.Error.Message)
+	}
+	if !strings.Contains(string(rpc.Result), `"completed"`) || !strings.Contains(string(rpc.Result), "hello") {
+		t.Errorf("message/send result = %s, want the completed task echoing the message", rpc.Result)
+	}
+}
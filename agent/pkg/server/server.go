@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/admin"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gorilla/mux"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/cmd/launcher/universal"
+	"google.golang.org/adk/cmd/launcher/web"
+	"google.golang.org/adk/session"
+)
+
+// Config holds web server configuration
+type Config struct {
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	DrainTimeout time.Duration           // How long in-flight requests may finish on SIGTERM or SIGINT, defaults to 30s
+	Middlewares  []mux.MiddlewareFunc    // Applied to every route, in order
+	Admin        *admin.Server           // Optional, served on its own port alongside the web server
+	Handlers     map[string]http.Handler // Extra routes by path, e.g. health probes, served without the middlewares
+	Logger       *slog.Logger
+}
+
+// Launcher is a drop-in replacement for ADK's web launcher that takes its
+// defaults from the application config and lets the application install
+// middlewares around all sublauncher routes. Every request gets a
+// correlation ID, returned in X-Request-Id and logged with each line.
+type Launcher struct {
+	cfg          *Config
+	flags        *flag.FlagSet
+	logger       *slog.Logger
+	sublaunchers []web.Sublauncher
+	active       []web.Sublauncher
+}
+
+// firstRouter is implemented by sublaunchers whose routes lie under another
+// sublauncher's path prefix, so they are set up first to be matched
+type firstRouter interface {
+	routesFirst()
+}
+
+// drainer is implemented by sublaunchers running turns that outlive their
+// HTTP requests, such as those of hijacked WebSocket connections, which the
+// server's shutdown neither signals nor waits for
+type drainer interface {
+	// closeTurns refuses new turns, called when the shutdown begins
+	closeTurns()
+	// drain refuses new turns and waits for the running ones until ctx is
+	// done
+	drain(ctx context.Context) error
+}
+
+// NewLauncher creates a new web launcher with the given sublaunchers (api, webui, a2a, ...)
+func NewLauncher(cfg *Config, sublaunchers ...web.Sublauncher) *Launcher {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := *cfg
+	if c.Port == 0 {
+		c.Port = 8080
+	}
+	if c.DrainTimeout <= 0 {
+		c.DrainTimeout = 30 * time.Second
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("server")
+	}
+
+	fs := flag.NewFlagSet("web", flag.ContinueOnError)
+	fs.IntVar(&c.Port, "port", c.Port, "Localhost port for the server")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "Server write timeout (i.e. '10s', '2m')")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "Server read timeout (i.e. '10s', '2m')")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "Server idle timeout (i.e. '10s', '2m')")
+	fs.DurationVar(&c.DrainTimeout, "drain-timeout", c.DrainTimeout, "How long in-flight requests may finish on shutdown (i.e. '30s')")
+
+	return &Launcher{
+		cfg:          &c,
+		flags:        fs,
+		logger:       logger,
+		sublaunchers: sublaunchers,
+	}
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *Launcher) Keyword() string {
+	return "web"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *Launcher) SimpleDescription() string {
+	return "starts web server with additional sub-servers specified by sublaunchers"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *Launcher) CommandLineSyntax() string {
+	var b strings.Builder
+	o := l.flags.Output()
+	l.flags.SetOutput(&b)
+	l.flags.PrintDefaults()
+	l.flags.SetOutput(o)
+
+	fmt.Fprintf(&b, "  You may specify sublaunchers:\n")
+	for _, s := range l.sublaunchers {
+		fmt.Fprintf(&b, "    * %s - %s\n", s.Keyword(), s.SimpleDescription())
+	}
+	fmt.Fprintf(&b, "  Sublaunchers syntax:\n")
+	for _, s := range l.sublaunchers {
+		fmt.Fprintf(&b, "    %s\n  %s\n", s.Keyword(), s.CommandLineSyntax())
+	}
+	return b.String()
+}
+
+// Parse implements launcher.SubLauncher. It parses the server flags followed by
+// the keywords and flags of the requested sublaunchers.
+func (l *Launcher) Parse(args []string) ([]string, error) {
+	byKeyword := make(map[string]web.Sublauncher, len(l.sublaunchers))
+	for _, s := range l.sublaunchers {
+		if _, ok := byKeyword[s.Keyword()]; ok {
+			return nil, fmt.Errorf("duplicate sublauncher keyword: %q", s.Keyword())
+		}
+		byKeyword[s.Keyword()] = s
+	}
+
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse web flags: %w", err)
+	}
+
+	rest := l.flags.Args()
+	seen := make(map[string]bool)
+	for len(rest) > 0 {
+		keyword := rest[0]
+		s, ok := byKeyword[keyword]
+		if !ok {
+			break
+		}
+		if seen[keyword] {
+			return rest, fmt.Errorf("sublauncher %q specified more than once", keyword)
+		}
+
+		var err error
+		rest, err = s.Parse(rest[1:])
+		if err != nil {
+			return nil, fmt.Errorf("sublauncher %q cannot parse arguments: %w", keyword, err)
+		}
+		seen[keyword] = true
+		l.active = append(l.active, s)
+	}
+	return rest, nil
+}
+
+// Execute implements launcher.Launcher so the server can also run standalone
+func (l *Launcher) Execute(ctx context.Context, config *launcher.Config, args []string) error {
+	rest, err := l.Parse(args)
+	if err != nil {
+		return err
+	}
+	if err := universal.ErrorOnUnparsedArgs(rest); err != nil {
+		return err
+	}
+	return l.Run(ctx, config)
+}
+
+// Run implements launcher.SubLauncher. On SIGTERM or SIGINT the server stops
+// accepting connections and lets in-flight requests, such as streamed
+// responses, and WebSocket turns finish for up to the drain timeout before
+// returning.
+func (l *Launcher) Run(ctx context.Context, config *launcher.Config) error {
+	if len(l.active) == 0 {
+		available := make([]string, len(l.sublaunchers))
+		for i, s := range l.sublaunchers {
+			available[i] = s.Keyword()
+		}
+		return fmt.Errorf("no active sublaunchers found - please specify them in the command line. Possible values: %v", available)
+	}
+
+	if config.SessionService == nil {
+		config.SessionService = session.InMemoryService()
+	}
+
+	router := web.BuildBaseRouter()
+	router.Use(logging.Middleware)
+	router.Use(l.cfg.Middlewares...)
+
+	// Routes are matched in the order they are added
+	active := slices.Clone(l.active)
+	slices.SortStableFunc(active, func(a, b web.Sublauncher) int {
+		_, af := a.(firstRouter)
+		_, bf := b.(firstRouter)
+		switch {
+		case af && !bf:
+			return -1
+		case bf && !af:
+			return 1
+		}
+		return 0
+	})
+	for _, s := range active {
+		if err := s.SetupSubrouters(router, config); err != nil {
+			return fmt.Errorf("%s subrouter setup failed: %w", s.Keyword(), err)
+		}
+	}
+
+	webURL := fmt.Sprintf("http://localhost:%d", l.cfg.Port)
+	l.logger.Info("Starting web server",
+		"url", webURL,
+		"read_timeout", l.cfg.ReadTimeout,
+		"write_timeout", l.cfg.WriteTimeout,
+		"idle_timeout", l.cfg.IdleTimeout,
+	)
+	for _, s := range l.active {
+		s.UserMessage(webURL, func(v ...any) { l.logger.Info(fmt.Sprint(v...)) })
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if l.cfg.Admin != nil {
+		adminCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := l.cfg.Admin.ListenAndServe(adminCtx); err != nil {
+				l.logger.Error("Admin server stopped", "error", err)
+			}
+		}()
+	}
+
+	var handler http.Handler = router
+	if len(l.cfg.Handlers) > 0 {
+		mux := http.NewServeMux()
+		for path, h := range l.cfg.Handlers {
+			mux.Handle(path, h)
+		}
+		mux.Handle("/", router)
+		handler = mux
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", l.cfg.Port),
+		ReadTimeout:  l.cfg.ReadTimeout,
+		WriteTimeout: l.cfg.WriteTimeout,
+		IdleTimeout:  l.cfg.IdleTimeout,
+		Handler:      handler,
+	}
+	var drainers []drainer
+	for _, s := range l.active {
+		if d, ok := s.(drainer); ok {
+			srv.RegisterOnShutdown(d.closeTurns)
+			drainers = append(drainers, d)
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	stop() // A second signal terminates the process at once
+	l.logger.Info("Shutting down, draining in-flight requests", "timeout", l.cfg.DrainTimeout)
+	start := time.Now()
+	drainCtx, cancel := context.WithTimeout(context.Background(), l.cfg.DrainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		l.logger.Warn("In-flight requests did not finish in time, closing them", "error", err)
+		srv.Close()
+		return nil
+	}
+	for _, d := range drainers {
+		if err := d.drain(drainCtx); err != nil {
+			l.logger.Warn("WebSocket turns did not finish in time", "error", err)
+			return nil
+		}
+	}
+	l.logger.Info("Server drained", "duration", time.Since(start))
+	return nil
+}
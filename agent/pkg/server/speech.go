@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+)
+
+// Speaker synthesizes the final answers of turns, e.g. an
+// *openai_compatible.Voice
+type Speaker interface {
+	Speak(ctx context.Context, text string) (*openai_compatible.Speech, error)
+}
+
+// audioFrame synthesizes the final answer of a turn, the text of its last
+// message frame. A failure is logged and reported false: the answer already
+// reached the client as text.
+func audioFrame(ctx context.Context, speaker Speaker, answer string, logger *slog.Logger) (Frame, bool) {
+	if speaker == nil || strings.TrimSpace(answer) == "" {
+		return Frame{}, false
+	}
+	speech, err := speaker.Speak(ctx, answer)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.WarnContext(ctx, "Failed to synthesize the answer", "error", err)
+		}
+		return Frame{}, false
+	}
+	return Frame{Type: FrameAudio, Audio: speech.Audio, Format: speech.Format}, true
+}
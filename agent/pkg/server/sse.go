@@ -0,0 +1,257 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gorilla/mux"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/cmd/launcher/web"
+	"google.golang.org/genai"
+)
+
+// SSEPath is where the SSE chat endpoint is served
+const SSEPath = "/api/chat"
+
+// SSE event types. A turn streams message.delta, tool.call, tool.result and
+// message.complete events, then a message.audio event when speech is on, and
+// the response ends with the turn; a failed turn ends with an error event.
+const (
+	EventMessageDelta    = "message.delta"    // Text streamed as the model produces it
+	EventToolCall        = "tool.call"        // ID, Name and Args of a tool call
+	EventToolResult      = "tool.result"      // ID, Name and Response of a tool call
+	EventMessageComplete = "message.complete" // Complete Text of a response, by Author
+	EventMessageAudio    = "message.audio"    // Audio of the final answer, base64 encoded, in Format
+	EventError           = "error"            // Error ends the turn
+)
+
+// sseEvents maps the WebSocket frame types to SSE event types
+var sseEvents = map[string]string{
+	FrameToken:      EventMessageDelta,
+	FrameToolCall:   EventToolCall,
+	FrameToolResult: EventToolResult,
+	FrameMessage:    EventMessageComplete,
+	FrameAudio:      EventMessageAudio,
+}
+
+// ChatRequest is the body of an SSE chat request
+type ChatRequest struct {
+	App       string `json:"app,omitempty"`        // Agent, defaults to the root agent
+	UserID    string `json:"user_id,omitempty"`    // Defaults to "user"
+	SessionID string `json:"session_id,omitempty"` // Session to resume, created when empty or unknown
+	Message   string `json:"message"`
+}
+
+// SSEConfig holds SSE chat endpoint configuration
+type SSEConfig struct {
+	// KeepAlive is how often a comment is sent while the agent is silent,
+	// e.g. during a long tool call, so proxies keep the stream open.
+	// Defaults to 15s.
+	KeepAlive time.Duration
+	// WriteTimeout bounds writing an event, defaults to 10s. It replaces
+	// the server's write timeout, which would otherwise end long turns.
+	WriteTimeout time.Duration
+	// AllowNewSession, when set, is asked before a request starts a
+	// session, which is refused with 503 when it reports false, e.g. under
+	// memory pressure
+	AllowNewSession func() bool
+	// Speaker, when set, synthesizes the final answer of each turn, sent
+	// as a message.audio event
+	Speaker Speaker
+	Logger  *slog.Logger
+}
+
+// SSELauncher is a sublauncher serving the chat endpoint as Server-Sent
+// Events, so frontends get typed progress events rather than the raw
+// session events of the REST API's run_sse.
+type SSELauncher struct {
+	cfg    SSEConfig
+	logger *slog.Logger
+}
+
+// NewSSELauncher creates the SSE sublauncher
+func NewSSELauncher(cfg *SSEConfig) *SSELauncher {
+	if cfg == nil {
+		cfg = &SSEConfig{}
+	}
+	c := *cfg
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = 15 * time.Second
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = 10 * time.Second
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("server")
+	}
+	return &SSELauncher{cfg: c, logger: logger}
+}
+
+// Keyword implements web.Sublauncher
+func (l *SSELauncher) Keyword() string {
+	return "sse"
+}
+
+// SimpleDescription implements web.Sublauncher
+func (l *SSELauncher) SimpleDescription() string {
+	return "starts the SSE chat endpoint streaming typed agent events"
+}
+
+// CommandLineSyntax implements web.Sublauncher
+func (l *SSELauncher) CommandLineSyntax() string {
+	return ""
+}
+
+// Parse implements web.Sublauncher. The sublauncher has no flags.
+func (l *SSELauncher) Parse(args []string) ([]string, error) {
+	return args, nil
+}
+
+// UserMessage implements web.Sublauncher
+func (l *SSELauncher) UserMessage(webURL string, printer func(v ...any)) {
+	printer(fmt.Sprintf("       sse:  you can chat with Server-Sent Events at %s%s", webURL, SSEPath))
+}
+
+// SetupSubrouters implements web.Sublauncher
+func (l *SSELauncher) SetupSubrouters(router *mux.Router, config *launcher.Config) error {
+	router.Methods(http.MethodPost).Path(SSEPath).Handler(l.Handler(config))
+	return nil
+}
+
+// routesFirst implements firstRouter, the endpoint lies under the REST
+// API's /api/ prefix
+func (l *SSELauncher) routesFirst() {}
+
+var _ web.Sublauncher = (*SSELauncher)(nil)
+
+// Handler returns the chat endpoint. It runs the agent on the request's
+// message and streams the turn as SSE events, naming the session in the
+// X-Session-Id header. The server's read and write timeouts do not apply to
+// the stream, each event is bounded by the write timeout instead.
+func (l *SSELauncher) Handler(config *launcher.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body ChatRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(body.Message) == "" {
+			http.Error(w, "message is required", http.StatusBadRequest)
+			return
+		}
+		a, err := loadAgent(config, body.App)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		userID := cmp.Or(body.UserID, "user")
+		sess, err := openSession(req.Context(), config.SessionService, a.Name(), userID, body.SessionID, l.cfg.AllowNewSession)
+		if err != nil {
+			http.Error(w, err.Error(), sessionErrorStatus(err))
+			return
+		}
+		r, err := newRunner(config, a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The body is read, so the read deadline would only cancel the
+		// request context
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Now().Add(l.cfg.WriteTimeout))
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Session-Id", sess.ID())
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		ctx := req.Context()
+		msg := genai.NewContentFromText(body.Message, genai.RoleUser)
+		events := r.Run(ctx, userID, sess.ID(), msg, agent.RunConfig{StreamingMode: agent.StreamingModeSSE})
+
+		// The agent runs in its own goroutine so the stream can be kept
+		// alive while it is silent
+		frames := make(chan Frame)
+		go func() {
+			defer close(frames)
+			var answer string
+			for event, err := range events {
+				if err == nil && event.ErrorMessage != "" {
+					err = fmt.Errorf("%s: %s", cmp.Or(event.ErrorCode, "model error"), event.ErrorMessage)
+				}
+				if err != nil {
+					select {
+					case frames <- Frame{Type: FrameError, Error: err.Error()}:
+					case <-ctx.Done():
+					}
+					return
+				}
+				for _, f := range eventFrames(event) {
+					if f.Type == FrameMessage {
+						answer = f.Text
+					}
+					select {
+					case frames <- f:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			if f, ok := audioFrame(ctx, l.cfg.Speaker, answer, l.logger); ok {
+				select {
+				case frames <- f:
+				case <-ctx.Done():
+				}
+			}
+		}()
+
+		keepAlive := time.NewTicker(l.cfg.KeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				l.logger.DebugContext(ctx, "SSE chat client disconnected", "user", userID, "session", sess.ID())
+				return
+			case <-keepAlive.C:
+				rc.SetWriteDeadline(time.Now().Add(l.cfg.WriteTimeout))
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				rc.Flush()
+			case f, ok := <-frames:
+				if !ok {
+					return
+				}
+				rc.SetWriteDeadline(time.Now().Add(l.cfg.WriteTimeout))
+				if err := writeSSE(w, f); err != nil {
+					l.logger.DebugContext(ctx, "SSE write failed", "error", err)
+					return
+				}
+				rc.Flush()
+				keepAlive.Reset(l.cfg.KeepAlive)
+			}
+		}
+	})
+}
+
+// writeSSE writes a frame as an SSE event, its data the frame with the SSE
+// event type
+func writeSSE(w http.ResponseWriter, f Frame) error {
+	f.Type = cmp.Or(sseEvents[f.Type], f.Type)
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", f.Type, data)
+	return err
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/llmmodel/openai_compatible"
+	"github.com/gorilla/mux"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+)
+
+// TestSSE tests streaming a turn as typed events and resuming its session
+func TestSSE(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewSSELauncher(nil)
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	chat := func(body string) (*http.Response, []string) {
+		resp, err := http.Post(srv.URL+SSEPath, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+		defer resp.Body.Close()
+		var events []string
+		var event string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var f Frame
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &f); err != nil {
+					t.Fatalf("event data %q: %v", line, err)
+				}
+				if f.Type != event {
+					t.Errorf("data type = %q, want %q", f.Type, event)
+				}
+				events = append(events, event+":"+f.Text)
+			}
+		}
+		return resp, events
+	}
+
+	resp, events := chat(`{"user_id":"u","message":"hello"}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("response = %d %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if want := "message.delta:he message.delta:llo message.complete:hello"; strings.Join(events, " ") != want {
+		t.Errorf("events = %q, want %q", strings.Join(events, " "), want)
+	}
+	sessionID := resp.Header.Get("X-Session-Id")
+	if sessionID == "" {
+		t.Fatal("X-Session-Id header is missing")
+	}
+
+	resp, _ = chat(`{"user_id":"u","session_id":"` + sessionID + `","message":"again"}`)
+	if got := resp.Header.Get("X-Session-Id"); got != sessionID {
+		t.Errorf("resumed session = %q, want %q", got, sessionID)
+	}
+
+	if resp, _ := chat(`{"message":" "}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty message status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// fakeSpeaker "synthesizes" text as its upper case
+type fakeSpeaker struct{}
+
+func (fakeSpeaker) Speak(_ context.Context, text string) (*openai_compatible.Speech, error) {
+	return &openai_compatible.Speech{Audio: []byte(strings.ToUpper(text)), Format: "mp3", ContentType: "audio/mpeg"}, nil
+}
+
+// TestSSEAudio tests sending the audio of the final answer after its text
+func TestSSEAudio(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewSSELauncher(&SSEConfig{Speaker: fakeSpeaker{}})
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+SSEPath, "application/json", strings.NewReader(`{"message":"hello"}`))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	defer resp.Body.Close()
+	var last Frame
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			last = Frame{}
+			if err := json.Unmarshal([]byte(data), &last); err != nil {
+				t.Fatalf("event data %q: %v", data, err)
+			}
+		}
+	}
+	if last.Type != EventMessageAudio || string(last.Audio) != "HELLO" || last.Format != "mp3" {
+		t.Errorf("last event = %+v, want the audio of the answer", last)
+	}
+}
+
+// slowModel answers like echoModel after a delay, closing started, when
+// set, as it begins
+type slowModel struct {
+	echoModel
+	delay   time.Duration
+	started chan struct{}
+}
+
+func (m slowModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if m.started != nil {
+			close(m.started)
+		}
+		select {
+		case <-time.After(m.delay):
+		case <-ctx.Done():
+			yield(nil, ctx.Err())
+			return
+		}
+		for resp, err := range m.echoModel.GenerateContent(ctx, req, stream) {
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// TestSSELongTurn tests that a turn outlasting the server's read and write
+// timeouts still streams to the end
+func TestSSELongTurn(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: slowModel{delay: 500 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewSSELauncher(&SSEConfig{KeepAlive: 50 * time.Millisecond})
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+SSEPath, "application/json", strings.NewReader(`{"message":"hello"}`))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	defer resp.Body.Close()
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if event, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading the stream: %v", err)
+	}
+	if !slices.Contains(events, EventMessageComplete) {
+		t.Errorf("events = %q, want the turn to complete", events)
+	}
+}
+
+// TestSSENoNewSession tests refusing to start sessions while resuming
+// existing ones
+func TestSSENoNewSession(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.InMemoryService()
+	existing, err := sessions.Create(context.Background(), &session.CreateRequest{AppName: "echo", UserID: "u"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewSSELauncher(&SSEConfig{AllowNewSession: func() bool { return false }})
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: sessions})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"user_id":"u","message":"hello"}`, http.StatusServiceUnavailable},
+		{`{"user_id":"u","session_id":"unknown","message":"hello"}`, http.StatusServiceUnavailable},
+		{`{"user_id":"u","session_id":"` + existing.Session.ID() + `","message":"hello"}`, http.StatusOK},
+	} {
+		resp, err := http.Post(srv.URL+SSEPath, "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s status = %d, want %d", tt.body, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,525 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/cmd/launcher/web"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// WebSocketPath is where the WebSocket chat endpoint is served
+const WebSocketPath = "/ws/chat"
+
+// Frame types. Clients send message and cancel frames; the server answers a
+// connection with a session frame and each message with token, tool_call,
+// tool_result and message frames, then an audio frame when speech is on,
+// ending the turn with done or error.
+const (
+	FrameSession    = "session"     // SessionID of the connection
+	FrameMessage    = "message"     // Client: Text to send. Server: complete Text of a response, by Author.
+	FrameCancel     = "cancel"      // Client: stops the running turn
+	FrameToken      = "token"       // Text streamed as the model produces it
+	FrameToolCall   = "tool_call"   // ID, Name and Args of a tool call
+	FrameToolResult = "tool_result" // ID, Name and Response of a tool call
+	FrameAudio      = "audio"       // Audio of the final answer, base64 encoded, in Format
+	FrameDone       = "done"        // The turn ended
+	FrameError      = "error"       // Error ends the turn, or rejects a client frame
+)
+
+// Frame is a JSON text frame of the WebSocket chat endpoint
+type Frame struct {
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	SessionID string         `json:"session_id,omitempty"`
+	Author    string         `json:"author,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Args      map[string]any `json:"args,omitempty"`
+	Response  map[string]any `json:"response,omitempty"`
+	Audio     []byte         `json:"audio,omitempty"`
+	Format    string         `json:"format,omitempty"` // Of Audio, e.g. mp3
+	Error     string         `json:"error,omitempty"`
+}
+
+// WebSocketConfig holds WebSocket chat endpoint configuration
+type WebSocketConfig struct {
+	// AllowedOrigins lists the origins of browser clients besides the
+	// server's own, "*" for any
+	AllowedOrigins []string
+	// PingInterval is how often the connection is checked, defaults to 30s.
+	// A client that does not answer within two intervals is disconnected.
+	PingInterval time.Duration
+	// WriteTimeout bounds writing a frame, defaults to 10s
+	WriteTimeout time.Duration
+	// AllowNewSession, when set, is asked before a connection starts a
+	// session, which is refused with 503 when it reports false, e.g. under
+	// memory pressure
+	AllowNewSession func() bool
+	// Speaker, when set, synthesizes the final answer of each turn, sent
+	// as an audio frame
+	Speaker Speaker
+	Logger  *slog.Logger
+}
+
+// WebSocketLauncher is a sublauncher serving the chat endpoint, for web
+// frontends that cannot consume SSE easily. A connection opens a session,
+// resuming the one named by the session_id query parameter, and runs the
+// agent on each message frame.
+type WebSocketLauncher struct {
+	cfg      WebSocketConfig
+	logger   *slog.Logger
+	upgrader websocket.Upgrader
+	turns    turnGroup
+}
+
+// NewWebSocketLauncher creates the WebSocket sublauncher
+func NewWebSocketLauncher(cfg *WebSocketConfig) *WebSocketLauncher {
+	if cfg == nil {
+		cfg = &WebSocketConfig{}
+	}
+	c := *cfg
+	if c.PingInterval <= 0 {
+		c.PingInterval = 30 * time.Second
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = 10 * time.Second
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("server")
+	}
+
+	l := &WebSocketLauncher{cfg: c, logger: logger}
+	l.upgrader = websocket.Upgrader{CheckOrigin: l.checkOrigin}
+	return l
+}
+
+// checkOrigin accepts clients without an Origin header, such as non-browser
+// clients, the server's own origin and the allowed ones
+func (l *WebSocketLauncher) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || slices.Contains(l.cfg.AllowedOrigins, "*") || slices.Contains(l.cfg.AllowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// Keyword implements web.Sublauncher
+func (l *WebSocketLauncher) Keyword() string {
+	return "ws"
+}
+
+// SimpleDescription implements web.Sublauncher
+func (l *WebSocketLauncher) SimpleDescription() string {
+	return "starts the WebSocket chat endpoint streaming agent responses"
+}
+
+// CommandLineSyntax implements web.Sublauncher
+func (l *WebSocketLauncher) CommandLineSyntax() string {
+	return ""
+}
+
+// Parse implements web.Sublauncher. The sublauncher has no flags.
+func (l *WebSocketLauncher) Parse(args []string) ([]string, error) {
+	return args, nil
+}
+
+// UserMessage implements web.Sublauncher
+func (l *WebSocketLauncher) UserMessage(webURL string, printer func(v ...any)) {
+	printer(fmt.Sprintf("        ws:  you can chat over WebSocket at %s%s", strings.Replace(webURL, "http", "ws", 1), WebSocketPath))
+}
+
+// SetupSubrouters implements web.Sublauncher
+func (l *WebSocketLauncher) SetupSubrouters(router *mux.Router, config *launcher.Config) error {
+	router.Methods(http.MethodGet).Path(WebSocketPath).Handler(l.Handler(config))
+	return nil
+}
+
+// closeTurns implements drainer
+func (l *WebSocketLauncher) closeTurns() {
+	l.turns.close()
+}
+
+// drain implements drainer
+func (l *WebSocketLauncher) drain(ctx context.Context) error {
+	l.turns.close()
+	return l.turns.wait(ctx)
+}
+
+var (
+	_ web.Sublauncher = (*WebSocketLauncher)(nil)
+	_ drainer         = (*WebSocketLauncher)(nil)
+)
+
+// turnGroup tracks the running turns of all connections, which outlive
+// their hijacked HTTP requests, so a shutdown can wait for them
+type turnGroup struct {
+	mu      sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// start adds a turn, reporting false once the server is shutting down
+func (g *turnGroup) start() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closing {
+		return false
+	}
+	g.wg.Add(1)
+	return true
+}
+
+// done removes a turn
+func (g *turnGroup) done() {
+	g.wg.Done()
+}
+
+// close refuses new turns
+func (g *turnGroup) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closing = true
+}
+
+// wait blocks until the running turns are over or ctx is done
+func (g *turnGroup) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handler returns the chat endpoint. The query parameters app, user_id and
+// session_id choose the agent, defaulting to the root agent, the user,
+// defaulting to "user", and the session, created when absent.
+func (l *WebSocketLauncher) Handler(config *launcher.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		a, err := loadAgent(config, query.Get("app"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		userID := query.Get("user_id")
+		if userID == "" {
+			userID = "user"
+		}
+		sess, err := openSession(req.Context(), config.SessionService, a.Name(), userID, query.Get("session_id"), l.cfg.AllowNewSession)
+		if err != nil {
+			http.Error(w, err.Error(), sessionErrorStatus(err))
+			return
+		}
+		r, err := newRunner(config, a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ws, err := l.upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			// The upgrader already answered
+			l.logger.DebugContext(req.Context(), "WebSocket upgrade failed", "error", err)
+			return
+		}
+		c := &chatConn{ws: ws, runner: r, userID: userID, sessionID: sess.ID(), cfg: &l.cfg, turns: &l.turns, logger: l.logger}
+		c.serve(req.Context())
+	})
+}
+
+// loadAgent returns the named agent, the root agent when name is empty
+func loadAgent(config *launcher.Config, name string) (agent.Agent, error) {
+	if name == "" {
+		return config.AgentLoader.RootAgent(), nil
+	}
+	return config.AgentLoader.LoadAgent(name)
+}
+
+// runnerConfig returns the runner configuration of the agent with the
+// launcher's services
+func runnerConfig(config *launcher.Config, a agent.Agent) runner.Config {
+	return runner.Config{
+		AppName:         a.Name(),
+		Agent:           a,
+		SessionService:  config.SessionService,
+		ArtifactService: config.ArtifactService,
+		MemoryService:   config.MemoryService,
+	}
+}
+
+// newRunner creates a runner of the agent with the launcher's services
+func newRunner(config *launcher.Config, a agent.Agent) (*runner.Runner, error) {
+	r, err := runner.New(runnerConfig(config, a))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create runner: %w", err)
+	}
+	return r, nil
+}
+
+// errNoNewSession is returned by openSession when new sessions are refused
+var errNoNewSession = errors.New("server is under memory pressure, try again later")
+
+// openSession resumes a session, creating it when id is empty or unknown
+// unless allowNew, when set, reports false
+func openSession(ctx context.Context, sessions session.Service, app, userID, id string, allowNew func() bool) (session.Session, error) {
+	if id != "" {
+		resp, err := sessions.Get(ctx, &session.GetRequest{AppName: app, UserID: userID, SessionID: id})
+		if err == nil {
+			return resp.Session, nil
+		}
+	}
+	if allowNew != nil && !allowNew() {
+		return nil, errNoNewSession
+	}
+	resp, err := sessions.Create(ctx, &session.CreateRequest{AppName: app, UserID: userID, SessionID: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return resp.Session, nil
+}
+
+// sessionErrorStatus returns the HTTP status of an openSession error
+func sessionErrorStatus(err error) int {
+	if errors.Is(err, errNoNewSession) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// chatConn is a WebSocket chat connection running one turn at a time
+type chatConn struct {
+	ws        *websocket.Conn
+	runner    *runner.Runner
+	userID    string
+	sessionID string
+	cfg       *WebSocketConfig
+	turns     *turnGroup
+	logger    *slog.Logger
+
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // Cancels the running turn, nil when idle
+}
+
+// turn is a message to run the agent on
+type turn struct {
+	ctx  context.Context
+	text string
+}
+
+// serve runs the turns read from the connection until it closes
+func (c *chatConn) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer c.ws.Close()
+
+	turns := make(chan turn, 1)
+	go c.read(ctx, cancel, turns)
+	go c.ping(ctx)
+
+	c.logger.DebugContext(ctx, "WebSocket chat connected", "user", c.userID, "session", c.sessionID)
+	if err := c.write(Frame{Type: FrameSession, SessionID: c.sessionID}); err != nil {
+		return
+	}
+	for t := range turns {
+		c.run(t)
+	}
+	c.logger.DebugContext(ctx, "WebSocket chat disconnected", "user", c.userID, "session", c.sessionID)
+}
+
+// read reads client frames until the connection closes, starting a turn on
+// each message. It closes turns and cancels ctx when done.
+func (c *chatConn) read(ctx context.Context, cancel context.CancelFunc, turns chan<- turn) {
+	defer close(turns)
+	defer cancel()
+
+	wait := 2 * c.cfg.PingInterval
+	c.ws.SetReadDeadline(time.Now().Add(wait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(wait))
+	})
+	for {
+		var f Frame
+		if err := c.ws.ReadJSON(&f); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) && ctx.Err() == nil {
+				c.logger.DebugContext(ctx, "WebSocket read failed", "error", err)
+			}
+			c.stop()
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(wait))
+
+		switch f.Type {
+		case FrameMessage:
+			if strings.TrimSpace(f.Text) == "" {
+				c.write(Frame{Type: FrameError, Error: "message text is required"})
+				continue
+			}
+			c.mu.Lock()
+			busy := c.cancel != nil
+			started := !busy && c.turns.start()
+			var turnCtx context.Context
+			if started {
+				turnCtx, c.cancel = context.WithCancel(ctx)
+			}
+			c.mu.Unlock()
+			if busy {
+				c.write(Frame{Type: FrameError, Error: "a turn is already running, wait for done or cancel it"})
+				continue
+			}
+			if !started {
+				c.write(Frame{Type: FrameError, Error: "the server is shutting down"})
+				continue
+			}
+			turns <- turn{ctx: turnCtx, text: f.Text}
+		case FrameCancel:
+			c.stop()
+		default:
+			c.write(Frame{Type: FrameError, Error: fmt.Sprintf("unknown frame type %q", f.Type)})
+		}
+	}
+}
+
+// stop cancels the running turn, if any
+func (c *chatConn) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// ping checks the connection until ctx is done
+func (c *chatConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.cfg.WriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run runs the agent on a message, streaming its events as frames. The
+// turn is over before its last frame is written, so the client may send the
+// next message as soon as it reads done, but a shutdown waits for the frame.
+func (c *chatConn) run(t turn) {
+	defer c.turns.done()
+	last, ok := c.stream(t)
+	c.mu.Lock()
+	c.cancel()
+	c.cancel = nil
+	c.mu.Unlock()
+	if ok {
+		c.write(last)
+	}
+}
+
+// stream writes the frames of the turn's events and returns its last frame,
+// done or error. It reports false when the connection failed.
+func (c *chatConn) stream(t turn) (Frame, bool) {
+	canceled := Frame{Type: FrameError, Error: "turn canceled"}
+	var answer string
+	msg := genai.NewContentFromText(t.text, genai.RoleUser)
+	events := c.runner.Run(t.ctx, c.userID, c.sessionID, msg, agent.RunConfig{StreamingMode: agent.StreamingModeSSE})
+	for event, err := range events {
+		if err == nil && event.ErrorMessage != "" {
+			err = fmt.Errorf("%s: %s", cmp.Or(event.ErrorCode, "model error"), event.ErrorMessage)
+		}
+		if t.ctx.Err() != nil {
+			return canceled, true
+		}
+		if err != nil {
+			return Frame{Type: FrameError, Error: err.Error()}, true
+		}
+		for _, f := range eventFrames(event) {
+			if f.Type == FrameMessage {
+				answer = f.Text
+			}
+			if err := c.write(f); err != nil {
+				return Frame{}, false
+			}
+		}
+	}
+	if f, ok := audioFrame(t.ctx, c.cfg.Speaker, answer, c.logger); ok {
+		if err := c.write(f); err != nil {
+			return Frame{}, false
+		}
+	}
+	if t.ctx.Err() != nil {
+		return canceled, true
+	}
+	return Frame{Type: FrameDone}, true
+}
+
+// eventFrames converts an event to frames: partial text to tokens, the text
+// of a complete response to a message, and tool calls and their results
+func eventFrames(event *session.Event) []Frame {
+	if event.Content == nil {
+		return nil
+	}
+	var frames []Frame
+	var text strings.Builder
+	for _, part := range event.Content.Parts {
+		switch {
+		case part.Text != "" && !part.Thought:
+			if event.Partial {
+				frames = append(frames, Frame{Type: FrameToken, Text: part.Text, Author: event.Author})
+			} else {
+				text.WriteString(part.Text)
+			}
+		case part.FunctionCall != nil:
+			frames = append(frames, Frame{Type: FrameToolCall, ID: part.FunctionCall.ID, Name: part.FunctionCall.Name, Args: part.FunctionCall.Args, Author: event.Author})
+		case part.FunctionResponse != nil:
+			frames = append(frames, Frame{Type: FrameToolResult, ID: part.FunctionResponse.ID, Name: part.FunctionResponse.Name, Response: part.FunctionResponse.Response, Author: event.Author})
+		}
+	}
+	if text.Len() > 0 {
+		frames = append(frames, Frame{Type: FrameMessage, Text: text.String(), Author: event.Author})
+	}
+	return frames
+}
+
+// write sends a frame, serializing writes from the turn and the reader
+func (c *chatConn) write(f Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	if err := c.ws.WriteJSON(f); err != nil {
+		c.logger.Debug("WebSocket write failed", "error", err)
+		return err
+	}
+	return nil
+}
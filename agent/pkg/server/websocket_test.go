@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// echoModel streams the user message back in two chunks
+type echoModel struct{}
+
+func (echoModel) Name() string { return "echo" }
+
+func (echoModel) GenerateContent(_ context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := req.Contents[len(req.Contents)-1].Parts[0].Text
+		if stream {
+			half := len(text) / 2
+			for _, chunk := range []string{text[:half], text[half:]} {
+				if !yield(&model.LLMResponse{Content: genai.NewContentFromText(chunk, genai.RoleModel), Partial: true}, nil) {
+					return
+				}
+			}
+		}
+		yield(&model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// TestWebSocket tests streaming a turn, resuming a session and rejecting
+// cross-origin clients
+func TestWebSocket(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.InMemoryService()
+	router := mux.NewRouter()
+	l := NewWebSocketLauncher(&WebSocketConfig{AllowedOrigins: []string{"https://app.example.com"}})
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: sessions})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + WebSocketPath
+
+	dial := func(query string, header http.Header) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+query, header)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		return conn
+	}
+	read := func(conn *websocket.Conn) Frame {
+		var f Frame
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return f
+	}
+
+	conn := dial("?user_id=u", http.Header{"Origin": {"https://app.example.com"}})
+	first := read(conn)
+	if first.Type != FrameSession || first.SessionID == "" {
+		t.Fatalf("first frame = %+v, want the session", first)
+	}
+	conn.WriteJSON(Frame{Type: "bogus"})
+	if f := read(conn); f.Type != FrameError {
+		t.Errorf("unknown frame answered with %+v", f)
+	}
+	conn.WriteJSON(Frame{Type: FrameMessage, Text: "hello"})
+	var got []string
+	for {
+		f := read(conn)
+		got = append(got, f.Type+":"+f.Text+f.Error)
+		if f.Type == FrameDone || f.Type == FrameError {
+			break
+		}
+	}
+	if want := "token:he token:llo message:hello done:"; strings.Join(got, " ") != want {
+		t.Errorf("turn frames = %q, want %q", strings.Join(got, " "), want)
+	}
+	conn.Close()
+
+	conn = dial("?user_id=u&session_id="+first.SessionID, nil)
+	if f := read(conn); f.SessionID != first.SessionID {
+		t.Errorf("resumed session = %q, want %q", f.SessionID, first.SessionID)
+	}
+	conn.Close()
+	resp, err := sessions.Get(context.Background(), &session.GetRequest{AppName: "echo", UserID: "u", SessionID: first.SessionID})
+	if err != nil || resp.Session.Events().Len() != 2 {
+		t.Errorf("session events = %v, %v; want the message and the reply", resp, err)
+	}
+
+	_, httpResp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil || httpResp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin dial succeeded")
+	}
+}
+
+// TestWebSocketDrain tests that a shutdown waits for a running turn and
+// refuses new ones
+func TestWebSocketDrain(t *testing.T) {
+	started := make(chan struct{})
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: slowModel{delay: 300 * time.Millisecond, started: started}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewWebSocketLauncher(nil)
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.RegisterOnShutdown(l.closeTurns)
+	srv.Start()
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+WebSocketPath, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	read := func() Frame {
+		var f Frame
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return f
+	}
+	read() // The session
+	conn.WriteJSON(Frame{Type: FrameMessage, Text: "hello"})
+	<-started
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Config.Shutdown(ctx); err != nil {
+			drained <- err
+			return
+		}
+		drained <- l.drain(ctx)
+	}()
+	select {
+	case err := <-drained:
+		t.Fatalf("drained with a running turn, error = %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	var last Frame
+	for last.Type != FrameDone && last.Type != FrameError {
+		last = read()
+	}
+	if last.Type != FrameDone {
+		t.Errorf("last frame = %+v, want the turn to finish", last)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("drain error = %v", err)
+	}
+
+	conn.WriteJSON(Frame{Type: FrameMessage, Text: "again"})
+	if f := read(); f.Type != FrameError || f.Error != "the server is shutting down" {
+		t.Errorf("message after shutdown answered with %+v", f)
+	}
+}
+
+// TestWebSocketNoNewSession tests refusing to start sessions while resuming
+// existing ones
+func TestWebSocketNoNewSession(t *testing.T) {
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.InMemoryService()
+	existing, err := sessions.Create(context.Background(), &session.CreateRequest{AppName: "echo", UserID: "u"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	l := NewWebSocketLauncher(&WebSocketConfig{AllowNewSession: func() bool { return false }})
+	l.SetupSubrouters(router, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: sessions})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + WebSocketPath
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?user_id=u", nil)
+	if err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("new session dial = %v, want %d", err, http.StatusServiceUnavailable)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?user_id=u&session_id="+existing.Session.ID(), nil)
+	if err != nil {
+		t.Fatalf("resuming dial error = %v", err)
+	}
+	conn.Close()
+}
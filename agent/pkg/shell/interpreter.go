@@ -0,0 +1,289 @@
+package shell
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+)
+
+// Defaults applied by NewInterpreter
+const (
+	DefaultCodeTimeout = 60 * time.Second // Includes compiling Go
+	DefaultMaxMemory   = 512 << 20
+	DefaultMaxCodeSize = 64 << 10
+)
+
+// maxFileBlocks caps files written by a snippet, in 512-byte blocks (64MB)
+const maxFileBlocks = 131072
+
+// language is how a snippet of one language is stored and run. run is a
+// shell script executed in the snippet's directory, with TMPDIR writable.
+type language struct {
+	file  string
+	run   string
+	image string // Default container image
+}
+
+var languages = map[string]language{
+	"python": {
+		file:  "main.py",
+		run:   "exec python3 -I main.py",
+		image: "python:3.13-slim",
+	},
+	"go": {
+		file:  "main.go",
+		run:   `go build -o "$TMPDIR/main" main.go && exec "$TMPDIR/main"`,
+		image: "golang:1.25-alpine",
+	},
+}
+
+// Languages lists the languages snippets can be written in
+func Languages() []string {
+	return slices.Sorted(func(yield func(string) bool) {
+		for name := range languages {
+			if !yield(name) {
+				return
+			}
+		}
+	})
+}
+
+// Runtimes lists where snippets can run: "process" runs them as local
+// processes under resource limits, the others in a container without network
+func Runtimes() []string {
+	return []string{"process", "docker", "podman"}
+}
+
+// InterpreterConfig configures the run_code tool
+type InterpreterConfig struct {
+	// Languages enables a subset of Languages(), defaults to all
+	Languages []string
+	// Runtime is one of Runtimes(), defaults to "process"
+	Runtime string
+	// Images overrides the container image of a language
+	Images map[string]string
+	// Timeout kills a snippet running longer, defaults to DefaultCodeTimeout
+	Timeout time.Duration
+	// MaxOutput caps stdout and stderr each, in bytes, defaults to DefaultMaxOutput
+	MaxOutput int
+	// MaxMemory caps the memory of a snippet, in bytes, defaults to DefaultMaxMemory
+	MaxMemory int64
+	Logger    *slog.Logger
+}
+
+// CodeArgs are the arguments of the run_code tool
+type CodeArgs struct {
+	Language string `json:"language" jsonschema:"Language of the code"`
+	Code     string `json:"code" jsonschema:"Complete program; print results to stdout"`
+}
+
+// Interpreter runs code snippets in a sandbox
+type Interpreter struct {
+	languages []string
+	runtime   string
+	images    map[string]string
+	timeout   time.Duration
+	maxOutput int
+	maxMemory int64
+	goCache   string
+	logger    *slog.Logger
+}
+
+// NewInterpreter creates an interpreter from cfg. The process runtime needs
+// the interpreters and compilers installed locally; it limits CPU time,
+// memory and file size but not network access.
+func NewInterpreter(cfg *InterpreterConfig) (*Interpreter, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	in := &Interpreter{
+		languages: slices.Clone(cfg.Languages),
+		runtime:   cfg.Runtime,
+		images:    map[string]string{},
+		timeout:   cfg.Timeout,
+		maxOutput: cfg.MaxOutput,
+		maxMemory: cfg.MaxMemory,
+		logger:    cfg.Logger,
+	}
+	if len(in.languages) == 0 {
+		in.languages = Languages()
+	}
+	if in.runtime == "" {
+		in.runtime = "process"
+	}
+	if !slices.Contains(Runtimes(), in.runtime) {
+		return nil, fmt.Errorf("unknown code runtime %q (must be one of %s)", in.runtime, strings.Join(Runtimes(), ", "))
+	}
+	for _, name := range in.languages {
+		lang, ok := languages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown language %q (must be one of %s)", name, strings.Join(Languages(), ", "))
+		}
+		in.images[name] = lang.image
+	}
+	for name, image := range cfg.Images {
+		if !slices.Contains(in.languages, name) {
+			return nil, fmt.Errorf("image given for language %q, which is not enabled", name)
+		}
+		in.images[name] = image
+	}
+	if in.timeout <= 0 {
+		in.timeout = DefaultCodeTimeout
+	}
+	if in.maxOutput <= 0 {
+		in.maxOutput = DefaultMaxOutput
+	}
+	if in.maxMemory <= 0 {
+		in.maxMemory = DefaultMaxMemory
+	}
+	if in.logger == nil {
+		in.logger = logging.Component("shell")
+	}
+
+	if in.runtime == "process" {
+		for _, name := range in.languages {
+			program := map[string]string{"python": "python3", "go": "go"}[name]
+			if _, err := exec.LookPath(program); err != nil {
+				return nil, fmt.Errorf("%s snippets need %s: %w", name, program, err)
+			}
+		}
+		// Share the build cache between runs, Go compiles are slow without it
+		if dir, err := os.UserCacheDir(); err == nil {
+			in.goCache = filepath.Join(dir, "yanshu", "go-build")
+		}
+	} else if _, err := exec.LookPath(in.runtime); err != nil {
+		return nil, fmt.Errorf("code runtime %s is not available: %w", in.runtime, err)
+	}
+	return in, nil
+}
+
+// Tool returns the run_code tool backed by the interpreter
+func (in *Interpreter) Tool() (tool.Tool, error) {
+	return functiontool.New(functiontool.Config{
+		Name: "run_code",
+		Description: fmt.Sprintf("Runs a short program in a sandbox and returns its exit code and output, "+
+			"e.g. to compute, analyze data or check an answer. Languages: %s. Programs run for at most %s "+
+			"with %d MB of memory, start in an empty directory and should print their results.",
+			strings.Join(in.languages, ", "), in.timeout, in.maxMemory>>20),
+	}, func(ctx tool.Context, args CodeArgs) (Result, error) {
+		return in.Run(ctx, args)
+	})
+}
+
+// Run runs the snippet of args in a fresh directory that is removed afterwards.
+// A non-zero exit code, including a failed Go build, is a result, not an error.
+func (in *Interpreter) Run(ctx context.Context, args CodeArgs) (Result, error) {
+	lang, ok := languages[args.Language]
+	if !ok || !slices.Contains(in.languages, args.Language) {
+		return Result{}, fmt.Errorf("language %q is not enabled, enabled: %s", args.Language, strings.Join(in.languages, ", "))
+	}
+	if strings.TrimSpace(args.Code) == "" {
+		return Result{}, fmt.Errorf("code is empty")
+	}
+	if len(args.Code) > DefaultMaxCodeSize {
+		return Result{}, fmt.Errorf("code of %d bytes exceeds the limit of %d", len(args.Code), DefaultMaxCodeSize)
+	}
+
+	dir, err := os.MkdirTemp("", "yanshu-code-")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create code directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	// Readable by the unprivileged container user
+	if err := os.Chmod(dir, 0o755); err != nil {
+		return Result{}, fmt.Errorf("failed to create code directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, lang.file), []byte(args.Code), 0o644); err != nil {
+		return Result{}, fmt.Errorf("failed to write code: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, in.timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if in.runtime == "process" {
+		cmd = in.processCommand(ctx, dir, lang)
+	} else {
+		cmd = in.containerCommand(ctx, dir, args.Language, lang)
+	}
+	cmd.WaitDelay = time.Second // Stop waiting for output held open by children
+	stdout := &cappedBuffer{max: in.maxOutput}
+	stderr := &cappedBuffer{max: in.maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	result := Result{
+		ExitCode:  cmd.ProcessState.ExitCode(),
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+		TimedOut:  errors.Is(ctx.Err(), context.DeadlineExceeded),
+	}
+	in.logger.Info("Code snippet finished", "language", args.Language, "runtime", in.runtime,
+		"exit_code", result.ExitCode, "timed_out", result.TimedOut, "duration", time.Since(start))
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && !result.TimedOut {
+		return Result{}, fmt.Errorf("failed to run %s code: %w", args.Language, err)
+	}
+	return result, nil
+}
+
+// limits returns the ulimit commands capping CPU time, memory and file size
+func (in *Interpreter) limits() string {
+	cpu := int(in.timeout.Seconds()) + 1
+	return fmt.Sprintf("ulimit -t %d; ulimit -d %d; ulimit -f %d; ", cpu, in.maxMemory>>10, maxFileBlocks)
+}
+
+// processCommand runs a snippet as a local process with a minimal
+// environment, its directory as home and resource limits
+func (in *Interpreter) processCommand(ctx context.Context, dir string, lang language) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", in.limits()+lang.run)
+	cmd.Dir = dir
+	cmd.Env = []string{"HOME=" + dir, "TMPDIR=" + dir, "GOTOOLCHAIN=local", "GOPROXY=off"}
+	if in.goCache != "" {
+		cmd.Env = append(cmd.Env, "GOCACHE="+in.goCache)
+	}
+	for _, name := range []string{"PATH", "LANG", "TZ"} {
+		if value, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	return cmd
+}
+
+// containerCommand runs a snippet in a throwaway container without network,
+// as an unprivileged user, with the code mounted read-only
+func (in *Interpreter) containerCommand(ctx context.Context, dir, name string, lang language) *exec.Cmd {
+	id := make([]byte, 6)
+	rand.Read(id)
+	container := "yanshu-code-" + hex.EncodeToString(id)
+	cmd := exec.CommandContext(ctx, in.runtime, "run", "--rm", "--name", container,
+		"--network", "none", "--user", "65534:65534", "--read-only", "--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges", "--pids-limit", "128",
+		"--memory", fmt.Sprint(in.maxMemory), "--cpus", "1",
+		"--tmpfs", "/tmp:rw,exec,size=256m",
+		"--volume", dir+":/code:ro", "--workdir", "/code",
+		"--env", "HOME=/tmp", "--env", "TMPDIR=/tmp", "--env", "GOCACHE=/tmp/go-build",
+		"--env", "GOTOOLCHAIN=local", "--env", "GOPROXY=off",
+		in.images[name], "sh", "-c", in.limits()+lang.run)
+	// Killing the client leaves the container running
+	cmd.Cancel = func() error {
+		exec.Command(in.runtime, "kill", container).Run()
+		return cmd.Process.Kill()
+	}
+	return cmd
+}
@@ -0,0 +1,97 @@
+package shell
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestInterpreter tests running snippets as local processes under limits
+func TestInterpreter(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	in, err := NewInterpreter(&InterpreterConfig{
+		Languages: []string{"python"},
+		Timeout:   2 * time.Second,
+		MaxOutput: 16,
+		MaxMemory: 128 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	result, err := in.Run(ctx, CodeArgs{Language: "python", Code: "import os\nprint(sum(range(10)), os.getcwd() == os.environ['HOME'])"})
+	if err != nil || result.ExitCode != 0 || result.Stdout != "45 True\n" {
+		t.Errorf("Run(sum) = %+v, %v", result, err)
+	}
+
+	result, err = in.Run(ctx, CodeArgs{Language: "python", Code: "raise SystemExit('failed')"})
+	if err != nil || result.ExitCode != 1 || !strings.Contains(result.Stderr, "failed") {
+		t.Errorf("Run(exit) = %+v, %v", result, err)
+	}
+
+	result, err = in.Run(ctx, CodeArgs{Language: "python", Code: "print('x' * 100)"})
+	if err != nil || !result.Truncated || len(result.Stdout) != 16 {
+		t.Errorf("Run(long output) = %+v, %v", result, err)
+	}
+
+	result, err = in.Run(ctx, CodeArgs{Language: "python", Code: "b = bytearray(256 << 20)"})
+	if err != nil || result.ExitCode == 0 || result.TimedOut {
+		t.Errorf("Run(over memory) = %+v, %v", result, err)
+	}
+
+	result, err = in.Run(ctx, CodeArgs{Language: "python", Code: "while True: pass"})
+	if err != nil || !result.TimedOut {
+		t.Errorf("Run(loop) = %+v, %v", result, err)
+	}
+
+	if _, err := in.Run(ctx, CodeArgs{Language: "go", Code: "package main"}); err == nil {
+		t.Error("Run(go) succeeded, want language not enabled")
+	}
+	if _, err := in.Run(ctx, CodeArgs{Language: "python", Code: " "}); err == nil {
+		t.Error("Run(empty) succeeded")
+	}
+}
+
+// TestInterpreterGo tests building and running Go snippets
+func TestInterpreterGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Go code")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	in, err := NewInterpreter(&InterpreterConfig{Languages: []string{"go"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	result, err := in.Run(ctx, CodeArgs{Language: "go", Code: "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(6 * 7) }\n"})
+	if err != nil || result.ExitCode != 0 || result.Stdout != "42\n" {
+		t.Errorf("Run(go) = %+v, %v", result, err)
+	}
+
+	result, err = in.Run(ctx, CodeArgs{Language: "go", Code: "package main\n\nfunc main() { undefined() }\n"})
+	if err != nil || result.ExitCode == 0 || !strings.Contains(result.Stderr, "undefined") {
+		t.Errorf("Run(build error) = %+v, %v", result, err)
+	}
+}
+
+// TestNewInterpreter tests configuration errors
+func TestNewInterpreter(t *testing.T) {
+	for _, cfg := range []*InterpreterConfig{
+		nil,
+		{Runtime: "chroot"},
+		{Languages: []string{"cobol"}},
+		{Languages: []string{"python"}, Images: map[string]string{"go": "golang"}},
+	} {
+		if _, err := NewInterpreter(cfg); err == nil {
+			t.Errorf("NewInterpreter(%+v) succeeded", cfg)
+		}
+	}
+}
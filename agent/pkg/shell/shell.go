@@ -0,0 +1,258 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+)
+
+// Defaults applied by New
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultMaxOutput = 64 << 10
+)
+
+// baseEnv are the environment variables every command gets
+var baseEnv = []string{"PATH", "HOME", "LANG", "TZ"}
+
+// Config configures the exec_shell tool
+type Config struct {
+	// Allow lists the programs that may run, by name as found in PATH
+	Allow []string
+	// Dir is the root commands run in; working directories outside it are
+	// rejected. Defaults to the current directory.
+	Dir string
+	// Timeout kills a command running longer, defaults to DefaultTimeout
+	Timeout time.Duration
+	// MaxOutput caps stdout and stderr each, in bytes, defaults to DefaultMaxOutput
+	MaxOutput int
+	// Env names variables passed through in addition to PATH, HOME, LANG and TZ
+	Env    []string
+	Logger *slog.Logger
+}
+
+// Args are the arguments of the exec_shell tool
+type Args struct {
+	Command string `json:"command" jsonschema:"Command line to run, e.g. 'git status --short'. Quotes group words; pipes, redirects and variables are not interpreted"`
+	Dir     string `json:"dir,omitempty" jsonschema:"Working directory relative to the workspace root"`
+}
+
+// Result is the outcome of a command; a non-zero exit code is a result, not an error
+type Result struct {
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+	TimedOut  bool   `json:"timed_out,omitempty"`
+}
+
+// Runner runs allowed commands under the configured restrictions
+type Runner struct {
+	allow     []string
+	dir       string
+	timeout   time.Duration
+	maxOutput int
+	env       []string
+	logger    *slog.Logger
+}
+
+// New creates a runner from cfg
+func New(cfg *Config) (*Runner, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if len(cfg.Allow) == 0 {
+		return nil, fmt.Errorf("at least one allowed command is required")
+	}
+	for _, name := range cfg.Allow {
+		if name == "" || strings.ContainsRune(name, filepath.Separator) || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("allowed command %q must be a program name without a path", name)
+		}
+	}
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve shell directory: %w", err)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return nil, fmt.Errorf("failed to resolve shell directory: %w", err)
+	}
+
+	r := &Runner{
+		allow:     slices.Clone(cfg.Allow),
+		dir:       dir,
+		timeout:   cfg.Timeout,
+		maxOutput: cfg.MaxOutput,
+		logger:    cfg.Logger,
+	}
+	if r.timeout <= 0 {
+		r.timeout = DefaultTimeout
+	}
+	if r.maxOutput <= 0 {
+		r.maxOutput = DefaultMaxOutput
+	}
+	if r.logger == nil {
+		r.logger = logging.Component("shell")
+	}
+	for _, name := range slices.Concat(baseEnv, cfg.Env) {
+		if value, ok := os.LookupEnv(name); ok {
+			r.env = append(r.env, name+"="+value)
+		}
+	}
+	return r, nil
+}
+
+// Tool returns the exec_shell tool backed by the runner
+func (r *Runner) Tool() (tool.Tool, error) {
+	return functiontool.New(functiontool.Config{
+		Name: "exec_shell",
+		Description: fmt.Sprintf("Runs a local command and returns its exit code and output. Allowed programs: %s. "+
+			"Commands run without a shell, for at most %s.", strings.Join(r.allow, ", "), r.timeout),
+	}, func(ctx tool.Context, args Args) (Result, error) {
+		return r.Run(ctx, args)
+	})
+}
+
+// Run runs the command of args, rejecting programs that are not allowed and
+// working directories outside the root
+func (r *Runner) Run(ctx context.Context, args Args) (Result, error) {
+	argv, err := Split(args.Command)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(argv) == 0 {
+		return Result{}, fmt.Errorf("command is empty")
+	}
+	if !slices.Contains(r.allow, argv[0]) {
+		return Result{}, fmt.Errorf("command %q is not allowed, allowed: %s", argv[0], strings.Join(r.allow, ", "))
+	}
+	dir, err := r.workDir(args.Dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = r.env
+	cmd.WaitDelay = time.Second // Stop waiting for output held open by children
+	stdout := &cappedBuffer{max: r.maxOutput}
+	stderr := &cappedBuffer{max: r.maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	result := Result{
+		ExitCode:  cmd.ProcessState.ExitCode(),
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+		TimedOut:  errors.Is(ctx.Err(), context.DeadlineExceeded),
+	}
+	r.logger.Info("Shell command finished", "command", argv[0], "dir", dir, "exit_code", result.ExitCode,
+		"timed_out", result.TimedOut, "duration", time.Since(start))
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && !result.TimedOut {
+		return Result{}, fmt.Errorf("failed to run %s: %w", argv[0], err)
+	}
+	return result, nil
+}
+
+// workDir resolves dir against the root, following symlinks, and rejects
+// directories outside it
+func (r *Runner) workDir(dir string) (string, error) {
+	if filepath.IsAbs(dir) {
+		return "", fmt.Errorf("directory %q must be relative to the workspace root", dir)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(r.dir, dir))
+	if err != nil {
+		return "", fmt.Errorf("invalid directory %q: %w", dir, err)
+	}
+	rel, err := filepath.Rel(r.dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("directory %q is outside the workspace root", dir)
+	}
+	return resolved, nil
+}
+
+// Split splits a command line into words. Single and double quotes group
+// words and a backslash escapes the next character outside single quotes;
+// nothing else is special.
+func Split(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in command %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// cappedBuffer keeps the first max bytes written and discards the rest
+type cappedBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room < len(p) {
+		b.buf = append(b.buf, p[:max(room, 0)]...)
+		b.truncated = true
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	return string(b.buf)
+}
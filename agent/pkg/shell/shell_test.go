@@ -0,0 +1,95 @@
+package shell
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSplit tests quoting and escaping of command lines
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"git status --short", []string{"git", "status", "--short"}, false},
+		{`grep -rn "two words" .`, []string{"grep", "-rn", "two words", "."}, false},
+		{`echo 'a "b"' c\ d ""`, []string{"echo", `a "b"`, "c d", ""}, false},
+		{"ls | wc -l; rm -rf /", []string{"ls", "|", "wc", "-l;", "rm", "-rf", "/"}, false},
+		{"  ", nil, false},
+		{`echo "open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := Split(tt.line)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("Split(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+// TestRun tests the allowlist, directory restriction, timeout and output cap
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.TempDir(), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRET_API_KEY", "sk-secret")
+	r, err := New(&Config{
+		Allow:     []string{"echo", "sleep", "sh"},
+		Dir:       root,
+		Timeout:   200 * time.Millisecond,
+		MaxOutput: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if dir, err := r.workDir("sub/"); err != nil || filepath.Base(dir) != "sub" {
+		t.Errorf("workDir(sub/) = %q, %v", dir, err)
+	}
+
+	result, err := r.Run(ctx, Args{Command: "echo 0123456789"})
+	if err != nil || result.Stdout != "01234567" || !result.Truncated {
+		t.Errorf("Run(echo) = %+v, %v, want truncated output", result, err)
+	}
+
+	result, err = r.Run(ctx, Args{Command: `sh -c "exit 3"`})
+	if err != nil || result.ExitCode != 3 {
+		t.Errorf("Run(exit 3) = %+v, %v", result, err)
+	}
+
+	result, err = r.Run(ctx, Args{Command: "sleep 5"})
+	if err != nil || !result.TimedOut {
+		t.Errorf("Run(sleep) = %+v, %v, want a timeout", result, err)
+	}
+
+	if slices.ContainsFunc(r.env, func(v string) bool { return strings.Contains(v, "sk-secret") }) {
+		t.Errorf("env = %q, leaks SECRET_API_KEY", r.env)
+	}
+
+	for _, args := range []Args{
+		{Command: "rm -rf sub"},
+		{Command: "/bin/echo hi"},
+		{Command: "echo hi", Dir: ".."},
+		{Command: "echo hi", Dir: "escape"},
+		{Command: "echo hi", Dir: "/tmp"},
+		{Command: ""},
+	} {
+		if _, err := r.Run(ctx, args); err == nil {
+			t.Errorf("Run(%+v) succeeded, want an error", args)
+		}
+	}
+
+	if _, err := New(&Config{Allow: []string{"/bin/rm"}}); err == nil {
+		t.Error("New() accepted a command with a path")
+	}
+}
@@ -0,0 +1,127 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// client calls the Slack Web API
+type client struct {
+	http    *http.Client
+	baseURL string
+}
+
+// call posts a JSON request to an API method and decodes the response into
+// out, failing when Slack answers ok false
+func (c *client) call(ctx context.Context, method, token string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.baseURL, "/")+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed with status %d: %s", method, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("%s failed: %s", method, status.Error)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode %s response: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// openConnection returns the WebSocket URL of a Socket Mode connection
+func (c *client) openConnection(ctx context.Context, appToken string) (string, error) {
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := c.call(ctx, "apps.connections.open", appToken, struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+// botUserID returns the user ID of the bot, mentioned as <@ID>
+func (c *client) botUserID(ctx context.Context, botToken string) (string, error) {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.call(ctx, "auth.test", botToken, struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.UserID, nil
+}
+
+// postMessage posts text to a channel, in a thread when threadTS is set,
+// and returns the message's timestamp
+func (c *client) postMessage(ctx context.Context, botToken, channel, threadTS, text string) (string, error) {
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	req := map[string]string{"channel": channel, "text": text}
+	if threadTS != "" {
+		req["thread_ts"] = threadTS
+	}
+	if err := c.call(ctx, "chat.postMessage", botToken, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.TS, nil
+}
+
+// updateMessage replaces the text of a message
+func (c *client) updateMessage(ctx context.Context, botToken, channel, ts, text string) error {
+	return c.call(ctx, "chat.update", botToken, map[string]string{"channel": channel, "ts": ts, "text": text}, nil)
+}
+
+// respond answers a slash command through its response URL, visible only
+// to the user who ran it
+func (c *client) respond(ctx context.Context, responseURL, text string) error {
+	body, err := json.Marshal(map[string]string{"response_type": "ephemeral", "text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create response request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("response request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	boldPattern    = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	linkPattern    = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+	headingPattern = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+	bulletPattern  = regexp.MustCompile(`^(\s*)[-*]\s+`)
+)
+
+// mrkdwn converts the Markdown of model answers to Slack's mrkdwn: bold,
+// links, headings and bullets are rewritten outside code blocks, and &, <
+// and > are escaped as Slack requires
+func mrkdwn(text string) string {
+	text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+	lines := strings.Split(text, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if !inCode {
+				// Slack shows a language hint as text
+				lines[i] = "```"
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		line = headingPattern.ReplaceAllString(line, "**$1**")
+		line = bulletPattern.ReplaceAllString(line, "$1• ")
+		line = boldPattern.ReplaceAllString(line, "*$1*")
+		line = linkPattern.ReplaceAllString(line, "<$2|$1>")
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,348 @@
+// Package slack runs the agent as a Slack app over Socket Mode, so it needs
+// no public endpoint. A mention in a channel is answered in its thread and
+// each thread is a session; direct messages are answered in the
+// conversation, which is a session of its own.
+package slack
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/bot"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// maxMessageSize is the length of message text past which a reply continues
+// in another message, below Slack's own limits
+const maxMessageSize = 3900
+
+// Config holds Slack app configuration
+type Config struct {
+	AppToken string // App-level token (xapp-) with connections:write, for Socket Mode
+	BotToken string // Bot token (xoxb-) with app_mentions:read, chat:write and im:history
+	// SlashCommand is the command registered for the app, e.g. "/yanshu".
+	// Empty ignores slash commands.
+	SlashCommand string
+	// Placeholder is posted at once and edited as the reply streams,
+	// defaults to "Thinking…"
+	Placeholder string
+	// UpdateInterval is the least time between two edits of a reply,
+	// defaults to 1s to stay within Slack's rate limits
+	UpdateInterval time.Duration
+	// BaseURL is the Web API endpoint, defaults to https://slack.com/api
+	BaseURL    string
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// Launcher is the `slack` subcommand
+type Launcher struct {
+	cfg    Config
+	flags  *flag.FlagSet
+	api    *client
+	logger *slog.Logger
+
+	bot       *bot.Bot
+	botUserID string
+	mention   *regexp.Regexp
+}
+
+// NewLauncher creates the `slack` subcommand
+func NewLauncher(cfg *Config) *Launcher {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := *cfg
+	if c.Placeholder == "" {
+		c.Placeholder = "Thinking…"
+	}
+	if c.UpdateInterval <= 0 {
+		c.UpdateInterval = time.Second
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = "https://slack.com/api"
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("slack")
+	}
+	return &Launcher{
+		cfg:    c,
+		flags:  flag.NewFlagSet("slack", flag.ContinueOnError),
+		api:    &client{http: c.HTTPClient, baseURL: c.BaseURL},
+		logger: logger,
+	}
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *Launcher) Keyword() string {
+	return "slack"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *Launcher) SimpleDescription() string {
+	return "runs the agent as a Slack app over Socket Mode"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *Launcher) CommandLineSyntax() string {
+	return ""
+}
+
+// Parse implements launcher.SubLauncher. The subcommand has no flags.
+func (l *Launcher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse slack flags: %w", err)
+	}
+	return l.flags.Args(), nil
+}
+
+// Execute implements launcher.Launcher
+func (l *Launcher) Execute(ctx context.Context, config *launcher.Config, args []string) error {
+	rest, err := l.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	return l.Run(ctx, config)
+}
+
+// Run implements launcher.SubLauncher. It answers Slack until ctx is done,
+// reconnecting when Slack closes the connection.
+func (l *Launcher) Run(ctx context.Context, config *launcher.Config) error {
+	if l.cfg.AppToken == "" || l.cfg.BotToken == "" {
+		return fmt.Errorf("slack.app_token and slack.bot_token are required")
+	}
+	b, err := bot.New(config, &bot.Config{Platform: "slack", UpdateInterval: l.cfg.UpdateInterval, Logger: l.logger})
+	if err != nil {
+		return err
+	}
+	l.bot = b
+	if l.botUserID, err = l.api.botUserID(ctx, l.cfg.BotToken); err != nil {
+		return fmt.Errorf("failed to authenticate the bot token: %w", err)
+	}
+	l.mention = regexp.MustCompile(`<@` + regexp.QuoteMeta(l.botUserID) + `(\|[^>]*)?>`)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	backoff := time.Second
+	for {
+		connected, err := l.connect(ctx, &wg)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if connected {
+			backoff = time.Second
+		}
+		l.logger.Warn("Slack connection closed, reconnecting", "error", err, "in", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// envelope is a Socket Mode message
+type envelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"` // Of a disconnect
+}
+
+// connect opens a Socket Mode connection and serves it until Slack or ctx
+// closes it. It reports whether the connection was established.
+func (l *Launcher) connect(ctx context.Context, wg *sync.WaitGroup) (bool, error) {
+	url, err := l.api.openConnection(ctx, l.cfg.AppToken)
+	if err != nil {
+		return false, err
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	for {
+		var env envelope
+		if err := ws.ReadJSON(&env); err != nil {
+			return true, err
+		}
+		// Slack redelivers envelopes not acknowledged within 3s
+		if env.EnvelopeID != "" {
+			if err := ws.WriteJSON(map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				return true, fmt.Errorf("failed to acknowledge: %w", err)
+			}
+		}
+		switch env.Type {
+		case "hello":
+			l.logger.Info("Connected to Slack", "bot_user", l.botUserID)
+		case "disconnect":
+			return true, fmt.Errorf("disconnected by Slack: %s", env.Reason)
+		case "events_api":
+			wg.Go(func() { l.handleEvent(ctx, env.Payload) })
+		case "slash_commands":
+			wg.Go(func() { l.handleCommand(ctx, env.Payload) })
+		}
+	}
+}
+
+// messageEvent is an app_mention or message event
+type messageEvent struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	ChannelType string `json:"channel_type"`
+	Channel     string `json:"channel"`
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+}
+
+// handleEvent answers mentions in channels and direct messages
+func (l *Launcher) handleEvent(ctx context.Context, payload json.RawMessage) {
+	var callback struct {
+		Event messageEvent `json:"event"`
+	}
+	if err := json.Unmarshal(payload, &callback); err != nil {
+		l.logger.Warn("Invalid Slack event", "error", err)
+		return
+	}
+	e := callback.Event
+	// Edits, joins and the bot's own messages have a subtype or a bot ID
+	if e.Subtype != "" || e.BotID != "" || e.User == l.botUserID {
+		return
+	}
+
+	var threadTS, conversation string
+	switch {
+	case e.Type == "app_mention":
+		threadTS = cmp.Or(e.ThreadTS, e.TS)
+		conversation = e.Channel + "-" + threadTS
+	case e.Type == "message" && e.ChannelType == "im":
+		threadTS = e.ThreadTS
+		conversation = e.Channel
+		if threadTS != "" {
+			conversation += "-" + threadTS
+		}
+	default:
+		return
+	}
+	text := strings.TrimSpace(l.mention.ReplaceAllString(e.Text, ""))
+	if text == "" {
+		return
+	}
+	l.reply(ctx, e.Channel, threadTS, conversation, text)
+}
+
+// handleCommand runs a slash command: "reset" starts the direct message
+// conversation over, "help" explains the command and any other text is a
+// question, answered in a thread of the channel that continues the session
+func (l *Launcher) handleCommand(ctx context.Context, payload json.RawMessage) {
+	var cmd struct {
+		Command     string `json:"command"`
+		Text        string `json:"text"`
+		ChannelID   string `json:"channel_id"`
+		UserID      string `json:"user_id"`
+		ResponseURL string `json:"response_url"`
+	}
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		l.logger.Warn("Invalid Slack slash command", "error", err)
+		return
+	}
+	if l.cfg.SlashCommand == "" || cmd.Command != l.cfg.SlashCommand {
+		return
+	}
+	respond := func(text string) {
+		if err := l.api.respond(ctx, cmd.ResponseURL, text); err != nil {
+			l.logger.Warn("Failed to answer Slack slash command", "error", err)
+		}
+	}
+
+	text := strings.TrimSpace(cmd.Text)
+	switch text {
+	case "", "help":
+		respond(fmt.Sprintf("`%[1]s <question>` asks in a new thread, mention me in the thread to follow up. `%[1]s reset` starts our direct messages over.", cmd.Command))
+	case "reset":
+		if err := l.bot.Reset(ctx, cmd.ChannelID); err != nil {
+			respond(":warning: " + err.Error())
+			return
+		}
+		respond("Starting over.")
+	default:
+		ts, err := l.api.postMessage(ctx, l.cfg.BotToken, cmd.ChannelID, "", fmt.Sprintf("<@%s> asked: %s", cmd.UserID, text))
+		if err != nil {
+			respond(fmt.Sprintf(":warning: I cannot post here, invite me to the channel first (%v)", err))
+			return
+		}
+		l.reply(ctx, cmd.ChannelID, ts, cmd.ChannelID+"-"+ts, text)
+	}
+}
+
+// reply posts the placeholder and edits it as the agent's reply streams,
+// continuing in more messages when the reply is too long for one
+func (l *Launcher) reply(ctx context.Context, channel, threadTS, conversation, text string) {
+	ts, err := l.api.postMessage(ctx, l.cfg.BotToken, channel, threadTS, "_"+l.cfg.Placeholder+"_")
+	if err != nil {
+		l.logger.Warn("Failed to post Slack reply", "channel", channel, "error", err)
+		return
+	}
+	var shown string // Text of the placeholder
+	update := func(ctx context.Context, reply string) error {
+		text := bot.Split(mrkdwn(reply), maxMessageSize)[0]
+		if err := l.api.updateMessage(ctx, l.cfg.BotToken, channel, ts, text); err != nil {
+			return err
+		}
+		shown = text
+		return nil
+	}
+
+	reply, err := l.bot.Reply(ctx, conversation, text, update)
+	switch {
+	case errors.Is(err, context.Canceled):
+		reply = ":warning: Interrupted, the app is shutting down."
+	case err != nil:
+		l.logger.Warn("Slack turn failed", "conversation", conversation, "error", err)
+		reply = ":warning: " + err.Error()
+	case strings.TrimSpace(reply) == "":
+		reply = "_No answer._"
+	default:
+		reply = mrkdwn(reply)
+	}
+	// The final edits outlive a shutdown so the placeholder is not left
+	ctx = context.WithoutCancel(ctx)
+	parts := bot.Split(reply, maxMessageSize)
+	if parts[0] != shown {
+		if err := l.api.updateMessage(ctx, l.cfg.BotToken, channel, ts, parts[0]); err != nil {
+			l.logger.Warn("Failed to update Slack reply", "channel", channel, "error", err)
+		}
+	}
+	for _, part := range parts[1:] {
+		if _, err := l.api.postMessage(ctx, l.cfg.BotToken, channel, threadTS, part); err != nil {
+			l.logger.Warn("Failed to post Slack reply", "channel", channel, "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// echoModel answers with the user message
+type echoModel struct{}
+
+func (echoModel) Name() string { return "echo" }
+
+func (echoModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := req.Contents[len(req.Contents)-1].Parts[0].Text
+		yield(&model.LLMResponse{Content: genai.NewContentFromText("**"+text+"**", genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// fakeSlack serves the Web API methods and a Socket Mode connection
+// delivering envelopes
+type fakeSlack struct {
+	*httptest.Server
+	envelopes []string
+
+	mu    sync.Mutex
+	calls []string // Method and text of each call
+	acks  []string
+}
+
+func newFakeSlack(t *testing.T, envelopes ...string) *fakeSlack {
+	f := &fakeSlack{envelopes: envelopes}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/api/")
+		switch method {
+		case "socket":
+			ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade() error = %v", err)
+				return
+			}
+			defer ws.Close()
+			ws.WriteJSON(map[string]string{"type": "hello"})
+			for _, env := range f.envelopes {
+				ws.WriteMessage(websocket.TextMessage, []byte(env))
+			}
+			for {
+				var ack map[string]string
+				if err := ws.ReadJSON(&ack); err != nil {
+					return
+				}
+				f.mu.Lock()
+				f.acks = append(f.acks, ack["envelope_id"])
+				f.mu.Unlock()
+			}
+		case "apps.connections.open":
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "url": "ws" + strings.TrimPrefix(f.URL, "http") + "/api/socket"})
+		case "auth.test":
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "user_id": "UBOT"})
+		default:
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			f.mu.Lock()
+			f.calls = append(f.calls, method+" "+req["channel"]+" "+req["thread_ts"]+" "+req["text"])
+			f.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "ts": "2.0"})
+		}
+	}))
+	return f
+}
+
+// TestLauncher tests answering a mention in its thread and ignoring the
+// bot's own messages
+func TestLauncher(t *testing.T) {
+	slack := newFakeSlack(t,
+		`{"type":"events_api","envelope_id":"e1","payload":{"event":{"type":"app_mention","channel":"C1","user":"U1","text":"<@UBOT> hello","ts":"1.0"}}}`,
+		`{"type":"events_api","envelope_id":"e2","payload":{"event":{"type":"message","channel_type":"im","channel":"D1","user":"UBOT","bot_id":"B1","text":"loop","ts":"1.5"}}}`,
+	)
+	defer slack.Close()
+
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLauncher(&Config{AppToken: "xapp", BotToken: "xoxb", BaseURL: slack.URL + "/api"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Run(ctx, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	}()
+
+	want := []string{
+		"chat.postMessage C1 1.0 _Thinking…_",
+		"chat.update C1  *hello*",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		slack.mu.Lock()
+		calls, acks := strings.Join(slack.calls, "\n"), strings.Join(slack.acks, " ")
+		slack.mu.Unlock()
+		if calls == strings.Join(want, "\n") && acks == "e1 e2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("calls = %q, acks = %q; want %q and e1 e2", calls, acks, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestMrkdwn(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"bold", "a **b** c", "a *b* c"},
+		{"heading", "## Title", "*Title*"},
+		{"bullet", "- one\n  * two", "• one\n  • two"},
+		{"link", "see [docs](https://go.dev/doc)", "see <https://go.dev/doc|docs>"},
+		{"escape", "a < b & c", "a &lt; b &amp; c"},
+		{"code", "```go\n**x** - y\n```", "```\n**x** - y\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mrkdwn(tt.in); got != tt.want {
+				t.Errorf("mrkdwn(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// client calls the Telegram Bot API
+type client struct {
+	http    *http.Client
+	baseURL string // Ends with the bot token path, e.g. https://api.telegram.org/bot<token>
+}
+
+// apiError is an error answered by the Bot API
+type apiError struct {
+	Method      string
+	Code        int
+	Description string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s failed (%d): %s", e.Method, e.Code, e.Description)
+}
+
+// call posts a JSON request to an API method and decodes its result into out
+func (c *client) call(ctx context.Context, method string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, method, out)
+}
+
+// upload posts a multipart request to an API method, sending data as the
+// file field name, and decodes its result into out
+func (c *client) upload(ctx context.Context, method string, fields map[string]string, name, filename string, data []byte, out any) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		w.WriteField(key, fields[key])
+	}
+	part, err := w.CreateFormFile(name, filename)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	part.Write(data)
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+method, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return c.do(req, method, out)
+}
+
+// do sends a request to an API method and decodes its result into out
+func (c *client) do(req *http.Request, method string, out any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The URL holds the token
+		return fmt.Errorf("%s request failed: %w", method, redact(err, c.baseURL))
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", method, err)
+	}
+	var result struct {
+		OK          bool            `json:"ok"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to decode %s response (status %d): %w", method, resp.StatusCode, err)
+	}
+	if !result.OK {
+		return &apiError{Method: method, Code: result.ErrorCode, Description: result.Description}
+	}
+	if out != nil {
+		if err := json.Unmarshal(result.Result, out); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// redact removes the token-bearing base URL from a transport error
+func redact(err error, baseURL string) error {
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), baseURL, "<api>"))
+}
+
+// user is a Telegram user or bot
+type user struct {
+	ID       int64  `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Username string `json:"username"`
+}
+
+// chat is a private chat, group or channel
+type chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"` // private, group, supergroup or channel
+}
+
+// message is a message of a chat
+type message struct {
+	MessageID       int64    `json:"message_id"`
+	MessageThreadID int64    `json:"message_thread_id"` // Forum topic
+	From            *user    `json:"from"`
+	Chat            chat     `json:"chat"`
+	Text            string   `json:"text"`
+	ReplyToMessage  *message `json:"reply_to_message"`
+}
+
+// update is an incoming update
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+// getMe returns the bot's user
+func (c *client) getMe(ctx context.Context) (*user, error) {
+	var me user
+	if err := c.call(ctx, "getMe", struct{}{}, &me); err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// getUpdates long polls for the updates after offset
+func (c *client) getUpdates(ctx context.Context, offset int64, timeoutSeconds int) ([]update, error) {
+	var updates []update
+	req := map[string]any{"offset": offset, "timeout": timeoutSeconds, "allowed_updates": []string{"message"}}
+	if err := c.call(ctx, "getUpdates", req, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// setWebhook has Telegram post updates to url with the secret in the
+// X-Telegram-Bot-Api-Secret-Token header
+func (c *client) setWebhook(ctx context.Context, url, secret string) error {
+	req := map[string]any{"url": url, "allowed_updates": []string{"message"}}
+	if secret != "" {
+		req["secret_token"] = secret
+	}
+	return c.call(ctx, "setWebhook", req, nil)
+}
+
+// deleteWebhook stops webhook delivery so updates can be polled
+func (c *client) deleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", struct{}{}, nil)
+}
+
+// sendMessage sends text, as HTML when html is set, and returns the message
+// ID
+func (c *client) sendMessage(ctx context.Context, chatID, threadID, replyTo int64, text string, html bool) (int64, error) {
+	req := map[string]any{"chat_id": chatID, "text": text}
+	if threadID != 0 {
+		req["message_thread_id"] = threadID
+	}
+	if replyTo != 0 {
+		req["reply_parameters"] = map[string]any{"message_id": replyTo, "allow_sending_without_reply": true}
+	}
+	if html {
+		req["parse_mode"] = "HTML"
+	}
+	var sent message
+	if err := c.call(ctx, "sendMessage", req, &sent); err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+// editMessageText replaces the text of a message, as HTML when html is set
+func (c *client) editMessageText(ctx context.Context, chatID, messageID int64, text string, html bool) error {
+	req := map[string]any{"chat_id": chatID, "message_id": messageID, "text": text}
+	if html {
+		req["parse_mode"] = "HTML"
+	}
+	err := c.call(ctx, "editMessageText", req, nil)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// sendTyping shows the bot as typing in the chat for about 5s
+func (c *client) sendTyping(ctx context.Context, chatID, threadID int64) error {
+	req := map[string]any{"chat_id": chatID, "action": "typing"}
+	if threadID != 0 {
+		req["message_thread_id"] = threadID
+	}
+	return c.call(ctx, "sendChatAction", req, nil)
+}
+
+// sendAudio sends speech as a reply, as a voice message when it is Ogg Opus
+// and as an audio file otherwise
+func (c *client) sendAudio(ctx context.Context, chatID, threadID, replyTo int64, audio []byte, format string) error {
+	fields := map[string]string{"chat_id": strconv.FormatInt(chatID, 10)}
+	if threadID != 0 {
+		fields["message_thread_id"] = strconv.FormatInt(threadID, 10)
+	}
+	if replyTo != 0 {
+		fields["reply_parameters"] = fmt.Sprintf(`{"message_id":%d,"allow_sending_without_reply":true}`, replyTo)
+	}
+	if format == "opus" {
+		return c.upload(ctx, "sendVoice", fields, "voice", "answer.ogg", audio, nil)
+	}
+	return c.upload(ctx, "sendAudio", fields, "audio", "answer."+format, audio, nil)
+}
@@ -0,0 +1,87 @@
+package telegram
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	codePattern    = regexp.MustCompile("`([^`\n]+)`")
+	boldPattern    = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	italicPattern  = regexp.MustCompile(`(^|[^\w*])\*([^*\s][^*]*?)\*`)
+	strikePattern  = regexp.MustCompile(`~~(.+?)~~`)
+	linkPattern    = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+	headingPattern = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+	bulletPattern  = regexp.MustCompile(`^(\s*)[-*]\s+`)
+	tagPattern     = regexp.MustCompile(`<[^>]+>`)
+)
+
+// renderHTML converts the Markdown of model answers to the HTML subset of
+// Telegram messages. Code blocks become pre elements, an unterminated one,
+// as in a streaming reply, closed at the end.
+func renderHTML(text string) string {
+	var out, code []string
+	var lang string
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		if fence, ok := strings.CutPrefix(strings.TrimSpace(line), "```"); ok {
+			if inCode {
+				out = append(out, renderCode(lang, code))
+				code = nil
+			} else {
+				lang = strings.TrimSpace(fence)
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			code = append(code, line)
+			continue
+		}
+		out = append(out, renderLine(line))
+	}
+	if inCode {
+		out = append(out, renderCode(lang, code))
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderCode renders the lines of a code block
+func renderCode(lang string, lines []string) string {
+	open := "<pre><code>"
+	if lang != "" {
+		open = `<pre><code class="language-` + html.EscapeString(lang) + `">`
+	}
+	return open + html.EscapeString(strings.Join(lines, "\n")) + "</code></pre>"
+}
+
+// renderLine converts the inline Markdown of a line, leaving code spans as
+// they are
+func renderLine(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range codePattern.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(renderInline(line[last:m[0]]))
+		b.WriteString("<code>" + html.EscapeString(line[m[2]:m[3]]) + "</code>")
+		last = m[1]
+	}
+	b.WriteString(renderInline(line[last:]))
+	return b.String()
+}
+
+// renderInline converts headings, bullets, emphasis and links
+func renderInline(s string) string {
+	s = html.EscapeString(s)
+	s = headingPattern.ReplaceAllString(s, "<b>$1</b>")
+	s = bulletPattern.ReplaceAllString(s, "$1• ")
+	s = boldPattern.ReplaceAllString(s, "<b>$1$2</b>")
+	s = italicPattern.ReplaceAllString(s, "$1<i>$2</i>")
+	s = strikePattern.ReplaceAllString(s, "<s>$1</s>")
+	return linkPattern.ReplaceAllString(s, `<a href="$2">$1</a>`)
+}
+
+// htmlToText returns the text of rendered HTML, for sending it as plain text
+func htmlToText(s string) string {
+	return html.UnescapeString(tagPattern.ReplaceAllString(s, ""))
+}
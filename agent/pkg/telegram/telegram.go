@@ -0,0 +1,402 @@
+// Package telegram runs the agent as a Telegram bot. Each chat, or forum
+// topic, is a session; in groups the bot answers messages that mention it
+// or reply to it. Updates arrive by long polling or, given a public URL, by
+// webhook.
+package telegram
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/bot"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/cmd/launcher"
+)
+
+// maxMessageSize is the length of message text past which a reply continues
+// in another message, below Telegram's limit of 4096 characters
+const maxMessageSize = 3800
+
+// Config holds Telegram bot configuration
+type Config struct {
+	Token string // From @BotFather
+	// WebhookURL is the public HTTPS URL Telegram posts updates to. Empty
+	// long polls instead.
+	WebhookURL string
+	// Listen is the address of the webhook server, defaults to ":8443"
+	Listen string
+	// WebhookSecret is checked against the X-Telegram-Bot-Api-Secret-Token
+	// header of webhook requests
+	WebhookSecret string
+	// AllowedChats restricts the bot to these chat IDs, any when empty
+	AllowedChats []int64
+	// UpdateInterval is the least time between two edits of a reply,
+	// defaults to 1s to stay within Telegram's rate limits
+	UpdateInterval time.Duration
+	// Speaker, when set, also sends the reply as speech, a voice message
+	// when it is Ogg Opus
+	Speaker bot.Speaker
+	// BaseURL is the Bot API endpoint, defaults to https://api.telegram.org
+	BaseURL    string
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// Launcher is the `telegram` subcommand
+type Launcher struct {
+	cfg    Config
+	flags  *flag.FlagSet
+	api    *client
+	logger *slog.Logger
+
+	bot *bot.Bot
+	me  *user
+	wg  sync.WaitGroup
+}
+
+// NewLauncher creates the `telegram` subcommand
+func NewLauncher(cfg *Config) *Launcher {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := *cfg
+	if c.Listen == "" {
+		c.Listen = ":8443"
+	}
+	if c.UpdateInterval <= 0 {
+		c.UpdateInterval = time.Second
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = "https://api.telegram.org"
+	}
+	if c.HTTPClient == nil {
+		// Long enough for a long poll
+		c.HTTPClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("telegram")
+	}
+	return &Launcher{
+		cfg:    c,
+		flags:  flag.NewFlagSet("telegram", flag.ContinueOnError),
+		api:    &client{http: c.HTTPClient, baseURL: strings.TrimSuffix(c.BaseURL, "/") + "/bot" + c.Token},
+		logger: logger,
+	}
+}
+
+// Keyword implements launcher.SubLauncher
+func (l *Launcher) Keyword() string {
+	return "telegram"
+}
+
+// SimpleDescription implements launcher.SubLauncher
+func (l *Launcher) SimpleDescription() string {
+	return "runs the agent as a Telegram bot"
+}
+
+// CommandLineSyntax implements launcher.SubLauncher
+func (l *Launcher) CommandLineSyntax() string {
+	return ""
+}
+
+// Parse implements launcher.SubLauncher. The subcommand has no flags.
+func (l *Launcher) Parse(args []string) ([]string, error) {
+	if err := l.flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse telegram flags: %w", err)
+	}
+	return l.flags.Args(), nil
+}
+
+// Execute implements launcher.Launcher
+func (l *Launcher) Execute(ctx context.Context, config *launcher.Config, args []string) error {
+	rest, err := l.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	return l.Run(ctx, config)
+}
+
+// Run implements launcher.SubLauncher. It answers Telegram until ctx is
+// done, then waits for the replies being written.
+func (l *Launcher) Run(ctx context.Context, config *launcher.Config) error {
+	if l.cfg.Token == "" {
+		return fmt.Errorf("telegram.token is required")
+	}
+	b, err := bot.New(config, &bot.Config{Platform: "telegram", UpdateInterval: l.cfg.UpdateInterval, Logger: l.logger})
+	if err != nil {
+		return err
+	}
+	l.bot = b
+	if l.me, err = l.api.getMe(ctx); err != nil {
+		return fmt.Errorf("failed to authenticate the bot token: %w", err)
+	}
+	defer l.wg.Wait()
+
+	if l.cfg.WebhookURL != "" {
+		return l.serveWebhook(ctx)
+	}
+	return l.poll(ctx)
+}
+
+// poll long polls for updates until ctx is done
+func (l *Launcher) poll(ctx context.Context) error {
+	if err := l.api.deleteWebhook(ctx); err != nil {
+		return fmt.Errorf("failed to remove the webhook: %w", err)
+	}
+	l.logger.Info("Polling Telegram", "bot", l.me.Username)
+
+	var offset int64
+	backoff := time.Second
+	for {
+		updates, err := l.api.getUpdates(ctx, offset, 30)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			l.logger.Warn("Failed to get Telegram updates, retrying", "error", err, "in", backoff)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			l.handle(ctx, u)
+		}
+	}
+}
+
+// serveWebhook registers the webhook and serves it until ctx is done
+func (l *Launcher) serveWebhook(ctx context.Context) error {
+	u, err := url.Parse(l.cfg.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+cmp.Or(u.Path, "/"), l.webhook)
+	srv := &http.Server{Addr: l.cfg.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	if err := l.api.setWebhook(ctx, l.cfg.WebhookURL, l.cfg.WebhookSecret); err != nil {
+		srv.Close()
+		return fmt.Errorf("failed to set the webhook: %w", err)
+	}
+	l.logger.Info("Serving the Telegram webhook", "bot", l.me.Username, "addr", l.cfg.Listen, "path", u.Path)
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("webhook server failed: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	return nil
+}
+
+// webhook receives an update. It answers at once and replies in the
+// background, as Telegram redelivers updates that take long.
+func (l *Launcher) webhook(w http.ResponseWriter, req *http.Request) {
+	secret := req.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if l.cfg.WebhookSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(l.cfg.WebhookSecret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var u update
+	if err := json.NewDecoder(req.Body).Decode(&u); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	// The reply outlives the request
+	l.handle(context.WithoutCancel(req.Context()), u)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handle starts answering a message addressed to the bot
+func (l *Launcher) handle(ctx context.Context, u update) {
+	m := u.Message
+	if m == nil || m.From == nil || m.From.IsBot || strings.TrimSpace(m.Text) == "" {
+		return
+	}
+	if len(l.cfg.AllowedChats) > 0 && !slices.Contains(l.cfg.AllowedChats, m.Chat.ID) {
+		l.logger.Debug("Ignoring Telegram chat", "chat", m.Chat.ID)
+		return
+	}
+	text, ok := l.addressed(m)
+	if !ok {
+		return
+	}
+	l.wg.Go(func() { l.answer(ctx, m, text) })
+}
+
+// addressed returns the text of a message to the bot without the mention
+// of the bot. In private chats every message is; in groups, those that
+// mention the bot, reply to it or are commands.
+func (l *Launcher) addressed(m *message) (string, bool) {
+	mention := "@" + l.me.Username
+	text := strings.TrimSpace(m.Text)
+	if m.Chat.Type == "private" {
+		return strings.TrimSpace(strings.ReplaceAll(text, mention, "")), true
+	}
+	switch {
+	case strings.HasPrefix(text, "/"):
+		// Commands in groups may name the bot, e.g. /reset@yanshu_bot
+		cmd, rest, _ := strings.Cut(text, " ")
+		if name, bot, ok := strings.Cut(cmd, "@"); ok {
+			if !strings.EqualFold(bot, l.me.Username) {
+				return "", false
+			}
+			cmd = name
+		}
+		return strings.TrimSpace(cmd + " " + rest), true
+	case strings.Contains(strings.ToLower(text), strings.ToLower(mention)):
+		return strings.TrimSpace(strings.ReplaceAll(text, mention, "")), true
+	case m.ReplyToMessage != nil && m.ReplyToMessage.From != nil && m.ReplyToMessage.From.ID == l.me.ID:
+		return text, true
+	}
+	return "", false
+}
+
+// answer runs a command or replies to a message, showing the bot as typing
+// until the reply is complete
+func (l *Launcher) answer(ctx context.Context, m *message, text string) {
+	conversation := strconv.FormatInt(m.Chat.ID, 10)
+	if m.MessageThreadID != 0 {
+		conversation += "-" + strconv.FormatInt(m.MessageThreadID, 10)
+	}
+	send := func(text string) {
+		if _, err := l.api.sendMessage(ctx, m.Chat.ID, m.MessageThreadID, m.MessageID, text, false); err != nil {
+			l.logger.Warn("Failed to send Telegram message", "chat", m.Chat.ID, "error", err)
+		}
+	}
+	switch strings.Fields(text + " ")[0] {
+	case "/start", "/help":
+		send("Send me a message and I will answer. /reset starts our conversation over.")
+		return
+	case "/reset":
+		if err := l.bot.Reset(ctx, conversation); err != nil {
+			send("Failed to reset: " + err.Error())
+			return
+		}
+		send("Starting over.")
+		return
+	}
+
+	typingCtx, stopTyping := context.WithCancel(ctx)
+	defer stopTyping()
+	go l.typing(typingCtx, m.Chat.ID, m.MessageThreadID)
+
+	var messageID int64 // Of the reply, once the first text streamed
+	var shown string
+	update := func(ctx context.Context, reply string) error {
+		text := renderHTML(bot.Split(reply, maxMessageSize)[0])
+		if err := l.show(ctx, m, &messageID, text); err != nil {
+			return err
+		}
+		shown = text
+		return nil
+	}
+	reply, err := l.bot.Reply(ctx, conversation, text, update)
+	stopTyping()
+	switch {
+	case errors.Is(err, context.Canceled):
+		reply = "⚠️ Interrupted, the bot is shutting down."
+	case err != nil:
+		l.logger.Warn("Telegram turn failed", "conversation", conversation, "error", err)
+		reply = "⚠️ " + err.Error()
+	case strings.TrimSpace(reply) == "":
+		reply = "No answer."
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	parts := bot.Split(reply, maxMessageSize)
+	if text := renderHTML(parts[0]); text != shown {
+		if err := l.show(ctx, m, &messageID, text); err != nil {
+			l.logger.Warn("Failed to send Telegram reply", "chat", m.Chat.ID, "error", err)
+		}
+	}
+	for _, part := range parts[1:] {
+		var id int64
+		if err := l.show(ctx, m, &id, renderHTML(part)); err != nil {
+			l.logger.Warn("Failed to send Telegram reply", "chat", m.Chat.ID, "error", err)
+			return
+		}
+	}
+	if err == nil {
+		l.speak(ctx, m, reply)
+	}
+}
+
+// speak sends the reply as speech when a speaker is set
+func (l *Launcher) speak(ctx context.Context, m *message, reply string) {
+	if l.cfg.Speaker == nil || strings.TrimSpace(reply) == "" {
+		return
+	}
+	speech, err := l.cfg.Speaker.Speak(ctx, reply)
+	if err != nil {
+		l.logger.Warn("Failed to synthesize Telegram reply", "chat", m.Chat.ID, "error", err)
+		return
+	}
+	if err := l.api.sendAudio(ctx, m.Chat.ID, m.MessageThreadID, m.MessageID, speech.Audio, speech.Format); err != nil {
+		l.logger.Warn("Failed to send Telegram speech", "chat", m.Chat.ID, "error", err)
+	}
+}
+
+// show sends the reply as HTML, or edits it once sent, falling back to
+// plain text when Telegram rejects the HTML
+func (l *Launcher) show(ctx context.Context, m *message, messageID *int64, text string) error {
+	send := func(html bool, text string) error {
+		if *messageID != 0 {
+			return l.api.editMessageText(ctx, m.Chat.ID, *messageID, text, html)
+		}
+		id, err := l.api.sendMessage(ctx, m.Chat.ID, m.MessageThreadID, m.MessageID, text, html)
+		*messageID = id
+		return err
+	}
+	err := send(true, text)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "can't parse entities") {
+		err = send(false, htmlToText(text))
+	}
+	return err
+}
+
+// typing shows the bot as typing until ctx is done
+func (l *Launcher) typing(ctx context.Context, chatID, threadID int64) {
+	ticker := time.NewTicker(4 * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := l.api.sendTyping(ctx, chatID, threadID); err != nil && ctx.Err() == nil {
+			l.logger.Debug("Failed to send Telegram typing action", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
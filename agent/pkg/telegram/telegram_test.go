@@ -0,0 +1,163 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/cmd/launcher"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// echoModel answers with the user message in bold
+type echoModel struct{}
+
+func (echoModel) Name() string { return "echo" }
+
+func (echoModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		text := req.Contents[len(req.Contents)-1].Parts[0].Text
+		yield(&model.LLMResponse{Content: genai.NewContentFromText("**"+text+"**", genai.RoleModel), TurnComplete: true}, nil)
+	}
+}
+
+// TestLauncher tests polling, answering a private chat and ignoring group
+// messages not addressed to the bot
+func TestLauncher(t *testing.T) {
+	updates := `[
+		{"update_id":1,"message":{"message_id":10,"from":{"id":5},"chat":{"id":100,"type":"group"},"text":"chatting among ourselves"}},
+		{"update_id":2,"message":{"message_id":11,"from":{"id":5},"chat":{"id":200,"type":"private"},"text":"hello"}}
+	]`
+	var mu sync.Mutex
+	var calls []string
+	polled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/bottoken/")
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		result := "true"
+		switch method {
+		case "getMe":
+			result = `{"id":1,"is_bot":true,"username":"yanshu_bot"}`
+		case "getUpdates":
+			mu.Lock()
+			result, polled = "[]", true
+			if req["offset"].(float64) == 0 {
+				result = updates
+			}
+			mu.Unlock()
+		case "sendMessage", "editMessageText":
+			mu.Lock()
+			calls = append(calls, method+" "+req["parse_mode"].(string)+" "+req["text"].(string))
+			mu.Unlock()
+			result = `{"message_id":12,"chat":{"id":200}}`
+		}
+		w.Write([]byte(`{"ok":true,"result":` + result + `}`))
+	}))
+	defer srv.Close()
+
+	a, err := llmagent.New(llmagent.Config{Name: "echo", Model: echoModel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLauncher(&Config{Token: "token", BaseURL: srv.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Run(ctx, &launcher.Config{AgentLoader: agent.NewSingleLoader(a), SessionService: session.InMemoryService()})
+	}()
+
+	want := "sendMessage HTML <b>hello</b>"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := strings.Join(calls, "\n")
+		ok := polled
+		mu.Unlock()
+		if ok && got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("calls = %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	l := NewLauncher(&Config{Token: "token", WebhookSecret: "s3cret"})
+	for secret, want := range map[string]int{"": http.StatusForbidden, "wrong": http.StatusForbidden, "s3cret": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id":1}`))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		rec := httptest.NewRecorder()
+		l.webhook(rec, req)
+		if rec.Code != want {
+			t.Errorf("secret %q: status = %d, want %d", secret, rec.Code, want)
+		}
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"emphasis", "**bold**, *italic* and ~~gone~~", "<b>bold</b>, <i>italic</i> and <s>gone</s>"},
+		{"escape", "a < b && c", "a &lt; b &amp;&amp; c"},
+		{"heading and bullets", "# Title\n- one\n* two", "<b>Title</b>\n• one\n• two"},
+		{"link", "[Go](https://go.dev)", `<a href="https://go.dev">Go</a>`},
+		{"inline code", "run `a*b*c` now", "run <code>a*b*c</code> now"},
+		{"code block", "```go\nx := **1** < 2\n```", `<pre><code class="language-go">x := **1** &lt; 2</code></pre>`},
+		{"unterminated code block", "```\nfmt.Println(", "<pre><code>fmt.Println(</code></pre>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderHTML(tt.in); got != tt.want {
+				t.Errorf("renderHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSendAudio tests uploading speech as a voice message or an audio file
+func TestSendAudio(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/bottoken/")
+		for _, field := range []string{"voice", "audio"} {
+			file, header, err := r.FormFile(field)
+			if err != nil {
+				continue
+			}
+			data, _ := io.ReadAll(file)
+			got = append(got, method+" "+r.FormValue("chat_id")+" "+header.Filename+" "+string(data))
+		}
+		w.Write([]byte(`{"ok":true,"result":{"message_id":13,"chat":{"id":200}}}`))
+	}))
+	defer srv.Close()
+
+	c := &client{http: srv.Client(), baseURL: srv.URL + "/bottoken"}
+	if err := c.sendAudio(context.Background(), 200, 0, 11, []byte("OggS"), "opus"); err != nil {
+		t.Fatalf("sendAudio() error = %v", err)
+	}
+	if err := c.sendAudio(context.Background(), 200, 0, 11, []byte("ID3"), "mp3"); err != nil {
+		t.Fatalf("sendAudio() error = %v", err)
+	}
+	if want := "sendVoice 200 answer.ogg OggS\nsendAudio 200 answer.mp3 ID3"; strings.Join(got, "\n") != want {
+		t.Errorf("uploads = %q, want %q", strings.Join(got, "\n"), want)
+	}
+}
@@ -0,0 +1,172 @@
+package tokenizer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Tokenizer counts the tokens a model would see for a text
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+}
+
+// Ratios are the estimated tokens per character for each character class.
+// BPE vocabularies differ most on CJK text: a Chinese character costs between
+// roughly half a token and more than one token depending on the model family.
+type Ratios struct {
+	CJK   float64 // Han, Hiragana, Katakana and Hangul
+	Latin float64 // ASCII letters and digits
+	Space float64 // Whitespace, mostly merged into the following word
+	Other float64 // Punctuation, symbols and other scripts
+}
+
+// Estimator is a Tokenizer that estimates counts from per-class character ratios
+type Estimator struct {
+	name   string
+	ratios Ratios
+}
+
+// NewEstimator creates an estimating tokenizer
+func NewEstimator(name string, ratios Ratios) *Estimator {
+	return &Estimator{name: name, ratios: ratios}
+}
+
+// Name implements Tokenizer
+func (e *Estimator) Name() string {
+	return e.name
+}
+
+// Count implements Tokenizer
+func (e *Estimator) Count(text string) int {
+	var total float64
+	for _, r := range text {
+		switch {
+		case IsCJK(r):
+			total += e.ratios.CJK
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			total += e.ratios.Latin
+		case unicode.IsSpace(r):
+			total += e.ratios.Space
+		default:
+			total += e.ratios.Other
+		}
+	}
+	return int(math.Ceil(total))
+}
+
+// IsCJK reports whether r is a Chinese, Japanese or Korean character
+func IsCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Built-in estimators, calibrated against the providers' published ratios.
+// They only approximate the vocabularies of each family; the OpenAI ones are
+// named after their ratios since they do not encode with OpenAI's BPE ranks.
+var (
+	Generic      = NewEstimator("generic", Ratios{CJK: 1.0, Latin: 0.25, Space: 0.05, Other: 0.5})
+	DeepSeek     = NewEstimator("deepseek", Ratios{CJK: 0.6, Latin: 0.3, Space: 0.05, Other: 0.3})
+	OpenAILegacy = NewEstimator("ratio-openai-legacy", Ratios{CJK: 1.1, Latin: 0.25, Space: 0.05, Other: 0.5}) // GPT-3.5 and GPT-4
+	OpenAI       = NewEstimator("ratio-openai", Ratios{CJK: 0.75, Latin: 0.25, Space: 0.05, Other: 0.4})       // GPT-4o and later
+	Qwen         = NewEstimator("qwen", Ratios{CJK: 0.67, Latin: 0.25, Space: 0.05, Other: 0.4})
+	GLM          = NewEstimator("glm", Ratios{CJK: 0.6, Latin: 0.25, Space: 0.05, Other: 0.4})
+)
+
+var (
+	mu     sync.RWMutex
+	byName = map[string]Tokenizer{}
+
+	// models maps model name prefixes to tokenizers; longer prefixes win
+	models = map[string]Tokenizer{}
+)
+
+func init() {
+	for _, t := range []Tokenizer{Generic, DeepSeek, OpenAILegacy, OpenAI, Qwen, GLM} {
+		byName[t.Name()] = t
+	}
+
+	for prefix, t := range map[string]Tokenizer{
+		"deepseek":       DeepSeek,
+		"gpt-3.5":        OpenAILegacy,
+		"gpt-4":          OpenAILegacy,
+		"gpt-4o":         OpenAI,
+		"gpt-4.1":        OpenAI,
+		"gpt-4.5":        OpenAI,
+		"gpt-5":          OpenAI,
+		"o1":             OpenAI,
+		"o3":             OpenAI,
+		"o4":             OpenAI,
+		"chatgpt":        OpenAI,
+		"qwen":           Qwen,
+		"qwq":            Qwen,
+		"glm":            GLM,
+		"chatglm":        GLM,
+		"text-embedding": OpenAILegacy,
+	} {
+		models[prefix] = t
+	}
+}
+
+// Register makes t available by name and selects it for models whose name
+// starts with any of prefixes. It is the hook for real (BPE) tokenizers,
+// which replace the built-in estimators for those prefixes.
+func Register(t Tokenizer, prefixes ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	byName[t.Name()] = t
+	for _, p := range prefixes {
+		models[strings.ToLower(p)] = t
+	}
+}
+
+// ByName returns the tokenizer registered under name
+func ByName(name string) (Tokenizer, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if t, ok := byName[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown tokenizer %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// ForModel selects the tokenizer for a model name. Provider prefixes such as
+// "deepseek/" or "openai/" (used by routers) are ignored when matching.
+func ForModel(modelName string) Tokenizer {
+	name := strings.ToLower(modelName)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	var best Tokenizer
+	bestLen := -1
+	for prefix, t := range models {
+		if strings.HasPrefix(name, prefix) && len(prefix) > bestLen {
+			best, bestLen = t, len(prefix)
+		}
+	}
+	if best == nil {
+		return Generic
+	}
+	return best
+}
+
+// Select returns the tokenizer named by name, or the one for modelName when
+// name is empty or "auto"
+func Select(name, modelName string) (Tokenizer, error) {
+	if name == "" || strings.EqualFold(name, "auto") {
+		return ForModel(modelName), nil
+	}
+	return ByName(name)
+}
@@ -0,0 +1,61 @@
+package tokenizer
+
+import "testing"
+
+// TestForModel tests model name based tokenizer selection
+func TestForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"deepseek-chat", "deepseek"},
+		{"gpt-4-turbo", "ratio-openai-legacy"},
+		{"gpt-4o-mini", "ratio-openai"},
+		{"openai/gpt-4.1", "ratio-openai"},
+		{"qwen-max", "qwen"},
+		{"glm-4-plus", "glm"},
+		{"llama3", "generic"},
+	}
+	for _, tt := range tests {
+		if got := ForModel(tt.model).Name(); got != tt.want {
+			t.Errorf("ForModel(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+// TestEstimatorCount tests that CJK and Latin text are counted with their own ratios
+func TestEstimatorCount(t *testing.T) {
+	tests := []struct {
+		name string
+		tok  Tokenizer
+		text string
+		want int
+	}{
+		{"empty", DeepSeek, "", 0},
+		{"english", DeepSeek, "hello world", 4},            // 10 letters * 0.3 + 1 space * 0.05
+		{"chinese", DeepSeek, "你好世界", 3},                   // 4 * 0.6
+		{"chinese openai legacy", OpenAILegacy, "你好世界", 5}, // 4 * 1.1
+		{"mixed", Qwen, "Go语言", 2},                         // 2 * 0.25 + 2 * 0.67
+		{"japanese", OpenAI, "こんにちは", 4},                   // 5 * 0.75
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tok.Count(tt.text); got != tt.want {
+				t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSelect tests explicit and automatic tokenizer selection
+func TestSelect(t *testing.T) {
+	if tok, err := Select("auto", "deepseek-reasoner"); err != nil || tok.Name() != "deepseek" {
+		t.Errorf("Select(auto) = %v, %v", tok, err)
+	}
+	if tok, err := Select("ratio-openai", "deepseek-chat"); err != nil || tok.Name() != "ratio-openai" {
+		t.Errorf("Select(ratio-openai) = %v, %v", tok, err)
+	}
+	if _, err := Select("unknown", ""); err == nil {
+		t.Error("Select(unknown) should fail")
+	}
+}
@@ -0,0 +1,199 @@
+// Package toolloop caps the model and tool round-trips of a turn, so an
+// agent stuck calling tools in a loop cannot burn tokens indefinitely. When
+// the cap is reached the turn stops with its partial answer, the model is
+// asked to summarize without tools, or the turn fails.
+package toolloop
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/instruction"
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// Action is what happens to a turn reaching the iteration cap
+type Action string
+
+const (
+	// ActionStop ends the turn with the text the model wrote so far,
+	// followed by the stop message
+	ActionStop Action = "stop"
+	// ActionSummarize lets the model give one last answer without tools,
+	// summarizing the work so far
+	ActionSummarize Action = "summarize"
+	// ActionError fails the turn with an *ExceededError
+	ActionError Action = "error"
+)
+
+// DefaultSummaryInstruction is appended to the system instruction of the
+// wrap-up call, used when the config leaves it empty
+const DefaultSummaryInstruction = "You have reached the limit of tool calls for this turn and no more tools can be called. " +
+	"Answer now with a concise summary of what you found and did so far, and what is left to do."
+
+// DefaultStopMessage ends the partial answer of a stopped turn, used when the
+// config leaves it empty
+const DefaultStopMessage = "[Stopped: the limit of tool calls for this turn was reached.]"
+
+// MetadataExceeded is the CustomMetadata key set to the iteration count on
+// the answer ending a turn at the cap
+const MetadataExceeded = "tool_iterations_exceeded"
+
+// ErrExceeded is wrapped by every *ExceededError
+var ErrExceeded = errors.New("tool iterations exceeded")
+
+// ExceededError fails a turn reaching the cap with ActionError
+type ExceededError struct {
+	Iterations int    `json:"iterations"`
+	Max        int    `json:"max"`
+	Agent      string `json:"agent,omitempty"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("tool iterations exceeded: %d of %d", e.Iterations, e.Max)
+}
+
+func (e *ExceededError) Unwrap() error {
+	return ErrExceeded
+}
+
+// Config holds the iteration cap of an agent
+type Config struct {
+	// MaxIterations is the number of tool round-trips allowed per turn, the
+	// model call following the last one applying the action
+	MaxIterations int
+
+	Action             Action // Defaults to ActionStop
+	SummaryInstruction string // Defaults to DefaultSummaryInstruction
+	StopMessage        string // Defaults to DefaultStopMessage
+
+	Logger *slog.Logger
+}
+
+// Guard applies the iteration cap before each model call of an agent
+type Guard struct {
+	cfg    Config
+	logger *slog.Logger
+}
+
+// New creates a guard with the cap in cfg
+func New(cfg *Config) (*Guard, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	if cfg.MaxIterations <= 0 {
+		return nil, fmt.Errorf("max iterations must be positive, got %d", cfg.MaxIterations)
+	}
+	c := *cfg
+	c.Action = cmp.Or(c.Action, ActionStop)
+	switch c.Action {
+	case ActionStop, ActionSummarize, ActionError:
+	default:
+		return nil, fmt.Errorf("invalid tool iterations action %q (must be %q, %q or %q)", c.Action, ActionStop, ActionSummarize, ActionError)
+	}
+	c.SummaryInstruction = cmp.Or(c.SummaryInstruction, DefaultSummaryInstruction)
+	c.StopMessage = cmp.Or(c.StopMessage, DefaultStopMessage)
+
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("toolloop")
+	}
+	return &Guard{cfg: c, logger: logger}, nil
+}
+
+// ModelCallback returns the before-model callback counting the tool
+// round-trips of the turn in the request and applying the action once they
+// reach the cap
+func (g *Guard) ModelCallback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		iterations, partial := turn(req.Contents)
+		if iterations < g.cfg.MaxIterations {
+			return nil, nil
+		}
+		g.logger.WarnContext(ctx, "Tool iterations exceeded",
+			"agent", ctx.AgentName(),
+			"iterations", iterations,
+			"max", g.cfg.MaxIterations,
+			"action", g.cfg.Action,
+		)
+
+		switch g.cfg.Action {
+		case ActionError:
+			return nil, &ExceededError{Iterations: iterations, Max: g.cfg.MaxIterations, Agent: ctx.AgentName()}
+		case ActionSummarize:
+			wrapUp(req, g.cfg.SummaryInstruction)
+			return nil, nil
+		default:
+			text := g.cfg.StopMessage
+			if partial != "" {
+				text = partial + "\n\n" + text
+			}
+			return &model.LLMResponse{
+				Content:        genai.NewContentFromText(text, genai.RoleModel),
+				CustomMetadata: map[string]any{MetadataExceeded: iterations},
+			}, nil
+		}
+	}
+}
+
+// turn returns the tool round-trips since the last user message and the
+// text the model wrote in between
+func turn(contents []*genai.Content) (iterations int, partial string) {
+	start := 0
+	for i := len(contents) - 1; i >= 0; i-- {
+		if isUserMessage(contents[i]) {
+			start = i + 1
+			break
+		}
+	}
+	var texts []string
+	for _, content := range contents[start:] {
+		if content == nil {
+			continue
+		}
+		responded := false
+		for _, part := range content.Parts {
+			switch {
+			case part == nil:
+			case part.FunctionResponse != nil:
+				responded = true
+			case content.Role == genai.RoleModel && !part.Thought && strings.TrimSpace(part.Text) != "":
+				texts = append(texts, strings.TrimSpace(part.Text))
+			}
+		}
+		if responded {
+			iterations++
+		}
+	}
+	return iterations, strings.Join(texts, "\n\n")
+}
+
+// isUserMessage reports whether content is a message of the user rather than
+// tool responses
+func isUserMessage(content *genai.Content) bool {
+	if content == nil || content.Role != genai.RoleUser {
+		return false
+	}
+	for _, part := range content.Parts {
+		if part != nil && part.FunctionResponse != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// wrapUp removes the tools of req and appends instruction to its system
+// instruction. The config is copied, since it may be shared with the agent.
+func wrapUp(req *model.LLMRequest, text string) {
+	req.Tools = nil
+	instruction.AppendSystem(req, text)
+	req.Config.Tools = nil
+	req.Config.ToolConfig = nil
+}
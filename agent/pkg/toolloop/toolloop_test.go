@@ -0,0 +1,106 @@
+package toolloop
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"testing"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// loopModel writes a note and calls the ping tool whenever it has tools,
+// and answers with a summary when it has none
+type loopModel struct {
+	calls int
+}
+
+func (*loopModel) Name() string { return "loop" }
+
+func (m *loopModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.calls++
+		if len(req.Tools) == 0 {
+			yield(&model.LLMResponse{Content: genai.NewContentFromText("summary", genai.RoleModel)}, nil)
+			return
+		}
+		yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{
+			genai.NewPartFromText("checking"),
+			{FunctionCall: &genai.FunctionCall{ID: "call", Name: "ping", Args: map[string]any{}}},
+		}}}, nil)
+	}
+}
+
+type noArgs struct{}
+
+// run runs a turn of an agent calling tools in a loop under the cap, and
+// returns its last text, the model calls and the error ending the turn
+func run(t *testing.T, cfg *Config) (string, int, error) {
+	t.Helper()
+	guard, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping, _ := functiontool.New(functiontool.Config{Name: "ping", Description: "Pings"},
+		func(tool.Context, noArgs) (map[string]any, error) {
+			return map[string]any{"ok": true}, nil
+		})
+	llm := &loopModel{}
+	a, err := llmagent.New(llmagent.Config{
+		Name:                 "test",
+		Model:                llm,
+		Tools:                []tool.Tool{ping},
+		BeforeModelCallbacks: []llmagent.BeforeModelCallback{guard.ModelCallback()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, err := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "u"})
+	var last string
+	for event, err := range r.Run(ctx, "u", created.Session.ID(), genai.NewContentFromText("go", genai.RoleUser), agent.RunConfig{}) {
+		if err != nil {
+			return last, llm.calls, err
+		}
+		if event.Content != nil && len(event.Content.Parts) > 0 && event.Content.Parts[0].Text != "" {
+			last = event.Content.Parts[0].Text
+		}
+	}
+	return last, llm.calls, nil
+}
+
+// TestGuard tests each action applied once a turn reaches the cap
+func TestGuard(t *testing.T) {
+	got, calls, err := run(t, &Config{MaxIterations: 2})
+	want := "checking\n\nchecking\n\n" + DefaultStopMessage
+	if err != nil || got != want || calls != 2 {
+		t.Errorf("stop: got %q, %d calls, %v, want %q after 2 calls", got, calls, err, want)
+	}
+
+	got, calls, err = run(t, &Config{MaxIterations: 2, Action: ActionSummarize})
+	if err != nil || got != "summary" || calls != 3 {
+		t.Errorf("summarize: got %q, %d calls, %v, want the summary after 3 calls", got, calls, err)
+	}
+
+	_, calls, err = run(t, &Config{MaxIterations: 3, Action: ActionError})
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || !errors.Is(err, ErrExceeded) || exceeded.Iterations != 3 || calls != 3 {
+		t.Errorf("error: got %v after %d calls, want an *ExceededError after 3 calls", err, calls)
+	}
+
+	if _, err := New(&Config{MaxIterations: 1, Action: "retry"}); err == nil {
+		t.Error("New(invalid action) error = nil")
+	}
+}
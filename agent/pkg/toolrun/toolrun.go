@@ -0,0 +1,221 @@
+// Package toolrun enforces execution policies on tools: a timeout per call,
+// retries of failed calls and a cap on the size of results, so one slow or
+// verbose tool cannot stall a turn or flood the context.
+package toolrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+)
+
+// TruncationMarker ends the results cut at the size limit, %d being the limit
+const TruncationMarker = "\n[Result truncated: it exceeded %d bytes.]"
+
+// defaultRetryBackoff is the wait before the first retry, doubled on each
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// Policy is the execution policy of a tool. Zero values disable a limit.
+type Policy struct {
+	Timeout        time.Duration // Per attempt
+	Retries        int           // Attempts after a failed or timed out one
+	MaxResultBytes int           // Of the JSON encoded result
+}
+
+// Config holds the policies of the tools
+type Config struct {
+	// Default applies to every tool
+	Default Policy
+	// Tools overrides the default per tool name, field by field: zero
+	// fields keep the default
+	Tools map[string]Policy
+
+	RetryBackoff time.Duration // Wait before the first retry, doubled on each, defaults to 500ms
+	Logger       *slog.Logger
+}
+
+// policy returns the policy of the tool named name
+func (c *Config) policy(name string) Policy {
+	p := c.Default
+	if o, ok := c.Tools[name]; ok {
+		if o.Timeout != 0 {
+			p.Timeout = o.Timeout
+		}
+		if o.Retries != 0 {
+			p.Retries = o.Retries
+		}
+		if o.MaxResultBytes != 0 {
+			p.MaxResultBytes = o.MaxResultBytes
+		}
+	}
+	return p
+}
+
+// functionTool is a tool the agent runs, as ADK's flow sees it
+type functionTool interface {
+	tool.Tool
+	Declaration() *genai.FunctionDeclaration
+	Run(ctx tool.Context, args any) (map[string]any, error)
+	ProcessRequest(ctx tool.Context, req *model.LLMRequest) error
+}
+
+// Wrap returns tools with their policies enforced. Tools without a policy,
+// and tools ADK does not run as functions, are returned as they are.
+func Wrap(tools []tool.Tool, cfg *Config) ([]tool.Tool, error) {
+	if cfg == nil {
+		return tools, nil
+	}
+	if err := validPolicy(cfg.Default); err != nil {
+		return nil, fmt.Errorf("default policy: %w", err)
+	}
+	for name, p := range cfg.Tools {
+		if err := validPolicy(p); err != nil {
+			return nil, fmt.Errorf("policy of %s: %w", name, err)
+		}
+	}
+	backoff := cfg.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("toolrun")
+	}
+
+	wrapped := make([]tool.Tool, len(tools))
+	for i, t := range tools {
+		wrapped[i] = t
+		ft, ok := t.(functionTool)
+		if p := cfg.policy(t.Name()); ok && p != (Policy{}) {
+			wrapped[i] = &policyTool{functionTool: ft, policy: p, backoff: backoff, logger: logger}
+		}
+	}
+	return wrapped, nil
+}
+
+func validPolicy(p Policy) error {
+	if p.Timeout < 0 || p.Retries < 0 || p.MaxResultBytes < 0 {
+		return fmt.Errorf("timeout, retries and max result bytes must not be negative")
+	}
+	return nil
+}
+
+// policyTool runs a tool under its policy
+type policyTool struct {
+	functionTool
+	policy  Policy
+	backoff time.Duration
+	logger  *slog.Logger
+}
+
+// ProcessRequest declares the tool, registering the wrapper as the tool the
+// flow runs under its name
+func (t *policyTool) ProcessRequest(ctx tool.Context, req *model.LLMRequest) error {
+	if err := t.functionTool.ProcessRequest(ctx, req); err != nil {
+		return err
+	}
+	if req.Tools == nil {
+		req.Tools = map[string]any{}
+	}
+	req.Tools[t.Name()] = t
+	return nil
+}
+
+// Run runs the tool, retrying failed attempts, and caps the size of its result
+func (t *policyTool) Run(ctx tool.Context, args any) (map[string]any, error) {
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		result, err := t.attempt(ctx, args)
+		if err == nil {
+			return t.truncate(ctx, result), nil
+		}
+		if attempt >= t.policy.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+		t.logger.WarnContext(ctx, "Retrying failed tool call", "tool", t.Name(), "attempt", attempt+1, "retries", t.policy.Retries, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt runs the tool once within the timeout. A tool that ignores the
+// cancellation of its context is abandoned, still running, when it expires.
+func (t *policyTool) attempt(ctx tool.Context, args any) (map[string]any, error) {
+	if t.policy.Timeout <= 0 {
+		return t.functionTool.Run(ctx, args)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, t.policy.Timeout)
+	defer cancel()
+
+	type outcome struct {
+		result map[string]any
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := t.functionTool.Run(&deadlineContext{Context: ctx, ctx: timeoutCtx}, args)
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		if o.err != nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, t.timeoutError(ctx)
+		}
+		return o.result, o.err
+	case <-timeoutCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, t.timeoutError(ctx)
+	}
+}
+
+func (t *policyTool) timeoutError(ctx context.Context) error {
+	t.logger.WarnContext(ctx, "Tool call timed out", "tool", t.Name(), "timeout", t.policy.Timeout)
+	return fmt.Errorf("tool %s timed out after %s", t.Name(), t.policy.Timeout)
+}
+
+// truncate replaces a result larger than the limit by its JSON encoding cut
+// at the limit and followed by TruncationMarker
+func (t *policyTool) truncate(ctx context.Context, result map[string]any) map[string]any {
+	if t.policy.MaxResultBytes <= 0 {
+		return result
+	}
+	data, err := json.Marshal(result)
+	if err != nil || len(data) <= t.policy.MaxResultBytes {
+		return result
+	}
+	cut := t.policy.MaxResultBytes
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	t.logger.InfoContext(ctx, "Truncated tool result", "tool", t.Name(), "size", len(data), "max", t.policy.MaxResultBytes)
+	return map[string]any{
+		"result":    string(data[:cut]) + fmt.Sprintf(TruncationMarker, t.policy.MaxResultBytes),
+		"truncated": true,
+	}
+}
+
+// deadlineContext is a tool context cancelled with ctx
+type deadlineContext struct {
+	tool.Context
+	ctx context.Context
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) { return c.ctx.Deadline() }
+func (c *deadlineContext) Done() <-chan struct{}       { return c.ctx.Done() }
+func (c *deadlineContext) Err() error                  { return c.ctx.Err() }
+func (c *deadlineContext) Value(key any) any           { return c.ctx.Value(key) }
@@ -0,0 +1,126 @@
+package toolrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// callModel calls the tool named by the user message, then replies with the
+// tool's response as JSON
+type callModel struct{}
+
+func (callModel) Name() string { return "call" }
+
+func (callModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		last := req.Contents[len(req.Contents)-1].Parts[0]
+		if last.FunctionResponse != nil {
+			data, _ := json.Marshal(last.FunctionResponse.Response)
+			yield(&model.LLMResponse{Content: genai.NewContentFromText(string(data), genai.RoleModel)}, nil)
+			return
+		}
+		call := &genai.Part{FunctionCall: &genai.FunctionCall{ID: "call_1", Name: last.Text, Args: map[string]any{}}}
+		yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{call}}}, nil)
+	}
+}
+
+// call runs an agent with tools, calling the tool named name, and returns
+// the tool's response
+func call(t *testing.T, tools []tool.Tool, name string) map[string]any {
+	t.Helper()
+	a, err := llmagent.New(llmagent.Config{Name: "test", Model: callModel{}, Tools: tools})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, err := runner.New(runner.Config{AppName: "test", Agent: a, SessionService: sessions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "test", UserID: "u"})
+	var reply string
+	for event, err := range r.Run(ctx, "u", created.Session.ID(), genai.NewContentFromText(name, genai.RoleUser), agent.RunConfig{}) {
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if event.Content != nil && event.Content.Parts[0].Text != "" {
+			reply = event.Content.Parts[0].Text
+		}
+	}
+	var response map[string]any
+	if err := json.Unmarshal([]byte(reply), &response); err != nil {
+		t.Fatalf("reply %q: %v", reply, err)
+	}
+	return response
+}
+
+type noArgs struct{}
+
+// TestWrap tests the timeout, retries and result size limit of tools run by
+// an agent
+func TestWrap(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow, _ := functiontool.New(functiontool.Config{Name: "slow", Description: "Blocks, ignoring its context"},
+		func(tool.Context, noArgs) (map[string]any, error) {
+			<-release
+			return map[string]any{"ok": true}, nil
+		})
+	attempts := 0
+	flaky, _ := functiontool.New(functiontool.Config{Name: "flaky", Description: "Fails twice"},
+		func(tool.Context, noArgs) (map[string]any, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("unavailable")
+			}
+			return map[string]any{"attempts": attempts}, nil
+		})
+	verbose, _ := functiontool.New(functiontool.Config{Name: "verbose", Description: "Returns a long result"},
+		func(tool.Context, noArgs) (map[string]any, error) {
+			return map[string]any{"text": strings.Repeat("é", 100)}, nil
+		})
+
+	tools, err := Wrap([]tool.Tool{slow, flaky, verbose}, &Config{
+		Default: Policy{Timeout: 50 * time.Millisecond},
+		Tools: map[string]Policy{
+			"flaky":   {Retries: 2},
+			"verbose": {MaxResultBytes: 20},
+		},
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+
+	start := time.Now()
+	if got := call(t, tools, "slow"); got["error"] != "tool slow timed out after 50ms" || time.Since(start) > 5*time.Second {
+		t.Errorf("slow response = %v", got)
+	}
+	if got := call(t, tools, "flaky"); got["attempts"] != 3.0 {
+		t.Errorf("flaky response = %v, want success on the third attempt", got)
+	}
+	got := call(t, tools, "verbose")
+	want := `{"text":"ééééé` + "\n[Result truncated: it exceeded 20 bytes.]"
+	if got["result"] != want || got["truncated"] != true {
+		t.Errorf("verbose response = %q, want %q", got["result"], want)
+	}
+
+	if _, err := Wrap(nil, &Config{Tools: map[string]Policy{"x": {Retries: -1}}}); err == nil {
+		t.Error("Wrap(negative retries) error = nil")
+	}
+}
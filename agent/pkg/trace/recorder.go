@@ -0,0 +1,290 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+)
+
+// seenTTL is how long an invocation is remembered to record its user message once
+const seenTTL = time.Hour
+
+// Config configures a Recorder
+type Config struct {
+	Store   Store
+	Pricing usage.Pricing // Prices model steps, optional
+	Logger  *slog.Logger
+}
+
+// Recorder stores the steps of every turn: the user message, model calls
+// through Model and tool calls through the tool callbacks
+type Recorder struct {
+	store   Store
+	pricing usage.Pricing
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	seen    map[string]time.Time // Invocations whose user message is recorded
+	started map[string]time.Time // Start of tool calls by function call ID
+}
+
+// New creates a recorder writing to cfg.Store
+func New(cfg *Config) (*Recorder, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("trace store is required")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Component("trace")
+	}
+	return &Recorder{
+		store:   cfg.Store,
+		pricing: cfg.Pricing,
+		logger:  logger,
+		seen:    make(map[string]time.Time),
+		started: make(map[string]time.Time),
+	}, nil
+}
+
+// Model wraps llm so its calls are recorded as model steps
+func (r *Recorder) Model(llm model.LLM) model.LLM {
+	return &tracingModel{llm: llm, recorder: r}
+}
+
+// BeforeToolCallback marks the start of tool calls; it should run before
+// other callbacks so their time counts
+func (r *Recorder) BeforeToolCallback() llmagent.BeforeToolCallback {
+	return func(ctx tool.Context, _ tool.Tool, _ map[string]any) (map[string]any, error) {
+		r.mu.Lock()
+		r.started[ctx.FunctionCallID()] = time.Now()
+		r.mu.Unlock()
+		return nil, nil
+	}
+}
+
+// AfterToolCallback records tool calls with their result, including results
+// set by before-tool callbacks
+func (r *Recorder) AfterToolCallback() llmagent.AfterToolCallback {
+	return func(ctx tool.Context, t tool.Tool, args, result map[string]any, err error) (map[string]any, error) {
+		now := time.Now()
+		r.mu.Lock()
+		start, ok := r.started[ctx.FunctionCallID()]
+		delete(r.started, ctx.FunctionCallID())
+		r.mu.Unlock()
+		if !ok {
+			start = now
+		}
+
+		r.user(ctx, start, ctx.InvocationID(), ctx.SessionID(), ctx.UserID(), ctx.UserContent())
+		step := Step{
+			Time:       start.UTC(),
+			Session:    ctx.SessionID(),
+			Invocation: ctx.InvocationID(),
+			User:       ctx.UserID(),
+			Agent:      ctx.AgentName(),
+			Kind:       KindTool,
+			Name:       t.Name(),
+			DurationMS: now.Sub(start).Milliseconds(),
+			Input:      payload(args),
+			Output:     payload(result),
+		}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		r.append(ctx, step)
+		return nil, nil
+	}
+}
+
+// ServeHTTP implements the admin endpoint returning the traces of a session
+// (?session=) or turn (?invocation=, the trace ID of metric exemplars) since
+// a time (?since=, RFC 3339), as JSON
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := Filter{Session: req.URL.Query().Get("session"), Invocation: req.URL.Query().Get("invocation")}
+	if since := req.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q: %v", since, err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	steps, err := r.store.List(req.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Build(steps))
+}
+
+// user records the message that started an invocation at the time of the
+// first step seen of the invocation
+func (r *Recorder) user(ctx context.Context, at time.Time, invocation, session, user string, msg *genai.Content) {
+	now := time.Now()
+	r.mu.Lock()
+	_, seen := r.seen[invocation]
+	if !seen {
+		maps.DeleteFunc(r.seen, func(_ string, t time.Time) bool { return now.Sub(t) > seenTTL })
+		r.seen[invocation] = now
+	}
+	r.mu.Unlock()
+	if seen || msg == nil {
+		return
+	}
+
+	r.append(ctx, Step{
+		Time:       at.UTC(),
+		Session:    session,
+		Invocation: invocation,
+		User:       user,
+		Kind:       KindUser,
+		Input:      payload(msg),
+	})
+}
+
+func (r *Recorder) append(ctx context.Context, step Step) {
+	if err := r.store.Append(context.WithoutCancel(ctx), step); err != nil {
+		r.logger.Error("Failed to record trace step", "kind", step.Kind, "error", err)
+	}
+}
+
+// tracingModel records each call of the wrapped model as a step
+type tracingModel struct {
+	llm      model.LLM
+	recorder *Recorder
+}
+
+// Name implements model.LLM
+func (m *tracingModel) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM. The step is recorded at the final
+// response, before the agent acts on it, so the call's duration excludes
+// the tool calls that follow.
+func (m *tracingModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		step := Step{
+			Time:  time.Now().UTC(),
+			Kind:  KindModel,
+			Name:  m.llm.Name(),
+			Input: payload(requestPayload(req)),
+		}
+		if ictx, ok := ctx.(agent.InvocationContext); ok {
+			step.Invocation = ictx.InvocationID()
+			if a := ictx.Agent(); a != nil {
+				step.Agent = a.Name()
+			}
+			if s := ictx.Session(); s != nil {
+				step.Session = s.ID()
+				step.User = s.UserID()
+			}
+			m.recorder.user(ctx, step.Time, step.Invocation, step.Session, step.User, ictx.UserContent())
+		}
+
+		var streamed strings.Builder
+		recorded := false
+		finish := func(final *model.LLMResponse, err error) {
+			if recorded {
+				return
+			}
+			recorded = true
+			m.record(ctx, step, final, streamed.String(), err)
+		}
+		defer finish(nil, nil)
+
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			switch {
+			case err != nil:
+				finish(nil, err)
+			case resp.Partial:
+				if resp.Content != nil {
+					for _, part := range resp.Content.Parts {
+						streamed.WriteString(part.Text)
+					}
+				}
+			default:
+				finish(resp, nil)
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// record completes and stores a model step. Streamed text stands in for the
+// response when no final one came.
+func (m *tracingModel) record(ctx context.Context, step Step, final *model.LLMResponse, streamed string, err error) {
+	step.DurationMS = time.Since(step.Time).Milliseconds()
+	switch {
+	case final != nil:
+		step.Output = payload(map[string]any{"content": final.Content, "finish_reason": final.FinishReason})
+		if u := final.UsageMetadata; u != nil {
+			step.InputTokens = int64(u.PromptTokenCount)
+			step.OutputTokens = int64(u.CandidatesTokenCount)
+			step.Cost = m.recorder.pricing.Cost(step.Name, step.InputTokens, int64(u.CachedContentTokenCount), step.OutputTokens)
+		}
+	case streamed != "":
+		step.Output = payload(map[string]any{"content": genai.NewContentFromText(streamed, genai.RoleModel)})
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	m.recorder.append(ctx, step)
+}
+
+// requestPayload is the part of a request worth keeping: the system
+// instruction, the contents and the names of the tools offered
+func requestPayload(req *model.LLMRequest) map[string]any {
+	p := map[string]any{"contents": req.Contents}
+	if req.Config != nil && req.Config.SystemInstruction != nil {
+		var system []string
+		for _, part := range req.Config.SystemInstruction.Parts {
+			system = append(system, part.Text)
+		}
+		p["system"] = strings.Join(system, "\n")
+	}
+	if len(req.Tools) > 0 {
+		p["tools"] = slices.Sorted(maps.Keys(req.Tools))
+	}
+	return p
+}
+
+// payload converts v to generic JSON values
+func payload(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("unencodable %T: %v", v, err)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Kinds of steps
+const (
+	KindUser  = "user"  // The message that started the turn
+	KindModel = "model" // A model call with its request and response
+	KindTool  = "tool"  // A tool call with its arguments and result
+)
+
+// Step is one event of a turn. Payloads hold generic JSON values, so steps
+// read back from a store look like the ones recorded.
+type Step struct {
+	Time         time.Time `json:"time" yaml:"time"`
+	Session      string    `json:"session" yaml:"session"`
+	Invocation   string    `json:"invocation" yaml:"invocation"`
+	User         string    `json:"user,omitempty" yaml:"user,omitempty"`
+	Agent        string    `json:"agent,omitempty" yaml:"agent,omitempty"`
+	Kind         string    `json:"kind" yaml:"kind"`
+	Name         string    `json:"name,omitempty" yaml:"name,omitempty"` // Model or tool name
+	DurationMS   int64     `json:"duration_ms,omitempty" yaml:"duration_ms,omitempty"`
+	Input        any       `json:"input,omitempty" yaml:"input,omitempty"`
+	Output       any       `json:"output,omitempty" yaml:"output,omitempty"`
+	Error        string    `json:"error,omitempty" yaml:"error,omitempty"`
+	InputTokens  int64     `json:"input_tokens,omitempty" yaml:"input_tokens,omitempty"`
+	OutputTokens int64     `json:"output_tokens,omitempty" yaml:"output_tokens,omitempty"`
+	Cost         float64   `json:"cost,omitempty" yaml:"cost,omitempty"`
+}
+
+// Trace is a turn: the steps of one invocation in order, with totals
+type Trace struct {
+	Invocation   string    `json:"invocation" yaml:"invocation"`
+	Session      string    `json:"session" yaml:"session"`
+	User         string    `json:"user,omitempty" yaml:"user,omitempty"`
+	Start        time.Time `json:"start" yaml:"start"`
+	End          time.Time `json:"end" yaml:"end"`
+	InputTokens  int64     `json:"input_tokens" yaml:"input_tokens"`
+	OutputTokens int64     `json:"output_tokens" yaml:"output_tokens"`
+	Cost         float64   `json:"cost" yaml:"cost"`
+	Steps        []Step    `json:"steps" yaml:"steps"`
+}
+
+// Build groups steps into traces by invocation, ordered by start time. A
+// step ends when its duration has passed, so a trace ends with its last step.
+func Build(steps []Step) []Trace {
+	byInvocation := make(map[string]*Trace)
+	var traces []*Trace
+	for _, s := range steps {
+		t, ok := byInvocation[s.Invocation]
+		if !ok {
+			t = &Trace{Invocation: s.Invocation, Session: s.Session, User: s.User, Start: s.Time}
+			byInvocation[s.Invocation] = t
+			traces = append(traces, t)
+		}
+		t.Steps = append(t.Steps, s)
+		t.InputTokens += s.InputTokens
+		t.OutputTokens += s.OutputTokens
+		t.Cost += s.Cost
+	}
+
+	result := make([]Trace, 0, len(traces))
+	for _, t := range traces {
+		slices.SortStableFunc(t.Steps, func(a, b Step) int { return a.Time.Compare(b.Time) })
+		t.Start = t.Steps[0].Time
+		for _, s := range t.Steps {
+			if end := s.Time.Add(time.Duration(s.DurationMS) * time.Millisecond); end.After(t.End) {
+				t.End = end
+			}
+		}
+		result = append(result, *t)
+	}
+	slices.SortStableFunc(result, func(a, b Trace) int { return a.Start.Compare(b.Start) })
+	return result
+}
+
+// Filter selects the steps to list
+type Filter struct {
+	Since      time.Time // Steps at or after this time
+	Session    string    // Only this session, empty for all
+	Invocation string    // Only this turn, empty for all
+}
+
+// Store persists trace steps
+type Store interface {
+	// Append stores a step
+	Append(ctx context.Context, s Step) error
+	// List returns the steps matching f in the order they were stored
+	List(ctx context.Context, f Filter) ([]Step, error)
+}
+
+// FileStore is a Store backed by an append-only JSON lines file
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a file store at path, creating parent directories as needed
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("trace store path is required")
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create trace directory: %w", err)
+		}
+	}
+	return &FileStore{path: path}, nil
+}
+
+// Append implements Store
+func (s *FileStore) Append(_ context.Context, step Step) error {
+	line, err := json.Marshal(step)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace step: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write trace step: %w", err)
+	}
+	return nil
+}
+
+// List implements Store
+func (s *FileStore) List(ctx context.Context, filter Filter) ([]Step, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer f.Close()
+
+	var steps []Step
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20) // Model steps carry whole requests
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var step Step
+		if err := json.Unmarshal(scanner.Bytes(), &step); err != nil {
+			return nil, fmt.Errorf("failed to parse trace step at line %d: %w", lineNo, err)
+		}
+		if step.Time.Before(filter.Since) || filter.Session != "" && step.Session != filter.Session ||
+			filter.Invocation != "" && step.Invocation != filter.Invocation {
+			continue
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace file: %w", err)
+	}
+	return steps, nil
+}
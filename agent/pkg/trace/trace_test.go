@@ -0,0 +1,136 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/usage"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+	"google.golang.org/genai"
+)
+
+// clockModel asks for the time, then answers with the tool result
+type clockModel struct{}
+
+func (clockModel) Name() string { return "clock-model" }
+
+func (clockModel) GenerateContent(_ context.Context, req *model.LLMRequest, _ bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		usage := &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 1000, CandidatesTokenCount: 100}
+		last := req.Contents[len(req.Contents)-1].Parts[0]
+		if r := last.FunctionResponse; r != nil {
+			yield(&model.LLMResponse{Content: genai.NewContentFromText("It is "+r.Response["time"].(string), genai.RoleModel), UsageMetadata: usage}, nil)
+			return
+		}
+		call := &genai.Part{FunctionCall: &genai.FunctionCall{ID: "call-1", Name: "clock", Args: map[string]any{}}}
+		yield(&model.LLMResponse{Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{call}}, UsageMetadata: usage}, nil)
+	}
+}
+
+// TestRecorder tests recording a turn with a tool call and exporting it
+func TestRecorder(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "traces.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := New(&Config{
+		Store:   store,
+		Pricing: usage.Pricing{"clock-model": {InputPerMillion: 1, OutputPerMillion: 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock, err := functiontool.New(functiontool.Config{Name: "clock", Description: "Tells the time"},
+		func(tool.Context, struct{}) (map[string]any, error) {
+			return map[string]any{"time": "noon"}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := llmagent.New(llmagent.Config{
+		Name:                "clock_agent",
+		Model:               recorder.Model(clockModel{}),
+		Instruction:         "Tell the time.",
+		Tools:               []tool.Tool{clock},
+		BeforeToolCallbacks: []llmagent.BeforeToolCallback{recorder.BeforeToolCallback()},
+		AfterToolCallbacks:  []llmagent.AfterToolCallback{recorder.AfterToolCallback()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.InMemoryService()
+	r, _ := runner.New(runner.Config{AppName: "app", Agent: a, SessionService: sessions})
+	created, _ := sessions.Create(ctx, &session.CreateRequest{AppName: "app", UserID: "alice"})
+	for _, err := range r.Run(ctx, "alice", created.Session.ID(), genai.NewContentFromText("What time is it?", genai.RoleUser), agent.RunConfig{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps, err := store.List(ctx, Filter{Session: created.Session.ID()})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	traces := Build(steps)
+	if len(traces) != 1 {
+		t.Fatalf("Build() = %d traces, want 1", len(traces))
+	}
+	trace := traces[0]
+	var kinds []string
+	for _, s := range trace.Steps {
+		kinds = append(kinds, s.Kind+":"+s.Name)
+	}
+	want := []string{"user:", "model:clock-model", "tool:clock", "model:clock-model"}
+	if len(kinds) != len(want) {
+		t.Fatalf("steps = %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("steps = %v, want %v", kinds, want)
+		}
+	}
+	if trace.User != "alice" || trace.Steps[1].Agent != "clock_agent" {
+		t.Errorf("trace = %+v", trace)
+	}
+	if trace.InputTokens != 2000 || trace.OutputTokens != 200 || trace.Cost != 0.004 {
+		t.Errorf("totals = %d, %d, %v", trace.InputTokens, trace.OutputTokens, trace.Cost)
+	}
+	if input := trace.Steps[1].Input.(map[string]any); input["system"] != "Tell the time." {
+		t.Errorf("model input = %v", input)
+	}
+	if output := trace.Steps[2].Output.(map[string]any); output["time"] != "noon" {
+		t.Errorf("tool output = %v", output)
+	}
+
+	rec := httptest.NewRecorder()
+	recorder.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/traces?session="+created.Session.ID(), nil))
+	var served []Trace
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil || len(served) != 1 || len(served[0].Steps) != 4 {
+		t.Errorf("ServeHTTP() = %d %s", rec.Code, rec.Body)
+	}
+
+	if steps, _ := store.List(ctx, Filter{Invocation: trace.Invocation}); len(steps) != 4 {
+		t.Errorf("List() of the invocation = %d steps, want 4", len(steps))
+	}
+	if steps, _ := store.List(ctx, Filter{Invocation: "other"}); len(steps) != 0 {
+		t.Errorf("List() of another invocation = %d steps", len(steps))
+	}
+	if steps, _ := store.List(ctx, Filter{Since: time.Now().Add(time.Hour)}); len(steps) != 0 {
+		t.Errorf("List() since the future = %d steps", len(steps))
+	}
+}
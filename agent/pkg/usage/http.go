@@ -0,0 +1,70 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultWindow is the report window of a request without since
+const defaultWindow = 7 * 24 * time.Hour
+
+// Handler serves usage reports from a store, for the admin server
+type Handler struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewHandler creates a handler reporting the records of store
+func NewHandler(store Store) *Handler {
+	return &Handler{store: store, now: time.Now}
+}
+
+// ServeHTTP returns the usage report as JSON. Query parameters: since, an
+// RFC 3339 time or a date (7 days ago by default); group_by, comma-separated
+// dimensions (day,model by default); session, to report a single session.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+
+	since := h.now().Add(-defaultWindow)
+	if s := query.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, s)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q: use an RFC 3339 time or a date like 2026-01-01", s), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	groupBy := []string{GroupByDay, GroupByModel}
+	if g := query.Get("group_by"); g != "" {
+		groupBy = nil
+		for _, item := range strings.Split(g, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				groupBy = append(groupBy, item)
+			}
+		}
+	}
+	if err := ValidateGroupBy(groupBy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := h.store.List(req.Context(), since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := Aggregate(FilterSession(records, query.Get("session")), since, groupBy)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
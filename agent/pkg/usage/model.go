@@ -0,0 +1,94 @@
+package usage
+
+import (
+	"context"
+	"iter"
+	"log/slog"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+)
+
+// RecordingModel wraps a model.LLM and stores a usage record for every
+// response that carries usage metadata
+type RecordingModel struct {
+	llm     model.LLM
+	store   Store
+	pricing Pricing
+	logger  *slog.Logger
+}
+
+// NewRecordingModel wraps llm so its usage is written to store
+func NewRecordingModel(llm model.LLM, store Store, pricing Pricing) *RecordingModel {
+	return &RecordingModel{
+		llm:     llm,
+		store:   store,
+		pricing: pricing,
+		logger:  logging.Component("usage"),
+	}
+}
+
+// Name implements model.LLM
+func (m *RecordingModel) Name() string {
+	return m.llm.Name()
+}
+
+// GenerateContent implements model.LLM
+func (m *RecordingModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		for resp, err := range m.llm.GenerateContent(ctx, req, stream) {
+			if err == nil && resp != nil && resp.UsageMetadata != nil {
+				m.record(ctx, resp)
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// keyContextKey is the context key of the API key name
+type keyContextKey struct{}
+
+// WithKey attributes the usage of model calls made with ctx to the named API key
+func WithKey(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, name)
+}
+
+// KeyFromContext returns the API key name set by WithKey, empty when none
+func KeyFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(keyContextKey{}).(string)
+	return name
+}
+
+// record builds and stores a usage record, attributing it to the invocation's agent, user, session and API key
+func (m *RecordingModel) record(ctx context.Context, resp *model.LLMResponse) {
+	usage := resp.UsageMetadata
+	r := Record{
+		Time:             time.Now().UTC(),
+		Model:            m.llm.Name(),
+		PromptTokens:     int64(usage.PromptTokenCount),
+		CachedTokens:     int64(usage.CachedContentTokenCount),
+		CompletionTokens: int64(usage.CandidatesTokenCount),
+		TotalTokens:      int64(usage.TotalTokenCount),
+	}
+	r.Cost = m.pricing.Cost(r.Model, r.PromptTokens, r.CachedTokens, r.CompletionTokens)
+
+	if ictx, ok := ctx.(agent.InvocationContext); ok {
+		if a := ictx.Agent(); a != nil {
+			r.Agent = a.Name()
+		}
+		if s := ictx.Session(); s != nil {
+			r.User = s.UserID()
+			r.Session = s.ID()
+		}
+	}
+
+	r.Key = KeyFromContext(ctx)
+
+	if err := m.store.Append(context.WithoutCancel(ctx), r); err != nil {
+		m.logger.Error("Failed to record usage", "error", err)
+	}
+}
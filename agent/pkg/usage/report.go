@@ -0,0 +1,211 @@
+package usage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dimensions a report can be grouped by
+const (
+	GroupByDay     = "day"
+	GroupByModel   = "model"
+	GroupByAgent   = "agent"
+	GroupByUser    = "user"
+	GroupByKey     = "key"
+	GroupBySession = "session"
+)
+
+// Row is one aggregated report line
+type Row struct {
+	Day              string  `json:"day,omitempty" yaml:"day,omitempty"`
+	Model            string  `json:"model,omitempty" yaml:"model,omitempty"`
+	Agent            string  `json:"agent,omitempty" yaml:"agent,omitempty"`
+	User             string  `json:"user,omitempty" yaml:"user,omitempty"`
+	Key              string  `json:"key,omitempty" yaml:"key,omitempty"`
+	Session          string  `json:"session,omitempty" yaml:"session,omitempty"`
+	Requests         int64   `json:"requests" yaml:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens" yaml:"prompt_tokens"`
+	CachedTokens     int64   `json:"cached_tokens" yaml:"cached_tokens"`
+	CompletionTokens int64   `json:"completion_tokens" yaml:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens" yaml:"total_tokens"`
+	Cost             float64 `json:"cost" yaml:"cost"`
+}
+
+// Report is usage aggregated by a set of dimensions
+type Report struct {
+	Since   time.Time `json:"since" yaml:"since"`
+	GroupBy []string  `json:"group_by" yaml:"group_by"`
+	Groups  []Row     `json:"groups" yaml:"groups"`
+	Total   Row       `json:"total" yaml:"total"`
+}
+
+// ValidateGroupBy checks that every dimension is supported
+func ValidateGroupBy(groupBy []string) error {
+	for _, g := range groupBy {
+		switch g {
+		case GroupByDay, GroupByModel, GroupByAgent, GroupByUser, GroupByKey, GroupBySession:
+		default:
+			return fmt.Errorf("invalid group-by dimension %q: must be day, model, agent, user, key or session", g)
+		}
+	}
+	return nil
+}
+
+// Aggregate groups records by the given dimensions. Rows are sorted by the dimension values.
+func Aggregate(records []Record, since time.Time, groupBy []string) *Report {
+	report := &Report{Since: since, GroupBy: groupBy}
+	rows := make(map[string]*Row)
+
+	for _, r := range records {
+		key := Row{}
+		for _, g := range groupBy {
+			switch g {
+			case GroupByDay:
+				key.Day = r.Time.UTC().Format(time.DateOnly)
+			case GroupByModel:
+				key.Model = r.Model
+			case GroupByAgent:
+				key.Agent = r.Agent
+			case GroupByUser:
+				key.User = r.User
+			case GroupByKey:
+				key.Key = r.Key
+			case GroupBySession:
+				key.Session = r.Session
+			}
+		}
+
+		id := strings.Join([]string{key.Day, key.Model, key.Agent, key.User, key.Key, key.Session}, "\x00")
+		row, ok := rows[id]
+		if !ok {
+			row = &key
+			rows[id] = row
+		}
+		row.add(r)
+		report.Total.add(r)
+	}
+
+	report.Groups = make([]Row, 0, len(rows))
+	for _, row := range rows {
+		report.Groups = append(report.Groups, *row)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		for _, pair := range [][2]string{{a.Day, b.Day}, {a.Model, b.Model}, {a.Agent, b.Agent}, {a.User, b.User}, {a.Key, b.Key}, {a.Session, b.Session}} {
+			if pair[0] != pair[1] {
+				return pair[0] < pair[1]
+			}
+		}
+		return false
+	})
+	return report
+}
+
+// FilterSession returns the records of a session, all of them when session
+// is empty
+func FilterSession(records []Record, session string) []Record {
+	if session == "" {
+		return records
+	}
+	var out []Record
+	for _, r := range records {
+		if r.Session == session {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// add accumulates a record into the row
+func (r *Row) add(rec Record) {
+	r.Requests++
+	r.PromptTokens += rec.PromptTokens
+	r.CachedTokens += rec.CachedTokens
+	r.CompletionTokens += rec.CompletionTokens
+	r.TotalTokens += rec.TotalTokens
+	r.Cost += rec.Cost
+}
+
+// Header returns the table header for the report's dimensions
+func (rep *Report) Header() []string {
+	header := make([]string, 0, len(rep.GroupBy)+6)
+	for _, g := range rep.GroupBy {
+		header = append(header, strings.ToUpper(g))
+	}
+	return append(header, "REQUESTS", "PROMPT_TOKENS", "CACHED_TOKENS", "COMPLETION_TOKENS", "TOTAL_TOKENS", "COST")
+}
+
+// Rows returns the table rows followed by a total line
+func (rep *Report) Rows() [][]string {
+	out := make([][]string, 0, len(rep.Groups)+1)
+	for _, row := range rep.Groups {
+		out = append(out, rep.cells(row, ""))
+	}
+	return append(out, rep.cells(rep.Total, "TOTAL"))
+}
+
+// cells formats a row; label replaces the dimension values when set
+func (rep *Report) cells(row Row, label string) []string {
+	cells := make([]string, 0, len(rep.GroupBy)+6)
+	for i, g := range rep.GroupBy {
+		switch {
+		case label != "" && i == 0:
+			cells = append(cells, label)
+		case label != "":
+			cells = append(cells, "")
+		default:
+			cells = append(cells, row.dimension(g))
+		}
+	}
+	return append(cells,
+		fmt.Sprint(row.Requests),
+		fmt.Sprint(row.PromptTokens),
+		fmt.Sprint(row.CachedTokens),
+		fmt.Sprint(row.CompletionTokens),
+		fmt.Sprint(row.TotalTokens),
+		fmt.Sprintf("%.4f", row.Cost),
+	)
+}
+
+// dimension returns the row's value for a group-by dimension
+func (r *Row) dimension(g string) string {
+	switch g {
+	case GroupByDay:
+		return r.Day
+	case GroupByModel:
+		return r.Model
+	case GroupByAgent:
+		return r.Agent
+	case GroupByUser:
+		return r.User
+	case GroupByKey:
+		return r.Key
+	case GroupBySession:
+		return r.Session
+	default:
+		return ""
+	}
+}
+
+// WriteCSV writes the report rows (without the total line) as CSV
+func (rep *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := rep.Header()
+	for i := range header {
+		header[i] = strings.ToLower(header[i])
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rep.Groups {
+		if err := cw.Write(rep.cells(row, "")); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
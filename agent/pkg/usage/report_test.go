@@ -0,0 +1,113 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAggregate tests grouping usage records by model and day
+func TestAggregate(t *testing.T) {
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	records := []Record{
+		{Time: day1, Model: "deepseek-chat", PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, Cost: 0.1},
+		{Time: day1.Add(time.Hour), Model: "deepseek-chat", PromptTokens: 20, CachedTokens: 16, CompletionTokens: 5, TotalTokens: 25, Cost: 0.2},
+		{Time: day2, Model: "gpt-4o", PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2, Cost: 0.5},
+	}
+
+	report := Aggregate(records, day1, []string{GroupByDay, GroupByModel})
+	if len(report.Groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(report.Groups))
+	}
+
+	first := report.Groups[0]
+	if first.Day != "2026-03-01" || first.Model != "deepseek-chat" || first.Requests != 2 || first.TotalTokens != 40 {
+		t.Errorf("unexpected first group %+v", first)
+	}
+	if report.Total.Requests != 3 || report.Total.TotalTokens != 42 {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	want := "day,model,requests,prompt_tokens,cached_tokens,completion_tokens,total_tokens,cost\n" +
+		"2026-03-01,deepseek-chat,2,30,16,10,40,0.3000\n" +
+		"2026-03-02,gpt-4o,1,1,0,1,2,0.5000\n"
+	if buf.String() != want {
+		t.Errorf("WriteCSV() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestCost(t *testing.T) {
+	pricing := Pricing{
+		"deepseek-chat": {InputPerMillion: 2, CachedInputPerMillion: 0.5, OutputPerMillion: 8},
+		"gpt-4o":        {InputPerMillion: 2, OutputPerMillion: 8},
+	}
+	// 600k uncached and 400k cached prompt tokens, 100k completion tokens
+	if got := pricing.Cost("deepseek-chat", 1_000_000, 400_000, 100_000); got != 1.2+0.2+0.8 {
+		t.Errorf("Cost() = %v, want 2.2", got)
+	}
+	// Without a cached price cache hits cost the input price
+	if got := pricing.Cost("gpt-4o", 1_000_000, 400_000, 100_000); got != 2.8 {
+		t.Errorf("Cost() = %v, want 2.8", got)
+	}
+	if got := pricing.Cost("unknown", 1_000_000, 0, 0); got != 0 {
+		t.Errorf("Cost() of an unpriced model = %v", got)
+	}
+}
+
+// TestHandler tests the usage endpoint's session grouping and filter
+func TestHandler(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "usage.jsonl"))
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	day := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, r := range []Record{
+		{Time: day, Model: "deepseek-chat", Session: "s1", PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		{Time: day.Add(time.Minute), Model: "deepseek-chat", Session: "s1", PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25},
+		{Time: day.Add(time.Hour), Model: "gpt-4o", Session: "s2", PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2},
+	} {
+		if err := store.Append(context.Background(), r); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	h := NewHandler(store)
+	h.now = func() time.Time { return day.AddDate(0, 0, 1) }
+
+	get := func(query string) (*httptest.ResponseRecorder, Report) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/usage?"+query, nil))
+		var report Report
+		if rec.Code == http.StatusOK {
+			json.NewDecoder(rec.Body).Decode(&report)
+		}
+		return rec, report
+	}
+
+	_, report := get("group_by=session,model")
+	if len(report.Groups) != 2 || report.Groups[0].Session != "s1" || report.Groups[0].TotalTokens != 40 || report.Groups[1].Session != "s2" {
+		t.Errorf("groups = %+v", report.Groups)
+	}
+	_, report = get("session=s2&since=2026-03-01")
+	if report.Total.Requests != 1 || report.Total.TotalTokens != 2 {
+		t.Errorf("session total = %+v", report.Total)
+	}
+	_, report = get("since=2026-03-01T10:30:00Z")
+	if report.Total.Requests != 1 {
+		t.Errorf("requests since 10:30 = %d, want 1", report.Total.Requests)
+	}
+	for _, query := range []string{"group_by=week", "since=yesterday"} {
+		if rec, _ := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
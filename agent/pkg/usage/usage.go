@@ -0,0 +1,141 @@
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record is a single model call's token usage and cost
+type Record struct {
+	Time             time.Time `json:"time" yaml:"time"`
+	Model            string    `json:"model" yaml:"model"`
+	Agent            string    `json:"agent,omitempty" yaml:"agent,omitempty"`
+	User             string    `json:"user,omitempty" yaml:"user,omitempty"`
+	Session          string    `json:"session,omitempty" yaml:"session,omitempty"`
+	Key              string    `json:"key,omitempty" yaml:"key,omitempty"` // Name of the API key of the request
+	PromptTokens     int64     `json:"prompt_tokens" yaml:"prompt_tokens"`
+	CachedTokens     int64     `json:"cached_tokens,omitempty" yaml:"cached_tokens,omitempty"` // Prompt tokens read from the provider's cache
+	CompletionTokens int64     `json:"completion_tokens" yaml:"completion_tokens"`
+	TotalTokens      int64     `json:"total_tokens" yaml:"total_tokens"`
+	Cost             float64   `json:"cost" yaml:"cost"`
+}
+
+// Store persists usage records
+type Store interface {
+	// Append stores a record
+	Append(ctx context.Context, r Record) error
+	// List returns records with Time at or after since
+	List(ctx context.Context, since time.Time) ([]Record, error)
+}
+
+// FileStore is a Store backed by an append-only JSON lines file
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a file store at path, creating parent directories as needed
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("usage store path is required")
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create usage directory: %w", err)
+		}
+	}
+	return &FileStore{path: path}, nil
+}
+
+// Append implements Store
+func (s *FileStore) Append(_ context.Context, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage record: %w", err)
+	}
+	return nil
+}
+
+// List implements Store
+func (s *FileStore) List(ctx context.Context, since time.Time) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage file: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse usage record at line %d: %w", lineNo, err)
+		}
+		if !r.Time.Before(since) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage file: %w", err)
+	}
+	return records, nil
+}
+
+// Price is the cost of a model in currency units per million tokens
+type Price struct {
+	InputPerMillion       float64
+	CachedInputPerMillion float64 // Prompt tokens read from the cache, 0 charges InputPerMillion
+	OutputPerMillion      float64
+}
+
+// Pricing maps model names to prices
+type Pricing map[string]Price
+
+// Cost returns the cost of a call, 0 when the model has no price.
+// cachedTokens are the part of promptTokens read from the prompt cache.
+func (p Pricing) Cost(model string, promptTokens, cachedTokens, completionTokens int64) float64 {
+	price, ok := p[model]
+	if !ok {
+		return 0
+	}
+	cachedPrice := price.CachedInputPerMillion
+	if cachedPrice == 0 {
+		cachedPrice = price.InputPerMillion
+	}
+	cachedTokens = min(cachedTokens, promptTokens)
+	return (float64(promptTokens-cachedTokens)*price.InputPerMillion + float64(cachedTokens)*cachedPrice +
+		float64(completionTokens)*price.OutputPerMillion) / 1e6
+}
@@ -0,0 +1,261 @@
+// Package warmup keeps the model of a local inference server (Ollama or the
+// llama.cpp server) loaded, so the first request after startup, an idle
+// period or a server restart does not pay the model load time.
+package warmup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+)
+
+// Config holds warm-up configuration
+type Config struct {
+	Backend   string        // "ollama" or "llamacpp"
+	BaseURL   string        // Server root, e.g. http://localhost:11434; a trailing /v1 is ignored
+	ModelName string        // Model to keep loaded (ollama)
+	KeepAlive time.Duration // Optional, how long Ollama keeps the model after a ping, defaults to 30 minutes
+	Interval  time.Duration // Optional, check interval, defaults to 1 minute
+	Timeout   time.Duration // Optional, per load request, defaults to 5 minutes
+
+	HTTPClient *http.Client // Optional, defaults to http.DefaultClient
+	Logger     *slog.Logger
+}
+
+// Keeper loads the model and keeps it loaded. It pings the server on every
+// interval, and reloads the model when it finds it unloaded or finds the
+// server back after being unreachable.
+type Keeper struct {
+	cfg    Config
+	client *http.Client
+	logger *slog.Logger
+
+	ready  atomic.Bool
+	loaded atomic.Bool // Whether the model was loaded at least once
+}
+
+// NewKeeper creates a keeper for the configured local server
+func NewKeeper(cfg *Config) (*Keeper, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("base URL is required")
+	}
+
+	c := *cfg
+	c.BaseURL = strings.TrimSuffix(strings.TrimRight(c.BaseURL, "/"), "/v1")
+	c.KeepAlive = cmp.Or(c.KeepAlive, 30*time.Minute)
+	c.Interval = cmp.Or(c.Interval, time.Minute)
+	c.Timeout = cmp.Or(c.Timeout, 5*time.Minute)
+	switch c.Backend {
+	case "ollama":
+		if c.ModelName == "" {
+			return nil, fmt.Errorf("model name is required for ollama")
+		}
+	case "llamacpp":
+	default:
+		return nil, fmt.Errorf("unknown backend %q (must be ollama or llamacpp)", c.Backend)
+	}
+	if c.KeepAlive < 0 || c.Interval < 0 || c.Timeout < 0 {
+		return nil, fmt.Errorf("keep alive, interval and timeout cannot be negative")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Component("warmup")
+	}
+
+	return &Keeper{
+		cfg:    c,
+		client: client,
+		logger: logger,
+	}, nil
+}
+
+// Ready reports whether the model was loaded at the last check
+func (k *Keeper) Ready() bool {
+	return k.ready.Load()
+}
+
+// Warm loads the model and waits until it is ready
+func (k *Keeper) Warm(ctx context.Context) error {
+	start := time.Now()
+	if err := k.load(ctx); err != nil {
+		k.ready.Store(false)
+		return fmt.Errorf("failed to load model: %w", err)
+	}
+
+	k.ready.Store(true)
+	k.loaded.Store(true)
+	k.logger.Info("Local model loaded", "backend", k.cfg.Backend, "model", k.cfg.ModelName, "duration", time.Since(start))
+	return nil
+}
+
+// load sends the request that loads the model, which also resets Ollama's unload timer
+func (k *Keeper) load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, k.cfg.Timeout)
+	defer cancel()
+
+	if k.cfg.Backend == "ollama" {
+		// A generate request without a prompt only loads the model
+		return k.post(ctx, "/api/generate", map[string]any{
+			"model":      k.cfg.ModelName,
+			"keep_alive": k.cfg.KeepAlive.String(),
+		})
+	}
+	// The llama.cpp server loads its model at startup; one token pages the weights in
+	return k.post(ctx, "/completion", map[string]any{"prompt": "Hi", "n_predict": 1})
+}
+
+// Run keeps the model loaded until ctx is cancelled
+func (k *Keeper) Run(ctx context.Context) {
+	k.logger.Info("Model warm-up started",
+		"backend", k.cfg.Backend,
+		"base_url", k.cfg.BaseURL,
+		"model", k.cfg.ModelName,
+		"interval", k.cfg.Interval,
+	)
+
+	ticker := time.NewTicker(k.cfg.Interval)
+	defer ticker.Stop()
+
+	k.check(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.check(ctx)
+		}
+	}
+}
+
+// check probes the server and reloads the model when needed
+func (k *Keeper) check(ctx context.Context) {
+	loaded, err := k.probe(ctx)
+	if err != nil {
+		if k.ready.Swap(false) {
+			k.logger.Warn("Local model server unavailable", "base_url", k.cfg.BaseURL, "error", err)
+		}
+		return
+	}
+
+	if !loaded && k.cfg.Backend == "llamacpp" {
+		// Still loading, warm it up once healthy
+		k.ready.Store(false)
+		return
+	}
+	if loaded && k.ready.Load() {
+		if k.cfg.Backend == "ollama" {
+			if err := k.load(ctx); err != nil {
+				k.logger.Warn("Failed to ping local model", "error", err)
+			}
+		}
+		return
+	}
+
+	if k.loaded.Load() {
+		k.logger.Warn("Local model was unloaded or the server restarted, reloading", "model", k.cfg.ModelName)
+	}
+	if err := k.Warm(ctx); err != nil {
+		k.logger.Warn("Failed to load local model", "error", err)
+	}
+}
+
+// probe reports whether the server is up and, for Ollama, whether the model is
+// loaded. The llama.cpp server answers 503 on /health until its model is loaded.
+func (k *Keeper) probe(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	path := "/health"
+	if k.cfg.Backend == "ollama" {
+		path = "/api/ps"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.cfg.BaseURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if k.cfg.Backend == "llamacpp" {
+		io.Copy(io.Discard, resp.Body)
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusServiceUnavailable:
+			return false, nil
+		}
+		return false, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var ps struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return false, fmt.Errorf("failed to decode running models: %w", err)
+	}
+	want := ollamaName(k.cfg.ModelName)
+	for _, m := range ps.Models {
+		if ollamaName(m.Name) == want || ollamaName(m.Model) == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// post sends a JSON request and discards the response
+func (k *Keeper) post(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.cfg.BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// ollamaName normalizes an Ollama model name, which defaults to the latest tag
+func ollamaName(name string) string {
+	if name != "" && !strings.Contains(name, ":") {
+		return name + ":latest"
+	}
+	return name
+}
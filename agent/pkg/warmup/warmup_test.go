@@ -0,0 +1,126 @@
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestKeeper_Ollama tests preloading, keep-alive pings and reloading after a restart
+func TestKeeper_Ollama(t *testing.T) {
+	var loaded atomic.Bool
+	var generates atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/ps":
+			models := []map[string]string{}
+			if loaded.Load() {
+				models = append(models, map[string]string{"name": "qwen3:latest", "model": "qwen3:latest"})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"models": models})
+		case "/api/generate":
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["model"] != "qwen3" || body["keep_alive"] != "30m0s" {
+				t.Errorf("generate body = %v", body)
+			}
+			generates.Add(1)
+			loaded.Store(true)
+			w.Write([]byte(`{"done":true}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	k, err := NewKeeper(&Config{Backend: "ollama", BaseURL: srv.URL + "/v1/", ModelName: "qwen3"})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	ctx := context.Background()
+
+	steps := []struct {
+		name          string
+		unload        bool
+		wantGenerates int32
+	}{
+		{name: "preload", wantGenerates: 1},
+		{name: "keep alive", wantGenerates: 2},
+		{name: "reload after restart", unload: true, wantGenerates: 3},
+	}
+	for _, step := range steps {
+		if step.unload {
+			loaded.Store(false)
+		}
+		k.check(ctx)
+		if got := generates.Load(); got != step.wantGenerates {
+			t.Errorf("%s: %d generate requests, want %d", step.name, got, step.wantGenerates)
+		}
+		if !k.Ready() {
+			t.Errorf("%s: Ready() = false, want true", step.name)
+		}
+	}
+}
+
+// TestKeeper_LlamaCpp tests waiting for the model to load and detecting an unreachable server
+func TestKeeper_LlamaCpp(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	var completions atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(int(status.Load()))
+		case "/completion":
+			completions.Add(1)
+			w.Write([]byte(`{"content":"!"}`))
+		}
+	}))
+
+	k, err := NewKeeper(&Config{Backend: "llamacpp", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	ctx := context.Background()
+
+	k.check(ctx)
+	if k.Ready() || completions.Load() != 0 {
+		t.Fatalf("loading server: Ready() = %v, %d completions, want not ready and none", k.Ready(), completions.Load())
+	}
+
+	status.Store(http.StatusOK)
+	k.check(ctx)
+	k.check(ctx)
+	if !k.Ready() || completions.Load() != 1 {
+		t.Fatalf("healthy server: Ready() = %v, %d completions, want ready after one warm-up", k.Ready(), completions.Load())
+	}
+
+	srv.Close()
+	k.check(ctx)
+	if k.Ready() {
+		t.Error("unreachable server: Ready() = true, want false")
+	}
+}
+
+// TestNewKeeper_Validation tests config validation
+func TestNewKeeper_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+	}{
+		{"nil config", nil},
+		{"missing base URL", &Config{Backend: "llamacpp"}},
+		{"ollama without model", &Config{Backend: "ollama", BaseURL: "http://localhost:11434"}},
+		{"unknown backend", &Config{Backend: "vllm", BaseURL: "http://localhost:8000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeeper(tt.cfg); err == nil {
+				t.Error("NewKeeper() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package websearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// searxng queries a SearxNG instance, which must have the json format enabled
+type searxng struct {
+	client  *http.Client
+	baseURL string
+}
+
+func (p *searxng) Name() string { return "searxng" }
+
+func (p *searxng) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		p.baseURL+"/search?"+url.Values{"q": {query}, "format": {"json"}}.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create searxng request: %w", err)
+	}
+	var resp struct {
+		Results []struct {
+			Title         string `json:"title"`
+			URL           string `json:"url"`
+			Content       string `json:"content"`
+			PublishedDate string `json:"publishedDate"`
+		} `json:"results"`
+	}
+	if err := getJSON(p.client, req, p.Name(), &resp); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(resp.Results))
+	for i, r := range resp.Results {
+		results[i] = Result{Title: r.Title, URL: r.URL, Snippet: r.Content, Published: r.PublishedDate}
+	}
+	return clean(results, count), nil
+}
+
+// brave queries the Brave Search API
+type brave struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func (p *brave) Name() string { return "brave" }
+
+func (p *brave) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		p.baseURL+"/res/v1/web/search?"+url.Values{"q": {query}, "count": {strconv.Itoa(min(count, 20))}}.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create brave request: %w", err)
+	}
+	req.Header.Set("X-Subscription-Token", p.apiKey)
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+				Age         string `json:"age"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	if err := getJSON(p.client, req, p.Name(), &resp); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(resp.Web.Results))
+	for i, r := range resp.Web.Results {
+		results[i] = Result{Title: r.Title, URL: r.URL, Snippet: r.Description, Published: r.Age}
+	}
+	return clean(results, count), nil
+}
+
+// tavily queries the Tavily search API
+type tavily struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func (p *tavily) Name() string { return "tavily" }
+
+func (p *tavily) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	body, err := json.Marshal(map[string]any{"query": query, "max_results": min(count, 20)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tavily request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/search", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tavily request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	var resp struct {
+		Results []struct {
+			Title         string `json:"title"`
+			URL           string `json:"url"`
+			Content       string `json:"content"`
+			PublishedDate string `json:"published_date"`
+		} `json:"results"`
+	}
+	if err := getJSON(p.client, req, p.Name(), &resp); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(resp.Results))
+	for i, r := range resp.Results {
+		results[i] = Result{Title: r.Title, URL: r.URL, Snippet: r.Content, Published: r.PublishedDate}
+	}
+	return clean(results, count), nil
+}
+
+// bing queries the Bing Web Search API
+type bing struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func (p *bing) Name() string { return "bing" }
+
+func (p *bing) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		p.baseURL+"/v7.0/search?"+url.Values{"q": {query}, "count": {strconv.Itoa(min(count, 50))}, "responseFilter": {"Webpages"}}.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bing request: %w", err)
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", p.apiKey)
+	var resp struct {
+		WebPages struct {
+			Value []struct {
+				Name          string `json:"name"`
+				URL           string `json:"url"`
+				Snippet       string `json:"snippet"`
+				DatePublished string `json:"datePublished"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	if err := getJSON(p.client, req, p.Name(), &resp); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(resp.WebPages.Value))
+	for i, r := range resp.WebPages.Value {
+		results[i] = Result{Title: r.Name, URL: r.URL, Snippet: r.Snippet, Published: r.DatePublished}
+	}
+	return clean(results, count), nil
+}
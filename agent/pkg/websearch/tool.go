@@ -0,0 +1,51 @@
+package websearch
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+)
+
+// SearchArgs are the arguments of the web_search tool
+type SearchArgs struct {
+	Query      string `json:"query" jsonschema:"What to search the web for"`
+	MaxResults int    `json:"max_results,omitempty" jsonschema:"Number of results to return"`
+}
+
+// SearchResults are the results of the web_search tool
+type SearchResults struct {
+	Results []Result `json:"results"`
+}
+
+// NewTool creates the "web_search" tool backed by provider. maxResults is the
+// default number of results.
+func NewTool(provider Provider, maxResults int) (tool.Tool, error) {
+	if provider == nil {
+		return nil, fmt.Errorf("provider is required")
+	}
+	if maxResults <= 0 {
+		maxResults = 5
+	}
+
+	return functiontool.New(functiontool.Config{
+		Name: "web_search",
+		Description: "Searches the web and returns the title, URL and snippet of each result. " +
+			"Cite the URLs of the results you use in your answer.",
+	}, func(ctx tool.Context, args SearchArgs) (SearchResults, error) {
+		query := strings.TrimSpace(args.Query)
+		if query == "" {
+			return SearchResults{}, fmt.Errorf("query is required")
+		}
+		n := args.MaxResults
+		if n <= 0 || n > 4*maxResults {
+			n = maxResults
+		}
+		results, err := provider.Search(ctx, query, n)
+		if err != nil {
+			return SearchResults{}, err
+		}
+		return SearchResults{Results: results}, nil
+	})
+}
@@ -0,0 +1,130 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a search request
+const DefaultTimeout = 10 * time.Second
+
+// Result is a web page found by a search
+type Result struct {
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	Snippet   string `json:"snippet"`
+	Published string `json:"published,omitempty"` // As reported by the provider, e.g. "2 days ago" or a date
+}
+
+// Provider searches the web
+type Provider interface {
+	// Name identifies the provider in logs and errors
+	Name() string
+	// Search returns up to count results for query
+	Search(ctx context.Context, query string, count int) ([]Result, error)
+}
+
+// Config selects and configures a provider
+type Config struct {
+	Provider   string // searxng, brave, tavily or bing
+	APIKey     string // Required except for searxng
+	BaseURL    string // Required for searxng, optional for the others
+	Timeout    time.Duration
+	HTTPClient *http.Client // Optional, replaces the client built from Timeout
+}
+
+// Providers lists the supported provider names
+func Providers() []string {
+	return []string{"searxng", "brave", "tavily", "bing"}
+}
+
+// New creates the provider selected by cfg
+func New(cfg *Config) (Provider, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	base := strings.TrimRight(cfg.BaseURL, "/")
+
+	if cfg.Provider != "searxng" && cfg.APIKey == "" {
+		return nil, fmt.Errorf("%s web search requires an API key", cfg.Provider)
+	}
+	switch cfg.Provider {
+	case "searxng":
+		if base == "" {
+			return nil, fmt.Errorf("searxng web search requires the base URL of an instance")
+		}
+		return &searxng{client: client, baseURL: base}, nil
+	case "brave":
+		return &brave{client: client, baseURL: orDefault(base, "https://api.search.brave.com"), apiKey: cfg.APIKey}, nil
+	case "tavily":
+		return &tavily{client: client, baseURL: orDefault(base, "https://api.tavily.com"), apiKey: cfg.APIKey}, nil
+	case "bing":
+		return &bing{client: client, baseURL: orDefault(base, "https://api.bing.microsoft.com"), apiKey: cfg.APIKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown web search provider %q (must be one of %s)", cfg.Provider, strings.Join(Providers(), ", "))
+	}
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// getJSON sends req and decodes a JSON response into out
+func getJSON(client *http.Client, req *http.Request, provider string, out any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s search failed: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s search returned %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", provider, err)
+	}
+	return nil
+}
+
+// tagPattern matches HTML tags, which some providers use to highlight matches
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// plainText strips tags and entities from a title or snippet
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(s, ""))), " ")
+}
+
+// clean drops results without a URL and strips markup
+func clean(results []Result, count int) []Result {
+	out := make([]Result, 0, min(len(results), count))
+	for _, r := range results {
+		if r.URL == "" {
+			continue
+		}
+		r.Title, r.Snippet = plainText(r.Title), plainText(r.Snippet)
+		out = append(out, r)
+		if len(out) == count {
+			break
+		}
+	}
+	return out
+}
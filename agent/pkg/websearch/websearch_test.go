@@ -0,0 +1,154 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProviders(t *testing.T) {
+	tests := []struct {
+		provider string
+		check    func(r *http.Request) string // Returns what is wrong with the request
+		response string
+	}{
+		{
+			provider: "searxng",
+			check: func(r *http.Request) string {
+				if r.URL.Path != "/search" || r.URL.Query().Get("format") != "json" || r.URL.Query().Get("q") != "go iterators" {
+					return "unexpected URL " + r.URL.String()
+				}
+				return ""
+			},
+			response: `{"results":[
+				{"title":"Range over func","url":"https://go.dev/blog/range-functions","content":"Iterators &amp; <b>range</b>","publishedDate":"2024-08-20"},
+				{"title":"No URL","url":"","content":"dropped"},
+				{"title":"Second","url":"https://example.com/2","content":"two"},
+				{"title":"Third","url":"https://example.com/3","content":"three"}]}`,
+		},
+		{
+			provider: "brave",
+			check: func(r *http.Request) string {
+				if r.Header.Get("X-Subscription-Token") != "key" {
+					return "missing subscription token"
+				}
+				if r.URL.Path != "/res/v1/web/search" || r.URL.Query().Get("count") != "2" {
+					return "unexpected URL " + r.URL.String()
+				}
+				return ""
+			},
+			response: `{"web":{"results":[
+				{"title":"Range over func","url":"https://go.dev/blog/range-functions","description":"Iterators &amp; <strong>range</strong>","age":"2024-08-20"},
+				{"title":"Second","url":"https://example.com/2","description":"two"}]}}`,
+		},
+		{
+			provider: "tavily",
+			check: func(r *http.Request) string {
+				if r.Method != http.MethodPost || r.URL.Path != "/search" || r.Header.Get("Authorization") != "Bearer key" {
+					return "unexpected request " + r.Method + " " + r.URL.String()
+				}
+				var body struct {
+					Query      string `json:"query"`
+					MaxResults int    `json:"max_results"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Query != "go iterators" || body.MaxResults != 2 {
+					return "unexpected body"
+				}
+				return ""
+			},
+			response: `{"results":[
+				{"title":"Range over func","url":"https://go.dev/blog/range-functions","content":"Iterators & range","published_date":"2024-08-20"},
+				{"title":"Second","url":"https://example.com/2","content":"two"}]}`,
+		},
+		{
+			provider: "bing",
+			check: func(r *http.Request) string {
+				if r.Header.Get("Ocp-Apim-Subscription-Key") != "key" || r.URL.Path != "/v7.0/search" {
+					return "unexpected request " + r.URL.String()
+				}
+				return ""
+			},
+			response: `{"webPages":{"value":[
+				{"name":"Range over func","url":"https://go.dev/blog/range-functions","snippet":"Iterators\n &amp; range","datePublished":"2024-08-20"},
+				{"name":"Second","url":"https://example.com/2","snippet":"two"}]}}`,
+		},
+	}
+
+	want := []Result{
+		{Title: "Range over func", URL: "https://go.dev/blog/range-functions", Snippet: "Iterators & range", Published: "2024-08-20"},
+		{Title: "Second", URL: "https://example.com/2", Snippet: "two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if problem := tt.check(r); problem != "" {
+					t.Error(problem)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+
+			p, err := New(&Config{Provider: tt.provider, APIKey: "key", BaseURL: srv.URL + "/"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Search(context.Background(), "go iterators", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Search() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSearchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid subscription token", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	p, err := New(&Config{Provider: "brave", APIKey: "wrong", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Search(context.Background(), "query", 5)
+	if err == nil || !strings.Contains(err.Error(), "401") || !strings.Contains(err.Error(), "invalid subscription token") {
+		t.Errorf("Search() error = %v, want the status and body", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *Config
+		wantErr string
+	}{
+		{"nil config", nil, "config cannot be nil"},
+		{"unknown provider", &Config{Provider: "altavista", APIKey: "key"}, "unknown web search provider"},
+		{"missing key", &Config{Provider: "tavily"}, "requires an API key"},
+		{"searxng without base URL", &Config{Provider: "searxng"}, "requires the base URL"},
+		{"searxng", &Config{Provider: "searxng", BaseURL: "http://localhost:8888"}, ""},
+		{"bing", &Config{Provider: "bing", APIKey: "key"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
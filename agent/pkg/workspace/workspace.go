@@ -0,0 +1,504 @@
+package workspace
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gopher-9527/yanshu/agent/pkg/logging"
+	"google.golang.org/adk/tool"
+	"google.golang.org/adk/tool/functiontool"
+)
+
+// Defaults applied by New
+const (
+	DefaultMaxFileSize = 1 << 20
+	DefaultMaxEntries  = 1000
+)
+
+// Config configures the workspace tools
+type Config struct {
+	// Root is the directory the tools are confined to, defaults to the current directory
+	Root string
+	// MaxFileSize caps files read and written, in bytes, defaults to DefaultMaxFileSize
+	MaxFileSize int
+	// MaxEntries caps the entries listed by list_dir and glob, defaults to DefaultMaxEntries
+	MaxEntries int
+	// ReadOnly leaves out write_file
+	ReadOnly bool
+	// Journal keeps what write_file replaced in each session, so checkpoints
+	// can roll the files back
+	Journal bool
+	Logger  *slog.Logger
+}
+
+// Workspace gives tools access to the files below a root directory. Paths
+// are relative to the root; paths leaving it, including through symlinks,
+// are rejected.
+type Workspace struct {
+	root        *os.Root
+	maxFileSize int
+	maxEntries  int
+	readOnly    bool
+	logger      *slog.Logger
+
+	journal  bool
+	mu       sync.Mutex
+	seq      int64
+	journals map[string][]change
+}
+
+// change is a file before a write of a session, content is nil when it did
+// not exist. Changes are numbered across sessions, so a number marks a point
+// in every journal.
+type change struct {
+	seq     int64
+	name    string
+	content []byte
+}
+
+// New opens the workspace root
+func New(cfg *Config) (*Workspace, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	dir := cfg.Root
+	if dir == "" {
+		dir = "."
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workspace root: %w", err)
+	}
+
+	w := &Workspace{
+		root:        root,
+		maxFileSize: cfg.MaxFileSize,
+		maxEntries:  cfg.MaxEntries,
+		readOnly:    cfg.ReadOnly,
+		logger:      cfg.Logger,
+		journal:     cfg.Journal,
+		journals:    make(map[string][]change),
+	}
+	if w.maxFileSize <= 0 {
+		w.maxFileSize = DefaultMaxFileSize
+	}
+	if w.maxEntries <= 0 {
+		w.maxEntries = DefaultMaxEntries
+	}
+	if w.logger == nil {
+		w.logger = logging.Component("workspace")
+	}
+	return w, nil
+}
+
+// Close closes the workspace root
+func (w *Workspace) Close() error {
+	return w.root.Close()
+}
+
+// ReadArgs are the arguments of read_file
+type ReadArgs struct {
+	Path string `json:"path" jsonschema:"File path relative to the workspace root"`
+}
+
+// ReadResult is the content of a file, cut at the size limit
+type ReadResult struct {
+	Content   string `json:"content"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// WriteArgs are the arguments of write_file
+type WriteArgs struct {
+	Path    string `json:"path" jsonschema:"File path relative to the workspace root; missing directories are created"`
+	Content string `json:"content" jsonschema:"Text to write"`
+	Append  bool   `json:"append,omitempty" jsonschema:"Append to the file instead of replacing it"`
+}
+
+// WriteResult reports a write
+type WriteResult struct {
+	Path    string `json:"path"`
+	Written int    `json:"written"`
+}
+
+// ListArgs are the arguments of list_dir
+type ListArgs struct {
+	Path string `json:"path,omitempty" jsonschema:"Directory relative to the workspace root, defaults to the root"`
+}
+
+// Entry is a directory entry
+type Entry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // file, dir or symlink
+	Size int64  `json:"size,omitempty"`
+}
+
+// ListResult lists a directory
+type ListResult struct {
+	Entries   []Entry `json:"entries"`
+	Truncated bool    `json:"truncated,omitempty"`
+}
+
+// GlobArgs are the arguments of glob
+type GlobArgs struct {
+	Pattern string `json:"pattern" jsonschema:"Pattern relative to the workspace root, e.g. 'docs/*.md'; ** matches any number of directories, e.g. '**/*.go'"`
+}
+
+// GlobResult lists the matching paths
+type GlobResult struct {
+	Paths     []string `json:"paths"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// Tools returns read_file, list_dir, glob and, unless read-only, write_file
+func (w *Workspace) Tools() ([]tool.Tool, error) {
+	read, err := functiontool.New(functiontool.Config{
+		Name:        "read_file",
+		Description: fmt.Sprintf("Reads a text file of the workspace. Content beyond %d bytes is cut off.", w.maxFileSize),
+	}, func(_ tool.Context, args ReadArgs) (ReadResult, error) {
+		return w.Read(args)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create read_file tool: %w", err)
+	}
+	list, err := functiontool.New(functiontool.Config{
+		Name:        "list_dir",
+		Description: "Lists the files and directories in a workspace directory.",
+	}, func(_ tool.Context, args ListArgs) (ListResult, error) {
+		return w.List(args)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create list_dir tool: %w", err)
+	}
+	glob, err := functiontool.New(functiontool.Config{
+		Name:        "glob",
+		Description: "Finds workspace files whose path matches a pattern.",
+	}, func(_ tool.Context, args GlobArgs) (GlobResult, error) {
+		return w.Glob(args)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glob tool: %w", err)
+	}
+	tools := []tool.Tool{read, list, glob}
+	if w.readOnly {
+		return tools, nil
+	}
+
+	write, err := functiontool.New(functiontool.Config{
+		Name:        "write_file",
+		Description: fmt.Sprintf("Creates, replaces or appends to a text file of the workspace, up to %d bytes.", w.maxFileSize),
+	}, func(ctx tool.Context, args WriteArgs) (WriteResult, error) {
+		return w.write(ctx.SessionID(), args)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create write_file tool: %w", err)
+	}
+	return append(tools, write), nil
+}
+
+// Read reads a text file, cut at the size limit
+func (w *Workspace) Read(args ReadArgs) (ReadResult, error) {
+	name, err := clean(args.Path)
+	if err != nil {
+		return ReadResult{}, err
+	}
+	f, err := w.root.Open(name)
+	if err != nil {
+		return ReadResult{}, pathError(args.Path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ReadResult{}, pathError(args.Path, err)
+	}
+	if info.IsDir() {
+		return ReadResult{}, fmt.Errorf("%s is a directory, use list_dir", args.Path)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, int64(w.maxFileSize)))
+	if err != nil {
+		return ReadResult{}, pathError(args.Path, err)
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return ReadResult{}, fmt.Errorf("%s is not a text file", args.Path)
+	}
+	return ReadResult{
+		Content:   string(data),
+		Size:      info.Size(),
+		Truncated: info.Size() > int64(len(data)),
+	}, nil
+}
+
+// Write creates, replaces or appends to a file, creating missing directories
+func (w *Workspace) Write(args WriteArgs) (WriteResult, error) {
+	return w.write("", args)
+}
+
+// write writes a file for a session, recording the previous content in the
+// session's journal
+func (w *Workspace) write(sessionID string, args WriteArgs) (WriteResult, error) {
+	if w.readOnly {
+		return WriteResult{}, fmt.Errorf("the workspace is read-only")
+	}
+	name, err := clean(args.Path)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	if name == "." {
+		return WriteResult{}, fmt.Errorf("path is required")
+	}
+	if len(args.Content) > w.maxFileSize {
+		return WriteResult{}, fmt.Errorf("content of %d bytes exceeds the limit of %d", len(args.Content), w.maxFileSize)
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := w.root.MkdirAll(dir, 0o755); err != nil {
+			return WriteResult{}, pathError(args.Path, err)
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if args.Append {
+		if info, err := w.root.Stat(name); err == nil && info.Size()+int64(len(args.Content)) > int64(w.maxFileSize) {
+			return WriteResult{}, fmt.Errorf("appending would grow %s beyond the limit of %d bytes", args.Path, w.maxFileSize)
+		}
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if err := w.record(sessionID, name); err != nil {
+		return WriteResult{}, pathError(args.Path, err)
+	}
+	f, err := w.root.OpenFile(name, flag, 0o644)
+	if err != nil {
+		return WriteResult{}, pathError(args.Path, err)
+	}
+	n, err := f.WriteString(args.Content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return WriteResult{}, pathError(args.Path, err)
+	}
+	w.logger.Info("Workspace file written", "path", name, "bytes", n, "append", args.Append)
+	return WriteResult{Path: name, Written: n}, nil
+}
+
+// record adds the current content of a file to the journal of a session
+func (w *Workspace) record(sessionID, name string) error {
+	if !w.journal || sessionID == "" {
+		return nil
+	}
+	data, err := w.root.ReadFile(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		data = nil
+	case err != nil:
+		return err
+	case data == nil:
+		data = []byte{} // Empty, not missing
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seq++
+	w.journals[sessionID] = append(w.journals[sessionID], change{seq: w.seq, name: name, content: data})
+	return nil
+}
+
+// Mark implements checkpoint.Resource
+func (w *Workspace) Mark(string) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// Rollback implements checkpoint.Resource, restoring the files a session
+// wrote after mark. Created directories are left in place.
+func (w *Workspace) Rollback(sessionID string, mark int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changes := w.journals[sessionID]
+	for len(changes) > 0 && changes[len(changes)-1].seq > mark {
+		c := changes[len(changes)-1]
+		var err error
+		if c.content == nil {
+			err = w.root.Remove(c.name)
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			err = w.root.WriteFile(c.name, c.content, 0o644)
+		}
+		if err != nil {
+			return pathError(c.name, err)
+		}
+		changes = changes[:len(changes)-1]
+		w.journals[sessionID] = changes
+		w.logger.Info("Workspace file rolled back", "path", c.name, "removed", c.content == nil)
+	}
+	return nil
+}
+
+// Release implements checkpoint.Resource
+func (w *Workspace) Release(sessionID string, mark int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changes := w.journals[sessionID]
+	n := 0
+	for n < len(changes) && changes[n].seq <= mark {
+		n++
+	}
+	if n == len(changes) {
+		delete(w.journals, sessionID)
+	} else {
+		w.journals[sessionID] = changes[n:]
+	}
+}
+
+// List lists a directory in name order
+func (w *Workspace) List(args ListArgs) (ListResult, error) {
+	name, err := clean(args.Path)
+	if err != nil {
+		return ListResult{}, err
+	}
+	entries, err := fs.ReadDir(w.root.FS(), name)
+	if err != nil {
+		return ListResult{}, pathError(args.Path, err)
+	}
+
+	result := ListResult{Entries: []Entry{}}
+	for _, e := range entries {
+		if len(result.Entries) == w.maxEntries {
+			result.Truncated = true
+			break
+		}
+		entry := Entry{Name: e.Name(), Type: "file"}
+		switch {
+		case e.Type()&fs.ModeSymlink != 0:
+			entry.Type = "symlink"
+		case e.IsDir():
+			entry.Type = "dir"
+		default:
+			if info, err := e.Info(); err == nil {
+				entry.Size = info.Size()
+			}
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+// Glob walks the workspace for files matching the pattern. Directories
+// matching a pattern segment are descended; symlinks are not followed.
+func (w *Workspace) Glob(args GlobArgs) (GlobResult, error) {
+	pattern, err := clean(args.Pattern)
+	if err != nil {
+		return GlobResult{}, err
+	}
+	segments := strings.Split(pattern, "/")
+	for _, s := range segments {
+		if _, err := path.Match(s, ""); err != nil {
+			return GlobResult{}, fmt.Errorf("invalid pattern %q: %w", args.Pattern, err)
+		}
+	}
+
+	result := GlobResult{Paths: []string{}}
+	errFull := errors.New("enough matches")
+	err = fs.WalkDir(w.root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip unreadable directories
+		}
+		if p == "." {
+			return nil
+		}
+		if d.IsDir() && !matchPrefix(segments, strings.Split(p, "/")) {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && match(segments, strings.Split(p, "/")) {
+			if len(result.Paths) == w.maxEntries {
+				result.Truncated = true
+				return errFull
+			}
+			result.Paths = append(result.Paths, p)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errFull) {
+		return GlobResult{}, fmt.Errorf("failed to search the workspace: %w", err)
+	}
+	return result, nil
+}
+
+// match reports whether the path segments match the pattern segments, where
+// ** matches any number of segments
+func match(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if match(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && match(pattern[1:], segments[1:])
+}
+
+// matchPrefix reports whether a directory with the path segments may contain matches
+func matchPrefix(pattern, segments []string) bool {
+	if len(segments) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchPrefix(pattern[1:], segments[1:])
+}
+
+// clean turns a path given to a tool into a slash-separated path relative to
+// the root. Absolute paths and paths leaving the root are rejected; os.Root
+// rejects symlinks leaving it.
+func clean(p string) (string, error) {
+	p = filepath.ToSlash(strings.TrimSpace(p))
+	if path.IsAbs(p) || filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %q must be relative to the workspace root", p)
+	}
+	p = path.Clean(cmp.Or(p, "."))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("path %q is outside the workspace root", p)
+	}
+	return p, nil
+}
+
+// pathError reports err without the absolute paths of the host
+func pathError(p string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%s does not exist", p)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%s is not accessible", p)
+	}
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("%s: %w", p, pe.Err)
+	}
+	return fmt.Errorf("%s: %w", p, err)
+}
@@ -0,0 +1,125 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestWorkspace tests reading, writing, listing and globbing below the root
+func TestWorkspace(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "README.md"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.TempDir(), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(&Config{Root: root, MaxFileSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	read, err := w.Read(ReadArgs{Path: "README.md"})
+	if err != nil || read.Content != "01234567" || read.Size != 10 || !read.Truncated {
+		t.Errorf("Read() = %+v, %v, want truncated content", read, err)
+	}
+
+	if _, err := w.Write(WriteArgs{Path: "src/pkg/main.go", Content: "package"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := w.Write(WriteArgs{Path: "src/pkg/main.go", Content: " main", Append: true}); err == nil {
+		t.Error("Write() appended beyond the size limit")
+	}
+	if _, err := w.Write(WriteArgs{Path: "src/b.go", Content: "x", Append: true}); err != nil {
+		t.Errorf("Write(append) error = %v", err)
+	}
+
+	list, err := w.List(ListArgs{})
+	if err != nil || len(list.Entries) != 3 || list.Entries[0] != (Entry{Name: "README.md", Type: "file", Size: 10}) ||
+		list.Entries[1].Type != "symlink" || list.Entries[2].Type != "dir" {
+		t.Errorf("List() = %+v, %v", list, err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"**/*.go", []string{"src/b.go", "src/pkg/main.go"}},
+		{"src/*.go", []string{"src/b.go"}},
+		{"*.md", []string{"README.md"}},
+	}
+	for _, tt := range tests {
+		got, err := w.Glob(GlobArgs{Pattern: tt.pattern})
+		if err != nil || !slices.Equal(got.Paths, tt.want) {
+			t.Errorf("Glob(%q) = %v, %v, want %v", tt.pattern, got.Paths, err, tt.want)
+		}
+	}
+
+	for _, p := range []string{"../secret", "/etc/passwd", "escape/x", "src/../../x"} {
+		if _, err := w.Read(ReadArgs{Path: p}); err == nil {
+			t.Errorf("Read(%q) succeeded, want an error", p)
+		}
+		if _, err := w.Write(WriteArgs{Path: p, Content: "x"}); err == nil {
+			t.Errorf("Write(%q) succeeded, want an error", p)
+		}
+	}
+
+	readOnly, err := New(&Config{Root: root, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	tools, err := readOnly.Tools()
+	if err != nil || len(tools) != 3 {
+		t.Errorf("Tools() = %d tools, %v, want 3 without write_file", len(tools), err)
+	}
+}
+
+// TestJournal tests rolling back the files written in a session
+func TestJournal(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(&Config{Root: root, Journal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+
+	start := w.Mark("s1")
+	w.write("s1", WriteArgs{Path: "notes.txt", Content: "v2"})
+	mark := w.Mark("s1")
+	w.write("s1", WriteArgs{Path: "notes.txt", Content: "+", Append: true})
+	w.write("s1", WriteArgs{Path: "new/file.txt", Content: "new"})
+	w.write("s2", WriteArgs{Path: "other.txt", Content: "other"})
+
+	if err := w.Rollback("s1", mark); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := read("notes.txt"); got != "v2" {
+		t.Errorf("notes.txt = %q after rollback, want v2", got)
+	}
+	if got := read("new/file.txt"); got != "<missing>" {
+		t.Errorf("new/file.txt = %q after rollback, want it removed", got)
+	}
+	if got := read("other.txt"); got != "other" {
+		t.Errorf("other.txt of another session = %q after rollback", got)
+	}
+
+	w.Release("s1", mark)
+	if err := w.Rollback("s1", start); err != nil || read("notes.txt") != "v2" {
+		t.Errorf("Rollback() past a release = %v, notes.txt = %q, want it kept", err, read("notes.txt"))
+	}
+}